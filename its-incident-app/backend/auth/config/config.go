@@ -2,14 +2,18 @@ package config
 
 import (
 	"auth/logger"
+	"auth/mtls"
+	"auth/secrets"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -19,15 +23,22 @@ type ServerConfig struct {
 	GinMode         string
 	LogLevel        zapcore.Level
 	DBPilotURL      string
+	DBPilotTimeout  time.Duration
+	DBPilotRetries  int
+	DBPilotBackoff  time.Duration
 	NotificationURL string
 	FrontendURL     string
 	JWTSecret       string
-	Environment     string
-	ServiceName     string
-	ShutdownTimeout time.Duration
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	IdleTimeout     time.Duration
+	// MaxLoginAttempts 回連続でログインに失敗したアカウントを AccountLockDurationMins 分ロックする（0で無効）
+	MaxLoginAttempts        int
+	AccountLockDurationMins int
+	MagicLinkTTL            time.Duration // ログインリンクの有効期間
+	Environment             string
+	ServiceName             string
+	ShutdownTimeout         time.Duration
+	ReadTimeout             time.Duration
+	WriteTimeout            time.Duration
+	IdleTimeout             time.Duration
 }
 
 // InitConfig は環境設定を初期化します
@@ -44,19 +55,25 @@ func InitConfig() (*ServerConfig, error) {
 	ginMode := initGinMode()
 
 	config := &ServerConfig{
-		Port:            getEnv("SERVER_PORT", "8080"),
-		GinMode:         ginMode,
-		LogLevel:        logLevel,
-		DBPilotURL:      getEnv("DB_PILOT_SERVICE_URL", ""),
-		NotificationURL: getEnv("NOTIFICATION_SERVICE_URL", ""),
-		FrontendURL:     getEnv("FRONTEND_URL", ""),
-		JWTSecret:       getEnv("JWT_SECRET", ""),
-		Environment:     getEnv("ENVIRONMENT", "development"),
-		ServiceName:     getEnv("SERVICE_NAME", "auth-service"),
-		ShutdownTimeout: getDuration("SHUTDOWN_TIMEOUT", 10*time.Second),
-		ReadTimeout:     getDuration("HTTP_READ_TIMEOUT", 15*time.Second),
-		WriteTimeout:    getDuration("HTTP_WRITE_TIMEOUT", 15*time.Second),
-		IdleTimeout:     getDuration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+		Port:                    getEnv("SERVER_PORT", "8080"),
+		GinMode:                 ginMode,
+		LogLevel:                logLevel,
+		DBPilotURL:              getEnv("DB_PILOT_SERVICE_URL", ""),
+		DBPilotTimeout:          getDuration("DB_PILOT_TIMEOUT", 10*time.Second),
+		DBPilotRetries:          getInt("DB_PILOT_MAX_RETRIES", 2),
+		DBPilotBackoff:          getDuration("DB_PILOT_RETRY_BACKOFF", 200*time.Millisecond),
+		NotificationURL:         getEnv("NOTIFICATION_SERVICE_URL", ""),
+		FrontendURL:             getEnv("FRONTEND_URL", ""),
+		JWTSecret:               secrets.Get("JWT_SECRET"),
+		MaxLoginAttempts:        getInt("MAX_LOGIN_ATTEMPTS", 5),
+		AccountLockDurationMins: getInt("ACCOUNT_LOCK_DURATION_MINS", 15),
+		MagicLinkTTL:            getDuration("MAGIC_LINK_TTL", 60*time.Minute),
+		Environment:             getEnv("ENVIRONMENT", "development"),
+		ServiceName:             getEnv("SERVICE_NAME", "auth-service"),
+		ShutdownTimeout:         getDuration("SHUTDOWN_TIMEOUT", 10*time.Second),
+		ReadTimeout:             getDuration("HTTP_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:            getDuration("HTTP_WRITE_TIMEOUT", 15*time.Second),
+		IdleTimeout:             getDuration("HTTP_IDLE_TIMEOUT", 60*time.Second),
 	}
 
 	return config, config.Validate()
@@ -67,7 +84,7 @@ func SetupServer(r *gin.Engine) *http.Server {
 	config, _ := InitConfig()
 	displayServerConfig(r, config)
 
-	return &http.Server{
+	srv := &http.Server{
 		Addr:              ":" + config.Port,
 		Handler:           r,
 		ReadTimeout:       config.ReadTimeout,
@@ -75,6 +92,15 @@ func SetupServer(r *gin.Engine) *http.Server {
 		IdleTimeout:       config.IdleTimeout,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
+
+	// 相互TLS（MTLS_CERT_FILE/MTLS_KEY_FILE が設定されている場合のみ）
+	tlsConfig, err := mtls.ServerConfig()
+	if err != nil {
+		logger.Logger.Fatal("mTLSの設定に失敗しました", zap.Error(err))
+	}
+	srv.TLSConfig = tlsConfig
+
+	return srv
 }
 
 func initLogLevel() zapcore.Level {
@@ -113,6 +139,15 @@ func getDuration(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
+func getInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
 func (c *ServerConfig) Validate() error {
 	required := map[string]string{
 		"DBPilotURL":      c.DBPilotURL,
@@ -0,0 +1,719 @@
+// Package dbpilot はauthサービスからDB Pilotを呼び出すための型付きクライアントを提供します
+package dbpilot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout      = 10 * time.Second
+	defaultMaxRetries   = 2
+	defaultRetryBackoff = 200 * time.Millisecond
+)
+
+// API はハンドラーが利用するDB Pilotの操作です（テスト時はフェイクに差し替え可能）
+type API interface {
+	SaveUser(ctx context.Context, req SaveUserRequest, authHeader string) error
+	UpdateUser(ctx context.Context, req UpdateUserRequest, authHeader string) error
+	CreateLoginToken(ctx context.Context, req CreateLoginTokenRequest, authHeader string) error
+	VerifyLoginToken(ctx context.Context, token string) (*LoginTokenVerification, error)
+	GetLoginAttempt(ctx context.Context, email string) (*LoginAttempt, error)
+	RecordLoginFailure(ctx context.Context, req LoginFailureRequest) (*LoginAttempt, error)
+	ResetLoginAttempts(ctx context.Context, email string) error
+	RevokeLoginToken(ctx context.Context, token string) (*RevokedLoginToken, error)
+	ListDirectoryUsers(ctx context.Context, filter DirectoryUserFilter) (*DirectoryUserList, error)
+	GetDirectoryUser(ctx context.Context, id uint) (*DirectoryUser, error)
+	CreateDirectoryUser(ctx context.Context, req CreateDirectoryUserRequest) (*DirectoryUser, error)
+	UpdateDirectoryUser(ctx context.Context, id uint, req UpdateDirectoryUserRequest) (*DirectoryUserUpdate, error)
+	CreateRefreshToken(ctx context.Context, req CreateRefreshTokenRequest) error
+	RotateRefreshToken(ctx context.Context, req RotateRefreshTokenRequest) (*RefreshedSession, error)
+	GetUserCredentials(ctx context.Context, email string) (*UserCredentials, error)
+	CreateAccount(ctx context.Context, req CreateAccountRequest) (map[string]interface{}, error)
+	ProvisionUser(ctx context.Context, req ProvisionUserRequest) (*ProvisionedUser, error)
+	LockUser(ctx context.Context, id uint, reason string) (*AccountLockResult, error)
+	UnlockUser(ctx context.Context, id uint) (*AccountLockResult, error)
+	CreateSession(ctx context.Context, req CreateSessionRequest) error
+	GetCurrentSession(ctx context.Context, sessionID string) (*Session, error)
+	ElevateSession(ctx context.Context, sessionID string, until time.Time) (time.Time, error)
+	DeleteCurrentSession(ctx context.Context, sessionID string) error
+	DeleteAllSessions(ctx context.Context, sessionID string, exceptCurrent bool) ([]string, error)
+	CreateDeviceToken(ctx context.Context, req CreateDeviceTokenRequest) error
+	ExchangeDeviceToken(ctx context.Context, req ExchangeDeviceTokenRequest) (*ResumedSession, error)
+	RevokeDeviceToken(ctx context.Context, tokenHash string) error
+	ListDeviceTokens(ctx context.Context, sessionID string) (json.RawMessage, error)
+	DeleteDeviceToken(ctx context.Context, sessionID, id string) (json.RawMessage, error)
+	RecordAuditLog(ctx context.Context, req AuditLogRequest) error
+	ListAuditLogs(ctx context.Context, query url.Values) (json.RawMessage, error)
+}
+
+// SaveUserRequest は POST /users のリクエスト
+type SaveUserRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// UpdateUserRequest は POST /users-update のリクエスト
+type UpdateUserRequest struct {
+	Name     string `json:"name,omitempty"`     // 更新する名前
+	Password string `json:"password,omitempty"` // ハッシュ化されたパスワード
+}
+
+// CreateLoginTokenRequest は POST /login-tokens のリクエスト
+type CreateLoginTokenRequest struct {
+	Email     string    `json:"email"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LoginTokenVerification は GET /login-tokens/verify のレスポンス
+type LoginTokenVerification struct {
+	Email  string `json:"email"`
+	UserID uint   `json:"user_id,omitempty"`
+}
+
+// RevokedLoginToken は DELETE /login-tokens/:token のレスポンス
+type RevokedLoginToken struct {
+	Email           string `json:"email"`
+	AlreadyConsumed bool   `json:"already_consumed"`
+}
+
+// LoginFailureRequest は POST /login-attempts/failure のリクエスト
+type LoginFailureRequest struct {
+	Email               string `json:"email"`
+	MaxAttempts         int    `json:"max_attempts"`
+	LockDurationSeconds int    `json:"lock_duration_seconds"`
+}
+
+// LoginAttempt はアカウント単位のログイン失敗状況
+type LoginAttempt struct {
+	Email          string     `json:"email"`
+	FailedCount    int        `json:"failed_count"`
+	Locked         bool       `json:"locked"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+	LockoutStarted bool       `json:"lockout_started,omitempty"`
+}
+
+// DirectoryUser はIdPからのプロビジョニング向けのユーザー情報
+type DirectoryUser struct {
+	ID         uint      `json:"id"`
+	Email      string    `json:"email"`
+	Name       string    `json:"name"`
+	ExternalID string    `json:"external_id"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// DirectoryUserFilter は GET /directory/users の検索条件
+type DirectoryUserFilter struct {
+	Email      string
+	ExternalID string
+	Offset     int
+	Limit      int
+}
+
+// DirectoryUserList は GET /directory/users のレスポンス
+type DirectoryUserList struct {
+	Total int64           `json:"total"`
+	Items []DirectoryUser `json:"items"`
+}
+
+// CreateDirectoryUserRequest は POST /directory/users のリクエスト
+type CreateDirectoryUserRequest struct {
+	Email      string `json:"email"`
+	Name       string `json:"name,omitempty"`
+	ExternalID string `json:"external_id,omitempty"`
+	Active     *bool  `json:"active,omitempty"`
+}
+
+// UpdateDirectoryUserRequest は PATCH /directory/users/:id のリクエスト（nilの項目は変更しない）
+type UpdateDirectoryUserRequest struct {
+	Email      *string `json:"email,omitempty"`
+	Name       *string `json:"name,omitempty"`
+	ExternalID *string `json:"external_id,omitempty"`
+	Active     *bool   `json:"active,omitempty"`
+}
+
+// DirectoryUserUpdate は PATCH /directory/users/:id のレスポンス
+type DirectoryUserUpdate struct {
+	User       DirectoryUser `json:"user"`
+	SessionIDs []string      `json:"session_ids"` // 無効化により失効したセッション
+}
+
+// CreateRefreshTokenRequest は POST /refresh-tokens のリクエスト
+type CreateRefreshTokenRequest struct {
+	TokenHash string    `json:"token_hash"`
+	FamilyID  string    `json:"family_id"`
+	SessionID string    `json:"session_id"`
+	UserID    uint      `json:"user_id"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RotateRefreshTokenRequest は POST /refresh-tokens/rotate のリクエスト
+type RotateRefreshTokenRequest struct {
+	TokenHash        string    `json:"token_hash"`
+	NewTokenHash     string    `json:"new_token_hash"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	SessionExpiresAt time.Time `json:"session_expires_at"`
+}
+
+// RefreshedSession は POST /refresh-tokens/rotate のレスポンス（延長後のセッション）
+type RefreshedSession struct {
+	UserID    uint      `json:"user_id"`
+	Email     string    `json:"email"`
+	SessionID string    `json:"session_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UserCredentials は POST /login のレスポンス（パスワードハッシュを含むユーザー情報）
+type UserCredentials struct {
+	ID       uint   `json:"id"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Locked   bool   `json:"locked"`
+}
+
+// CreateAccountRequest は POST /accounts のリクエスト
+type CreateAccountRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"` // ハッシュ化されたパスワード
+}
+
+// ProvisionUserRequest は POST /users/provision のリクエスト（SSOログイン時のユーザー作成・紐付け）
+type ProvisionUserRequest struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	ImageURL string `json:"image_url"`
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+// ProvisionedUser は POST /users/provision のレスポンス
+type ProvisionedUser struct {
+	ID      uint   `json:"id"`
+	Email   string `json:"email"`
+	Created bool   `json:"created"`
+}
+
+// AccountLockResult は POST /users/:id/lock・unlock のレスポンス
+type AccountLockResult struct {
+	UserID     uint     `json:"user_id"`
+	Email      string   `json:"email"`
+	SessionIDs []string `json:"session_ids"` // ロックにより失効したセッション
+}
+
+// CreateSessionRequest は POST /sessions のリクエスト
+type CreateSessionRequest struct {
+	UserID    uint      `json:"user_id"`
+	Email     string    `json:"email"`
+	SessionID string    `json:"session_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Session は GET /sessions/current のレスポンス
+type Session struct {
+	UserID        uint       `json:"user_id"`
+	Email         string     `json:"email"`
+	SessionID     string     `json:"session_id"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	ElevatedUntil *time.Time `json:"elevated_until,omitempty"`
+}
+
+// CreateDeviceTokenRequest は POST /device-tokens のリクエスト
+type CreateDeviceTokenRequest struct {
+	TokenHash  string    `json:"token_hash"`
+	UserID     uint      `json:"user_id"`
+	Email      string    `json:"email"`
+	DeviceName string    `json:"device_name"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ExchangeDeviceTokenRequest は POST /device-tokens/exchange のリクエスト
+type ExchangeDeviceTokenRequest struct {
+	TokenHash        string    `json:"token_hash"`
+	NewTokenHash     string    `json:"new_token_hash"`
+	SessionID        string    `json:"session_id"`
+	SessionExpiresAt time.Time `json:"session_expires_at"`
+}
+
+// ResumedSession は POST /device-tokens/exchange のレスポンス（新しいセッションと端末トークンの有効期限）
+type ResumedSession struct {
+	Session
+	DeviceExpiresAt time.Time `json:"device_expires_at"`
+}
+
+// AuditLogRequest は POST /audit-logs のリクエスト
+type AuditLogRequest struct {
+	EventType string `json:"event_type"`
+	Outcome   string `json:"outcome"`
+	UserID    uint   `json:"user_id,omitempty"`
+	Email     string `json:"email,omitempty"`
+	ClientIP  string `json:"client_ip"`
+	UserAgent string `json:"user_agent"`
+	Details   string `json:"details,omitempty"`
+}
+
+// APIError はDB Pilotが2xx以外を返した場合のエラー
+type APIError struct {
+	StatusCode int
+	Message    string // レスポンスの "error" フィールド
+	Code       string // レスポンスの "code" フィールド
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("DB pilot returned status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("DB pilot returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// StatusCode はエラーがAPIErrorの場合にそのステータスコードを返します（それ以外は0）
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// Client はタイムアウトとリトライ方針を共有するDB Pilotクライアント
+type Client struct {
+	baseURL      string
+	httpClient   *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// Option はClientの設定を変更します
+type Option func(*Client)
+
+// WithTimeout はリクエストごとのタイムアウトを設定します
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		if timeout > 0 {
+			c.httpClient.Timeout = timeout
+		}
+	}
+}
+
+// WithRetry はリトライ回数と初回の待機時間（以降は倍増）を設定します
+func WithRetry(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		if maxRetries >= 0 {
+			c.maxRetries = maxRetries
+		}
+		if backoff > 0 {
+			c.retryBackoff = backoff
+		}
+	}
+}
+
+// WithHTTPClient は使用するHTTPクライアントを差し替えます
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
+
+// NewClient はDB Pilotクライアントを作成します。
+// baseURL が空の場合はリクエストごとに DB_PILOT_SERVICE_URL を参照します
+func NewClient(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		httpClient:   &http.Client{Timeout: defaultTimeout},
+		maxRetries:   defaultMaxRetries,
+		retryBackoff: defaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SaveUser はパスワード認証のユーザーを作成します
+func (c *Client) SaveUser(ctx context.Context, req SaveUserRequest, authHeader string) error {
+	return c.do(ctx, http.MethodPost, "/users", authHeader, req, nil, false)
+}
+
+// UpdateUser はセッションのユーザーの名前・パスワードを更新します
+func (c *Client) UpdateUser(ctx context.Context, req UpdateUserRequest, authHeader string) error {
+	return c.do(ctx, http.MethodPost, "/users-update", authHeader, req, nil, true)
+}
+
+// CreateLoginToken はログインリンク用のトークンを保存します
+func (c *Client) CreateLoginToken(ctx context.Context, req CreateLoginTokenRequest, authHeader string) error {
+	return c.do(ctx, http.MethodPost, "/login-tokens", authHeader, req, nil, false)
+}
+
+// VerifyLoginToken はログインリンク用のトークンを検証します。
+// トークンは検証に成功すると使用済みになるため、応答を受け取れなかった場合もリトライしません
+func (c *Client) VerifyLoginToken(ctx context.Context, token string) (*LoginTokenVerification, error) {
+	var result LoginTokenVerification
+	path := "/login-tokens/verify?token=" + url.QueryEscape(token)
+	if err := c.do(ctx, http.MethodGet, path, "", nil, &result, false); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RevokeLoginToken はログインリンク用のトークンを失効させます（サービス認証で呼び出し）
+func (c *Client) RevokeLoginToken(ctx context.Context, token string) (*RevokedLoginToken, error) {
+	var result RevokedLoginToken
+	if err := c.do(ctx, http.MethodDelete, "/login-tokens/"+url.PathEscape(token), "", nil, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetLoginAttempt はメールアドレスのログイン失敗回数とロックアウト状態を取得します
+func (c *Client) GetLoginAttempt(ctx context.Context, email string) (*LoginAttempt, error) {
+	var result LoginAttempt
+	path := "/login-attempts?email=" + url.QueryEscape(email)
+	if err := c.do(ctx, http.MethodGet, path, "", nil, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RecordLoginFailure はログイン失敗を記録し、更新後の状態を返します
+func (c *Client) RecordLoginFailure(ctx context.Context, req LoginFailureRequest) (*LoginAttempt, error) {
+	var result LoginAttempt
+	if err := c.do(ctx, http.MethodPost, "/login-attempts/failure", "", req, &result, false); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ResetLoginAttempts はログイン成功時に失敗回数をリセットします
+func (c *Client) ResetLoginAttempts(ctx context.Context, email string) error {
+	return c.do(ctx, http.MethodPost, "/login-attempts/reset", "", map[string]string{"email": email}, nil, true)
+}
+
+// ListDirectoryUsers はメールアドレス・外部IDでユーザーを検索します
+func (c *Client) ListDirectoryUsers(ctx context.Context, filter DirectoryUserFilter) (*DirectoryUserList, error) {
+	query := url.Values{}
+	if filter.Email != "" {
+		query.Set("email", filter.Email)
+	}
+	if filter.ExternalID != "" {
+		query.Set("external_id", filter.ExternalID)
+	}
+	query.Set("offset", strconv.Itoa(filter.Offset))
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	var result DirectoryUserList
+	if err := c.do(ctx, http.MethodGet, "/directory/users?"+query.Encode(), "", nil, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetDirectoryUser はIDでユーザーを取得します
+func (c *Client) GetDirectoryUser(ctx context.Context, id uint) (*DirectoryUser, error) {
+	var result DirectoryUser
+	path := "/directory/users/" + strconv.FormatUint(uint64(id), 10)
+	if err := c.do(ctx, http.MethodGet, path, "", nil, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CreateDirectoryUser はIdPからプロビジョニングされたユーザーを作成します
+func (c *Client) CreateDirectoryUser(ctx context.Context, req CreateDirectoryUserRequest) (*DirectoryUser, error) {
+	var result DirectoryUser
+	if err := c.do(ctx, http.MethodPost, "/directory/users", "", req, &result, false); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UpdateDirectoryUser はユーザーの属性と有効/無効を更新します
+func (c *Client) UpdateDirectoryUser(ctx context.Context, id uint, req UpdateDirectoryUserRequest) (*DirectoryUserUpdate, error) {
+	var result DirectoryUserUpdate
+	path := "/directory/users/" + strconv.FormatUint(uint64(id), 10)
+	if err := c.do(ctx, http.MethodPatch, path, "", req, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CreateRefreshToken はセッションに紐づくリフレッシュトークンを保存します
+func (c *Client) CreateRefreshToken(ctx context.Context, req CreateRefreshTokenRequest) error {
+	return c.do(ctx, http.MethodPost, "/refresh-tokens", "", req, nil, false)
+}
+
+// RotateRefreshToken はリフレッシュトークンを新しいトークンへ置き換え、延長後のセッションを返します。
+// 使用済みのトークンの再提示は漏洩とみなされ系列ごと失効するため、応答を受け取れなかった場合もリトライしません
+func (c *Client) RotateRefreshToken(ctx context.Context, req RotateRefreshTokenRequest) (*RefreshedSession, error) {
+	var result RefreshedSession
+	if err := c.do(ctx, http.MethodPost, "/refresh-tokens/rotate", "", req, &result, false); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetUserCredentials はメールアドレスでユーザーを検索し、パスワードハッシュとロック状態を返します
+func (c *Client) GetUserCredentials(ctx context.Context, email string) (*UserCredentials, error) {
+	var result UserCredentials
+	if err := c.do(ctx, http.MethodPost, "/login", "", map[string]string{"email": email}, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CreateAccount はパスワード認証のアカウントを作成し、作成されたユーザー情報を返します
+func (c *Client) CreateAccount(ctx context.Context, req CreateAccountRequest) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	if err := c.do(ctx, http.MethodPost, "/accounts", "", req, &result, false); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ProvisionUser はSSOでログインしたユーザーを作成、または既存のユーザーに紐付けます
+func (c *Client) ProvisionUser(ctx context.Context, req ProvisionUserRequest) (*ProvisionedUser, error) {
+	var result ProvisionedUser
+	if err := c.do(ctx, http.MethodPost, "/users/provision", "", req, &result, false); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// LockUser はアカウントをロックし、そのユーザーのすべてのセッションを失効させます
+func (c *Client) LockUser(ctx context.Context, id uint, reason string) (*AccountLockResult, error) {
+	var result AccountLockResult
+	path := "/users/" + strconv.FormatUint(uint64(id), 10) + "/lock"
+	if err := c.do(ctx, http.MethodPost, path, "", map[string]string{"reason": reason}, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UnlockUser はアカウントのロックを解除します
+func (c *Client) UnlockUser(ctx context.Context, id uint) (*AccountLockResult, error) {
+	var result AccountLockResult
+	path := "/users/" + strconv.FormatUint(uint64(id), 10) + "/unlock"
+	if err := c.do(ctx, http.MethodPost, path, "", struct{}{}, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CreateSession はログインしたユーザーのセッションを保存します（ロック中のアカウントは403）
+func (c *Client) CreateSession(ctx context.Context, req CreateSessionRequest) error {
+	return c.do(ctx, http.MethodPost, "/sessions", "", req, nil, false)
+}
+
+// GetCurrentSession はセッションIDで認証し、そのセッションの情報を返します
+func (c *Client) GetCurrentSession(ctx context.Context, sessionID string) (*Session, error) {
+	var result Session
+	if err := c.do(ctx, http.MethodGet, "/sessions/current", "Bearer "+sessionID, nil, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ElevateSession はセッションの再認証（ステップアップ認証）の有効期限を保存し、保存された期限を返します
+func (c *Client) ElevateSession(ctx context.Context, sessionID string, until time.Time) (time.Time, error) {
+	var result struct {
+		ElevatedUntil time.Time `json:"elevated_until"`
+	}
+	body := map[string]time.Time{"elevated_until": until}
+	if err := c.do(ctx, http.MethodPost, "/sessions/current/elevate", "Bearer "+sessionID, body, &result, true); err != nil {
+		return time.Time{}, err
+	}
+	return result.ElevatedUntil, nil
+}
+
+// DeleteCurrentSession はセッションIDで認証し、そのセッションを失効させます
+func (c *Client) DeleteCurrentSession(ctx context.Context, sessionID string) error {
+	return c.do(ctx, http.MethodDelete, "/sessions/current", "Bearer "+sessionID, nil, nil, true)
+}
+
+// DeleteAllSessions はセッションのユーザーのすべてのセッションを失効させ、失効したセッションIDを返します。
+// exceptCurrent が true の場合は認証に使用したセッションを残します
+func (c *Client) DeleteAllSessions(ctx context.Context, sessionID string, exceptCurrent bool) ([]string, error) {
+	path := "/sessions/all"
+	if exceptCurrent {
+		path += "?except_current=true"
+	}
+	var result struct {
+		SessionIDs []string `json:"session_ids"`
+	}
+	if err := c.do(ctx, http.MethodDelete, path, "Bearer "+sessionID, nil, &result, true); err != nil {
+		return nil, err
+	}
+	return result.SessionIDs, nil
+}
+
+// CreateDeviceToken はログイン状態を保持する端末トークンを保存します
+func (c *Client) CreateDeviceToken(ctx context.Context, req CreateDeviceTokenRequest) error {
+	return c.do(ctx, http.MethodPost, "/device-tokens", "", req, nil, false)
+}
+
+// ExchangeDeviceToken は端末トークンを新しいトークンへ置き換え、新しいセッションを作成します。
+// 使用済みのトークンは再提示できないため、応答を受け取れなかった場合もリトライしません
+func (c *Client) ExchangeDeviceToken(ctx context.Context, req ExchangeDeviceTokenRequest) (*ResumedSession, error) {
+	var result ResumedSession
+	if err := c.do(ctx, http.MethodPost, "/device-tokens/exchange", "", req, &result, false); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RevokeDeviceToken は端末トークンを失効させます
+func (c *Client) RevokeDeviceToken(ctx context.Context, tokenHash string) error {
+	return c.do(ctx, http.MethodPost, "/device-tokens/revoke", "", map[string]string{"token_hash": tokenHash}, nil, true)
+}
+
+// ListDeviceTokens はセッションのユーザーがログイン状態を保持している端末の一覧を返します
+func (c *Client) ListDeviceTokens(ctx context.Context, sessionID string) (json.RawMessage, error) {
+	var result json.RawMessage
+	if err := c.do(ctx, http.MethodGet, "/device-tokens", "Bearer "+sessionID, nil, &result, true); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteDeviceToken はセッションのユーザーの端末のログイン状態の保持を解除します
+func (c *Client) DeleteDeviceToken(ctx context.Context, sessionID, id string) (json.RawMessage, error) {
+	var result json.RawMessage
+	if err := c.do(ctx, http.MethodDelete, "/device-tokens/"+url.PathEscape(id), "Bearer "+sessionID, nil, &result, true); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RecordAuditLog は認証イベントを監査ログに保存します
+func (c *Client) RecordAuditLog(ctx context.Context, req AuditLogRequest) error {
+	return c.do(ctx, http.MethodPost, "/audit-logs", "", req, nil, false)
+}
+
+// ListAuditLogs は監査ログを検索し、DB Pilotのレスポンスをそのまま返します
+func (c *Client) ListAuditLogs(ctx context.Context, query url.Values) (json.RawMessage, error) {
+	var result json.RawMessage
+	if err := c.do(ctx, http.MethodGet, "/audit-logs?"+query.Encode(), "", nil, &result, true); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// do はリクエストを送信し、成功時はレスポンスを out にデコードします。
+// 接続確立前のエラーは常に、502/503/504 とタイムアウトは idempotent な場合のみリトライします
+func (c *Client) do(ctx context.Context, method, path, authHeader string, body, out interface{}, idempotent bool) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+	}
+
+	backoff := c.retryBackoff
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		retryable, err := c.send(ctx, method, path, authHeader, payload, out, idempotent)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retryable {
+			break
+		}
+	}
+	return lastErr
+}
+
+func (c *Client) send(ctx context.Context, method, path, authHeader string, payload []byte, out interface{}, idempotent bool) (bool, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path), reqBody)
+	if err != nil {
+		return false, fmt.Errorf("failed to create DB pilot request: %v", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return isDialError(err) || (idempotent && ctx.Err() == nil),
+			fmt.Errorf("failed to send DB pilot request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Body: string(respBody)}
+		var errResp struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if json.Unmarshal(respBody, &errResp) == nil {
+			apiErr.Message = errResp.Error
+			apiErr.Code = errResp.Code
+		}
+		return idempotent && isRetryableStatus(resp.StatusCode), apiErr
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return false, fmt.Errorf("failed to decode DB pilot response: %v", err)
+		}
+	}
+	return false, nil
+}
+
+func (c *Client) url(path string) string {
+	baseURL := c.baseURL
+	if baseURL == "" {
+		baseURL = strings.TrimSuffix(os.Getenv("DB_PILOT_SERVICE_URL"), "/")
+	}
+	return baseURL + path
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// isDialError はサーバーにリクエストが届いていないことが確実な接続エラーかを判定します
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"auth/dbpilot"
+	"auth/logger"
+	"auth/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// errCodeAccountLocked はロック中のアカウントに対するエラーコード
+const errCodeAccountLocked = "account_locked"
+
+// errAccountLocked はDB Pilotがロック中のアカウントのセッション作成を拒否した場合のエラー
+var errAccountLocked = errors.New("account is locked")
+
+// 管理者情報のコンテキストキー
+const adminEmailKey = "admin_email"
+
+type LockAccountRequest struct {
+	Reason string `json:"reason"`
+}
+
+// RequireAdmin はセッションを検証し、管理者（ADMIN_EMAILS）以外のリクエストを拒否します
+func RequireAdmin(c *gin.Context) {
+	sessionID := sessionIDFromRequest(c)
+	if sessionID == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session is required"})
+		return
+	}
+
+	session, status, err := verifySessionCached(sessionID)
+	if err != nil {
+		logger.Logger.Warn("セッションの確認に失敗しました",
+			zap.String("path", c.Request.URL.Path), zap.Int("status_code", status), zap.Error(err))
+		if status == http.StatusUnauthorized || status == http.StatusNotFound {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "Failed to verify session"})
+		return
+	}
+
+	if !utils.IsAdminEmail(session.Email) {
+		logger.Logger.Warn("管理者以外による管理APIへのアクセスを拒否しました",
+			zap.String("path", c.Request.URL.Path), zap.String("email", session.Email))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin privileges required"})
+		return
+	}
+
+	c.Set(adminEmailKey, session.Email)
+	c.Next()
+}
+
+// LockAccount はアカウントをロックし、そのユーザーのすべてのセッションを失効させます
+func LockAccount(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "LockAccount"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+		zap.String("admin_email", c.GetString(adminEmailKey)),
+	}
+
+	var req LockAccountRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+
+	result, status, err := updateAccountLock(c.Request.Context(), c.Param("id"), "lock", req.Reason)
+	if err != nil {
+		logger.Logger.Error("アカウントのロックに失敗しました",
+			append(logFields, zap.Int("status_code", status), zap.Error(err))...)
+		respondAccountLockError(c, status)
+		return
+	}
+
+	// 失効したセッションを検証キャッシュからも削除し、即座に利用できなくする
+	for _, id := range result.SessionIDs {
+		verifiedSessions.invalidate(id)
+	}
+
+	recordAuditEvent(c, auditEventAccountLock, auditOutcomeSuccess, result.UserID, result.Email,
+		map[string]string{
+			"actor":            c.GetString(adminEmailKey),
+			"reason":           req.Reason,
+			"revoked_sessions": strconv.Itoa(len(result.SessionIDs)),
+		})
+
+	logger.Logger.Warn("アカウントをロックしました",
+		append(logFields,
+			zap.Uint("user_id", result.UserID),
+			zap.String("email", result.Email),
+			zap.Int("revoked_sessions", len(result.SessionIDs)))...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Account locked successfully",
+		"user_id":          result.UserID,
+		"email":            result.Email,
+		"revoked_sessions": len(result.SessionIDs),
+	})
+}
+
+// UnlockAccount はアカウントのロックを解除します
+func UnlockAccount(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "UnlockAccount"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+		zap.String("admin_email", c.GetString(adminEmailKey)),
+	}
+
+	result, status, err := updateAccountLock(c.Request.Context(), c.Param("id"), "unlock", "")
+	if err != nil {
+		logger.Logger.Error("アカウントのロック解除に失敗しました",
+			append(logFields, zap.Int("status_code", status), zap.Error(err))...)
+		respondAccountLockError(c, status)
+		return
+	}
+
+	recordAuditEvent(c, auditEventAccountUnlock, auditOutcomeSuccess, result.UserID, result.Email,
+		map[string]string{"actor": c.GetString(adminEmailKey)})
+
+	logger.Logger.Info("アカウントのロックを解除しました",
+		append(logFields, zap.Uint("user_id", result.UserID), zap.String("email", result.Email))...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Account unlocked successfully",
+		"user_id": result.UserID,
+		"email":   result.Email,
+	})
+}
+
+// updateAccountLock はDB Pilotのロック/ロック解除APIを呼び出します
+func updateAccountLock(ctx context.Context, userID, action, reason string) (*dbpilot.AccountLockResult, int, error) {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid user id: %q", userID)
+	}
+
+	var result *dbpilot.AccountLockResult
+	if action == "lock" {
+		result, err = dbPilotClient.LockUser(ctx, uint(id), reason)
+	} else {
+		result, err = dbPilotClient.UnlockUser(ctx, uint(id))
+	}
+	if err != nil {
+		status := dbpilot.StatusCode(err)
+		if status == 0 {
+			status = http.StatusBadGateway
+		}
+		return nil, status, err
+	}
+	return result, http.StatusOK, nil
+}
+
+// respondAccountLockError はDB Pilotのエラーをクライアント向けのレスポンスに変換します
+func respondAccountLockError(c *gin.Context, status int) {
+	switch status {
+	case http.StatusBadRequest:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+	case http.StatusNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to update account lock"})
+	}
+}
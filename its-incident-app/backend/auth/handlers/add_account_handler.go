@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"auth/dbpilot"
 	"auth/logger"
+	"auth/utils"
 	"bytes"
 	"crypto/rand"
 	"encoding/hex"
@@ -9,6 +11,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -19,17 +24,74 @@ type AddAccountRequest struct {
 	Email string `json:"email" binding:"required,email"`
 }
 
-type DBPilotRequest struct {
-	Email     string    `json:"email"`
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_at"`
+type NotificationRequest struct {
+	Email            string    `json:"email"`
+	Token            string    `json:"token"`
+	LoginURL         string    `json:"login_url"`
+	ExpiresIn        string    `json:"expires_in"` // メール本文に表示する有効期間（例: "15分"）
+	ExpiresInMinutes int       `json:"expires_in_minutes"`
+	ExpiresAt        time.Time `json:"expires_at"`
 }
 
-type NotificationRequest struct {
-	Email     string `json:"email"`
-	Token     string `json:"token"`
-	LoginURL  string `json:"login_url"`
-	ExpiresIn string `json:"expires_in"`
+const (
+	defaultMagicLinkIPLimit         = 20
+	defaultMagicLinkEmailLimit      = 5
+	defaultMagicLinkRateWindow      = time.Hour
+	defaultMagicLinkMinResponseTime = 500 * time.Millisecond
+	defaultMagicLinkTTL             = 60 * time.Minute
+)
+
+// magicLinkTTL はログインリンクの有効期間（MAGIC_LINK_TTL）
+var magicLinkTTL = defaultMagicLinkTTL
+
+// SetMagicLinkTTL はログインリンクの有効期間を設定します（0以下の場合はデフォルト）
+func SetMagicLinkTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = defaultMagicLinkTTL
+	}
+	magicLinkTTL = ttl
+}
+
+// formatExpiresIn は有効期間をメール本文用の表記（例: "1時間30分"）に変換します
+func formatExpiresIn(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Minute {
+		return "1分"
+	}
+
+	hours := int(d / time.Hour)
+	minutes := int((d % time.Hour) / time.Minute)
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%d分", minutes)
+	case minutes == 0:
+		return fmt.Sprintf("%d時間", hours)
+	default:
+		return fmt.Sprintf("%d時間%d分", hours, minutes)
+	}
+}
+
+var (
+	magicLinkLimitersOnce sync.Once
+	magicLinkIPLimiter    *utils.RateLimiter
+	magicLinkEmailLimiter *utils.RateLimiter
+)
+
+// magicLinkLimiters はログインリンク発行のIP単位・メールアドレス単位のレートリミッターを返します
+func magicLinkLimiters() (*utils.RateLimiter, *utils.RateLimiter) {
+	magicLinkLimitersOnce.Do(func() {
+		window := getEnvDuration("MAGIC_LINK_RATE_WINDOW", defaultMagicLinkRateWindow)
+		magicLinkIPLimiter = utils.NewRateLimiter(getEnvInt("MAGIC_LINK_IP_LIMIT", defaultMagicLinkIPLimit), window)
+		magicLinkEmailLimiter = utils.NewRateLimiter(getEnvInt("MAGIC_LINK_EMAIL_LIMIT", defaultMagicLinkEmailLimit), window)
+	})
+	return magicLinkIPLimiter, magicLinkEmailLimiter
+}
+
+// padResponseTime は処理結果によって応答時間が変わらないよう、最低応答時間まで待機します
+func padResponseTime(start time.Time, minimum time.Duration) {
+	if remaining := minimum - time.Since(start); remaining > 0 {
+		time.Sleep(remaining)
+	}
 }
 
 // トークン生成関数
@@ -47,6 +109,27 @@ func AddAccountUser(c *gin.Context) {
 		zap.String("handler", "AddAccountUser"),
 		zap.String("method", c.Request.Method),
 		zap.String("path", c.Request.URL.Path),
+		zap.String("client_ip", c.ClientIP()),
+	}
+
+	// メールアドレスの存在や制限状態を応答時間から推測されないようにする
+	defer padResponseTime(time.Now(),
+		getEnvDuration("MAGIC_LINK_MIN_RESPONSE_TIME", defaultMagicLinkMinResponseTime))
+
+	issueOutcome := "error"
+	defer func() { magicLinkIssuedTotal.Inc(issueOutcome) }()
+
+	ipLimiter, emailLimiter := magicLinkLimiters()
+	if attempts, allowed, retryAfter := ipLimiter.Allow(c.ClientIP()); !allowed {
+		logger.Logger.Warn("ログインリンク発行のIPレート制限を超過しました",
+			append(logFields,
+				zap.String("audit", "magic_link_throttled"),
+				zap.String("scope", "ip"),
+				zap.Int("attempts", attempts))...)
+		issueOutcome = "throttled"
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+		return
 	}
 
 	// Bearerトークンの取得
@@ -65,6 +148,33 @@ func AddAccountUser(c *gin.Context) {
 		return
 	}
 
+	if !utils.IsEmailDomainAllowed(req.Email) {
+		logger.Logger.Warn("許可されていないドメインのメールアドレスです",
+			append(logFields, zap.String("email", req.Email))...)
+		issueOutcome = "domain_rejected"
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Email domain is not allowed",
+			"code":  utils.ErrCodeEmailDomainNotAllowed,
+		})
+		return
+	}
+
+	// メールアドレス単位の制限超過時は送信せずに通常と同じ応答を返し、受信箱への大量送信を防ぐ
+	attempts, allowed, _ := emailLimiter.Allow(strings.ToLower(strings.TrimSpace(req.Email)))
+	logFields = append(logFields, zap.Int("email_attempts", attempts))
+	if !allowed {
+		logger.Logger.Warn("ログインリンク発行のメールアドレス単位のレート制限を超過しました",
+			append(logFields,
+				zap.String("audit", "magic_link_throttled"),
+				zap.String("scope", "email"),
+				zap.String("email", req.Email))...)
+		issueOutcome = "throttled"
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Login link has been sent to your email",
+		})
+		return
+	}
+
 	// トークンの生成
 	token, err := generateToken()
 	if err != nil {
@@ -74,52 +184,19 @@ func AddAccountUser(c *gin.Context) {
 		return
 	}
 
-	// DB Pilotへ送信するデータの準備
-	expiresAt := time.Now().Add(60 * time.Minute)
-	dbReqBody := DBPilotRequest{
+	// DB Pilotへトークンを保存（メールに記載する有効期間と同じ値を使う）
+	ttl := magicLinkTTL
+	expiresAt := time.Now().Add(ttl)
+	err = dbPilotClient.CreateLoginToken(c.Request.Context(), dbpilot.CreateLoginTokenRequest{
 		Email:     req.Email,
 		Token:     token,
 		ExpiresAt: expiresAt,
-	}
-
-	// DB PilotへのリクエストJSONの作成
-	jsonData, err := json.Marshal(dbReqBody)
-	if err != nil {
-		logger.Logger.Error("JSONエンコードに失敗しました",
-			append(logFields, zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare DB Pilot request"})
-		return
-	}
-
-	// DB Pilotへのリクエスト作成
-	dbPilotURL := os.Getenv("DB_PILOT_SERVICE_URL") + "/login-tokens"
-	dbReq, err := http.NewRequest("POST", dbPilotURL, bytes.NewBuffer(jsonData))
+	}, authHeader)
 	if err != nil {
-		logger.Logger.Error("DB Pilotリクエストの作成に失敗しました",
-			append(logFields, zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create DB Pilot request"})
-		return
-	}
-
-	// ヘッダーの設定
-	dbReq.Header.Set("Content-Type", "application/json")
-	dbReq.Header.Set("Authorization", authHeader)
-
-	// DB Pilotへリクエスト送信
-	client := &http.Client{}
-	resp, err := client.Do(dbReq)
-	if err != nil {
-		logger.Logger.Error("DB Pilotへのリクエスト送信に失敗しました",
-			append(logFields, zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send request to DB Pilot"})
-		return
-	}
-	defer resp.Body.Close()
-
-	// DB Pilotからのレスポンスチェック
-	if resp.StatusCode != http.StatusOK {
-		logger.Logger.Error("DB Pilotからエラーレスポンスを受信しました",
-			append(logFields, zap.Int("status_code", resp.StatusCode))...)
+		logger.Logger.Error("DB Pilotへのトークン保存に失敗しました",
+			append(logFields,
+				zap.Int("status_code", dbpilot.StatusCode(err)),
+				zap.Error(err))...)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save token in DB Pilot"})
 		return
 	}
@@ -129,10 +206,12 @@ func AddAccountUser(c *gin.Context) {
 		os.Getenv("FRONTEND_URL"), token)
 
 	notifReqBody := NotificationRequest{
-		Email:     req.Email,
-		Token:     token,
-		LoginURL:  loginURL,
-		ExpiresIn: "15分",
+		Email:            req.Email,
+		Token:            token,
+		LoginURL:         loginURL,
+		ExpiresIn:        formatExpiresIn(ttl),
+		ExpiresInMinutes: int(ttl.Round(time.Minute) / time.Minute),
+		ExpiresAt:        expiresAt,
 	}
 
 	notificationJSON, err := json.Marshal(notifReqBody)
@@ -158,6 +237,7 @@ func AddAccountUser(c *gin.Context) {
 	notifReq.Header.Set("Authorization", authHeader)
 
 	// 通知サービスへリクエスト送信
+	client := &http.Client{Timeout: 10 * time.Second}
 	notificationResp, err := client.Do(notifReq)
 	if err != nil {
 		logger.Logger.Error("通知サービスへのリクエスト送信に失敗しました",
@@ -176,9 +256,30 @@ func AddAccountUser(c *gin.Context) {
 	}
 
 	logger.Logger.Info("ログインリンクの送信を完了しました",
-		append(logFields, zap.String("email", req.Email))...)
+		append(logFields,
+			zap.String("audit", "magic_link_issued"),
+			zap.String("email", req.Email))...)
+	issueOutcome = "sent"
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Login link has been sent to your email",
 	})
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"auth/dbpilot"
+	"auth/logger"
+	"auth/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 監査ログのイベント種別
+const (
+	auditEventLogin          = "login"
+	auditEventLogout         = "logout"
+	auditEventLogoutAll      = "logout_all"
+	auditEventTokenVerify    = "token_verify"
+	auditEventAccountCreate  = "account_create"
+	auditEventAccountLock    = "account_lock"
+	auditEventAccountUnlock  = "account_unlock"
+	auditEventReauth         = "reauth"
+	auditEventLockout        = "account_lockout"
+	auditEventTokenRevoke    = "login_token_revoke"
+	auditEventSCIMProvision  = "scim_provision"
+	auditEventSCIMUpdate     = "scim_update"
+	auditEventSCIMDeactivate = "scim_deactivate"
+
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+)
+
+// recordAuditEvent は認証イベントを非同期でDB Pilotの監査ログに保存します（失敗しても処理は継続）
+func recordAuditEvent(c *gin.Context, eventType, outcome string, userID uint, email string, details map[string]string) {
+	event := dbpilot.AuditLogRequest{
+		EventType: eventType,
+		Outcome:   outcome,
+		UserID:    userID,
+		Email:     email,
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if len(details) > 0 {
+		if b, err := json.Marshal(details); err == nil {
+			event.Details = string(b)
+		}
+	}
+
+	// リクエストの完了後も保存を続けるため、リクエストのコンテキストは使わない
+	client := dbPilotClient
+	go func() {
+		if err := client.RecordAuditLog(context.Background(), event); err != nil {
+			logger.Logger.Warn("監査ログの保存に失敗しました",
+				zap.String("event_type", eventType),
+				zap.Int("status_code", dbpilot.StatusCode(err)),
+				zap.Error(err))
+		}
+	}()
+}
+
+// GetAuditLogs は監査ログを検索します。
+// 管理者（ADMIN_EMAILS）は全ユーザーを、それ以外のユーザーは自分のイベントのみ参照できます
+func GetAuditLogs(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "GetAuditLogs"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	sessionID := sessionIDFromRequest(c)
+	if sessionID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is required"})
+		return
+	}
+
+	session, status, err := verifySessionCached(sessionID)
+	if err != nil {
+		logger.Logger.Warn("セッションの確認に失敗しました",
+			append(logFields, zap.Int("status_code", status), zap.Error(err))...)
+		if status == http.StatusUnauthorized {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to verify session"})
+		return
+	}
+
+	params := url.Values{}
+	for _, key := range []string{"user_id", "email", "event_type", "outcome", "from", "to", "limit", "offset"} {
+		if value := c.Query(key); value != "" {
+			params.Set(key, value)
+		}
+	}
+	if !utils.IsAdminEmail(session.Email) {
+		params.Del("user_id")
+		params.Set("email", session.Email)
+	}
+
+	logs, err := dbPilotClient.ListAuditLogs(c.Request.Context(), params)
+	if err != nil {
+		// 検索条件の誤りはDB Pilotのエラーをそのまま返す
+		var apiErr *dbpilot.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest {
+			c.Data(http.StatusBadRequest, "application/json", []byte(apiErr.Body))
+			return
+		}
+		logger.Logger.Error("監査ログの取得に失敗しました",
+			append(logFields, zap.Int("status_code", dbpilot.StatusCode(err)), zap.Error(err))...)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch audit logs"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", logs)
+}
@@ -0,0 +1,11 @@
+package handlers
+
+import "auth/dbpilot"
+
+// dbPilotClient はハンドラーが使用するDB Pilotクライアント
+var dbPilotClient dbpilot.API = dbpilot.NewClient("")
+
+// SetDBPilotClient はハンドラーが使用するDB Pilotクライアントを差し替えます（起動時やテストで使用）
+func SetDBPilotClient(client dbpilot.API) {
+	dbPilotClient = client
+}
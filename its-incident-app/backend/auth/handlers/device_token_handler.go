@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"auth/dbpilot"
+	"auth/logger"
+	"auth/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	deviceTokenCookie     = "device_token"
+	defaultDeviceTokenTTL = 30 * 24 * time.Hour
+	maxDeviceNameLength   = 100
+)
+
+// ResumeSession は端末トークン（ログイン状態の保持）と引き換えに新しいセッションを発行します。
+// 使用した端末トークンはローテーションされます
+func ResumeSession(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "ResumeSession"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	deviceToken, err := c.Cookie(deviceTokenCookie)
+	if err != nil || deviceToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Device token is required"})
+		return
+	}
+
+	newDeviceToken, err := generateToken()
+	if err != nil {
+		logger.Logger.Error("トークン生成に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	sessionID := utils.GenerateSessionID()
+	sessionExpiresAt := time.Now().Add(sessionDuration)
+
+	result, err := dbPilotClient.ExchangeDeviceToken(c.Request.Context(), dbpilot.ExchangeDeviceTokenRequest{
+		TokenHash:        hashRefreshToken(deviceToken),
+		NewTokenHash:     hashRefreshToken(newDeviceToken),
+		SessionID:        sessionID,
+		SessionExpiresAt: sessionExpiresAt,
+	})
+	if err != nil {
+		switch status := dbpilot.StatusCode(err); status {
+		case http.StatusUnauthorized:
+			logger.Logger.Warn("端末トークンが拒否されました", logFields...)
+			clearDeviceTokenCookie(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid device token"})
+		case http.StatusForbidden:
+			clearDeviceTokenCookie(c)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is locked", "code": errCodeAccountLocked})
+		default:
+			logger.Logger.Error("端末トークンの交換に失敗しました",
+				append(logFields, zap.Int("status_code", status), zap.Error(err))...)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to resume session"})
+		}
+		return
+	}
+	session := CurrentSession{
+		UserID:        result.UserID,
+		Email:         result.Email,
+		SessionID:     result.SessionID,
+		ExpiresAt:     result.ExpiresAt,
+		ElevatedUntil: result.ElevatedUntil,
+	}
+
+	setDeviceTokenCookie(c, newDeviceToken, result.DeviceExpiresAt)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.SessionID,
+		HttpOnly: true,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+	})
+	verifiedSessions.set(session.SessionID, &session)
+
+	// リフレッシュトークンの発行に失敗してもセッションの再開自体は成功とする
+	if err := issueRefreshToken(c, session.SessionID, session.UserID, session.Email); err != nil {
+		logger.Logger.Warn("リフレッシュトークンの発行に失敗しました",
+			append(logFields, zap.Uint("user_id", session.UserID), zap.Error(err))...)
+	}
+
+	recordAuditEvent(c, auditEventLogin, auditOutcomeSuccess, session.UserID, session.Email,
+		map[string]string{"method": "device_token"})
+	countLogin("device_token", "")
+
+	logger.Logger.Info("端末トークンからセッションを再開しました",
+		append(logFields, zap.Uint("user_id", session.UserID))...)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session resumed", "expires_at": session.ExpiresAt})
+}
+
+// ListDevices はログイン状態を保持している端末の一覧を返します
+func ListDevices(c *gin.Context) {
+	proxyDeviceRequest(c, "ListDevices", func(ctx context.Context, sessionID string) (json.RawMessage, error) {
+		return dbPilotClient.ListDeviceTokens(ctx, sessionID)
+	})
+}
+
+// RevokeDevice は指定した端末のログイン状態の保持を解除します
+func RevokeDevice(c *gin.Context) {
+	proxyDeviceRequest(c, "RevokeDevice", func(ctx context.Context, sessionID string) (json.RawMessage, error) {
+		return dbPilotClient.DeleteDeviceToken(ctx, sessionID, c.Param("id"))
+	})
+}
+
+// proxyDeviceRequest はセッションIDでDB Pilotの端末トークンAPIを呼び出し、結果をそのまま返します
+func proxyDeviceRequest(c *gin.Context, handler string, call func(ctx context.Context, sessionID string) (json.RawMessage, error)) {
+	logFields := []zap.Field{
+		zap.String("handler", handler),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	sessionID := sessionIDFromRequest(c)
+	if sessionID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is required"})
+		return
+	}
+
+	body, err := call(c.Request.Context(), sessionID)
+	if err != nil {
+		var apiErr *dbpilot.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode < http.StatusInternalServerError {
+			c.Data(apiErr.StatusCode, "application/json", []byte(apiErr.Body))
+			return
+		}
+		logger.Logger.Error("DB Pilotへのリクエストに失敗しました",
+			append(logFields, zap.Int("status_code", dbpilot.StatusCode(err)), zap.Error(err))...)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to communicate with DB Pilot"})
+		return
+	}
+	c.Data(http.StatusOK, "application/json", body)
+}
+
+// issueDeviceToken は端末トークンを発行してDB Pilotに保存し、クッキーに設定します
+func issueDeviceToken(c *gin.Context, userID uint, email, deviceName string) error {
+	token, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate device token: %v", err)
+	}
+	expiresAt := time.Now().Add(deviceTokenTTL())
+
+	if deviceName == "" {
+		deviceName = c.Request.UserAgent()
+	}
+	if len(deviceName) > maxDeviceNameLength {
+		deviceName = deviceName[:maxDeviceNameLength]
+	}
+
+	if err := dbPilotClient.CreateDeviceToken(c.Request.Context(), dbpilot.CreateDeviceTokenRequest{
+		TokenHash:  hashRefreshToken(token),
+		UserID:     userID,
+		Email:      email,
+		DeviceName: deviceName,
+		ExpiresAt:  expiresAt,
+	}); err != nil {
+		return err
+	}
+
+	setDeviceTokenCookie(c, token, expiresAt)
+	return nil
+}
+
+// revokeCurrentDeviceToken はリクエストの端末トークンを失効させ、クッキーを削除します
+func revokeCurrentDeviceToken(c *gin.Context) error {
+	token, err := c.Cookie(deviceTokenCookie)
+	if err != nil || token == "" {
+		return nil
+	}
+	clearDeviceTokenCookie(c)
+
+	return dbPilotClient.RevokeDeviceToken(c.Request.Context(), hashRefreshToken(token))
+}
+
+func setDeviceTokenCookie(c *gin.Context, token string, expiresAt time.Time) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     deviceTokenCookie,
+		Value:    token,
+		HttpOnly: true,
+		Path:     "/",
+		Expires:  expiresAt,
+		Secure:   c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearDeviceTokenCookie(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     deviceTokenCookie,
+		Value:    "",
+		HttpOnly: true,
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+	})
+}
+
+// deviceTokenTTL は端末トークンの有効期間（DEVICE_TOKEN_TTL、デフォルト30日）
+func deviceTokenTTL() time.Duration {
+	if ttl := getEnvDuration("DEVICE_TOKEN_TTL", defaultDeviceTokenTTL); ttl > 0 {
+		return ttl
+	}
+	return defaultDeviceTokenTTL
+}
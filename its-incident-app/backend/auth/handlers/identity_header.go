@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"auth/identity"
+	"auth/logger"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// defaultIdentityHeaderTTL は署名付き利用者情報ヘッダーの既定の有効期間
+const defaultIdentityHeaderTTL = time.Minute
+
+// setIdentityHeader は検証済みのセッションから署名付きの X-Authenticated-User を発行し、レスポンスに付与します。
+// 呼び出し元はこのヘッダーを dbpilot / notify へ転送することで、セッションの再問い合わせを省略できます。
+// IDENTITY_HEADER_SECRET が未設定の環境では何もしません
+func setIdentityHeader(c *gin.Context, session *CurrentSession) {
+	if !identity.Enabled() {
+		return
+	}
+
+	value, err := identity.Sign(identity.Identity{
+		UserID:    session.UserID,
+		Email:     session.Email,
+		SessionID: session.SessionID,
+	}, identityHeaderTTL(session))
+	if err != nil {
+		logger.Logger.Warn("利用者情報ヘッダーの署名に失敗しました",
+			zap.Uint("user_id", session.UserID), zap.Error(err))
+		return
+	}
+
+	c.Header(identity.Header, value)
+}
+
+// identityHeaderTTL は IDENTITY_HEADER_TTL（セッションの残り時間を上限）を返します
+func identityHeaderTTL(session *CurrentSession) time.Duration {
+	ttl := getEnvDuration("IDENTITY_HEADER_TTL", defaultIdentityHeaderTTL)
+	if remaining := time.Until(session.ExpiresAt); remaining < ttl {
+		ttl = remaining
+	}
+	return ttl
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"auth/dbpilot"
+	"auth/logger"
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// errCodeLoginAttemptsExceeded はログイン失敗回数の上限によるロックアウト中のエラーコード
+const errCodeLoginAttemptsExceeded = "login_attempts_exceeded"
+
+// loginAttemptPolicy はアカウント単位のログイン失敗回数の上限とロック時間
+type loginAttemptPolicy struct {
+	maxAttempts  int
+	lockDuration time.Duration
+}
+
+var (
+	attemptPolicy = loginAttemptPolicy{maxAttempts: 5, lockDuration: 15 * time.Minute}
+
+	// ロックアウトの発生状況（/debug/vars で参照可能）
+	loginFailuresTotal    = expvar.NewInt("auth_login_failures_total")
+	accountLockoutsTotal  = expvar.NewInt("auth_account_lockouts_total")
+	lockedLoginRejections = expvar.NewInt("auth_locked_login_rejections_total")
+)
+
+// SetLoginAttemptPolicy は MaxLoginAttempts / AccountLockDurationMins の設定を反映します（maxAttempts が0以下で無効）
+func SetLoginAttemptPolicy(maxAttempts, lockDurationMins int) {
+	attemptPolicy = loginAttemptPolicy{
+		maxAttempts:  maxAttempts,
+		lockDuration: time.Duration(lockDurationMins) * time.Minute,
+	}
+}
+
+func (p loginAttemptPolicy) enabled() bool {
+	return p.maxAttempts > 0 && p.lockDuration > 0
+}
+
+// rejectIfLockedOut はロックアウト中のアカウントに429を返し、処理を打ち切った場合は true を返します。
+// DB Pilotに問い合わせできない場合はログインを妨げないよう許可します
+func rejectIfLockedOut(c *gin.Context, email string, logFields []zap.Field) bool {
+	if !attemptPolicy.enabled() || email == "" {
+		return false
+	}
+
+	attempt, err := dbPilotClient.GetLoginAttempt(c.Request.Context(), normalizeEmail(email))
+	if err != nil {
+		logger.Logger.Warn("ログイン失敗回数の取得に失敗しました", append(logFields, zap.Error(err))...)
+		return false
+	}
+	if !attempt.Locked || attempt.LockedUntil == nil {
+		return false
+	}
+
+	lockedLoginRejections.Add(1)
+	logger.Logger.Warn("ロックアウト中のアカウントのログインを拒否しました",
+		append(logFields, zap.String("email", email), zap.Time("locked_until", *attempt.LockedUntil))...)
+
+	c.Header("Retry-After", strconv.Itoa(int(time.Until(*attempt.LockedUntil).Seconds())+1))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": "Too many failed login attempts",
+		"code":  errCodeLoginAttemptsExceeded,
+	})
+	return true
+}
+
+// recordLoginFailure はログイン失敗を数え、上限に達した場合はロックアウトを監査ログに記録します
+func recordLoginFailure(c *gin.Context, userID uint, email string, logFields []zap.Field) {
+	loginFailuresTotal.Add(1)
+	if !attemptPolicy.enabled() || email == "" {
+		return
+	}
+
+	attempt, err := dbPilotClient.RecordLoginFailure(c.Request.Context(), dbpilot.LoginFailureRequest{
+		Email:               normalizeEmail(email),
+		MaxAttempts:         attemptPolicy.maxAttempts,
+		LockDurationSeconds: int(attemptPolicy.lockDuration.Seconds()),
+	})
+	if err != nil {
+		logger.Logger.Warn("ログイン失敗の記録に失敗しました", append(logFields, zap.Error(err))...)
+		return
+	}
+
+	if attempt.LockoutStarted {
+		accountLockoutsTotal.Add(1)
+		recordAuditEvent(c, auditEventLockout, auditOutcomeSuccess, userID, email,
+			map[string]string{"max_attempts": strconv.Itoa(attemptPolicy.maxAttempts)})
+		logger.Logger.Warn("ログイン失敗回数が上限に達しました",
+			append(logFields, zap.String("email", email), zap.Timep("locked_until", attempt.LockedUntil))...)
+	}
+}
+
+// resetLoginAttempts はログイン成功時に失敗回数をリセットします（失敗してもログインは継続）
+func resetLoginAttempts(email string, logFields []zap.Field) {
+	if !attemptPolicy.enabled() || email == "" {
+		return
+	}
+
+	// レスポンスを待たせないよう非同期で実行する
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := dbPilotClient.ResetLoginAttempts(ctx, normalizeEmail(email)); err != nil {
+			logger.Logger.Warn("ログイン失敗回数のリセットに失敗しました", append(logFields, zap.Error(err))...)
+		}
+	}()
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -2,72 +2,101 @@
 package handlers
 
 import (
-	"bytes"
-	"encoding/json"
 	"net/http"
-	"os"
 	"time"
 
+	"auth/dbpilot"
+	"auth/logger"
+	"auth/middleware"
 	"auth/utils"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-}
-
-type QueryUserResponse struct {
-	ID       uint   `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	RememberMe bool   `json:"remember_me"` // 端末トークンを発行してログイン状態を保持する
+	DeviceName string `json:"device_name"`
 }
 
 func LoginUser(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "LoginUser"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	// 失敗理由（成功時は空）をメトリクスに記録する
+	start := time.Now()
+	failureReason := "internal_error"
+	defer func() { observeLogin(start, failureReason) }()
+
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
+		failureReason = "invalid_request"
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
+	c.Set(middleware.AuthEmailKey, req.Email)
 
-	// DB Pilot Serviceからユーザー情報を取得
-	baseURL := os.Getenv("DB_PILOT_SERVICE_URL")
-	userData := map[string]string{"email": req.Email}
-	userDataJSON, _ := json.Marshal(userData)
-	resp, err := http.Post(baseURL+"/login", "application/json", bytes.NewBuffer(userDataJSON))
-	if err != nil || resp.StatusCode != http.StatusOK {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+	// 連続したログイン失敗によるロックアウト中は認証しない
+	if rejectIfLockedOut(c, req.Email, logFields) {
+		failureReason = "locked_out"
 		return
 	}
 
-	var userResponse QueryUserResponse
-	if err := json.NewDecoder(resp.Body).Decode(&userResponse); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user data"})
+	// DB Pilot Serviceからユーザー情報を取得
+	userResponse, err := dbPilotClient.GetUserCredentials(c.Request.Context(), req.Email)
+	if err != nil {
+		if dbpilot.StatusCode(err) != http.StatusNotFound {
+			logger.Logger.Error("ユーザー情報の取得に失敗しました", append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+		recordAuditEvent(c, auditEventLogin, auditOutcomeFailure, 0, req.Email,
+			map[string]string{"reason": "user_not_found"})
+		failureReason = "user_not_found"
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
 	}
 
 	// パスワード検証
 	if err := bcrypt.CompareHashAndPassword([]byte(userResponse.Password), []byte(req.Password)); err != nil {
+		recordAuditEvent(c, auditEventLogin, auditOutcomeFailure, userResponse.ID, req.Email,
+			map[string]string{"reason": "invalid_password"})
+		failureReason = "invalid_password"
+		recordLoginFailure(c, userResponse.ID, req.Email, logFields)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
 		return
 	}
 
+	// 管理者によりロックされたアカウントはログインさせない
+	if userResponse.Locked {
+		recordAuditEvent(c, auditEventLogin, auditOutcomeFailure, userResponse.ID, req.Email,
+			map[string]string{"reason": "account_locked"})
+		failureReason = "account_locked"
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is locked", "code": errCodeAccountLocked})
+		return
+	}
+
+	resetLoginAttempts(req.Email, logFields)
+
 	// セッションIDの生成
 	sessionID := utils.GenerateSessionID()
 	expirationTime := time.Now().Add(24 * time.Hour) // セッションの有効期限
 
 	// セッション情報をDB Pilot Serviceに保存
-	saveSessionReq := map[string]interface{}{
-		"user_id":    userResponse.ID,
-		"email":      userResponse.Email,
-		"session_id": sessionID,
-		"expires_at": expirationTime,
-	}
-	saveSessionReqJSON, _ := json.Marshal(saveSessionReq)
-	_, err = http.Post(baseURL+"/sessions", "application/json", bytes.NewBuffer(saveSessionReqJSON))
+	err = dbPilotClient.CreateSession(c.Request.Context(), dbpilot.CreateSessionRequest{
+		UserID:    userResponse.ID,
+		Email:     userResponse.Email,
+		SessionID: sessionID,
+		ExpiresAt: expirationTime,
+	})
 	if err != nil {
+		logger.Logger.Error("セッションの保存に失敗しました", append(logFields, zap.Error(err))...)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session"})
 		return
 	}
@@ -81,5 +110,23 @@ func LoginUser(c *gin.Context) {
 		Expires:  expirationTime,
 	})
 
+	// リフレッシュトークンの発行（失敗してもログイン自体は成功とする）
+	if err := issueRefreshToken(c, sessionID, userResponse.ID, userResponse.Email); err != nil {
+		logger.Logger.Warn("リフレッシュトークンの発行に失敗しました",
+			zap.Uint("user_id", userResponse.ID), zap.Error(err))
+	}
+
+	// ログイン状態の保持（失敗してもログイン自体は成功とする）
+	if req.RememberMe {
+		if err := issueDeviceToken(c, userResponse.ID, userResponse.Email, req.DeviceName); err != nil {
+			logger.Logger.Warn("端末トークンの発行に失敗しました",
+				zap.Uint("user_id", userResponse.ID), zap.Error(err))
+		}
+	}
+
+	recordAuditEvent(c, auditEventLogin, auditOutcomeSuccess, userResponse.ID, userResponse.Email,
+		map[string]string{"method": "password"})
+	failureReason = ""
+
 	c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
 }
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"auth/dbpilot"
+	"auth/logger"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const sessionCookieName = "session_id"
+
+// LogoutUser はセッションの失効とクッキーの削除をまとめて行います
+func LogoutUser(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "LogoutUser"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	sessionID := sessionIDFromRequest(c)
+	if sessionID == "" {
+		logger.Logger.Warn("セッションIDが見つかりません", logFields...)
+		clearSessionCookie(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is required"})
+		return
+	}
+
+	// 監査ログ用にユーザーを特定する（失敗してもログアウトは継続）
+	var userID uint
+	var email string
+	if session, _, err := verifySessionCached(sessionID); err == nil {
+		userID, email = session.UserID, session.Email
+	}
+
+	// DB Pilotのセッションを失効
+	if err := revokeDBPilotSession(c.Request.Context(), sessionID); err != nil {
+		logger.Logger.Error("セッションの失効に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	// 検証結果のキャッシュとクッキーはセッション失効後に必ず削除
+	verifiedSessions.invalidate(sessionID)
+	clearSessionCookie(c)
+	clearRefreshTokenCookie(c)
+
+	// この端末のログイン状態の保持も解除する
+	if err := revokeCurrentDeviceToken(c); err != nil {
+		logger.Logger.Warn("端末トークンの失効に失敗しました",
+			append(logFields, zap.Error(err))...)
+	}
+
+	recordAuditEvent(c, auditEventLogout, auditOutcomeSuccess, userID, email, nil)
+
+	logger.Logger.Info("ログアウトが完了しました", logFields...)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
+}
+
+// LogoutAllDevices はユーザーのすべての端末のセッションを失効させます。
+// パスワード変更後や不正アクセスが疑われる場合に使用します
+func LogoutAllDevices(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "LogoutAllDevices"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	sessionID := sessionIDFromRequest(c)
+	if sessionID == "" {
+		logger.Logger.Warn("セッションIDが見つかりません", logFields...)
+		clearSessionCookie(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is required"})
+		return
+	}
+
+	var userID uint
+	var email string
+	if session, _, err := verifySessionCached(sessionID); err == nil {
+		userID, email = session.UserID, session.Email
+	}
+
+	sessionIDs, err := revokeAllDBPilotSessions(c.Request.Context(), sessionID, false)
+	if err != nil {
+		logger.Logger.Error("全セッションの失効に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	clearSessionCookie(c)
+	clearRefreshTokenCookie(c)
+	// 端末トークンはDB Pilot側で全件失効済み
+	clearDeviceTokenCookie(c)
+
+	recordAuditEvent(c, auditEventLogoutAll, auditOutcomeSuccess, userID, email,
+		map[string]string{"revoked_sessions": strconv.Itoa(len(sessionIDs))})
+
+	logger.Logger.Info("全端末からのログアウトが完了しました",
+		append(logFields, zap.Int("revoked_sessions", len(sessionIDs)))...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Successfully logged out from all devices",
+		"revoked_sessions": len(sessionIDs),
+	})
+}
+
+// sessionIDFromRequest はクッキーまたはAuthorizationヘッダーからセッションIDを取得します
+func sessionIDFromRequest(c *gin.Context) string {
+	if cookie, err := c.Cookie(sessionCookieName); err == nil && cookie != "" {
+		return cookie
+	}
+
+	authHeader := c.GetHeader("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	return ""
+}
+
+// clearSessionCookie はログイン時と同じ属性でセッションクッキーを失効させます
+func clearSessionCookie(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		HttpOnly: true,
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+	})
+}
+
+// revokeDBPilotSession はDB Pilotのセッションを失効させます。既に失効済みのセッションはログアウト済みとみなします
+func revokeDBPilotSession(ctx context.Context, sessionID string) error {
+	err := dbPilotClient.DeleteCurrentSession(ctx, sessionID)
+	if dbpilot.StatusCode(err) == http.StatusUnauthorized {
+		return nil
+	}
+	return err
+}
+
+// revokeAllDBPilotSessions はユーザーのすべてのセッションをDB Pilotで失効させ、検証結果のキャッシュからも削除します。
+// exceptCurrent が true の場合はリクエストに使用したセッションを残します
+func revokeAllDBPilotSessions(ctx context.Context, sessionID string, exceptCurrent bool) ([]string, error) {
+	sessionIDs, err := dbPilotClient.DeleteAllSessions(ctx, sessionID, exceptCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range sessionIDs {
+		verifiedSessions.invalidate(id)
+	}
+	if !exceptCurrent {
+		verifiedSessions.invalidate(sessionID)
+	}
+	return sessionIDs, nil
+}
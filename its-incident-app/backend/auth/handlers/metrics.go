@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"auth/metrics"
+	"time"
+)
+
+// 認証の成否とレイテンシ（/metrics で公開し、認証まわりの劣化をアラートで検知する）
+var (
+	loginTotal = metrics.NewCounterVec("auth_login_total",
+		"Login attempts by method, outcome and failure reason.", "method", "outcome", "reason")
+	loginDuration = metrics.NewHistogramVec("auth_login_duration_seconds",
+		"Latency of password login requests.", nil, "outcome")
+	magicLinkIssuedTotal = metrics.NewCounterVec("auth_magic_link_issued_total",
+		"Login link requests by outcome.", "outcome")
+	magicLinkVerifiedTotal = metrics.NewCounterVec("auth_magic_link_verified_total",
+		"Login link verifications by outcome and failure reason.", "outcome", "reason")
+	sessionVerificationsTotal = metrics.NewCounterVec("auth_session_verifications_total",
+		"Session verifications by outcome and source (cache or dbpilot).", "outcome", "source")
+	sessionVerificationDuration = metrics.NewHistogramVec("auth_session_verification_duration_seconds",
+		"Latency of session verifications against DB Pilot.", nil, "outcome")
+)
+
+// countLogin はログインの成否を記録します（reason が空の場合は成功）
+func countLogin(method, reason string) {
+	loginTotal.Inc(method, metricOutcome(reason), reason)
+}
+
+// observeLogin はパスワードログインの成否とレイテンシを記録します
+func observeLogin(start time.Time, reason string) {
+	countLogin("password", reason)
+	loginDuration.ObserveSince(start, metricOutcome(reason))
+}
+
+func metricOutcome(reason string) string {
+	if reason == "" {
+		return auditOutcomeSuccess
+	}
+	return auditOutcomeFailure
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"auth/dbpilot"
+	"auth/logger"
+	"auth/utils"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	oidcStateCookie  = "oidc_state"
+	oidcNonceCookie  = "oidc_nonce"
+	oidcCookieMaxAge = 10 * 60
+)
+
+// OIDCLogin はIdPの認可エンドポイントへリダイレクトします
+func OIDCLogin(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "OIDCLogin"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	provider := utils.GetOIDCProvider()
+	if !provider.Config().Enabled() {
+		logger.Logger.Warn("OIDCが設定されていません", logFields...)
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not configured"})
+		return
+	}
+
+	state, err := utils.GenerateRandomString(32)
+	if err != nil {
+		logger.Logger.Error("stateの生成に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	nonce, err := utils.GenerateRandomString(32)
+	if err != nil {
+		logger.Logger.Error("nonceの生成に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce)
+	if err != nil {
+		logger.Logger.Error("認可URLの生成に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to contact identity provider"})
+		return
+	}
+
+	setOIDCCookie(c, oidcStateCookie, state, oidcCookieMaxAge)
+	setOIDCCookie(c, oidcNonceCookie, nonce, oidcCookieMaxAge)
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback は認可コードを検証し、ユーザーのプロビジョニングとセッション作成を行います
+func OIDCCallback(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "OIDCCallback"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	provider := utils.GetOIDCProvider()
+	if !provider.Config().Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not configured"})
+		return
+	}
+
+	if errParam := c.Query("error"); errParam != "" {
+		logger.Logger.Warn("IdPがエラーを返しました",
+			append(logFields, zap.String("idp_error", errParam))...)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login was cancelled or denied"})
+		return
+	}
+
+	// stateとnonceのクッキーは一度だけ使用する
+	expectedState, _ := c.Cookie(oidcStateCookie)
+	nonce, _ := c.Cookie(oidcNonceCookie)
+	setOIDCCookie(c, oidcStateCookie, "", -1)
+	setOIDCCookie(c, oidcNonceCookie, "", -1)
+
+	if expectedState == "" || c.Query("state") != expectedState {
+		logger.Logger.Warn("stateが一致しません", logFields...)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Authorization code is required"})
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(code)
+	if err != nil {
+		logger.Logger.Error("認可コードの交換に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to exchange authorization code"})
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(rawIDToken, nonce)
+	if err != nil {
+		logger.Logger.Warn("IDトークンの検証に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token"})
+		return
+	}
+
+	logFields = append(logFields, zap.String("email", claims.Email))
+
+	user, err := provisionUser(c.Request.Context(), claims.Email, claims.Name, claims.Picture, "oidc", claims.Subject)
+	if err != nil {
+		logger.Logger.Error("ユーザーのプロビジョニングに失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision user"})
+		return
+	}
+
+	if err := startSession(c, user.ID, user.Email); err != nil {
+		if errors.Is(err, errAccountLocked) {
+			recordAuditEvent(c, auditEventLogin, auditOutcomeFailure, user.ID, user.Email,
+				map[string]string{"method": "oidc", "reason": "account_locked"})
+			countLogin("oidc", "account_locked")
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is locked", "code": errCodeAccountLocked})
+			return
+		}
+		logger.Logger.Error("セッションの作成に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session"})
+		return
+	}
+
+	recordAuditEvent(c, auditEventLogin, auditOutcomeSuccess, user.ID, user.Email,
+		map[string]string{"method": "oidc"})
+	countLogin("oidc", "")
+
+	logger.Logger.Info("OIDCログインが完了しました",
+		append(logFields,
+			zap.Uint("user_id", user.ID),
+			zap.Bool("user_created", user.Created))...)
+
+	c.Redirect(http.StatusFound, os.Getenv("FRONTEND_URL"))
+}
+
+func setOIDCCookie(c *gin.Context, name, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// provisionUser はDB Pilotにユーザーの作成または紐付けを依頼します
+func provisionUser(ctx context.Context, email, name, imageURL, authProvider, subject string) (*dbpilot.ProvisionedUser, error) {
+	return dbPilotClient.ProvisionUser(ctx, dbpilot.ProvisionUserRequest{
+		Email:    email,
+		Name:     name,
+		ImageURL: imageURL,
+		Provider: authProvider,
+		Subject:  subject,
+	})
+}
+
+// startSession はDB Pilotにセッションを保存し、セッションクッキーを設定します
+func startSession(c *gin.Context, userID uint, email string) error {
+	sessionID := utils.GenerateSessionID()
+	expirationTime := time.Now().Add(sessionDuration)
+
+	err := dbPilotClient.CreateSession(c.Request.Context(), dbpilot.CreateSessionRequest{
+		UserID:    userID,
+		Email:     email,
+		SessionID: sessionID,
+		ExpiresAt: expirationTime,
+	})
+	if dbpilot.StatusCode(err) == http.StatusForbidden {
+		return errAccountLocked
+	}
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionID,
+		HttpOnly: true,
+		Path:     "/",
+		Expires:  expirationTime,
+	})
+
+	// リフレッシュトークンの発行に失敗してもログイン自体は成功とする
+	if err := issueRefreshToken(c, sessionID, userID, email); err != nil {
+		logger.Logger.Warn("リフレッシュトークンの発行に失敗しました",
+			zap.Uint("user_id", userID), zap.Error(err))
+	}
+	return nil
+}
@@ -2,12 +2,10 @@
 package handlers
 
 import (
+	"auth/dbpilot"
 	"auth/logger"
-	"bytes"
-	"encoding/json"
-	"io"
+	"auth/utils"
 	"net/http"
-	"os"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -17,13 +15,7 @@ import (
 type CreateAccountRequest struct {
 	Name     string `json:"name" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
-}
-
-type DBPilotAccountRequest struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"password" binding:"required"`
 }
 
 func CreateAccount(c *gin.Context) {
@@ -49,8 +41,23 @@ func CreateAccount(c *gin.Context) {
 		zap.String("email", req.Email),
 		zap.String("name", req.Name))
 
+	if !utils.IsEmailDomainAllowed(req.Email) {
+		logger.Logger.Warn("許可されていないドメインのメールアドレスです", logFields...)
+		recordAuditEvent(c, auditEventAccountCreate, auditOutcomeFailure, 0, req.Email,
+			map[string]string{"reason": utils.ErrCodeEmailDomainNotAllowed})
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Email domain is not allowed",
+			"code":  utils.ErrCodeEmailDomainNotAllowed,
+		})
+		return
+	}
+
 	logger.Logger.Info("アカウント作成を開始します", logFields...)
 
+	if !enforcePasswordPolicy(c, req.Password, logFields) {
+		return
+	}
+
 	// パスワードのハッシュ化
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -60,68 +67,28 @@ func CreateAccount(c *gin.Context) {
 		return
 	}
 
-	// DBPilotへのリクエスト準備
-	dbPilotReq := DBPilotAccountRequest{
+	// DBPilotへアカウントの作成を依頼（サービス認証はトランスポートで付与）
+	dbPilotResponse, err := dbPilotClient.CreateAccount(c.Request.Context(), dbpilot.CreateAccountRequest{
 		Name:     req.Name,
 		Email:    req.Email,
 		Password: string(hashedPassword),
-	}
-
-	jsonData, err := json.Marshal(dbPilotReq)
-	if err != nil {
-		logger.Logger.Error("リクエストのJSONエンコードに失敗しました",
-			append(logFields, zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare request"})
-		return
-	}
-
-	bearerToken := os.Getenv("SERVICE_TOKEN")
-	if bearerToken == "" {
-		logger.Logger.Error("Bearer tokenが設定されていません",
-			append(logFields, zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password"})
-		return
-	}
-	// DB Pilotへのリクエスト作成
-	dbPilotURL := os.Getenv("DB_PILOT_SERVICE_URL") + "/accounts"
-	request, err := http.NewRequest("POST", dbPilotURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		logger.Logger.Error("DBPilotへのリクエスト作成に失敗しました",
-			append(logFields,
-				zap.String("url", dbPilotURL),
-				zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
-		return
-	}
-
-	// ヘッダーの設定
-	token := "Bearer " + bearerToken
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Authorization", token)
-
-	// リクエストの送信
-	client := &http.Client{}
-	resp, err := client.Do(request)
+	})
 	if err != nil {
-		logger.Logger.Error("DBPilotへのリクエスト送信に失敗しました",
-			append(logFields,
-				zap.String("url", dbPilotURL),
-				zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send request"})
-		return
-	}
-	defer resp.Body.Close()
+		status := dbpilot.StatusCode(err)
+		if status == 0 {
+			logger.Logger.Error("DBPilotへのリクエスト送信に失敗しました",
+				append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send request"})
+			return
+		}
 
-	// レスポンスの確認
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
 		logger.Logger.Error("DBPilotからエラーレスポンスを受信しました",
 			append(logFields,
-				zap.Int("status_code", resp.StatusCode),
-				zap.String("response_body", string(body)))...)
+				zap.Int("status_code", status),
+				zap.Error(err))...)
 
 		// メールアドレスの重複エラーの場合は専用のエラーメッセージを返す
-		if resp.StatusCode == http.StatusConflict {
+		if status == http.StatusConflict {
 			c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
 			return
 		}
@@ -130,20 +97,38 @@ func CreateAccount(c *gin.Context) {
 		return
 	}
 
-	// DBPilotからのレスポンスをパース
-	var dbPilotResponse map[string]interface{}
-	if err := json.Unmarshal(body, &dbPilotResponse); err != nil {
-		logger.Logger.Error("レスポンスの解析に失敗しました",
-			append(logFields, zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process response"})
-		return
-	}
-
 	logger.Logger.Info("アカウント作成が完了しました",
 		append(logFields, zap.Any("response", dbPilotResponse))...)
+	recordAuditEvent(c, auditEventAccountCreate, auditOutcomeSuccess, 0, req.Email, nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Account created successfully",
 		"user":    dbPilotResponse,
 	})
 }
+
+// enforcePasswordPolicy はパスワードポリシーを検証し、違反がある場合は構造化したエラーを返します
+func enforcePasswordPolicy(c *gin.Context, password string, logFields []zap.Field) bool {
+	violations, err := utils.LoadPasswordPolicy().Validate(password)
+	if err != nil {
+		// 漏洩パスワードAPIの障害時は登録を妨げない
+		logger.Logger.Warn("漏洩パスワードの確認に失敗しました", append(logFields, zap.Error(err))...)
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	codes := make([]string, 0, len(violations))
+	for _, v := range violations {
+		codes = append(codes, v.Code)
+	}
+	logger.Logger.Info("パスワードポリシーを満たしていません",
+		append(logFields, zap.Strings("violations", codes))...)
+
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":      "Password does not meet the policy",
+		"code":       utils.ErrCodePasswordPolicyViolation,
+		"violations": violations,
+	})
+	return false
+}
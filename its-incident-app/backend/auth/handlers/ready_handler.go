@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"auth/logger"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 依存サービスの状態
+const (
+	dependencyUp            = "up"
+	dependencyDown          = "down"
+	dependencyNotConfigured = "not_configured"
+)
+
+// dependencyStatus は依存サービスごとのチェック結果
+type dependencyStatus struct {
+	Status    string `json:"status"`
+	Critical  bool   `json:"critical"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// readinessDependency はレディネスチェックの対象
+type readinessDependency struct {
+	name     string
+	baseURL  string
+	critical bool // false の場合は停止していても degraded として応答可能とみなす
+}
+
+var readinessClient = &http.Client{}
+
+// Readiness はDB PilotとNotificationの疎通を確認し、依存サービスごとの状態を返します。
+// DB Pilotが停止している場合は503、Notificationのみの停止は degraded として200を返します
+func Readiness(c *gin.Context) {
+	timeout := getEnvDuration("READINESS_TIMEOUT", 2*time.Second)
+
+	dependencies := []readinessDependency{
+		{name: "dbpilot", baseURL: os.Getenv("DB_PILOT_SERVICE_URL"), critical: true},
+		{name: "notify", baseURL: os.Getenv("NOTIFICATION_SERVICE_URL"), critical: false},
+	}
+
+	results := make(map[string]dependencyStatus, len(dependencies))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, dep := range dependencies {
+		wg.Add(1)
+		go func(dep readinessDependency) {
+			defer wg.Done()
+			status := checkDependency(c.Request.Context(), dep, timeout)
+			mu.Lock()
+			results[dep.name] = status
+			mu.Unlock()
+		}(dep)
+	}
+	wg.Wait()
+
+	overall := "ok"
+	httpStatus := http.StatusOK
+	for _, result := range results {
+		if result.Status == dependencyUp {
+			continue
+		}
+		if result.Critical {
+			overall = "unavailable"
+			httpStatus = http.StatusServiceUnavailable
+			break
+		}
+		overall = "degraded"
+	}
+
+	if overall != "ok" {
+		logger.Logger.Warn("依存サービスに異常があります",
+			zap.String("status", overall), zap.Any("dependencies", results))
+	}
+
+	c.JSON(httpStatus, gin.H{
+		"status":       overall,
+		"dependencies": results,
+	})
+}
+
+// checkDependency は依存サービスの /health にタイムアウト付きでリクエストします
+func checkDependency(ctx context.Context, dep readinessDependency, timeout time.Duration) dependencyStatus {
+	result := dependencyStatus{Critical: dep.critical}
+	if dep.baseURL == "" {
+		result.Status = dependencyNotConfigured
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(dep.baseURL, "/")+"/health", nil)
+	if err != nil {
+		result.Status = dependencyDown
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	resp, err := readinessClient.Do(req)
+	result.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Status = dependencyDown
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		result.Status = dependencyDown
+		result.Error = fmt.Sprintf("health check returned status %d", resp.StatusCode)
+		return result
+	}
+
+	result.Status = dependencyUp
+	return result
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"auth/dbpilot"
+	"auth/logger"
+	"auth/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	refreshTokenCookie     = "refresh_token"
+	defaultRefreshTokenTTL = 7 * 24 * time.Hour
+	sessionDuration        = 24 * time.Hour
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshAccessToken はリフレッシュトークンをローテーションし、セッションを延長して新しいアクセストークンを返します
+func RefreshAccessToken(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "RefreshAccessToken"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	refreshToken, fromCookie := refreshTokenFromRequest(c)
+	if refreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token is required"})
+		return
+	}
+
+	newRefreshToken, err := generateToken()
+	if err != nil {
+		logger.Logger.Error("トークン生成に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	refreshExpiresAt := time.Now().Add(refreshTokenTTL())
+	sessionExpiresAt := time.Now().Add(sessionDuration)
+
+	session, status, err := rotateRefreshToken(c.Request.Context(), refreshToken, newRefreshToken, refreshExpiresAt, sessionExpiresAt)
+	if err != nil {
+		if status == http.StatusUnauthorized {
+			logger.Logger.Warn("リフレッシュトークンが拒否されました",
+				append(logFields, zap.Error(err))...)
+			// 再利用検知時はDB Pilot側でセッションも失効しているためキャッシュを破棄する
+			if sessionID := sessionIDFromRequest(c); sessionID != "" {
+				verifiedSessions.invalidate(sessionID)
+			}
+			clearRefreshTokenCookie(c)
+			clearSessionCookie(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		logger.Logger.Error("リフレッシュトークンのローテーションに失敗しました",
+			append(logFields, zap.Int("status_code", status), zap.Error(err))...)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	accessToken, accessExpiresAt, err := utils.IssueAccessToken(session.UserID, session.Email, session.SessionID, session.ExpiresAt)
+	if err != nil {
+		logger.Logger.Error("アクセストークンの発行に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue access token"})
+		return
+	}
+
+	verifiedSessions.set(session.SessionID, session)
+	setRefreshTokenCookie(c, newRefreshToken, refreshExpiresAt)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.SessionID,
+		HttpOnly: true,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+	})
+
+	logger.Logger.Info("セッションを延長しました",
+		append(logFields,
+			zap.Uint("user_id", session.UserID),
+			zap.Time("session_expires_at", session.ExpiresAt))...)
+
+	response := gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(accessExpiresAt).Seconds()),
+	}
+	// クッキー以外で受け取ったクライアントにはボディで新しいトークンを返す
+	if !fromCookie {
+		response["refresh_token"] = newRefreshToken
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// issueRefreshToken はセッションに紐づく新しいトークン系列を発行し、クッキーに設定します
+func issueRefreshToken(c *gin.Context, sessionID string, userID uint, email string) error {
+	token, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+	expiresAt := time.Now().Add(refreshTokenTTL())
+
+	if err := dbPilotClient.CreateRefreshToken(c.Request.Context(), dbpilot.CreateRefreshTokenRequest{
+		TokenHash: hashRefreshToken(token),
+		FamilyID:  utils.GenerateSessionID(),
+		SessionID: sessionID,
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return err
+	}
+
+	setRefreshTokenCookie(c, token, expiresAt)
+	return nil
+}
+
+func rotateRefreshToken(ctx context.Context, token, newToken string, expiresAt, sessionExpiresAt time.Time) (*CurrentSession, int, error) {
+	session, err := dbPilotClient.RotateRefreshToken(ctx, dbpilot.RotateRefreshTokenRequest{
+		TokenHash:        hashRefreshToken(token),
+		NewTokenHash:     hashRefreshToken(newToken),
+		ExpiresAt:        expiresAt,
+		SessionExpiresAt: sessionExpiresAt,
+	})
+	if err != nil {
+		return nil, dbpilot.StatusCode(err), err
+	}
+	return &CurrentSession{
+		UserID:    session.UserID,
+		Email:     session.Email,
+		SessionID: session.SessionID,
+		ExpiresAt: session.ExpiresAt,
+	}, http.StatusOK, nil
+}
+
+func refreshTokenFromRequest(c *gin.Context) (string, bool) {
+	if cookie, err := c.Cookie(refreshTokenCookie); err == nil && cookie != "" {
+		return cookie, true
+	}
+
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err == nil {
+		return req.RefreshToken, false
+	}
+	return "", false
+}
+
+func setRefreshTokenCookie(c *gin.Context, token string, expiresAt time.Time) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    token,
+		HttpOnly: true,
+		Path:     "/",
+		Expires:  expiresAt,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearRefreshTokenCookie(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    "",
+		HttpOnly: true,
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+	})
+}
+
+func refreshTokenTTL() time.Duration {
+	if value := os.Getenv("REFRESH_TOKEN_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil && ttl > 0 {
+			return ttl
+		}
+	}
+	return defaultRefreshTokenTTL
+}
+
+// hashRefreshToken はDBに保存するためのトークンのハッシュを返します
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,13 +1,9 @@
 package handlers
 
 import (
+	"auth/dbpilot"
 	"auth/logger"
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -57,59 +53,20 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
-	// DB Pilot Serviceへのリクエスト準備
-	baseURL := os.Getenv("DB_PILOT_SERVICE_URL")
-	saveUserReq := map[string]string{
-		"email":    req.Email,
-		"password": string(hashedPassword),
-	}
-
-	saveUserReqJSON, err := json.Marshal(saveUserReq)
-	if err != nil {
-		logger.Logger.Error("リクエストのJSONエンコードに失敗しました",
-			append(logFields, zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare request"})
-		return
-	}
-
-	// HTTPリクエストの作成
-	request, err := http.NewRequest("POST", baseURL+"/users", bytes.NewBuffer(saveUserReqJSON))
-	if err != nil {
-		logger.Logger.Error("DBPilotへのリクエスト作成に失敗しました",
-			append(logFields,
-				zap.String("url", baseURL+"/users"),
-				zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
-		return
-	}
-
-	// ヘッダーの設定
-	request.Header.Set("Content-Type", "application/json")
+	// DB Pilot Serviceへユーザーを保存
+	var authorization string
 	if token != "" {
-		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		authorization = "Bearer " + token
 	}
-
-	// リクエストの実行
-	client := &http.Client{}
-	resp, err := client.Do(request)
+	err = dbPilotClient.SaveUser(c.Request.Context(), dbpilot.SaveUserRequest{
+		Email:    req.Email,
+		Password: string(hashedPassword),
+	}, authorization)
 	if err != nil {
-		logger.Logger.Error("DBPilotへのリクエスト送信に失敗しました",
+		logger.Logger.Error("DBPilotへのユーザー保存に失敗しました",
 			append(logFields,
-				zap.String("url", baseURL+"/users"),
+				zap.Int("status_code", dbpilot.StatusCode(err)),
 				zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send request to DB Pilot Service"})
-		return
-	}
-	defer resp.Body.Close()
-
-	// レスポンスの確認
-	if resp.StatusCode != http.StatusOK {
-		// レスポンスボディの読み取り
-		body, _ := io.ReadAll(resp.Body)
-		logger.Logger.Error("DBPilotからエラーレスポンスを受信しました",
-			append(logFields,
-				zap.Int("status_code", resp.StatusCode),
-				zap.String("response_body", string(body)))...)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save user to DB Pilot Service"})
 		return
 	}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"auth/logger"
+	"auth/utils"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const samlRequestCookie = "saml_request_id"
+
+// SAMLMetadata はIdPに登録するSPメタデータを返します
+func SAMLMetadata(c *gin.Context) {
+	sp, ok := samlServiceProvider(c, "SAMLMetadata")
+	if !ok {
+		return
+	}
+
+	metadata, err := sp.Metadata()
+	if err != nil {
+		logger.Logger.Error("SPメタデータの生成に失敗しました",
+			zap.String("handler", "SAMLMetadata"), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate metadata"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// SAMLLogin はAuthnRequestを付与してIdPへリダイレクトします（SP起点のログイン）
+func SAMLLogin(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "SAMLLogin"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	sp, ok := samlServiceProvider(c, "SAMLLogin")
+	if !ok {
+		return
+	}
+
+	redirectURL, requestID, err := sp.AuthnRequestURL("")
+	if err != nil {
+		logger.Logger.Error("AuthnRequestの生成に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	// IdPからのクロスサイトPOSTでも送信されるようSameSite=Noneで保存する
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     samlRequestCookie,
+		Value:    requestID,
+		Path:     "/",
+		MaxAge:   oidcCookieMaxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// SAMLAssertionConsumer はIdPからのSAMLResponseを検証し、ユーザーのプロビジョニングとセッション作成を行います
+func SAMLAssertionConsumer(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "SAMLAssertionConsumer"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	sp, ok := samlServiceProvider(c, "SAMLAssertionConsumer")
+	if !ok {
+		return
+	}
+
+	samlResponse := c.PostForm("SAMLResponse")
+	if samlResponse == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "SAMLResponse is required"})
+		return
+	}
+
+	// SP起点の場合はリクエストIDを照合する（IdP起点の場合はクッキーなし）
+	requestID, _ := c.Cookie(samlRequestCookie)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     samlRequestCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	user, err := sp.ParseResponse(samlResponse, requestID)
+	if err != nil {
+		logger.Logger.Warn("SAMLアサーションの検証に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid SAML response"})
+		return
+	}
+
+	logFields = append(logFields, zap.String("email", user.Email))
+
+	provisioned, err := provisionUser(c.Request.Context(), user.Email, user.Name, "", "saml", user.NameID)
+	if err != nil {
+		logger.Logger.Error("ユーザーのプロビジョニングに失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision user"})
+		return
+	}
+
+	if err := startSession(c, provisioned.ID, provisioned.Email); err != nil {
+		if errors.Is(err, errAccountLocked) {
+			recordAuditEvent(c, auditEventLogin, auditOutcomeFailure, provisioned.ID, provisioned.Email,
+				map[string]string{"method": "saml", "reason": "account_locked"})
+			countLogin("saml", "account_locked")
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is locked", "code": errCodeAccountLocked})
+			return
+		}
+		logger.Logger.Error("セッションの作成に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session"})
+		return
+	}
+
+	recordAuditEvent(c, auditEventLogin, auditOutcomeSuccess, provisioned.ID, provisioned.Email,
+		map[string]string{"method": "saml"})
+	countLogin("saml", "")
+
+	logger.Logger.Info("SAMLログインが完了しました",
+		append(logFields,
+			zap.Uint("user_id", provisioned.ID),
+			zap.Bool("user_created", provisioned.Created))...)
+
+	c.Redirect(http.StatusFound, os.Getenv("FRONTEND_URL"))
+}
+
+func samlServiceProvider(c *gin.Context, handler string) (*utils.SAMLServiceProvider, bool) {
+	sp, err := utils.GetSAMLServiceProvider()
+	if err != nil {
+		logger.Logger.Error("SAML設定の読み込みに失敗しました",
+			zap.String("handler", handler), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "SAML is misconfigured"})
+		return nil, false
+	}
+	if !sp.Config().Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SAML login is not configured"})
+		return nil, false
+	}
+	return sp, true
+}
@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"auth/dbpilot"
+	"auth/logger"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	scimUserSchema   = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimListSchema   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema  = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimContentType  = "application/scim+json"
+	scimDefaultCount = 100
+	scimMaxCount     = 500
+	scimUsersPath    = "/scim/v2/Users/"
+)
+
+// scimFilterPattern は IdP が利用する `attr eq "value"` 形式のフィルターのみを受け付けます
+var scimFilterPattern = regexp.MustCompile(`(?i)^\s*([a-z.]+)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+// SCIMUser はSCIM 2.0 の User リソース（RFC 7643 4.1）のうち本サービスで扱う属性
+type SCIMUser struct {
+	Schemas     []string      `json:"schemas"`
+	ID          string        `json:"id,omitempty"`
+	ExternalID  string        `json:"externalId,omitempty"`
+	UserName    string        `json:"userName"`
+	DisplayName string        `json:"displayName,omitempty"`
+	Name        *SCIMName     `json:"name,omitempty"`
+	Emails      []SCIMEmail   `json:"emails,omitempty"`
+	Active      *bool         `json:"active,omitempty"`
+	Meta        *SCIMUserMeta `json:"meta,omitempty"`
+}
+
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type SCIMUserMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+// SCIMPatchRequest は PATCH /Users/:id のリクエスト（RFC 7644 3.5.2）
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations" binding:"required"`
+}
+
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// SCIMListUsers はユーザーを検索するハンドラー（userName / externalId / emails.value の eq フィルターに対応）
+func SCIMListUsers(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "SCIMListUsers"),
+		zap.String("filter", c.Query("filter")),
+	}
+
+	startIndex, _ := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(scimDefaultCount)))
+	if err != nil || count < 0 {
+		count = scimDefaultCount
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+
+	filter := dbpilot.DirectoryUserFilter{Offset: startIndex - 1, Limit: count}
+	if raw := c.Query("filter"); raw != "" {
+		m := scimFilterPattern.FindStringSubmatch(raw)
+		if m == nil {
+			respondSCIMError(c, http.StatusBadRequest, "invalidFilter", "Unsupported filter expression")
+			return
+		}
+		value := strings.ReplaceAll(m[2], `\"`, `"`)
+		switch strings.ToLower(m[1]) {
+		case "username", "emails.value":
+			filter.Email = value
+		case "externalid":
+			filter.ExternalID = value
+		default:
+			respondSCIMError(c, http.StatusBadRequest, "invalidFilter", "Unsupported filter attribute: "+m[1])
+			return
+		}
+	}
+
+	resources := []SCIMUser{}
+	// count=0 は件数のみの問い合わせ
+	if count == 0 {
+		filter.Limit = 1
+	}
+	list, err := dbPilotClient.ListDirectoryUsers(c.Request.Context(), filter)
+	if err != nil {
+		logger.Logger.Error("SCIMユーザーの検索に失敗しました", append(logFields, zap.Error(err))...)
+		respondSCIMError(c, http.StatusBadGateway, "", "Failed to list users")
+		return
+	}
+	if count > 0 {
+		for _, user := range list.Items {
+			resources = append(resources, newSCIMUser(c, user))
+		}
+	}
+
+	c.Header("Content-Type", scimContentType)
+	c.JSON(http.StatusOK, gin.H{
+		"schemas":      []string{scimListSchema},
+		"totalResults": list.Total,
+		"startIndex":   startIndex,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	})
+}
+
+// SCIMGetUser はIDでユーザーを取得するハンドラー
+func SCIMGetUser(c *gin.Context) {
+	id, ok := scimUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := dbPilotClient.GetDirectoryUser(c.Request.Context(), id)
+	if err != nil {
+		respondSCIMClientError(c, "SCIMユーザーの取得に失敗しました", err, zap.Uint("user_id", id))
+		return
+	}
+
+	c.Header("Content-Type", scimContentType)
+	c.JSON(http.StatusOK, newSCIMUser(c, *user))
+}
+
+// SCIMCreateUser はIdPからのユーザーのプロビジョニングを処理するハンドラー
+func SCIMCreateUser(c *gin.Context) {
+	var req SCIMUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondSCIMError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	email := scimPrimaryEmail(req)
+	if email == "" {
+		respondSCIMError(c, http.StatusBadRequest, "invalidValue", "userName is required")
+		return
+	}
+
+	logFields := []zap.Field{
+		zap.String("handler", "SCIMCreateUser"),
+		zap.String("email", email),
+		zap.String("external_id", req.ExternalID),
+	}
+
+	user, err := dbPilotClient.CreateDirectoryUser(c.Request.Context(), dbpilot.CreateDirectoryUserRequest{
+		Email:      email,
+		Name:       scimDisplayName(req),
+		ExternalID: req.ExternalID,
+		Active:     req.Active,
+	})
+	if err != nil {
+		respondSCIMClientError(c, "SCIMユーザーの作成に失敗しました", err, logFields...)
+		return
+	}
+
+	recordAuditEvent(c, auditEventSCIMProvision, auditOutcomeSuccess, user.ID, user.Email,
+		map[string]string{"external_id": req.ExternalID})
+	logger.Logger.Info("IdPからユーザーをプロビジョニングしました",
+		append(logFields, zap.Uint("user_id", user.ID))...)
+
+	resource := newSCIMUser(c, *user)
+	c.Header("Location", resource.Meta.Location)
+	c.Header("Content-Type", scimContentType)
+	c.JSON(http.StatusCreated, resource)
+}
+
+// SCIMReplaceUser はユーザーの属性を置き換えるハンドラー（PUT）。
+// active を省略した場合は有効/無効を変更しません
+func SCIMReplaceUser(c *gin.Context) {
+	id, ok := scimUserID(c)
+	if !ok {
+		return
+	}
+
+	var req SCIMUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondSCIMError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	email := scimPrimaryEmail(req)
+	if email == "" {
+		respondSCIMError(c, http.StatusBadRequest, "invalidValue", "userName is required")
+		return
+	}
+	name := scimDisplayName(req)
+
+	applySCIMUpdate(c, id, dbpilot.UpdateDirectoryUserRequest{
+		Email:      &email,
+		Name:       &name,
+		ExternalID: &req.ExternalID,
+		Active:     req.Active,
+	})
+}
+
+// SCIMPatchUser は PatchOp による部分更新を処理するハンドラー。
+// IdP が送る active / userName / displayName / name.formatted / externalId の add・replace に対応します
+func SCIMPatchUser(c *gin.Context) {
+	id, ok := scimUserID(c)
+	if !ok {
+		return
+	}
+
+	var req SCIMPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondSCIMError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	var update dbpilot.UpdateDirectoryUserRequest
+	for _, op := range req.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+		default:
+			respondSCIMError(c, http.StatusBadRequest, "invalidValue", "Unsupported patch operation: "+op.Op)
+			return
+		}
+
+		// path 省略時は value が属性のオブジェクト
+		values := map[string]json.RawMessage{}
+		if op.Path == "" {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				respondSCIMError(c, http.StatusBadRequest, "invalidValue", "Patch value must be an object")
+				return
+			}
+		} else {
+			values[op.Path] = op.Value
+		}
+
+		for path, value := range values {
+			if err := applySCIMPatchValue(&update, path, value); err != nil {
+				respondSCIMError(c, http.StatusBadRequest, "invalidValue", err.Error())
+				return
+			}
+		}
+	}
+
+	applySCIMUpdate(c, id, update)
+}
+
+// SCIMDeleteUser はユーザーを無効化するハンドラー。監査のためユーザーは削除せず、
+// アカウントのロックと全セッションの失効で退職者のアクセスを止めます
+func SCIMDeleteUser(c *gin.Context) {
+	id, ok := scimUserID(c)
+	if !ok {
+		return
+	}
+
+	inactive := false
+	if _, ok := updateSCIMUser(c, id, dbpilot.UpdateDirectoryUserRequest{Active: &inactive}); !ok {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// applySCIMUpdate はDB Pilotのユーザーを更新し、更新後のリソースを返します
+func applySCIMUpdate(c *gin.Context, id uint, req dbpilot.UpdateDirectoryUserRequest) {
+	user, ok := updateSCIMUser(c, id, req)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", scimContentType)
+	c.JSON(http.StatusOK, newSCIMUser(c, *user))
+}
+
+// updateSCIMUser は更新を反映し、無効化で失効したセッションをキャッシュからも削除します
+func updateSCIMUser(c *gin.Context, id uint, req dbpilot.UpdateDirectoryUserRequest) (*dbpilot.DirectoryUser, bool) {
+	logFields := []zap.Field{
+		zap.String("handler", "SCIMUpdateUser"),
+		zap.String("method", c.Request.Method),
+		zap.Uint("user_id", id),
+	}
+
+	result, err := dbPilotClient.UpdateDirectoryUser(c.Request.Context(), id, req)
+	if err != nil {
+		respondSCIMClientError(c, "SCIMユーザーの更新に失敗しました", err, logFields...)
+		return nil, false
+	}
+
+	for _, sessionID := range result.SessionIDs {
+		verifiedSessions.invalidate(sessionID)
+	}
+
+	eventType := auditEventSCIMUpdate
+	if req.Active != nil && !*req.Active {
+		eventType = auditEventSCIMDeactivate
+		logger.Logger.Warn("IdPの指示によりユーザーを無効化しました",
+			append(logFields, zap.Int("revoked_sessions", len(result.SessionIDs)))...)
+	}
+	recordAuditEvent(c, eventType, auditOutcomeSuccess, result.User.ID, result.User.Email,
+		map[string]string{"revoked_sessions": strconv.Itoa(len(result.SessionIDs))})
+
+	return &result.User, true
+}
+
+// applySCIMPatchValue は PatchOp の1属性を更新内容に反映します
+func applySCIMPatchValue(update *dbpilot.UpdateDirectoryUserRequest, path string, value json.RawMessage) error {
+	switch strings.ToLower(path) {
+	case "active":
+		active, err := scimBool(value)
+		if err != nil {
+			return err
+		}
+		update.Active = &active
+	case "username", "emails[type eq \"work\"].value":
+		var email string
+		if err := json.Unmarshal(value, &email); err != nil {
+			return errors.New(path + " must be a string")
+		}
+		update.Email = &email
+	case "displayname", "name.formatted":
+		var name string
+		if err := json.Unmarshal(value, &name); err != nil {
+			return errors.New(path + " must be a string")
+		}
+		update.Name = &name
+	case "externalid":
+		var externalID string
+		if err := json.Unmarshal(value, &externalID); err != nil {
+			return errors.New(path + " must be a string")
+		}
+		update.ExternalID = &externalID
+	default:
+		// 未対応の属性（電話番号など）は無視する
+		logger.Logger.Debug("未対応のSCIM属性を無視しました", zap.String("path", path))
+	}
+	return nil
+}
+
+// scimBool は真偽値を解釈します（"True" のように文字列で送るIdPにも対応）。
+// null は false として扱わず、誤ってユーザーを無効化しないようエラーにします
+func scimBool(value json.RawMessage) (bool, error) {
+	var b *bool
+	if err := json.Unmarshal(value, &b); err == nil && b != nil {
+		return *b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if parsed, err := strconv.ParseBool(strings.ToLower(s)); err == nil {
+			return parsed, nil
+		}
+	}
+	return false, errors.New("active must be a boolean")
+}
+
+func scimUserID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		respondSCIMError(c, http.StatusNotFound, "", "User not found")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// scimPrimaryEmail は userName（なければ primary のメールアドレス）をメールアドレスとして扱います
+func scimPrimaryEmail(user SCIMUser) string {
+	if user.UserName != "" {
+		return user.UserName
+	}
+	for _, email := range user.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(user.Emails) > 0 {
+		return user.Emails[0].Value
+	}
+	return ""
+}
+
+func scimDisplayName(user SCIMUser) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	if user.Name == nil {
+		return ""
+	}
+	if user.Name.Formatted != "" {
+		return user.Name.Formatted
+	}
+	return strings.TrimSpace(user.Name.GivenName + " " + user.Name.FamilyName)
+}
+
+func newSCIMUser(c *gin.Context, user dbpilot.DirectoryUser) SCIMUser {
+	id := strconv.FormatUint(uint64(user.ID), 10)
+	active := user.Active
+	resource := SCIMUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          id,
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email,
+		DisplayName: user.Name,
+		Emails:      []SCIMEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &SCIMUserMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     scimBaseURL(c) + scimUsersPath + id,
+		},
+	}
+	if user.Name != "" {
+		resource.Name = &SCIMName{Formatted: user.Name}
+	}
+	return resource
+}
+
+// scimBaseURL は SCIM_BASE_URL（未設定時はリクエストのホスト）を返します
+func scimBaseURL(c *gin.Context) string {
+	if base := os.Getenv("SCIM_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	scheme := "https"
+	if c.Request.TLS == nil && c.GetHeader("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// respondSCIMClientError はDB Pilotのエラーを対応するSCIMのエラーに変換します
+func respondSCIMClientError(c *gin.Context, msg string, err error, logFields ...zap.Field) {
+	switch dbpilot.StatusCode(err) {
+	case http.StatusNotFound:
+		respondSCIMError(c, http.StatusNotFound, "", "User not found")
+	case http.StatusConflict:
+		respondSCIMError(c, http.StatusConflict, "uniqueness", "User already exists")
+	case http.StatusBadRequest, http.StatusForbidden:
+		var apiErr *dbpilot.APIError
+		detail := "Invalid user"
+		if errors.As(err, &apiErr) && apiErr.Message != "" {
+			detail = apiErr.Message
+		}
+		respondSCIMError(c, http.StatusBadRequest, "invalidValue", detail)
+	default:
+		logger.Logger.Error(msg, append(logFields, zap.Error(err))...)
+		respondSCIMError(c, http.StatusBadGateway, "", "Failed to reach user directory")
+	}
+}
+
+// respondSCIMError はSCIMのエラー形式（RFC 7644 3.12）でレスポンスを返します
+func respondSCIMError(c *gin.Context, status int, scimType, detail string) {
+	body := gin.H{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	c.Header("Content-Type", scimContentType)
+	c.AbortWithStatusJSON(status, body)
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/dbpilot"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeDirectory はユーザー更新を記録し、無効化で失効したセッションを返すDB Pilotの代わりです
+type fakeDirectory struct {
+	mu            sync.Mutex
+	updates       []dbpilot.UpdateDirectoryUserRequest
+	revokeOnClose []string
+}
+
+func newFakeDirectory(t *testing.T) *fakeDirectory {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	f := &fakeDirectory{}
+
+	server := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(server.Close)
+	SetDBPilotClient(dbpilot.NewClient(server.URL))
+	t.Cleanup(func() { SetDBPilotClient(dbpilot.NewClient("")) })
+	return f
+}
+
+func (f *fakeDirectory) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPatch || !strings.HasPrefix(r.URL.Path, "/directory/users/") {
+		// 監査ログの記録など
+		w.Write([]byte(`{}`))
+		return
+	}
+
+	var req dbpilot.UpdateDirectoryUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.updates = append(f.updates, req)
+	result := dbpilot.DirectoryUserUpdate{
+		User: dbpilot.DirectoryUser{ID: 7, Email: "user@example.com", Active: true},
+	}
+	if req.Active != nil && !*req.Active {
+		result.User.Active = false
+		result.SessionIDs = f.revokeOnClose
+	}
+	f.mu.Unlock()
+	json.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeDirectory) lastUpdate(t *testing.T) dbpilot.UpdateDirectoryUserRequest {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.updates) != 1 {
+		t.Fatalf("DB Pilot received %d updates, want 1", len(f.updates))
+	}
+	return f.updates[0]
+}
+
+func serveSCIM(method, path, body string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.PATCH("/scim/v2/Users/:id", SCIMPatchUser)
+	router.DELETE("/scim/v2/Users/:id", SCIMDeleteUser)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/scim+json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestScimBool(t *testing.T) {
+	tests := map[string]bool{
+		`true`:    true,
+		`false`:   false,
+		`"True"`:  true,
+		`"False"`: false,
+		`"true"`:  true,
+	}
+	for raw, want := range tests {
+		got, err := scimBool(json.RawMessage(raw))
+		if err != nil || got != want {
+			t.Errorf("scimBool(%s) = %v, %v, want %v", raw, got, err, want)
+		}
+	}
+
+	for _, raw := range []string{`"yes please"`, `1`, `null`, `{}`} {
+		if _, err := scimBool(json.RawMessage(raw)); err == nil {
+			t.Errorf("scimBool(%s) accepted a non-boolean", raw)
+		}
+	}
+}
+
+func TestSCIMPatchUserWithPath(t *testing.T) {
+	fake := newFakeDirectory(t)
+
+	body := `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "Replace", "path": "userName", "value": "new@example.com"},
+			{"op": "add", "path": "name.formatted", "value": "New Name"},
+			{"op": "replace", "path": "externalId", "value": "ext-1"},
+			{"op": "replace", "path": "phoneNumbers", "value": "000"}
+		]
+	}`
+	w := serveSCIM(http.MethodPatch, "/scim/v2/Users/7", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	update := fake.lastUpdate(t)
+	if update.Email == nil || *update.Email != "new@example.com" {
+		t.Errorf("email = %v, want new@example.com", update.Email)
+	}
+	if update.Name == nil || *update.Name != "New Name" {
+		t.Errorf("name = %v, want New Name", update.Name)
+	}
+	if update.ExternalID == nil || *update.ExternalID != "ext-1" {
+		t.Errorf("external id = %v, want ext-1", update.ExternalID)
+	}
+	if update.Active != nil {
+		t.Errorf("active = %v, want unchanged", *update.Active)
+	}
+}
+
+func TestSCIMPatchUserWithoutPath(t *testing.T) {
+	fake := newFakeDirectory(t)
+
+	// Entra ID は path を省略し、active を文字列で送る
+	body := `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "Replace", "value": {"active": "False", "displayName": "Leaver"}}
+		]
+	}`
+	w := serveSCIM(http.MethodPatch, "/scim/v2/Users/7", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	update := fake.lastUpdate(t)
+	if update.Active == nil || *update.Active {
+		t.Errorf("active = %v, want false", update.Active)
+	}
+	if update.Name == nil || *update.Name != "Leaver" {
+		t.Errorf("name = %v, want Leaver", update.Name)
+	}
+}
+
+func TestSCIMPatchUserRejectsInvalidOperations(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"remove operation", "/scim/v2/Users/7", `{"Operations": [{"op": "remove", "path": "active"}]}`},
+		{"non-boolean active", "/scim/v2/Users/7", `{"Operations": [{"op": "replace", "path": "active", "value": "maybe"}]}`},
+		{"non-string userName", "/scim/v2/Users/7", `{"Operations": [{"op": "replace", "path": "userName", "value": 1}]}`},
+		{"non-object value without path", "/scim/v2/Users/7", `{"Operations": [{"op": "replace", "value": "active"}]}`},
+		{"malformed request", "/scim/v2/Users/7", `{"Operations": `},
+		{"null active", "/scim/v2/Users/7", `{"Operations": [{"op": "replace", "path": "active", "value": null}]}`},
+	}
+	for _, tt := range tests {
+		fake := newFakeDirectory(t)
+		w := serveSCIM(http.MethodPatch, tt.path, tt.body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, http.StatusBadRequest)
+		}
+		if len(fake.updates) != 0 {
+			t.Errorf("%s: DB Pilot was updated: %+v", tt.name, fake.updates)
+		}
+	}
+}
+
+func TestSCIMDeleteUserRevokesSessions(t *testing.T) {
+	fake := newFakeDirectory(t)
+	fake.revokeOnClose = []string{"session-a", "session-b"}
+
+	expiresAt := time.Now().Add(time.Hour)
+	verifiedSessions.set("session-a", &CurrentSession{UserID: 7, SessionID: "session-a", ExpiresAt: expiresAt})
+	verifiedSessions.set("session-b", &CurrentSession{UserID: 7, SessionID: "session-b", ExpiresAt: expiresAt})
+	verifiedSessions.set("session-other", &CurrentSession{UserID: 8, SessionID: "session-other", ExpiresAt: expiresAt})
+	t.Cleanup(func() { verifiedSessions.invalidate("session-other") })
+
+	w := serveSCIM(http.MethodDelete, "/scim/v2/Users/7", "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+
+	// 削除はユーザーを残したまま無効化する
+	update := fake.lastUpdate(t)
+	if update.Active == nil || *update.Active {
+		t.Errorf("active = %v, want false", update.Active)
+	}
+
+	// 失効したセッションは検証結果のキャッシュからも削除する
+	for _, sessionID := range fake.revokeOnClose {
+		if _, ok := verifiedSessions.get(sessionID); ok {
+			t.Errorf("revoked session %s is still cached", sessionID)
+		}
+	}
+	if _, ok := verifiedSessions.get("session-other"); !ok {
+		t.Error("another user's session was removed from the cache")
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionCacheTTL = 30 * time.Second
+	maxSessionCacheEntries = 10000
+)
+
+type sessionCacheEntry struct {
+	session   CurrentSession
+	expiresAt time.Time
+}
+
+// sessionCache はDB Pilotでのセッション検証結果を短時間保持するインメモリキャッシュ
+type sessionCache struct {
+	mu      sync.RWMutex
+	entries map[string]sessionCacheEntry
+}
+
+var verifiedSessions = &sessionCache{entries: map[string]sessionCacheEntry{}}
+
+// get は有効期限内のキャッシュ済みセッションを返します
+func (sc *sessionCache) get(sessionID string) (*CurrentSession, bool) {
+	sc.mu.RLock()
+	entry, ok := sc.entries[sessionID]
+	sc.mu.RUnlock()
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	session := entry.session
+	return &session, true
+}
+
+// set は検証済みのセッションをキャッシュします。キャッシュ期間はセッションの有効期限を超えません
+func (sc *sessionCache) set(sessionID string, session *CurrentSession) {
+	ttl := sessionCacheTTL()
+	if ttl <= 0 {
+		return
+	}
+	expiresAt := time.Now().Add(ttl)
+	if session.ExpiresAt.Before(expiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if len(sc.entries) >= maxSessionCacheEntries {
+		sc.evictExpiredLocked()
+		if len(sc.entries) >= maxSessionCacheEntries {
+			sc.entries = map[string]sessionCacheEntry{}
+		}
+	}
+	sc.entries[sessionID] = sessionCacheEntry{session: *session, expiresAt: expiresAt}
+}
+
+// invalidate はログアウトなどで失効したセッションをキャッシュから削除します
+func (sc *sessionCache) invalidate(sessionID string) {
+	sc.mu.Lock()
+	delete(sc.entries, sessionID)
+	sc.mu.Unlock()
+}
+
+func (sc *sessionCache) evictExpiredLocked() {
+	now := time.Now()
+	for id, entry := range sc.entries {
+		if now.After(entry.expiresAt) {
+			delete(sc.entries, id)
+		}
+	}
+}
+
+// sessionCacheTTL は SESSION_CACHE_TTL（0でキャッシュ無効）を返します
+func sessionCacheTTL() time.Duration {
+	if value := os.Getenv("SESSION_CACHE_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil {
+			return ttl
+		}
+	}
+	return defaultSessionCacheTTL
+}
@@ -2,57 +2,81 @@
 package handlers
 
 import (
+	"auth/logger"
 	"fmt"
 	"net/http"
-	"os"
-	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
+// VerifySession はセッションの有効性を確認します。
+// 検証結果は SESSION_CACHE_TTL の間キャッシュし、DB Pilotへの問い合わせを減らします
 func VerifySession(c *gin.Context) {
-	tokenString := c.GetHeader("Authorization")
-	if tokenString == "" {
+	logFields := []zap.Field{
+		zap.String("handler", "VerifySession"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	sessionID := sessionIDFromRequest(c)
+	if sessionID == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is required"})
 		return
 	}
 
-	
-
-	authHeader := c.GetHeader("Authorization")
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	endpoint := os.Getenv("DB_PILOT_SERVICE_URL") + "/sessions"
-
-	_, err := SendDBpilot(token, endpoint)
+	session, status, err := verifySessionCached(sessionID)
 	if err != nil {
-		fmt.Printf("db pilot error: %V\n", err)
+		logger.Logger.Warn("セッションの確認に失敗しました",
+			append(logFields, zap.Int("status_code", status), zap.Error(err))...)
+		if status == http.StatusUnauthorized || status == http.StatusNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to verify session"})
+		return
 	}
 
+	// 下流のサービスがセッションを再確認せずに利用者を特定できるよう署名付きヘッダーを発行
+	setIdentityHeader(c, session)
 
 	// 有効なトークン
-	c.JSON(http.StatusOK, gin.H{"message": "Token is valid"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Token is valid",
+		"user_id":    session.UserID,
+		"email":      session.Email,
+		"expires_at": session.ExpiresAt,
+	})
 }
 
-func SendDBpilot(authHeader string, endpoint string) (string, error) {
-	
-
-	dbClient := &http.Client{}
-	dbRequest, err := http.NewRequest("GET", endpoint, nil)
-	if err != nil {
-		return "failed", fmt.Errorf(("failed to marshal DB pilot request: %v"), err)
+// verifySessionCached はキャッシュを優先してセッションを検証します。
+// 期限切れのセッションはエラー（401）として扱います
+func verifySessionCached(sessionID string) (*CurrentSession, int, error) {
+	if session, ok := verifiedSessions.get(sessionID); ok {
+		sessionVerificationsTotal.Inc("valid", "cache")
+		return session, http.StatusOK, nil
 	}
-	dbRequest.Header.Set("Content-Type", "application/json")
-	dbRequest.Header.Set("Authorization", "Bearer "+authHeader)
 
-	dbResp, err := dbClient.Do(dbRequest)
-	if err != nil {
-		return "failed", fmt.Errorf(("failed to marshal DB pilot request: %v"), err)
+	start := time.Now()
+	session, status, err := fetchCurrentSession(sessionID)
+	if err == nil && time.Now().After(session.ExpiresAt) {
+		session, status, err = nil, http.StatusUnauthorized, fmt.Errorf("session expired")
 	}
-	defer dbResp.Body.Close()
 
-	if dbResp.StatusCode != http.StatusOK {
-		return "failed", fmt.Errorf(("failed to marshal DB pilot request: %d"), dbResp.StatusCode)
+	outcome := "valid"
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusNotFound:
+		outcome = "invalid"
+	case err != nil:
+		outcome = "error"
+	}
+	sessionVerificationsTotal.Inc(outcome, "dbpilot")
+	sessionVerificationDuration.ObserveSince(start, outcome)
+	if err != nil {
+		return nil, status, err
 	}
 
-	return "success", nil
+	verifiedSessions.set(sessionID, session)
+	return session, status, nil
 }
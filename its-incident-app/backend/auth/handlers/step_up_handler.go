@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"auth/logger"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// errCodeStepUpRequired は再認証が必要な場合のエラーコード
+const errCodeStepUpRequired = "step_up_required"
+
+type ReauthRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// stepUpTTL は再認証後に機微な操作を許可する期間（STEP_UP_TTL、デフォルト5分）
+func stepUpTTL() time.Duration {
+	return getEnvDuration("STEP_UP_TTL", 5*time.Minute)
+}
+
+// Reauthenticate はパスワードを再確認し、セッションに短時間の昇格フラグを設定します
+func Reauthenticate(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "Reauthenticate"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	var req ReauthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	sessionID := sessionIDFromRequest(c)
+	if sessionID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is required"})
+		return
+	}
+
+	session, status, err := verifySessionCached(sessionID)
+	if err != nil {
+		logger.Logger.Warn("セッションの確認に失敗しました",
+			append(logFields, zap.Int("status_code", status), zap.Error(err))...)
+		if status == http.StatusUnauthorized || status == http.StatusNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to verify session"})
+		return
+	}
+	logFields = append(logFields, zap.Uint("user_id", session.UserID))
+
+	user, err := dbPilotClient.GetUserCredentials(c.Request.Context(), session.Email)
+	if err != nil {
+		logger.Logger.Error("ユーザー情報の取得に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	// SSOのみで登録されたユーザーはパスワードを持たない
+	if user.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Password re-authentication is not available for this account",
+			"code":  "password_not_set",
+		})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		recordAuditEvent(c, auditEventReauth, auditOutcomeFailure, session.UserID, session.Email,
+			map[string]string{"reason": "invalid_password"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	elevatedUntil, err := dbPilotClient.ElevateSession(c.Request.Context(), sessionID, time.Now().Add(stepUpTTL()))
+	if err != nil {
+		logger.Logger.Error("セッションの昇格に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to elevate session"})
+		return
+	}
+
+	// 昇格状態を反映させるため、検証結果のキャッシュを破棄する
+	verifiedSessions.invalidate(sessionID)
+
+	recordAuditEvent(c, auditEventReauth, auditOutcomeSuccess, session.UserID, session.Email,
+		map[string]string{"method": "password"})
+
+	logger.Logger.Info("再認証が完了しました", append(logFields, zap.Time("elevated_until", elevatedUntil))...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Re-authentication successful",
+		"elevated_until": elevatedUntil,
+	})
+}
+
+// RequireStepUp は直近に再認証済みのセッション以外のリクエストを拒否するミドルウェアです
+func RequireStepUp(c *gin.Context) {
+	sessionID := sessionIDFromRequest(c)
+	if sessionID == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session is required"})
+		return
+	}
+
+	session, status, err := verifySessionCached(sessionID)
+	if err != nil {
+		if status == http.StatusUnauthorized || status == http.StatusNotFound {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "Failed to verify session"})
+		return
+	}
+
+	if session.ElevatedUntil == nil || time.Now().After(*session.ElevatedUntil) {
+		logger.Logger.Info("再認証が必要な操作のため拒否しました",
+			zap.String("path", c.Request.URL.Path), zap.Uint("user_id", session.UserID))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "Re-authentication required",
+			"code":  errCodeStepUpRequired,
+		})
+		return
+	}
+
+	c.Next()
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"auth/dbpilot"
+	"auth/logger"
+	"auth/utils"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// CurrentSession はDB Pilotが返すセッション情報
+type CurrentSession struct {
+	UserID        uint       `json:"user_id"`
+	Email         string     `json:"email"`
+	SessionID     string     `json:"session_id"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	ElevatedUntil *time.Time `json:"elevated_until,omitempty"`
+}
+
+// JWKS はアクセストークン検証用の公開鍵セットを返します
+func JWKS(c *gin.Context) {
+	keys, err := utils.PublicJWKS()
+	if err != nil {
+		logger.Logger.Error("公開鍵の取得に失敗しました",
+			zap.String("handler", "JWKS"), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load signing key"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// IssueAccessToken は有効なセッションと引き換えに短命のアクセストークン(JWT)を発行します
+func IssueAccessToken(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "IssueAccessToken"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	sessionID := sessionIDFromRequest(c)
+	if sessionID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is required"})
+		return
+	}
+
+	session, status, err := verifySessionCached(sessionID)
+	if err != nil {
+		logger.Logger.Warn("セッションの確認に失敗しました",
+			append(logFields, zap.Int("status_code", status), zap.Error(err))...)
+		if status == http.StatusUnauthorized {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to verify session"})
+		return
+	}
+
+	token, expiresAt, err := utils.IssueAccessToken(session.UserID, session.Email, session.SessionID, session.ExpiresAt)
+	if err != nil {
+		logger.Logger.Error("アクセストークンの発行に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue access token"})
+		return
+	}
+
+	logger.Logger.Info("アクセストークンを発行しました",
+		append(logFields,
+			zap.Uint("user_id", session.UserID),
+			zap.Time("expires_at", expiresAt))...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(expiresAt).Seconds()),
+	})
+}
+
+// fetchCurrentSession はセッションIDでDB Pilotのセッション情報を取得します
+func fetchCurrentSession(sessionID string) (*CurrentSession, int, error) {
+	session, err := dbPilotClient.GetCurrentSession(context.Background(), sessionID)
+	if err != nil {
+		return nil, dbpilot.StatusCode(err), err
+	}
+	return &CurrentSession{
+		UserID:        session.UserID,
+		Email:         session.Email,
+		SessionID:     session.SessionID,
+		ExpiresAt:     session.ExpiresAt,
+		ElevatedUntil: session.ElevatedUntil,
+	}, http.StatusOK, nil
+}
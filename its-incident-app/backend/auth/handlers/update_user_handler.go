@@ -2,12 +2,14 @@
 package handlers
 
 import (
-	"bytes"
-	"encoding/json"
+	"auth/dbpilot"
+	"auth/logger"
+	"errors"
 	"net/http"
-	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -18,12 +20,6 @@ type UpdateUserRequest struct {
 	CurrentPassword string `json:"current_password"`   // 現在のパスワード（必須）
 }
 
-// DB Pilotへのリクエスト構造体
-type DBPilotUpdateRequest struct {
-	Name     string `json:"name,omitempty"`     // 更新する名前
-	Password string `json:"password,omitempty"` // ハッシュ化されたパスワード
-}
-
 func UpdateUser(c *gin.Context) {
 	var userReq UpdateUserRequest
 	if err := c.ShouldBindJSON(&userReq); err != nil {
@@ -42,6 +38,17 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
+	if userReq.NewPassword != "" {
+		logFields := []zap.Field{
+			zap.String("handler", "UpdateUser"),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+		}
+		if !enforcePasswordPolicy(c, userReq.NewPassword, logFields) {
+			return
+		}
+	}
+
 	// Auth ServiceからDB Pilotへのリクエストには、クライアントから受け取ったセッションIDをそのまま使用
 	authHeader := c.GetHeader("Authorization")
 	if authHeader == "" {
@@ -49,14 +56,8 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
-	baseURL := os.Getenv("DB_PILOT_SERVICE_URL")
-	if baseURL == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB Pilot Service URL is not configured"})
-		return
-	}
-
 	// DB Pilot Serviceへの更新リクエストを準備
-	updateReq := DBPilotUpdateRequest{}
+	updateReq := dbpilot.UpdateUserRequest{}
 
 	// パスワードの更新がある場合
 	if userReq.NewPassword != "" {
@@ -74,41 +75,31 @@ func UpdateUser(c *gin.Context) {
 		updateReq.Name = userReq.NewName
 	}
 
-	// DB Pilotへリクエストを送信
-	updateReqJSON, _ := json.Marshal(updateReq)
-	httpReq, err := http.NewRequest(http.MethodPost, baseURL+"/users-update", bytes.NewBuffer(updateReqJSON))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request to DB Pilot"})
-		return
-	}
-
-	// DB PilotへセッションIDを転送
-	httpReq.Header.Set("Authorization", authHeader)
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	// リクエストを実行
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to communicate with DB Pilot"})
-		return
-	}
-	defer resp.Body.Close()
-
-	// DB Pilotからのレスポンスを確認
-	if resp.StatusCode != http.StatusOK {
-		var errorResponse struct {
-			Error string `json:"error"`
+	// DB Pilotへリクエストを送信（セッションIDを転送）
+	if err := dbPilotClient.UpdateUser(c.Request.Context(), updateReq, authHeader); err != nil {
+		var apiErr *dbpilot.APIError
+		if !errors.As(err, &apiErr) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to communicate with DB Pilot"})
+			return
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&errorResponse); err != nil {
-			c.JSON(resp.StatusCode, gin.H{"error": "Update failed"})
+		if apiErr.Message == "" {
+			c.JSON(apiErr.StatusCode, gin.H{"error": "Update failed"})
 			return
 		}
-		c.JSON(resp.StatusCode, gin.H{"error": errorResponse.Error})
+		c.JSON(apiErr.StatusCode, gin.H{"error": apiErr.Message})
 		return
 	}
 
+	// パスワード変更後は他の端末のセッションをすべて失効させる
+	if userReq.NewPassword != "" {
+		sessionID := strings.TrimPrefix(authHeader, "Bearer ")
+		if _, err := revokeAllDBPilotSessions(c.Request.Context(), sessionID, true); err != nil {
+			logger.Logger.Warn("パスワード変更後のセッション失効に失敗しました",
+				zap.String("handler", "UpdateUser"), zap.Error(err))
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "User information updated successfully",
 	})
-}
\ No newline at end of file
+}
@@ -1,22 +1,16 @@
 package handlers
 
 import (
+	"auth/dbpilot"
 	"auth/logger"
-	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
 	"net/http"
-	"os"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
-type TokenVerificationResponse struct {
-	Email  string `json:"email"`
-	UserID uint   `json:"user_id,omitempty"`
-}
-
 func VerifyToken(c *gin.Context) {
 	logFields := []zap.Field{
 		zap.String("handler", "VerifyToken"),
@@ -36,54 +30,40 @@ func VerifyToken(c *gin.Context) {
 
 	logFields = append(logFields, zap.String("token", token))
 
-	// DBPilotに検証リクエストを送信
-	dbPilotURL := fmt.Sprintf("%s/login-tokens/verify?token=%s",
-		os.Getenv("DB_PILOT_SERVICE_URL"), token)
-
-	// DBPilotへのリクエスト作成
-	req, err := http.NewRequest("GET", dbPilotURL, nil)
-	if err != nil {
-		logger.Logger.Error("リクエストの作成に失敗しました",
-			append(logFields, zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create request",
-		})
-		return
-	}
-
-	// ヘッダーの設定
-	req.Header.Set("Content-Type", "application/json")
-
-	// リクエスト送信
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// DBPilotでトークンを検証
+	verificationResponse, err := dbPilotClient.VerifyLoginToken(c.Request.Context(), token)
 	if err != nil {
-		logger.Logger.Error("DB Pilotへのリクエスト送信に失敗しました",
-			append(logFields, zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to verify token",
-		})
-		return
-	}
-	defer resp.Body.Close()
-
-	// レスポンスボディの読み取り（エラーメッセージのために）
-	respBody, _ := io.ReadAll(resp.Body)
-	logFields = append(logFields, zap.String("response_body", string(respBody)))
+		status := dbpilot.StatusCode(err)
+		if status == 0 {
+			magicLinkVerifiedTotal.Inc(auditOutcomeFailure, "dbpilot_unavailable")
+			logger.Logger.Error("DB Pilotへのリクエスト送信に失敗しました",
+				append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to verify token",
+			})
+			return
+		}
 
-	// レスポンスのステータスコードチェック
-	if resp.StatusCode != http.StatusOK {
 		logger.Logger.Error("トークン検証に失敗しました",
 			append(logFields,
-				zap.Int("status_code", resp.StatusCode))...)
-
-		// DBPilotからのエラーメッセージを解析
-		var errorResponse struct {
-			Error string `json:"error"`
+				zap.Int("status_code", status),
+				zap.Error(err))...)
+		// DBPilotからのエラーメッセージとコード（使用済み・失効・期限切れ）を返す
+		var apiErr *dbpilot.APIError
+		isAPIErr := errors.As(err, &apiErr)
+		details := map[string]string{"status_code": strconv.Itoa(status)}
+		if isAPIErr && apiErr.Code != "" {
+			details["reason"] = apiErr.Code
+		} else {
+			details["reason"] = "invalid_token"
 		}
-		if err := json.Unmarshal(respBody, &errorResponse); err == nil {
+		magicLinkVerifiedTotal.Inc(auditOutcomeFailure, details["reason"])
+		recordAuditEvent(c, auditEventTokenVerify, auditOutcomeFailure, 0, "", details)
+
+		if isAPIErr && apiErr.Message != "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": errorResponse.Error,
+				"error": apiErr.Message,
+				"code":  apiErr.Code,
 			})
 		} else {
 			c.JSON(http.StatusUnauthorized, gin.H{
@@ -93,17 +73,6 @@ func VerifyToken(c *gin.Context) {
 		return
 	}
 
-	// レスポンスのデコード
-	var verificationResponse TokenVerificationResponse
-	if err := json.Unmarshal(respBody, &verificationResponse); err != nil {
-		logger.Logger.Error("レスポンスのデコードに失敗しました",
-			append(logFields, zap.Error(err))...)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to process response",
-		})
-		return
-	}
-
 	if verificationResponse.Email == "" {
 		logger.Logger.Error("メールアドレスが取得できませんでした", logFields...)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -112,6 +81,17 @@ func VerifyToken(c *gin.Context) {
 		return
 	}
 
+	// パスワードログインと同じ失敗回数の上限を適用する
+	if rejectIfLockedOut(c, verificationResponse.Email, logFields) {
+		magicLinkVerifiedTotal.Inc(auditOutcomeFailure, "locked_out")
+		return
+	}
+	resetLoginAttempts(verificationResponse.Email, logFields)
+
+	recordAuditEvent(c, auditEventTokenVerify, auditOutcomeSuccess,
+		verificationResponse.UserID, verificationResponse.Email, nil)
+	magicLinkVerifiedTotal.Inc(auditOutcomeSuccess, "")
+
 	logger.Logger.Info("トークンの検証が成功しました",
 		append(logFields, zap.String("email", verificationResponse.Email))...)
 
@@ -121,3 +101,40 @@ func VerifyToken(c *gin.Context) {
 		"user_id": verificationResponse.UserID,
 	})
 }
+
+// RevokeLoginToken は誤送信されたログインリンクを管理者が失効させます
+func RevokeLoginToken(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "RevokeLoginToken"),
+		zap.String("method", c.Request.Method),
+		zap.String("admin_email", c.GetString(adminEmailKey)),
+	}
+
+	result, err := dbPilotClient.RevokeLoginToken(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		if dbpilot.StatusCode(err) == http.StatusNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+		logger.Logger.Error("ログインリンクの失効に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	recordAuditEvent(c, auditEventTokenRevoke, auditOutcomeSuccess, 0, result.Email,
+		map[string]string{
+			"actor":            c.GetString(adminEmailKey),
+			"already_consumed": strconv.FormatBool(result.AlreadyConsumed),
+		})
+
+	logger.Logger.Warn("ログインリンクを失効させました",
+		append(logFields,
+			zap.String("email", result.Email),
+			zap.Bool("already_consumed", result.AlreadyConsumed))...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Login token revoked successfully",
+		"email":            result.Email,
+		"already_consumed": result.AlreadyConsumed,
+	})
+}
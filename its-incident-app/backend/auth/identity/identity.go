@@ -0,0 +1,99 @@
+// Package identity は認証サービスが署名する利用者情報ヘッダー（X-Authenticated-User）を扱います。
+// 下流のサービスはヘッダーの署名を検証することで、セッションを問い合わせずに利用者を特定できます。
+// 形式は base64url(JSONペイロード) + "." + base64url(HMAC-SHA256) で、鍵は IDENTITY_HEADER_SECRET です
+package identity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"auth/secrets"
+)
+
+// Header は署名付きの利用者情報を格納するヘッダー名
+const Header = "X-Authenticated-User"
+
+// clockSkew は発行元との時刻のずれとして許容する時間
+const clockSkew = 30 * time.Second
+
+var (
+	ErrNotConfigured = errors.New("IDENTITY_HEADER_SECRET is not configured")
+	ErrMalformed     = errors.New("identity header is malformed")
+	ErrSignature     = errors.New("identity header signature is invalid")
+	ErrExpired       = errors.New("identity header has expired")
+)
+
+// Identity はセッション検証済みの利用者情報
+type Identity struct {
+	UserID    uint   `json:"uid"`
+	Email     string `json:"email"`
+	SessionID string `json:"sid"`
+	ExpiresAt int64  `json:"exp"` // UNIX秒
+}
+
+// Enabled は署名鍵が設定されているかを返します
+func Enabled() bool {
+	return secrets.Get("IDENTITY_HEADER_SECRET") != ""
+}
+
+// Sign は ttl の間有効な署名付きヘッダー値を生成します
+func Sign(id Identity, ttl time.Duration) (string, error) {
+	key := secrets.Get("IDENTITY_HEADER_SECRET")
+	if key == "" {
+		return "", ErrNotConfigured
+	}
+
+	id.ExpiresAt = time.Now().Add(ttl).Unix()
+	payload, err := json.Marshal(id)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sign(key, encoded)), nil
+}
+
+// Verify はヘッダー値の署名と有効期限を検証し、利用者情報を返します
+func Verify(value string) (*Identity, error) {
+	key := secrets.Get("IDENTITY_HEADER_SECRET")
+	if key == "" {
+		return nil, ErrNotConfigured
+	}
+
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok || encoded == "" || signature == "" {
+		return nil, ErrMalformed
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if !hmac.Equal(mac, sign(key, encoded)) {
+		return nil, ErrSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var id Identity
+	if err := json.Unmarshal(payload, &id); err != nil || id.SessionID == "" {
+		return nil, ErrMalformed
+	}
+	if time.Now().Add(-clockSkew).Unix() > id.ExpiresAt {
+		return nil, ErrExpired
+	}
+	return &id, nil
+}
+
+func sign(key, encoded string) []byte {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
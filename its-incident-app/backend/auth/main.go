@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"net/http"
 	"os"
 	"os/signal"
@@ -9,9 +10,15 @@ import (
 	"time"
 
 	"auth/config"
+	"auth/dbpilot"
 	"auth/handlers"
 	"auth/logger"
+	"auth/metrics"
 	"auth/middleware"
+	"auth/mtls"
+	"auth/serviceauth"
+	"auth/utils"
+	"shared/idtoken"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -24,7 +31,46 @@ func main() {
 		logger.Logger.Fatal("設定の初期化に失敗しました", zap.Error(err))
 	}
 
+	// 内部サービス呼び出しにクライアント証明書を付与（相互TLSが有効な場合のみ）
+	if err := mtls.InstallTransport(); err != nil {
+		logger.Logger.Fatal("mTLSクライアントの設定に失敗しました", zap.Error(err))
+	}
+
+	// IDトークンを受け付ける場合は呼び出し元のサービスアカウントを限定する（未設定の場合はすべてのIDトークンを拒否）
+	if err := idtoken.CheckConfig(); err != nil {
+		if cfg.Environment == "production" {
+			logger.Logger.Fatal("サービス認証の設定が不正です", zap.Error(err))
+		}
+		logger.Logger.Warn("サービス認証の設定が不正なため、IDトークンをすべて拒否します", zap.Error(err))
+	}
+
+	// アクセストークンの署名鍵を読み込む（本番環境では JWT_SIGNING_KEY が必須）
+	if err := utils.InitTokenSigner(cfg.Environment); err != nil {
+		logger.Logger.Fatal("アクセストークンの署名鍵の読み込みに失敗しました", zap.Error(err))
+	}
+
+	// 内部サービス宛てのリクエストにサービス認証を付与
+	serviceauth.InstallTransport(cfg.DBPilotURL, cfg.NotificationURL)
+
+	// ハンドラーが使用するDB Pilotクライアントを設定
+	handlers.SetDBPilotClient(dbpilot.NewClient(cfg.DBPilotURL,
+		dbpilot.WithTimeout(cfg.DBPilotTimeout),
+		dbpilot.WithRetry(cfg.DBPilotRetries, cfg.DBPilotBackoff)))
+	handlers.SetLoginAttemptPolicy(cfg.MaxLoginAttempts, cfg.AccountLockDurationMins)
+	handlers.SetMagicLinkTTL(cfg.MagicLinkTTL)
+
 	// ルーターの設定
+	r := setupRouter(cfg)
+
+	// サーバーの設定と起動
+	srv := config.SetupServer(r)
+
+	// グレースフルシャットダウンの実装
+	handleGracefulShutdown(srv, cfg.ShutdownTimeout)
+}
+
+// setupRouter はミドルウェアとルーティングを設定したルーターを返します
+func setupRouter(cfg *config.ServerConfig) *gin.Engine {
 	r := gin.New()
 	r.Use(gin.Logger())
 
@@ -38,23 +84,55 @@ func main() {
 	middleware.SetupMiddleware(r, middlewareConfig)
 
 	// 認証をスキップするパスを設定
-	r.Use(middleware.SkipAuthMiddleware("/login", "/health", "/verify-token", "/accounts"))
+	r.Use(middleware.SkipAuthMiddleware("/login", "/logout", "/logout-all", "/health", "/ready", "/verify-token", "/accounts", "/oidc/login", "/oidc/callback",
+		"/saml/metadata", "/saml/login", "/saml/acs", "/.well-known/jwks.json", "/token", "/token/refresh", "/audit", "/accounts/*", "/reauth",
+		"/session/resume", "/devices", "/devices/*", "/login-tokens/*", "/scim/v2/*"))
 
 	// ハンドラーの設定
 	r.POST("/register", handlers.RegisterUser)
-	r.POST("/login", handlers.LoginUser)
-	r.POST("/update-user", handlers.UpdateUser)
-	r.POST("/add-account", handlers.AddAccountUser)
-	r.POST("/accounts", handlers.CreateAccount)
+	r.POST("/login", middleware.LoginThrottle(), middleware.CaptchaVerification(), handlers.LoginUser)
+	r.POST("/logout", handlers.LogoutUser)
+	r.POST("/logout-all", handlers.LogoutAllDevices)
+	r.POST("/accounts", middleware.CaptchaVerification(), handlers.CreateAccount)
 	r.GET("/verify-session", handlers.VerifySession)
 	r.GET("/health", handleHealthCheck)
-	r.GET("/verify-token", handlers.VerifyToken)
+	r.GET("/ready", handlers.Readiness)
+	r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	r.GET("/verify-token", middleware.LoginThrottle(), handlers.VerifyToken)
+	r.GET("/oidc/login", handlers.OIDCLogin)
+	r.GET("/oidc/callback", handlers.OIDCCallback)
+	r.GET("/saml/metadata", handlers.SAMLMetadata)
+	r.GET("/saml/login", handlers.SAMLLogin)
+	r.POST("/saml/acs", handlers.SAMLAssertionConsumer)
+	r.GET("/.well-known/jwks.json", handlers.JWKS)
+	r.POST("/token", handlers.IssueAccessToken)
+	r.POST("/token/refresh", handlers.RefreshAccessToken)
+	r.GET("/audit", handlers.GetAuditLogs)
+	r.POST("/reauth", middleware.LoginThrottle(), handlers.Reauthenticate)
+	r.POST("/session/resume", middleware.LoginThrottle(), handlers.ResumeSession)
+	r.GET("/devices", handlers.ListDevices)
+
+	// IdPからのユーザープロビジョニング（SCIM 2.0）
+	scim := r.Group("/scim/v2", middleware.SCIMAuth())
+	{
+		scim.GET("/Users", handlers.SCIMListUsers)
+		scim.POST("/Users", handlers.SCIMCreateUser)
+		scim.GET("/Users/:id", handlers.SCIMGetUser)
+		scim.PUT("/Users/:id", handlers.SCIMReplaceUser)
+		scim.PATCH("/Users/:id", handlers.SCIMPatchUser)
+		scim.DELETE("/Users/:id", handlers.SCIMDeleteUser)
+	}
 
-	// サーバーの設定と起動
-	srv := config.SetupServer(r)
+	// 機微な操作はステップアップ認証を必須とする
+	r.POST("/accounts/:id/lock", handlers.RequireAdmin, handlers.RequireStepUp, handlers.LockAccount)
+	r.POST("/accounts/:id/unlock", handlers.RequireAdmin, handlers.RequireStepUp, handlers.UnlockAccount)
+	r.POST("/update-user", handlers.RequireStepUp, handlers.UpdateUser)
+	r.POST("/add-account", handlers.RequireStepUp, handlers.AddAccountUser)
+	r.DELETE("/login-tokens/:token", handlers.RequireAdmin, handlers.RequireStepUp, handlers.RevokeLoginToken)
+	r.DELETE("/devices/:id", handlers.RequireStepUp, handlers.RevokeDevice)
 
-	// グレースフルシャットダウンの実装
-	handleGracefulShutdown(srv, cfg.ShutdownTimeout)
+	return r
 }
 
 // handleHealthCheck はヘルスチェックエンドポイントを処理します
@@ -65,7 +143,7 @@ func handleHealthCheck(c *gin.Context) {
 func handleGracefulShutdown(srv *http.Server, timeout time.Duration) {
 	// サーバーを別のゴルーチンで起動
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := mtls.ListenAndServe(srv); err != nil && err != http.ErrServerClosed {
 			logger.Logger.Fatal("サーバーの起動に失敗しました", zap.Error(err))
 		}
 	}()
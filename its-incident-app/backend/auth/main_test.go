@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/config"
+	"auth/dbpilot"
+	"auth/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testAdminEmail   = "admin@example.com"
+	testServiceToken = "test-service-token"
+)
+
+// fakeDBPilot はセッション確認にだけ応答し、それ以外に届いたリクエストを記録するDB Pilotの代わりです
+type fakeDBPilot struct {
+	mu       sync.Mutex
+	elevated map[string]bool
+	calls    []string
+}
+
+func newFakeDBPilot(t *testing.T) *fakeDBPilot {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	f := &fakeDBPilot{elevated: map[string]bool{}}
+
+	server := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(server.Close)
+	t.Setenv("DB_PILOT_SERVICE_URL", server.URL)
+	t.Setenv("ADMIN_EMAILS", testAdminEmail)
+	t.Setenv("SERVICE_TOKEN", testServiceToken)
+	handlers.SetDBPilotClient(dbpilot.NewClient(server.URL))
+	t.Cleanup(func() { handlers.SetDBPilotClient(dbpilot.NewClient("")) })
+	return f
+}
+
+func (f *fakeDBPilot) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet && r.URL.Path == "/sessions/current" {
+		sessionID := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		session := map[string]interface{}{
+			"user_id":    1,
+			"email":      testAdminEmail,
+			"session_id": sessionID,
+			"expires_at": time.Now().Add(time.Hour),
+		}
+		f.mu.Lock()
+		if f.elevated[sessionID] {
+			session["elevated_until"] = time.Now().Add(time.Minute)
+		}
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(session)
+		return
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+	f.mu.Unlock()
+	w.Write([]byte(`{}`))
+}
+
+func (f *fakeDBPilot) elevate(sessionID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.elevated[sessionID] = true
+}
+
+func (f *fakeDBPilot) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func TestSensitiveRoutesRequireStepUp(t *testing.T) {
+	fake := newFakeDBPilot(t)
+	router := setupRouter(&config.ServerConfig{Environment: "test"})
+
+	routes := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/accounts/2/lock", ""},
+		{http.MethodPost, "/accounts/2/unlock", ""},
+		{http.MethodPost, "/update-user", `{"name":"new name","current_password":"password"}`},
+		{http.MethodPost, "/add-account", `{"email":"new@example.com"}`},
+		{http.MethodDelete, "/login-tokens/0123456789abcdef", ""},
+		{http.MethodDelete, "/devices/3", ""},
+	}
+
+	for _, route := range routes {
+		sessionID := "session-" + strings.ReplaceAll(route.path, "/", "-")
+		req := httptest.NewRequest(route.method, route.path, strings.NewReader(route.body))
+		req.Header.Set("Content-Type", "application/json")
+		// フロントエンドのサーバーからの呼び出しと同様に、サービストークンとセッションクッキーを付与する
+		req.Header.Set("Authorization", "Bearer "+testServiceToken)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status = %d, want %d", route.method, route.path, w.Code, http.StatusForbidden)
+			continue
+		}
+		var body struct {
+			Code string `json:"code"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if body.Code != "step_up_required" {
+			t.Errorf("%s %s: code = %q, want step_up_required", route.method, route.path, body.Code)
+		}
+		if calls := fake.takeCalls(); len(calls) != 0 {
+			t.Errorf("%s %s: DB Pilot was called without step-up: %v", route.method, route.path, calls)
+		}
+	}
+}
+
+func TestStepUpAllowsRecentlyReauthenticatedSession(t *testing.T) {
+	fake := newFakeDBPilot(t)
+	router := setupRouter(&config.ServerConfig{Environment: "test"})
+	fake.elevate("elevated-session")
+
+	req := httptest.NewRequest(http.MethodDelete, "/devices/3", nil)
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: "elevated-session"})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	calls := fake.takeCalls()
+	if len(calls) != 1 || calls[0] != "DELETE /device-tokens/3" {
+		t.Errorf("DB Pilot calls = %v, want [DELETE /device-tokens/3]", calls)
+	}
+}
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth/logger"
+	"auth/secrets"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	captchaHeader            = "X-Captcha-Token"
+	recaptchaVerifyURL       = "https://www.google.com/recaptcha/api/siteverify"
+	turnstileVerifyURL       = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+	defaultRecaptchaMinScore = 0.5
+)
+
+// captchaVerifyResponse はreCAPTCHA/Turnstileの検証APIのレスポンス（共通部分）
+type captchaVerifyResponse struct {
+	Success    bool     `json:"success"`
+	Score      *float64 `json:"score"` // reCAPTCHA v3 のみ
+	Action     string   `json:"action"`
+	Hostname   string   `json:"hostname"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+var captchaClient = &http.Client{Timeout: 5 * time.Second}
+
+// CaptchaVerification は X-Captcha-Token ヘッダーのreCAPTCHA/Turnstileトークンを検証するミドルウェア。
+// CAPTCHA_PROVIDER（recaptcha または turnstile）が未設定の環境では何もしません
+func CaptchaVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider := strings.ToLower(os.Getenv("CAPTCHA_PROVIDER"))
+		if provider == "" {
+			c.Next()
+			return
+		}
+
+		logFields := []zap.Field{
+			zap.String("provider", provider),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("client_ip", c.ClientIP()),
+		}
+
+		token := c.GetHeader(captchaHeader)
+		if token == "" {
+			logger.Logger.Warn("CAPTCHAトークンがありません", logFields...)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "captcha token is required",
+				"code":  "captcha_required",
+			})
+			return
+		}
+
+		result, err := verifyCaptcha(provider, token, c.ClientIP())
+		if err != nil {
+			// 検証できない場合は不正利用を防ぐため拒否する
+			logger.Logger.Error("CAPTCHAの検証に失敗しました", append(logFields, zap.Error(err))...)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error": "captcha verification is unavailable",
+				"code":  "captcha_unavailable",
+			})
+			return
+		}
+
+		if reason := rejectCaptcha(provider, result); reason != "" {
+			logger.Logger.Warn("CAPTCHAの検証で拒否しました",
+				append(logFields,
+					zap.String("reason", reason),
+					zap.Strings("error_codes", result.ErrorCodes))...)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "captcha verification failed",
+				"code":  "captcha_failed",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// verifyCaptcha はプロバイダーの検証APIにトークンを送信します
+func verifyCaptcha(provider, token, remoteIP string) (*captchaVerifyResponse, error) {
+	var verifyURL string
+	switch provider {
+	case "recaptcha":
+		verifyURL = recaptchaVerifyURL
+	case "turnstile":
+		verifyURL = turnstileVerifyURL
+	default:
+		return nil, fmt.Errorf("unsupported captcha provider: %s", provider)
+	}
+
+	secret := secrets.Get("CAPTCHA_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("CAPTCHA_SECRET is not configured")
+	}
+
+	resp, err := captchaClient.PostForm(verifyURL, url.Values{
+		"secret":   {secret},
+		"response": {token},
+		"remoteip": {remoteIP},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send verification request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("verification API returned status %d", resp.StatusCode)
+	}
+
+	var result captchaVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode verification response: %v", err)
+	}
+	return &result, nil
+}
+
+// rejectCaptcha は検証結果を拒否する理由を返します（許可する場合は空文字）
+func rejectCaptcha(provider string, result *captchaVerifyResponse) string {
+	if !result.Success {
+		return "not_success"
+	}
+
+	if hostname := os.Getenv("CAPTCHA_EXPECTED_HOSTNAME"); hostname != "" && result.Hostname != hostname {
+		return "hostname_mismatch"
+	}
+
+	// reCAPTCHA v3 はスコアが低いリクエストを拒否する
+	if provider == "recaptcha" && result.Score != nil {
+		minScore := defaultRecaptchaMinScore
+		if value := os.Getenv("CAPTCHA_MIN_SCORE"); value != "" {
+			if score, err := strconv.ParseFloat(value, 64); err == nil {
+				minScore = score
+			}
+		}
+		if *result.Score < minScore {
+			return "low_score"
+		}
+	}
+	return ""
+}
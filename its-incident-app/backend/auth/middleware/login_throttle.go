@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"auth/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLoginThrottleThreshold   = 5
+	defaultLoginThrottleBasePenalty = time.Second
+	defaultLoginThrottleMaxPenalty  = time.Hour
+	defaultLoginThrottleWindow      = 15 * time.Minute
+	loginThrottleMaxEntries         = 100000
+)
+
+type ipFailureState struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// loginThrottle はクライアントIP単位で認証失敗を数え、しきい値を超えると指数的に長くなる待機時間を課します。
+// アカウント単位のロックとは独立して動作します
+type loginThrottle struct {
+	mu          sync.Mutex
+	states      map[string]*ipFailureState
+	threshold   int
+	basePenalty time.Duration
+	maxPenalty  time.Duration
+	window      time.Duration
+}
+
+// AuthEmailKey はハンドラーが認証を試みたメールアドレスをコンテキストに保存するキー
+const AuthEmailKey = "auth_email"
+
+var failureReportClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	sharedLoginThrottle     *loginThrottle
+	sharedLoginThrottleOnce sync.Once
+)
+
+// LoginThrottle は /login や /verify-token に適用するIP単位のスロットリングミドルウェア
+func LoginThrottle() gin.HandlerFunc {
+	sharedLoginThrottleOnce.Do(func() {
+		sharedLoginThrottle = &loginThrottle{
+			states:      map[string]*ipFailureState{},
+			threshold:   getEnvInt("LOGIN_THROTTLE_THRESHOLD", defaultLoginThrottleThreshold),
+			basePenalty: getEnvDuration("LOGIN_THROTTLE_BASE_PENALTY", defaultLoginThrottleBasePenalty),
+			maxPenalty:  getEnvDuration("LOGIN_THROTTLE_MAX_PENALTY", defaultLoginThrottleMaxPenalty),
+			window:      getEnvDuration("LOGIN_THROTTLE_WINDOW", defaultLoginThrottleWindow),
+		}
+	})
+	throttle := sharedLoginThrottle
+
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		if wait := throttle.blockedFor(ip); wait > 0 {
+			logger.Logger.Warn("IPスロットリングによりリクエストを拒否しました",
+				zap.String("client_ip", ip),
+				zap.String("path", c.Request.URL.Path),
+				zap.Duration("retry_after", wait))
+			c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			abortWithError(c, http.StatusTooManyRequests, "too many failed attempts")
+			return
+		}
+
+		c.Next()
+
+		switch status := c.Writer.Status(); {
+		case status == http.StatusUnauthorized:
+			reportAuthFailure(ip, c.GetString(AuthEmailKey), c.Request.URL.Path)
+			if penalty := throttle.recordFailure(ip); penalty > 0 {
+				logger.Logger.Warn("認証失敗が続いたためIPにペナルティを課しました",
+					zap.String("client_ip", ip),
+					zap.String("path", c.Request.URL.Path),
+					zap.Duration("penalty", penalty))
+			}
+		case status >= 200 && status < 300:
+			throttle.reset(ip)
+		}
+	}
+}
+
+// reportAuthFailure はブルートフォース検知のためDB Pilotへ認証失敗を非同期で送信します
+func reportAuthFailure(ip, email, path string) {
+	endpoint := os.Getenv("DB_PILOT_SERVICE_URL") + "/auth-failures"
+	payload, err := json.Marshal(map[string]string{
+		"source":    "auth",
+		"reason":    "unauthorized",
+		"client_ip": ip,
+		"email":     email,
+		"path":      path,
+	})
+	if err != nil {
+		return
+	}
+
+	go func() {
+		resp, err := failureReportClient.Post(endpoint, "application/json", bytes.NewBuffer(payload))
+		if err != nil {
+			logger.Logger.Warn("認証失敗の送信に失敗しました", zap.Error(err))
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			logger.Logger.Warn("認証失敗の送信でエラーレスポンスを受信しました",
+				zap.Int("status_code", resp.StatusCode))
+		}
+	}()
+}
+
+// blockedFor はIPが待機中であれば残り時間を返します
+func (t *loginThrottle) blockedFor(ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.states[ip]
+	if !ok {
+		return 0
+	}
+	return time.Until(state.blockedUntil)
+}
+
+// recordFailure は失敗を記録し、課したペナルティ時間を返します
+func (t *loginThrottle) recordFailure(ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	state, ok := t.states[ip]
+	if !ok || now.Sub(state.lastFailure) > t.window {
+		if len(t.states) >= loginThrottleMaxEntries {
+			t.sweep(now)
+		}
+		state = &ipFailureState{}
+		t.states[ip] = state
+	}
+	state.failures++
+	state.lastFailure = now
+
+	if state.failures < t.threshold {
+		return 0
+	}
+
+	// しきい値到達後は失敗のたびに待機時間を倍にする
+	penalty := t.basePenalty
+	for i := t.threshold; i < state.failures && penalty < t.maxPenalty; i++ {
+		penalty *= 2
+	}
+	if penalty > t.maxPenalty {
+		penalty = t.maxPenalty
+	}
+	state.blockedUntil = now.Add(penalty)
+	return penalty
+}
+
+func (t *loginThrottle) reset(ip string) {
+	t.mu.Lock()
+	delete(t.states, ip)
+	t.mu.Unlock()
+}
+
+func (t *loginThrottle) sweep(now time.Time) {
+	for ip, state := range t.states {
+		if now.Sub(state.lastFailure) > t.window && now.After(state.blockedUntil) {
+			delete(t.states, ip)
+		}
+	}
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
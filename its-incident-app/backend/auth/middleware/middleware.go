@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"auth/logger"
+	"auth/mtls"
+	"auth/serviceauth"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -39,8 +41,13 @@ func SetupMiddleware(r *gin.Engine, cfg *Config) {
 // AuthMiddleware Bearerトークン検証用ミドルウェア
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		serviceToken := os.Getenv("SERVICE_TOKEN")
-		if serviceToken == "" {
+		// 検証済みのクライアント証明書（相互TLS）によるサービス間認証
+		if mtls.IsVerifiedClient(c.Request) {
+			c.Next()
+			return
+		}
+
+		if os.Getenv("SERVICE_TOKEN") == "" && os.Getenv("ID_TOKEN_AUDIENCE") == "" {
 			logger.Logger.Warn("SERVICE_TOKEN is not set")
 			abortWithError(c, http.StatusUnauthorized, "unauthorized")
 			return
@@ -53,8 +60,9 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// SERVICE_TOKEN またはGoogle署名のIDトークンを受け付ける
 		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token != serviceToken {
+		if !serviceauth.Authorize(token) {
 			logUnauthorizedRequest(c)
 			abortWithError(c, http.StatusUnauthorized, "invalid token")
 			return
@@ -185,7 +193,8 @@ func logRequestWithLevel(c *gin.Context, fields ...zap.Field) {
 	}
 }
 
-// SkipAuthMiddleware 特定のパスの認証をスキップするミドルウェアを生成
+// SkipAuthMiddleware 特定のパスの認証をスキップするミドルウェアを生成。
+// 末尾が "/*" のパスは前方一致で判定します
 func SkipAuthMiddleware(skipPaths ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 現在のパスがスキップ対象かチェック
@@ -195,9 +204,18 @@ func SkipAuthMiddleware(skipPaths ...string) gin.HandlerFunc {
 				c.Next()
 				return
 			}
+			if prefix, ok := strings.CutSuffix(skipPath, "*"); ok && strings.HasPrefix(path, prefix) {
+				c.Next()
+				return
+			}
 		}
 
 		// AuthMiddlewareと同じ処理を実行
+		if mtls.IsVerifiedClient(c.Request) {
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			logger.Logger.Warn("認証ヘッダーが見つかりません")
@@ -212,7 +230,7 @@ func SkipAuthMiddleware(skipPaths ...string) gin.HandlerFunc {
 		}
 
 		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token != os.Getenv("SERVICE_TOKEN") {
+		if !serviceauth.Authorize(token) {
 			logUnauthorizedRequest(c)
 			abortWithError(c, http.StatusUnauthorized, "invalid token")
 			return
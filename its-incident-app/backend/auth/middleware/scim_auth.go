@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"auth/logger"
+	"auth/secrets"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const scimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+// SCIMAuth はIdPからのSCIMリクエストのBearerトークンを SCIM_TOKEN と照合するミドルウェア。
+// SCIM_TOKEN が未設定の環境ではSCIM APIを無効とし、すべて拒否します
+func SCIMAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := secrets.Get("SCIM_TOKEN")
+		if expected == "" {
+			logger.Logger.Warn("SCIM_TOKEN が設定されていないためSCIMリクエストを拒否しました",
+				zap.String("path", c.Request.URL.Path))
+			abortWithSCIMError(c, http.StatusNotFound, "SCIM provisioning is not enabled")
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			logger.Logger.Warn("SCIMリクエストの認証に失敗しました",
+				zap.String("path", c.Request.URL.Path),
+				zap.String("client_ip", c.ClientIP()))
+			abortWithSCIMError(c, http.StatusUnauthorized, "invalid SCIM token")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// abortWithSCIMError はSCIMのエラー形式（RFC 7644 3.12）でレスポンスを返します
+func abortWithSCIMError(c *gin.Context, status int, detail string) {
+	c.Header("Content-Type", "application/scim+json")
+	c.AbortWithStatusJSON(status, gin.H{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	})
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func serveSCIMAuth(authorization string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/scim/v2/Users", SCIMAuth(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestSCIMAuthDisabledWithoutToken(t *testing.T) {
+	t.Setenv("SCIM_TOKEN", "")
+
+	// SCIM_TOKEN が未設定の場合は、空のトークンも含めてすべて拒否する
+	for _, authorization := range []string{"", "Bearer ", "Bearer anything"} {
+		if w := serveSCIMAuth(authorization); w.Code != http.StatusNotFound {
+			t.Errorf("Authorization %q: status = %d, want %d", authorization, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestSCIMAuthRejectsInvalidToken(t *testing.T) {
+	t.Setenv("SCIM_TOKEN", "scim-token")
+
+	for _, authorization := range []string{"", "Bearer ", "Bearer wrong-token", "Bearer scim-token-suffix", "Basic scim-token", "scim-token"} {
+		w := serveSCIMAuth(authorization)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want %d", authorization, w.Code, http.StatusUnauthorized)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/scim+json" {
+			t.Errorf("Authorization %q: Content-Type = %q, want application/scim+json", authorization, got)
+		}
+	}
+
+	if w := serveSCIMAuth("Bearer scim-token"); w.Code != http.StatusOK {
+		t.Errorf("valid token: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
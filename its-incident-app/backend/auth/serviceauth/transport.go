@@ -0,0 +1,47 @@
+package serviceauth
+
+import (
+	"net/http"
+	"net/url"
+
+	"shared/idtoken"
+)
+
+// transport は内部サービス宛てのリクエストにサービス認証ヘッダーを付与します
+type transport struct {
+	base  http.RoundTripper
+	hosts map[string]bool
+}
+
+// InstallTransport は http.DefaultTransport を置き換え、指定した内部サービス宛てのリクエストに
+// サービス認証を付与します。Authorizationヘッダーが未設定の場合はそこにトークンを設定し、
+// ユーザーのセッションで設定済みの場合はCloud Run IAM用に X-Serverless-Authorization へ設定します
+func InstallTransport(internalURLs ...string) {
+	hosts := map[string]bool{}
+	for _, raw := range internalURLs {
+		if u, err := url.Parse(raw); err == nil && u.Host != "" {
+			hosts[u.Host] = true
+		}
+	}
+	http.DefaultTransport = &transport{base: http.DefaultTransport, hosts: hosts}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.hosts[req.URL.Host] {
+		return t.base.RoundTrip(req)
+	}
+
+	target := req.URL.Scheme + "://" + req.URL.Host
+	req = req.Clone(req.Context())
+	if req.Header.Get("Authorization") == "" {
+		if token := idtoken.BearerToken(target, PrimaryServiceToken()); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	} else if idtoken.IDTokenEnabled() {
+		if token, err := idtoken.FetchIDToken(idtoken.AudienceFor(target)); err == nil {
+			req.Header.Set("X-Serverless-Authorization", "Bearer "+token)
+		}
+	}
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"auth/logger"
+	"auth/secrets"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAccessTokenTTL      = 15 * time.Minute
+	defaultAccessTokenIssuer   = "incident-tools-auth"
+	defaultAccessTokenAudience = "incident-tools"
+)
+
+// AccessTokenClaims はアクセストークン(JWT)のクレーム
+type AccessTokenClaims struct {
+	Email     string `json:"email"`
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// JWK はJWKSで公開する公開鍵
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type accessTokenSigner struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+var (
+	tokenSignerMu sync.RWMutex
+	tokenSigner   *accessTokenSigner
+)
+
+// InitTokenSigner は JWT_SIGNING_KEY（PEM形式のRSA秘密鍵）から署名鍵を読み込みます。
+// 未設定の場合、本番環境ではエラーを返します。それ以外の環境では起動ごとに鍵を生成するため、
+// 再起動や複数インスタンス構成では発行済みのアクセストークンが検証できなくなります
+func InitTokenSigner(environment string) error {
+	var key *rsa.PrivateKey
+	if keyPEM := secrets.Get("JWT_SIGNING_KEY"); keyPEM != "" {
+		parsed, err := parseRSAPrivateKey(keyPEM)
+		if err != nil {
+			return err
+		}
+		key = parsed
+	} else {
+		if environment == "production" {
+			return errors.New("JWT_SIGNING_KEY is not set")
+		}
+		generated, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return fmt.Errorf("failed to generate signing key: %v", err)
+		}
+		key = generated
+		logger.Logger.Warn("JWT_SIGNING_KEYが未設定のため、一時的な署名鍵を生成しました。再起動すると発行済みのアクセストークンは無効になります。本番環境では必ず設定してください",
+			zap.String("environment", environment))
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(der)
+
+	tokenSignerMu.Lock()
+	tokenSigner = &accessTokenSigner{
+		key: key,
+		kid: base64.RawURLEncoding.EncodeToString(sum[:12]),
+	}
+	tokenSignerMu.Unlock()
+	return nil
+}
+
+// getTokenSigner は InitTokenSigner で読み込んだ署名鍵を返します
+func getTokenSigner() (*accessTokenSigner, error) {
+	tokenSignerMu.RLock()
+	defer tokenSignerMu.RUnlock()
+	if tokenSigner == nil {
+		return nil, errors.New("access token signing key is not initialized")
+	}
+	return tokenSigner, nil
+}
+
+// AccessTokenTTL はアクセストークンの有効期間を返します
+func AccessTokenTTL() time.Duration {
+	if value := os.Getenv("ACCESS_TOKEN_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil && ttl > 0 {
+			return ttl
+		}
+	}
+	return defaultAccessTokenTTL
+}
+
+// IssueAccessToken はセッションに紐づく短命のアクセストークンを発行します。
+// 有効期限はセッションの有効期限を超えません
+func IssueAccessToken(userID uint, email, sessionID string, sessionExpiresAt time.Time) (string, time.Time, error) {
+	signer, err := getTokenSigner()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to load signing key: %v", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(AccessTokenTTL())
+	if !sessionExpiresAt.IsZero() && sessionExpiresAt.Before(expiresAt) {
+		expiresAt = sessionExpiresAt
+	}
+
+	claims := AccessTokenClaims{
+		Email:     email,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    getEnvOrDefault("JWT_ISSUER", defaultAccessTokenIssuer),
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			Audience:  jwt.ClaimStrings{getEnvOrDefault("JWT_AUDIENCE", defaultAccessTokenAudience)},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = signer.kid
+
+	signed, err := token.SignedString(signer.key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// PublicJWKS は検証用の公開鍵セットを返します
+func PublicJWKS() ([]JWK, error) {
+	signer, err := getTokenSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := signer.key.PublicKey
+	return []JWK{{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: signer.kid,
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}}, nil
+}
+
+func parseRSAPrivateKey(keyPEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("JWT_SIGNING_KEY is not a PEM encoded key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT_SIGNING_KEY: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("JWT_SIGNING_KEY is not an RSA key")
+	}
+	return key, nil
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// resetTokenSigner はテスト終了時に署名鍵を未初期化の状態に戻します
+func resetTokenSigner(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		tokenSignerMu.Lock()
+		tokenSigner = nil
+		tokenSignerMu.Unlock()
+	})
+}
+
+func testSigningKeyPEM(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// publicKeyFromJWK はJWKSの公開鍵をRSA公開鍵に戻します
+func publicKeyFromJWK(t *testing.T, jwk JWK) *rsa.PublicKey {
+	t.Helper()
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		t.Fatalf("decode n: %v", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		t.Fatalf("decode e: %v", err)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+}
+
+func TestInitTokenSignerRequiresKeyInProduction(t *testing.T) {
+	resetTokenSigner(t)
+	t.Setenv("JWT_SIGNING_KEY", "")
+
+	if err := InitTokenSigner("production"); err == nil {
+		t.Fatal("InitTokenSigner accepted a missing JWT_SIGNING_KEY in production")
+	}
+	if _, _, err := IssueAccessToken(1, "user@example.com", "sid", time.Time{}); err == nil {
+		t.Error("IssueAccessToken signed a token without a signing key")
+	}
+	if _, err := PublicJWKS(); err == nil {
+		t.Error("PublicJWKS returned keys without a signing key")
+	}
+
+	// 本番以外では一時的な鍵を生成して起動できる
+	if err := InitTokenSigner("development"); err != nil {
+		t.Fatalf("InitTokenSigner(development): %v", err)
+	}
+	if _, _, err := IssueAccessToken(1, "user@example.com", "sid", time.Time{}); err != nil {
+		t.Errorf("IssueAccessToken with generated key: %v", err)
+	}
+}
+
+func TestInitTokenSignerRejectsInvalidKey(t *testing.T) {
+	resetTokenSigner(t)
+
+	t.Setenv("JWT_SIGNING_KEY", "not a pem")
+	if err := InitTokenSigner("development"); err == nil {
+		t.Error("InitTokenSigner accepted a non-PEM key")
+	}
+
+	t.Setenv("JWT_SIGNING_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")})))
+	if err := InitTokenSigner("development"); err == nil {
+		t.Error("InitTokenSigner accepted a malformed key")
+	}
+}
+
+func TestIssueAccessTokenVerifiesWithJWKS(t *testing.T) {
+	resetTokenSigner(t)
+	key, keyPEM := testSigningKeyPEM(t)
+	t.Setenv("JWT_SIGNING_KEY", keyPEM)
+	t.Setenv("JWT_ISSUER", "")
+	t.Setenv("JWT_AUDIENCE", "")
+	t.Setenv("ACCESS_TOKEN_TTL", "")
+
+	if err := InitTokenSigner("production"); err != nil {
+		t.Fatalf("InitTokenSigner: %v", err)
+	}
+
+	keys, err := PublicJWKS()
+	if err != nil {
+		t.Fatalf("PublicJWKS: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("PublicJWKS returned %d keys, want 1", len(keys))
+	}
+	jwk := keys[0]
+	if jwk.Kty != "RSA" || jwk.Alg != "RS256" || jwk.Use != "sig" || jwk.Kid == "" {
+		t.Errorf("unexpected JWK: %+v", jwk)
+	}
+	publicKey := publicKeyFromJWK(t, jwk)
+	if !publicKey.Equal(&key.PublicKey) {
+		t.Fatal("JWKS public key does not match JWT_SIGNING_KEY")
+	}
+
+	sessionExpiresAt := time.Now().Add(time.Hour)
+	signed, expiresAt, err := IssueAccessToken(42, "user@example.com", "session-1", sessionExpiresAt)
+	if err != nil {
+		t.Fatalf("IssueAccessToken: %v", err)
+	}
+	if got := time.Until(expiresAt); got > defaultAccessTokenTTL || got < defaultAccessTokenTTL-time.Minute {
+		t.Errorf("expiresAt is %v from now, want about %v", got, defaultAccessTokenTTL)
+	}
+
+	claims := &AccessTokenClaims{}
+	token, err := jwt.ParseWithClaims(signed, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != jwk.Kid {
+			t.Errorf("token kid = %v, want %s", token.Header["kid"], jwk.Kid)
+		}
+		return publicKey, nil
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(defaultAccessTokenIssuer),
+		jwt.WithAudience(defaultAccessTokenAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		t.Fatalf("token did not verify with the JWKS key: %v", err)
+	}
+	if claims.Subject != "42" || claims.Email != "user@example.com" || claims.SessionID != "session-1" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	// 別の鍵では検証できない
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return &otherKey.PublicKey, nil }); err == nil {
+		t.Error("token verified with an unrelated key")
+	}
+}
+
+func TestIssueAccessTokenCappedBySession(t *testing.T) {
+	resetTokenSigner(t)
+	t.Setenv("JWT_SIGNING_KEY", "")
+	if err := InitTokenSigner("development"); err != nil {
+		t.Fatalf("InitTokenSigner: %v", err)
+	}
+
+	sessionExpiresAt := time.Now().Add(2 * time.Minute).Truncate(time.Second)
+	_, expiresAt, err := IssueAccessToken(1, "user@example.com", "sid", sessionExpiresAt)
+	if err != nil {
+		t.Fatalf("IssueAccessToken: %v", err)
+	}
+	if !expiresAt.Equal(sessionExpiresAt) {
+		t.Errorf("expiresAt = %v, want session expiry %v", expiresAt, sessionExpiresAt)
+	}
+}
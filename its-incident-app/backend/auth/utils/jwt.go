@@ -1,7 +1,7 @@
 package utils
 
 import (
-	"os"
+	"auth/secrets"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -12,5 +12,5 @@ func GenerateJWT(userID uint) (string, error) {
 		"userID": userID,
 		"exp":    time.Now().Add(time.Hour * 1).Unix(),
 	})
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	return token.SignedString([]byte(secrets.Get("JWT_SECRET")))
 }
@@ -0,0 +1,315 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultOIDCIssuer = "https://accounts.google.com"
+	jwksCacheDuration = time.Hour
+)
+
+// OIDCConfig はOIDCログインの設定
+type OIDCConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	AllowedDomain string
+}
+
+// OIDCClaims はIDトークンから取り出すクレーム
+type OIDCClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+	HostedDomain  string `json:"hd"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// OIDCProvider はディスカバリー情報と署名鍵をキャッシュするOIDCプロバイダー
+type OIDCProvider struct {
+	config     OIDCConfig
+	client     *http.Client
+	mu         sync.RWMutex
+	discovery  *oidcDiscovery
+	keys       map[string]*rsa.PublicKey
+	keysExpiry time.Time
+}
+
+var (
+	oidcProvider     *OIDCProvider
+	oidcProviderOnce sync.Once
+)
+
+// LoadOIDCConfig は環境変数からOIDC設定を読み込みます
+func LoadOIDCConfig() OIDCConfig {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		issuer = defaultOIDCIssuer
+	}
+	return OIDCConfig{
+		Issuer:        strings.TrimSuffix(issuer, "/"),
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		AllowedDomain: os.Getenv("OIDC_ALLOWED_DOMAIN"),
+	}
+}
+
+// Enabled はOIDCログインに必要な設定が揃っているかを返します
+func (c OIDCConfig) Enabled() bool {
+	return c.ClientID != "" && c.ClientSecret != "" && c.RedirectURL != ""
+}
+
+// GetOIDCProvider は環境変数の設定でOIDCプロバイダーを初期化して返します
+func GetOIDCProvider() *OIDCProvider {
+	oidcProviderOnce.Do(func() {
+		oidcProvider = &OIDCProvider{
+			config: LoadOIDCConfig(),
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+	})
+	return oidcProvider
+}
+
+// Config はプロバイダーの設定を返します
+func (p *OIDCProvider) Config() OIDCConfig {
+	return p.config
+}
+
+// GenerateRandomString はstate/nonce用のランダム文字列を生成します
+func GenerateRandomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL は認可エンドポイントへのリダイレクトURLを生成します
+func (p *OIDCProvider) AuthCodeURL(state, nonce string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.config.ClientID},
+		"redirect_uri":  {p.config.RedirectURL},
+		"scope":         {"openid email profile"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	if p.config.AllowedDomain != "" {
+		params.Set("hd", p.config.AllowedDomain)
+	}
+
+	return discovery.AuthorizationEndpoint + "?" + params.Encode(), nil
+}
+
+// Exchange は認可コードをトークンエンドポイントでIDトークンに交換します
+func (p *OIDCProvider) Exchange(code string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {p.config.ClientID},
+		"client_secret": {p.config.ClientSecret},
+		"redirect_uri":  {p.config.RedirectURL},
+	}
+
+	resp, err := p.client.PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		return "", fmt.Errorf("failed to call token endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %v", err)
+	}
+	if tokenResp.IDToken == "" {
+		return "", errors.New("id_token is missing in token response")
+	}
+
+	return tokenResp.IDToken, nil
+}
+
+// VerifyIDToken はIDトークンの署名・発行者・オーディエンス・nonceを検証します
+func (p *OIDCProvider) VerifyIDToken(rawIDToken, nonce string) (*OIDCClaims, error) {
+	claims := &OIDCClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, p.keyFunc,
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+
+	// Googleは "accounts.google.com" と "https://accounts.google.com" の両方を発行者として使用する
+	issuer := strings.TrimPrefix(claims.Issuer, "https://")
+	if issuer != strings.TrimPrefix(p.config.Issuer, "https://") {
+		return nil, fmt.Errorf("unexpected issuer: %s", claims.Issuer)
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errors.New("email is not verified")
+	}
+	if p.config.AllowedDomain != "" && !strings.EqualFold(claims.HostedDomain, p.config.AllowedDomain) {
+		return nil, fmt.Errorf("domain %q is not allowed", claims.HostedDomain)
+	}
+
+	return claims, nil
+}
+
+func (p *OIDCProvider) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key := p.cachedKey(kid); key != nil {
+		return key, nil
+	}
+
+	// 鍵のローテーションに備え、見つからない場合はJWKSを再取得する
+	if err := p.refreshKeys(); err != nil {
+		return nil, err
+	}
+	if key := p.cachedKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("signing key %q not found", kid)
+}
+
+func (p *OIDCProvider) cachedKey(kid string) *rsa.PublicKey {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if time.Now().After(p.keysExpiry) {
+		return nil
+	}
+	return p.keys[kid]
+}
+
+func (p *OIDCProvider) refreshKeys() error {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("failed to fetch jwks: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		key, err := parseRSAPublicKey(k.N, k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysExpiry = time.Now().Add(jwksCacheDuration)
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mu.RLock()
+	discovery := p.discovery
+	p.mu.RUnlock()
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	discovery = &oidcDiscovery{}
+	if err := p.getJSON(p.config.Issuer+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document: %v", err)
+	}
+
+	p.mu.Lock()
+	p.discovery = discovery
+	p.mu.Unlock()
+	return discovery, nil
+}
+
+func (p *OIDCProvider) getJSON(endpoint string, v interface{}) error {
+	resp, err := p.client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, endpoint)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func parseRSAPublicKey(n, e string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
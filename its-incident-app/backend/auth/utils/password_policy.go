@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrCodePasswordPolicyViolation はパスワードポリシー違反時に返すエラーコード
+const ErrCodePasswordPolicyViolation = "password_policy_violation"
+
+const (
+	defaultPasswordMinLength = 8
+	// bcryptは72バイトを超える部分を無視するため上限とする
+	passwordMaxBytes  = 72
+	pwnedPasswordsURL = "https://api.pwnedpasswords.com/range/"
+)
+
+// PasswordPolicy はパスワードの検証ルール
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	CheckBreached bool
+}
+
+// PasswordViolation はポリシー違反の内容
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var pwnedClient = &http.Client{Timeout: 5 * time.Second}
+
+// LoadPasswordPolicy は環境変数からパスワードポリシーを読み込みます
+func LoadPasswordPolicy() PasswordPolicy {
+	minLength := defaultPasswordMinLength
+	if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			minLength = n
+		}
+	}
+
+	return PasswordPolicy{
+		MinLength:     minLength,
+		RequireUpper:  getBoolEnv("PASSWORD_REQUIRE_UPPER"),
+		RequireLower:  getBoolEnv("PASSWORD_REQUIRE_LOWER"),
+		RequireDigit:  getBoolEnv("PASSWORD_REQUIRE_DIGIT"),
+		RequireSymbol: getBoolEnv("PASSWORD_REQUIRE_SYMBOL"),
+		CheckBreached: getBoolEnv("PASSWORD_CHECK_BREACHED"),
+	}
+}
+
+// Validate はパスワードを検証し、違反の一覧を返します。
+// 漏洩パスワードの確認に失敗した場合は違反とせずにエラーを返します
+func (p PasswordPolicy) Validate(password string) ([]PasswordViolation, error) {
+	var violations []PasswordViolation
+
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_short",
+			Message: fmt.Sprintf("Password must be at least %d characters", p.MinLength),
+		})
+	}
+	if len(password) > passwordMaxBytes {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_long",
+			Message: fmt.Sprintf("Password must be at most %d bytes", passwordMaxBytes),
+		})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, PasswordViolation{Code: "missing_upper", Message: "Password must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, PasswordViolation{Code: "missing_lower", Message: "Password must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, PasswordViolation{Code: "missing_digit", Message: "Password must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, PasswordViolation{Code: "missing_symbol", Message: "Password must contain a symbol"})
+	}
+
+	if !p.CheckBreached || len(violations) > 0 {
+		return violations, nil
+	}
+
+	breached, err := isBreachedPassword(password)
+	if err != nil {
+		return violations, err
+	}
+	if breached {
+		violations = append(violations, PasswordViolation{
+			Code:    "breached",
+			Message: "Password has appeared in a data breach",
+		})
+	}
+	return violations, nil
+}
+
+// isBreachedPassword はk-匿名性API（SHA-1の先頭5文字のみ送信）で漏洩済みのパスワードかを確認します
+func isBreachedPassword(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequest(http.MethodGet, pwnedPasswordsURL+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	// レスポンスサイズから問い合わせ内容を推測されないようにする
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := pwnedClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to call breached password API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("breached password API returned status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		candidate, count, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !found || !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+		// パディング行は出現回数0で返される
+		if n, err := strconv.Atoi(count); err == nil && n > 0 {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func getBoolEnv(key string) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	return err == nil && value
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+const rateLimiterMaxKeys = 100000
+
+// RateLimiter はキーごとに一定時間内のリクエスト数を制限するインメモリのスライディングウィンドウ
+type RateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	history map[string][]time.Time
+}
+
+// NewRateLimiter は window あたり limit 回までを許可するレートリミッターを作成します
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		history: map[string][]time.Time{},
+	}
+}
+
+// Allow はリクエストを記録し、ウィンドウ内の試行回数と許可されたかどうかを返します。
+// 拒否された場合は次に許可されるまでの時間も返します
+func (rl *RateLimiter) Allow(key string) (attempts int, allowed bool, retryAfter time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	recent := rl.prune(key, now)
+
+	if rl.limit > 0 && len(recent) >= rl.limit {
+		rl.history[key] = recent
+		return len(recent) + 1, false, recent[0].Add(rl.window).Sub(now)
+	}
+
+	if len(rl.history) >= rateLimiterMaxKeys {
+		rl.sweep(now)
+	}
+	rl.history[key] = append(recent, now)
+	return len(recent) + 1, true, 0
+}
+
+// Reset はキーの記録を削除します
+func (rl *RateLimiter) Reset(key string) {
+	rl.mu.Lock()
+	delete(rl.history, key)
+	rl.mu.Unlock()
+}
+
+func (rl *RateLimiter) prune(key string, now time.Time) []time.Time {
+	entries := rl.history[key]
+	cutoff := now.Add(-rl.window)
+	i := 0
+	for i < len(entries) && !entries[i].After(cutoff) {
+		i++
+	}
+	return entries[i:]
+}
+
+// sweep はウィンドウ外の記録しかないキーを削除します
+func (rl *RateLimiter) sweep(now time.Time) {
+	for key := range rl.history {
+		if recent := rl.prune(key, now); len(recent) == 0 {
+			delete(rl.history, key)
+		} else {
+			rl.history[key] = recent
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package utils
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	samlProtocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlStatusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBindingHTTPPost    = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlNameIDEmail        = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	samlClockSkew          = 2 * time.Minute
+)
+
+// 属性名のデフォルト値（Entra ID・Okta・汎用の順）
+var (
+	defaultSAMLEmailAttributes = []string{
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+		"email",
+		"mail",
+	}
+	defaultSAMLNameAttributes = []string{
+		"http://schemas.microsoft.com/identity/claims/displayname",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name",
+		"displayName",
+		"name",
+	}
+)
+
+// SAMLConfig はSAML SPの設定
+type SAMLConfig struct {
+	EntityID        string
+	ACSURL          string
+	IdPEntityID     string
+	IdPSSOURL       string
+	IdPCertificate  *x509.Certificate
+	EmailAttributes []string
+	NameAttributes  []string
+	// AllowIdPInitiated はSPのリクエストに対応しないIdP起点のレスポンスを受け付けるか（SAML_ALLOW_IDP_INITIATED）
+	AllowIdPInitiated bool
+}
+
+// SAMLUser はアサーションから取り出したユーザー属性
+type SAMLUser struct {
+	NameID       string
+	Email        string
+	Name         string
+	SessionIndex string
+	Attributes   map[string][]string
+}
+
+// SAMLServiceProvider はSAML 2.0のSPとしてアサーションを検証します
+type SAMLServiceProvider struct {
+	config SAMLConfig
+	mu     sync.Mutex
+	seen   map[string]time.Time
+}
+
+var (
+	samlProvider     *SAMLServiceProvider
+	samlProviderErr  error
+	samlProviderOnce sync.Once
+)
+
+// LoadSAMLConfig は環境変数からSAML設定を読み込みます
+func LoadSAMLConfig() (SAMLConfig, error) {
+	config := SAMLConfig{
+		EntityID:        os.Getenv("SAML_SP_ENTITY_ID"),
+		ACSURL:          os.Getenv("SAML_ACS_URL"),
+		IdPEntityID:     os.Getenv("SAML_IDP_ENTITY_ID"),
+		IdPSSOURL:       os.Getenv("SAML_IDP_SSO_URL"),
+		EmailAttributes: getListEnv("SAML_ATTR_EMAIL", defaultSAMLEmailAttributes),
+		NameAttributes:  getListEnv("SAML_ATTR_NAME", defaultSAMLNameAttributes),
+		// IdP起点のログインはログインCSRFに使われるため、明示的に有効化した場合のみ受け付ける
+		AllowIdPInitiated: getBoolEnv("SAML_ALLOW_IDP_INITIATED"),
+	}
+
+	if certPEM := os.Getenv("SAML_IDP_CERT"); certPEM != "" {
+		cert, err := parseCertificate(certPEM)
+		if err != nil {
+			return config, fmt.Errorf("invalid SAML_IDP_CERT: %v", err)
+		}
+		config.IdPCertificate = cert
+	}
+
+	return config, nil
+}
+
+// Enabled はSAMLログインに必要な設定が揃っているかを返します
+func (c SAMLConfig) Enabled() bool {
+	return c.EntityID != "" && c.ACSURL != "" && c.IdPCertificate != nil
+}
+
+// GetSAMLServiceProvider は環境変数の設定でSAML SPを初期化して返します
+func GetSAMLServiceProvider() (*SAMLServiceProvider, error) {
+	samlProviderOnce.Do(func() {
+		config, err := LoadSAMLConfig()
+		if err != nil {
+			samlProviderErr = err
+			return
+		}
+		samlProvider = &SAMLServiceProvider{
+			config: config,
+			seen:   map[string]time.Time{},
+		}
+	})
+	return samlProvider, samlProviderErr
+}
+
+// Config はSPの設定を返します
+func (sp *SAMLServiceProvider) Config() SAMLConfig {
+	return sp.config
+}
+
+// Metadata はIdPに登録するSPメタデータを生成します
+func (sp *SAMLServiceProvider) Metadata() ([]byte, error) {
+	type assertionConsumerService struct {
+		Binding  string `xml:"Binding,attr"`
+		Location string `xml:"Location,attr"`
+		Index    int    `xml:"index,attr"`
+	}
+	type spSSODescriptor struct {
+		AuthnRequestsSigned        bool                     `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool                     `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupportEnumeration string                   `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormat               string                   `xml:"NameIDFormat"`
+		AssertionConsumerService   assertionConsumerService `xml:"AssertionConsumerService"`
+	}
+	type entityDescriptor struct {
+		XMLName         xml.Name        `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+		EntityID        string          `xml:"entityID,attr"`
+		SPSSODescriptor spSSODescriptor `xml:"SPSSODescriptor"`
+	}
+
+	metadata := entityDescriptor{
+		EntityID: sp.config.EntityID,
+		SPSSODescriptor: spSSODescriptor{
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: samlProtocolNamespace,
+			NameIDFormat:               samlNameIDEmail,
+			AssertionConsumerService: assertionConsumerService{
+				Binding:  samlBindingHTTPPost,
+				Location: sp.config.ACSURL,
+				Index:    0,
+			},
+		},
+	}
+
+	body, err := xml.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// AuthnRequestURL はHTTP-RedirectバインディングのAuthnRequest URLとリクエストIDを生成します
+func (sp *SAMLServiceProvider) AuthnRequestURL(relayState string) (string, string, error) {
+	if sp.config.IdPSSOURL == "" {
+		return "", "", errors.New("SAML_IDP_SSO_URL is not configured")
+	}
+
+	random, err := GenerateRandomString(20)
+	if err != nil {
+		return "", "", err
+	}
+	requestID := "_" + random
+
+	var request bytes.Buffer
+	fmt.Fprintf(&request,
+		`<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s" Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="%s">`+
+			`<saml:Issuer>%s</saml:Issuer>`+
+			`<samlp:NameIDPolicy Format="%s" AllowCreate="true"/>`+
+			`</samlp:AuthnRequest>`,
+		samlProtocolNamespace, samlAssertionNamespace, requestID,
+		time.Now().UTC().Format(time.RFC3339), xmlEscape(sp.config.IdPSSOURL),
+		xmlEscape(sp.config.ACSURL), samlBindingHTTPPost,
+		xmlEscape(sp.config.EntityID), samlNameIDEmail)
+
+	var compressed bytes.Buffer
+	writer, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := writer.Write(request.Bytes()); err != nil {
+		return "", "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", "", err
+	}
+
+	params := url.Values{"SAMLRequest": {base64.StdEncoding.EncodeToString(compressed.Bytes())}}
+	if relayState != "" {
+		params.Set("RelayState", relayState)
+	}
+
+	separator := "?"
+	if strings.Contains(sp.config.IdPSSOURL, "?") {
+		separator = "&"
+	}
+	return sp.config.IdPSSOURL + separator + params.Encode(), requestID, nil
+}
+
+// ParseResponse はHTTP-POSTで受け取ったSAMLResponseを検証し、ユーザー属性を返します。
+// expectedRequestID が空の場合はIdP起点のログインとして扱い、AllowIdPInitiated が有効な場合のみ受け付けます
+func (sp *SAMLServiceProvider) ParseResponse(encoded, expectedRequestID string) (*SAMLUser, error) {
+	if sp.config.IdPCertificate == nil {
+		return nil, errors.New("SAML_IDP_CERT is not configured")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(stripWhitespace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMLResponse encoding: %v", err)
+	}
+
+	response, err := parseXMLTree(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMLResponse XML: %v", err)
+	}
+	if response.Local != "Response" || response.Namespace() != samlProtocolNamespace {
+		return nil, errors.New("root element is not a SAML Response")
+	}
+
+	if destination := response.Attr("Destination"); destination != "" && destination != sp.config.ACSURL {
+		return nil, fmt.Errorf("unexpected destination: %s", destination)
+	}
+	inResponseTo := response.Attr("InResponseTo")
+	if expectedRequestID == "" {
+		// リクエストIDのクッキーがない場合はIdP起点のレスポンスのみ（SP起点のレスポンスは別のブラウザーで開始されたもの）
+		if !sp.config.AllowIdPInitiated {
+			return nil, errors.New("unsolicited response is not allowed")
+		}
+		if inResponseTo != "" {
+			return nil, errors.New("InResponseTo is set but no request is pending")
+		}
+	} else if inResponseTo != expectedRequestID {
+		return nil, errors.New("InResponseTo does not match the request")
+	}
+
+	status := response.Child(samlProtocolNamespace, "Status")
+	if status == nil {
+		return nil, errors.New("status is missing")
+	}
+	if code := status.Child(samlProtocolNamespace, "StatusCode"); code == nil || code.Attr("Value") != samlStatusSuccess {
+		return nil, errors.New("IdP did not return a success status")
+	}
+
+	if len(response.ChildElements(samlAssertionNamespace, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("encrypted assertions are not supported")
+	}
+	assertions := response.ChildElements(samlAssertionNamespace, "Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("expected exactly one assertion, found %d", len(assertions))
+	}
+
+	// アサーション自体の署名を優先し、なければレスポンス全体の署名で検証する。
+	// 以降は署名で保護された要素だけを参照する（署名ラッピング攻撃対策）
+	var assertion *xmlNode
+	if assertions[0].Child(xmlDSigNamespace, "Signature") != nil {
+		assertion, err = verifySignedElement(raw, true, sp.config.IdPCertificate)
+	} else {
+		var signed *xmlNode
+		if signed, err = verifySignedElement(raw, false, sp.config.IdPCertificate); err == nil {
+			if signedAssertions := signed.ChildElements(samlAssertionNamespace, "Assertion"); len(signedAssertions) == 1 {
+				assertion = signedAssertions[0]
+			} else {
+				err = errors.New("signed response does not contain exactly one assertion")
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sp.validateAssertion(assertion, expectedRequestID); err != nil {
+		return nil, err
+	}
+
+	return sp.extractUser(assertion)
+}
+
+func (sp *SAMLServiceProvider) validateAssertion(assertion *xmlNode, expectedRequestID string) error {
+	now := time.Now()
+
+	issuer := assertion.Child(samlAssertionNamespace, "Issuer")
+	if issuer == nil {
+		return errors.New("assertion issuer is missing")
+	}
+	if sp.config.IdPEntityID != "" && issuer.Text() != sp.config.IdPEntityID {
+		return fmt.Errorf("unexpected issuer: %s", issuer.Text())
+	}
+
+	conditions := assertion.Child(samlAssertionNamespace, "Conditions")
+	if conditions == nil {
+		return errors.New("conditions are missing")
+	}
+	if err := checkTimeWindow(conditions.Attr("NotBefore"), conditions.Attr("NotOnOrAfter"), now); err != nil {
+		return err
+	}
+
+	audienceMatched := false
+	for _, restriction := range conditions.ChildElements(samlAssertionNamespace, "AudienceRestriction") {
+		for _, audience := range restriction.ChildElements(samlAssertionNamespace, "Audience") {
+			if audience.Text() == sp.config.EntityID {
+				audienceMatched = true
+			}
+		}
+	}
+	if !audienceMatched {
+		return errors.New("audience does not match this service provider")
+	}
+
+	subject := assertion.Child(samlAssertionNamespace, "Subject")
+	if subject == nil {
+		return errors.New("subject is missing")
+	}
+
+	confirmed := false
+	for _, confirmation := range subject.ChildElements(samlAssertionNamespace, "SubjectConfirmation") {
+		data := confirmation.Child(samlAssertionNamespace, "SubjectConfirmationData")
+		if data == nil {
+			continue
+		}
+		if recipient := data.Attr("Recipient"); recipient != "" && recipient != sp.config.ACSURL {
+			continue
+		}
+		if expectedRequestID != "" && data.Attr("InResponseTo") != expectedRequestID {
+			continue
+		}
+		if checkTimeWindow("", data.Attr("NotOnOrAfter"), now) != nil {
+			continue
+		}
+		confirmed = true
+	}
+	if !confirmed {
+		return errors.New("no valid subject confirmation")
+	}
+
+	// 同じアサーションの再利用を防ぐ
+	return sp.markAssertionUsed(assertion.Attr("ID"), conditions.Attr("NotOnOrAfter"))
+}
+
+func (sp *SAMLServiceProvider) extractUser(assertion *xmlNode) (*SAMLUser, error) {
+	user := &SAMLUser{Attributes: map[string][]string{}}
+
+	if subject := assertion.Child(samlAssertionNamespace, "Subject"); subject != nil {
+		if nameID := subject.Child(samlAssertionNamespace, "NameID"); nameID != nil {
+			user.NameID = nameID.Text()
+		}
+	}
+	if authn := assertion.Child(samlAssertionNamespace, "AuthnStatement"); authn != nil {
+		user.SessionIndex = authn.Attr("SessionIndex")
+	}
+
+	for _, statement := range assertion.ChildElements(samlAssertionNamespace, "AttributeStatement") {
+		for _, attribute := range statement.ChildElements(samlAssertionNamespace, "Attribute") {
+			name := attribute.Attr("Name")
+			for _, value := range attribute.ChildElements(samlAssertionNamespace, "AttributeValue") {
+				user.Attributes[name] = append(user.Attributes[name], value.Text())
+			}
+		}
+	}
+
+	user.Email = firstAttribute(user.Attributes, sp.config.EmailAttributes)
+	if user.Email == "" && strings.Contains(user.NameID, "@") {
+		user.Email = user.NameID
+	}
+	user.Name = firstAttribute(user.Attributes, sp.config.NameAttributes)
+
+	if user.Email == "" {
+		return nil, errors.New("email attribute is missing in assertion")
+	}
+	if user.NameID == "" {
+		user.NameID = user.Email
+	}
+	return user, nil
+}
+
+func (sp *SAMLServiceProvider) markAssertionUsed(id, notOnOrAfter string) error {
+	if id == "" {
+		return errors.New("assertion ID is missing")
+	}
+
+	expiry := time.Now().Add(time.Hour)
+	if t, err := time.Parse(time.RFC3339, notOnOrAfter); err == nil {
+		expiry = t.Add(samlClockSkew)
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	now := time.Now()
+	for seenID, seenExpiry := range sp.seen {
+		if now.After(seenExpiry) {
+			delete(sp.seen, seenID)
+		}
+	}
+	if _, ok := sp.seen[id]; ok {
+		return errors.New("assertion has already been used")
+	}
+	sp.seen[id] = expiry
+	return nil
+}
+
+func checkTimeWindow(notBefore, notOnOrAfter string, now time.Time) error {
+	if notBefore != "" {
+		t, err := time.Parse(time.RFC3339, notBefore)
+		if err != nil {
+			return fmt.Errorf("invalid NotBefore: %v", err)
+		}
+		if now.Add(samlClockSkew).Before(t) {
+			return errors.New("assertion is not yet valid")
+		}
+	}
+	if notOnOrAfter != "" {
+		t, err := time.Parse(time.RFC3339, notOnOrAfter)
+		if err != nil {
+			return fmt.Errorf("invalid NotOnOrAfter: %v", err)
+		}
+		if !now.Add(-samlClockSkew).Before(t) {
+			return errors.New("assertion has expired")
+		}
+	}
+	return nil
+}
+
+func firstAttribute(attributes map[string][]string, names []string) string {
+	for _, name := range names {
+		for _, value := range attributes[name] {
+			if value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+func parseCertificate(value string) (*x509.Certificate, error) {
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		return x509.ParseCertificate(block.Bytes)
+	}
+
+	// PEMヘッダーなしのBase64（IdPメタデータの X509Certificate の値）も受け付ける
+	der, err := base64.StdEncoding.DecodeString(stripWhitespace(value))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func getListEnv(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
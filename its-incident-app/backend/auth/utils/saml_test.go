@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSPEntityID  = "https://incident.example.com/saml/metadata"
+	testACSURL      = "https://incident.example.com/saml/acs"
+	testIdPEntityID = "https://idp.example.com/metadata"
+	testAssertionID = "_assertion1"
+	testRequestID   = "_request1"
+
+	// 署名で使用するアルゴリズム（検証側の定数に依存しないよう仕様のURIをそのまま記述する）
+	testExcC14N   = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	testEnveloped = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	testRSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	testSHA256    = "http://www.w3.org/2001/04/xmlenc#sha256"
+)
+
+// testIdP はテスト用のIdPの鍵と証明書です
+type testIdP struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return &testIdP{key: key, cert: cert}
+}
+
+func newTestServiceProvider(cert *x509.Certificate) *SAMLServiceProvider {
+	return &SAMLServiceProvider{
+		config: SAMLConfig{
+			EntityID:        testSPEntityID,
+			ACSURL:          testACSURL,
+			IdPEntityID:     testIdPEntityID,
+			IdPCertificate:  cert,
+			EmailAttributes: defaultSAMLEmailAttributes,
+			NameAttributes:  defaultSAMLNameAttributes,
+		},
+		seen: map[string]time.Time{},
+	}
+}
+
+// canonicalAssertion はアサーションのExclusive C14N後の形式です（署名を除く）。
+// 検証側の正規化に依存しないよう、ダイジェストはこの文字列から直接計算します。
+// 文書中では saml の名前空間をレスポンスで宣言するため、アサーションの開始タグには宣言がありません。
+// requestID が空の場合はIdP起点のアサーションです
+func canonicalAssertion(id, email, requestID string) string {
+	now := time.Now().UTC()
+	notBefore := now.Add(-time.Minute).Format(time.RFC3339)
+	notOnOrAfter := now.Add(5 * time.Minute).Format(time.RFC3339)
+	inResponseTo := ""
+	if requestID != "" {
+		inResponseTo = ` InResponseTo="` + requestID + `"`
+	}
+
+	return `<saml:Assertion xmlns:saml="` + samlAssertionNamespace + `" ID="` + id + `" IssueInstant="` + now.Format(time.RFC3339) + `" Version="2.0">` +
+		`<saml:Issuer>` + testIdPEntityID + `</saml:Issuer>` +
+		`<saml:Subject>` +
+		`<saml:NameID Format="` + samlNameIDEmail + `">` + email + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">` +
+		`<saml:SubjectConfirmationData` + inResponseTo + ` NotOnOrAfter="` + notOnOrAfter + `" Recipient="` + testACSURL + `"></saml:SubjectConfirmationData>` +
+		`</saml:SubjectConfirmation>` +
+		`</saml:Subject>` +
+		`<saml:Conditions NotBefore="` + notBefore + `" NotOnOrAfter="` + notOnOrAfter + `">` +
+		`<saml:AudienceRestriction><saml:Audience>` + testSPEntityID + `</saml:Audience></saml:AudienceRestriction>` +
+		`</saml:Conditions>` +
+		`<saml:AuthnStatement AuthnInstant="` + now.Format(time.RFC3339) + `" SessionIndex="_session1"></saml:AuthnStatement>` +
+		`<saml:AttributeStatement>` +
+		`<saml:Attribute Name="email"><saml:AttributeValue>` + email + `</saml:AttributeValue></saml:Attribute>` +
+		`</saml:AttributeStatement>` +
+		`</saml:Assertion>`
+}
+
+// sign は正規化済みのアサーションに対する署名要素を返します
+func (idp *testIdP) sign(t *testing.T, id, canonical string) string {
+	t.Helper()
+	digest := sha256.Sum256([]byte(canonical))
+
+	// 文書中では ds の名前空間を Signature で宣言するため、正規化後の SignedInfo にだけ宣言がある
+	signedInfo := `<ds:SignedInfo xmlns:ds="` + xmlDSigNamespace + `">` +
+		`<ds:CanonicalizationMethod Algorithm="` + testExcC14N + `"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="` + testRSASHA256 + `"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#` + id + `">` +
+		`<ds:Transforms>` +
+		`<ds:Transform Algorithm="` + testEnveloped + `"></ds:Transform>` +
+		`<ds:Transform Algorithm="` + testExcC14N + `"></ds:Transform>` +
+		`</ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="` + testSHA256 + `"></ds:DigestMethod>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue>` +
+		`</ds:Reference>` +
+		`</ds:SignedInfo>`
+
+	signedInfoDigest := sha256.Sum256([]byte(signedInfo))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, signedInfoDigest[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15: %v", err)
+	}
+
+	return `<ds:Signature xmlns:ds="` + xmlDSigNamespace + `">` +
+		strings.Replace(signedInfo, ` xmlns:ds="`+xmlDSigNamespace+`"`, "", 1) +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(signature) + `</ds:SignatureValue>` +
+		`</ds:Signature>`
+}
+
+// signedAssertion は署名済みのアサーションを文書に埋め込む形式で返します（署名は Issuer の直後）
+func (idp *testIdP) signedAssertion(t *testing.T, id, email, requestID string) string {
+	t.Helper()
+	canonical := canonicalAssertion(id, email, requestID)
+	assertion := strings.Replace(canonical, ` xmlns:saml="`+samlAssertionNamespace+`"`, "", 1)
+	return strings.Replace(assertion, `</saml:Issuer>`, `</saml:Issuer>`+idp.sign(t, id, canonical), 1)
+}
+
+// samlResponse はアサーションを含むSAMLResponseをBase64で返します（requestID が空の場合はIdP起点）
+func samlResponse(requestID string, assertions ...string) string {
+	inResponseTo := ""
+	if requestID != "" {
+		inResponseTo = ` InResponseTo="` + requestID + `"`
+	}
+	response := `<samlp:Response xmlns:samlp="` + samlProtocolNamespace + `" xmlns:saml="` + samlAssertionNamespace + `"` +
+		` Destination="` + testACSURL + `" ID="_response1"` + inResponseTo + ` IssueInstant="` + time.Now().UTC().Format(time.RFC3339) + `" Version="2.0">` +
+		`<saml:Issuer>` + testIdPEntityID + `</saml:Issuer>` +
+		`<samlp:Status><samlp:StatusCode Value="` + samlStatusSuccess + `"/></samlp:Status>` +
+		strings.Join(assertions, "") +
+		`</samlp:Response>`
+	return base64.StdEncoding.EncodeToString([]byte(response))
+}
+
+func TestParseResponseAcceptsSignedAssertion(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newTestServiceProvider(idp.cert)
+
+	encoded := samlResponse(testRequestID, idp.signedAssertion(t, testAssertionID, "user@example.com", testRequestID))
+	user, err := sp.ParseResponse(encoded, testRequestID)
+	if err != nil {
+		t.Fatalf("ParseResponse: %v", err)
+	}
+	if user.Email != "user@example.com" || user.NameID != "user@example.com" || user.SessionIndex != "_session1" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	// 同じアサーションは再利用できない
+	if _, err := sp.ParseResponse(encoded, testRequestID); err == nil {
+		t.Error("replayed assertion was accepted")
+	}
+}
+
+func TestParseResponseAcceptsSignedResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newTestServiceProvider(idp.cert)
+
+	// レスポンス全体に署名する場合は、署名の対象にアサーションも含まれる。
+	// 正規化後は saml の名前空間を使用する要素（Issuer・Assertion）ごとに宣言する
+	samlNS := ` xmlns:saml="` + samlAssertionNamespace + `"`
+	canonical := `<samlp:Response xmlns:samlp="` + samlProtocolNamespace + `"` +
+		` Destination="` + testACSURL + `" ID="_response1" InResponseTo="` + testRequestID + `" IssueInstant="` + time.Now().UTC().Format(time.RFC3339) + `" Version="2.0">` +
+		`<saml:Issuer` + samlNS + `>` + testIdPEntityID + `</saml:Issuer>` +
+		`<samlp:Status><samlp:StatusCode Value="` + samlStatusSuccess + `"></samlp:StatusCode></samlp:Status>` +
+		canonicalAssertion(testAssertionID, "user@example.com", testRequestID) +
+		`</samlp:Response>`
+	response := strings.ReplaceAll(canonical, samlNS, "")
+	response = strings.Replace(response, `<samlp:Response xmlns:samlp="`+samlProtocolNamespace+`"`, `<samlp:Response xmlns:samlp="`+samlProtocolNamespace+`"`+samlNS, 1)
+	response = strings.Replace(response, `</saml:Issuer>`, `</saml:Issuer>`+idp.sign(t, "_response1", canonical), 1)
+
+	user, err := sp.ParseResponse(base64.StdEncoding.EncodeToString([]byte(response)), testRequestID)
+	if err != nil {
+		t.Fatalf("ParseResponse: %v", err)
+	}
+	if user.Email != "user@example.com" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+}
+
+func TestParseResponseIdPInitiated(t *testing.T) {
+	idp := newTestIdP(t)
+	encoded := samlResponse("", idp.signedAssertion(t, testAssertionID, "user@example.com", ""))
+
+	// リクエストに対応しないレスポンスは既定では受け付けない
+	if _, err := newTestServiceProvider(idp.cert).ParseResponse(encoded, ""); err == nil {
+		t.Error("unsolicited response was accepted")
+	}
+
+	sp := newTestServiceProvider(idp.cert)
+	sp.config.AllowIdPInitiated = true
+	if _, err := sp.ParseResponse(encoded, ""); err != nil {
+		t.Errorf("IdP-initiated response rejected with SAML_ALLOW_IDP_INITIATED: %v", err)
+	}
+
+	// SP起点のレスポンスはリクエストIDのクッキーがなければ受け付けない
+	solicited := samlResponse(testRequestID, idp.signedAssertion(t, "_assertion2", "user@example.com", testRequestID))
+	if _, err := sp.ParseResponse(solicited, ""); err == nil {
+		t.Error("SP-initiated response without a pending request was accepted")
+	}
+	if _, err := sp.ParseResponse(solicited, "_other"); err == nil {
+		t.Error("response to another request was accepted")
+	}
+}
+
+func TestParseResponseRejectsInvalidSignatures(t *testing.T) {
+	idp := newTestIdP(t)
+	signed := idp.signedAssertion(t, testAssertionID, "user@example.com", testRequestID)
+	unsigned := strings.Replace(canonicalAssertion("_evil", "attacker@example.com", testRequestID), ` xmlns:saml="`+samlAssertionNamespace+`"`, "", 1)
+
+	// 署名済みのアサーションから署名要素だけを取り出す
+	signature := signed[strings.Index(signed, "<ds:Signature") : strings.Index(signed, "</ds:Signature>")+len("</ds:Signature>")]
+
+	tests := []struct {
+		name       string
+		assertions []string
+		otherIdP   bool
+	}{
+		{name: "unsigned assertion", assertions: []string{unsigned}},
+		{
+			name:       "tampered attribute",
+			assertions: []string{strings.Replace(signed, "<saml:AttributeValue>user@example.com", "<saml:AttributeValue>attacker@example.com", 1)},
+		},
+		{
+			name:       "tampered signature value",
+			assertions: []string{strings.Replace(signed, "<ds:SignatureValue>", "<ds:SignatureValue>AAAA", 1)},
+		},
+		{name: "signed by another key", assertions: []string{signed}, otherIdP: true},
+		{
+			// 署名済みのアサーションを Extensions に退避し、署名のないアサーションを差し込む
+			name:       "wrapped in extensions",
+			assertions: []string{`<samlp:Extensions>` + signed + `</samlp:Extensions>` + unsigned},
+		},
+		{
+			// 同じIDの偽のアサーションに元の署名を複製する
+			name: "copied signature with same ID",
+			assertions: []string{strings.Replace(
+				strings.Replace(unsigned, `ID="_evil"`, `ID="`+testAssertionID+`"`, 1),
+				`</saml:Issuer>`, `</saml:Issuer>`+signature, 1)},
+		},
+		{
+			// 偽のアサーションに元の署名を複製する（参照先のIDが一致しない）
+			name:       "copied signature with different ID",
+			assertions: []string{strings.Replace(unsigned, `</saml:Issuer>`, `</saml:Issuer>`+signature, 1)},
+		},
+		{
+			// 署名済みのアサーションを偽のアサーションの中に入れる
+			name:       "nested in forged assertion",
+			assertions: []string{strings.Replace(unsigned, `</saml:AttributeStatement>`, `</saml:AttributeStatement>`+signed, 1)},
+		},
+		{name: "additional assertion", assertions: []string{signed, unsigned}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := idp.cert
+			if tt.otherIdP {
+				cert = newTestIdP(t).cert
+			}
+			user, err := newTestServiceProvider(cert).ParseResponse(samlResponse(testRequestID, tt.assertions...), testRequestID)
+			if err == nil {
+				t.Fatalf("accepted: %+v", user)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const (
+	xmlDSigNamespace = "http://www.w3.org/2000/09/xmldsig#"
+	xmlNamespace     = "http://www.w3.org/XML/1998/namespace"
+)
+
+// xmlNode は名前空間プレフィックスを保持したままパースしたXML要素
+type xmlNode struct {
+	Prefix   string
+	Local    string
+	Attrs    []xml.Attr
+	NSDecls  map[string]string
+	Children []interface{} // *xmlNode または string
+	Parent   *xmlNode
+}
+
+// parseXMLTree はXMLをプレフィックスを解決せずに木構造としてパースします。
+// DTDを含む文書はXXE等を避けるため拒否します
+func parseXMLTree(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+
+	var root, current *xmlNode
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{
+				Prefix:  t.Name.Space,
+				Local:   t.Name.Local,
+				NSDecls: map[string]string{},
+				Parent:  current,
+			}
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "xmlns":
+					node.NSDecls[attr.Name.Local] = attr.Value
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					node.NSDecls[""] = attr.Value
+				default:
+					node.Attrs = append(node.Attrs, attr)
+				}
+			}
+			if current == nil {
+				if root != nil {
+					return nil, errors.New("multiple root elements")
+				}
+				root = node
+			} else {
+				current.Children = append(current.Children, node)
+			}
+			current = node
+		case xml.EndElement:
+			if current == nil {
+				return nil, errors.New("unexpected end element")
+			}
+			current = current.Parent
+		case xml.CharData:
+			if current != nil {
+				current.Children = append(current.Children, string(t))
+			}
+		case xml.Directive:
+			return nil, errors.New("DTD is not allowed")
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("empty document")
+	}
+	return root, nil
+}
+
+// lookupNamespace はプレフィックスに対応する名前空間URIを祖先要素から解決します
+func (n *xmlNode) lookupNamespace(prefix string) string {
+	if prefix == "xml" {
+		return xmlNamespace
+	}
+	for node := n; node != nil; node = node.Parent {
+		if uri, ok := node.NSDecls[prefix]; ok {
+			return uri
+		}
+	}
+	return ""
+}
+
+// Namespace は要素の名前空間URIを返します
+func (n *xmlNode) Namespace() string {
+	return n.lookupNamespace(n.Prefix)
+}
+
+// Attr は名前空間なしの属性値を返します
+func (n *xmlNode) Attr(local string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Space == "" && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// ChildElements は指定した名前空間・ローカル名の子要素を返します
+func (n *xmlNode) ChildElements(namespace, local string) []*xmlNode {
+	var result []*xmlNode
+	for _, child := range n.Children {
+		if el, ok := child.(*xmlNode); ok && el.Local == local && el.Namespace() == namespace {
+			result = append(result, el)
+		}
+	}
+	return result
+}
+
+// Child は指定した名前空間・ローカル名の最初の子要素を返します
+func (n *xmlNode) Child(namespace, local string) *xmlNode {
+	if children := n.ChildElements(namespace, local); len(children) > 0 {
+		return children[0]
+	}
+	return nil
+}
+
+// Text は直下のテキストを連結して返します
+func (n *xmlNode) Text() string {
+	var sb strings.Builder
+	for _, child := range n.Children {
+		if text, ok := child.(string); ok {
+			sb.WriteString(text)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// verifySignedElement は文書のルート要素（assertion が true の場合はルート直下のアサーション）の
+// エンベロープ署名を検証し、署名の対象となった要素を返します。
+// 署名の検証と正規化には goxmldsig を使用し、鍵は設定済みのIdP証明書に限ります。
+// 呼び出し側は元の文書ではなく、返された要素（署名で保護された内容）だけを参照してください（署名ラッピング攻撃対策）
+func verifySignedElement(raw []byte, assertion bool, cert *x509.Certificate) (*xmlNode, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return nil, fmt.Errorf("invalid XML: %v", err)
+	}
+
+	el := doc.Root()
+	if el == nil {
+		return nil, errors.New("empty document")
+	}
+	ctx := etreeutils.NewDefaultNSContext()
+	if assertion {
+		var err error
+		if ctx, err = ctx.SubContext(el); err != nil {
+			return nil, err
+		}
+		var assertions []*etree.Element
+		err = etreeutils.NSFindChildrenIterateCtx(ctx, el, samlAssertionNamespace, "Assertion", func(_ etreeutils.NSContext, child *etree.Element) error {
+			assertions = append(assertions, child)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(assertions) != 1 {
+			return nil, fmt.Errorf("expected exactly one assertion, found %d", len(assertions))
+		}
+		el = assertions[0]
+	}
+
+	// 祖先で宣言された名前空間を引き継いで切り出す
+	detached, err := etreeutils.NSDetatch(ctx, el)
+	if err != nil {
+		return nil, err
+	}
+
+	validationContext := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+	validated, err := validationContext.Validate(detached)
+	if err != nil {
+		return nil, fmt.Errorf("signature verification failed: %v", err)
+	}
+
+	verifiedDoc := etree.NewDocument()
+	verifiedDoc.SetRoot(validated)
+	verified, err := verifiedDoc.WriteToBytes()
+	if err != nil {
+		return nil, err
+	}
+	return parseXMLTree(verified)
+}
+
+func stripWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
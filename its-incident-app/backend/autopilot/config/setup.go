@@ -2,33 +2,200 @@ package config
 
 import (
 	"autopilot/logger"
+	"autopilot/mtls"
+	"autopilot/secrets"
+	"autopilot/serviceauth"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 // ServerConfig サーバーの基本設定
 type ServerConfig struct {
-	Port            string
-	GinMode         string
-	LogLevel        zapcore.Level
-	DBPilotURL      string
-	ServiceToken    string
-	AIEndpoint      string
-	AIToken         string
-	Environment     string
-	ProjectID       string
-	ServiceName     string
-	ShutdownTimeout time.Duration
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	IdleTimeout     time.Duration
+	Port         string
+	GinMode      string
+	LogLevel     zapcore.Level
+	DBPilotURL   string
+	ServiceToken string
+	AIEndpoint   string
+	AIToken      string
+	// AIProviders はフェイルオーバー順（priority の昇順）に試行するAIプロバイダー。
+	// AI_PROVIDERS 未設定の場合は ENDPOINT/TOKEN の1件になります
+	AIProviders        []AIProviderConfig
+	AIWorkflow         AIWorkflowConfig
+	AIShadow           AIShadowConfig
+	AIRetry            AIRetryConfig
+	AIStreaming        AIStreamingConfig
+	AIBreakerThreshold int
+	AIBreakerCooldown  time.Duration
+	// AIOutputMapping はワークフローの出力のキーとインシデントの項目の対応（AI_OUTPUT_MAPPING、未設定の項目は同じ名前のキー）
+	AIOutputMapping map[string]AIOutputField
+	// Redaction はAIに送信する前にメール内容から個人情報をマスクする設定
+	Redaction RedactionConfig
+	// Spam はメルマガ・自動応答などをインシデントにせず隔離するためのスコアリングの設定
+	Spam SpamConfig
+	// AICacheTTL は同じ内容のメールのAI処理結果を再利用する期間（0で無効）
+	AICacheTTL time.Duration
+	// AIDailyTokenBudget は1日（JST）あたりのトークン上限。超えると受信のみ（queued）になります（0で無制限）
+	AIDailyTokenBudget int
+	// NotificationURL は利用上限などのアラートを送る通知サービスのURL
+	NotificationURL string
+	// CallbackAllowedHosts は完了通知のコールバック先として許可するホスト（.example.com でサブドメインを許可、空の場合はコールバックを受け付けない）
+	CallbackAllowedHosts []string
+	// CallbackSecret は完了通知の署名（X-Autopilot-Signature）に使う鍵（CallbackAllowedHosts を設定する場合は必須）
+	CallbackSecret string
+	// CallbackAllowInsecure はローカル開発用に http やプライベートアドレスへのコールバックを許可します（本番環境では設定不可）
+	CallbackAllowInsecure bool
+	Environment           string
+	ProjectID             string
+	ServiceName           string
+	HoldSenders           []string
+	// IngestionMode は "http"（/receive のみ）または "pubsub"（サブスクリプションからも取り込む）
+	IngestionMode      string
+	PubSubSubscription string
+	PubSubMaxMessages  int
+	// AIQueue は "goroutine"（インスタンス内で実行）または "cloudtasks"
+	AIQueue             string
+	CloudTasksQueue     string
+	TasksTargetURL      string
+	TasksServiceAccount string
+	TasksMaxAttempts    int
+	// AIWorkers/AIWorkerQueueSize はインスタンス内でAI処理を実行する場合の並列数と待ち行列の長さ
+	AIWorkers         int
+	AIWorkerQueueSize int
+	// StaleSweepInterval は pending/running のまま更新が止まったメッセージを定期的に掃除する間隔（0で無効）
+	StaleSweepInterval time.Duration
+	// StaleThreshold はこの時間以上更新のないメッセージを滞留とみなすしきい値
+	StaleThreshold time.Duration
+	// StaleRequeue が true の場合、滞留したメッセージを失敗にした後でAI処理をやり直します
+	StaleRequeue bool
+	// StatusStore は処理状態の保存先で "dbpilot" または "datastore"。
+	// datastore の場合、完了時のデッドレターの解決とインシデントIDの記録はdbpilot側で行われません
+	StatusStore        string
+	DatastoreDatabase  string
+	DatastoreNamespace string
+	// DryRunPercent はAIを呼び出さずにドライランのインシデントを保存するメッセージの割合（0〜100、負荷試験・新しい受信元の確認用）。
+	// リクエストごとに X-Dry-Run ヘッダー（Pub/Subでは dry_run 属性）でも指定できます
+	DryRunPercent int
+	// Threading が true の場合、In-Reply-To・References（または返信の接頭辞付きの件名）で既存のインシデントへの返信と判定したメールを、
+	// 新しいインシデントにせず対応履歴として追加します。ThreadSubjectWindow は件名で返信元を探す期間（0で件名では判定しない）
+	Threading           bool
+	ThreadSubjectWindow time.Duration
+	// MessageEvents が true の場合、メッセージの処理のステップをdbpilotに保存します（MessageEventBuffer は送信待ちの上限）
+	MessageEvents      bool
+	MessageEventBuffer int
+	// ReadinessDBPilotTimeout/ReadinessAITimeout は /ready での依存サービスごとの確認の上限
+	ReadinessDBPilotTimeout time.Duration
+	ReadinessAITimeout      time.Duration
+	ShutdownTimeout         time.Duration
+	ReadTimeout             time.Duration
+	WriteTimeout            time.Duration
+	IdleTimeout             time.Duration
+}
+
+// AIProviderConfig はAIプロバイダー1件の設定です。
+// TokenEnv はトークンを格納した環境変数名で、Secret Managerの参照（sm://）も使えます
+type AIProviderConfig struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	TokenEnv string `json:"token_env"`
+	Priority int    `json:"priority"`
+}
+
+// AIWorkflowConfig はAIに指定するプロンプト/ワークフローのバージョンです。
+// CanaryPercent の割合（メッセージIDで固定）のメッセージだけ CanaryVersion を使い、段階的に切り替えます。
+// LanguageVersions（AI_WORKFLOW_LANGUAGE_VERSIONS、例: en=v3-en）に検出した言語のバージョンがあれば、そちらを優先します
+type AIWorkflowConfig struct {
+	Version          string
+	CanaryVersion    string
+	CanaryPercent    int
+	LanguageVersions map[string]string
+}
+
+// AIShadowConfig は評価中のAIモデル（シャドウ）の設定です。
+// Percent の割合（メッセージIDで固定）のメッセージを本番と並行して処理し、結果は比較用に保存するだけで分類には使いません
+type AIShadowConfig struct {
+	Name            string
+	Endpoint        string
+	TokenEnv        string
+	WorkflowVersion string
+	Percent         int
+}
+
+// AIRetryConfig は1つのAIプロバイダーへの再試行の設定です。
+// 失敗した呼び出しは InitialBackoff から倍々に（MaxBackoff まで）Jitter の割合だけ揺らした時間待って再試行し、
+// MaxAttempts 回失敗すると次のプロバイダーに切り替えます。再試行はタイムアウトと RetryableStatus のHTTPステータスのみで、
+// BudgetRatio はリクエスト数に対する再試行の割合の上限です（障害時に再試行でAIへの負荷を増やさないため、0で無制限）
+type AIRetryConfig struct {
+	MaxAttempts     int
+	InitialBackoff  time.Duration
+	MaxBackoff      time.Duration
+	Jitter          float64
+	BudgetRatio     float64
+	RetryableStatus []int
+}
+
+// AIStreamingConfig はAIワークフローのストリーミング応答の設定です。
+// 有効な場合は途中結果（件名・判定など）を処理状態に保存し、IdleTimeout の間イベントが届かない場合だけ停止とみなします。
+// 進行中の処理は MaxDuration まで待ちます（ストリーミングなしの場合は90秒で打ち切り）
+type AIStreamingConfig struct {
+	Enabled     bool
+	IdleTimeout time.Duration
+	MaxDuration time.Duration
+}
+
+// AIOutputField はインシデントの項目（subject・priority など）に対応付けるワークフローの出力です。
+// From のキーを順に見て、値が空でない最初のものを使います。どれもない場合は Default を使います。
+// 例: {"priority": {"from": ["severity", "priority"], "default": "low"}}
+type AIOutputField struct {
+	From    []string `json:"from"`
+	Default string   `json:"default,omitempty"`
+}
+
+// RedactionConfig はAIに送信する件名・送信者・本文のマスク設定です。
+// メールアドレス・電話番号・IPアドレスは組み込みで、顧客IDなどは Patterns（PII_REDACTION_PATTERNS）で追加します。
+// Allowlist（PII_REDACTION_ALLOWLIST）の値、または @ドメイン に一致するメールアドレスはマスクしません
+type RedactionConfig struct {
+	Enabled   bool
+	Patterns  []RedactionPattern
+	Allowlist []string
+}
+
+// RedactionPattern は追加のマスク対象（正規表現）です。Name はマスク後の文字列 [REDACTED:name] に使われます
+type RedactionPattern struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// SpamConfig は受信したメールのスパム・ノイズ判定の設定です。
+// 組み込みのルール（自動応答・一括配信のヘッダー、不在通知の件名）と Rules（SPAM_RULES）のスコアの合計に、
+// FilterURL（SPAM_FILTER_URL）を設定した場合は外部フィルターのスコアを加え、Threshold 以上のメールを隔離します
+type SpamConfig struct {
+	Enabled       bool
+	Threshold     float64
+	Rules         []SpamRule
+	FilterURL     string
+	FilterTimeout time.Duration
+}
+
+// SpamRule は追加のスコアリングのルールです。Field は subject・from・body、または header:名前 で、
+// Pattern（正規表現）に一致した場合に Score を加えます
+type SpamRule struct {
+	Name    string  `json:"name"`
+	Field   string  `json:"field"`
+	Pattern string  `json:"pattern"`
+	Score   float64 `json:"score"`
 }
 
 // InitConfig は環境設定を初期化します
@@ -45,31 +212,203 @@ func InitConfig() (*ServerConfig, error) {
 	ginMode := initGinMode()
 
 	config := &ServerConfig{
-		Port:            getEnv("SERVER_PORT", "8080"),
-		GinMode:         ginMode,
-		LogLevel:        logLevel,
-		DBPilotURL:      getEnv("DBPILOT_URL", ""),
-		ServiceToken:    getEnv("SERVICE_TOKEN", ""),
-		AIEndpoint:      getEnv("ENDPOINT", ""),
-		AIToken:         getEnv("TOKEN", ""),
-		Environment:     getEnv("ENVIRONMENT", "development"),
-		ProjectID:       getEnv("GOOGLE_CLOUD_PROJECT", ""),
-		ServiceName:     getEnv("K_SERVICE", "auto-service"),
-		ShutdownTimeout: getDuration("SHUTDOWN_TIMEOUT", 10*time.Second),
-		ReadTimeout:     getDuration("HTTP_READ_TIMEOUT", 15*time.Second),
-		WriteTimeout:    getDuration("HTTP_WRITE_TIMEOUT", 15*time.Second),
-		IdleTimeout:     getDuration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+		Port:         getEnv("SERVER_PORT", "8080"),
+		GinMode:      ginMode,
+		LogLevel:     logLevel,
+		DBPilotURL:   getEnv("DBPILOT_URL", ""),
+		ServiceToken: serviceauth.PrimaryServiceToken(),
+		AIEndpoint:   getEnv("ENDPOINT", ""),
+		AIToken:      secrets.Get("TOKEN"),
+		Environment:  getEnv("ENVIRONMENT", "development"),
+		ProjectID:    getEnv("GOOGLE_CLOUD_PROJECT", ""),
+		ServiceName:  getEnv("K_SERVICE", "auto-service"),
+		AIWorkflow: AIWorkflowConfig{
+			Version:       getEnv("AI_WORKFLOW_VERSION", ""),
+			CanaryVersion: getEnv("AI_WORKFLOW_CANARY_VERSION", ""),
+			CanaryPercent: getInt("AI_WORKFLOW_CANARY_PERCENT", 0),
+		},
+		AIShadow: AIShadowConfig{
+			Name:            getEnv("AI_SHADOW_NAME", "shadow"),
+			Endpoint:        getEnv("AI_SHADOW_ENDPOINT", ""),
+			TokenEnv:        getEnv("AI_SHADOW_TOKEN_ENV", "AI_SHADOW_TOKEN"),
+			WorkflowVersion: getEnv("AI_SHADOW_WORKFLOW_VERSION", ""),
+			Percent:         getInt("AI_SHADOW_PERCENT", 10),
+		},
+		AIRetry: AIRetryConfig{
+			MaxAttempts:    getInt("AI_RETRY_MAX_ATTEMPTS", 2),
+			InitialBackoff: getDuration("AI_RETRY_INITIAL_BACKOFF", time.Second),
+			MaxBackoff:     getDuration("AI_RETRY_MAX_BACKOFF", 10*time.Second),
+			Jitter:         getFloat("AI_RETRY_JITTER", 0.5),
+			BudgetRatio:    getFloat("AI_RETRY_BUDGET_RATIO", 0.2),
+		},
+		AIStreaming: AIStreamingConfig{
+			Enabled:     strings.EqualFold(getEnv("AI_STREAMING", "false"), "true"),
+			IdleTimeout: getDuration("AI_STREAM_IDLE_TIMEOUT", 30*time.Second),
+			MaxDuration: getDuration("AI_STREAM_MAX_DURATION", 5*time.Minute),
+		},
+		AIBreakerThreshold:      getInt("AI_BREAKER_THRESHOLD", 5),
+		AIBreakerCooldown:       getDuration("AI_BREAKER_COOLDOWN", 30*time.Second),
+		AICacheTTL:              getDuration("AI_CACHE_TTL", 0),
+		AIDailyTokenBudget:      getInt("AI_DAILY_TOKEN_BUDGET", 0),
+		NotificationURL:         getEnv("NOTIFICATION_SERVICE_URL", ""),
+		CallbackAllowedHosts:    getList("CALLBACK_ALLOWED_HOSTS"),
+		CallbackSecret:          secrets.Get("CALLBACK_SECRET"),
+		CallbackAllowInsecure:   strings.EqualFold(getEnv("CALLBACK_ALLOW_INSECURE", "false"), "true"),
+		HoldSenders:             getList("HOLD_SENDERS"),
+		IngestionMode:           strings.ToLower(getEnv("INGESTION_MODE", "http")),
+		PubSubSubscription:      getEnv("PUBSUB_SUBSCRIPTION", ""),
+		PubSubMaxMessages:       getInt("PUBSUB_MAX_MESSAGES", 10),
+		AIQueue:                 strings.ToLower(getEnv("AI_QUEUE", "goroutine")),
+		CloudTasksQueue:         getEnv("CLOUD_TASKS_QUEUE", ""),
+		TasksTargetURL:          getEnv("TASKS_TARGET_URL", ""),
+		TasksServiceAccount:     getEnv("TASKS_SERVICE_ACCOUNT", ""),
+		TasksMaxAttempts:        getInt("TASKS_MAX_ATTEMPTS", 5),
+		AIWorkers:               getInt("AI_WORKERS", 10),
+		AIWorkerQueueSize:       getInt("AI_WORKER_QUEUE_SIZE", 100),
+		StaleSweepInterval:      getDuration("STALE_SWEEP_INTERVAL", 0),
+		StaleThreshold:          getDuration("STALE_THRESHOLD", 15*time.Minute),
+		StaleRequeue:            strings.EqualFold(getEnv("STALE_REQUEUE", "false"), "true"),
+		StatusStore:             strings.ToLower(getEnv("STATUS_STORE", "dbpilot")),
+		DryRunPercent:           getInt("DRY_RUN_PERCENT", 0),
+		Threading:               !strings.EqualFold(getEnv("THREADING", "true"), "false"),
+		ThreadSubjectWindow:     getDuration("THREAD_SUBJECT_WINDOW", 72*time.Hour),
+		MessageEvents:           !strings.EqualFold(getEnv("MESSAGE_EVENTS", "true"), "false"),
+		MessageEventBuffer:      getInt("MESSAGE_EVENT_BUFFER", 1000),
+		DatastoreDatabase:       getEnv("DATASTORE_DATABASE", ""),
+		DatastoreNamespace:      getEnv("DATASTORE_NAMESPACE", ""),
+		ReadinessDBPilotTimeout: getDuration("READINESS_DBPILOT_TIMEOUT", 2*time.Second),
+		ReadinessAITimeout:      getDuration("READINESS_AI_TIMEOUT", 3*time.Second),
+		ShutdownTimeout:         getDuration("SHUTDOWN_TIMEOUT", 10*time.Second),
+		ReadTimeout:             getDuration("HTTP_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:            getDuration("HTTP_WRITE_TIMEOUT", 15*time.Second),
+		IdleTimeout:             getDuration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+	}
+
+	providers, err := loadAIProviders(config.AIEndpoint)
+	if err != nil {
+		return config, err
+	}
+	config.AIProviders = providers
+
+	retryableStatus, err := getIntList("AI_RETRY_STATUS_CODES", []int{429, 500, 502, 503, 504})
+	if err != nil {
+		return config, err
 	}
+	config.AIRetry.RetryableStatus = retryableStatus
+
+	languageVersions, err := getMap("AI_WORKFLOW_LANGUAGE_VERSIONS")
+	if err != nil {
+		return config, err
+	}
+	config.AIWorkflow.LanguageVersions = languageVersions
+
+	if raw := os.Getenv("AI_OUTPUT_MAPPING"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &config.AIOutputMapping); err != nil {
+			return config, fmt.Errorf("invalid AI_OUTPUT_MAPPING: %v", err)
+		}
+	}
+
+	redaction, err := loadRedaction()
+	if err != nil {
+		return config, err
+	}
+	config.Redaction = redaction
+
+	spam, err := loadSpam()
+	if err != nil {
+		return config, err
+	}
+	config.Spam = spam
 
 	return config, config.Validate()
 }
 
+// loadSpam はスパム・ノイズ判定の設定を読み込みます。無効化（SPAM_SCORING=false）しない限り有効です
+func loadSpam() (SpamConfig, error) {
+	spam := SpamConfig{
+		Enabled:       !strings.EqualFold(getEnv("SPAM_SCORING", "true"), "false"),
+		Threshold:     getFloat("SPAM_THRESHOLD", 5),
+		FilterURL:     getEnv("SPAM_FILTER_URL", ""),
+		FilterTimeout: getDuration("SPAM_FILTER_TIMEOUT", 3*time.Second),
+	}
+
+	if raw := os.Getenv("SPAM_RULES"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &spam.Rules); err != nil {
+			return spam, fmt.Errorf("invalid SPAM_RULES: %v", err)
+		}
+	}
+	for i, rule := range spam.Rules {
+		if rule.Name == "" || rule.Pattern == "" {
+			return spam, fmt.Errorf("SPAM_RULES[%d]: name and pattern are required", i)
+		}
+		switch field := strings.ToLower(rule.Field); {
+		case field == "subject", field == "from", field == "body":
+		case strings.HasPrefix(field, "header:") && len(field) > len("header:"):
+		default:
+			return spam, fmt.Errorf("SPAM_RULES[%d]: field must be subject, from, body or header:<name>", i)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return spam, fmt.Errorf("SPAM_RULES[%d]: %v", i, err)
+		}
+	}
+	if spam.Enabled && spam.Threshold <= 0 {
+		return spam, fmt.Errorf("SPAM_THRESHOLD must be positive")
+	}
+	if spam.FilterTimeout <= 0 {
+		return spam, fmt.Errorf("SPAM_FILTER_TIMEOUT must be positive")
+	}
+	return spam, nil
+}
+
+// loadRedaction は個人情報のマスク設定を読み込みます。無効化（PII_REDACTION=false）しない限り有効です
+func loadRedaction() (RedactionConfig, error) {
+	redaction := RedactionConfig{
+		Enabled:   !strings.EqualFold(getEnv("PII_REDACTION", "true"), "false"),
+		Allowlist: getList("PII_REDACTION_ALLOWLIST"),
+	}
+
+	if raw := os.Getenv("PII_REDACTION_PATTERNS"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &redaction.Patterns); err != nil {
+			return redaction, fmt.Errorf("invalid PII_REDACTION_PATTERNS: %v", err)
+		}
+	}
+	for i, pattern := range redaction.Patterns {
+		if pattern.Name == "" || pattern.Pattern == "" {
+			return redaction, fmt.Errorf("PII_REDACTION_PATTERNS[%d]: name and pattern are required", i)
+		}
+		if _, err := regexp.Compile(pattern.Pattern); err != nil {
+			return redaction, fmt.Errorf("PII_REDACTION_PATTERNS[%d]: %v", i, err)
+		}
+	}
+	return redaction, nil
+}
+
+// loadAIProviders はAI_PROVIDERS（JSON配列）からプロバイダーを読み込み、priority の昇順に並べます
+func loadAIProviders(defaultEndpoint string) ([]AIProviderConfig, error) {
+	raw := os.Getenv("AI_PROVIDERS")
+	if raw == "" {
+		if defaultEndpoint == "" {
+			return nil, nil
+		}
+		return []AIProviderConfig{{Name: "default", Endpoint: defaultEndpoint, TokenEnv: "TOKEN"}}, nil
+	}
+
+	var providers []AIProviderConfig
+	if err := json.Unmarshal([]byte(raw), &providers); err != nil {
+		return nil, fmt.Errorf("invalid AI_PROVIDERS: %v", err)
+	}
+	sort.SliceStable(providers, func(i, j int) bool {
+		return providers[i].Priority < providers[j].Priority
+	})
+	return providers, nil
+}
+
 // SetupServer はサーバーの設定を行います
 func SetupServer(r *gin.Engine) *http.Server {
 	config, _ := InitConfig()
 	displayServerConfig(r, config)
 
-	return &http.Server{
+	srv := &http.Server{
 		Addr:              ":" + config.Port,
 		Handler:           r,
 		ReadTimeout:       config.ReadTimeout,
@@ -77,6 +416,15 @@ func SetupServer(r *gin.Engine) *http.Server {
 		IdleTimeout:       config.IdleTimeout,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
+
+	// 相互TLS（MTLS_CERT_FILE/MTLS_KEY_FILE が設定されている場合のみ）
+	tlsConfig, err := mtls.ServerConfig()
+	if err != nil {
+		logger.Logger.Fatal("mTLSの設定に失敗しました", zap.Error(err))
+	}
+	srv.TLSConfig = tlsConfig
+
+	return srv
 }
 
 func initLogLevel() zapcore.Level {
@@ -106,6 +454,69 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getList はカンマ区切りの環境変数を小文字化したリストとして取得します
+func getList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+func getFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+// getIntList はカンマ区切りの整数のリストを取得します（未設定の場合は defaultValue）
+func getIntList(key string, defaultValue []int) ([]int, error) {
+	values := getList(key)
+	if len(values) == 0 {
+		return defaultValue, nil
+	}
+	result := make([]int, 0, len(values))
+	for _, value := range values {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		result = append(result, i)
+	}
+	return result, nil
+}
+
+// getMap は key=value のカンマ区切りのリストを取得します。キーは小文字にし、値は大文字・小文字を保持します
+func getMap(key string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("invalid %s: %q must be key=value", key, entry)
+		}
+		result[strings.ToLower(name)] = value
+	}
+	return result, nil
+}
+
 func getDuration(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -115,12 +526,13 @@ func getDuration(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
+// MinStaleThreshold は滞留判定のしきい値の下限です。AI処理のタイムアウトより短いと処理中のメッセージを誤って失敗にします
+const MinStaleThreshold = 2 * time.Minute
+
 func (c *ServerConfig) Validate() error {
 	required := map[string]string{
 		"DBPilotURL":   c.DBPilotURL,
 		"ServiceToken": c.ServiceToken,
-		"AIEndpoint":   c.AIEndpoint,
-		"AIToken":      c.AIToken,
 	}
 
 	for name, value := range required {
@@ -129,6 +541,102 @@ func (c *ServerConfig) Validate() error {
 		}
 	}
 
+	if len(c.AIProviders) == 0 {
+		return fmt.Errorf("AIEndpoint or AI_PROVIDERS is required")
+	}
+	names := make(map[string]bool)
+	for i, provider := range c.AIProviders {
+		if provider.Name == "" || provider.Endpoint == "" || provider.TokenEnv == "" {
+			return fmt.Errorf("AI_PROVIDERS[%d]: name, endpoint and token_env are required", i)
+		}
+		if names[provider.Name] {
+			return fmt.Errorf("AI_PROVIDERS: duplicate provider name %s", provider.Name)
+		}
+		names[provider.Name] = true
+		if secrets.Get(provider.TokenEnv) == "" {
+			return fmt.Errorf("%s is required for AI provider %s", provider.TokenEnv, provider.Name)
+		}
+	}
+
+	if c.AIWorkflow.CanaryPercent < 0 || c.AIWorkflow.CanaryPercent > 100 {
+		return fmt.Errorf("AI_WORKFLOW_CANARY_PERCENT must be between 0 and 100")
+	}
+	if c.AIWorkflow.CanaryPercent > 0 && c.AIWorkflow.CanaryVersion == "" {
+		return fmt.Errorf("AI_WORKFLOW_CANARY_VERSION is required when AI_WORKFLOW_CANARY_PERCENT is set")
+	}
+
+	if c.AIRetry.MaxAttempts < 1 {
+		return fmt.Errorf("AI_RETRY_MAX_ATTEMPTS must be at least 1")
+	}
+	if c.AIRetry.Jitter < 0 || c.AIRetry.Jitter > 1 {
+		return fmt.Errorf("AI_RETRY_JITTER must be between 0 and 1")
+	}
+	if c.AIRetry.BudgetRatio < 0 {
+		return fmt.Errorf("AI_RETRY_BUDGET_RATIO must not be negative")
+	}
+
+	if c.AIStreaming.Enabled && (c.AIStreaming.IdleTimeout <= 0 || c.AIStreaming.MaxDuration < c.AIStreaming.IdleTimeout) {
+		return fmt.Errorf("AI_STREAM_IDLE_TIMEOUT must be positive and not longer than AI_STREAM_MAX_DURATION")
+	}
+	if c.MessageEvents && c.MessageEventBuffer <= 0 {
+		return fmt.Errorf("MESSAGE_EVENT_BUFFER must be positive")
+	}
+	if c.DryRunPercent < 0 || c.DryRunPercent > 100 {
+		return fmt.Errorf("DRY_RUN_PERCENT must be between 0 and 100")
+	}
+	if c.ReadinessDBPilotTimeout <= 0 || c.ReadinessAITimeout <= 0 {
+		return fmt.Errorf("READINESS_DBPILOT_TIMEOUT and READINESS_AI_TIMEOUT must be positive")
+	}
+	if c.AIStreaming.Enabled && c.StaleThreshold <= c.AIStreaming.MaxDuration {
+		return fmt.Errorf("STALE_THRESHOLD must be longer than AI_STREAM_MAX_DURATION")
+	}
+
+	if c.AIShadow.Endpoint != "" {
+		if c.AIShadow.Percent < 0 || c.AIShadow.Percent > 100 {
+			return fmt.Errorf("AI_SHADOW_PERCENT must be between 0 and 100")
+		}
+		if secrets.Get(c.AIShadow.TokenEnv) == "" {
+			return fmt.Errorf("%s is required for the shadow AI endpoint", c.AIShadow.TokenEnv)
+		}
+	}
+
+	if len(c.CallbackAllowedHosts) > 0 && c.CallbackSecret == "" {
+		return fmt.Errorf("CALLBACK_SECRET is required when CALLBACK_ALLOWED_HOSTS is set")
+	}
+	if c.CallbackAllowInsecure && c.Environment == "production" {
+		return fmt.Errorf("CALLBACK_ALLOW_INSECURE must not be set in production")
+	}
+
+	if c.StaleThreshold < MinStaleThreshold {
+		return fmt.Errorf("STALE_THRESHOLD must be at least %s", MinStaleThreshold)
+	}
+
+	switch c.IngestionMode {
+	case "http":
+	case "pubsub":
+		if c.PubSubSubscription == "" {
+			return fmt.Errorf("PubSubSubscription is required when INGESTION_MODE is pubsub")
+		}
+	default:
+		return fmt.Errorf("unknown INGESTION_MODE: %s", c.IngestionMode)
+	}
+
+	switch c.AIQueue {
+	case "goroutine":
+	case "cloudtasks":
+		if c.CloudTasksQueue == "" || c.TasksTargetURL == "" {
+			return fmt.Errorf("CloudTasksQueue and TasksTargetURL are required when AI_QUEUE is cloudtasks")
+		}
+	default:
+		return fmt.Errorf("unknown AI_QUEUE: %s", c.AIQueue)
+	}
+
+	switch c.StatusStore {
+	case "dbpilot", "datastore":
+	default:
+		return fmt.Errorf("unknown STATUS_STORE: %s", c.StatusStore)
+	}
+
 	return nil
 }
 
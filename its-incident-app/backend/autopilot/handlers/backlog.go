@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/models"
+	"autopilot/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// defaultBacklogLimit は処理状態の一覧で返す件数の既定値
+const defaultBacklogLimit = 100
+
+// backlogStatuses は status を省略した場合に一覧する、処理が終わっていない・失敗したメッセージの状態
+var backlogStatuses = []models.ProcessStatus{
+	models.StatusPending,
+	models.StatusRunning,
+	models.StatusFailed,
+	models.StatusRequeue,
+	models.StatusQueued,
+	models.StatusHeld,
+	models.StatusQuarantined,
+}
+
+// BacklogItem は処理状態の一覧の1件です。AgeSeconds は受信からの経過時間です
+type BacklogItem struct {
+	models.ProcessingStatus
+	AgeSeconds int64 `json:"age_seconds"`
+}
+
+// HandleListStatus は処理中・失敗などのメッセージの一覧と状態ごとの件数を返します。
+// status（カンマ区切り）・older_than（受信からの経過時間、例: 30m）・stale_for（最終更新からの経過時間）・sender（部分一致）・limit で絞り込めます
+func (h *EmailHandler) HandleListStatus(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "HandleListStatus"),
+	}
+
+	filter := models.StatusFilter{
+		Statuses: backlogStatuses,
+		Sender:   c.Query("sender"),
+		Limit:    defaultBacklogLimit,
+	}
+
+	if raw := c.Query("status"); raw != "" {
+		filter.Statuses = nil
+		for _, value := range strings.Split(raw, ",") {
+			status := models.ProcessStatus(strings.TrimSpace(value))
+			if !knownStatus(status) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown status", "status": status})
+				return
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	now := time.Now()
+	for param, target := range map[string]*time.Time{
+		"older_than": &filter.To,
+		"stale_for":  &filter.UpdatedBefore,
+	} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be a positive duration (e.g. 30m)"})
+			return
+		}
+		*target = now.Add(-d)
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxBatchLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxBatchLimit)})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	list, err := h.statusStore.SummarizeProcessingStatuses(filter)
+	if errors.Is(err, services.ErrUnsupportedFilter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Logger.Error("処理状態一覧の取得に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to list processing statuses"})
+		return
+	}
+
+	items := make([]BacklogItem, 0, len(list.Data))
+	for _, status := range list.Data {
+		item := BacklogItem{ProcessingStatus: status}
+		if !status.CreatedAt.IsZero() {
+			item.AgeSeconds = int64(now.Sub(status.CreatedAt).Seconds())
+		}
+		items = append(items, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count":     len(items),
+		"total":     list.Total,
+		"by_status": list.ByStatus,
+		"data":      items,
+	})
+}
+
+// knownStatus は処理状態として定義された値かを返します
+func knownStatus(status models.ProcessStatus) bool {
+	switch status {
+	case models.StatusPending, models.StatusRunning, models.StatusComplete, models.StatusFailed,
+		models.StatusHeld, models.StatusRejected, models.StatusRequeue, models.StatusQueued, models.StatusQuarantined:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"autopilot/models"
+	"autopilot/services"
+	"autopilot/services/fake"
+)
+
+type backlogResponse struct {
+	Count    int                            `json:"count"`
+	Total    int64                          `json:"total"`
+	ByStatus map[models.ProcessStatus]int64 `json:"by_status"`
+	Data     []BacklogItem                  `json:"data"`
+}
+
+// listBacklog は GET /status?query の結果と、一覧に含まれるメッセージIDを返します
+func listBacklog(t *testing.T, h *EmailHandler, query string) (backlogResponse, string) {
+	t.Helper()
+	w := serve(http.MethodGet, "/status?"+query, "/status", h.HandleListStatus)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /status?%s: status = %d, body = %s", query, w.Code, w.Body.String())
+	}
+	var body backlogResponse
+	json.Unmarshal(w.Body.Bytes(), &body)
+
+	ids := make([]string, 0, len(body.Data))
+	for _, item := range body.Data {
+		ids = append(ids, item.MessageID)
+	}
+	sort.Strings(ids)
+	return body, strings.Join(ids, ",")
+}
+
+func TestHandleListStatus(t *testing.T) {
+	h, db := newTestEmailHandler(t, &fake.AI{})
+	seedTestMessage(t, db, "pending", models.StatusPending, time.Minute)
+	seedTestMessage(t, db, "stuck", models.StatusRunning, time.Hour)
+	seedTestMessage(t, db, "failed", models.StatusFailed, time.Minute)
+	seedTestMessage(t, db, "complete", models.StatusComplete, time.Minute)
+	other := testEmail()
+	other.From = "alerts@other.example.com"
+	db.SaveEmail(other, "other-sender")
+	db.UpdateProcessingStatus(&models.ProcessingStatus{MessageID: "other-sender", Status: models.StatusFailed})
+
+	// 既定では処理が終わっていない・失敗したメッセージだけを一覧する
+	body, ids := listBacklog(t, h, "")
+	if ids != "failed,other-sender,pending,stuck" || body.Total != 4 || body.ByStatus[models.StatusFailed] != 2 {
+		t.Errorf("default list = %s, total = %d, by_status = %v", ids, body.Total, body.ByStatus)
+	}
+
+	for query, want := range map[string]string{
+		"status=complete":               "complete",
+		"status=failed,+running":        "failed,other-sender,stuck",
+		"sender=OTHER.example":          "other-sender",
+		"stale_for=30m":                 "stuck",
+		"status=failed&sender=monitor@": "failed",
+	} {
+		if _, ids := listBacklog(t, h, query); ids != want {
+			t.Errorf("%s: list = %s, want %s", query, ids, want)
+		}
+	}
+
+	if body, _ := listBacklog(t, h, "limit=1"); body.Count != 1 || body.Total != 4 {
+		t.Errorf("limit=1: count = %d, total = %d, want 1 of 4", body.Count, body.Total)
+	}
+}
+
+func TestHandleListStatusRejectsInvalidQuery(t *testing.T) {
+	h, db := newTestEmailHandler(t, &fake.AI{})
+
+	for _, query := range []string{"status=done", "older_than=soon", "stale_for=-1m", "limit=0", fmt.Sprintf("limit=%d", maxBatchLimit+1)} {
+		if w := serve(http.MethodGet, "/status?"+query, "/status", h.HandleListStatus); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	// 保存先が対応していない条件は400、保存先のエラーは502
+	db.FailOn("SummarizeProcessingStatuses", fmt.Errorf("%w: sender", services.ErrUnsupportedFilter))
+	if w := serve(http.MethodGet, "/status?sender=monitor", "/status", h.HandleListStatus); w.Code != http.StatusBadRequest {
+		t.Errorf("unsupported filter status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	db.FailOn("SummarizeProcessingStatuses", errors.New("dbpilot unavailable"))
+	if w := serve(http.MethodGet, "/status", "/status", h.HandleListStatus); w.Code != http.StatusBadGateway {
+		t.Errorf("failing store status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBatchConcurrency = 5
+	maxBatchConcurrency     = 20
+	defaultBatchLimit       = 500
+	maxBatchLimit           = 1000
+	// maxBatchJobHistory は進捗を保持するバッチジョブの件数
+	maxBatchJobHistory = 20
+	// maxBatchJobErrors は進捗に含めるメッセージごとのエラーの件数
+	maxBatchJobErrors = 100
+)
+
+// BatchReprocessRequest は一括再処理の対象を指定するリクエストです。
+// statuses と stuck_after を省略した場合は failed と requeue のメッセージを対象にします
+type BatchReprocessRequest struct {
+	From     time.Time              `json:"from"` // 作成日時がこの日時以降（RFC3339）
+	To       time.Time              `json:"to"`   // 作成日時がこの日時より前（RFC3339）
+	Statuses []models.ProcessStatus `json:"statuses"`
+	// StuckAfter を指定すると、この時間（例: 30m）以上更新のない pending/running のメッセージも対象にします
+	StuckAfter  string `json:"stuck_after"`
+	Concurrency int    `json:"concurrency"`
+	Limit       int    `json:"limit"`
+}
+
+// BatchJobProgress は一括再処理ジョブの進捗です
+type BatchJobProgress struct {
+	JobID       string            `json:"job_id"`
+	State       string            `json:"state"` // running / completed
+	Total       int               `json:"total"`
+	Enqueued    int               `json:"enqueued"`  // Cloud Tasksに登録した件数
+	Completed   int               `json:"completed"` // インスタンス内で処理が完了した件数
+	Failed      int               `json:"failed"`
+	Concurrency int               `json:"concurrency"`
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  *time.Time        `json:"finished_at,omitempty"`
+	Errors      map[string]string `json:"errors,omitempty"`
+}
+
+type batchJob struct {
+	mu       sync.Mutex
+	progress BatchJobProgress
+}
+
+func (j *batchJob) snapshot() BatchJobProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	progress := j.progress
+	progress.Errors = make(map[string]string, len(j.progress.Errors))
+	for messageID, message := range j.progress.Errors {
+		progress.Errors[messageID] = message
+	}
+	return progress
+}
+
+func (j *batchJob) record(messageID string, enqueued bool, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch {
+	case err != nil:
+		j.progress.Failed++
+		if len(j.progress.Errors) < maxBatchJobErrors {
+			j.progress.Errors[messageID] = err.Error()
+		}
+	case enqueued:
+		j.progress.Enqueued++
+	default:
+		j.progress.Completed++
+	}
+}
+
+func (j *batchJob) finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	j.progress.State = "completed"
+	j.progress.FinishedAt = &now
+}
+
+// batchJobRegistry は一括再処理ジョブの進捗をメモリ上に保持します（インスタンスごと）
+type batchJobRegistry struct {
+	mu    sync.Mutex
+	jobs  map[string]*batchJob
+	order []string
+}
+
+func newBatchJobRegistry() *batchJobRegistry {
+	return &batchJobRegistry{jobs: make(map[string]*batchJob)}
+}
+
+// start は新しいジョブを登録します。実行中のジョブがある場合は nil を返します
+func (r *batchJobRegistry) start(total, concurrency int) *batchJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, job := range r.jobs {
+		if job.snapshot().State == "running" {
+			return nil
+		}
+	}
+
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	job := &batchJob{progress: BatchJobProgress{
+		JobID:       hex.EncodeToString(id),
+		State:       "running",
+		Total:       total,
+		Concurrency: concurrency,
+		StartedAt:   time.Now(),
+		Errors:      make(map[string]string),
+	}}
+
+	r.jobs[job.progress.JobID] = job
+	r.order = append(r.order, job.progress.JobID)
+	if len(r.order) > maxBatchJobHistory {
+		delete(r.jobs, r.order[0])
+		r.order = r.order[1:]
+	}
+	return job
+}
+
+func (r *batchJobRegistry) get(jobID string) (*batchJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[jobID]
+	return job, ok
+}
+
+// HandleBatchReprocess は期間・状態で絞り込んだ失敗または滞留中のメッセージを一括で再処理します。
+// 処理はバックグラウンドで並列数を制限して実行し、進捗は GET /reprocess/batch/:jobID で確認できます
+func (h *EmailHandler) HandleBatchReprocess(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "HandleBatchReprocess"),
+	}
+
+	var req BatchReprocessRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+			return
+		}
+	}
+
+	for _, status := range req.Statuses {
+		switch status {
+		case models.StatusFailed, models.StatusRequeue, models.StatusQueued, models.StatusPending, models.StatusRunning:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":  "Only failed, requeue, queued, pending and running messages can be reprocessed",
+				"status": status,
+			})
+			return
+		}
+	}
+	if len(req.Statuses) == 0 && req.StuckAfter == "" {
+		req.Statuses = []models.ProcessStatus{models.StatusFailed, models.StatusRequeue}
+	}
+
+	var stuckAfter time.Duration
+	if req.StuckAfter != "" {
+		d, err := time.ParseDuration(req.StuckAfter)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "stuck_after must be a positive duration (e.g. 30m)"})
+			return
+		}
+		stuckAfter = d
+	}
+
+	if req.Concurrency <= 0 {
+		req.Concurrency = defaultBatchConcurrency
+	}
+	if req.Concurrency > maxBatchConcurrency {
+		req.Concurrency = maxBatchConcurrency
+	}
+	if req.Limit <= 0 || req.Limit > maxBatchLimit {
+		req.Limit = defaultBatchLimit
+	}
+
+	messageIDs, err := h.findReprocessTargets(req, stuckAfter)
+	if err != nil {
+		logger.Logger.Error("再処理対象の取得に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list processing statuses"})
+		return
+	}
+
+	job := h.batchJobs.start(len(messageIDs), req.Concurrency)
+	if job == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another batch reprocess job is running"})
+		return
+	}
+	progress := job.snapshot()
+	logFields = append(logFields,
+		zap.String("job_id", progress.JobID),
+		zap.Int("total", progress.Total),
+		zap.Int("concurrency", req.Concurrency))
+
+	logger.Logger.Info("一括再処理を開始します", logFields...)
+
+	go h.runBatchReprocess(job, messageIDs, req.Concurrency, logFields)
+
+	c.JSON(http.StatusAccepted, progress)
+}
+
+// HandleBatchReprocessStatus は一括再処理ジョブの進捗を返します
+func (h *EmailHandler) HandleBatchReprocessStatus(c *gin.Context) {
+	job, ok := h.batchJobs.get(c.Param("jobID"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Batch job not found", "job_id": c.Param("jobID")})
+		return
+	}
+	c.JSON(http.StatusOK, job.snapshot())
+}
+
+// findReprocessTargets は条件に一致するメッセージIDを重複なく返します
+func (h *EmailHandler) findReprocessTargets(req BatchReprocessRequest, stuckAfter time.Duration) ([]string, error) {
+	filters := []models.StatusFilter{}
+	if len(req.Statuses) > 0 {
+		filters = append(filters, models.StatusFilter{
+			Statuses: req.Statuses,
+			From:     req.From,
+			To:       req.To,
+			Limit:    req.Limit,
+		})
+	}
+	if stuckAfter > 0 {
+		filters = append(filters, models.StatusFilter{
+			Statuses:      []models.ProcessStatus{models.StatusPending, models.StatusRunning},
+			From:          req.From,
+			To:            req.To,
+			UpdatedBefore: time.Now().Add(-stuckAfter),
+			Limit:         req.Limit,
+		})
+	}
+
+	seen := make(map[string]bool)
+	var messageIDs []string
+	for _, filter := range filters {
+		statuses, err := h.statusStore.SearchProcessingStatuses(filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, status := range statuses {
+			if seen[status.MessageID] || len(messageIDs) >= req.Limit {
+				continue
+			}
+			seen[status.MessageID] = true
+			messageIDs = append(messageIDs, status.MessageID)
+		}
+	}
+	return messageIDs, nil
+}
+
+// runBatchReprocess は最大 concurrency 件ずつメッセージを再処理します。
+// Cloud Tasksを使う場合はタスクの登録まで、使わない場合はAI処理の完了までを1件の処理とします
+func (h *EmailHandler) runBatchReprocess(job *batchJob, messageIDs []string, concurrency int, logFields []zap.Field) {
+	jobID := job.snapshot().JobID
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for _, messageID := range messageIDs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(messageID string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			enqueued, err := h.reprocessMessage(messageID, "batch-"+jobID)
+			job.record(messageID, enqueued, err)
+		}(messageID)
+	}
+
+	wg.Wait()
+	job.finish()
+
+	progress := job.snapshot()
+	logger.Logger.Info("一括再処理が完了しました",
+		append(logFields,
+			zap.Int("enqueued", progress.Enqueued),
+			zap.Int("completed", progress.Completed),
+			zap.Int("failed", progress.Failed))...)
+}
+
+// reprocessMessage は保存済みのメールデータでAI処理をやり直します。タスクキューに登録した場合は true を返します
+func (h *EmailHandler) reprocessMessage(messageID, dedupKey string) (bool, error) {
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("process", "batch_reprocess"),
+	}
+
+	emailData, err := h.dbpilotService.GetEmail(messageID)
+	if err != nil {
+		logger.Logger.Error("再処理するメールデータの取得に失敗しました", append(logFields, zap.Error(err))...)
+		return false, err
+	}
+
+	if err := h.statusStore.UpdateProcessingStatus(models.NewProcessingStatus(messageID)); err != nil {
+		logger.Logger.Error("処理状態の更新に失敗しました", append(logFields, zap.Error(err))...)
+		return false, err
+	}
+
+	if h.taskQueue != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		err := h.taskQueue.EnqueueAIProcessing(ctx, messageID, dedupKey)
+		if err == nil {
+			return true, nil
+		}
+		logger.Logger.Error("AI処理タスクの登録に失敗したためインスタンス内で処理します",
+			append(logFields, zap.Error(err))...)
+	}
+
+	return false, h.processEmailAsync(h.workers.Context(), messageID, emailData, logFields)
+}
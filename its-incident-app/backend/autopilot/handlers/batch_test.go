@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"autopilot/models"
+	"autopilot/services/fake"
+)
+
+// startBatch は一括再処理を開始し、ジョブの進捗を返します
+func startBatch(t *testing.T, h *EmailHandler, body string) BatchJobProgress {
+	t.Helper()
+	w := serveJSON(http.MethodPost, "/reprocess/batch", "/reprocess/batch", body, h.HandleBatchReprocess)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("batch status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var progress BatchJobProgress
+	json.Unmarshal(w.Body.Bytes(), &progress)
+	return progress
+}
+
+// waitForBatch はジョブが完了するまで進捗を確認します
+func waitForBatch(t *testing.T, h *EmailHandler, jobID string) BatchJobProgress {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := serve(http.MethodGet, "/reprocess/batch/"+jobID, "/reprocess/batch/:jobID", h.HandleBatchReprocessStatus)
+		var progress BatchJobProgress
+		json.Unmarshal(w.Body.Bytes(), &progress)
+		if progress.State == "completed" {
+			return progress
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("batch job %s did not complete (status %d, progress %+v)", jobID, w.Code, progress)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBatchReprocessDefaultsToFailedAndRequeue(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestEmailHandler(t, ai)
+	seedTestMessage(t, db, "failed", models.StatusFailed, time.Hour)
+	seedTestMessage(t, db, "requeue", models.StatusRequeue, time.Hour)
+	seedTestMessage(t, db, "complete", models.StatusComplete, time.Hour)
+	seedTestMessage(t, db, "stuck", models.StatusRunning, time.Hour)
+	db.UpdateProcessingStatus(&models.ProcessingStatus{MessageID: "no-email", Status: models.StatusFailed})
+
+	progress := waitForBatch(t, h, startBatch(t, h, "").JobID)
+
+	// メールデータのないメッセージは失敗として記録し、ほかのメッセージの処理は続ける
+	if progress.Total != 3 || progress.Completed != 2 || progress.Failed != 1 || progress.Errors["no-email"] == "" {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+	for _, messageID := range []string{"failed", "requeue"} {
+		waitForStatus(t, db, messageID, models.StatusComplete)
+	}
+	for _, messageID := range []string{"complete", "stuck"} {
+		if calls := ai.Calls(messageID); calls != 0 {
+			t.Errorf("%s: AI called %d times", messageID, calls)
+		}
+	}
+}
+
+func TestBatchReprocessStuckMessages(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestEmailHandler(t, ai)
+	seedTestMessage(t, db, "stuck", models.StatusRunning, time.Hour)
+	seedTestMessage(t, db, "recent", models.StatusRunning, time.Minute)
+	seedTestMessage(t, db, "failed", models.StatusFailed, time.Hour)
+
+	// stuck_after だけを指定した場合は、更新の止まった pending/running のメッセージだけを対象にする
+	progress := waitForBatch(t, h, startBatch(t, h, `{"stuck_after": "30m"}`).JobID)
+	if progress.Total != 1 || progress.Completed != 1 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+	if ai.Calls("stuck") != 1 || ai.Calls("recent") != 0 || ai.Calls("failed") != 0 {
+		t.Errorf("AI calls: stuck=%d recent=%d failed=%d", ai.Calls("stuck"), ai.Calls("recent"), ai.Calls("failed"))
+	}
+}
+
+func TestBatchReprocessRejectsConcurrentJobs(t *testing.T) {
+	h, db := newTestEmailHandler(t, &fake.AI{Delay: 200 * time.Millisecond})
+	seedTestMessage(t, db, "failed", models.StatusFailed, time.Hour)
+
+	jobID := startBatch(t, h, "").JobID
+	if w := serveJSON(http.MethodPost, "/reprocess/batch", "/reprocess/batch", "", h.HandleBatchReprocess); w.Code != http.StatusConflict {
+		t.Errorf("second batch status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	waitForBatch(t, h, jobID)
+
+	// 完了後は次のジョブを開始できる
+	waitForBatch(t, h, startBatch(t, h, "").JobID)
+}
+
+func TestBatchReprocessRejectsInvalidRequest(t *testing.T) {
+	h, _ := newTestEmailHandler(t, &fake.AI{})
+
+	for _, body := range []string{`{"statuses": ["complete"]}`, `{"stuck_after": "-5m"}`, `{"stuck_after": "later"}`, `{"from": "yesterday"}`} {
+		if w := serveJSON(http.MethodPost, "/reprocess/batch", "/reprocess/batch", body, h.HandleBatchReprocess); w.Code != http.StatusBadRequest {
+			t.Errorf("body %s: status = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := serve(http.MethodGet, "/reprocess/batch/missing", "/reprocess/batch/:jobID", h.HandleBatchReprocessStatus); w.Code != http.StatusNotFound {
+		t.Errorf("missing job status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+// callbackTimeout は完了通知の送信（再送を含む）にかける上限
+const callbackTimeout = time.Minute
+
+// notifyCompletion は処理が終了（完了・失敗・却下）したメッセージについて、
+// 受信時にコールバックURLが登録されていれば最終的な状態とインシデントIDをバックグラウンドで通知します
+func (h *EmailHandler) notifyCompletion(messageID string, logFields []zap.Field) {
+	status, err := h.statusStore.GetProcessingStatus(messageID)
+	if err != nil {
+		logger.Logger.Warn("完了通知のための処理状態の取得に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return
+	}
+	if status.CallbackURL == "" {
+		return
+	}
+
+	callback := &models.CompletionCallback{
+		MessageID:   messageID,
+		Status:      status.Status,
+		IncidentID:  status.IncidentID,
+		Error:       status.Error,
+		CompletedAt: status.CompletedAt,
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), callbackTimeout)
+		defer cancel()
+
+		if err := h.callbacks.Send(ctx, status.CallbackURL, callback); err != nil {
+			logger.Logger.Error("完了通知の送信に失敗しました",
+				append(logFields, zap.Error(err))...)
+			return
+		}
+		logger.Logger.Info("完了通知を送信しました",
+			append(logFields, zap.String("status", string(callback.Status)))...)
+	}()
+}
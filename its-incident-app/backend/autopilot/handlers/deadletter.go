@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// saveDeadLetter はAI処理の再試行をすべて失敗したメッセージをデッドレターとして保存します
+func (h *EmailHandler) saveDeadLetter(messageID string, emailData *models.EmailData, cause error, logFields []zap.Field) {
+	if err := h.dbpilotService.SaveDeadLetter(messageID, emailData, cause); err != nil {
+		logger.Logger.Error("デッドレターの保存に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return
+	}
+
+	logger.Logger.Warn("AI処理に失敗したメッセージをデッドレターに保存しました",
+		append(logFields, zap.NamedError("cause", cause))...)
+}
+
+// HandleReprocess はデッドレターに保存されたメッセージのAI処理を再実行します。
+// AIエンドポイントの復旧後に呼び出すことを想定しており、処理が完了するとデッドレターは解決済みになります
+func (h *EmailHandler) HandleReprocess(c *gin.Context) {
+	messageID := c.Param("messageID")
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("handler", "HandleReprocess"),
+	}
+
+	deadLetter, err := h.dbpilotService.GetDeadLetter(messageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":      "Dead letter not found",
+				"message_id": messageID,
+			})
+			return
+		}
+		logger.Logger.Error("デッドレターの取得に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to get dead letter",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	if deadLetter.IsResolved() {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":       "Dead letter is already resolved",
+			"message_id":  messageID,
+			"resolved_at": deadLetter.ResolvedAt,
+		})
+		return
+	}
+
+	var emailData models.EmailData
+	if err := json.Unmarshal([]byte(deadLetter.Payload), &emailData); err != nil {
+		logger.Logger.Error("デッドレターのメールデータのデコードに失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":      "Invalid dead letter payload",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	status := models.NewProcessingStatus(messageID)
+	if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
+		logger.Logger.Error("処理状態の更新に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to update processing status",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	// 再処理回数をタスクの重複排除キーに使い、過去のタスク名と衝突しないようにする
+	reprocessCount, err := h.dbpilotService.MarkDeadLetterReprocessed(messageID)
+	if err != nil {
+		logger.Logger.Error("デッドレターの再処理の記録に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to record reprocess",
+			"message_id": messageID,
+		})
+		return
+	}
+	logFields = append(logFields, zap.Int("reprocess_count", reprocessCount))
+
+	logger.Logger.Info("デッドレターのメッセージを再処理します",
+		append(logFields, zap.String("last_error", deadLetter.Error))...)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":          "processing",
+		"message":         "Dead letter is being reprocessed",
+		"message_id":      messageID,
+		"reprocess_count": reprocessCount,
+	})
+
+	h.dispatchAIProcessing(messageID, &emailData, "reprocess-"+strconv.Itoa(reprocessCount), logFields)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"hash/fnv"
+	"strconv"
+
+	"autopilot/logger"
+	"autopilot/metrics"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// dryRunHeader は /receive でドライランを指定するヘッダー（true/false）
+	dryRunHeader = "X-Dry-Run"
+	// dryRunAttribute はPub/Subメッセージの属性のうち、X-Dry-Run に相当するもの
+	dryRunAttribute = "dry_run"
+)
+
+// dryRunTotal はドライランで処理したメッセージ数（reason: requested=ヘッダー・属性で指定, sampled=DRY_RUN_PERCENT の対象）
+var dryRunTotal = metrics.NewCounterVec("autopilot_dry_run_total",
+	"Messages stored with a synthetic dry-run incident instead of calling the AI endpoint.", "reason")
+
+// ConfigureDryRun はドライランで処理するメッセージの割合（0〜100、メッセージIDで固定）を設定します
+func (h *EmailHandler) ConfigureDryRun(percent int) {
+	h.dryRunPercent = percent
+	if percent > 0 {
+		logger.Logger.Warn("ドライランを有効にしました（対象のメッセージはAIを呼び出しません）",
+			zap.Int("percent", percent))
+	}
+}
+
+// dryRunReason はメッセージをドライランで処理するかを判定し、対象の場合は理由を返します。
+// requested（ヘッダー・属性の値）が true/false の場合はそれに従い、未指定の場合は DRY_RUN_PERCENT の割合で抽出します
+func (h *EmailHandler) dryRunReason(messageID, requested string) string {
+	if requested != "" {
+		if enabled, err := strconv.ParseBool(requested); err == nil {
+			if enabled {
+				return "requested"
+			}
+			return ""
+		}
+	}
+	if h.dryRunPercent <= 0 {
+		return ""
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte("dry-run:" + messageID))
+	if int(hash.Sum32()%100) < h.dryRunPercent {
+		return "sampled"
+	}
+	return ""
+}
+
+// processDryRun はAIを呼び出さずにドライランのインシデントを保存し、処理状態を完了にします
+func (h *EmailHandler) processDryRun(messageID string, emailData *models.EmailData, reason string, logFields []zap.Field) (*models.ProcessingStatus, error) {
+	logFields = append(logFields, zap.String("dry_run_reason", reason))
+
+	status := &models.ProcessingStatus{MessageID: messageID}
+	if err := h.dbpilotService.SaveIncident(models.NewDryRunResponse(messageID, emailData), messageID); err != nil {
+		logger.Logger.Error("ドライランのインシデントの保存に失敗しました",
+			append(logFields, zap.Error(err))...)
+		status.SetFailed(err)
+		if updateErr := h.statusStore.UpdateProcessingStatus(status); updateErr != nil {
+			logger.Logger.Error("エラー状態の更新に失敗しました",
+				append(logFields, zap.Error(updateErr))...)
+		}
+		return status, err
+	}
+
+	status.SetComplete()
+	if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
+		logger.Logger.Error("完了状態の更新に失敗しました",
+			append(logFields, zap.Error(err))...)
+	}
+	dryRunTotal.Inc(reason)
+	h.events.Record(messageID, models.StepIncidentSaved, "dry_run", reason)
+	h.notifyCompletion(messageID, logFields)
+
+	logger.Logger.Info("ドライランのためAIを呼び出さずにインシデントを保存しました", logFields...)
+	return status, nil
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"autopilot/models"
+	"autopilot/services/fake"
+
+	"github.com/gin-gonic/gin"
+)
+
+// receiveWithDryRun は X-Dry-Run ヘッダーに dryRun を指定して（空の場合は付けずに）メールを受信させます
+func receiveWithDryRun(h *EmailHandler, messageID, dryRun string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.POST("/receive", h.HandleEmailReceive)
+	req := httptest.NewRequest(http.MethodPost, "/receive", strings.NewReader(`{"from":"monitor@example.com","subject":"disk full","body":"web01"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Message-ID", messageID)
+	if dryRun != "" {
+		req.Header.Set(dryRunHeader, dryRun)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleEmailReceiveDryRun(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestEmailHandler(t, ai)
+
+	// ドライランはAIを呼び出さず、受信時にドライランのインシデントを保存して完了にする
+	w := receiveWithDryRun(h, "msg-1", "true")
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusOK || body["dry_run"] != true || body["status"] != string(models.StatusComplete) {
+		t.Fatalf("dry run status = %d, body = %s", w.Code, w.Body.String())
+	}
+	incidents := db.Incidents("msg-1")
+	if len(incidents) != 1 || incidents[0].Provider != models.DryRunJudgment || incidents[0].Data.Outputs.Subject != "disk full" {
+		t.Errorf("unexpected incidents: %+v", incidents)
+	}
+	if status, err := db.GetProcessingStatus("msg-1"); err != nil || status.Status != models.StatusComplete {
+		t.Errorf("status = %+v, err = %v, want complete", status, err)
+	}
+
+	// false や解釈できない値は通常どおりAI処理する
+	for i, dryRun := range []string{"false", "maybe"} {
+		messageID := fmt.Sprintf("msg-%d", i+2)
+		if w := receiveWithDryRun(h, messageID, dryRun); w.Code != http.StatusAccepted {
+			t.Errorf("%s: status = %d, want %d", dryRun, w.Code, http.StatusAccepted)
+		}
+		waitForStatus(t, db, messageID, models.StatusComplete)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if ai.Calls("msg-1") != 0 || ai.Calls("msg-2") != 1 || ai.Calls("msg-3") != 1 {
+		t.Errorf("AI calls: msg-1=%d msg-2=%d msg-3=%d", ai.Calls("msg-1"), ai.Calls("msg-2"), ai.Calls("msg-3"))
+	}
+}
+
+func TestDryRunReason(t *testing.T) {
+	h, _ := newTestEmailHandler(t, &fake.AI{})
+
+	if reason := h.dryRunReason("msg-1", ""); reason != "" {
+		t.Errorf("dryRunReason without DRY_RUN_PERCENT = %q, want none", reason)
+	}
+
+	// ヘッダーの指定は割合より優先する
+	h.ConfigureDryRun(100)
+	if reason := h.dryRunReason("msg-1", ""); reason != "sampled" {
+		t.Errorf("dryRunReason at 100%% = %q, want sampled", reason)
+	}
+	if reason := h.dryRunReason("msg-1", "false"); reason != "" {
+		t.Errorf("dryRunReason with X-Dry-Run: false = %q, want none", reason)
+	}
+	if reason := h.dryRunReason("msg-1", "1"); reason != "requested" {
+		t.Errorf("dryRunReason with X-Dry-Run: 1 = %q, want requested", reason)
+	}
+
+	// 抽出の対象はメッセージIDで決まる
+	h.ConfigureDryRun(30)
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		messageID := fmt.Sprintf("msg-%d", i)
+		reason := h.dryRunReason(messageID, "")
+		if again := h.dryRunReason(messageID, ""); again != reason {
+			t.Fatalf("%s: reason changed from %q to %q", messageID, reason, again)
+		}
+		if reason == "sampled" {
+			sampled++
+		}
+	}
+	if sampled < 230 || sampled > 370 {
+		t.Errorf("%d of 1000 messages sampled, want about 300", sampled)
+	}
+}
+
+func TestDryRunIgnoresFullQueue(t *testing.T) {
+	h, db := newTestEmailHandler(t, &fake.AI{})
+	pool := NewWorkerPool(1, 1)
+	shutdownPool(t, pool)
+	h.workers = pool
+	release := fillPool(t, pool, 1, 1)
+	defer close(release)
+
+	// ドライランはAIの待ち行列を使わないため、満杯でも受信する
+	if w := receiveWithDryRun(h, "msg-1", "true"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if len(db.Incidents("msg-1")) != 1 {
+		t.Error("dry-run incident not saved while the queue was full")
+	}
+}
@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"autopilot/logger"
@@ -15,18 +18,67 @@ import (
 	"go.uber.org/zap"
 )
 
+// queueFullRetryAfter はAI処理の待ち行列が満杯の場合に返すRetry-After（秒）
+const queueFullRetryAfter = "30"
+
+// defaultProcessTimeout は1メッセージのAI処理の上限（ストリーミングなしの場合）
+const defaultProcessTimeout = 90 * time.Second
+
+var (
+	// errQueueFull はAI処理の待ち行列が満杯で受け付けられないことを表します
+	errQueueFull = errors.New("AI processing queue is full")
+	// errDuplicate は同じメッセージIDを受信済み（処理中・完了など）であることを表します
+	errDuplicate = errors.New("message already received")
+)
+
 type EmailHandler struct {
-	dbpilotService *services.DBPilotService
-	aiService      *services.AIService
+	dbpilotService      services.DBPilot
+	statusStore         services.StatusStore
+	aiService           services.AIClassifier
+	taskQueue           *services.TaskQueueService // nil の場合はワーカープールでAI処理を実行
+	workers             *WorkerPool
+	resultCache         *services.ResultCache // nil の場合はキャッシュを使わない
+	budget              *services.BudgetGuard // nil の場合はトークン上限なし
+	callbacks           *services.CallbackService
+	holdSenders         []string
+	spam                *services.SpamScorer    // nil の場合はスパム・ノイズ判定をしない
+	dryRunPercent       int                     // ドライランで処理するメッセージの割合（0で無効）
+	threading           bool                    // 返信メールを既存のインシデントに追加する
+	threadSubjectWindow time.Duration           // 件名で返信元を探す期間（0で件名では判定しない）
+	events              *services.EventRecorder // nil の場合は処理のステップを記録しない
+	batchJobs           *batchJobRegistry
+	sweeper             staleSweeper
+	processTimeout      time.Duration // 1メッセージのAI処理の上限（ストリーミングでは延長される）
+	inflight            sync.Map      // 取り込み中のメッセージID（同時に届いた重複の排除用）
 }
 
-func NewEmailHandler(dbpilot *services.DBPilotService, ai *services.AIService) *EmailHandler {
+func NewEmailHandler(dbpilot services.DBPilot, statusStore services.StatusStore, ai services.AIClassifier, taskQueue *services.TaskQueueService, workers *WorkerPool, resultCache *services.ResultCache, budget *services.BudgetGuard, callbacks *services.CallbackService, holdSenders []string) *EmailHandler {
 	return &EmailHandler{
 		dbpilotService: dbpilot,
+		statusStore:    statusStore,
 		aiService:      ai,
+		taskQueue:      taskQueue,
+		workers:        workers,
+		resultCache:    resultCache,
+		budget:         budget,
+		callbacks:      callbacks,
+		holdSenders:    holdSenders,
+		batchJobs:      newBatchJobRegistry(),
+		sweeper:        staleSweeper{threshold: defaultStaleThreshold},
+		processTimeout: defaultProcessTimeout,
 	}
 }
 
+// SetEventRecorder はメッセージの処理のステップ（受信・保存・AI処理など）の記録先を設定します
+func (h *EmailHandler) SetEventRecorder(events *services.EventRecorder) {
+	h.events = events
+}
+
+// ConfigureProcessTimeout は1メッセージのAI処理の上限を設定します（ストリーミングで進行中の処理を待つ場合に延長します）
+func (h *EmailHandler) ConfigureProcessTimeout(timeout time.Duration) {
+	h.processTimeout = timeout
+}
+
 func (h *EmailHandler) HandleEmailReceive(c *gin.Context) {
 	messageID := c.GetHeader("X-Message-ID")
 	if messageID == "" {
@@ -42,6 +94,20 @@ func (h *EmailHandler) HandleEmailReceive(c *gin.Context) {
 		zap.String("path", c.Request.URL.Path),
 	}
 
+	// 完了時の通知先（ヘッダーまたはクエリ）。登録された場合は /status のポーリングは不要
+	callbackURL := c.GetHeader("X-Callback-URL")
+	if callbackURL == "" {
+		callbackURL = c.Query("callback_url")
+	}
+	if callbackURL != "" {
+		if err := h.callbacks.ValidateURL(callbackURL); err != nil {
+			logger.Logger.Warn("コールバックURLが不正です",
+				append(logFields, zap.String("callback_url", callbackURL), zap.Error(err))...)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid callback URL", "details": err.Error()})
+			return
+		}
+	}
+
 	var emailData models.EmailData
 	if err := c.ShouldBindJSON(&emailData); err != nil {
 		logger.Logger.Error("リクエストのバインドに失敗しました",
@@ -50,46 +116,276 @@ func (h *EmailHandler) HandleEmailReceive(c *gin.Context) {
 		return
 	}
 
+	status, failure, err := h.ingestEmail(messageID, &emailData, callbackURL, c.GetHeader(dryRunHeader), logFields)
+	if errors.Is(err, errDuplicate) {
+		// 上流の再送は既存の処理状態を返すだけにする
+		c.JSON(http.StatusOK, gin.H{
+			"status":     string(status),
+			"message":    "Email already received",
+			"message_id": messageID,
+			"duplicate":  true,
+		})
+		return
+	}
+	if errors.Is(err, errQueueFull) {
+		c.Header("Retry-After", queueFullRetryAfter)
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":      failure,
+			"message_id": messageID,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   failure,
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if status == models.StatusHeld {
+		c.JSON(http.StatusAccepted, gin.H{
+			"status":     string(models.StatusHeld),
+			"message":    "Email received and held for manual approval",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	if status == models.StatusQuarantined {
+		c.JSON(http.StatusAccepted, gin.H{
+			"status":     string(models.StatusQuarantined),
+			"message":    "Email received and quarantined as spam or noise",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	if status == models.StatusQueued {
+		c.JSON(http.StatusAccepted, gin.H{
+			"status":     string(models.StatusQueued),
+			"message":    "Email received and queued (AI usage budget exceeded)",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	// ドライランは受信時に完了している
+	if status == models.StatusComplete {
+		c.JSON(http.StatusOK, gin.H{
+			"status":     string(models.StatusComplete),
+			"message":    "Email received and stored as a dry run (AI processing skipped)",
+			"message_id": messageID,
+			"dry_run":    true,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":     "processing",
+		"message":    "Email received and being processed",
+		"message_id": messageID,
+	})
+}
+
+// ingestEmail はメールデータを保存し、承認対象の送信者であれば保留、スパム・ノイズと判定すれば隔離（quarantined）、
+// 既存のインシデントへの返信であればそのインシデントに追加して完了（complete）、
+// AIの利用上限を超えていれば受信のみ（queued）、それ以外はAI処理を非同期で開始します。
+// 戻り値は保存後の処理状態で、失敗した場合は呼び出し元に返すエラーメッセージとエラーを返します。
+// 受信済み（失敗・再キュー以外）のメッセージは何もせず既存の状態と errDuplicate を、
+// AI処理の待ち行列が満杯の場合は何も保存せずに errQueueFull を返します。
+// callbackURL を指定すると処理の終了時にその URL へ結果を通知します。
+// dryRun（X-Dry-Run ヘッダー・dry_run 属性の値）が true、または DRY_RUN_PERCENT の対象の場合は、
+// AIを呼び出さずにドライランのインシデントを保存して complete を返します
+func (h *EmailHandler) ingestEmail(messageID string, emailData *models.EmailData, callbackURL, dryRun string, logFields []zap.Field) (models.ProcessStatus, string, error) {
+	if _, loaded := h.inflight.LoadOrStore(messageID, struct{}{}); loaded {
+		logger.Logger.Info("同じメッセージを取り込み中のためスキップします", logFields...)
+		return models.StatusPending, "", errDuplicate
+	}
+	defer h.inflight.Delete(messageID)
+
+	existing, err := h.statusStore.GetProcessingStatus(messageID)
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		logger.Logger.Error("処理状態の確認に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return "", "Failed to get processing status", err
+	}
+	if existing != nil && !existing.AcceptsReingest() {
+		logger.Logger.Info("受信済みのメッセージのためスキップします",
+			append(logFields, zap.String("status", string(existing.Status)))...)
+		return existing.Status, "", errDuplicate
+	}
+
+	h.events.Record(messageID, models.StepReceived, "from", emailData.From)
+
+	dryRunReason := h.dryRunReason(messageID, dryRun)
+	if dryRunReason == "" && h.taskQueue == nil && h.workers.Full() {
+		logger.Logger.Warn("AI処理の待ち行列が満杯のため受信を拒否します",
+			append(logFields, zap.Int("pending", h.workers.Pending()))...)
+		return "", "AI processing queue is full", errQueueFull
+	}
+
 	// 処理状態の初期化
 	status := models.NewProcessingStatus(messageID)
-	if err := h.dbpilotService.UpdateProcessingStatus(status); err != nil {
+	status.CallbackURL = callbackURL
+	if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
 		logger.Logger.Error("処理状態の初期化に失敗しました",
 			append(logFields, zap.Error(err))...)
 	}
 
 	// メールデータの保存
-	if err := h.dbpilotService.SaveEmail(&emailData, messageID); err != nil {
+	if err := h.dbpilotService.SaveEmail(emailData, messageID); err != nil {
 		logger.Logger.Error("メールデータの保存に失敗しました",
 			append(logFields, zap.Error(err))...)
 		status.SetFailed(err)
-		_ = h.dbpilotService.UpdateProcessingStatus(status)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to save email data",
-			"details": err.Error(),
-		})
-		return
+		_ = h.statusStore.UpdateProcessingStatus(status)
+		return status.Status, "Failed to save email data", err
 	}
 
 	logger.Logger.Debug("メールデータを保存しました", logFields...)
+	h.events.Record(messageID, models.StepSavedEmail)
+
+	// 承認対象の送信者はAI処理を保留し、手動承認を待つ
+	if h.requiresApproval(emailData.From) {
+		status.SetHeld()
+		if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
+			logger.Logger.Error("承認待ち状態の更新に失敗しました",
+				append(logFields, zap.Error(err))...)
+			return status.Status, "Failed to hold message", err
+		}
 
-	// 非同期処理を開始する前に202レスポンスを返す
-	c.JSON(http.StatusAccepted, gin.H{
-		"status":     "processing",
-		"message":    "Email received and being processed",
-		"message_id": messageID,
-	})
+		logger.Logger.Info("承認待ちとしてAI処理を保留しました",
+			append(logFields, zap.String("from", emailData.From))...)
+		return status.Status, "", nil
+	}
+
+	// メルマガ・自動応答などはインシデントにせず、隔離して確認を待つ
+	if verdict := h.spam.Score(context.Background(), emailData); verdict.Quarantine {
+		status.SetQuarantined(verdict.String())
+		if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
+			logger.Logger.Error("隔離状態の更新に失敗しました",
+				append(logFields, zap.Error(err))...)
+			return status.Status, "Failed to quarantine message", err
+		}
+
+		h.events.Record(messageID, models.StepQuarantined,
+			"score", strconv.FormatFloat(verdict.Score, 'f', 1, 64), "reasons", strings.Join(verdict.Reasons, ","))
+		logger.Logger.Info("スパム・ノイズと判定したため隔離しました",
+			append(logFields, zap.Float64("score", verdict.Score), zap.Strings("reasons", verdict.Reasons))...)
+		return status.Status, "", nil
+	}
+
+	// ドライランはAIを呼び出さないため、トークン上限や待ち行列に関係なく受信時に完了させる
+	if dryRunReason != "" {
+		dryRunStatus, err := h.processDryRun(messageID, emailData, dryRunReason, logFields)
+		if err != nil {
+			return dryRunStatus.Status, "Failed to save dry-run incident", err
+		}
+		return dryRunStatus.Status, "", nil
+	}
+
+	// 既存のインシデントへの返信は新しいインシデントを作らず、対応履歴として追加する
+	if threaded := h.threadReply(messageID, emailData, logFields); threaded != nil {
+		return threaded.Status, "", nil
+	}
+
+	// 当日のトークン上限を超えている場合は受信のみとし、AI処理は一括再処理に回す
+	if h.budget.Exceeded() {
+		status.SetQueued("AI daily token budget exceeded")
+		if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
+			logger.Logger.Error("受信のみの状態の更新に失敗しました",
+				append(logFields, zap.Error(err))...)
+			return status.Status, "Failed to queue message", err
+		}
+
+		logger.Logger.Warn("AIの利用上限を超えているため受信のみとしました", logFields...)
+		return status.Status, "", nil
+	}
 
 	// AI処理を非同期で実行
-	go h.processEmailAsync(messageID, &emailData, logFields)
+	h.dispatchAIProcessing(messageID, emailData, "", logFields)
+	return status.Status, "", nil
 }
 
-func (h *EmailHandler) processEmailAsync(messageID string, emailData *models.EmailData, logFields []zap.Field) {
-	processCtx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+// dispatchAIProcessing はAI処理をタスクキューに登録します。
+// キューが未設定、または登録に失敗した場合はワーカープールで実行し、待ち行列が満杯の場合は失敗としてデッドレターに保存します。
+// dedupKey はタスクの重複排除キーで、同じメッセージを再度処理する場合に指定します
+func (h *EmailHandler) dispatchAIProcessing(messageID string, emailData *models.EmailData, dedupKey string, logFields []zap.Field) {
+	if h.taskQueue != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		err := h.taskQueue.EnqueueAIProcessing(ctx, messageID, dedupKey)
+		if err == nil {
+			return
+		}
+		logger.Logger.Error("AI処理タスクの登録に失敗したためインスタンス内で処理します",
+			append(logFields, zap.Error(err))...)
+	}
+
+	dispatchedAt := time.Now()
+	err := h.workers.TrySubmit(func(ctx context.Context) {
+		err := h.processEmailAsync(ctx, messageID, emailData, logFields)
+		messageLatency.ObserveSince(dispatchedAt, processOutcome(ctx, err))
+	})
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, errShuttingDown):
+		h.requeue(messageID, logFields)
+		return
+	}
+
+	logger.Logger.Error("AI処理の待ち行列が満杯のため処理できません",
+		append(logFields, zap.Int("pending", h.workers.Pending()))...)
+	status := &models.ProcessingStatus{MessageID: messageID}
+	status.SetFailed(err)
+	if updateErr := h.statusStore.UpdateProcessingStatus(status); updateErr != nil {
+		logger.Logger.Error("エラー状態の更新に失敗しました",
+			append(logFields, zap.Error(updateErr))...)
+	}
+	h.saveDeadLetter(messageID, emailData, err, logFields)
+	h.notifyCompletion(messageID, logFields)
+}
+
+// requeue はシャットダウンで処理できなかったメッセージを requeue 状態にします（一括再処理の対象になります）
+func (h *EmailHandler) requeue(messageID string, logFields []zap.Field) {
+	status := &models.ProcessingStatus{MessageID: messageID}
+	status.SetRequeue(errShuttingDown.Error())
+	if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
+		logger.Logger.Error("再キュー状態の保存に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return
+	}
+	logger.Logger.Warn("シャットダウンのためメッセージを再キュー状態にしました", logFields...)
+}
+
+// processEmailAsync はAI処理を実行して処理状態を更新します。失敗した場合はデッドレターに保存してエラーを返します。
+// シャットダウンで ctx がキャンセルされた場合は失敗ではなく requeue 状態にします
+func (h *EmailHandler) processEmailAsync(ctx context.Context, messageID string, emailData *models.EmailData, logFields []zap.Field) error {
+	if isShuttingDown(ctx) {
+		h.requeue(messageID, logFields)
+		return errShuttingDown
+	}
+
+	// 待ち行列にいる間に別の経路で処理が完了していれば重複して実行しない
+	if status, err := h.statusStore.GetProcessingStatus(messageID); err == nil &&
+		(status.IsComplete() || status.Status == models.StatusRejected) {
+		logger.Logger.Info("処理済みのメッセージのためAI処理をスキップします",
+			append(logFields, zap.String("status", string(status.Status)))...)
+		return nil
+	}
+
+	processCtx, cancel := context.WithTimeout(ctx, h.processTimeout)
 	defer cancel()
 
 	logger.Logger.Debug("非同期AI処理を開始します", logFields...)
 
-	if err := h.processAIAndSaveIncident(processCtx, emailData, messageID); err != nil {
+	if err := h.processAIAndSaveIncident(processCtx, emailData, messageID, true); err != nil {
+		if isShuttingDown(processCtx) {
+			h.requeue(messageID, logFields)
+			return err
+		}
+
 		logger.Logger.Error("AI処理とインシデント保存に失敗しました",
 			append(logFields, zap.Error(err))...)
 
@@ -97,26 +393,32 @@ func (h *EmailHandler) processEmailAsync(messageID string, emailData *models.Ema
 			MessageID: messageID,
 		}
 		status.SetFailed(err)
-		if updateErr := h.dbpilotService.UpdateProcessingStatus(status); updateErr != nil {
+		if updateErr := h.statusStore.UpdateProcessingStatus(status); updateErr != nil {
 			logger.Logger.Error("エラー状態の更新に失敗しました",
 				append(logFields, zap.Error(updateErr))...)
 		}
-		return
+		h.saveDeadLetter(messageID, emailData, err, logFields)
+		h.notifyCompletion(messageID, logFields)
+		return err
 	}
 
 	status := &models.ProcessingStatus{
 		MessageID: messageID,
 	}
 	status.SetComplete()
-	if err := h.dbpilotService.UpdateProcessingStatus(status); err != nil {
+	if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
 		logger.Logger.Error("完了状態の更新に失敗しました",
 			append(logFields, zap.Error(err))...)
 	}
+	h.notifyCompletion(messageID, logFields)
 
 	logger.Logger.Debug("非同期AI処理が完了しました", logFields...)
+	return nil
 }
 
-func (h *EmailHandler) processAIAndSaveIncident(ctx context.Context, emailData *models.EmailData, messageID string) error {
+// processAIAndSaveIncident はAI処理を実行してインシデントを保存します。
+// saveErrorIncident が true の場合、AI処理の失敗もエラーのインシデントとして保存します
+func (h *EmailHandler) processAIAndSaveIncident(ctx context.Context, emailData *models.EmailData, messageID string, saveErrorIncident bool) error {
 	logFields := []zap.Field{
 		zap.String("message_id", messageID),
 		zap.String("process", "AI_processing"),
@@ -126,20 +428,34 @@ func (h *EmailHandler) processAIAndSaveIncident(ctx context.Context, emailData *
 		MessageID: messageID,
 	}
 	status.SetRunning("")
-	if err := h.dbpilotService.UpdateProcessingStatus(status); err != nil {
+	if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
 		logger.Logger.Debug("実行中状態の更新に失敗しました",
 			append(logFields, zap.Error(err))...)
 	}
 
+	language := services.DetectLanguage(emailData)
+	logFields = append(logFields,
+		zap.String("language", language),
+		zap.String("workflow_version", h.aiService.WorkflowVersion(messageID, language)))
 	logger.Logger.Info("AI処理を開始します", logFields...)
+	h.events.Record(messageID, models.StepAIStarted,
+		"language", language,
+		"workflow_version", h.aiService.WorkflowVersion(messageID, language))
 
-	aiResponse, err := h.aiService.ProcessEmail(ctx, emailData)
+	aiResponse, err := h.classify(ctx, messageID, emailData, logFields)
 	if err != nil {
 		logger.Logger.Error("AI処理に失敗しました",
 			append(logFields, zap.Error(err))...)
+		h.events.Record(messageID, models.StepAIFailed, "error", err.Error())
+
+		// シャットダウンによる中断はAIの失敗ではないため記録しない
+		if !saveErrorIncident || isShuttingDown(ctx) {
+			return err
+		}
 
 		// エラー用のAIResponseを生成
 		errorResponse := models.NewErrorResponse(messageID, err)
+		errorResponse.Language = language
 
 		// エラー情報もインシデントとして保存
 		if saveErr := h.dbpilotService.SaveIncident(errorResponse, messageID); saveErr != nil {
@@ -149,6 +465,7 @@ func (h *EmailHandler) processAIAndSaveIncident(ctx context.Context, emailData *
 					zap.Error(err))...)
 			return fmt.Errorf("failed to save error incident: %v (original error: %v)", saveErr, err)
 		}
+		h.events.Record(messageID, models.StepIncidentSaved, "error_incident", "true")
 
 		return err
 	}
@@ -157,13 +474,17 @@ func (h *EmailHandler) processAIAndSaveIncident(ctx context.Context, emailData *
 		append(logFields, zap.Any("ai_response", aiResponse))...)
 
 	status.SetRunning(aiResponse.TaskID)
-	if err := h.dbpilotService.UpdateProcessingStatus(status); err != nil {
+	if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
 		logger.Logger.Debug("TaskIDの更新に失敗しました",
 			append(logFields, zap.Error(err))...)
 	}
 
 	logger.Logger.Info("AI処理が完了しました",
 		append(logFields, zap.String("task_id", aiResponse.TaskID))...)
+	h.events.Record(messageID, models.StepAISucceeded,
+		"provider", aiResponse.Provider,
+		"task_id", aiResponse.TaskID,
+		"cache_hit", strconv.FormatBool(aiResponse.CacheHit))
 
 	if err := h.dbpilotService.SaveIncident(aiResponse, messageID); err != nil {
 		logger.Logger.Error("インシデントの保存に失敗しました",
@@ -175,9 +496,52 @@ func (h *EmailHandler) processAIAndSaveIncident(ctx context.Context, emailData *
 
 	logger.Logger.Debug("インシデントを保存しました",
 		append(logFields, zap.String("task_id", aiResponse.TaskID))...)
+	h.events.Record(messageID, models.StepIncidentSaved)
+
+	// 評価中のモデルでも並行して処理し、比較用に保存する（キャッシュの再利用時はAIを呼んでいないため対象外）
+	if !aiResponse.CacheHit && h.aiService.ShadowSampled(messageID) {
+		// シャドウは比較用のため、待ち行列が満杯またはシャットダウン中の場合は実行しない
+		err := h.workers.TrySubmit(func(ctx context.Context) {
+			h.runShadow(ctx, messageID, emailData, aiResponse, logFields)
+		})
+		if err != nil {
+			logger.Logger.Debug("シャドウのAI処理を実行しません",
+				append(logFields, zap.Error(err))...)
+		}
+	}
 	return nil
 }
 
+// classify は同じ内容のメールの結果がキャッシュにあれば再利用し、なければAI処理を実行します
+func (h *EmailHandler) classify(ctx context.Context, messageID string, emailData *models.EmailData, logFields []zap.Field) (*models.AIResponse, error) {
+	contentHash := services.ContentHash(emailData)
+	language := services.DetectLanguage(emailData)
+	if cached := h.resultCache.Lookup(contentHash, h.aiService.WorkflowVersion(messageID, language)); cached != nil {
+		logger.Logger.Info("同じ内容のメールのAI処理結果を再利用します",
+			append(logFields,
+				zap.String("content_hash", contentHash),
+				zap.String("cached_from_message_id", cached.CachedFromMessageID))...)
+		cached.Language = language
+		return cached, nil
+	}
+
+	// ストリーミングの途中結果は処理状態に保存し、完了前でも件名・判定を確認できるようにする
+	onPartial := func(outputs map[string]string) {
+		status := &models.ProcessingStatus{MessageID: messageID, PartialOutputs: outputs}
+		status.SetRunning("")
+		if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
+			logger.Logger.Debug("途中結果の保存に失敗しました",
+				append(logFields, zap.Error(err))...)
+		}
+	}
+	aiResponse, err := h.aiService.ProcessEmailStream(ctx, messageID, emailData, onPartial)
+	if err != nil {
+		return nil, err
+	}
+	aiResponse.ContentHash = contentHash
+	return aiResponse, nil
+}
+
 func (h *EmailHandler) HandleCheckStatus(c *gin.Context) {
 	messageID := c.Param("messageID")
 	if messageID == "" {
@@ -190,7 +554,7 @@ func (h *EmailHandler) HandleCheckStatus(c *gin.Context) {
 		zap.String("handler", "HandleCheckStatus"),
 	}
 
-	status, err := h.dbpilotService.GetProcessingStatus(messageID)
+	status, err := h.statusStore.GetProcessingStatus(messageID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			logger.Logger.Info("処理状態が見つかりません", logFields...)
@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"autopilot/models"
+	"autopilot/services"
+	"autopilot/services/fake"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestEmailHandler はインメモリのdbpilotとAIを使うハンドラーを作成します
+func newTestEmailHandler(t *testing.T, ai *fake.AI) (*EmailHandler, *fake.DBPilot) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db := fake.NewDBPilot()
+	workers := NewWorkerPool(2, 10)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		workers.Shutdown(ctx)
+	})
+	return NewEmailHandler(db, db, ai, nil, workers, nil, nil, nil, nil), db
+}
+
+// testEmail はテストで処理するメールデータを返します
+func testEmail() *models.EmailData {
+	return &models.EmailData{
+		From:    "monitor@example.com",
+		To:      "support@example.com",
+		Subject: "サーバーが応答しません",
+		Body:    "web01 が応答しません。",
+	}
+}
+
+// waitForStatus はメッセージの処理状態が want になるまで待ちます（ワーカープールでの非同期処理の確認用）
+func waitForStatus(t *testing.T, db *fake.DBPilot, messageID string, want models.ProcessStatus) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if status, err := db.GetProcessingStatus(messageID); err == nil && status.Status == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	status, err := db.GetProcessingStatus(messageID)
+	t.Fatalf("status of %s did not become %s (status: %+v, err: %v)", messageID, want, status, err)
+}
+
+// serve はハンドラーにリクエストを送信し、レスポンスを返します
+func serve(method, path, pattern string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, pattern, handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+// serveJSON は body をJSONのリクエストボディとしてハンドラーに送信し、レスポンスを返します
+func serveJSON(method, path, pattern, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, pattern, handler)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestProcessEmailAsyncSavesIncident(t *testing.T) {
+	ai := &fake.AI{Version: "v1"}
+	h, db := newTestEmailHandler(t, ai)
+
+	if err := h.processEmailAsync(context.Background(), "msg-1", testEmail(), nil); err != nil {
+		t.Fatalf("processEmailAsync: %v", err)
+	}
+
+	incidents := db.Incidents("msg-1")
+	if len(incidents) != 1 || incidents[0].Data.Outputs.Judgment != "fake" {
+		t.Fatalf("unexpected incidents: %+v", incidents)
+	}
+	history := db.StatusHistory("msg-1")
+	if len(history) == 0 || history[0] != models.StatusRunning || history[len(history)-1] != models.StatusComplete {
+		t.Errorf("unexpected status history: %v", history)
+	}
+	if _, err := db.GetDeadLetter("msg-1"); err == nil {
+		t.Error("dead letter saved for a successful message")
+	}
+}
+
+func TestProcessEmailAsyncSavesErrorIncident(t *testing.T) {
+	ai := &fake.AI{Errors: []error{errors.New("ai endpoint unavailable")}}
+	h, db := newTestEmailHandler(t, ai)
+
+	if err := h.processEmailAsync(context.Background(), "msg-1", testEmail(), nil); err == nil {
+		t.Fatal("processEmailAsync succeeded with a failing AI")
+	}
+
+	// AI処理の失敗もエラーのインシデントとして保存し、処理状態は failed、メールはデッドレターに保存する
+	incidents := db.Incidents("msg-1")
+	if len(incidents) != 1 || incidents[0].Data.Status != "error" || incidents[0].Data.Error != "ai endpoint unavailable" {
+		t.Fatalf("unexpected incidents: %+v", incidents)
+	}
+	status, err := db.GetProcessingStatus("msg-1")
+	if err != nil || status.Status != models.StatusFailed {
+		t.Errorf("status = %+v, err = %v, want failed", status, err)
+	}
+	deadLetter, err := db.GetDeadLetter("msg-1")
+	if err != nil || deadLetter.Error != "ai endpoint unavailable" {
+		t.Errorf("dead letter = %+v, err = %v", deadLetter, err)
+	}
+}
+
+func TestProcessEmailAsyncFailsWhenErrorIncidentCannotBeSaved(t *testing.T) {
+	ai := &fake.AI{Errors: []error{errors.New("ai endpoint unavailable")}}
+	h, db := newTestEmailHandler(t, ai)
+	db.FailOn("SaveIncident", errors.New("dbpilot unavailable"))
+
+	if err := h.processEmailAsync(context.Background(), "msg-1", testEmail(), nil); err == nil {
+		t.Fatal("processEmailAsync succeeded without saving the error incident")
+	}
+	if _, err := db.GetDeadLetter("msg-1"); err != nil {
+		t.Errorf("dead letter not saved: %v", err)
+	}
+}
+
+func TestProcessEmailAsyncReusesCachedResult(t *testing.T) {
+	ai := &fake.AI{Version: "v1", Shadow: true}
+	h, db := newTestEmailHandler(t, ai)
+	h.resultCache = services.NewResultCache(db, time.Hour)
+	cached := &models.AIResponse{TaskID: "task-0"}
+	cached.Data.Status = "succeeded"
+	cached.Data.Outputs.Judgment = "要対応"
+	db.SetCachedResponse(services.ContentHash(testEmail()), "v1", "msg-0", cached)
+
+	// 同じ内容のメールはAIを呼び出さず、キャッシュの結果でインシデントを作成する（シャドウも実行しない）
+	if err := h.processEmailAsync(context.Background(), "msg-1", testEmail(), nil); err != nil {
+		t.Fatalf("processEmailAsync: %v", err)
+	}
+	if calls := ai.Calls("msg-1"); calls != 0 {
+		t.Errorf("AI called %d times for a cached result", calls)
+	}
+	incidents := db.Incidents("msg-1")
+	if len(incidents) != 1 || !incidents[0].CacheHit || incidents[0].CachedFromMessageID != "msg-0" || incidents[0].Data.Outputs.Judgment != "要対応" {
+		t.Fatalf("unexpected incidents: %+v", incidents)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h.workers.Shutdown(ctx)
+	if shadows := db.ShadowResults(); len(shadows) != 0 {
+		t.Errorf("shadow ran for a cached result: %+v", shadows)
+	}
+
+	// 内容が異なるメールは通常どおりAI処理する
+	email := testEmail()
+	email.Body = "web02 が応答しません。"
+	if err := h.processEmailAsync(context.Background(), "msg-2", email, nil); err != nil {
+		t.Fatalf("processEmailAsync: %v", err)
+	}
+	if calls := ai.Calls("msg-2"); calls != 1 {
+		t.Errorf("AI called %d times, want 1", calls)
+	}
+}
+
+func TestShadowRunsOnWorkerPool(t *testing.T) {
+	ai := &fake.AI{Version: "v1", Shadow: true, Delay: 50 * time.Millisecond}
+	h, db := newTestEmailHandler(t, ai)
+
+	if err := h.processEmailAsync(context.Background(), "msg-1", testEmail(), nil); err != nil {
+		t.Fatalf("processEmailAsync: %v", err)
+	}
+
+	// シャットダウンはワーカープールで実行中のシャドウの保存を待つ
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h.workers.Shutdown(ctx)
+
+	shadows := db.ShadowResults()
+	if len(shadows) != 1 || shadows[0].MessageID != "msg-1" || shadows[0].Shadow == nil {
+		t.Fatalf("unexpected shadow results: %+v", shadows)
+	}
+
+	// シャットダウン後はシャドウを実行しない
+	if err := h.processEmailAsync(context.Background(), "msg-2", testEmail(), nil); err != nil {
+		t.Fatalf("processEmailAsync after shutdown: %v", err)
+	}
+	if shadows := db.ShadowResults(); len(shadows) != 1 {
+		t.Errorf("shadow ran after shutdown: %+v", shadows)
+	}
+}
+
+func TestIngestEmailQueuesWhenBudgetExceeded(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestEmailHandler(t, ai)
+	db.SetCostUsage(&models.CostUsageReport{Total: models.CostUsageSummary{TotalTokens: 1000}})
+	h.budget = services.NewBudgetGuard(db, nil, 1000)
+
+	// 当日の上限を超えている場合は受信のみとし、AI処理は一括再処理に回す
+	status, _, err := h.ingestEmail("msg-1", testEmail(), "", "", nil)
+	if err != nil {
+		t.Fatalf("ingestEmail: %v", err)
+	}
+	if status != models.StatusQueued {
+		t.Errorf("status = %s, want %s", status, models.StatusQueued)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if calls := ai.Calls("msg-1"); calls != 0 {
+		t.Errorf("AI called %d times over budget", calls)
+	}
+
+	// 上限内であれば通常どおりAI処理する
+	db.SetCostUsage(&models.CostUsageReport{Total: models.CostUsageSummary{TotalTokens: 10}})
+	h.budget = services.NewBudgetGuard(db, nil, 1000)
+	if _, _, err := h.ingestEmail("msg-2", testEmail(), "", "", nil); err != nil {
+		t.Fatalf("ingestEmail: %v", err)
+	}
+	waitForStatus(t, db, "msg-2", models.StatusComplete)
+}
+
+func TestReprocessRetriesDeadLetter(t *testing.T) {
+	ai := &fake.AI{Errors: []error{errors.New("ai endpoint unavailable")}}
+	h, db := newTestEmailHandler(t, ai)
+
+	if err := h.processEmailAsync(context.Background(), "msg-1", testEmail(), nil); err == nil {
+		t.Fatal("first attempt succeeded with a failing AI")
+	}
+
+	// AIの復旧後にデッドレターから再処理すると、再びAI処理を実行して完了する
+	w := serve(http.MethodPost, "/deadletters/msg-1/reprocess", "/deadletters/:messageID/reprocess", h.HandleReprocess)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("reprocess status = %d, body = %s", w.Code, w.Body.String())
+	}
+	waitForStatus(t, db, "msg-1", models.StatusComplete)
+
+	if calls := ai.Calls("msg-1"); calls != 2 {
+		t.Errorf("AI called %d times, want 2", calls)
+	}
+	deadLetter, err := db.GetDeadLetter("msg-1")
+	if err != nil || !deadLetter.IsResolved() || deadLetter.ReprocessCount != 1 {
+		t.Errorf("dead letter = %+v, err = %v, want resolved after one reprocess", deadLetter, err)
+	}
+
+	// 解決済みのデッドレターは再処理しない
+	if w := serve(http.MethodPost, "/deadletters/msg-1/reprocess", "/deadletters/:messageID/reprocess", h.HandleReprocess); w.Code != http.StatusConflict {
+		t.Errorf("second reprocess status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestReprocessRejectsMissingDeadLetter(t *testing.T) {
+	h, db := newTestEmailHandler(t, &fake.AI{})
+
+	if w := serve(http.MethodPost, "/deadletters/missing/reprocess", "/deadletters/:messageID/reprocess", h.HandleReprocess); w.Code != http.StatusNotFound {
+		t.Errorf("missing dead letter status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	db.FailOn("GetDeadLetter", errors.New("dbpilot unavailable"))
+	if w := serve(http.MethodPost, "/deadletters/msg-1/reprocess", "/deadletters/:messageID/reprocess", h.HandleReprocess); w.Code != http.StatusInternalServerError {
+		t.Errorf("failing store status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestHandleCheckStatus(t *testing.T) {
+	h, db := newTestEmailHandler(t, &fake.AI{})
+
+	if w := serve(http.MethodGet, "/status/missing", "/status/:messageID", h.HandleCheckStatus); w.Code != http.StatusNotFound {
+		t.Errorf("missing status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	db.UpdateProcessingStatus(models.NewProcessingStatus("msg-1"))
+	if w := serve(http.MethodGet, "/status/msg-1", "/status/:messageID", h.HandleCheckStatus); w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	db.FailOn("GetProcessingStatus", errors.New("dbpilot unavailable"))
+	if w := serve(http.MethodGet, "/status/msg-1", "/status/:messageID", h.HandleCheckStatus); w.Code != http.StatusInternalServerError {
+		t.Errorf("failing store status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestHandleEmailReceiveSkipsDuplicates(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestEmailHandler(t, ai)
+	router := gin.New()
+	router.POST("/receive", h.HandleEmailReceive)
+	receive := func() (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/receive", strings.NewReader(`{"from":"monitor@example.com","subject":"disk full","body":"web01"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Message-ID", "msg-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+
+	if w, _ := receive(); w.Code != http.StatusAccepted {
+		t.Fatalf("first delivery status = %d, body = %s", w.Code, w.Body.String())
+	}
+	waitForStatus(t, db, "msg-1", models.StatusComplete)
+
+	// 上流の再送は処理し直さず、既存の処理状態を返す
+	w, body := receive()
+	if w.Code != http.StatusOK || body["duplicate"] != true || body["status"] != string(models.StatusComplete) {
+		t.Errorf("duplicate delivery status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if calls := ai.Calls("msg-1"); calls != 1 {
+		t.Errorf("AI called %d times, want 1", calls)
+	}
+
+	// 失敗したメッセージの再送は取り込み直す
+	failed := &models.ProcessingStatus{MessageID: "msg-1"}
+	failed.SetFailed(errors.New("ai endpoint unavailable"))
+	db.UpdateProcessingStatus(failed)
+	if w, _ := receive(); w.Code != http.StatusAccepted {
+		t.Fatalf("redelivery after failure status = %d, body = %s", w.Code, w.Body.String())
+	}
+	waitForStatus(t, db, "msg-1", models.StatusComplete)
+	if calls := ai.Calls("msg-1"); calls != 2 {
+		t.Errorf("AI called %d times, want 2", calls)
+	}
+}
+
+func TestIngestEmailRecordsSteps(t *testing.T) {
+	h, db := newTestEmailHandler(t, &fake.AI{})
+	events := services.NewEventRecorder(db, 100)
+	h.SetEventRecorder(events)
+	ctx, cancel := context.WithCancel(context.Background())
+	events.Start(ctx)
+
+	if _, _, err := h.ingestEmail("msg-1", testEmail(), "", "", nil); err != nil {
+		t.Fatalf("ingestEmail: %v", err)
+	}
+	waitForStatus(t, db, "msg-1", models.StatusComplete)
+	cancel()
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	events.Wait(waitCtx)
+
+	want := []models.MessageStep{models.StepReceived, models.StepSavedEmail, models.StepAIStarted, models.StepAISucceeded, models.StepIncidentSaved}
+	got := db.Events("msg-1")
+	if len(got) != len(want) {
+		t.Fatalf("steps = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("steps = %v, want %v", got, want)
+			break
+		}
+	}
+}
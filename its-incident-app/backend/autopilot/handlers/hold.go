@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"autopilot/logger"
+	"autopilot/models"
+	"autopilot/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// HoldDecisionRequest は承認・却下時に受け付けるリクエストです
+type HoldDecisionRequest struct {
+	Operator string `json:"operator"`
+	Reason   string `json:"reason"`
+}
+
+// requiresApproval は送信者が手動承認の対象かを判定します。
+// HOLD_SENDERSにはメールアドレス、またはドメイン（example.com / @example.com）を指定できます
+func (h *EmailHandler) requiresApproval(from string) bool {
+	if len(h.holdSenders) == 0 {
+		return false
+	}
+
+	address := strings.ToLower(strings.TrimSpace(from))
+	if parsed, err := mail.ParseAddress(from); err == nil {
+		address = strings.ToLower(parsed.Address)
+	}
+
+	domain := address
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		domain = address[at+1:]
+	}
+
+	for _, sender := range h.holdSenders {
+		if sender == address || strings.TrimPrefix(sender, "@") == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleListHeld は承認待ちのメッセージ一覧を返します
+func (h *EmailHandler) HandleListHeld(c *gin.Context) {
+	statuses, err := h.statusStore.ListProcessingStatuses(models.StatusHeld)
+	if err != nil {
+		logger.Logger.Error("承認待ち一覧の取得に失敗しました",
+			zap.String("handler", "HandleListHeld"),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list held messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count": len(statuses),
+		"data":  statuses,
+	})
+}
+
+// HandleApproveHold は承認待ちのメッセージを承認し、AI処理を開始します
+func (h *EmailHandler) HandleApproveHold(c *gin.Context) {
+	messageID := c.Param("messageID")
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("handler", "HandleApproveHold"),
+	}
+
+	var req HoldDecisionRequest
+	_ = c.ShouldBindJSON(&req)
+	logFields = append(logFields, zap.String("operator", req.Operator))
+
+	if !h.ensureHeld(c, messageID, logFields) {
+		return
+	}
+
+	emailData, err := h.dbpilotService.GetEmail(messageID)
+	if err != nil {
+		logger.Logger.Error("保留中のメールデータの取得に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to load held email",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	// 承認待ちの場合だけ処理待ちにする（同時に承認・却下された場合は1つだけ成功し、AI処理を重複して実行しない）
+	status := models.NewProcessingStatus(messageID)
+	if err := h.statusStore.TransitionProcessingStatus(models.StatusHeld, status); err != nil {
+		h.respondHoldTransitionError(c, messageID, err, logFields)
+		return
+	}
+
+	logger.Logger.Info("保留中のメッセージが承認されました", logFields...)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":     "processing",
+		"message":    "Held email approved and being processed",
+		"message_id": messageID,
+	})
+
+	h.dispatchAIProcessing(messageID, emailData, "", logFields)
+}
+
+// HandleRejectHold は承認待ちのメッセージを却下し、AI処理を行わずに終了します
+func (h *EmailHandler) HandleRejectHold(c *gin.Context) {
+	messageID := c.Param("messageID")
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("handler", "HandleRejectHold"),
+	}
+
+	var req HoldDecisionRequest
+	_ = c.ShouldBindJSON(&req)
+	logFields = append(logFields, zap.String("operator", req.Operator))
+
+	if !h.ensureHeld(c, messageID, logFields) {
+		return
+	}
+
+	reason := "rejected by operator"
+	if req.Reason != "" {
+		reason = fmt.Sprintf("rejected by operator: %s", req.Reason)
+	}
+
+	status := &models.ProcessingStatus{MessageID: messageID}
+	status.SetRejected(reason)
+	if err := h.statusStore.TransitionProcessingStatus(models.StatusHeld, status); err != nil {
+		h.respondHoldTransitionError(c, messageID, err, logFields)
+		return
+	}
+
+	logger.Logger.Info("保留中のメッセージが却下されました",
+		append(logFields, zap.String("reason", reason))...)
+	h.notifyCompletion(messageID, logFields)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     string(models.StatusRejected),
+		"message_id": messageID,
+	})
+}
+
+// respondHoldTransitionError は承認待ちからの状態の変更に失敗した場合のレスポンスを返します
+// （他の操作で先に承認・却下された場合は 409）
+func (h *EmailHandler) respondHoldTransitionError(c *gin.Context, messageID string, err error, logFields []zap.Field) {
+	switch {
+	case errors.Is(err, services.ErrStatusConflict):
+		logger.Logger.Warn("他の操作で先に承認・却下されたメッセージです", logFields...)
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Message is not held for approval",
+			"message_id": messageID,
+		})
+	case strings.Contains(err.Error(), "not found"):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":      "Processing status not found",
+			"message_id": messageID,
+		})
+	default:
+		logger.Logger.Error("処理状態の更新に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to update processing status",
+			"message_id": messageID,
+		})
+	}
+}
+
+// ensureHeld はメッセージが承認待ち状態であることを確認し、そうでなければエラーレスポンスを返します
+func (h *EmailHandler) ensureHeld(c *gin.Context, messageID string, logFields []zap.Field) bool {
+	return h.ensureStatus(c, messageID, models.StatusHeld, "Message is not held for approval", logFields)
+}
+
+// ensureStatus はメッセージが want の状態であることを確認し、そうでなければ conflict をエラーとしてレスポンスを返します
+func (h *EmailHandler) ensureStatus(c *gin.Context, messageID string, want models.ProcessStatus, conflict string, logFields []zap.Field) bool {
+	status, err := h.statusStore.GetProcessingStatus(messageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":      "Processing status not found",
+				"message_id": messageID,
+			})
+			return false
+		}
+		logger.Logger.Error("処理状態の取得に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to get processing status",
+			"message_id": messageID,
+		})
+		return false
+	}
+
+	if status.Status != want {
+		logger.Logger.Warn("操作できない状態のメッセージです",
+			append(logFields, zap.String("status", string(status.Status)), zap.String("expected", string(want)))...)
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      conflict,
+			"message_id": messageID,
+			"status":     status.Status,
+		})
+		return false
+	}
+	return true
+}
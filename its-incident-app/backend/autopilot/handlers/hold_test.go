@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"autopilot/models"
+	"autopilot/services/fake"
+)
+
+// barrierStatusStore は最初の n 回の処理状態の取得を、n 回そろうまで待たせます。
+// 同時に届いた承認がすべて「承認待ち」を確認した後に状態を変更する順序を再現します
+type barrierStatusStore struct {
+	*fake.DBPilot
+
+	mu      sync.Mutex
+	waiting int
+	release chan struct{}
+}
+
+func newBarrierStatusStore(db *fake.DBPilot, n int) *barrierStatusStore {
+	return &barrierStatusStore{DBPilot: db, waiting: n, release: make(chan struct{})}
+}
+
+func (s *barrierStatusStore) GetProcessingStatus(messageID string) (*models.ProcessingStatus, error) {
+	status, err := s.DBPilot.GetProcessingStatus(messageID)
+
+	s.mu.Lock()
+	if s.waiting == 0 {
+		s.mu.Unlock()
+		return status, err
+	}
+	s.waiting--
+	if s.waiting == 0 {
+		close(s.release)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.release:
+	case <-time.After(5 * time.Second):
+	}
+	return status, err
+}
+
+// holdTestMessage は承認待ちのメッセージを登録します
+func holdTestMessage(t *testing.T, db *fake.DBPilot, messageID string) {
+	t.Helper()
+	if err := db.SaveEmail(testEmail(), messageID); err != nil {
+		t.Fatalf("SaveEmail: %v", err)
+	}
+	status := &models.ProcessingStatus{MessageID: messageID, Status: models.StatusHeld}
+	if err := db.UpdateProcessingStatus(status); err != nil {
+		t.Fatalf("UpdateProcessingStatus: %v", err)
+	}
+}
+
+func TestHandleApproveHoldConcurrentApprovals(t *testing.T) {
+	const approvals = 8
+	ai := &fake.AI{}
+	db := fake.NewDBPilot()
+	workers := NewWorkerPool(2, 10)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		workers.Shutdown(ctx)
+	})
+	h := NewEmailHandler(db, newBarrierStatusStore(db, approvals), ai, nil, workers, nil, nil, nil, nil)
+	holdTestMessage(t, db, "msg-1")
+
+	codes := make(chan int, approvals)
+	var wg sync.WaitGroup
+	for i := 0; i < approvals; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(http.MethodPost, "/hold/msg-1/approve", "/hold/:messageID/approve", h.HandleApproveHold).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	accepted := 0
+	for code := range codes {
+		switch code {
+		case http.StatusAccepted:
+			accepted++
+		case http.StatusConflict:
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("%d approvals accepted, want 1", accepted)
+	}
+
+	waitForStatus(t, db, "msg-1", models.StatusComplete)
+	if calls := ai.Calls("msg-1"); calls != 1 {
+		t.Errorf("AI called %d times, want 1", calls)
+	}
+	if incidents := db.Incidents("msg-1"); len(incidents) != 1 {
+		t.Errorf("%d incidents saved, want 1", len(incidents))
+	}
+}
+
+func TestHandleApproveHoldAfterReject(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestEmailHandler(t, ai)
+	holdTestMessage(t, db, "msg-1")
+
+	if w := serve(http.MethodPost, "/hold/msg-1/reject", "/hold/:messageID/reject", h.HandleRejectHold); w.Code != http.StatusOK {
+		t.Fatalf("reject status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPost, "/hold/msg-1/approve", "/hold/:messageID/approve", h.HandleApproveHold); w.Code != http.StatusConflict {
+		t.Errorf("approve after reject status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if calls := ai.Calls("msg-1"); calls != 0 {
+		t.Errorf("AI called %d times for a rejected message", calls)
+	}
+}
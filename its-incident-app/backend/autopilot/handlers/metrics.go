@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"autopilot/metrics"
+)
+
+// messageLatencyBuckets はメッセージの受信から処理終了までの時間（秒）用のバケット（待ち行列と再試行を含む）
+var messageLatencyBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// メッセージ単位の処理時間と再試行回数（/metrics で公開し、AI処理の滞留をアラートで検知する）
+var (
+	messageLatency = metrics.NewHistogramVec("autopilot_message_latency_seconds",
+		"Time from dispatching a message for AI processing until it finished, including queueing and retries.",
+		messageLatencyBuckets, "outcome")
+	taskRetries = metrics.NewHistogramVec("autopilot_task_retries",
+		"Cloud Tasks retries needed until a message finished.", []float64{0, 1, 2, 3, 5, 10}, "outcome")
+)
+
+// registerQueueMetrics はワーカープールの待ち行列の長さと実行中のジョブ数をゲージとして公開します
+func registerQueueMetrics(pool *WorkerPool) {
+	metrics.NewGaugeFunc("autopilot_ai_queue_depth",
+		"AI jobs waiting in the in-process worker queue.", func() float64 { return float64(pool.Pending()) })
+	metrics.NewGaugeFunc("autopilot_ai_jobs_active",
+		"AI jobs currently running on the in-process workers.", func() float64 { return float64(pool.Active()) })
+}
+
+// processOutcome はAI処理の結果をメトリクスのラベルにします
+func processOutcome(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return "complete"
+	case errors.Is(err, errShuttingDown) || isShuttingDown(ctx):
+		return "requeue"
+	default:
+		return "failed"
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"autopilot/logger"
+	"autopilot/models"
+	"autopilot/pubsub"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// messageIDAttribute はPub/Subメッセージの属性のうち、X-Message-ID に相当するもの
+	messageIDAttribute = "message_id"
+	// callbackURLAttribute はPub/Subメッセージの属性のうち、X-Callback-URL に相当するもの
+	callbackURLAttribute = "callback_url"
+)
+
+// HandlePubSubMessage はPub/Subで受け取ったメールを /receive と同様に取り込みます。
+// メールデータの保存に成功した時点でACKし（AI処理は非同期で継続）、保存に失敗した場合は再配信させます
+func (h *EmailHandler) HandlePubSubMessage(ctx context.Context, msg *pubsub.Message) error {
+	messageID := msg.Attributes[messageIDAttribute]
+	if messageID == "" {
+		messageID = msg.ID
+	}
+
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("handler", "HandlePubSubMessage"),
+		zap.String("pubsub_message_id", msg.ID),
+	}
+
+	var emailData models.EmailData
+	if err := json.Unmarshal(msg.Data, &emailData); err != nil {
+		// 再配信しても処理できないためACKして破棄する
+		logger.Logger.Error("Pub/Subメッセージのデコードに失敗したため破棄します",
+			append(logFields, zap.Error(err))...)
+		return nil
+	}
+
+	// 不正なコールバックURLは再配信しても直らないため、通知なしで取り込む
+	callbackURL := msg.Attributes[callbackURLAttribute]
+	if callbackURL != "" {
+		if err := h.callbacks.ValidateURL(callbackURL); err != nil {
+			logger.Logger.Warn("コールバックURLが不正なため無視します",
+				append(logFields, zap.String("callback_url", callbackURL), zap.Error(err))...)
+			callbackURL = ""
+		}
+	}
+
+	if _, _, err := h.ingestEmail(messageID, &emailData, callbackURL, msg.Attributes[dryRunAttribute], logFields); err != nil && !errors.Is(err, errDuplicate) {
+		return err
+	}
+
+	logger.Logger.Debug("Pub/Subからメールを取り込みました", logFields...)
+	return nil
+}
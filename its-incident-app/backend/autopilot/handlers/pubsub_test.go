@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"autopilot/models"
+	"autopilot/pubsub"
+	"autopilot/services/fake"
+)
+
+func pubsubTestMessage(attributes map[string]string) *pubsub.Message {
+	return &pubsub.Message{
+		ID:         "pubsub-1",
+		Data:       []byte(`{"from":"monitor@example.com","subject":"disk full","body":"web01"}`),
+		Attributes: attributes,
+	}
+}
+
+func TestHandlePubSubMessage(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestEmailHandler(t, ai)
+
+	// 属性の message_id を使って /receive と同様に取り込む
+	msg := pubsubTestMessage(map[string]string{messageIDAttribute: "msg-1", callbackURLAttribute: "https://hooks.example.com/done"})
+	if err := h.HandlePubSubMessage(context.Background(), msg); err != nil {
+		t.Fatalf("HandlePubSubMessage: %v", err)
+	}
+	waitForStatus(t, db, "msg-1", models.StatusComplete)
+
+	// コールバックが設定されていないためURLは無視し、取り込みは続ける
+	if status, _ := db.GetProcessingStatus("msg-1"); status.CallbackURL != "" {
+		t.Errorf("callback URL = %q, want ignored", status.CallbackURL)
+	}
+
+	// 再配信は重複としてACKし、処理し直さない
+	if err := h.HandlePubSubMessage(context.Background(), msg); err != nil {
+		t.Errorf("redelivery: %v", err)
+	}
+	if calls := ai.Calls("msg-1"); calls != 1 {
+		t.Errorf("AI called %d times, want 1", calls)
+	}
+
+	// message_id の属性がなければPub/SubのメッセージIDを使う
+	if err := h.HandlePubSubMessage(context.Background(), pubsubTestMessage(nil)); err != nil {
+		t.Fatalf("HandlePubSubMessage without message_id: %v", err)
+	}
+	waitForStatus(t, db, "pubsub-1", models.StatusComplete)
+}
+
+func TestHandlePubSubMessageAcksUndecodableData(t *testing.T) {
+	h, db := newTestEmailHandler(t, &fake.AI{})
+
+	// 再配信しても処理できないメッセージはACKして破棄する
+	msg := &pubsub.Message{ID: "pubsub-1", Data: []byte("not json")}
+	if err := h.HandlePubSubMessage(context.Background(), msg); err != nil {
+		t.Errorf("HandlePubSubMessage = %v, want ack", err)
+	}
+	if _, err := db.GetProcessingStatus("pubsub-1"); err == nil {
+		t.Error("processing status created for an undecodable message")
+	}
+}
+
+func TestHandlePubSubMessageRedeliversOnSaveFailure(t *testing.T) {
+	h, db := newTestEmailHandler(t, &fake.AI{})
+	db.FailOn("SaveEmail", errors.New("dbpilot unavailable"))
+
+	// メールデータを保存できない場合は再配信させる
+	if err := h.HandlePubSubMessage(context.Background(), pubsubTestMessage(map[string]string{messageIDAttribute: "msg-1"})); err == nil {
+		t.Error("HandlePubSubMessage acked a message that was not saved")
+	}
+}
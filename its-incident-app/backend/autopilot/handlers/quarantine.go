@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"autopilot/logger"
+	"autopilot/models"
+	"autopilot/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// SetSpamScorer はスパム・ノイズ判定を設定します。nil の場合はすべてのメールをAI処理します
+func (h *EmailHandler) SetSpamScorer(spam *services.SpamScorer) {
+	h.spam = spam
+}
+
+// HandleListQuarantined は隔離中のメッセージ一覧を返します。error にスコアと一致したルールが入ります
+func (h *EmailHandler) HandleListQuarantined(c *gin.Context) {
+	statuses, err := h.statusStore.ListProcessingStatuses(models.StatusQuarantined)
+	if err != nil {
+		logger.Logger.Error("隔離中のメッセージ一覧の取得に失敗しました",
+			zap.String("handler", "HandleListQuarantined"),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list quarantined messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count": len(statuses),
+		"data":  statuses,
+	})
+}
+
+// HandleReleaseQuarantined は隔離中のメッセージを解放し、AI処理を開始します（誤判定の救済用）
+func (h *EmailHandler) HandleReleaseQuarantined(c *gin.Context) {
+	messageID := c.Param("messageID")
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("handler", "HandleReleaseQuarantined"),
+	}
+
+	var req HoldDecisionRequest
+	_ = c.ShouldBindJSON(&req)
+	logFields = append(logFields, zap.String("operator", req.Operator))
+
+	if !h.ensureStatus(c, messageID, models.StatusQuarantined, "Message is not quarantined", logFields) {
+		return
+	}
+
+	emailData, err := h.dbpilotService.GetEmail(messageID)
+	if err != nil {
+		logger.Logger.Error("隔離中のメールデータの取得に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to load quarantined email",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	status := models.NewProcessingStatus(messageID)
+	if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
+		logger.Logger.Error("処理状態の更新に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to update processing status",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	logger.Logger.Info("隔離中のメッセージが解放されました", logFields...)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":     "processing",
+		"message":    "Quarantined email released and being processed",
+		"message_id": messageID,
+	})
+
+	h.dispatchAIProcessing(messageID, emailData, "", logFields)
+}
+
+// HandleDiscardQuarantined は隔離中のメッセージをスパム・ノイズとして確定し、AI処理を行わずに終了します
+func (h *EmailHandler) HandleDiscardQuarantined(c *gin.Context) {
+	messageID := c.Param("messageID")
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("handler", "HandleDiscardQuarantined"),
+	}
+
+	var req HoldDecisionRequest
+	_ = c.ShouldBindJSON(&req)
+	logFields = append(logFields, zap.String("operator", req.Operator))
+
+	if !h.ensureStatus(c, messageID, models.StatusQuarantined, "Message is not quarantined", logFields) {
+		return
+	}
+
+	reason := "discarded as spam by operator"
+	if req.Reason != "" {
+		reason = fmt.Sprintf("discarded as spam by operator: %s", req.Reason)
+	}
+
+	status := &models.ProcessingStatus{MessageID: messageID}
+	status.SetRejected(reason)
+	if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
+		logger.Logger.Error("破棄状態の更新に失敗しました",
+			append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to update processing status",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	logger.Logger.Info("隔離中のメッセージが破棄されました",
+		append(logFields, zap.String("reason", reason))...)
+	h.notifyCompletion(messageID, logFields)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     string(models.StatusRejected),
+		"message_id": messageID,
+	})
+}
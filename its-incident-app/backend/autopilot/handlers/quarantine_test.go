@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"autopilot/config"
+	"autopilot/models"
+	"autopilot/services"
+	"autopilot/services/fake"
+)
+
+// quarantineTestMessage は自動応答のメールを受信し、隔離されたことを確認します
+func quarantineTestMessage(t *testing.T, h *EmailHandler, db *fake.DBPilot, messageID string) {
+	t.Helper()
+	email := testEmail()
+	email.Subject = "自動返信: 不在のお知らせ"
+
+	status, _, err := h.ingestEmail(messageID, email, "", "", nil)
+	if err != nil {
+		t.Fatalf("ingestEmail: %v", err)
+	}
+	if status != models.StatusQuarantined {
+		t.Fatalf("status = %s, want %s", status, models.StatusQuarantined)
+	}
+	saved, err := db.GetProcessingStatus(messageID)
+	if err != nil || !strings.Contains(saved.Error, "out_of_office") {
+		t.Errorf("saved status = %+v, err = %v, want the matched rule in error", saved, err)
+	}
+}
+
+func newTestQuarantineHandler(t *testing.T, ai *fake.AI) (*EmailHandler, *fake.DBPilot) {
+	t.Helper()
+	h, db := newTestEmailHandler(t, ai)
+	h.SetSpamScorer(services.NewSpamScorer(config.SpamConfig{Enabled: true, Threshold: 5}))
+	return h, db
+}
+
+func TestIngestEmailQuarantinesSpam(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestQuarantineHandler(t, ai)
+
+	quarantineTestMessage(t, h, db, "msg-1")
+	time.Sleep(50 * time.Millisecond)
+	if calls := ai.Calls("msg-1"); calls != 0 {
+		t.Errorf("AI called %d times for a quarantined message", calls)
+	}
+
+	// しきい値に届かないメールは通常どおりAI処理する
+	if _, _, err := h.ingestEmail("msg-2", testEmail(), "", "", nil); err != nil {
+		t.Fatalf("ingestEmail: %v", err)
+	}
+	waitForStatus(t, db, "msg-2", models.StatusComplete)
+}
+
+func TestReleaseQuarantinedProcessesMessage(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestQuarantineHandler(t, ai)
+	quarantineTestMessage(t, h, db, "msg-1")
+
+	// 誤判定のメールを解放するとAI処理してインシデントを作成する
+	w := serve(http.MethodPost, "/quarantine/msg-1/release", "/quarantine/:messageID/release", h.HandleReleaseQuarantined)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("release status = %d, body = %s", w.Code, w.Body.String())
+	}
+	waitForStatus(t, db, "msg-1", models.StatusComplete)
+	if incidents := db.Incidents("msg-1"); len(incidents) != 1 {
+		t.Errorf("incidents = %+v, want 1", incidents)
+	}
+
+	// 隔離中でないメッセージは解放できない
+	if w := serve(http.MethodPost, "/quarantine/msg-1/release", "/quarantine/:messageID/release", h.HandleReleaseQuarantined); w.Code != http.StatusConflict {
+		t.Errorf("second release status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestDiscardQuarantinedRejectsMessage(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestQuarantineHandler(t, ai)
+	quarantineTestMessage(t, h, db, "msg-1")
+
+	w := serve(http.MethodPost, "/quarantine/msg-1/discard", "/quarantine/:messageID/discard", h.HandleDiscardQuarantined)
+	if w.Code != http.StatusOK {
+		t.Fatalf("discard status = %d, body = %s", w.Code, w.Body.String())
+	}
+	status, err := db.GetProcessingStatus("msg-1")
+	if err != nil || status.Status != models.StatusRejected {
+		t.Errorf("status = %+v, err = %v, want rejected", status, err)
+	}
+	if calls := ai.Calls("msg-1"); calls != 0 {
+		t.Errorf("AI called %d times for a discarded message", calls)
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 依存サービスの状態
+const (
+	dependencyUp   = "up"
+	dependencyDown = "down"
+)
+
+// dependencyStatus は依存サービスごとのチェック結果
+type dependencyStatus struct {
+	Status      string `json:"status"`
+	Critical    bool   `json:"critical"`
+	LatencyMS   int64  `json:"latency_ms"`
+	BreakerOpen bool   `json:"breaker_open,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ReadinessHandler は /ready で依存サービス（dbpilotとAIプロバイダー）の疎通を確認します
+type ReadinessHandler struct {
+	dbpilot        *services.DBPilotService
+	ai             *services.AIService
+	dbpilotTimeout time.Duration
+	aiTimeout      time.Duration
+}
+
+func NewReadinessHandler(dbpilot *services.DBPilotService, ai *services.AIService, dbpilotTimeout, aiTimeout time.Duration) *ReadinessHandler {
+	return &ReadinessHandler{
+		dbpilot:        dbpilot,
+		ai:             ai,
+		dbpilotTimeout: dbpilotTimeout,
+		aiTimeout:      aiTimeout,
+	}
+}
+
+// HandleReady はdbpilotとAIプロバイダーの疎通を並行に確認し、依存サービスごとの結果を返します。
+// dbpilotに到達できない場合とすべてのAIプロバイダーに到達できない場合は503、一部のプロバイダーのみの停止は degraded として200を返します
+func (h *ReadinessHandler) HandleReady(c *gin.Context) {
+	ctx := c.Request.Context()
+	results := make(map[string]dependencyStatus)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pingCtx, cancel := context.WithTimeout(ctx, h.dbpilotTimeout)
+		defer cancel()
+
+		start := time.Now()
+		err := h.dbpilot.Ping(pingCtx)
+		result := dependencyStatus{Status: dependencyUp, Critical: true, LatencyMS: time.Since(start).Milliseconds()}
+		if err != nil {
+			result.Status = dependencyDown
+			result.Error = err.Error()
+		}
+		mu.Lock()
+		results["dbpilot"] = result
+		mu.Unlock()
+	}()
+	go func() {
+		defer wg.Done()
+		pingCtx, cancel := context.WithTimeout(ctx, h.aiTimeout)
+		defer cancel()
+
+		for _, ping := range h.ai.Ping(pingCtx) {
+			result := dependencyStatus{
+				Status:      dependencyUp,
+				LatencyMS:   ping.Latency.Milliseconds(),
+				BreakerOpen: ping.BreakerOpen,
+			}
+			if ping.Err != nil {
+				result.Status = dependencyDown
+				result.Error = ping.Err.Error()
+			}
+			mu.Lock()
+			results["ai:"+ping.Name] = result
+			mu.Unlock()
+		}
+	}()
+	wg.Wait()
+
+	overall := "ok"
+	httpStatus := http.StatusOK
+	providersUp, providers := 0, 0
+	for name, result := range results {
+		if name != "dbpilot" {
+			providers++
+			if result.Status == dependencyUp {
+				providersUp++
+			}
+		}
+		if result.Status == dependencyUp {
+			continue
+		}
+		if result.Critical {
+			overall = "unavailable"
+		} else if overall == "ok" {
+			overall = "degraded"
+		}
+	}
+	// 1つでもAIプロバイダーに到達できればフォールバックで処理できる
+	if providers > 0 && providersUp == 0 {
+		overall = "unavailable"
+	}
+	if overall == "unavailable" {
+		httpStatus = http.StatusServiceUnavailable
+	}
+
+	if overall != "ok" {
+		logger.Logger.Warn("依存サービスに異常があります",
+			zap.String("status", overall), zap.Any("dependencies", results))
+	}
+
+	c.JSON(httpStatus, gin.H{
+		"status":       overall,
+		"dependencies": results,
+	})
+}
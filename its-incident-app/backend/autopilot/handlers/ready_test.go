@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"autopilot/config"
+	"autopilot/services"
+)
+
+// newTestReadinessHandler は dbpilot の /health と各AIプロバイダーが status を返す ReadinessHandler を作成します
+func newTestReadinessHandler(t *testing.T, dbpilotStatus int, providerStatus ...int) *ReadinessHandler {
+	t.Helper()
+	t.Setenv("TEST_AI_TOKEN", "ai-token")
+	statusServer := func(status int) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+
+	names := []string{"primary", "secondary"}
+	providers := make([]config.AIProviderConfig, len(providerStatus))
+	for i, status := range providerStatus {
+		providers[i] = config.AIProviderConfig{Name: names[i], Endpoint: statusServer(status), TokenEnv: "TEST_AI_TOKEN"}
+	}
+	ai := services.NewAIService(providers, config.AIWorkflowConfig{}, config.AIShadowConfig{}, config.AIRetryConfig{}, 0, 0, nil)
+	dbpilot := services.NewDBPilotService(statusServer(dbpilotStatus), "service-token")
+	return NewReadinessHandler(dbpilot, ai, time.Second, time.Second)
+}
+
+func TestHandleReady(t *testing.T) {
+	tests := []struct {
+		name           string
+		dbpilot        int
+		providers      []int
+		wantCode       int
+		wantStatus     string
+		wantDependency map[string]string
+	}{
+		{"all up", http.StatusOK, []int{http.StatusMethodNotAllowed, http.StatusOK}, http.StatusOK, "ok",
+			map[string]string{"dbpilot": "up", "ai:primary": "up", "ai:secondary": "up"}},
+		// 一部のプロバイダーの停止はフォールバックで処理できるため degraded
+		{"one provider down", http.StatusOK, []int{http.StatusBadGateway, http.StatusOK}, http.StatusOK, "degraded",
+			map[string]string{"ai:primary": "down", "ai:secondary": "up"}},
+		{"token rejected", http.StatusOK, []int{http.StatusUnauthorized}, http.StatusServiceUnavailable, "unavailable",
+			map[string]string{"ai:primary": "down"}},
+		{"dbpilot down", http.StatusServiceUnavailable, []int{http.StatusOK}, http.StatusServiceUnavailable, "unavailable",
+			map[string]string{"dbpilot": "down", "ai:primary": "up"}},
+	}
+	for _, tt := range tests {
+		h := newTestReadinessHandler(t, tt.dbpilot, tt.providers...)
+		w := serve(http.MethodGet, "/ready", "/ready", h.HandleReady)
+
+		var body struct {
+			Status       string                      `json:"status"`
+			Dependencies map[string]dependencyStatus `json:"dependencies"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != tt.wantCode || body.Status != tt.wantStatus {
+			t.Errorf("%s: status = %d %q, want %d %q", tt.name, w.Code, body.Status, tt.wantCode, tt.wantStatus)
+		}
+		for name, want := range tt.wantDependency {
+			if got := body.Dependencies[name].Status; got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.name, name, got, want)
+			}
+		}
+	}
+}
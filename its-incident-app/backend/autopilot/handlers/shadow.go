@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+// shadowTimeout はシャドウのAIモデルの処理を待つ上限
+const shadowTimeout = 90 * time.Second
+
+// runShadow はシャドウ評価の対象のメッセージをシャドウのAIモデルでも処理し、本番の結果と比較できるように保存します。
+// ワーカープールで本番の処理とは独立して実行し、失敗しても本番の状態には影響させません
+func (h *EmailHandler) runShadow(ctx context.Context, messageID string, emailData *models.EmailData, primary *models.AIResponse, logFields []zap.Field) {
+	ctx, cancel := context.WithTimeout(ctx, shadowTimeout)
+	defer cancel()
+
+	payload := &models.ShadowResultPayload{
+		MessageID: messageID,
+		Primary:   primary,
+	}
+	shadow, err := h.aiService.ProcessShadow(ctx, messageID, emailData)
+	if err != nil {
+		logger.Logger.Warn("シャドウのAI処理に失敗しました",
+			append(logFields, zap.Error(err))...)
+		payload.Error = err.Error()
+	} else {
+		payload.Shadow = shadow
+	}
+
+	if err := h.dbpilotService.SaveShadowResult(payload); err != nil {
+		return
+	}
+
+	logger.Logger.Debug("シャドウの結果を保存しました",
+		append(logFields, zap.Bool("shadow_error", payload.Error != ""))...)
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"autopilot/config"
+	"autopilot/logger"
+	"autopilot/metrics"
+	"autopilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// defaultStaleThreshold は ConfigureStaleSweeper を呼ばない場合の滞留判定のしきい値
+const defaultStaleThreshold = 15 * time.Minute
+
+// staleSwept は滞留として失敗にしたメッセージの件数（action は failed / requeued）
+var staleSwept = metrics.NewCounterVec("autopilot_stale_swept_total",
+	"Messages stuck in pending/running that were marked failed by the stale sweeper.", "action")
+
+// StaleSweepRequest は滞留メッセージの掃除の条件です。省略した項目は設定値（STALE_THRESHOLD / STALE_REQUEUE）を使います
+type StaleSweepRequest struct {
+	OlderThan string `json:"older_than"` // この時間（例: 15m）以上更新のないメッセージを対象にする
+	Requeue   *bool  `json:"requeue"`
+	Limit     int    `json:"limit"`
+}
+
+// StaleSweepResult は滞留メッセージの掃除の結果です
+type StaleSweepResult struct {
+	Threshold string            `json:"threshold"`
+	Found     int               `json:"found"`
+	Failed    int               `json:"failed"`
+	Requeued  int               `json:"requeued"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// staleSweeper は pending/running のまま更新が止まったメッセージを失敗にする設定と、掃除の多重実行を防ぐロックを保持します
+type staleSweeper struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	requeue   bool
+}
+
+// ConfigureStaleSweeper は滞留判定のしきい値と再処理の既定値を設定します
+func (h *EmailHandler) ConfigureStaleSweeper(threshold time.Duration, requeue bool) {
+	h.sweeper.threshold = threshold
+	h.sweeper.requeue = requeue
+}
+
+// StartStaleSweeper は interval ごとに滞留メッセージを掃除するバックグラウンド処理を開始します（ctx のキャンセルで停止）
+func (h *EmailHandler) StartStaleSweeper(ctx context.Context, interval time.Duration) {
+	logger.Logger.Info("滞留メッセージの定期掃除を開始します",
+		zap.Duration("interval", interval),
+		zap.Duration("threshold", h.sweeper.threshold),
+		zap.Bool("requeue", h.sweeper.requeue))
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				result, err := h.sweepStale(h.sweeper.threshold, h.sweeper.requeue, defaultBatchLimit)
+				if err != nil {
+					logger.Logger.Error("滞留メッセージの掃除に失敗しました", zap.Error(err))
+				} else if result.Found > 0 {
+					logger.Logger.Warn("滞留メッセージを掃除しました",
+						zap.Int("found", result.Found),
+						zap.Int("failed", result.Failed),
+						zap.Int("requeued", result.Requeued))
+				}
+			}
+		}
+	}()
+}
+
+// HandleSweepStale は滞留メッセージを掃除します。schedule-service から定期的に呼び出すことを想定しています
+func (h *EmailHandler) HandleSweepStale(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "HandleSweepStale"),
+	}
+
+	var req StaleSweepRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+			return
+		}
+	}
+
+	threshold := h.sweeper.threshold
+	if req.OlderThan != "" {
+		d, err := time.ParseDuration(req.OlderThan)
+		if err != nil || d < config.MinStaleThreshold {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("older_than must be a duration of at least %s", config.MinStaleThreshold),
+			})
+			return
+		}
+		threshold = d
+	}
+	requeue := h.sweeper.requeue
+	if req.Requeue != nil {
+		requeue = *req.Requeue
+	}
+	if req.Limit <= 0 || req.Limit > maxBatchLimit {
+		req.Limit = defaultBatchLimit
+	}
+
+	result, err := h.sweepStale(threshold, requeue, req.Limit)
+	if err != nil {
+		logger.Logger.Error("滞留メッセージの掃除に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sweep stale messages"})
+		return
+	}
+
+	logger.Logger.Info("滞留メッセージを掃除しました",
+		append(logFields,
+			zap.Int("found", result.Found),
+			zap.Int("failed", result.Failed),
+			zap.Int("requeued", result.Requeued))...)
+	c.JSON(http.StatusOK, result)
+}
+
+// sweepStale は threshold 以上更新のない pending/running のメッセージをタイムアウトとして失敗にし、
+// デッドレターに保存します。requeue が true の場合は続けてAI処理をやり直します
+func (h *EmailHandler) sweepStale(threshold time.Duration, requeue bool, limit int) (*StaleSweepResult, error) {
+	h.sweeper.mu.Lock()
+	defer h.sweeper.mu.Unlock()
+
+	statuses, err := h.statusStore.SearchProcessingStatuses(models.StatusFilter{
+		Statuses:      []models.ProcessStatus{models.StatusPending, models.StatusRunning},
+		UpdatedBefore: time.Now().Add(-threshold),
+		Limit:         limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &StaleSweepResult{
+		Threshold: threshold.String(),
+		Found:     len(statuses),
+		Errors:    make(map[string]string),
+	}
+	sweptAt := time.Now().Unix()
+	for _, stale := range statuses {
+		logFields := []zap.Field{
+			zap.String("message_id", stale.MessageID),
+			zap.String("process", "stale_sweep"),
+			zap.String("stale_status", string(stale.Status)),
+		}
+
+		cause := fmt.Errorf("timeout: no progress in %s for %s", stale.Status, threshold)
+		status := &models.ProcessingStatus{MessageID: stale.MessageID}
+		status.SetFailed(cause)
+		if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
+			logger.Logger.Error("滞留メッセージの失敗状態の更新に失敗しました",
+				append(logFields, zap.Error(err))...)
+			result.Errors[stale.MessageID] = err.Error()
+			continue
+		}
+		result.Failed++
+		logger.Logger.Warn("滞留メッセージをタイムアウトとして失敗にしました", logFields...)
+
+		emailData, err := h.dbpilotService.GetEmail(stale.MessageID)
+		if err != nil {
+			logger.Logger.Error("滞留メッセージのメールデータの取得に失敗しました",
+				append(logFields, zap.Error(err))...)
+			result.Errors[stale.MessageID] = err.Error()
+			staleSwept.Inc("failed")
+			h.notifyCompletion(stale.MessageID, logFields)
+			continue
+		}
+		h.saveDeadLetter(stale.MessageID, emailData, cause, logFields)
+
+		if !requeue {
+			staleSwept.Inc("failed")
+			h.notifyCompletion(stale.MessageID, logFields)
+			continue
+		}
+
+		if err := h.statusStore.UpdateProcessingStatus(models.NewProcessingStatus(stale.MessageID)); err != nil {
+			logger.Logger.Error("処理状態の更新に失敗しました", append(logFields, zap.Error(err))...)
+			result.Errors[stale.MessageID] = err.Error()
+			staleSwept.Inc("failed")
+			h.notifyCompletion(stale.MessageID, logFields)
+			continue
+		}
+		h.dispatchAIProcessing(stale.MessageID, emailData, "sweep-"+strconv.FormatInt(sweptAt, 10), logFields)
+		result.Requeued++
+		staleSwept.Inc("requeued")
+	}
+	return result, nil
+}
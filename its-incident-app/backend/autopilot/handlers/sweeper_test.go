@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"autopilot/models"
+	"autopilot/services/fake"
+)
+
+// seedTestMessage は status のまま age だけ更新のないメッセージを登録します
+func seedTestMessage(t *testing.T, db *fake.DBPilot, messageID string, status models.ProcessStatus, age time.Duration) {
+	t.Helper()
+	if err := db.SaveEmail(testEmail(), messageID); err != nil {
+		t.Fatalf("SaveEmail: %v", err)
+	}
+	if err := db.UpdateProcessingStatus(&models.ProcessingStatus{MessageID: messageID, Status: status}); err != nil {
+		t.Fatalf("UpdateProcessingStatus: %v", err)
+	}
+	db.Backdate(messageID, age)
+}
+
+func sweepRequest(h *EmailHandler, body string) (*httptest.ResponseRecorder, StaleSweepResult) {
+	w := serveJSON(http.MethodPost, "/sweep/stale", "/sweep/stale", body, h.HandleSweepStale)
+	var result StaleSweepResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	return w, result
+}
+
+func TestSweepStaleFailsStuckMessages(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestEmailHandler(t, ai)
+	h.ConfigureStaleSweeper(15*time.Minute, false)
+	seedTestMessage(t, db, "stuck-pending", models.StatusPending, time.Hour)
+	seedTestMessage(t, db, "stuck-running", models.StatusRunning, 20*time.Minute)
+	seedTestMessage(t, db, "recent", models.StatusRunning, time.Minute)
+	seedTestMessage(t, db, "old-complete", models.StatusComplete, time.Hour)
+
+	w, result := sweepRequest(h, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if result.Found != 2 || result.Failed != 2 || result.Requeued != 0 || result.Threshold != "15m0s" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// 滞留したメッセージはタイムアウトとして失敗にし、デッドレターに保存する
+	for _, messageID := range []string{"stuck-pending", "stuck-running"} {
+		status, err := db.GetProcessingStatus(messageID)
+		if err != nil || status.Status != models.StatusFailed || !strings.Contains(status.Error, "timeout") {
+			t.Errorf("%s: status = %+v, err = %v, want failed with a timeout", messageID, status, err)
+		}
+		if _, err := db.GetDeadLetter(messageID); err != nil {
+			t.Errorf("%s: dead letter not saved: %v", messageID, err)
+		}
+	}
+	for messageID, want := range map[string]models.ProcessStatus{"recent": models.StatusRunning, "old-complete": models.StatusComplete} {
+		if status, _ := db.GetProcessingStatus(messageID); status.Status != want {
+			t.Errorf("%s: status = %s, want %s", messageID, status.Status, want)
+		}
+	}
+	if calls := ai.Calls("stuck-pending"); calls != 0 {
+		t.Errorf("AI called %d times without requeue", calls)
+	}
+}
+
+func TestSweepStaleRequeues(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestEmailHandler(t, ai)
+	h.ConfigureStaleSweeper(15*time.Minute, false)
+	seedTestMessage(t, db, "stuck", models.StatusRunning, 10*time.Minute)
+
+	// リクエストで指定したしきい値と再処理が設定値より優先される
+	w, result := sweepRequest(h, `{"older_than": "5m", "requeue": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if result.Found != 1 || result.Failed != 1 || result.Requeued != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	waitForStatus(t, db, "stuck", models.StatusComplete)
+	if calls := ai.Calls("stuck"); calls != 1 {
+		t.Errorf("AI called %d times, want 1", calls)
+	}
+}
+
+func TestSweepStaleRejectsInvalidRequest(t *testing.T) {
+	h, _ := newTestEmailHandler(t, &fake.AI{})
+
+	for _, body := range []string{`{"older_than": "soon"}`, `{"older_than": "1s"}`, `{"older_than": `} {
+		if w, _ := sweepRequest(h, body); w.Code != http.StatusBadRequest {
+			t.Errorf("body %s: status = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+}
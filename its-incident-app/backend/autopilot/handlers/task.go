@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"autopilot/logger"
+	"autopilot/models"
+	"autopilot/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// HandleProcessTask はCloud Tasksから配信されたAI処理タスクを実行します。
+// 2xx以外を返すとキューの設定に従って再試行されます。完了済みのメッセージは処理せずに成功を返すため、
+// 同じタスクが重複して配信されても問題ありません
+func (h *EmailHandler) HandleProcessTask(c *gin.Context) {
+	var payload services.TaskPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.MessageID == "" {
+		// 再試行しても成功しないため破棄する
+		logger.Logger.Error("AI処理タスクのペイロードが不正です", zap.Error(err))
+		c.JSON(http.StatusOK, gin.H{"status": "discarded"})
+		return
+	}
+
+	messageID := payload.MessageID
+	retryCount, _ := strconv.Atoi(c.GetHeader("X-CloudTasks-TaskRetryCount"))
+	finalAttempt := h.taskQueue == nil || retryCount+1 >= h.taskQueue.MaxAttempts()
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("handler", "HandleProcessTask"),
+		zap.String("task_name", c.GetHeader("X-CloudTasks-TaskName")),
+		zap.Int("retry_count", retryCount),
+	}
+
+	status, err := h.statusStore.GetProcessingStatus(messageID)
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		logger.Logger.Error("処理状態の取得に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to get processing status"})
+		return
+	}
+	if status != nil && (status.IsComplete() || status.Status == models.StatusRejected || status.IsHeld()) {
+		logger.Logger.Info("処理済みのメッセージのためタスクをスキップします",
+			append(logFields, zap.String("status", string(status.Status)))...)
+		c.JSON(http.StatusOK, gin.H{"status": string(status.Status), "message_id": messageID})
+		return
+	}
+
+	emailData, err := h.dbpilotService.GetEmail(messageID)
+	if err != nil {
+		logger.Logger.Error("メールデータの取得に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to load email"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.processTimeout)
+	defer cancel()
+
+	// 最終試行のみAI処理の失敗をエラーのインシデントとして保存する
+	if err := h.processAIAndSaveIncident(ctx, emailData, messageID, finalAttempt); err != nil {
+		failed := &models.ProcessingStatus{MessageID: messageID}
+		failed.SetFailed(err)
+		if updateErr := h.statusStore.UpdateProcessingStatus(failed); updateErr != nil {
+			logger.Logger.Error("エラー状態の更新に失敗しました", append(logFields, zap.Error(updateErr))...)
+		}
+
+		if finalAttempt {
+			logger.Logger.Error("最大試行回数に達したためAI処理を打ち切ります",
+				append(logFields, zap.Error(err))...)
+			h.saveDeadLetter(messageID, emailData, err, logFields)
+			h.notifyCompletion(messageID, logFields)
+			observeTask(payload, retryCount, "failed")
+			c.JSON(http.StatusOK, gin.H{"status": string(models.StatusFailed), "message_id": messageID})
+			return
+		}
+
+		logger.Logger.Warn("AI処理に失敗したためタスクを再試行します", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "AI processing failed", "message_id": messageID})
+		return
+	}
+
+	completed := &models.ProcessingStatus{MessageID: messageID}
+	completed.SetComplete()
+	if err := h.statusStore.UpdateProcessingStatus(completed); err != nil {
+		logger.Logger.Error("完了状態の更新に失敗しました", append(logFields, zap.Error(err))...)
+	}
+	h.notifyCompletion(messageID, logFields)
+	observeTask(payload, retryCount, "complete")
+
+	logger.Logger.Info("AI処理タスクが完了しました", logFields...)
+	c.JSON(http.StatusOK, gin.H{"status": string(models.StatusComplete), "message_id": messageID})
+}
+
+// observeTask はタスクの最終的な結果について、登録からの処理時間と再試行回数を記録します
+func observeTask(payload services.TaskPayload, retryCount int, outcome string) {
+	taskRetries.Observe(float64(retryCount), outcome)
+	if !payload.EnqueuedAt.IsZero() {
+		messageLatency.ObserveSince(payload.EnqueuedAt, outcome)
+	}
+}
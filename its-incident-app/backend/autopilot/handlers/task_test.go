@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"autopilot/models"
+	"autopilot/services"
+	"autopilot/services/fake"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testTaskMaxAttempts = 3
+
+// newTestTaskHandler はCloud Tasksからの配信を受けるハンドラーを作成し、処理対象のメールを登録します
+func newTestTaskHandler(t *testing.T, ai *fake.AI) (*EmailHandler, *fake.DBPilot) {
+	t.Helper()
+	h, db := newTestEmailHandler(t, ai)
+	h.taskQueue = services.NewTaskQueueService("projects/p/locations/l/queues/q", "http://autopilot/tasks/process-email", "", testTaskMaxAttempts)
+	if err := db.SaveEmail(testEmail(), "msg-1"); err != nil {
+		t.Fatalf("SaveEmail: %v", err)
+	}
+	return h, db
+}
+
+// deliverTask はCloud Tasksと同じヘッダーでタスクを配信します
+func deliverTask(h *EmailHandler, body string, retryCount int) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.POST("/tasks/process-email", h.HandleProcessTask)
+	req := httptest.NewRequest(http.MethodPost, "/tasks/process-email", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CloudTasks-TaskName", "ai-test")
+	req.Header.Set("X-CloudTasks-TaskRetryCount", strconv.Itoa(retryCount))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleProcessTaskCompletes(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestTaskHandler(t, ai)
+
+	if w := deliverTask(h, `{"message_id":"msg-1"}`, 0); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if status, err := db.GetProcessingStatus("msg-1"); err != nil || status.Status != models.StatusComplete {
+		t.Fatalf("status = %+v, err = %v, want complete", status, err)
+	}
+	if len(db.Incidents("msg-1")) != 1 {
+		t.Errorf("incidents = %d, want 1", len(db.Incidents("msg-1")))
+	}
+
+	// 重複して配信されたタスクは処理せずに成功を返す
+	if w := deliverTask(h, `{"message_id":"msg-1"}`, 0); w.Code != http.StatusOK {
+		t.Errorf("duplicate status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if calls := ai.Calls("msg-1"); calls != 1 {
+		t.Errorf("AI called %d times, want 1", calls)
+	}
+}
+
+func TestHandleProcessTaskRetriesUntilFinalAttempt(t *testing.T) {
+	failure := errors.New("ai endpoint unavailable")
+	ai := &fake.AI{Errors: []error{failure, failure, failure}}
+	h, db := newTestTaskHandler(t, ai)
+
+	// 最終試行より前の失敗は5xxを返してCloud Tasksに再試行させ、エラーのインシデントは保存しない
+	for retry := 0; retry < testTaskMaxAttempts-1; retry++ {
+		if w := deliverTask(h, `{"message_id":"msg-1"}`, retry); w.Code != http.StatusInternalServerError {
+			t.Fatalf("retry %d: status = %d, want %d", retry, w.Code, http.StatusInternalServerError)
+		}
+	}
+	if incidents := db.Incidents("msg-1"); len(incidents) != 0 {
+		t.Errorf("error incident saved before the final attempt: %+v", incidents)
+	}
+	if _, err := db.GetDeadLetter("msg-1"); err == nil {
+		t.Error("dead letter saved before the final attempt")
+	}
+
+	// 最終試行の失敗は200で打ち切り、エラーのインシデントとデッドレターを保存する
+	if w := deliverTask(h, `{"message_id":"msg-1"}`, testTaskMaxAttempts-1); w.Code != http.StatusOK {
+		t.Fatalf("final attempt: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	status, err := db.GetProcessingStatus("msg-1")
+	if err != nil || status.Status != models.StatusFailed {
+		t.Errorf("status = %+v, err = %v, want failed", status, err)
+	}
+	if incidents := db.Incidents("msg-1"); len(incidents) != 1 || incidents[0].Data.Status != "error" {
+		t.Errorf("unexpected incidents: %+v", incidents)
+	}
+	if _, err := db.GetDeadLetter("msg-1"); err != nil {
+		t.Errorf("dead letter not saved: %v", err)
+	}
+}
+
+func TestHandleProcessTaskDiscardsInvalidPayload(t *testing.T) {
+	ai := &fake.AI{}
+	h, _ := newTestTaskHandler(t, ai)
+
+	// 再試行しても成功しないペイロードは破棄する
+	for _, body := range []string{`not json`, `{}`} {
+		if w := deliverTask(h, body, 0); w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", body, w.Code, http.StatusOK)
+		}
+	}
+	if calls := ai.Calls("msg-1"); calls != 0 {
+		t.Errorf("AI called %d times, want 0", calls)
+	}
+}
+
+func TestHandleProcessTaskRetriesWhenStoreUnavailable(t *testing.T) {
+	h, db := newTestTaskHandler(t, &fake.AI{})
+	db.FailOn("GetEmail", errors.New("dbpilot unavailable"))
+
+	if w := deliverTask(h, `{"message_id":"msg-1"}`, 0); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+// replySubject は返信・転送の接頭辞で始まる件名（"Re:"、"Fwd:"、"返信:" など）
+var replySubject = regexp.MustCompile(`^\s*(?i:re|fw|fwd|aw|sv|返信|転送)(\[\d+\])?\s*[:：]`)
+
+// ConfigureThreading は返信メールを既存のインシデントに追加するかを設定します。
+// subjectWindow は件名で返信元を探す期間で、0 の場合は In-Reply-To・References だけで判定します
+func (h *EmailHandler) ConfigureThreading(enabled bool, subjectWindow time.Duration) {
+	h.threading = enabled
+	h.threadSubjectWindow = subjectWindow
+}
+
+// isReply はメールが返信の可能性があるか（スレッドのヘッダー、または返信の接頭辞付きの件名があるか）を返します
+func isReply(emailData *models.EmailData) bool {
+	return strings.TrimSpace(emailData.InReplyTo) != "" ||
+		strings.TrimSpace(emailData.References) != "" ||
+		replySubject.MatchString(emailData.Subject)
+}
+
+// threadReply は返信メールを返信元の既存のインシデントに追加し、処理状態を完了にします。
+// 返信元が見つからない場合・判定に失敗した場合は nil を返し、呼び出し元は通常どおりAI処理を行います
+func (h *EmailHandler) threadReply(messageID string, emailData *models.EmailData, logFields []zap.Field) *models.ProcessingStatus {
+	if !h.threading || !isReply(emailData) {
+		return nil
+	}
+
+	match, err := h.dbpilotService.ThreadEmail(messageID, h.threadSubjectWindow)
+	if err != nil {
+		logger.Logger.Warn("スレッドの判定に失敗したため新しいインシデントとして処理します",
+			append(logFields, zap.Error(err))...)
+		return nil
+	}
+	if match.IncidentID == 0 {
+		return nil
+	}
+	logFields = append(logFields,
+		zap.Uint("incident_id", match.IncidentID),
+		zap.String("matched_by", match.MatchedBy))
+
+	status := &models.ProcessingStatus{MessageID: messageID, IncidentID: match.IncidentID}
+	status.SetComplete()
+	if err := h.statusStore.UpdateProcessingStatus(status); err != nil {
+		logger.Logger.Error("完了状態の更新に失敗しました",
+			append(logFields, zap.Error(err))...)
+	}
+	h.events.Record(messageID, models.StepThreaded,
+		"incident_id", strconv.FormatUint(uint64(match.IncidentID), 10), "matched_by", match.MatchedBy)
+	h.notifyCompletion(messageID, logFields)
+
+	logger.Logger.Info("返信メールを既存のインシデントに追加しました", logFields...)
+	return status
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"autopilot/models"
+	"autopilot/services/fake"
+)
+
+// threadTestParent は返信元のメールと、そのインシデント（ID 5）を登録します
+func threadTestParent(t *testing.T, db *fake.DBPilot) {
+	t.Helper()
+	parent := testEmail()
+	parent.OriginalMessageID = "<parent@example.com>"
+	if err := db.SaveEmail(parent, "parent"); err != nil {
+		t.Fatalf("SaveEmail: %v", err)
+	}
+	status := &models.ProcessingStatus{MessageID: "parent", IncidentID: 5}
+	status.SetComplete()
+	if err := db.UpdateProcessingStatus(status); err != nil {
+		t.Fatalf("UpdateProcessingStatus: %v", err)
+	}
+}
+
+func testReply() *models.EmailData {
+	reply := testEmail()
+	reply.Subject = "Re: サーバーが応答しません"
+	reply.InReplyTo = "<parent@example.com>"
+	return reply
+}
+
+func TestIsReply(t *testing.T) {
+	tests := map[string]bool{
+		"Re: サーバーが応答しません":     true,
+		"RE[2]: サーバーが応答しません":  true,
+		"Fwd: サーバーが応答しません":    true,
+		"返信：サーバーが応答しません":      true,
+		"転送: サーバーが応答しません":     true,
+		"サーバーが応答しません":         false,
+		"Report: web01":       false,
+		"Regression in build": false,
+	}
+	for subject, want := range tests {
+		if got := isReply(&models.EmailData{Subject: subject}); got != want {
+			t.Errorf("isReply(%q) = %v, want %v", subject, got, want)
+		}
+	}
+
+	// スレッドのヘッダーがあれば件名に関係なく返信として扱う
+	if !isReply(&models.EmailData{Subject: "サーバーが応答しません", References: "<parent@example.com>"}) {
+		t.Error("email with References was not treated as a reply")
+	}
+}
+
+func TestIngestEmailThreadsReply(t *testing.T) {
+	ai := &fake.AI{}
+	h, db := newTestEmailHandler(t, ai)
+	h.ConfigureThreading(true, 0)
+	threadTestParent(t, db)
+
+	// 返信は新しいインシデントを作らず、返信元のインシデントに追加して完了にする
+	status, _, err := h.ingestEmail("reply", testReply(), "", "", nil)
+	if err != nil {
+		t.Fatalf("ingestEmail: %v", err)
+	}
+	if status != models.StatusComplete {
+		t.Errorf("status = %s, want %s", status, models.StatusComplete)
+	}
+	saved, err := db.GetProcessingStatus("reply")
+	if err != nil || saved.IncidentID != 5 {
+		t.Errorf("saved status = %+v, err = %v, want incident 5", saved, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if calls := ai.Calls("reply"); calls != 0 {
+		t.Errorf("AI called %d times for a threaded reply", calls)
+	}
+	if incidents := db.Incidents("reply"); len(incidents) != 0 {
+		t.Errorf("new incident created for a threaded reply: %+v", incidents)
+	}
+}
+
+func TestIngestEmailProcessesUnthreadedReply(t *testing.T) {
+	tests := []struct {
+		name      string
+		threading bool
+		reply     func() *models.EmailData
+		failOn    error
+	}{
+		{name: "threading disabled", reply: testReply},
+		{name: "unknown parent", threading: true, reply: func() *models.EmailData {
+			reply := testReply()
+			reply.InReplyTo = "<unknown@example.com>"
+			return reply
+		}},
+		{name: "thread lookup failure", threading: true, reply: testReply, failOn: errors.New("dbpilot unavailable")},
+	}
+	for _, tt := range tests {
+		h, db := newTestEmailHandler(t, &fake.AI{})
+		h.ConfigureThreading(tt.threading, 0)
+		threadTestParent(t, db)
+		if tt.failOn != nil {
+			db.FailOn("ThreadEmail", tt.failOn)
+		}
+
+		// 返信元が見つからない・判定に失敗した場合は通常どおりAI処理してインシデントを作成する
+		if _, _, err := h.ingestEmail("reply", tt.reply(), "", "", nil); err != nil {
+			t.Fatalf("%s: ingestEmail: %v", tt.name, err)
+		}
+		waitForStatus(t, db, "reply", models.StatusComplete)
+		if incidents := db.Incidents("reply"); len(incidents) != 1 {
+			t.Errorf("%s: incidents = %+v, want 1", tt.name, incidents)
+		}
+	}
+}
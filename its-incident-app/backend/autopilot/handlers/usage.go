@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"autopilot/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// HandleUsage はAIのトークン使用量・処理時間の集計（日別・送信者別）と、1日あたりの上限の状態を返します。
+// from/to は YYYY-MM-DD（JST）で、未指定の場合は当日です
+func (h *EmailHandler) HandleUsage(c *gin.Context) {
+	logFields := []zap.Field{
+		zap.String("handler", "HandleUsage"),
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+	}
+
+	report, err := h.dbpilotService.GetCostUsage(c.Query("from"), c.Query("to"))
+	if err != nil {
+		logger.Logger.Error("AI利用量の取得に失敗しました",
+			append(logFields, zap.Error(err))...)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "status: 400") {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"error":   "Failed to get usage",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"usage": report,
+		"budget": gin.H{
+			"daily_tokens": h.budget.Limit(),
+			"used_today":   h.budget.UsedTokens(),
+			"exceeded":     h.budget.Exceeded(),
+		},
+	})
+}
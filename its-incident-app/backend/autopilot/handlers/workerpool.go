@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"autopilot/logger"
+
+	"go.uber.org/zap"
+)
+
+// requeueGrace はシャットダウンの期限後、中断したジョブが requeue 状態を保存し終えるまで待つ時間
+const requeueGrace = 3 * time.Second
+
+// errShuttingDown はシャットダウンのためAI処理を受け付けない、または中断したことを表します
+var errShuttingDown = errors.New("AI worker pool is shutting down")
+
+// WorkerPool はインスタンス内のAI処理を固定数のワーカーで実行します。
+// キューが満杯の場合は受け付けず、呼び出し元で429などのバックプレッシャーを返します
+type WorkerPool struct {
+	mu     sync.RWMutex
+	closed bool
+	jobs   chan func(ctx context.Context)
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	active atomic.Int64
+}
+
+// NewWorkerPool は workers 個のワーカーと queueSize 件の待ち行列を持つWorkerPoolを起動します
+func NewWorkerPool(workers, queueSize int) *WorkerPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	pool := &WorkerPool{
+		jobs:   make(chan func(ctx context.Context), queueSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for i := 0; i < workers; i++ {
+		pool.wg.Add(1)
+		go pool.work()
+	}
+
+	logger.Logger.Info("AI処理のワーカープールを起動しました",
+		zap.Int("workers", workers),
+		zap.Int("queue_size", queueSize))
+	registerQueueMetrics(pool)
+
+	return pool
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		p.run(job)
+	}
+}
+
+func (p *WorkerPool) run(job func(ctx context.Context)) {
+	p.active.Add(1)
+	defer p.active.Add(-1)
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Logger.Error("AI処理のワーカーでパニックが発生しました", zap.Any("panic", r))
+		}
+	}()
+	// シャットダウンで中断された後に取り出したジョブも、ctx を見て requeue を記録できるよう実行する
+	job(p.ctx)
+}
+
+// TrySubmit はジョブを待ち行列に追加します。
+// 満杯の場合は errQueueFull、シャットダウン中の場合は errShuttingDown を返します
+func (p *WorkerPool) TrySubmit(job func(ctx context.Context)) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return errShuttingDown
+	}
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Full は新しいジョブを受け付けられない（待ち行列が満杯、またはシャットダウン中）かを返します
+func (p *WorkerPool) Full() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.closed || len(p.jobs) >= cap(p.jobs)
+}
+
+// Pending は待ち行列にあるジョブの件数を返します
+func (p *WorkerPool) Pending() int {
+	return len(p.jobs)
+}
+
+// Active は実行中のジョブの件数を返します
+func (p *WorkerPool) Active() int {
+	return int(p.active.Load())
+}
+
+// Context はワーカープールのジョブに渡すコンテキストを返します（シャットダウンの期限でキャンセルされます）
+func (p *WorkerPool) Context() context.Context {
+	return p.ctx
+}
+
+// Shutdown は新しいジョブの受け付けを止め、実行中・待機中のジョブが終わるまで ctx の期限まで待ちます。
+// 期限までに終わらないジョブはキャンセルし、各ジョブが requeue 状態を保存するまで requeueGrace だけ待ちます
+func (p *WorkerPool) Shutdown(ctx context.Context) {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	logger.Logger.Info("AI処理の完了を待機します", zap.Int("pending", len(p.jobs)))
+
+	select {
+	case <-done:
+		logger.Logger.Info("AI処理のワーカープールを停止しました")
+		return
+	case <-ctx.Done():
+	}
+
+	logger.Logger.Warn("期限までに終わらないAI処理を中断して再キューに戻します",
+		zap.Int("pending", len(p.jobs)))
+	p.cancel(errShuttingDown)
+
+	select {
+	case <-done:
+		logger.Logger.Info("AI処理のワーカープールを停止しました")
+	case <-time.After(requeueGrace):
+		logger.Logger.Error("AI処理の中断が完了しないまま終了します", zap.Int("pending", len(p.jobs)))
+	}
+}
+
+// isShuttingDown はシャットダウンによって ctx がキャンセルされたかを返します
+func isShuttingDown(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errShuttingDown)
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"autopilot/models"
+	"autopilot/services/fake"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shutdownPool はテスト終了時にワーカープールを停止します
+func shutdownPool(t *testing.T, pool *WorkerPool) {
+	t.Helper()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		pool.Shutdown(ctx)
+	})
+}
+
+// fillPool は workers 個のワーカーを release が閉じられるまで止め、待ち行列を満杯にします
+func fillPool(t *testing.T, pool *WorkerPool, workers, queueSize int) chan struct{} {
+	t.Helper()
+	release := make(chan struct{})
+	block := func(ctx context.Context) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		if err := pool.TrySubmit(block); err != nil {
+			t.Fatalf("TrySubmit: %v", err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Active() < workers && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < queueSize; i++ {
+		if err := pool.TrySubmit(block); err != nil {
+			t.Fatalf("TrySubmit: %v", err)
+		}
+	}
+	return release
+}
+
+func TestWorkerPoolRejectsWhenFull(t *testing.T) {
+	pool := NewWorkerPool(1, 2)
+	shutdownPool(t, pool)
+
+	release := fillPool(t, pool, 1, 2)
+	if !pool.Full() || pool.Active() != 1 || pool.Pending() != 2 {
+		t.Fatalf("Full = %v, Active = %d, Pending = %d, want a full pool", pool.Full(), pool.Active(), pool.Pending())
+	}
+	if err := pool.TrySubmit(func(context.Context) {}); !errors.Is(err, errQueueFull) {
+		t.Errorf("TrySubmit on a full pool = %v, want errQueueFull", err)
+	}
+
+	// 実行中のジョブが終われば再び受け付ける
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Full() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan struct{})
+	if err := pool.TrySubmit(func(context.Context) { close(done) }); err != nil {
+		t.Fatalf("TrySubmit after release: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not run")
+	}
+}
+
+func TestWorkerPoolRecoversFromPanic(t *testing.T) {
+	pool := NewWorkerPool(1, 2)
+	shutdownPool(t, pool)
+
+	done := make(chan struct{})
+	pool.TrySubmit(func(context.Context) { panic("broken job") })
+	pool.TrySubmit(func(context.Context) { close(done) })
+
+	// パニックしたジョブの後もワーカーは次のジョブを実行する
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker stopped after a panic")
+	}
+}
+
+func TestHandleEmailReceiveRejectsWhenQueueFull(t *testing.T) {
+	h, db := newTestEmailHandler(t, &fake.AI{})
+	pool := NewWorkerPool(1, 1)
+	shutdownPool(t, pool)
+	h.workers = pool
+	release := fillPool(t, pool, 1, 1)
+	defer close(release)
+
+	router := gin.New()
+	router.POST("/receive", h.HandleEmailReceive)
+	req := httptest.NewRequest(http.MethodPost, "/receive", strings.NewReader(`{"from":"monitor@example.com","subject":"disk full","body":"web01"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Message-ID", "msg-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// 待ち行列が満杯の場合は429で上流に再送させ、処理状態は作らない
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusTooManyRequests, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After header is missing")
+	}
+	if _, err := db.GetProcessingStatus("msg-1"); err == nil {
+		t.Error("processing status created for a rejected message")
+	}
+}
+
+func TestWorkerPoolShutdownDrainsJobs(t *testing.T) {
+	pool := NewWorkerPool(2, 10)
+
+	var mu sync.Mutex
+	finished := 0
+	for i := 0; i < 6; i++ {
+		pool.TrySubmit(func(context.Context) {
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			finished++
+			mu.Unlock()
+		})
+	}
+
+	// 期限内に終わるジョブは、待ち行列にあるものも含めてすべて実行してから停止する
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pool.Shutdown(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if finished != 6 {
+		t.Errorf("finished = %d, want 6", finished)
+	}
+	if err := pool.TrySubmit(func(context.Context) {}); !errors.Is(err, errShuttingDown) {
+		t.Errorf("TrySubmit after shutdown = %v, want errShuttingDown", err)
+	}
+}
+
+func TestWorkerPoolShutdownCancelsAfterDeadline(t *testing.T) {
+	pool := NewWorkerPool(1, 1)
+
+	cancelled := make(chan bool, 1)
+	started := make(chan struct{})
+	pool.TrySubmit(func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		cancelled <- isShuttingDown(ctx)
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	pool.Shutdown(ctx)
+
+	// 期限を過ぎたジョブはシャットダウンを理由にキャンセルする
+	if elapsed := time.Since(begin); elapsed > requeueGrace {
+		t.Errorf("Shutdown took %v", elapsed)
+	}
+	select {
+	case shuttingDown := <-cancelled:
+		if !shuttingDown {
+			t.Error("job context was not cancelled by shutdown")
+		}
+	default:
+		t.Error("job was not cancelled")
+	}
+}
+
+func TestShutdownRequeuesUnfinishedMessages(t *testing.T) {
+	h, db := newTestEmailHandler(t, &fake.AI{Delay: time.Minute})
+	pool := NewWorkerPool(1, 5)
+	h.workers = pool
+
+	for _, messageID := range []string{"msg-running", "msg-queued"} {
+		db.UpdateProcessingStatus(models.NewProcessingStatus(messageID))
+		h.dispatchAIProcessing(messageID, testEmail(), "", nil)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Active() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	pool.Shutdown(ctx)
+
+	// 実行中・待機中のメッセージは失敗ではなく requeue 状態にし、デッドレターには保存しない
+	for _, messageID := range []string{"msg-running", "msg-queued"} {
+		status, err := db.GetProcessingStatus(messageID)
+		if err != nil || status.Status != models.StatusRequeue {
+			t.Errorf("%s: status = %+v, err = %v, want requeue", messageID, status, err)
+		}
+		if _, err := db.GetDeadLetter(messageID); err == nil {
+			t.Errorf("%s: dead letter saved for an interrupted message", messageID)
+		}
+		if incidents := db.Incidents(messageID); len(incidents) != 0 {
+			t.Errorf("%s: incident saved for an interrupted message: %+v", messageID, incidents)
+		}
+	}
+}
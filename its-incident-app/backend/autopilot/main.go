@@ -11,8 +11,12 @@ import (
 	"autopilot/config"
 	"autopilot/handlers"
 	"autopilot/logger"
+	"autopilot/metrics"
 	"autopilot/middleware"
+	"autopilot/mtls"
+	"autopilot/pubsub"
 	"autopilot/services"
+	"shared/idtoken"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -25,9 +29,47 @@ func main() {
 		logger.Logger.Fatal("設定の初期化に失敗しました", zap.Error(err))
 	}
 
+	// 内部サービス呼び出しにクライアント証明書を付与（相互TLSが有効な場合のみ）
+	if err := mtls.InstallTransport(); err != nil {
+		logger.Logger.Fatal("mTLSクライアントの設定に失敗しました", zap.Error(err))
+	}
+
+	// IDトークンを受け付ける場合は呼び出し元のサービスアカウントを限定する（未設定の場合はすべてのIDトークンを拒否）
+	if err := idtoken.CheckConfig(); err != nil {
+		if cfg.Environment == "production" {
+			logger.Logger.Fatal("サービス認証の設定が不正です", zap.Error(err))
+		}
+		logger.Logger.Warn("サービス認証の設定が不正なため、IDトークンをすべて拒否します", zap.Error(err))
+	}
+
 	// サービスの初期化
 	dbpilotService := services.NewDBPilotService(cfg.DBPilotURL, cfg.ServiceToken)
-	aiService := services.NewAIService(cfg.AIEndpoint, cfg.AIToken)
+	// 処理状態の保存先（既定はdbpilot）
+	var statusStore services.StatusStore = dbpilotService
+	if cfg.StatusStore == "datastore" {
+		datastoreStore, err := services.NewDatastoreStatusStore(cfg.DatastoreDatabase, cfg.DatastoreNamespace)
+		if err != nil {
+			logger.Logger.Fatal("Datastoreの処理状態ストアの作成に失敗しました", zap.Error(err))
+		}
+		statusStore = datastoreStore
+	}
+	aiService := services.NewAIService(cfg.AIProviders, cfg.AIWorkflow, cfg.AIShadow, cfg.AIRetry, cfg.AIBreakerThreshold, cfg.AIBreakerCooldown,
+		services.NewRedactor(cfg.Redaction))
+	aiService.SetStreaming(cfg.AIStreaming)
+	if len(cfg.AIOutputMapping) > 0 {
+		outputMapper, err := services.NewOutputMapper(cfg.AIOutputMapping)
+		if err != nil {
+			logger.Logger.Fatal("AIの出力のマッピングが不正です", zap.Error(err))
+		}
+		aiService.SetOutputMapper(outputMapper)
+	}
+
+	// AI処理をCloud Tasksで実行する場合はキューを設定（インスタンス停止時も処理が失われない）
+	var taskQueue *services.TaskQueueService
+	if cfg.AIQueue == "cloudtasks" {
+		taskQueue = services.NewTaskQueueService(cfg.CloudTasksQueue, cfg.TasksTargetURL,
+			cfg.TasksServiceAccount, cfg.TasksMaxAttempts)
+	}
 
 	// ルーターの設定
 	r := gin.New()
@@ -40,17 +82,92 @@ func main() {
 	middleware.SetupMiddleware(r, middlewareConfig)
 
 	// ハンドラーの設定
-	emailHandler := handlers.NewEmailHandler(dbpilotService, aiService)
+	workers := handlers.NewWorkerPool(cfg.AIWorkers, cfg.AIWorkerQueueSize)
+	resultCache := services.NewResultCache(dbpilotService, cfg.AICacheTTL)
+	budget := services.NewBudgetGuard(dbpilotService, services.NewNotifyService(cfg.NotificationURL), int64(cfg.AIDailyTokenBudget))
+	emailHandler := handlers.NewEmailHandler(dbpilotService, statusStore, aiService, taskQueue, workers, resultCache, budget,
+		services.NewCallbackService(cfg.CallbackAllowedHosts, cfg.CallbackSecret, cfg.CallbackAllowInsecure), cfg.HoldSenders)
+	emailHandler.ConfigureStaleSweeper(cfg.StaleThreshold, cfg.StaleRequeue)
+	emailHandler.ConfigureDryRun(cfg.DryRunPercent)
+	emailHandler.SetSpamScorer(services.NewSpamScorer(cfg.Spam))
+	emailHandler.ConfigureThreading(cfg.Threading, cfg.ThreadSubjectWindow)
+	// メッセージの処理のステップはインシデントのタイムラインに表示する
+	var events *services.EventRecorder
+	if cfg.MessageEvents {
+		events = services.NewEventRecorder(dbpilotService, cfg.MessageEventBuffer)
+		emailHandler.SetEventRecorder(events)
+		aiService.SetEventRecorder(events)
+	}
+	if cfg.AIStreaming.Enabled {
+		emailHandler.ConfigureProcessTimeout(cfg.AIStreaming.MaxDuration)
+	}
 	r.GET("/health", handleHealthCheck)
+	// 依存サービス（dbpilot・AIプロバイダー）の疎通確認
+	r.GET("/ready", handlers.NewReadinessHandler(dbpilotService, aiService, cfg.ReadinessDBPilotTimeout, cfg.ReadinessAITimeout).HandleReady)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
 	r.POST("/receive", emailHandler.HandleEmailReceive)
 	// 処理状態確認エンドポイントの追加
+	r.GET("/status", emailHandler.HandleListStatus)
 	r.GET("/status/:messageID", emailHandler.HandleCheckStatus)
+	// Cloud TasksからのAI処理タスク
+	r.POST("/tasks/process-email", emailHandler.HandleProcessTask)
+	// 手動承認ゲート
+	r.GET("/hold", emailHandler.HandleListHeld)
+	r.POST("/hold/:messageID/approve", emailHandler.HandleApproveHold)
+	r.POST("/hold/:messageID/reject", emailHandler.HandleRejectHold)
+	// スパム・ノイズとして隔離したメッセージの確認
+	r.GET("/quarantine", emailHandler.HandleListQuarantined)
+	r.POST("/quarantine/:messageID/release", emailHandler.HandleReleaseQuarantined)
+	r.POST("/quarantine/:messageID/discard", emailHandler.HandleDiscardQuarantined)
+	// AI処理に失敗したメッセージ（デッドレター）の再処理
+	r.POST("/reprocess/:messageID", emailHandler.HandleReprocess)
+	r.POST("/reprocess/batch", emailHandler.HandleBatchReprocess)
+	r.GET("/reprocess/batch/:jobID", emailHandler.HandleBatchReprocessStatus)
+	// pending/running のまま止まったメッセージの掃除（schedule-serviceから定期実行）
+	r.POST("/sweep/stale", emailHandler.HandleSweepStale)
+	// AIのトークン使用量と利用上限
+	r.GET("/usage", emailHandler.HandleUsage)
+
+	// Pub/Subモードではサブスクリプションからもメールを取り込む
+	ingestCtx, stopIngestion := context.WithCancel(context.Background())
+	defer stopIngestion()
+	if cfg.IngestionMode == "pubsub" {
+		startPubSubIngestion(ingestCtx, cfg, emailHandler)
+	}
+	if cfg.StaleSweepInterval > 0 {
+		emailHandler.StartStaleSweeper(ingestCtx, cfg.StaleSweepInterval)
+	}
+	// 実行中のAI処理のイベントを送れるよう、取り込みとは別にワーカーの停止後まで動かす
+	eventsCtx, stopEvents := context.WithCancel(context.Background())
+	defer stopEvents()
+	events.Start(eventsCtx)
 
 	// サーバーの設定と起動
 	srv := config.SetupServer(r)
 
 	// グレースフルシャットダウンの実装
-	handleGracefulShutdown(srv, cfg.ShutdownTimeout) // タイムアウト設定を渡すように変更
+	handleGracefulShutdown(srv, cfg.ShutdownTimeout, stopIngestion, workers, func(ctx context.Context) {
+		stopEvents()
+		events.Wait(ctx)
+	})
+}
+
+// startPubSubIngestion はサブスクリプションからのpullをバックグラウンドで開始します
+func startPubSubIngestion(ctx context.Context, cfg *config.ServerConfig, emailHandler *handlers.EmailHandler) {
+	subscriber, err := pubsub.NewSubscriber(cfg.PubSubSubscription, cfg.PubSubMaxMessages)
+	if err != nil {
+		logger.Logger.Fatal("Pub/Subサブスクライバーの初期化に失敗しました", zap.Error(err))
+	}
+
+	logger.Logger.Info("Pub/Subからの取り込みを開始します",
+		zap.String("subscription", subscriber.Subscription()),
+		zap.Int("max_messages", cfg.PubSubMaxMessages))
+
+	go func() {
+		if err := subscriber.Receive(ctx, emailHandler.HandlePubSubMessage); err != nil {
+			logger.Logger.Error("Pub/Subからの取り込みが停止しました", zap.Error(err))
+		}
+	}()
 }
 
 // handleHealthCheck はヘルスチェックエンドポイントを処理します
@@ -58,10 +175,10 @@ func handleHealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
-func handleGracefulShutdown(srv *http.Server, timeout time.Duration) {
+func handleGracefulShutdown(srv *http.Server, timeout time.Duration, stopIngestion func(), workers *handlers.WorkerPool, flushEvents func(ctx context.Context)) {
 	// サーバーを別のゴルーチンで起動
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := mtls.ListenAndServe(srv); err != nil && err != http.ErrServerClosed {
 			logger.Logger.Fatal("サーバーの起動に失敗しました", zap.Error(err))
 		}
 	}()
@@ -72,6 +189,9 @@ func handleGracefulShutdown(srv *http.Server, timeout time.Duration) {
 	<-quit
 	logger.Logger.Info("シャットダウンを開始します...")
 
+	// 新しいメッセージの取り込みを停止
+	stopIngestion()
+
 	// シャットダウンのタイムアウト設定
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
@@ -81,5 +201,11 @@ func handleGracefulShutdown(srv *http.Server, timeout time.Duration) {
 		logger.Logger.Error("サーバーのシャットダウンでエラーが発生", zap.Error(err))
 	}
 
+	// 実行中のAI処理を待機し、期限までに終わらないものは requeue 状態にする
+	workers.Shutdown(ctx)
+
+	// 送信待ちの処理のステップをdbpilotに送る
+	flushEvents(ctx)
+
 	logger.Logger.Info("サーバーを正常に終了しました")
 }
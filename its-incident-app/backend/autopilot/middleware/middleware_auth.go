@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"autopilot/logger"
+	"autopilot/mtls"
+	"autopilot/serviceauth"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -39,8 +41,13 @@ func SetupMiddleware(r *gin.Engine, cfg *Config) {
 // AuthMiddleware Bearerトークン検証用ミドルウェア
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		serviceToken := os.Getenv("SERVICE_TOKEN")
-		if serviceToken == "" {
+		// 検証済みのクライアント証明書（相互TLS）によるサービス間認証
+		if mtls.IsVerifiedClient(c.Request) {
+			c.Next()
+			return
+		}
+
+		if os.Getenv("SERVICE_TOKEN") == "" && os.Getenv("ID_TOKEN_AUDIENCE") == "" {
 			logger.Logger.Warn("SERVICE_TOKEN is not set")
 			abortWithError(c, http.StatusUnauthorized, "unauthorized")
 			return
@@ -53,8 +60,9 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// SERVICE_TOKEN またはGoogle署名のIDトークンを受け付ける
 		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token != serviceToken {
+		if !serviceauth.Authorize(token) {
 			logUnauthorizedRequest(c)
 			abortWithError(c, http.StatusUnauthorized, "invalid token")
 			return
@@ -42,6 +42,26 @@ type AIResponse struct {
 	TaskID        string         `json:"task_id"`
 	WorkflowRunID string         `json:"workflow_run_id"`
 	Data          AIResponseData `json:"data"`
+	// Provider は処理したAIプロバイダー名（autopilotで設定）
+	Provider string `json:"provider,omitempty"`
+	// WorkflowVersion はリクエストしたプロンプト/ワークフローのバージョン（autopilotで設定）
+	WorkflowVersion string `json:"workflow_version,omitempty"`
+	// Language は検出したメールの言語（autopilotで設定、インシデントの絞り込み用）
+	Language string `json:"language,omitempty"`
+	// ContentHash は正規化した件名・本文のハッシュ。CacheHit の場合は CachedFromMessageID の結果を再利用しています
+	ContentHash         string `json:"content_hash,omitempty"`
+	CacheHit            bool   `json:"cache_hit,omitempty"`
+	CachedFromMessageID string `json:"cached_from_message_id,omitempty"`
+}
+
+// AIStreamEvent はストリーミング応答の1イベント（SSEの data 行）です。
+// workflow_finished の Data は AIResponseData と同じ形式で、error の場合は Message にエラー内容が入ります
+type AIStreamEvent struct {
+	Event         string          `json:"event"`
+	TaskID        string          `json:"task_id"`
+	WorkflowRunID string          `json:"workflow_run_id"`
+	Data          json.RawMessage `json:"data"`
+	Message       string          `json:"message,omitempty"`
 }
 
 // AIResponsePayload はDBpilotのincidentsエンドポイントへ送信するペイロードです
@@ -50,6 +70,15 @@ type AIResponsePayload struct {
 	AIResponse *AIResponse `json:"ai_response"`
 }
 
+// ShadowResultPayload はDBpilotのshadow-resultsエンドポイントへ送信するペイロードです。
+// シャドウの呼び出しに失敗した場合は Shadow を nil にして Error を設定します
+type ShadowResultPayload struct {
+	MessageID string      `json:"message_id"`
+	Primary   *AIResponse `json:"primary"`
+	Shadow    *AIResponse `json:"shadow,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
 // OutputsData は生のワークフローログを持つ出力データを定義します
 type OutputsData struct {
 	Body         string          `json:"body"`
@@ -121,6 +150,46 @@ func NewErrorResponse(messageID string, err error) *AIResponse {
 	return response
 }
 
+// DryRunJudgment はドライランで作成するインシデントの判定です（AIの判定と区別するため）
+const DryRunJudgment = "dry-run"
+
+// NewDryRunResponse はAIを呼び出さずに作成するドライラン用のAIResponseを生成します。
+// 件名・送信者はメールの値をそのまま使い、判定は DryRunJudgment になります
+func NewDryRunResponse(messageID string, emailData *EmailData) *AIResponse {
+	now := time.Now()
+	unixNow := now.Unix()
+
+	response := &AIResponse{
+		TaskID:        "dry-run-" + messageID,
+		WorkflowRunID: "dry-run-workflow-" + messageID,
+		Provider:      DryRunJudgment,
+	}
+	response.Data.ID = "dry-run-" + messageID
+	response.Data.WorkflowID = "dry-run-workflow-" + messageID
+	response.Data.Status = "succeeded"
+	response.Data.CreatedAt = unixNow
+	response.Data.FinishedAt = unixNow
+	response.Data.Outputs.Subject = emailData.Subject
+	response.Data.Outputs.From = emailData.From
+	response.Data.Outputs.Sender = emailData.From
+	response.Data.Outputs.Body = emailData.Body
+	response.Data.Outputs.Priority = "low"
+	response.Data.Outputs.Judgment = DryRunJudgment
+	response.Data.Outputs.Incident = "dry-run: AI processing was skipped"
+	response.Data.Outputs.Time = now.Format(time.RFC3339)
+	response.Data.Outputs.Final = DryRunJudgment
+	response.Data.Outputs.WorkflowLogs = []WorkflowLog{
+		{
+			"step":    "1",
+			"action":  "dry-run",
+			"message": "AI processing was skipped",
+			"time":    now.Format(time.RFC3339),
+		},
+	}
+
+	return response
+}
+
 // IsError はレスポンスがエラー状態かどうかを判定します
 func (r *AIResponse) IsError() bool {
 	return r.Data.Status == "error" || r.Data.Error != nil
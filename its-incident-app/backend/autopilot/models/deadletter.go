@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// DeadLetter は再試行をすべて失敗したAI処理のメッセージです。
+// Payload には再処理に使うメールデータ（EmailData のJSON）をそのまま保持します
+type DeadLetter struct {
+	MessageID         string     `json:"message_id"`
+	Payload           string     `json:"payload,omitempty"`
+	Error             string     `json:"error"`
+	Attempts          int        `json:"attempts"`
+	LastFailedAt      time.Time  `json:"last_failed_at"`
+	ReprocessCount    int        `json:"reprocess_count"`
+	LastReprocessedAt *time.Time `json:"last_reprocessed_at,omitempty"`
+	ResolvedAt        *time.Time `json:"resolved_at,omitempty"`
+}
+
+// IsResolved は再処理などで解決済みになっているかを確認します
+func (d *DeadLetter) IsResolved() bool {
+	return d.ResolvedAt != nil
+}
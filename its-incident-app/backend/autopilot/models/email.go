@@ -1,18 +1,45 @@
 package models
 
+import "time"
+
 // EmailData はメールのデータ構造を定義します
 type EmailData struct {
-	From                    string `json:"from"`
-	To                      string `json:"to"`
-	Subject                 string `json:"subject"`
-	Date                    string `json:"date"`
-	OriginalMessageID       string `json:"original_message_id"`
-	MIMEVersion             string `json:"mime_version"`
-	ContentType             string `json:"content_type"`
-	ContentTransferEncoding string `json:"content_transfer_encoding"`
-	CC                      string `json:"cc"`
-	Body                    string `json:"body"`
-	FileName                string `json:"file_name,omitempty"`
+	From                    string       `json:"from"`
+	To                      string       `json:"to"`
+	Subject                 string       `json:"subject"`
+	Date                    string       `json:"date"`
+	OriginalMessageID       string       `json:"original_message_id"`
+	MIMEVersion             string       `json:"mime_version"`
+	ContentType             string       `json:"content_type"`
+	ContentTransferEncoding string       `json:"content_transfer_encoding"`
+	CC                      string       `json:"cc"`
+	Body                    string       `json:"body"`
+	HTMLBody                string       `json:"html_body,omitempty"`   // HTML本文（インライン画像は data URI に書き換え済み）
+	InReplyTo               string       `json:"in_reply_to,omitempty"` // 返信元の Message-ID（スレッドの判定用）
+	References              string       `json:"references,omitempty"`  // スレッドの Message-ID の一覧
+	FileName                string       `json:"file_name,omitempty"`
+	Attachments             []Attachment `json:"attachments,omitempty"`
+	RawHeader               []byte       `json:"raw_header,omitempty"`  // 受信したRFC822のヘッダー部分
+	RawGCSURI               string       `json:"raw_gcs_uri,omitempty"` // 生データ全体を保存したCloud StorageのURI
+
+	// カレンダー招待（メンテナンスの告知など）の最初の予定。dbpilotがメンテナンスの期間として保存します
+	CalendarMethod  string     `json:"calendar_method,omitempty"`
+	CalendarUID     string     `json:"calendar_uid,omitempty"`
+	CalendarSummary string     `json:"calendar_summary,omitempty"`
+	CalendarStart   *time.Time `json:"calendar_start,omitempty"`
+	CalendarEnd     *time.Time `json:"calendar_end,omitempty"`
+}
+
+// Attachment は添付ファイルの情報です。テキスト形式の添付ファイルは内容（上限まで）も含みます
+type Attachment struct {
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Content     string `json:"content,omitempty"`
+	Truncated   bool   `json:"truncated,omitempty"`
+	GCSURI      string `json:"gcs_uri,omitempty"` // mailconverterが内容を保存したCloud StorageのURI
+	ContentID   string `json:"content_id,omitempty"`
+	Inline      bool   `json:"inline,omitempty"` // HTML本文に表示されるインライン画像
 }
 
 // EmailPayload はDBpilotのemailsエンドポイントへ送信するペイロードです
@@ -23,10 +50,20 @@ type EmailPayload struct {
 
 // APIPayload は外部APIへのリクエストペイロードの構造を定義します
 type APIPayload struct {
-	Inputs struct {
-		Subject string `json:"subject"`
-		From    string `json:"from"`
-		Body    string `json:"body"`
-	} `json:"inputs"`
-	User string `json:"user"`
+	Inputs APIInputs `json:"inputs"`
+	User   string    `json:"user"`
+	// ResponseMode は "streaming" の場合にイベントを逐次受け取ります（省略時は完了後に一括で返る）
+	ResponseMode string `json:"response_mode,omitempty"`
+}
+
+// APIInputs はワークフローへの入力です。WorkflowVersion でプロンプト/ワークフローのバージョンを指定します
+type APIInputs struct {
+	Subject         string `json:"subject"`
+	From            string `json:"from"`
+	Body            string `json:"body"`
+	WorkflowVersion string `json:"workflow_version,omitempty"`
+	// Language は検出したメールの言語（ja/en）。ワークフロー側でプロンプトを切り替える場合に使います
+	Language string `json:"language,omitempty"`
+	// Attachments は添付ファイルの一覧とテキスト形式の添付ファイルの内容を1つの文字列にしたもの
+	Attachments string `json:"attachments,omitempty"`
 }
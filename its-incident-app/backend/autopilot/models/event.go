@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// MessageStep はメッセージの処理のステップを表す型
+type MessageStep string
+
+const (
+	StepReceived      MessageStep = "received"       // 受信（取り込み開始）
+	StepSavedEmail    MessageStep = "saved_email"    // メールデータをdbpilotに保存
+	StepQuarantined   MessageStep = "quarantined"    // スパム・ノイズと判定して隔離
+	StepThreaded      MessageStep = "threaded"       // 返信として既存のインシデントに追加
+	StepAIStarted     MessageStep = "ai_started"     // AI処理を開始
+	StepAIRetry       MessageStep = "ai_retry"       // AIプロバイダーへのリクエストを再試行
+	StepAISucceeded   MessageStep = "ai_succeeded"   // AI処理が成功（キャッシュの再利用を含む）
+	StepAIFailed      MessageStep = "ai_failed"      // すべてのAIプロバイダーで失敗
+	StepIncidentSaved MessageStep = "incident_saved" // インシデントを保存
+)
+
+// MessageEvent はメッセージの処理の1ステップです。Attributes にはプロバイダー・試行回数・エラーなどを入れます
+type MessageEvent struct {
+	MessageID  string            `json:"message_id"`
+	Step       MessageStep       `json:"step"`
+	OccurredAt time.Time         `json:"occurred_at"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // ProcessStatus は処理状態を表す型
 type ProcessStatus string
@@ -11,6 +14,12 @@ const (
 	StatusRunning  ProcessStatus = "running"  // AI処理実行中
 	StatusComplete ProcessStatus = "complete" // 処理完了
 	StatusFailed   ProcessStatus = "failed"   // 処理失敗
+	StatusHeld     ProcessStatus = "held"     // 承認待ち（AI処理保留）
+	StatusRejected ProcessStatus = "rejected" // 承認却下
+	StatusRequeue  ProcessStatus = "requeue"  // シャットダウンで中断（再処理待ち）
+	StatusQueued   ProcessStatus = "queued"   // AIの利用上限超過で受信のみ（再処理待ち）
+	// StatusQuarantined はスパム・ノイズと判定して隔離した状態（確認して解放するとAI処理を開始）
+	StatusQuarantined ProcessStatus = "quarantined"
 )
 
 // ProcessingStatus は処理の状態を表す構造体
@@ -21,6 +30,42 @@ type ProcessingStatus struct {
 	CreatedAt   time.Time     `json:"created_at"`
 	CompletedAt *time.Time    `json:"completed_at,omitempty"`
 	Error       string        `json:"error,omitempty"`
+	IncidentID  uint          `json:"incident_id,omitempty"`
+	// CallbackURL は処理の完了時に結果を通知する呼び出し元のURL
+	CallbackURL string `json:"callback_url,omitempty"`
+	// PartialOutputs はストリーミング中に受け取ったAIの途中結果（件名・判定など）
+	PartialOutputs map[string]string `json:"partial_outputs,omitempty"`
+	// UpdatedAt はdbpilotでの最終更新日時（取得時のみ）
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UnmarshalJSON はdbpilotが gorm.Model のフィールド名（CreatedAt/UpdatedAt）で返す日時も読み取ります
+func (p *ProcessingStatus) UnmarshalJSON(data []byte) error {
+	type plain ProcessingStatus
+	aux := struct {
+		*plain
+		ModelCreatedAt time.Time `json:"CreatedAt"`
+		ModelUpdatedAt time.Time `json:"UpdatedAt"`
+	}{plain: (*plain)(p)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = aux.ModelCreatedAt
+	}
+	if p.UpdatedAt.IsZero() {
+		p.UpdatedAt = aux.ModelUpdatedAt
+	}
+	return nil
+}
+
+// CompletionCallback は処理の完了時に呼び出し元のコールバックURLへ送信する内容です
+type CompletionCallback struct {
+	MessageID   string        `json:"message_id"`
+	Status      ProcessStatus `json:"status"`
+	IncidentID  uint          `json:"incident_id,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	CompletedAt *time.Time    `json:"completed_at,omitempty"`
 }
 
 // NewProcessingStatus は新しいProcessingStatusインスタンスを作成します
@@ -53,6 +98,52 @@ func (p *ProcessingStatus) SetFailed(err error) {
 	p.CompletedAt = &now
 }
 
+// SetHeld は状態を承認待ちに更新します
+func (p *ProcessingStatus) SetHeld() {
+	p.Status = StatusHeld
+}
+
+// SetRejected は承認却下状態に更新します
+func (p *ProcessingStatus) SetRejected(reason string) {
+	p.Status = StatusRejected
+	p.Error = reason
+	now := time.Now()
+	p.CompletedAt = &now
+}
+
+// SetRequeue はシャットダウンなどで中断し、再処理を待つ状態に更新します
+func (p *ProcessingStatus) SetRequeue(reason string) {
+	p.Status = StatusRequeue
+	p.Error = reason
+}
+
+// SetQueued はAIの利用上限を超えたため、AI処理をせずに再処理を待つ状態に更新します
+func (p *ProcessingStatus) SetQueued(reason string) {
+	p.Status = StatusQueued
+	p.Error = reason
+}
+
+// SetQuarantined はスパム・ノイズと判定したため、AI処理をせずに確認を待つ状態に更新します
+func (p *ProcessingStatus) SetQuarantined(reason string) {
+	p.Status = StatusQuarantined
+	p.Error = reason
+}
+
+// AcceptsReingest は同じメッセージを再度受信した場合に取り込み直すか（失敗・再キュー状態）を返します
+func (p *ProcessingStatus) AcceptsReingest() bool {
+	return p.IsFailed() || p.Status == StatusRequeue
+}
+
+// IsHeld は承認待ちかを確認します
+func (p *ProcessingStatus) IsHeld() bool {
+	return p.Status == StatusHeld
+}
+
+// IsQuarantined は隔離中かを確認します
+func (p *ProcessingStatus) IsQuarantined() bool {
+	return p.Status == StatusQuarantined
+}
+
 // IsComplete は処理が完了しているかを確認します
 func (p *ProcessingStatus) IsComplete() bool {
 	return p.Status == StatusComplete
@@ -67,3 +158,21 @@ func (p *ProcessingStatus) IsFailed() bool {
 func (p *ProcessingStatus) IsFinished() bool {
 	return p.IsComplete() || p.IsFailed()
 }
+
+// StatusFilter は処理状態一覧の検索条件です（ゼロ値の項目は絞り込みに使いません）
+type StatusFilter struct {
+	Statuses      []ProcessStatus
+	From          time.Time // 作成日時がこの日時以降
+	To            time.Time // 作成日時がこの日時より前
+	UpdatedBefore time.Time // 最終更新がこの日時より前（滞留しているメッセージの検出用）
+	Sender        string    // 送信者のメールアドレス（部分一致）
+	Limit         int
+}
+
+// StatusList は処理状態一覧の検索結果です。Total と ByStatus は件数の上限に関係なく条件に一致したメッセージの件数です
+type StatusList struct {
+	Count    int                     `json:"count"`
+	Total    int64                   `json:"total"`
+	ByStatus map[ProcessStatus]int64 `json:"by_status"`
+	Data     []ProcessingStatus      `json:"data"`
+}
@@ -0,0 +1,8 @@
+package models
+
+// ThreadMatch は返信メールを追加した既存のインシデントです。IncidentID が 0 の場合は返信元が見つかっていません
+type ThreadMatch struct {
+	IncidentID uint   `json:"incident_id"`
+	MatchedBy  string `json:"matched_by,omitempty"` // in_reply_to・references・subject のいずれか
+	ResponseID uint   `json:"response_id,omitempty"`
+}
@@ -0,0 +1,18 @@
+package models
+
+// CostUsageSummary はAI利用量の合計です（Key は日付または送信者）
+type CostUsageSummary struct {
+	Key         string  `json:"key,omitempty"`
+	Requests    int     `json:"requests"`
+	TotalTokens int64   `json:"total_tokens"`
+	ElapsedTime float64 `json:"elapsed_time"`
+}
+
+// CostUsageReport は期間内のAI利用量の集計です
+type CostUsageReport struct {
+	From     string             `json:"from"`
+	To       string             `json:"to"`
+	Total    CostUsageSummary   `json:"total"`
+	ByDay    []CostUsageSummary `json:"by_day"`
+	BySender []CostUsageSummary `json:"by_sender"`
+}
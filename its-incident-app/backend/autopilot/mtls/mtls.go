@@ -0,0 +1,157 @@
+// Package mtls はCloud Run以外の環境（オンプレミスなど）向けに、サービス間の相互TLS認証を提供します。
+// MTLS_CERT_FILE と MTLS_KEY_FILE が設定されている場合のみ有効になります
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Enabled は相互TLSが設定されているかを返します
+func Enabled() bool {
+	return os.Getenv("MTLS_CERT_FILE") != "" && os.Getenv("MTLS_KEY_FILE") != ""
+}
+
+// ServerConfig はサーバー用のTLS設定を返します（無効の場合は nil）。
+// MTLS_CLIENT_AUTH=require の場合はクライアント証明書を必須とし、
+// それ以外は提示された場合のみ検証します（ブラウザなど証明書を持たないクライアントも接続可能）
+func ServerConfig() (*tls.Config, error) {
+	if !Enabled() {
+		return nil, nil
+	}
+
+	cert, err := loadCertificate()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := loadCAPool()
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth := tls.VerifyClientCertIfGiven
+	if strings.EqualFold(os.Getenv("MTLS_CLIENT_AUTH"), "require") {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   clientAuth,
+	}, nil
+}
+
+// ClientConfig は内部サービス呼び出し用のTLS設定を返します（無効の場合は nil）
+func ClientConfig() (*tls.Config, error) {
+	if !Enabled() {
+		return nil, nil
+	}
+
+	cert, err := loadCertificate()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := loadCAPool()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}, nil
+}
+
+// InstallTransport は http.DefaultTransport にクライアント証明書を設定します。
+// 相互TLSが無効の場合は何もしません
+func InstallTransport() error {
+	tlsConfig, err := ClientConfig()
+	if err != nil || tlsConfig == nil {
+		return err
+	}
+
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("http.DefaultTransport is not *http.Transport")
+	}
+	transport := base.Clone()
+	transport.TLSClientConfig = tlsConfig
+	http.DefaultTransport = transport
+	return nil
+}
+
+// ListenAndServe はTLS設定がある場合はHTTPSで、ない場合はHTTPでサーバーを起動します
+func ListenAndServe(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		return srv.ListenAndServeTLS("", "")
+	}
+	return srv.ListenAndServe()
+}
+
+// IsVerifiedClient はリクエストが検証済みのクライアント証明書で送信されたかを返します。
+// MTLS_ALLOWED_CLIENTS（カンマ区切りのCN/DNS名）が設定されている場合はそれに一致するもののみ許可します
+func IsVerifiedClient(r *http.Request) bool {
+	return ClientIdentity(r) != ""
+}
+
+// ClientIdentity は検証済みクライアント証明書のCN（なければ最初のDNS名）を返します（未検証の場合は空文字）
+func ClientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+
+	names := append([]string{leaf.Subject.CommonName}, leaf.DNSNames...)
+	allowed := allowedClients()
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if len(allowed) == 0 || allowed[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+func allowedClients() map[string]bool {
+	allowed := map[string]bool{}
+	for _, name := range strings.Split(os.Getenv("MTLS_ALLOWED_CLIENTS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			allowed[name] = true
+		}
+	}
+	return allowed
+}
+
+func loadCertificate() (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(os.Getenv("MTLS_CERT_FILE"), os.Getenv("MTLS_KEY_FILE"))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load mTLS certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// loadCAPool は MTLS_CA_FILE の証明書プールを返します（未設定の場合はシステムの証明書を使用）
+func loadCAPool() (*x509.CertPool, error) {
+	caFile := os.Getenv("MTLS_CA_FILE")
+	if caFile == "" {
+		return x509.SystemCertPool()
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mTLS CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid certificates found in %s", caFile)
+	}
+	return pool, nil
+}
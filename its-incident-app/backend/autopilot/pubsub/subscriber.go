@@ -0,0 +1,235 @@
+// Package pubsub はPub/SubのREST APIでサブスクリプションからメッセージをpullするクライアントを提供します。
+// PUBSUB_EMULATOR_HOST が設定されている場合はエミュレーターに接続します
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/secrets"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultEndpoint    = "https://pubsub.googleapis.com/v1/"
+	defaultMaxMessages = 10
+	minPullBackoff     = time.Second
+	maxPullBackoff     = 30 * time.Second
+	pullTimeout        = 90 * time.Second
+)
+
+// Message はpullしたPub/Subのメッセージ
+type Message struct {
+	ID              string
+	AckID           string
+	Data            []byte
+	Attributes      map[string]string
+	PublishTime     time.Time
+	DeliveryAttempt int // デッドレタートピック設定時のみ1以上
+}
+
+// Handler はメッセージを処理します。nil を返した場合のみACKし、エラーの場合は再配信させます
+type Handler func(ctx context.Context, msg *Message) error
+
+// Subscriber はサブスクリプションからメッセージをpullして処理します
+type Subscriber struct {
+	subscription string
+	endpoint     string
+	maxMessages  int
+	client       *http.Client
+	useAuth      bool
+}
+
+// NewSubscriber はサブスクリプション（名前のみ、または projects/.../subscriptions/... 形式）のSubscriberを作成します
+func NewSubscriber(subscription string, maxMessages int) (*Subscriber, error) {
+	if subscription == "" {
+		return nil, errors.New("subscription is required")
+	}
+	if !strings.HasPrefix(subscription, "projects/") {
+		project, err := secrets.ProjectID()
+		if err != nil {
+			return nil, err
+		}
+		subscription = fmt.Sprintf("projects/%s/subscriptions/%s", project, subscription)
+	}
+	if maxMessages <= 0 {
+		maxMessages = defaultMaxMessages
+	}
+
+	s := &Subscriber{
+		subscription: subscription,
+		endpoint:     defaultEndpoint,
+		maxMessages:  maxMessages,
+		client:       &http.Client{Timeout: pullTimeout},
+		useAuth:      true,
+	}
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		s.endpoint = "http://" + host + "/v1/"
+		s.useAuth = false
+	}
+	return s, nil
+}
+
+// Subscription はサブスクリプションの完全な名前を返します
+func (s *Subscriber) Subscription() string {
+	return s.subscription
+}
+
+// Receive は ctx がキャンセルされるまでメッセージをpullし、handler で並行に処理します。
+// pull に失敗した場合は待機時間を倍増させながら再試行します
+func (s *Subscriber) Receive(ctx context.Context, handler Handler) error {
+	logFields := []zap.Field{zap.String("subscription", s.subscription)}
+	backoff := minPullBackoff
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		messages, err := s.pull(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Logger.Warn("Pub/Subからのpullに失敗しました",
+				append(logFields, zap.Error(err), zap.Duration("retry_in", backoff))...)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxPullBackoff)
+			continue
+		}
+		backoff = minPullBackoff
+
+		var wg sync.WaitGroup
+		for _, msg := range messages {
+			wg.Add(1)
+			go func(msg *Message) {
+				defer wg.Done()
+				s.handle(ctx, msg, handler, logFields)
+			}(msg)
+		}
+		wg.Wait()
+	}
+}
+
+func (s *Subscriber) handle(ctx context.Context, msg *Message, handler Handler, logFields []zap.Field) {
+	logFields = append(logFields,
+		zap.String("pubsub_message_id", msg.ID),
+		zap.Int("delivery_attempt", msg.DeliveryAttempt))
+
+	// シャットダウン中でもACK/NACKを送れるよう、処理とは別のコンテキストを使う
+	ackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	if err := handler(ctx, msg); err != nil {
+		logger.Logger.Warn("メッセージの処理に失敗したため再配信させます",
+			append(logFields, zap.Error(err))...)
+		if nackErr := s.nack(ackCtx, msg.AckID); nackErr != nil {
+			logger.Logger.Warn("NACKの送信に失敗しました", append(logFields, zap.Error(nackErr))...)
+		}
+		return
+	}
+
+	if err := s.ack(ackCtx, msg.AckID); err != nil {
+		// ACKできなかった場合は再配信されるため、ハンドラーは冪等である必要がある
+		logger.Logger.Error("ACKの送信に失敗しました", append(logFields, zap.Error(err))...)
+	}
+}
+
+func (s *Subscriber) pull(ctx context.Context) ([]*Message, error) {
+	var result struct {
+		ReceivedMessages []struct {
+			AckID           string `json:"ackId"`
+			DeliveryAttempt int    `json:"deliveryAttempt"`
+			Message         struct {
+				MessageID   string            `json:"messageId"`
+				Data        string            `json:"data"`
+				Attributes  map[string]string `json:"attributes"`
+				PublishTime time.Time         `json:"publishTime"`
+			} `json:"message"`
+		} `json:"receivedMessages"`
+	}
+	if err := s.call(ctx, ":pull", map[string]interface{}{"maxMessages": s.maxMessages}, &result); err != nil {
+		return nil, err
+	}
+
+	messages := make([]*Message, 0, len(result.ReceivedMessages))
+	for _, received := range result.ReceivedMessages {
+		data, err := base64.StdEncoding.DecodeString(received.Message.Data)
+		if err != nil {
+			logger.Logger.Warn("メッセージのデコードに失敗しました",
+				zap.String("pubsub_message_id", received.Message.MessageID), zap.Error(err))
+		}
+		messages = append(messages, &Message{
+			ID:              received.Message.MessageID,
+			AckID:           received.AckID,
+			Data:            data,
+			Attributes:      received.Message.Attributes,
+			PublishTime:     received.Message.PublishTime,
+			DeliveryAttempt: received.DeliveryAttempt,
+		})
+	}
+	return messages, nil
+}
+
+func (s *Subscriber) ack(ctx context.Context, ackID string) error {
+	return s.call(ctx, ":acknowledge", map[string]interface{}{"ackIds": []string{ackID}}, nil)
+}
+
+// nack は確認期限を0にしてメッセージをすぐに再配信させます
+func (s *Subscriber) nack(ctx context.Context, ackID string) error {
+	return s.call(ctx, ":modifyAckDeadline", map[string]interface{}{
+		"ackIds":             []string{ackID},
+		"ackDeadlineSeconds": 0,
+	}, nil)
+}
+
+func (s *Subscriber) call(ctx context.Context, method string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+s.subscription+method, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.useAuth {
+		token, err := secrets.AccessToken()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("pubsub %s returned status %d: %s", method, resp.StatusCode, string(respBody))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -5,20 +5,45 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"autopilot/config"
 	"autopilot/logger"
 	"autopilot/models"
+	"autopilot/secrets"
 
 	"go.uber.org/zap"
 )
 
+// aiProvider はフェイルオーバー対象のAIプロバイダーです
+type aiProvider struct {
+	name     string
+	endpoint string
+	tokenEnv string
+	breaker  *circuitBreaker
+}
+
 type AIService struct {
-	endpoint    string
-	token       string
+	providers   []*aiProvider
+	workflow    config.AIWorkflowConfig
+	shadow      *aiProvider // nil の場合はシャドウ評価なし
+	shadowCfg   config.AIShadowConfig
+	redactor    *Redactor   // nil の場合はマスクしない
+	retry       RetryPolicy // nil の場合は再試行せずに次のプロバイダーに切り替える
+	retryBudget *retryBudget
+	streaming   config.AIStreamingConfig
+	events      *EventRecorder // nil の場合は再試行のステップを記録しない
+	outputs     *OutputMapper  // nil の場合はワークフローの出力のキーをそのまま使う
 	shortClient *http.Client
 	longClient  *http.Client
+	// streamClient は全体のタイムアウトを持たず、ctx とイベント間の待ち時間（streaming.IdleTimeout）で打ち切ります
+	streamClient *http.Client
 }
 
 const (
@@ -26,21 +51,60 @@ const (
 	defaultLongTimeout  = 90 * time.Second
 )
 
-func NewAIService(endpoint, token string) *AIService {
+// NewAIService は優先度順に並んだプロバイダーのAIServiceを作成します。
+// 各プロバイダーは連続した失敗が breakerThreshold に達すると breakerCooldown の間スキップされ、
+// 送信するメール内容は redactor で個人情報をマスクします。shadow.Endpoint を指定するとシャドウ評価を行います。
+// 失敗した呼び出しは retry の設定（指数バックオフ）で再試行し、SetRetryPolicy で別のポリシーに差し替えられます
+func NewAIService(providers []config.AIProviderConfig, workflow config.AIWorkflowConfig, shadow config.AIShadowConfig, retry config.AIRetryConfig, breakerThreshold int, breakerCooldown time.Duration, redactor *Redactor) *AIService {
 	service := &AIService{
-		endpoint: endpoint,
-		token:    token,
+		workflow:    workflow,
+		shadowCfg:   shadow,
+		redactor:    redactor,
+		retry:       NewExponentialBackoff(retry),
+		retryBudget: newRetryBudget(retry.BudgetRatio),
 		shortClient: &http.Client{
 			Timeout: defaultShortTimeout,
 		},
 		longClient: &http.Client{
 			Timeout: defaultLongTimeout,
 		},
+		streamClient: &http.Client{},
+	}
+
+	names := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		service.providers = append(service.providers, &aiProvider{
+			name:     provider.Name,
+			endpoint: provider.Endpoint,
+			tokenEnv: provider.TokenEnv,
+			breaker:  newCircuitBreaker(breakerThreshold, breakerCooldown),
+		})
+		names = append(names, provider.Name)
+	}
+	if shadow.Endpoint != "" && shadow.Percent > 0 {
+		service.shadow = &aiProvider{
+			name:     shadow.Name,
+			endpoint: shadow.Endpoint,
+			tokenEnv: shadow.TokenEnv,
+			breaker:  newCircuitBreaker(breakerThreshold, breakerCooldown),
+		}
+		logger.Logger.Info("シャドウのAIモデルを設定しました",
+			zap.String("provider", shadow.Name),
+			zap.String("workflow_version", shadow.WorkflowVersion),
+			zap.Int("percent", shadow.Percent))
 	}
 
 	logger.Logger.Info("AIサービスを初期化しました",
-		zap.Bool("has_endpoint", endpoint != ""),
-		zap.Bool("has_token", token != ""),
+		zap.Strings("providers", names),
+		zap.String("workflow_version", workflow.Version),
+		zap.String("workflow_canary_version", workflow.CanaryVersion),
+		zap.Int("workflow_canary_percent", workflow.CanaryPercent),
+		zap.Any("workflow_language_versions", workflow.LanguageVersions),
+		zap.Int("retry_max_attempts", retry.MaxAttempts),
+		zap.Duration("retry_initial_backoff", retry.InitialBackoff),
+		zap.Ints("retry_status_codes", retry.RetryableStatus),
+		zap.Int("breaker_threshold", breakerThreshold),
+		zap.Duration("breaker_cooldown", breakerCooldown),
 		zap.Duration("short_timeout", defaultShortTimeout),
 		zap.Duration("long_timeout", defaultLongTimeout),
 	)
@@ -48,36 +112,249 @@ func NewAIService(endpoint, token string) *AIService {
 	return service
 }
 
-func (s *AIService) ProcessEmail(ctx context.Context, emailData *models.EmailData) (*models.AIResponse, error) {
-	if s.endpoint == "" {
+// SetStreaming はAIワークフローのストリーミング応答を設定します。無効の場合は完了後に一括で受け取ります
+func (s *AIService) SetStreaming(streaming config.AIStreamingConfig) {
+	s.streaming = streaming
+	if streaming.Enabled {
+		logger.Logger.Info("AIのストリーミング応答を有効にしました",
+			zap.Duration("idle_timeout", streaming.IdleTimeout),
+			zap.Duration("max_duration", streaming.MaxDuration))
+	}
+}
+
+// SetEventRecorder は再試行をメッセージのステップとして記録する先を設定します
+func (s *AIService) SetEventRecorder(events *EventRecorder) {
+	s.events = events
+}
+
+// SetOutputMapper はワークフローの出力をインシデントの項目に対応付けるマッピングを設定します
+func (s *AIService) SetOutputMapper(outputs *OutputMapper) {
+	s.outputs = outputs
+}
+
+// SetRetryPolicy は再試行のポリシーを差し替えます。nil を指定すると再試行しません
+func (s *AIService) SetRetryPolicy(policy RetryPolicy) {
+	s.retry = policy
+}
+
+// currentToken はSecret Managerでローテーションされた最新のトークンを返します
+func (p *aiProvider) currentToken() string {
+	return secrets.Get(p.tokenEnv)
+}
+
+// ProviderPing はAIプロバイダー1件の疎通確認の結果です
+type ProviderPing struct {
+	Name        string
+	Latency     time.Duration
+	BreakerOpen bool // 連続した失敗で呼び出し対象から外している
+	Err         error
+}
+
+// Ping は各プロバイダーのエンドポイントにHEADリクエストを送り、到達できるかとトークンが拒否されないかを確認します。
+// ワークフローは実行しないため、405などの応答も到達できたものとみなします
+func (s *AIService) Ping(ctx context.Context) []ProviderPing {
+	results := make([]ProviderPing, len(s.providers))
+	var wg sync.WaitGroup
+	for i, provider := range s.providers {
+		wg.Add(1)
+		go func(i int, provider *aiProvider) {
+			defer wg.Done()
+			start := time.Now()
+			err := s.pingProvider(ctx, provider)
+			results[i] = ProviderPing{
+				Name:        provider.name,
+				Latency:     time.Since(start),
+				BreakerOpen: provider.breaker.Open(),
+				Err:         err,
+			}
+		}(i, provider)
+	}
+	wg.Wait()
+	return results
+}
+
+func (s *AIService) pingProvider(ctx context.Context, provider *aiProvider) error {
+	token := provider.currentToken()
+	if token == "" {
+		return fmt.Errorf("AI token is not set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, provider.endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := s.shortClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("AI token was rejected: status %d", resp.StatusCode)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return &aiStatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// WorkflowVersion はメッセージに使うプロンプト/ワークフローのバージョンを返します。
+// language（DetectLanguage の結果）の専用のバージョンが設定されていればそれを使い、カナリアの対象にしません。
+// カナリアの対象はメッセージIDのハッシュで決めるため、再試行や再処理でも同じバージョンになります
+func (s *AIService) WorkflowVersion(messageID, language string) string {
+	if version, ok := s.workflow.LanguageVersions[language]; ok {
+		return version
+	}
+	if s.workflow.CanaryVersion == "" || s.workflow.CanaryPercent <= 0 {
+		return s.workflow.Version
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(messageID))
+	if int(h.Sum32()%100) < s.workflow.CanaryPercent {
+		return s.workflow.CanaryVersion
+	}
+	return s.workflow.Version
+}
+
+// ProcessEmail は優先度の高いプロバイダーから順にAI処理を試行し、失敗またはブレーカーが開いている場合は次のプロバイダーに切り替えます。
+// 成功したプロバイダー名とワークフローのバージョンは AIResponse に設定されます
+func (s *AIService) ProcessEmail(ctx context.Context, messageID string, emailData *models.EmailData) (*models.AIResponse, error) {
+	return s.ProcessEmailStream(ctx, messageID, emailData, nil)
+}
+
+// ProcessEmailStream は ProcessEmail と同じ処理で、ストリーミングが有効な場合は途中結果を受け取るたびに onPartial を呼び出します
+func (s *AIService) ProcessEmailStream(ctx context.Context, messageID string, emailData *models.EmailData, onPartial PartialFunc) (*models.AIResponse, error) {
+	if len(s.providers) == 0 {
 		logger.Logger.Error("AIエンドポイントが設定されていません")
 		return nil, fmt.Errorf("AI endpoint is not set")
 	}
 
-	if s.token == "" {
-		logger.Logger.Error("AIトークンが設定されていません")
-		return nil, fmt.Errorf("AI token is not set")
+	// 外部のAIには個人情報をマスクした内容だけを送る（元のメールはdbpilotにのみ保存）
+	redacted, counts := s.redactor.RedactEmail(emailData)
+	if len(counts) > 0 {
+		fields := []zap.Field{zap.String("message_id", messageID)}
+		for name, count := range counts {
+			fields = append(fields, zap.Int("redacted_"+name, count))
+		}
+		logger.Logger.Info("AIに送信するメール内容の個人情報をマスクしました", fields...)
+	}
+
+	language := DetectLanguage(emailData)
+	workflowVersion := s.WorkflowVersion(messageID, language)
+	responseMode := ""
+	if s.streaming.Enabled {
+		responseMode = responseModeStreaming
+	}
+	payloadBytes, err := buildPayload(redacted, workflowVersion, language, responseMode)
+	if err != nil {
+		logger.Logger.Error("ペイロードのJSONエンコードに失敗しました",
+			zap.Error(err),
+			zap.String("message_id", messageID),
+		)
+		return nil, err
 	}
 
+	var errs []string
+	for _, provider := range s.providers {
+		if !provider.breaker.Allow() {
+			logger.Logger.Warn("ブレーカーが開いているためAIプロバイダーをスキップします",
+				zap.String("provider", provider.name))
+			errs = append(errs, fmt.Sprintf("%s: circuit open", provider.name))
+			continue
+		}
+
+		aiResponse, err := s.callWithRetry(ctx, messageID, provider, payloadBytes, onPartial)
+		if err == nil {
+			provider.breaker.Success()
+			aiResponse.Provider = provider.name
+			aiResponse.WorkflowVersion = workflowVersion
+			aiResponse.Language = language
+			if len(errs) > 0 {
+				aiFailoversTotal.Inc(provider.name)
+				logger.Logger.Warn("フォールバック先のAIプロバイダーで処理しました",
+					zap.String("provider", provider.name),
+					zap.Strings("failed_providers", errs))
+			}
+			return aiResponse, nil
+		}
+
+		errs = append(errs, fmt.Sprintf("%s: %v", provider.name, err))
+		if provider.breaker.Failure() {
+			logger.Logger.Warn("AIプロバイダーのブレーカーが開きました",
+				zap.String("provider", provider.name))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("all AI providers failed: %s", strings.Join(errs, "; "))
+}
+
+// ShadowSampled はメッセージがシャドウ評価の対象かを返します。
+// カナリアとは別の値でハッシュし、カナリアの対象と偏らないようにします
+func (s *AIService) ShadowSampled(messageID string) bool {
+	if s.shadow == nil {
+		return false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte("shadow:" + messageID))
+	return int(h.Sum32()%100) < s.shadowCfg.Percent
+}
+
+// ProcessShadow はシャドウのAIモデルでメールを処理します。結果は比較用で、本番の分類には使いません
+func (s *AIService) ProcessShadow(ctx context.Context, messageID string, emailData *models.EmailData) (*models.AIResponse, error) {
+	if s.shadow == nil {
+		return nil, fmt.Errorf("shadow AI endpoint is not set")
+	}
+	if !s.shadow.breaker.Allow() {
+		return nil, fmt.Errorf("%s: circuit open", s.shadow.name)
+	}
+
+	redacted, _ := s.redactor.RedactEmail(emailData)
+	language := DetectLanguage(emailData)
+	workflowVersion := s.shadowCfg.WorkflowVersion
+	if workflowVersion == "" {
+		workflowVersion = s.WorkflowVersion(messageID, language)
+	}
+	payloadBytes, err := buildPayload(redacted, workflowVersion, language, "")
+	if err != nil {
+		return nil, err
+	}
+
+	aiResponse, err := s.callProvider(ctx, s.shadow, payloadBytes, false, nil)
+	if err != nil {
+		s.shadow.breaker.Failure()
+		return nil, err
+	}
+	s.shadow.breaker.Success()
+	aiResponse.Provider = s.shadow.name
+	aiResponse.WorkflowVersion = workflowVersion
+	aiResponse.Language = language
+	return aiResponse, nil
+}
+
+// buildPayload はAIワークフローへのリクエストボディを作成します
+func buildPayload(emailData *models.EmailData, workflowVersion, language, responseMode string) ([]byte, error) {
 	apiPayload := models.APIPayload{
-		User: "system",
-		Inputs: struct {
-			Subject string `json:"subject"`
-			From    string `json:"from"`
-			Body    string `json:"body"`
-		}{
-			Subject: emailData.Subject,
-			From:    emailData.From,
-			Body:    emailData.Body,
+		User:         "system",
+		ResponseMode: responseMode,
+		Inputs: models.APIInputs{
+			Subject:         emailData.Subject,
+			From:            emailData.From,
+			Body:            emailData.Body,
+			WorkflowVersion: workflowVersion,
+			Language:        language,
+			Attachments:     formatAttachments(emailData.Attachments),
 		},
 	}
 
 	payloadBytes, err := json.Marshal(apiPayload)
 	if err != nil {
-		logger.Logger.Error("ペイロードのJSONエンコードに失敗しました",
-			zap.Error(err),
-			zap.String("subject", emailData.Subject),
-		)
 		return nil, fmt.Errorf("failed to marshal payload: %v", err)
 	}
 
@@ -85,45 +362,166 @@ func (s *AIService) ProcessEmail(ctx context.Context, emailData *models.EmailDat
 	logger.Logger.Debug("AI APIリクエストペイロード",
 		zap.String("payload", string(payloadBytes)),
 	)
+	return payloadBytes, nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint, bytes.NewBuffer(payloadBytes))
+// formatAttachments は添付ファイルをワークフローの入力用の1つの文字列にまとめます。
+// 内容のない添付ファイル（バイナリなど）はファイル名と種類だけを含めます。HTML本文のインライン画像は含めません
+func formatAttachments(attachments []models.Attachment) string {
+	var b strings.Builder
+	for _, attachment := range attachments {
+		if attachment.Inline {
+			continue
+		}
+		fmt.Fprintf(&b, "--- %s (%s, %d bytes)", attachment.FileName, attachment.ContentType, attachment.Size)
+		if attachment.Truncated {
+			b.WriteString(" [truncated]")
+		}
+		b.WriteString(" ---\n")
+		if attachment.Content != "" {
+			b.WriteString(attachment.Content)
+			if !strings.HasSuffix(attachment.Content, "\n") {
+				b.WriteString("\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// callWithRetry は再試行のポリシーに従って1つのプロバイダーにAI処理をリクエストします。
+// 待ち時間が ctx の期限を超える場合や、再試行の割合の上限に達している場合は再試行しません
+func (s *AIService) callWithRetry(ctx context.Context, messageID string, provider *aiProvider, payloadBytes []byte, onPartial PartialFunc) (*models.AIResponse, error) {
+	s.retryBudget.deposit()
+
+	for attempt := 1; ; attempt++ {
+		aiResponse, err := s.callProvider(ctx, provider, payloadBytes, s.streaming.Enabled, onPartial)
+		if err == nil || s.retry == nil || ctx.Err() != nil {
+			return aiResponse, err
+		}
+
+		wait, ok := s.retry.Backoff(attempt, err)
+		if !ok {
+			return nil, err
+		}
+		if deadline, hasDeadline := ctx.Deadline(); hasDeadline && time.Until(deadline) < wait {
+			return nil, err
+		}
+		if !s.retryBudget.withdraw() {
+			logger.Logger.Warn("再試行の上限に達しているためAIプロバイダーを再試行しません",
+				zap.String("provider", provider.name),
+				zap.Error(err))
+			return nil, err
+		}
+
+		aiRetriesTotal.Inc(provider.name)
+		s.events.Record(messageID, models.StepAIRetry,
+			"provider", provider.name,
+			"attempt", strconv.Itoa(attempt),
+			"backoff", wait.String(),
+			"error", err.Error())
+		logger.Logger.Warn("AIプロバイダーへのリクエストを再試行します",
+			zap.String("provider", provider.name),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", wait),
+			zap.Error(err))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// callProvider は1つのプロバイダーにAI処理をリクエストします。streaming が true の場合はストリーミング応答として読み取ります
+func (s *AIService) callProvider(ctx context.Context, provider *aiProvider, payloadBytes []byte, streaming bool, onPartial PartialFunc) (_ *models.AIResponse, err error) {
+	start := time.Now()
+	defer func() {
+		outcome := "success"
+		if err != nil {
+			outcome = "failure"
+		}
+		aiRequestDuration.ObserveSince(start, provider.name, outcome)
+	}()
+
+	token := provider.currentToken()
+	if token == "" {
+		logger.Logger.Error("AIトークンが設定されていません", zap.String("provider", provider.name))
+		return nil, fmt.Errorf("AI token is not set")
+	}
+
+	// ストリーミングではイベントが途絶えた場合だけ打ち切り、進行中の処理は ctx の期限まで待つ
+	client := s.longClient
+	requestCtx := ctx
+	var idle *time.Timer
+	if streaming {
+		streamCtx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+		idle = time.AfterFunc(s.streaming.IdleTimeout, func() { cancel(errAIStreamStalled) })
+		defer idle.Stop()
+		client, requestCtx = s.streamClient, streamCtx
+	}
+
+	req, err := http.NewRequestWithContext(requestCtx, "POST", provider.endpoint, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		logger.Logger.Error("HTTPリクエストの作成に失敗しました",
 			zap.Error(err),
-			zap.String("endpoint", s.endpoint),
+			zap.String("provider", provider.name),
+			zap.String("endpoint", provider.endpoint),
 		)
 		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.token)
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	// リクエスト送信情報はDEBUGレベル
 	logger.Logger.Debug("AI APIにリクエストを送信します",
+		zap.String("provider", provider.name),
 		zap.String("method", req.Method),
 		zap.String("endpoint", req.URL.String()),
 	)
 
-	resp, err := s.longClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
+		if streaming {
+			err = s.streamError(requestCtx, ctx, err, 0)
+		}
 		logger.Logger.Error("HTTPリクエストの実行に失敗しました",
 			zap.Error(err),
+			zap.String("provider", provider.name),
 		)
-		return nil, fmt.Errorf("failed to make HTTP request: %v", err)
+		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		logger.Logger.Error("AI APIが異常なステータスを返しました",
 			zap.Int("status_code", resp.StatusCode),
+			zap.String("provider", provider.name),
 		)
-		return nil, fmt.Errorf("AI API returned non-200 status: %d", resp.StatusCode)
+		return nil, &aiStatusError{StatusCode: resp.StatusCode}
 	}
 
 	var aiResponse models.AIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&aiResponse); err != nil {
+	if streaming {
+		streamed, events, err := s.readStream(resp.Body, idle, onPartial)
+		if err != nil {
+			err = s.streamError(requestCtx, ctx, err, events)
+			logger.Logger.Error("AIのストリーミング応答の受信に失敗しました",
+				zap.Error(err),
+				zap.String("provider", provider.name),
+				zap.Int("events", events),
+			)
+			return nil, err
+		}
+		aiResponse = *streamed
+	} else if err := s.decodeResponse(resp.Body, &aiResponse); err != nil {
 		logger.Logger.Error("AIレスポンスのデコードに失敗しました",
 			zap.Error(err),
+			zap.String("provider", provider.name),
 		)
 		return nil, fmt.Errorf("failed to decode AI response: %v", err)
 	}
@@ -132,6 +530,7 @@ func (s *AIService) ProcessEmail(ctx context.Context, emailData *models.EmailDat
 	if err := s.ValidateResponse(&aiResponse); err != nil {
 		logger.Logger.Error("AIレスポンスの検証に失敗しました",
 			zap.Error(err),
+			zap.String("provider", provider.name),
 			zap.Any("response", aiResponse),
 		)
 		return nil, fmt.Errorf("invalid AI response: %v", err)
@@ -139,6 +538,7 @@ func (s *AIService) ProcessEmail(ctx context.Context, emailData *models.EmailDat
 
 	// 処理完了のログは重要なのでINFOレベル
 	logger.Logger.Info("AI処理が完了しました",
+		zap.String("provider", provider.name),
 		zap.String("task_id", aiResponse.TaskID),
 		zap.String("status", aiResponse.Data.Status),
 	)
@@ -146,6 +546,27 @@ func (s *AIService) ProcessEmail(ctx context.Context, emailData *models.EmailDat
 	return &aiResponse, nil
 }
 
+// decodeResponse は一括で受け取ったAI応答を読み取り、出力のマッピングを適用します
+func (s *AIService) decodeResponse(body io.Reader, aiResponse *models.AIResponse) error {
+	var raw struct {
+		Data json.RawMessage `json:"data"`
+	}
+	respBody, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(respBody, aiResponse); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(respBody, &raw); err != nil {
+		return err
+	}
+	if len(raw.Data) == 0 {
+		return nil
+	}
+	return s.mapOutputs(raw.Data, &aiResponse.Data)
+}
+
 func (s *AIService) ValidateResponse(response *models.AIResponse) error {
 	if response == nil {
 		return fmt.Errorf("AI response is nil")
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"autopilot/config"
+	"autopilot/models"
+)
+
+const testAIResponse = `{"task_id": "task-1", "data": {"status": "succeeded", "outputs": {"judgment": "要対応"}}}`
+
+// fakeAIProvider は status を返すAIプロバイダーの代わりで、受け取ったリクエスト数を数えます
+type fakeAIProvider struct {
+	mu     sync.Mutex
+	status []int // 先頭から順に返すステータス（最後の値を繰り返す）
+	calls  int
+	server *httptest.Server
+}
+
+func newFakeAIProvider(t *testing.T, status ...int) *fakeAIProvider {
+	t.Helper()
+	p := &fakeAIProvider{status: status}
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		code := p.status[min(p.calls, len(p.status)-1)]
+		p.calls++
+		p.mu.Unlock()
+
+		if code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		w.Write([]byte(testAIResponse))
+	}))
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *fakeAIProvider) respond(status ...int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status = status
+}
+
+func (p *fakeAIProvider) takeCalls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	calls := p.calls
+	p.calls = 0
+	return calls
+}
+
+// newTestAIService は providers を優先度順に呼び出すAIServiceを作成します（再試行なし、2回の失敗でブレーカーが開く）
+func newTestAIService(t *testing.T, providers ...*fakeAIProvider) *AIService {
+	t.Helper()
+	t.Setenv("TEST_AI_TOKEN", "ai-token")
+
+	names := []string{"primary", "secondary"}
+	configs := make([]config.AIProviderConfig, len(providers))
+	for i, p := range providers {
+		configs[i] = config.AIProviderConfig{Name: names[i], Endpoint: p.server.URL, TokenEnv: "TEST_AI_TOKEN"}
+	}
+	return NewAIService(configs, config.AIWorkflowConfig{Version: "v1"}, config.AIShadowConfig{},
+		config.AIRetryConfig{MaxAttempts: 1}, 2, time.Hour, nil)
+}
+
+func TestProcessEmailFailsOverToNextProvider(t *testing.T) {
+	primary := newFakeAIProvider(t, http.StatusInternalServerError)
+	secondary := newFakeAIProvider(t, http.StatusOK)
+	s := newTestAIService(t, primary, secondary)
+
+	email := &models.EmailData{Subject: "サーバーが応答しません"}
+	resp, err := s.ProcessEmail(context.Background(), "msg-1", email)
+	if err != nil {
+		t.Fatalf("ProcessEmail: %v", err)
+	}
+	if resp.Provider != "secondary" || resp.WorkflowVersion != "v1" || resp.Data.Outputs.Judgment != "要対応" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	// 連続した失敗がしきい値に達すると、クールダウンの間は失敗したプロバイダーを呼び出さない
+	if _, err := s.ProcessEmail(context.Background(), "msg-2", email); err != nil {
+		t.Fatalf("ProcessEmail: %v", err)
+	}
+	if calls := primary.takeCalls(); calls != 2 {
+		t.Errorf("primary called %d times before the breaker opened, want 2", calls)
+	}
+	if _, err := s.ProcessEmail(context.Background(), "msg-3", email); err != nil {
+		t.Fatalf("ProcessEmail: %v", err)
+	}
+	if calls := primary.takeCalls(); calls != 0 {
+		t.Errorf("primary called %d times while the breaker was open", calls)
+	}
+	if calls := secondary.takeCalls(); calls != 3 {
+		t.Errorf("secondary called %d times, want 3", calls)
+	}
+
+	pings := s.Ping(context.Background())
+	if len(pings) != 2 || !pings[0].BreakerOpen || pings[1].BreakerOpen {
+		t.Errorf("unexpected pings: %+v", pings)
+	}
+}
+
+func TestProcessEmailReportsAllProviderErrors(t *testing.T) {
+	primary := newFakeAIProvider(t, http.StatusInternalServerError)
+	secondary := newFakeAIProvider(t, http.StatusBadGateway)
+	s := newTestAIService(t, primary, secondary)
+
+	_, err := s.ProcessEmail(context.Background(), "msg-1", &models.EmailData{})
+	if err == nil {
+		t.Fatal("ProcessEmail succeeded with every provider failing")
+	}
+	for _, want := range []string{"primary: AI API returned non-200 status: 500", "secondary: AI API returned non-200 status: 502"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	b := newCircuitBreaker(2, 20*time.Millisecond)
+	b.Failure()
+	if !b.Allow() {
+		t.Fatal("breaker opened before reaching the threshold")
+	}
+	if opened := b.Failure(); !opened || b.Allow() || !b.Open() {
+		t.Fatal("breaker did not open at the threshold")
+	}
+
+	// クールダウン後は1回だけ試行を許可し、失敗すれば再び開く
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("breaker did not allow a trial call after the cooldown")
+	}
+	if b.Allow() {
+		t.Error("breaker allowed a second call while half-open")
+	}
+	b.Failure()
+	if b.Allow() {
+		t.Error("breaker allowed a call after the trial failed")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	b.Allow()
+	b.Success()
+	if !b.Allow() || b.Open() {
+		t.Error("breaker did not close after the trial succeeded")
+	}
+}
+
+func TestWorkflowVersionCanary(t *testing.T) {
+	newService := func(percent int) *AIService {
+		return NewAIService(nil, config.AIWorkflowConfig{Version: "v1", CanaryVersion: "v2", CanaryPercent: percent},
+			config.AIShadowConfig{}, config.AIRetryConfig{}, 0, 0, nil)
+	}
+
+	if got := newService(0).WorkflowVersion("msg-1", ""); got != "v1" {
+		t.Errorf("WorkflowVersion with 0%% canary = %q, want v1", got)
+	}
+	if got := newService(100).WorkflowVersion("msg-1", ""); got != "v2" {
+		t.Errorf("WorkflowVersion with 100%% canary = %q, want v2", got)
+	}
+
+	// カナリアの対象はメッセージIDで決まり、同じメッセージは常に同じバージョンになる
+	s := newService(20)
+	canary := 0
+	for i := 0; i < 1000; i++ {
+		messageID := fmt.Sprintf("msg-%d", i)
+		version := s.WorkflowVersion(messageID, "")
+		if again := s.WorkflowVersion(messageID, ""); again != version {
+			t.Fatalf("%s: version changed from %q to %q", messageID, version, again)
+		}
+		if version == "v2" {
+			canary++
+		}
+	}
+	if canary < 150 || canary > 250 {
+		t.Errorf("%d of 1000 messages used the canary, want about 200", canary)
+	}
+}
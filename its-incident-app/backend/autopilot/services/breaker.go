@@ -0,0 +1,66 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitBreaker は連続した失敗が閾値に達したプロバイダーを一定時間呼び出し対象から外します。
+// クールダウン後は1回だけ試行を許可し（half-open）、成功すれば通常状態に戻ります
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow は呼び出してよいかを返します
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) {
+		return false
+	}
+	// half-open: 次の結果が出るまで他の呼び出しを止める
+	b.openUntil = time.Now().Add(b.cooldown)
+	return true
+}
+
+// Success は呼び出しの成功を記録します
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+// Failure は呼び出しの失敗を記録し、閾値に達した場合は true を返します
+func (b *circuitBreaker) Failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		return true
+	}
+	return false
+}
+
+// Open は呼び出し対象から外している（クールダウン中の）場合に true を返します。Allow と異なり状態を変更しません
+func (b *circuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.threshold > 0 && b.failures >= b.threshold && time.Now().Before(b.openUntil)
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+// budgetCheckInterval は当日の利用量をdbpilotに問い合わせる間隔
+const budgetCheckInterval = time.Minute
+
+// BudgetGuard は1日（JST）のトークン使用量の上限を監視します。
+// 上限を超えると新しいメールのAI処理を止めて受信のみ（queued）にし、その日に1回だけアラートを送信します
+type BudgetGuard struct {
+	dbpilot     DBPilot
+	notifier    *NotifyService
+	dailyTokens int64
+
+	mu          sync.Mutex
+	usedTokens  int64
+	checkedDate string
+	checkedAt   time.Time
+	alertedDate string
+}
+
+// NewBudgetGuard は1日あたり dailyTokens を上限とするBudgetGuardを作成します。0以下の場合は nil（上限なし）を返します
+func NewBudgetGuard(dbpilot DBPilot, notifier *NotifyService, dailyTokens int64) *BudgetGuard {
+	if dailyTokens <= 0 {
+		return nil
+	}
+
+	logger.Logger.Info("AIの1日あたりのトークン上限を設定しました", zap.Int64("daily_tokens", dailyTokens))
+	return &BudgetGuard{dbpilot: dbpilot, notifier: notifier, dailyTokens: dailyTokens}
+}
+
+// Limit は1日あたりのトークン上限を返します（上限なしの場合は0）
+func (g *BudgetGuard) Limit() int64 {
+	if g == nil {
+		return 0
+	}
+	return g.dailyTokens
+}
+
+// Exceeded は当日のトークン使用量が上限に達しているかを返します。
+// 利用量の取得に失敗した場合は直前の値で判定します
+func (g *BudgetGuard) Exceeded() bool {
+	if g == nil {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	today := usageDate(time.Now())
+	if g.checkedDate != today || time.Since(g.checkedAt) >= budgetCheckInterval {
+		report, err := g.dbpilot.GetCostUsage(today, today)
+		if err != nil {
+			logger.Logger.Warn("AI利用量の取得に失敗しました", zap.Error(err))
+		} else {
+			g.usedTokens = report.Total.TotalTokens
+			g.checkedDate = today
+		}
+		g.checkedAt = time.Now()
+	}
+
+	if g.checkedDate != today || g.usedTokens < g.dailyTokens {
+		return false
+	}
+
+	if g.alertedDate != today {
+		g.alertedDate = today
+		used := g.usedTokens
+		go g.alert(today, used)
+	}
+	return true
+}
+
+func (g *BudgetGuard) alert(date string, used int64) {
+	logger.Logger.Warn("AIのトークン上限に達したため受信のみのモードに切り替えます",
+		zap.String("date", date),
+		zap.Int64("used_tokens", used),
+		zap.Int64("daily_tokens", g.dailyTokens))
+
+	content := fmt.Sprintf("%s のAIトークン使用量が %d（上限 %d）に達したため、新しいメールはAI処理せずに受信のみとしています。"+
+		"翌日以降に一括再処理（status: queued）で処理してください。", date, used, g.dailyTokens)
+	if err := g.notifier.SendAlert("AIの利用上限に達しました", content, "高"); err != nil {
+		logger.Logger.Error("利用上限のアラート送信に失敗しました", zap.Error(err))
+	}
+}
+
+// UsedTokens は直近に取得した当日のトークン使用量を返します
+func (g *BudgetGuard) UsedTokens() int64 {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.usedTokens
+}
+
+// usageDate はdbpilotの集計と同じJSTの日付を返します
+func usageDate(t time.Time) string {
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		jst = time.FixedZone("JST", 9*60*60)
+	}
+	return t.In(jst).Format("2006-01-02")
+}
+
+// GetCostUsage は期間（YYYY-MM-DD）のAI利用量の集計を日別・送信者別に取得します
+func (s *DBPilotService) GetCostUsage(from, to string) (*models.CostUsageReport, error) {
+	logFields := []zap.Field{
+		zap.String("operation", "GetCostUsage"),
+		zap.String("from", from),
+		zap.String("to", to),
+	}
+
+	// 未指定の場合はdbpilot側で当日（JST）になります
+	query := url.Values{}
+	if from != "" {
+		query.Set("from", from)
+	}
+	if to != "" {
+		query.Set("to", to)
+	}
+
+	req, err := s.createRequest("GET", "/cost-usage?"+query.Encode(), nil)
+	if err != nil {
+		logger.Logger.Error("リクエストの作成に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cost usage: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get cost usage, status: %d, response: %s",
+			resp.StatusCode, string(respBody))
+	}
+
+	var report models.CostUsageReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to decode cost usage: %v", err)
+	}
+	return &report, nil
+}
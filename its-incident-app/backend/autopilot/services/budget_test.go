@@ -0,0 +1,145 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"autopilot/models"
+)
+
+// fakeCostUsage は /cost-usage に usedTokens を返すdbpilotの代わりです。fail が true の場合は500を返します
+type fakeCostUsage struct {
+	mu         sync.Mutex
+	usedTokens int64
+	fail       bool
+	queries    []string
+}
+
+func (f *fakeCostUsage) set(usedTokens int64, fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.usedTokens, f.fail = usedTokens, fail
+}
+
+func (f *fakeCostUsage) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, r.URL.RawQuery)
+	if f.fail {
+		http.Error(w, "database unavailable", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(models.CostUsageReport{Total: models.CostUsageSummary{TotalTokens: f.usedTokens}})
+}
+
+// newTestBudgetGuard は dailyTokens を上限とするBudgetGuardと、アラートの送信を受け取るチャネルを返します
+func newTestBudgetGuard(t *testing.T, usage *fakeCostUsage, dailyTokens int64) (*BudgetGuard, chan map[string]interface{}) {
+	t.Helper()
+	t.Setenv("SERVICE_TOKEN", testServiceToken)
+
+	alerts := make(chan map[string]interface{}, 10)
+	notify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts <- alert
+	}))
+	t.Cleanup(notify.Close)
+
+	db := newTestDBPilotService(t, usage.serveHTTP)
+	return NewBudgetGuard(db, NewNotifyService(notify.URL), dailyTokens), alerts
+}
+
+// expireBudgetCheck は次の Exceeded で利用量を問い合わせ直すようにします
+func expireBudgetCheck(g *BudgetGuard) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.checkedAt = time.Time{}
+}
+
+func TestBudgetGuardDisabled(t *testing.T) {
+	guard := NewBudgetGuard(nil, nil, 0)
+	if guard != nil {
+		t.Fatal("NewBudgetGuard returned a guard without a limit")
+	}
+	if guard.Exceeded() || guard.Limit() != 0 || guard.UsedTokens() != 0 {
+		t.Error("nil guard should never be exceeded")
+	}
+}
+
+func TestBudgetGuardExceeded(t *testing.T) {
+	usage := &fakeCostUsage{usedTokens: 999}
+	guard, alerts := newTestBudgetGuard(t, usage, 1000)
+
+	if guard.Exceeded() {
+		t.Fatal("Exceeded with usage below the limit")
+	}
+	today := usageDate(time.Now())
+	if len(usage.queries) != 1 || usage.queries[0] != "from="+today+"&to="+today {
+		t.Errorf("queries = %v, want today's usage (%s)", usage.queries, today)
+	}
+
+	// 問い合わせの間隔内はdbpilotに問い合わせない
+	usage.set(1000, false)
+	if guard.Exceeded() {
+		t.Error("usage re-fetched within the check interval")
+	}
+
+	expireBudgetCheck(guard)
+	if !guard.Exceeded() || guard.UsedTokens() != 1000 {
+		t.Fatalf("Exceeded = false, UsedTokens = %d, want exceeded at the limit", guard.UsedTokens())
+	}
+	select {
+	case alert := <-alerts:
+		if alert["title"] != "AIの利用上限に達しました" || alert["priority"] != "高" {
+			t.Errorf("unexpected alert: %+v", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("alert was not sent")
+	}
+
+	// アラートは1日1回だけ送信する
+	expireBudgetCheck(guard)
+	if !guard.Exceeded() {
+		t.Error("Exceeded = false on the second check")
+	}
+	select {
+	case alert := <-alerts:
+		t.Errorf("alert sent twice on the same day: %+v", alert)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBudgetGuardKeepsLastUsageOnError(t *testing.T) {
+	// 一度も取得できていない場合は上限なしとして扱い、受信を止めない
+	usage := &fakeCostUsage{fail: true}
+	guard, _ := newTestBudgetGuard(t, usage, 1000)
+	if guard.Exceeded() {
+		t.Error("Exceeded without any usage report")
+	}
+
+	// 取得に失敗した場合は直前の値で判定する
+	usage.set(5000, false)
+	expireBudgetCheck(guard)
+	if !guard.Exceeded() {
+		t.Fatal("Exceeded = false with usage above the limit")
+	}
+	usage.set(0, true)
+	expireBudgetCheck(guard)
+	if !guard.Exceeded() {
+		t.Error("Exceeded = false after a failed usage check")
+	}
+}
+
+func TestUsageDateUsesJST(t *testing.T) {
+	// UTC 15:00 はJSTの翌日 0:00
+	if got := usageDate(time.Date(2026, 3, 31, 15, 0, 0, 0, time.UTC)); got != "2026-04-01" {
+		t.Errorf("usageDate = %s, want 2026-04-01", got)
+	}
+	if got := usageDate(time.Date(2026, 3, 31, 14, 59, 0, 0, time.UTC)); got != "2026-03-31" {
+		t.Errorf("usageDate = %s, want 2026-03-31", got)
+	}
+}
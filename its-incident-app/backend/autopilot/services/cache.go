@@ -0,0 +1,126 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+// ContentHash は件名・本文・添付ファイルの内容を正規化（前後の空白除去・空白の連続を1つに・小文字化）したSHA-256ハッシュを返します
+func ContentHash(emailData *models.EmailData) string {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.Join(strings.Fields(s), " "))
+	}
+	// 添付ファイルにアラートの詳細が含まれる場合があるため、添付ファイルの内容もハッシュに含める
+	content := normalize(emailData.Subject) + "\n" + normalize(emailData.Body)
+	for _, attachment := range emailData.Attachments {
+		content += "\n" + attachment.FileName + "\n" + normalize(attachment.Content)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// ResultCache は同じ内容のメールに対する直近のAI処理結果を再利用します。
+// 監視システムが同じ文面のアラートを繰り返し送る場合のAI呼び出しを削減するためのもので、
+// nil の場合（AI_CACHE_TTL 未設定）は常にキャッシュなしとして動作します
+type ResultCache struct {
+	dbpilot DBPilot
+	ttl     time.Duration
+}
+
+// NewResultCache は ttl 以内の結果を再利用するResultCacheを作成します。ttl が0以下の場合は nil を返します
+func NewResultCache(dbpilot DBPilot, ttl time.Duration) *ResultCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	logger.Logger.Info("AI処理結果のキャッシュを有効にしました", zap.Duration("ttl", ttl))
+	return &ResultCache{dbpilot: dbpilot, ttl: ttl}
+}
+
+// Lookup は同じ内容・同じワークフローのバージョンの結果があれば、キャッシュヒットの印を付けた AIResponse を返します
+func (c *ResultCache) Lookup(contentHash, workflowVersion string) *models.AIResponse {
+	if c == nil {
+		return nil
+	}
+
+	cached, messageID, err := c.dbpilot.FindCachedResponse(contentHash, workflowVersion, time.Now().Add(-c.ttl))
+	if err != nil {
+		logger.Logger.Warn("AI処理結果のキャッシュの確認に失敗しました",
+			zap.String("content_hash", contentHash),
+			zap.Error(err))
+		return nil
+	}
+	if cached == nil {
+		return nil
+	}
+
+	// 再利用した結果はAIの利用量に含めず、インシデントの日時は今回の受信時刻にする
+	now := time.Now().Unix()
+	cached.ContentHash = contentHash
+	cached.CacheHit = true
+	cached.CachedFromMessageID = messageID
+	cached.Data.CreatedAt = now
+	cached.Data.FinishedAt = now
+	cached.Data.ElapsedTime = 0
+	cached.Data.TotalTokens = 0
+	return cached
+}
+
+// FindCachedResponse は since 以降に保存された同じ内容のAI応答と、その元のメッセージIDを返します（見つからない場合は nil）
+func (s *DBPilotService) FindCachedResponse(contentHash, workflowVersion string, since time.Time) (*models.AIResponse, string, error) {
+	logFields := []zap.Field{
+		zap.String("content_hash", contentHash),
+		zap.String("operation", "FindCachedResponse"),
+	}
+
+	query := url.Values{}
+	query.Set("content_hash", contentHash)
+	query.Set("workflow_version", workflowVersion)
+	query.Set("since", strconv.FormatInt(since.Unix(), 10))
+
+	req, err := s.createRequest("GET", "/api-responses/cache?"+query.Encode(), nil)
+	if err != nil {
+		logger.Logger.Error("リクエストの作成に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, "", fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find cached response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, "", fmt.Errorf("failed to find cached response, status: %d, response: %s",
+			resp.StatusCode, string(respBody))
+	}
+
+	var cached struct {
+		models.AIResponse
+		MessageID string `json:"message_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&cached); err != nil {
+		return nil, "", fmt.Errorf("failed to decode cached response: %v", err)
+	}
+
+	return &cached.AIResponse, cached.MessageID, nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"autopilot/models"
+)
+
+func TestContentHash(t *testing.T) {
+	base := ContentHash(&models.EmailData{Subject: "Disk full on web01", Body: "/var is 95% full"})
+
+	// 空白と大文字小文字の違いは同じ内容として扱う
+	same := ContentHash(&models.EmailData{Subject: "  disk FULL on  web01 ", Body: "/var is\n95% full\n", From: "other@example.com"})
+	if same != base {
+		t.Error("normalized content produced a different hash")
+	}
+
+	for name, email := range map[string]*models.EmailData{
+		"different body": {Subject: "Disk full on web01", Body: "/var is 96% full"},
+		"with attachment": {Subject: "Disk full on web01", Body: "/var is 95% full",
+			Attachments: []models.Attachment{{FileName: "df.txt", Content: "/var 95%"}}},
+	} {
+		if ContentHash(email) == base {
+			t.Errorf("%s: hash did not change", name)
+		}
+	}
+}
+
+func TestResultCacheLookup(t *testing.T) {
+	if NewResultCache(nil, 0) != nil {
+		t.Fatal("NewResultCache returned a cache without a TTL")
+	}
+
+	var query url.Values
+	s := newTestDBPilotService(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		if query.Get("content_hash") != "hash-1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"message_id": "msg-0", "task_id": "task-0",
+			"data": {"status": "succeeded", "total_tokens": 1200, "elapsed_time": 8.5, "created_at": 1, "finished_at": 2}}`))
+	})
+	cache := NewResultCache(s, time.Hour)
+
+	before := time.Now()
+	cached := cache.Lookup("hash-1", "v2")
+	if cached == nil {
+		t.Fatal("Lookup returned no cached response")
+	}
+	if query.Get("workflow_version") != "v2" {
+		t.Errorf("workflow_version = %q, want v2", query.Get("workflow_version"))
+	}
+	since, _ := strconv.ParseInt(query.Get("since"), 10, 64)
+	if want := before.Add(-time.Hour).Unix(); since < want || since > want+1 {
+		t.Errorf("since = %d, want %d", since, want)
+	}
+
+	// 再利用した結果はAIの利用量に含めず、日時は今回の処理時刻にする
+	if !cached.CacheHit || cached.CachedFromMessageID != "msg-0" || cached.ContentHash != "hash-1" || cached.TaskID != "task-0" {
+		t.Errorf("unexpected cached response: %+v", cached)
+	}
+	if cached.Data.TotalTokens != 0 || cached.Data.ElapsedTime != 0 || cached.Data.CreatedAt < before.Unix() || cached.Data.FinishedAt < before.Unix() {
+		t.Errorf("cached usage and times were not reset: %+v", cached.Data)
+	}
+
+	if cache.Lookup("hash-2", "v2") != nil {
+		t.Error("Lookup returned a response for an unknown hash")
+	}
+	var missing *ResultCache
+	if missing.Lookup("hash-1", "v2") != nil {
+		t.Error("disabled cache returned a response")
+	}
+}
+
+func TestResultCacheLookupIgnoresErrors(t *testing.T) {
+	s := newTestDBPilotService(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database unavailable", http.StatusInternalServerError)
+	})
+
+	// キャッシュの確認に失敗してもAI処理は続けるため、キャッシュなしとして扱う
+	if cached := NewResultCache(s, time.Hour).Lookup("hash-1", "v1"); cached != nil {
+		t.Errorf("Lookup = %+v, want nil on error", cached)
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// callbackMaxAttempts は完了通知の送信を試行する回数
+	callbackMaxAttempts = 3
+	// callbackRetryInterval は完了通知の再送までの初回の待ち時間（試行ごとに倍）
+	callbackRetryInterval = 2 * time.Second
+)
+
+// CallbackService は処理の完了を呼び出し元のコールバックURLに通知します
+type CallbackService struct {
+	client        *http.Client
+	allowedHosts  []string
+	secret        string
+	allowInsecure bool
+}
+
+// NewCallbackService はコールバックの送信先を allowedHosts に制限したCallbackServiceを作成します。
+// allowedHosts が空の場合はコールバックを受け付けません。送信する完了通知は secret で必ず署名します。
+// allowInsecure が false の場合は https のURLのみ受け付け、名前解決の結果がループバック・リンクローカル・
+// プライベートアドレスの場合は接続しません
+func NewCallbackService(allowedHosts []string, secret string, allowInsecure bool) *CallbackService {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowInsecure {
+		dialer.Control = rejectInternalAddress
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &CallbackService{
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: transport,
+			// リダイレクト先は検証していないため追従しない
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		allowedHosts:  allowedHosts,
+		secret:        secret,
+		allowInsecure: allowInsecure,
+	}
+}
+
+// ValidateURL はコールバックURLが送信先として許可されているかを確認します
+func (s *CallbackService) ValidateURL(raw string) error {
+	if s == nil || len(s.allowedHosts) == 0 || s.secret == "" {
+		return fmt.Errorf("callbacks are not enabled")
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid callback URL")
+	}
+	if u.Scheme != "https" && !(s.allowInsecure && u.Scheme == "http") {
+		return fmt.Errorf("callback URL must use https")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range s.allowedHosts {
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("callback host %s is not allowed", host)
+}
+
+// rejectInternalAddress は名前解決後の接続先が内部向けのアドレスの場合に接続を拒否します。
+// 許可したホスト名が内部のアドレスに解決される場合（DNSリバインディングなど）も拒否されます
+func rejectInternalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("callback address %s is not an IP address", host)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("callback address %s is not allowed", ip)
+	}
+	return nil
+}
+
+// Send は完了通知をコールバックURLにPOSTします。2xx 以外の応答や通信エラーは間隔をあけて再送します
+func (s *CallbackService) Send(ctx context.Context, callbackURL string, callback *models.CompletionCallback) error {
+	if s.secret == "" {
+		return fmt.Errorf("callback secret is not configured")
+	}
+
+	body, err := json.Marshal(callback)
+	if err != nil {
+		return fmt.Errorf("failed to marshal callback: %v", err)
+	}
+
+	wait := callbackRetryInterval
+	for attempt := 1; ; attempt++ {
+		err = s.post(ctx, callbackURL, body)
+		if err == nil || attempt >= callbackMaxAttempts {
+			return err
+		}
+
+		logger.Logger.Warn("完了通知の送信に失敗したため再送します",
+			zap.String("message_id", callback.MessageID),
+			zap.Int("attempt", attempt),
+			zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// signCallback は完了通知の本文に対する X-Autopilot-Signature の値（sha256=HMAC-SHA256の16進表記）を返します
+func signCallback(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *CallbackService) post(ctx context.Context, callbackURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Autopilot-Signature", signCallback(s.secret, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send callback: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"autopilot/models"
+)
+
+const testCallbackSecret = "callback-secret"
+
+func TestCallbackValidateURL(t *testing.T) {
+	// 許可するホストが未設定の場合はコールバックを受け付けない
+	disabled := NewCallbackService(nil, testCallbackSecret, false)
+	if err := disabled.ValidateURL("https://hooks.example.com/done"); err == nil {
+		t.Error("callback accepted without CALLBACK_ALLOWED_HOSTS")
+	}
+	var missing *CallbackService
+	if err := missing.ValidateURL("https://hooks.example.com/done"); err == nil {
+		t.Error("callback accepted without a callback service")
+	}
+	unsigned := NewCallbackService([]string{"hooks.example.com"}, "", false)
+	if err := unsigned.ValidateURL("https://hooks.example.com/done"); err == nil {
+		t.Error("callback accepted without CALLBACK_SECRET")
+	}
+
+	s := NewCallbackService([]string{"hooks.example.com", ".callbacks.example.com"}, testCallbackSecret, false)
+	tests := map[string]bool{
+		"https://hooks.example.com/done":          true,
+		"https://HOOKS.example.com/done":          true,
+		"https://a.callbacks.example.com/done":    true,
+		"http://hooks.example.com/done":           false,
+		"https://evil.example.com/done":           false,
+		"https://hooks.example.com.evil.com/":     false,
+		"https://169.254.169.254/computeMetadata": false,
+		"https://localhost/done":                  false,
+		"ftp://hooks.example.com/done":            false,
+		"not a url":                               false,
+	}
+	for raw, want := range tests {
+		if err := s.ValidateURL(raw); (err == nil) != want {
+			t.Errorf("ValidateURL(%q) = %v, want allowed=%v", raw, err, want)
+		}
+	}
+
+	insecure := NewCallbackService([]string{"localhost"}, testCallbackSecret, true)
+	if err := insecure.ValidateURL("http://localhost:8080/done"); err != nil {
+		t.Errorf("ValidateURL with allowInsecure: %v", err)
+	}
+}
+
+func TestCallbackSendRejectsInternalAddress(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	// 許可したホスト名でも、接続先がループバックアドレスであれば送信しない
+	u, _ := url.Parse(server.URL)
+	s := NewCallbackService([]string{u.Hostname()}, testCallbackSecret, false)
+	if err := s.post(context.Background(), server.URL, []byte(`{}`)); err == nil {
+		t.Error("callback sent to a loopback address")
+	}
+	if called {
+		t.Error("callback server was reached")
+	}
+}
+
+func TestCallbackSendSignsBody(t *testing.T) {
+	var signature, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Autopilot-Signature")
+		raw, _ := io.ReadAll(r.Body)
+		body = string(raw)
+	}))
+	defer server.Close()
+
+	s := NewCallbackService([]string{"127.0.0.1"}, testCallbackSecret, true)
+	callback := &models.CompletionCallback{MessageID: "msg-1", Status: models.StatusComplete, IncidentID: 7}
+	if err := s.Send(context.Background(), server.URL, callback); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if !strings.Contains(body, `"message_id":"msg-1"`) {
+		t.Errorf("unexpected body: %s", body)
+	}
+	if want := signCallback(testCallbackSecret, []byte(body)); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+
+	// 署名鍵がない場合は送信しない
+	unsigned := NewCallbackService([]string{"127.0.0.1"}, "", true)
+	if err := unsigned.Send(context.Background(), server.URL, callback); err == nil {
+		t.Error("callback sent without a signing secret")
+	}
+}
+
+func TestCallbackSendDoesNotFollowRedirects(t *testing.T) {
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	s := NewCallbackService([]string{"127.0.0.1"}, testCallbackSecret, true)
+	if err := s.post(context.Background(), server.URL, []byte(`{}`)); err == nil {
+		t.Error("redirect response treated as success")
+	}
+	if redirected {
+		t.Error("callback followed a redirect")
+	}
+}
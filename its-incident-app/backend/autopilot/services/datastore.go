@@ -0,0 +1,539 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/models"
+	"autopilot/secrets"
+
+	"go.uber.org/zap"
+)
+
+const (
+	datastoreEndpoint = "https://datastore.googleapis.com/v1/"
+	// statusKind は処理状態を保存するエンティティの種類（キーの名前はメッセージID）
+	statusKind = "ProcessingStatus"
+	// maxCommitAttempts はトランザクションが競合した場合に更新を試みる回数
+	maxCommitAttempts = 3
+)
+
+// ErrUnsupportedFilter は処理状態の保存先が対応していない検索条件が指定されたことを表します
+var ErrUnsupportedFilter = errors.New("filter is not supported by the status store")
+
+// errTransactionConflict はDatastoreのトランザクションが他の更新と競合したことを表します
+var errTransactionConflict = errors.New("datastore transaction conflict")
+
+// knownStatuses は条件で状態を指定しない場合に件数を集計する処理状態
+var knownStatuses = []models.ProcessStatus{
+	models.StatusPending, models.StatusRunning, models.StatusComplete, models.StatusFailed,
+	models.StatusHeld, models.StatusRejected, models.StatusRequeue, models.StatusQueued,
+	models.StatusQuarantined,
+}
+
+// DatastoreStatusStore はFirestore（Datastoreモード）のREST APIで処理状態を保存するStatusStoreです。
+// DATASTORE_EMULATOR_HOST が設定されている場合はエミュレーターに接続します。
+// 一覧の取得には status と created_at（updated_at で絞り込む場合はそれも）の複合インデックスが必要です
+type DatastoreStatusStore struct {
+	project   string
+	database  string
+	namespace string
+	endpoint  string
+	client    *http.Client
+	useAuth   bool
+}
+
+// NewDatastoreStatusStore は database（空の場合は既定のデータベース）と namespace に処理状態を保存するStatusStoreを作成します
+func NewDatastoreStatusStore(database, namespace string) (*DatastoreStatusStore, error) {
+	project, err := secrets.ProjectID()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &DatastoreStatusStore{
+		project:   project,
+		database:  database,
+		namespace: namespace,
+		endpoint:  datastoreEndpoint,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		useAuth:   true,
+	}
+	if host := os.Getenv("DATASTORE_EMULATOR_HOST"); host != "" {
+		s.endpoint = "http://" + host + "/v1/"
+		s.useAuth = false
+	}
+	return s, nil
+}
+
+// datastoreValue はDatastoreのプロパティの値です（使用する型のみ）
+type datastoreValue struct {
+	StringValue        *string         `json:"stringValue,omitempty"`
+	IntegerValue       *string         `json:"integerValue,omitempty"`
+	TimestampValue     *time.Time      `json:"timestampValue,omitempty"`
+	ArrayValue         *datastoreArray `json:"arrayValue,omitempty"`
+	ExcludeFromIndexes bool            `json:"excludeFromIndexes,omitempty"`
+}
+
+type datastoreArray struct {
+	Values []datastoreValue `json:"values"`
+}
+
+type datastoreKey struct {
+	PartitionID map[string]string      `json:"partitionId"`
+	Path        []datastorePathElement `json:"path"`
+}
+
+type datastorePathElement struct {
+	Kind string `json:"kind"`
+	Name string `json:"name,omitempty"`
+}
+
+type datastoreEntity struct {
+	Key        datastoreKey              `json:"key"`
+	Properties map[string]datastoreValue `json:"properties"`
+}
+
+func stringValue(value string, excludeFromIndexes bool) datastoreValue {
+	return datastoreValue{StringValue: &value, ExcludeFromIndexes: excludeFromIndexes}
+}
+
+func timestampValue(value time.Time) datastoreValue {
+	value = value.UTC()
+	return datastoreValue{TimestampValue: &value}
+}
+
+func (s *DatastoreStatusStore) partitionID() map[string]string {
+	partition := map[string]string{"projectId": s.project}
+	if s.database != "" {
+		partition["databaseId"] = s.database
+	}
+	if s.namespace != "" {
+		partition["namespaceId"] = s.namespace
+	}
+	return partition
+}
+
+func (s *DatastoreStatusStore) key(messageID string) datastoreKey {
+	return datastoreKey{
+		PartitionID: s.partitionID(),
+		Path:        []datastorePathElement{{Kind: statusKind, Name: messageID}},
+	}
+}
+
+// toEntity は処理状態をエンティティに変換します。エラー・コールバックURL・途中結果は長くなり得るためインデックスに含めません
+func (s *DatastoreStatusStore) toEntity(status *models.ProcessingStatus) (*datastoreEntity, error) {
+	properties := map[string]datastoreValue{
+		"status":     stringValue(string(status.Status), false),
+		"task_id":    stringValue(status.TaskID, false),
+		"created_at": timestampValue(status.CreatedAt),
+		"updated_at": timestampValue(status.UpdatedAt),
+		"error":      stringValue(status.Error, true),
+	}
+	if status.CompletedAt != nil {
+		properties["completed_at"] = timestampValue(*status.CompletedAt)
+	}
+	if status.IncidentID != 0 {
+		incidentID := strconv.FormatUint(uint64(status.IncidentID), 10)
+		properties["incident_id"] = datastoreValue{IntegerValue: &incidentID}
+	}
+	if status.CallbackURL != "" {
+		properties["callback_url"] = stringValue(status.CallbackURL, true)
+	}
+	if len(status.PartialOutputs) > 0 {
+		partial, err := json.Marshal(status.PartialOutputs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal partial outputs: %v", err)
+		}
+		properties["partial_outputs"] = stringValue(string(partial), true)
+	}
+	return &datastoreEntity{Key: s.key(status.MessageID), Properties: properties}, nil
+}
+
+// fromEntity はエンティティを処理状態に変換します
+func fromEntity(entity *datastoreEntity) models.ProcessingStatus {
+	var status models.ProcessingStatus
+	if n := len(entity.Key.Path); n > 0 {
+		status.MessageID = entity.Key.Path[n-1].Name
+	}
+	str := func(name string) string {
+		if value, ok := entity.Properties[name]; ok && value.StringValue != nil {
+			return *value.StringValue
+		}
+		return ""
+	}
+	timestamp := func(name string) *time.Time {
+		if value, ok := entity.Properties[name]; ok && value.TimestampValue != nil {
+			return value.TimestampValue
+		}
+		return nil
+	}
+
+	status.Status = models.ProcessStatus(str("status"))
+	status.TaskID = str("task_id")
+	status.Error = str("error")
+	status.CallbackURL = str("callback_url")
+	status.CompletedAt = timestamp("completed_at")
+	if createdAt := timestamp("created_at"); createdAt != nil {
+		status.CreatedAt = *createdAt
+	}
+	if updatedAt := timestamp("updated_at"); updatedAt != nil {
+		status.UpdatedAt = *updatedAt
+	}
+	if value, ok := entity.Properties["incident_id"]; ok && value.IntegerValue != nil {
+		if incidentID, err := strconv.ParseUint(*value.IntegerValue, 10, 64); err == nil {
+			status.IncidentID = uint(incidentID)
+		}
+	}
+	if partial := str("partial_outputs"); partial != "" {
+		if err := json.Unmarshal([]byte(partial), &status.PartialOutputs); err != nil {
+			logger.Logger.Warn("途中結果のデコードに失敗しました",
+				zap.String("message_id", status.MessageID), zap.Error(err))
+		}
+	}
+	return status
+}
+
+func (s *DatastoreStatusStore) GetProcessingStatus(messageID string) (*models.ProcessingStatus, error) {
+	entity, err := s.lookup(messageID, "")
+	if err != nil {
+		logger.Logger.Error("処理状態の取得に失敗しました",
+			zap.String("message_id", messageID), zap.String("operation", "GetProcessingStatus"), zap.Error(err))
+		return nil, fmt.Errorf("failed to get processing status: %v", err)
+	}
+	if entity == nil {
+		return nil, fmt.Errorf("processing status not found for message_id: %s", messageID)
+	}
+	status := fromEntity(entity)
+	return &status, nil
+}
+
+// UpdateProcessingStatus はトランザクション内で既存の処理状態を読み取り、dbpilotと同じ規則で値を引き継いで保存します
+func (s *DatastoreStatusStore) UpdateProcessingStatus(status *models.ProcessingStatus) error {
+	return s.save(status, "")
+}
+
+// TransitionProcessingStatus はトランザクション内で現在の状態が from であることを確認してから保存します
+func (s *DatastoreStatusStore) TransitionProcessingStatus(from models.ProcessStatus, status *models.ProcessingStatus) error {
+	return s.save(status, from)
+}
+
+func (s *DatastoreStatusStore) save(status *models.ProcessingStatus, expected models.ProcessStatus) error {
+	logFields := []zap.Field{
+		zap.String("message_id", status.MessageID),
+		zap.String("operation", "UpdateProcessingStatus"),
+		zap.String("status", string(status.Status)),
+	}
+	if expected != "" {
+		logFields = append(logFields, zap.String("expected_status", string(expected)))
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxCommitAttempts; attempt++ {
+		if err = s.update(status, expected); !errors.Is(err, errTransactionConflict) {
+			break
+		}
+		logger.Logger.Debug("処理状態の更新が競合したため再試行します",
+			append(logFields, zap.Int("attempt", attempt))...)
+	}
+	if errors.Is(err, ErrStatusConflict) {
+		logger.Logger.Info("処理状態が変わっていたため更新しませんでした", logFields...)
+		return err
+	}
+	if err != nil {
+		logger.Logger.Error("処理状態の更新に失敗しました", append(logFields, zap.Error(err))...)
+		return fmt.Errorf("failed to update processing status: %v", err)
+	}
+
+	logger.Logger.Debug("処理状態を更新しました", logFields...)
+	return nil
+}
+
+// update は1回のトランザクションで処理状態を保存します。expected を指定した場合は現在の状態が一致する場合だけ保存します
+func (s *DatastoreStatusStore) update(status *models.ProcessingStatus, expected models.ProcessStatus) error {
+	var begun struct {
+		Transaction string `json:"transaction"`
+	}
+	if err := s.call(":beginTransaction", map[string]interface{}{}, &begun); err != nil {
+		return err
+	}
+
+	existing, err := s.lookup(status.MessageID, begun.Transaction)
+	if err != nil {
+		return err
+	}
+	if expected != "" {
+		var mismatch error
+		switch {
+		case existing == nil:
+			mismatch = fmt.Errorf("processing status not found for message_id: %s", status.MessageID)
+		case fromEntity(existing).Status != expected:
+			mismatch = ErrStatusConflict
+		}
+		if mismatch != nil {
+			s.call(":rollback", map[string]interface{}{"transaction": begun.Transaction}, nil)
+			return mismatch
+		}
+	}
+
+	now := time.Now()
+	updated := *status
+	updated.UpdatedAt = now
+	if existing != nil {
+		previous := fromEntity(existing)
+		updated.CreatedAt = previous.CreatedAt
+		if updated.IncidentID == 0 {
+			updated.IncidentID = previous.IncidentID
+		}
+		if updated.CallbackURL == "" {
+			updated.CallbackURL = previous.CallbackURL
+		}
+	}
+	if updated.CreatedAt.IsZero() {
+		updated.CreatedAt = now
+	}
+
+	entity, err := s.toEntity(&updated)
+	if err != nil {
+		return err
+	}
+	return s.call(":commit", map[string]interface{}{
+		"mode":        "TRANSACTIONAL",
+		"transaction": begun.Transaction,
+		"mutations":   []map[string]interface{}{{"upsert": entity}},
+	}, nil)
+}
+
+// lookup はメッセージIDの処理状態のエンティティを返します。存在しない場合は nil を返します
+func (s *DatastoreStatusStore) lookup(messageID, transaction string) (*datastoreEntity, error) {
+	body := map[string]interface{}{"keys": []datastoreKey{s.key(messageID)}}
+	if transaction != "" {
+		body["readOptions"] = map[string]string{"transaction": transaction}
+	}
+
+	var result struct {
+		Found []struct {
+			Entity datastoreEntity `json:"entity"`
+		} `json:"found"`
+	}
+	if err := s.call(":lookup", body, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Found) == 0 {
+		return nil, nil
+	}
+	return &result.Found[0].Entity, nil
+}
+
+func (s *DatastoreStatusStore) ListProcessingStatuses(status models.ProcessStatus) ([]models.ProcessingStatus, error) {
+	return s.SearchProcessingStatuses(models.StatusFilter{Statuses: []models.ProcessStatus{status}})
+}
+
+// SearchProcessingStatuses は状態・期間などの条件で処理状態の一覧を取得します
+func (s *DatastoreStatusStore) SearchProcessingStatuses(filter models.StatusFilter) ([]models.ProcessingStatus, error) {
+	list, err := s.SummarizeProcessingStatuses(filter)
+	if err != nil {
+		return nil, err
+	}
+	return list.Data, nil
+}
+
+// SummarizeProcessingStatuses は処理状態の一覧と、状態ごとの件数を取得します。
+// メール本文はDatastoreにないため、送信者での絞り込みには対応していません（ErrUnsupportedFilter）
+func (s *DatastoreStatusStore) SummarizeProcessingStatuses(filter models.StatusFilter) (*models.StatusList, error) {
+	if filter.Sender != "" {
+		return nil, fmt.Errorf("%w: sender", ErrUnsupportedFilter)
+	}
+	logFields := []zap.Field{
+		zap.String("operation", "SummarizeProcessingStatuses"),
+		zap.Int("statuses", len(filter.Statuses)),
+	}
+
+	list := &models.StatusList{ByStatus: make(map[models.ProcessStatus]int64)}
+	statuses := filter.Statuses
+	if len(statuses) == 0 {
+		statuses = knownStatuses
+	}
+	for _, status := range statuses {
+		count, err := s.count(filter, status)
+		if err != nil {
+			logger.Logger.Error("処理状態の件数の取得に失敗しました",
+				append(logFields, zap.String("status", string(status)), zap.Error(err))...)
+			return nil, fmt.Errorf("failed to count processing statuses: %v", err)
+		}
+		if count > 0 {
+			list.ByStatus[status] = count
+			list.Total += count
+		}
+	}
+
+	data, err := s.query(filter)
+	if err != nil {
+		logger.Logger.Error("処理状態一覧の取得に失敗しました", append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to list processing statuses: %v", err)
+	}
+	list.Data = data
+	list.Count = len(data)
+	return list, nil
+}
+
+// buildQuery は条件からDatastoreのクエリを組み立てます（作成日時の昇順）
+func (s *DatastoreStatusStore) buildQuery(filter models.StatusFilter, statuses []models.ProcessStatus) map[string]interface{} {
+	propertyFilter := func(property, op string, value datastoreValue) map[string]interface{} {
+		return map[string]interface{}{"propertyFilter": map[string]interface{}{
+			"property": map[string]string{"name": property},
+			"op":       op,
+			"value":    value,
+		}}
+	}
+
+	var filters []map[string]interface{}
+	switch len(statuses) {
+	case 0:
+	case 1:
+		filters = append(filters, propertyFilter("status", "EQUAL", stringValue(string(statuses[0]), false)))
+	default:
+		values := make([]datastoreValue, 0, len(statuses))
+		for _, status := range statuses {
+			values = append(values, stringValue(string(status), false))
+		}
+		filters = append(filters, propertyFilter("status", "IN", datastoreValue{ArrayValue: &datastoreArray{Values: values}}))
+	}
+	if !filter.From.IsZero() {
+		filters = append(filters, propertyFilter("created_at", "GREATER_THAN_OR_EQUAL", timestampValue(filter.From)))
+	}
+	if !filter.To.IsZero() {
+		filters = append(filters, propertyFilter("created_at", "LESS_THAN", timestampValue(filter.To)))
+	}
+	if !filter.UpdatedBefore.IsZero() {
+		filters = append(filters, propertyFilter("updated_at", "LESS_THAN", timestampValue(filter.UpdatedBefore)))
+	}
+
+	query := map[string]interface{}{
+		"kind":  []map[string]string{{"name": statusKind}},
+		"order": []map[string]interface{}{{"property": map[string]string{"name": "created_at"}, "direction": "ASCENDING"}},
+	}
+	if len(filters) > 0 {
+		query["filter"] = map[string]interface{}{"compositeFilter": map[string]interface{}{"op": "AND", "filters": filters}}
+	}
+	return query
+}
+
+// query は条件に一致する処理状態を作成日時の昇順で最大 filter.Limit 件（0の場合はすべて）取得します
+func (s *DatastoreStatusStore) query(filter models.StatusFilter) ([]models.ProcessingStatus, error) {
+	query := s.buildQuery(filter, filter.Statuses)
+	if filter.Limit > 0 {
+		query["limit"] = filter.Limit
+	}
+
+	statuses := make([]models.ProcessingStatus, 0)
+	for {
+		var result struct {
+			Batch struct {
+				EntityResults []struct {
+					Entity datastoreEntity `json:"entity"`
+				} `json:"entityResults"`
+				EndCursor   string `json:"endCursor"`
+				MoreResults string `json:"moreResults"`
+			} `json:"batch"`
+		}
+		if err := s.call(":runQuery", map[string]interface{}{
+			"partitionId": s.partitionID(),
+			"query":       query,
+		}, &result); err != nil {
+			return nil, err
+		}
+
+		for _, entityResult := range result.Batch.EntityResults {
+			statuses = append(statuses, fromEntity(&entityResult.Entity))
+		}
+		if result.Batch.MoreResults != "NOT_FINISHED" || (filter.Limit > 0 && len(statuses) >= filter.Limit) {
+			break
+		}
+		query["startCursor"] = result.Batch.EndCursor
+		if filter.Limit > 0 {
+			query["limit"] = filter.Limit - len(statuses)
+		}
+	}
+
+	// 作成日時の順序を保証する（インデックスの順序に依存しない）
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].CreatedAt.Before(statuses[j].CreatedAt)
+	})
+	return statuses, nil
+}
+
+// count は条件に一致する status の処理状態の件数を集計クエリで取得します
+func (s *DatastoreStatusStore) count(filter models.StatusFilter, status models.ProcessStatus) (int64, error) {
+	var result struct {
+		Batch struct {
+			AggregationResults []struct {
+				AggregateProperties map[string]datastoreValue `json:"aggregateProperties"`
+			} `json:"aggregationResults"`
+		} `json:"batch"`
+	}
+	if err := s.call(":runAggregationQuery", map[string]interface{}{
+		"partitionId": s.partitionID(),
+		"aggregationQuery": map[string]interface{}{
+			"nestedQuery":  s.buildQuery(filter, []models.ProcessStatus{status}),
+			"aggregations": []map[string]interface{}{{"alias": "total", "count": map[string]interface{}{}}},
+		},
+	}, &result); err != nil {
+		return 0, err
+	}
+
+	if len(result.Batch.AggregationResults) == 0 {
+		return 0, nil
+	}
+	total, ok := result.Batch.AggregationResults[0].AggregateProperties["total"]
+	if !ok || total.IntegerValue == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(*total.IntegerValue, 10, 64)
+}
+
+func (s *DatastoreStatusStore) call(method string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint+"projects/"+s.project+method, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.useAuth {
+		token, err := secrets.AccessToken()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%w: %s", errTransactionConflict, string(respBody))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("datastore %s returned status %d: %s", method, resp.StatusCode, string(respBody))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,400 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"autopilot/models"
+)
+
+const (
+	testProject = "test-project"
+	// fakeDatastoreBatchSize は1回の runQuery で返す件数（ページングの確認用）
+	fakeDatastoreBatchSize = 2
+)
+
+// fakeDatastore はDatastoreのREST APIのうち、StatusStoreが使うメソッドだけを実装したエミュレーターの代わりです
+type fakeDatastore struct {
+	mu        sync.Mutex
+	entities  map[string]datastoreEntity
+	txSeq     int
+	conflicts int // この回数だけ commit を409で失敗させる
+	calls     []string
+}
+
+type fakeDatastoreFilter struct {
+	CompositeFilter *struct {
+		Filters []fakeDatastoreFilter `json:"filters"`
+	} `json:"compositeFilter"`
+	PropertyFilter *struct {
+		Property struct {
+			Name string `json:"name"`
+		} `json:"property"`
+		Op    string         `json:"op"`
+		Value datastoreValue `json:"value"`
+	} `json:"propertyFilter"`
+}
+
+type fakeDatastoreQuery struct {
+	Filter      *fakeDatastoreFilter `json:"filter"`
+	Limit       int                  `json:"limit"`
+	StartCursor string               `json:"startCursor"`
+}
+
+func newFakeDatastore(t *testing.T) (*fakeDatastore, *DatastoreStatusStore) {
+	t.Helper()
+	f := &fakeDatastore{entities: map[string]datastoreEntity{}}
+	server := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(server.Close)
+	t.Setenv("GOOGLE_CLOUD_PROJECT", testProject)
+	t.Setenv("DATASTORE_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	store, err := NewDatastoreStatusStore("", "autopilot-test")
+	if err != nil {
+		t.Fatalf("NewDatastoreStatusStore: %v", err)
+	}
+	return f, store
+}
+
+func (f *fakeDatastore) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prefix := "/v1/projects/" + testProject + ":"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, prefix)
+	f.calls = append(f.calls, method)
+
+	var body struct {
+		Keys      []datastoreKey `json:"keys"`
+		Mutations []struct {
+			Upsert datastoreEntity `json:"upsert"`
+		} `json:"mutations"`
+		Query            fakeDatastoreQuery `json:"query"`
+		AggregationQuery struct {
+			NestedQuery fakeDatastoreQuery `json:"nestedQuery"`
+		} `json:"aggregationQuery"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch method {
+	case "beginTransaction":
+		f.txSeq++
+		json.NewEncoder(w).Encode(map[string]string{"transaction": "tx-" + strconv.Itoa(f.txSeq)})
+	case "rollback":
+		w.Write([]byte(`{}`))
+	case "lookup":
+		found := []map[string]datastoreEntity{}
+		for _, key := range body.Keys {
+			if entity, ok := f.entities[key.Path[len(key.Path)-1].Name]; ok {
+				found = append(found, map[string]datastoreEntity{"entity": entity})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"found": found})
+	case "commit":
+		if f.conflicts > 0 {
+			f.conflicts--
+			http.Error(w, `{"error":{"status":"ABORTED"}}`, http.StatusConflict)
+			return
+		}
+		for _, mutation := range body.Mutations {
+			path := mutation.Upsert.Key.Path
+			f.entities[path[len(path)-1].Name] = mutation.Upsert
+		}
+		w.Write([]byte(`{}`))
+	case "runQuery":
+		matched := f.match(body.Query.Filter)
+		offset, _ := strconv.Atoi(body.Query.StartCursor)
+		end := offset + fakeDatastoreBatchSize
+		if body.Query.Limit > 0 && offset+body.Query.Limit < end {
+			end = offset + body.Query.Limit
+		}
+		if end > len(matched) {
+			end = len(matched)
+		}
+		results := []map[string]datastoreEntity{}
+		for _, entity := range matched[offset:end] {
+			results = append(results, map[string]datastoreEntity{"entity": entity})
+		}
+		more := "NO_MORE_RESULTS"
+		if end < len(matched) {
+			more = "NOT_FINISHED"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"batch": map[string]interface{}{
+			"entityResults": results,
+			"endCursor":     strconv.Itoa(end),
+			"moreResults":   more,
+		}})
+	case "runAggregationQuery":
+		count := strconv.Itoa(len(f.match(body.AggregationQuery.NestedQuery.Filter)))
+		json.NewEncoder(w).Encode(map[string]interface{}{"batch": map[string]interface{}{
+			"aggregationResults": []map[string]interface{}{
+				{"aggregateProperties": map[string]datastoreValue{"total": {IntegerValue: &count}}},
+			},
+		}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// match は条件に一致するエンティティを作成日時の昇順で返します
+func (f *fakeDatastore) match(filter *fakeDatastoreFilter) []datastoreEntity {
+	var matched []datastoreEntity
+	for _, entity := range f.entities {
+		if matchesFilter(entity, filter) {
+			matched = append(matched, entity)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Properties["created_at"].TimestampValue.Before(*matched[j].Properties["created_at"].TimestampValue)
+	})
+	return matched
+}
+
+func matchesFilter(entity datastoreEntity, filter *fakeDatastoreFilter) bool {
+	switch {
+	case filter == nil:
+		return true
+	case filter.CompositeFilter != nil:
+		for i := range filter.CompositeFilter.Filters {
+			if !matchesFilter(entity, &filter.CompositeFilter.Filters[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	pf := filter.PropertyFilter
+	value := entity.Properties[pf.Property.Name]
+	switch pf.Op {
+	case "EQUAL":
+		return *value.StringValue == *pf.Value.StringValue
+	case "IN":
+		for _, candidate := range pf.Value.ArrayValue.Values {
+			if *value.StringValue == *candidate.StringValue {
+				return true
+			}
+		}
+		return false
+	case "GREATER_THAN_OR_EQUAL":
+		return !value.TimestampValue.Before(*pf.Value.TimestampValue)
+	case "LESS_THAN":
+		return value.TimestampValue.Before(*pf.Value.TimestampValue)
+	}
+	return false
+}
+
+func (f *fakeDatastore) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func TestDatastoreStatusStoreRoundTrip(t *testing.T) {
+	_, store := newFakeDatastore(t)
+
+	// 見つからない場合はハンドラーが判定できるよう "not found" を含むエラーを返す
+	if _, err := store.GetProcessingStatus("msg-1"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("GetProcessingStatus(missing) error = %v, want not found", err)
+	}
+
+	completedAt := time.Now().Add(-time.Minute).UTC().Truncate(time.Millisecond)
+	status := &models.ProcessingStatus{
+		MessageID:      "<msg-1@example.com>",
+		Status:         models.StatusComplete,
+		TaskID:         "task-1",
+		CompletedAt:    &completedAt,
+		Error:          strings.Repeat("e", 2000),
+		IncidentID:     42,
+		CallbackURL:    "https://hooks.example.com/done",
+		PartialOutputs: map[string]string{"judgment": "要対応"},
+	}
+	if err := store.UpdateProcessingStatus(status); err != nil {
+		t.Fatalf("UpdateProcessingStatus: %v", err)
+	}
+
+	got, err := store.GetProcessingStatus("<msg-1@example.com>")
+	if err != nil {
+		t.Fatalf("GetProcessingStatus: %v", err)
+	}
+	if got.MessageID != status.MessageID || got.Status != status.Status || got.TaskID != "task-1" ||
+		got.Error != status.Error || got.IncidentID != 42 || got.CallbackURL != status.CallbackURL ||
+		got.PartialOutputs["judgment"] != "要対応" {
+		t.Errorf("unexpected status: %+v", got)
+	}
+	if got.CompletedAt == nil || !got.CompletedAt.Equal(completedAt) {
+		t.Errorf("CompletedAt = %v, want %v", got.CompletedAt, completedAt)
+	}
+	if got.CreatedAt.IsZero() || got.UpdatedAt.IsZero() {
+		t.Errorf("CreatedAt = %v, UpdatedAt = %v, want both set", got.CreatedAt, got.UpdatedAt)
+	}
+}
+
+func TestDatastoreStatusStoreKeepsExistingFields(t *testing.T) {
+	fake, store := newFakeDatastore(t)
+
+	first := models.NewProcessingStatus("msg-1")
+	first.CallbackURL = "https://hooks.example.com/done"
+	if err := store.UpdateProcessingStatus(first); err != nil {
+		t.Fatalf("UpdateProcessingStatus: %v", err)
+	}
+	created, _ := store.GetProcessingStatus("msg-1")
+
+	// 後からの更新で指定しなかった作成日時・インシデントID・コールバックURLは引き継ぐ
+	completed := &models.ProcessingStatus{MessageID: "msg-1", IncidentID: 7}
+	completed.SetComplete()
+	if err := store.UpdateProcessingStatus(completed); err != nil {
+		t.Fatalf("UpdateProcessingStatus: %v", err)
+	}
+	final := &models.ProcessingStatus{MessageID: "msg-1", Status: models.StatusComplete}
+	fake.takeCalls()
+	if err := store.UpdateProcessingStatus(final); err != nil {
+		t.Fatalf("UpdateProcessingStatus: %v", err)
+	}
+	if calls := fake.takeCalls(); strings.Join(calls, ",") != "beginTransaction,lookup,commit" {
+		t.Errorf("calls = %v, want a single transaction", calls)
+	}
+
+	got, _ := store.GetProcessingStatus("msg-1")
+	if !got.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", got.CreatedAt, created.CreatedAt)
+	}
+	if got.IncidentID != 7 || got.CallbackURL != first.CallbackURL {
+		t.Errorf("IncidentID = %d, CallbackURL = %q, want them kept", got.IncidentID, got.CallbackURL)
+	}
+	if got.UpdatedAt.Before(created.UpdatedAt) {
+		t.Errorf("UpdatedAt = %v went back from %v", got.UpdatedAt, created.UpdatedAt)
+	}
+}
+
+func TestDatastoreStatusStoreTransition(t *testing.T) {
+	fake, store := newFakeDatastore(t)
+
+	held := &models.ProcessingStatus{MessageID: "msg-1", Status: models.StatusHeld}
+	if err := store.UpdateProcessingStatus(held); err != nil {
+		t.Fatalf("UpdateProcessingStatus: %v", err)
+	}
+
+	running := &models.ProcessingStatus{MessageID: "msg-1", Status: models.StatusRunning}
+	if err := store.TransitionProcessingStatus(models.StatusHeld, running); err != nil {
+		t.Fatalf("TransitionProcessingStatus: %v", err)
+	}
+
+	// 既に状態が変わっている場合は保存せず、トランザクションをロールバックする
+	fake.takeCalls()
+	rejected := &models.ProcessingStatus{MessageID: "msg-1", Status: models.StatusRejected}
+	if err := store.TransitionProcessingStatus(models.StatusHeld, rejected); !errors.Is(err, ErrStatusConflict) {
+		t.Errorf("TransitionProcessingStatus = %v, want ErrStatusConflict", err)
+	}
+	if calls := fake.takeCalls(); strings.Join(calls, ",") != "beginTransaction,lookup,rollback" {
+		t.Errorf("calls = %v, want a rolled back transaction", calls)
+	}
+	if got, _ := store.GetProcessingStatus("msg-1"); got.Status != models.StatusRunning {
+		t.Errorf("status = %s, want %s", got.Status, models.StatusRunning)
+	}
+
+	missing := &models.ProcessingStatus{MessageID: "missing", Status: models.StatusRunning}
+	if err := store.TransitionProcessingStatus(models.StatusHeld, missing); err == nil || errors.Is(err, ErrStatusConflict) {
+		t.Errorf("TransitionProcessingStatus(missing) = %v, want a not found error", err)
+	}
+}
+
+func TestDatastoreStatusStoreRetriesConflicts(t *testing.T) {
+	fake, store := newFakeDatastore(t)
+
+	// 他の更新との競合は maxCommitAttempts 回まで再試行する
+	fake.conflicts = maxCommitAttempts - 1
+	if err := store.UpdateProcessingStatus(models.NewProcessingStatus("msg-1")); err != nil {
+		t.Fatalf("UpdateProcessingStatus with conflicts: %v", err)
+	}
+
+	fake.conflicts = maxCommitAttempts
+	if err := store.UpdateProcessingStatus(models.NewProcessingStatus("msg-2")); err == nil {
+		t.Error("UpdateProcessingStatus succeeded after every commit conflicted")
+	}
+	if _, err := store.GetProcessingStatus("msg-2"); err == nil {
+		t.Error("status saved despite the conflicts")
+	}
+}
+
+func TestDatastoreStatusStoreSummarize(t *testing.T) {
+	_, store := newFakeDatastore(t)
+
+	base := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	seed := []struct {
+		messageID string
+		status    models.ProcessStatus
+	}{
+		{"msg-1", models.StatusPending},
+		{"msg-2", models.StatusFailed},
+		{"msg-3", models.StatusPending},
+		{"msg-4", models.StatusComplete},
+		{"msg-5", models.StatusPending},
+	}
+	for i, s := range seed {
+		status := &models.ProcessingStatus{MessageID: s.messageID, Status: s.status, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := store.UpdateProcessingStatus(status); err != nil {
+			t.Fatalf("UpdateProcessingStatus: %v", err)
+		}
+	}
+
+	list, err := store.SummarizeProcessingStatuses(models.StatusFilter{})
+	if err != nil {
+		t.Fatalf("SummarizeProcessingStatuses: %v", err)
+	}
+	if list.Total != 5 || list.ByStatus[models.StatusPending] != 3 || list.ByStatus[models.StatusFailed] != 1 || list.ByStatus[models.StatusComplete] != 1 {
+		t.Errorf("Total = %d, ByStatus = %v", list.Total, list.ByStatus)
+	}
+	if ids := messageIDs(list.Data); ids != "msg-1,msg-2,msg-3,msg-4,msg-5" {
+		t.Errorf("data = %s, want all statuses in created order across pages", ids)
+	}
+
+	// 件数は上限に関係なく数え、一覧は上限まで返す
+	pending, err := store.SummarizeProcessingStatuses(models.StatusFilter{Statuses: []models.ProcessStatus{models.StatusPending}, Limit: 2})
+	if err != nil {
+		t.Fatalf("SummarizeProcessingStatuses: %v", err)
+	}
+	if pending.Total != 3 || pending.Count != 2 || messageIDs(pending.Data) != "msg-1,msg-3" {
+		t.Errorf("Total = %d, data = %s", pending.Total, messageIDs(pending.Data))
+	}
+
+	ranged, err := store.SearchProcessingStatuses(models.StatusFilter{
+		Statuses: []models.ProcessStatus{models.StatusPending, models.StatusFailed},
+		From:     base.Add(time.Minute),
+		To:       base.Add(4 * time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("SearchProcessingStatuses: %v", err)
+	}
+	if ids := messageIDs(ranged); ids != "msg-2,msg-3" {
+		t.Errorf("data = %s, want msg-2,msg-3", ids)
+	}
+
+	if _, err := store.SummarizeProcessingStatuses(models.StatusFilter{Sender: "monitor@example.com"}); !errors.Is(err, ErrUnsupportedFilter) {
+		t.Errorf("sender filter error = %v, want ErrUnsupportedFilter", err)
+	}
+}
+
+func messageIDs(statuses []models.ProcessingStatus) string {
+	ids := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		ids = append(ids, status.MessageID)
+	}
+	return strings.Join(ids, ",")
+}
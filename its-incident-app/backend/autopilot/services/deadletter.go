@@ -0,0 +1,147 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+// SaveDeadLetter は再試行をすべて失敗したメッセージを、メールデータとエラーとともにデッドレターとして保存します
+func (s *DBPilotService) SaveDeadLetter(messageID string, emailData *models.EmailData, cause error) error {
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("operation", "SaveDeadLetter"),
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"payload": emailData,
+		"error":   cause.Error(),
+	})
+	if err != nil {
+		logger.Logger.Error("デッドレターのJSONエンコードに失敗しました",
+			append(logFields, zap.Error(err))...)
+		return fmt.Errorf("failed to marshal dead letter: %v", err)
+	}
+
+	req, err := s.createRequest("PUT", "/dead-letters/"+url.PathEscape(messageID), jsonData)
+	if err != nil {
+		logger.Logger.Error("リクエストの作成に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Logger.Error("デッドレターの保存に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return fmt.Errorf("failed to save dead letter: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		logger.Logger.Error("デッドレターの保存でエラーが発生しました",
+			append(logFields,
+				zap.Int("status_code", resp.StatusCode),
+				zap.String("response_body", string(respBody)))...)
+		return fmt.Errorf("failed to save dead letter, status: %d, response: %s",
+			resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// GetDeadLetter はメッセージIDのデッドレターを取得します
+func (s *DBPilotService) GetDeadLetter(messageID string) (*models.DeadLetter, error) {
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("operation", "GetDeadLetter"),
+	}
+
+	req, err := s.createRequest("GET", "/dead-letters/"+url.PathEscape(messageID), nil)
+	if err != nil {
+		logger.Logger.Error("リクエストの作成に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Logger.Error("デッドレターの取得に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to get dead letter: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("dead letter not found for message_id: %s", messageID)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		logger.Logger.Error("デッドレターの取得でエラーが発生しました",
+			append(logFields,
+				zap.Int("status_code", resp.StatusCode),
+				zap.String("response_body", string(respBody)))...)
+		return nil, fmt.Errorf("failed to get dead letter, status: %d, response: %s",
+			resp.StatusCode, string(respBody))
+	}
+
+	var deadLetter models.DeadLetter
+	if err := json.NewDecoder(resp.Body).Decode(&deadLetter); err != nil {
+		logger.Logger.Error("レスポンスのデコードに失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to decode dead letter: %v", err)
+	}
+
+	return &deadLetter, nil
+}
+
+// MarkDeadLetterReprocessed はデッドレターの再処理回数を加算し、加算後の回数を返します
+func (s *DBPilotService) MarkDeadLetterReprocessed(messageID string) (int, error) {
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("operation", "MarkDeadLetterReprocessed"),
+	}
+
+	req, err := s.createRequest("POST", "/dead-letters/"+url.PathEscape(messageID)+"/reprocessed", nil)
+	if err != nil {
+		logger.Logger.Error("リクエストの作成に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Logger.Error("デッドレターの再処理の記録に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return 0, fmt.Errorf("failed to mark dead letter reprocessed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		logger.Logger.Error("デッドレターの再処理の記録でエラーが発生しました",
+			append(logFields,
+				zap.Int("status_code", resp.StatusCode),
+				zap.String("response_body", string(respBody)))...)
+		return 0, fmt.Errorf("failed to mark dead letter reprocessed, status: %d, response: %s",
+			resp.StatusCode, string(respBody))
+	}
+
+	var result struct {
+		ReprocessCount int `json:"reprocess_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return result.ReprocessCount, nil
+}
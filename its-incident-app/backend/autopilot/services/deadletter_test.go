@@ -0,0 +1,99 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"autopilot/models"
+)
+
+const testServiceToken = "test-service-token"
+
+// newTestDBPilotService は handler をdbpilotとして使うDBPilotServiceを作成します
+func newTestDBPilotService(t *testing.T, handler http.HandlerFunc) *DBPilotService {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewDBPilotService(server.URL, testServiceToken)
+}
+
+func TestSaveDeadLetter(t *testing.T) {
+	var path, authorization string
+	var body struct {
+		Payload models.EmailData `json:"payload"`
+		Error   string           `json:"error"`
+	}
+	s := newTestDBPilotService(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.Method + " " + r.URL.EscapedPath()
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+	})
+
+	emailData := &models.EmailData{From: "monitor@example.com", Subject: "disk full"}
+	if err := s.SaveDeadLetter("<msg/1@example.com>", emailData, errors.New("ai endpoint unavailable")); err != nil {
+		t.Fatalf("SaveDeadLetter: %v", err)
+	}
+	if path != "PUT /dead-letters/%3Cmsg%2F1@example.com%3E" {
+		t.Errorf("request = %q, want the message ID escaped in the path", path)
+	}
+	if authorization != "Bearer "+testServiceToken {
+		t.Errorf("Authorization = %q", authorization)
+	}
+	if body.Error != "ai endpoint unavailable" || body.Payload.Subject != "disk full" {
+		t.Errorf("unexpected dead letter: %+v", body)
+	}
+}
+
+func TestSaveDeadLetterReturnsError(t *testing.T) {
+	s := newTestDBPilotService(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database unavailable", http.StatusInternalServerError)
+	})
+
+	err := s.SaveDeadLetter("msg-1", &models.EmailData{}, errors.New("ai endpoint unavailable"))
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("SaveDeadLetter error = %v, want the status code", err)
+	}
+}
+
+func TestGetDeadLetter(t *testing.T) {
+	s := newTestDBPilotService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dead-letters/msg-1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"message_id":"msg-1","payload":"{}","error":"ai endpoint unavailable","attempts":3,"reprocess_count":1}`))
+	})
+
+	deadLetter, err := s.GetDeadLetter("msg-1")
+	if err != nil {
+		t.Fatalf("GetDeadLetter: %v", err)
+	}
+	if deadLetter.Attempts != 3 || deadLetter.ReprocessCount != 1 || deadLetter.IsResolved() {
+		t.Errorf("unexpected dead letter: %+v", deadLetter)
+	}
+
+	// 見つからない場合はハンドラーが404と判定できるよう "not found" を含むエラーを返す
+	if _, err := s.GetDeadLetter("missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("GetDeadLetter(missing) error = %v, want not found", err)
+	}
+}
+
+func TestMarkDeadLetterReprocessed(t *testing.T) {
+	var path string
+	s := newTestDBPilotService(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.Method + " " + r.URL.Path
+		w.Write([]byte(`{"reprocess_count":2}`))
+	})
+
+	count, err := s.MarkDeadLetterReprocessed("msg-1")
+	if err != nil {
+		t.Fatalf("MarkDeadLetterReprocessed: %v", err)
+	}
+	if path != "POST /dead-letters/msg-1/reprocessed" || count != 2 {
+		t.Errorf("request = %q, count = %d", path, count)
+	}
+}
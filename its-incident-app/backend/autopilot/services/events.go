@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/metrics"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// eventFlushInterval はステップのイベントをdbpilotにまとめて送る間隔
+	eventFlushInterval = time.Second
+	// maxEventBatch は1回に送るイベント数の上限（dbpilotの上限以下）
+	maxEventBatch = 200
+)
+
+// messageEventsDropped は送信待ちがあふれた、または保存に失敗して捨てたイベント数
+var messageEventsDropped = metrics.NewCounterVec("autopilot_message_events_dropped_total",
+	"Message step events that were dropped before reaching dbpilot.", "reason")
+
+// EventRecorder はメッセージの処理のステップをバッファし、バックグラウンドでdbpilotにまとめて保存します。
+// 記録は処理をブロックせず、バッファがあふれた場合はイベントを捨てます。nil の場合は何も記録しません
+type EventRecorder struct {
+	dbpilot DBPilot
+	events  chan models.MessageEvent
+	done    chan struct{}
+}
+
+// NewEventRecorder は bufferSize 件まで送信待ちを保持するEventRecorderを作成します
+func NewEventRecorder(dbpilot DBPilot, bufferSize int) *EventRecorder {
+	return &EventRecorder{
+		dbpilot: dbpilot,
+		events:  make(chan models.MessageEvent, bufferSize),
+		done:    make(chan struct{}),
+	}
+}
+
+// Record はメッセージのステップを記録します。attrs は key, value の組です
+func (r *EventRecorder) Record(messageID string, step models.MessageStep, attrs ...string) {
+	if r == nil {
+		return
+	}
+
+	event := models.MessageEvent{MessageID: messageID, Step: step, OccurredAt: time.Now()}
+	if len(attrs) > 1 {
+		event.Attributes = make(map[string]string, len(attrs)/2)
+		for i := 0; i+1 < len(attrs); i += 2 {
+			event.Attributes[attrs[i]] = attrs[i+1]
+		}
+	}
+
+	select {
+	case r.events <- event:
+	default:
+		messageEventsDropped.Inc("buffer_full")
+	}
+}
+
+// Start はイベントの送信をバックグラウンドで開始します。ctx がキャンセルされると残りを送って終了します
+func (r *EventRecorder) Start(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	go r.run(ctx)
+}
+
+// Wait は Start のゴルーチンが残りのイベントを送り終えるか、ctx が期限に達するまで待ちます
+func (r *EventRecorder) Wait(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		logger.Logger.Warn("ステップのイベントの送信が期限までに終わりませんでした",
+			zap.Int("pending", len(r.events)))
+	}
+}
+
+func (r *EventRecorder) run(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(eventFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.MessageEvent, 0, maxEventBatch)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := r.dbpilot.SaveMessageEvents(batch); err != nil {
+			messageEventsDropped.Add(float64(len(batch)), "save_failed")
+			logger.Logger.Warn("ステップのイベントの保存に失敗しました",
+				zap.Int("events", len(batch)), zap.Error(err))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case event := <-r.events:
+			batch = append(batch, event)
+			if len(batch) >= maxEventBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-r.events:
+					batch = append(batch, event)
+					if len(batch) >= maxEventBatch {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// SaveMessageEvents はメッセージの処理のステップをまとめてdbpilotに保存します
+func (s *DBPilotService) SaveMessageEvents(events []models.MessageEvent) error {
+	logFields := []zap.Field{
+		zap.String("operation", "SaveMessageEvents"),
+		zap.Int("events", len(events)),
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		return fmt.Errorf("failed to marshal message events: %v", err)
+	}
+
+	req, err := s.createRequest("POST", "/message-events", jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to save message events: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		logger.Logger.Debug("ステップのイベントの保存でエラーが発生しました",
+			append(logFields,
+				zap.Int("status_code", resp.StatusCode),
+				zap.String("response_body", string(respBody)))...)
+		return fmt.Errorf("failed to save message events, status: %d, response: %s",
+			resp.StatusCode, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"autopilot/models"
+)
+
+func TestEventRecorderFlushesOnShutdown(t *testing.T) {
+	var mu sync.Mutex
+	var saved []models.MessageEvent
+	s := newTestDBPilotService(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Events []models.MessageEvent `json:"events"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		saved = append(saved, body.Events...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	recorder := NewEventRecorder(s, 10)
+	recorder.Record("msg-1", models.StepReceived, "from", "monitor@example.com")
+	recorder.Record("msg-1", models.StepAIRetry, "provider", "primary", "attempt")
+	ctx, cancel := context.WithCancel(context.Background())
+	recorder.Start(ctx)
+
+	// 停止時は送信待ちのイベントを送ってから終了する
+	cancel()
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	recorder.Wait(waitCtx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(saved) != 2 {
+		t.Fatalf("saved %d events, want 2: %+v", len(saved), saved)
+	}
+	if saved[0].MessageID != "msg-1" || saved[0].Step != models.StepReceived || saved[0].Attributes["from"] != "monitor@example.com" {
+		t.Errorf("unexpected event: %+v", saved[0])
+	}
+	// 値のないキーは捨てる
+	if len(saved[1].Attributes) != 1 || saved[1].Attributes["provider"] != "primary" {
+		t.Errorf("attributes = %v, want provider only", saved[1].Attributes)
+	}
+}
+
+func TestEventRecorderDropsWhenBufferFull(t *testing.T) {
+	calls := 0
+	s := newTestDBPilotService(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "database unavailable", http.StatusInternalServerError)
+	})
+
+	// 記録は処理をブロックせず、バッファがあふれたイベントは捨てる
+	recorder := NewEventRecorder(s, 1)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			recorder.Record("msg-1", models.StepReceived)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Record blocked on a full buffer")
+	}
+
+	// 保存に失敗しても停止できる
+	ctx, cancel := context.WithCancel(context.Background())
+	recorder.Start(ctx)
+	cancel()
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	recorder.Wait(waitCtx)
+	if calls != 1 {
+		t.Errorf("dbpilot called %d times, want 1", calls)
+	}
+
+	var missing *EventRecorder
+	missing.Record("msg-1", models.StepReceived)
+	missing.Start(context.Background())
+	missing.Wait(context.Background())
+}
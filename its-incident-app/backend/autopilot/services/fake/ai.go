@@ -0,0 +1,121 @@
+package fake
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"autopilot/models"
+	"autopilot/services"
+)
+
+// AI は services.AIClassifier のインメモリ実装です。
+// Errors に設定したエラーを呼び出し順に返し、使い切った後は Response を返します（再試行の確認用）
+type AI struct {
+	// Version は WorkflowVersion が返すワークフローのバージョン
+	Version string
+	// Response は成功時に返すAI応答。nil の場合は判定 "fake" の応答を返します
+	Response *models.AIResponse
+	// Errors は先頭から順に1回ずつ返すエラー
+	Errors []error
+	// Partial はストリーミングの途中結果として onPartial に渡す値
+	Partial map[string]string
+	// Delay は応答までの待ち時間（タイムアウトの確認用）。ctx がキャンセルされると ctx のエラーを返します
+	Delay time.Duration
+	// Shadow が true の場合はすべてのメッセージをシャドウ評価の対象にします
+	Shadow bool
+	// ShadowResponse はシャドウ評価で返すAI応答。nil の場合は Response と同じ応答を返します
+	ShadowResponse *models.AIResponse
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+var _ services.AIClassifier = (*AI)(nil)
+
+// Calls はメッセージについて ProcessEmail が呼ばれた回数を返します
+func (a *AI) Calls(messageID string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.calls[messageID]
+}
+
+func (a *AI) WorkflowVersion(messageID, language string) string {
+	return a.Version
+}
+
+func (a *AI) ProcessEmail(ctx context.Context, messageID string, emailData *models.EmailData) (*models.AIResponse, error) {
+	return a.ProcessEmailStream(ctx, messageID, emailData, nil)
+}
+
+// ProcessEmailStream は Partial を設定した場合、応答の前に onPartial に渡します
+func (a *AI) ProcessEmailStream(ctx context.Context, messageID string, emailData *models.EmailData, onPartial services.PartialFunc) (*models.AIResponse, error) {
+	a.mu.Lock()
+	if a.calls == nil {
+		a.calls = make(map[string]int)
+	}
+	a.calls[messageID]++
+	var injected error
+	if len(a.Errors) > 0 {
+		injected, a.Errors = a.Errors[0], a.Errors[1:]
+	}
+	a.mu.Unlock()
+
+	if err := a.wait(ctx); err != nil {
+		return nil, err
+	}
+	if len(a.Partial) > 0 && onPartial != nil {
+		copied := make(map[string]string, len(a.Partial))
+		for key, value := range a.Partial {
+			copied[key] = value
+		}
+		onPartial(copied)
+	}
+	if injected != nil {
+		return nil, injected
+	}
+	return a.response(a.Response, emailData), nil
+}
+
+func (a *AI) ShadowSampled(messageID string) bool {
+	return a.Shadow
+}
+
+func (a *AI) ProcessShadow(ctx context.Context, messageID string, emailData *models.EmailData) (*models.AIResponse, error) {
+	if err := a.wait(ctx); err != nil {
+		return nil, err
+	}
+	if a.ShadowResponse != nil {
+		return a.response(a.ShadowResponse, emailData), nil
+	}
+	return a.response(a.Response, emailData), nil
+}
+
+func (a *AI) wait(ctx context.Context) error {
+	if a.Delay <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(a.Delay):
+		return nil
+	}
+}
+
+// response は呼び出しごとに独立したAI応答を返します（ハンドラーが応答を書き換えても他の呼び出しに影響しない）
+func (a *AI) response(base *models.AIResponse, emailData *models.EmailData) *models.AIResponse {
+	var response models.AIResponse
+	if base != nil {
+		response = *base
+	} else {
+		response.Data.Status = "succeeded"
+		response.Data.Outputs.Judgment = "fake"
+		response.Data.Outputs.Subject = emailData.Subject
+		response.Data.Outputs.From = emailData.From
+	}
+	response.Provider = "fake"
+	response.WorkflowVersion = a.Version
+	response.Language = services.DetectLanguage(emailData)
+	return &response
+}
@@ -0,0 +1,450 @@
+// Package fake はハンドラーの単体テスト用に、dbpilotとAIのインメモリ実装を提供します。
+// 本番のAIエンドポイントやdbpilotなしで、再試行・エラーインシデント・処理状態の遷移を確認できます
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"autopilot/models"
+	"autopilot/services"
+)
+
+// DBPilot は services.DBPilot と services.StatusStore のインメモリ実装です（NewEmailHandler の両方に渡します）。
+// 見つからない場合のエラーは DBPilotService と同じく "not found" を含むメッセージを返します
+type DBPilot struct {
+	mu sync.Mutex
+
+	statuses    map[string]*models.ProcessingStatus
+	updatedAt   map[string]time.Time
+	history     map[string][]models.ProcessStatus
+	emails      map[string]*models.EmailData
+	incidents   map[string][]*models.AIResponse
+	incidentSeq uint
+	deadLetters map[string]*models.DeadLetter
+	shadows     []*models.ShadowResultPayload
+	cached      map[string]cachedResponse
+	usage       *models.CostUsageReport
+	events      map[string][]models.MessageStep
+	failures    map[string]error
+}
+
+type cachedResponse struct {
+	response  *models.AIResponse
+	messageID string
+}
+
+var (
+	_ services.DBPilot     = (*DBPilot)(nil)
+	_ services.StatusStore = (*DBPilot)(nil)
+)
+
+// NewDBPilot は空のDBPilotを作成します
+func NewDBPilot() *DBPilot {
+	return &DBPilot{
+		statuses:    make(map[string]*models.ProcessingStatus),
+		updatedAt:   make(map[string]time.Time),
+		history:     make(map[string][]models.ProcessStatus),
+		emails:      make(map[string]*models.EmailData),
+		incidents:   make(map[string][]*models.AIResponse),
+		deadLetters: make(map[string]*models.DeadLetter),
+		cached:      make(map[string]cachedResponse),
+		usage:       &models.CostUsageReport{},
+		events:      make(map[string][]models.MessageStep),
+	}
+}
+
+// FailOn は method（例: "SaveIncident"）の呼び出しで err を返すようにします。err が nil の場合は解除します
+func (d *DBPilot) FailOn(method string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.failures == nil {
+		d.failures = make(map[string]error)
+	}
+	if err == nil {
+		delete(d.failures, method)
+		return
+	}
+	d.failures[method] = err
+}
+
+func (d *DBPilot) fail(method string) error {
+	return d.failures[method]
+}
+
+// SetCachedResponse は FindCachedResponse が返す結果を登録します
+func (d *DBPilot) SetCachedResponse(contentHash, workflowVersion, messageID string, response *models.AIResponse) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cached[contentHash+"\n"+workflowVersion] = cachedResponse{response: response, messageID: messageID}
+}
+
+// SetCostUsage は GetCostUsage が返す利用量を設定します
+func (d *DBPilot) SetCostUsage(report *models.CostUsageReport) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.usage = report
+}
+
+// StatusHistory はメッセージの処理状態の遷移を更新順に返します
+func (d *DBPilot) StatusHistory(messageID string) []models.ProcessStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]models.ProcessStatus(nil), d.history[messageID]...)
+}
+
+// Incidents はメッセージについて保存されたAI応答（エラーのインシデントを含む）を保存順に返します
+func (d *DBPilot) Incidents(messageID string) []*models.AIResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*models.AIResponse(nil), d.incidents[messageID]...)
+}
+
+// ShadowResults は保存されたシャドウ評価の結果を返します
+func (d *DBPilot) ShadowResults() []*models.ShadowResultPayload {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*models.ShadowResultPayload(nil), d.shadows...)
+}
+
+func (d *DBPilot) SaveEmail(emailData *models.EmailData, messageID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("SaveEmail"); err != nil {
+		return err
+	}
+	copied := *emailData
+	d.emails[messageID] = &copied
+	return nil
+}
+
+func (d *DBPilot) GetEmail(messageID string) (*models.EmailData, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("GetEmail"); err != nil {
+		return nil, err
+	}
+	emailData, ok := d.emails[messageID]
+	if !ok {
+		return nil, fmt.Errorf("email not found for message_id: %s", messageID)
+	}
+	copied := *emailData
+	return &copied, nil
+}
+
+func (d *DBPilot) SaveIncident(aiResponse *models.AIResponse, messageID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("SaveIncident"); err != nil {
+		return err
+	}
+	d.incidents[messageID] = append(d.incidents[messageID], aiResponse)
+	d.incidentSeq++
+	if status, ok := d.statuses[messageID]; ok {
+		status.IncidentID = d.incidentSeq
+	}
+	return nil
+}
+
+// ThreadEmail は In-Reply-To・References に一致する OriginalMessageID のメールを探し、そのインシデントを返します（件名での判定は行いません）
+func (d *DBPilot) ThreadEmail(messageID string, subjectWindow time.Duration) (*models.ThreadMatch, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("ThreadEmail"); err != nil {
+		return nil, err
+	}
+	emailData, ok := d.emails[messageID]
+	if !ok {
+		return nil, fmt.Errorf("email not found for message_id: %s", messageID)
+	}
+	for _, ref := range append(strings.Fields(emailData.InReplyTo), strings.Fields(emailData.References)...) {
+		for parentID, parent := range d.emails {
+			if parentID == messageID || strings.Trim(parent.OriginalMessageID, "<>") != strings.Trim(ref, "<>") {
+				continue
+			}
+			if status, ok := d.statuses[parentID]; ok && status.IncidentID != 0 {
+				return &models.ThreadMatch{IncidentID: status.IncidentID, MatchedBy: "in_reply_to"}, nil
+			}
+		}
+	}
+	return &models.ThreadMatch{}, nil
+}
+
+func (d *DBPilot) GetProcessingStatus(messageID string) (*models.ProcessingStatus, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("GetProcessingStatus"); err != nil {
+		return nil, err
+	}
+	status, ok := d.statuses[messageID]
+	if !ok {
+		return nil, fmt.Errorf("processing status not found for message_id: %s", messageID)
+	}
+	copied := *status
+	copied.UpdatedAt = d.updatedAt[messageID]
+	return &copied, nil
+}
+
+// UpdateProcessingStatus はdbpilotと同じく、インシデントIDとコールバックURLは空でない場合だけ更新します
+func (d *DBPilot) UpdateProcessingStatus(status *models.ProcessingStatus) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("UpdateProcessingStatus"); err != nil {
+		return err
+	}
+
+	d.save(status)
+	return nil
+}
+
+// TransitionProcessingStatus は現在の状態が from の場合だけ UpdateProcessingStatus と同じく保存します
+func (d *DBPilot) TransitionProcessingStatus(from models.ProcessStatus, status *models.ProcessingStatus) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("TransitionProcessingStatus"); err != nil {
+		return err
+	}
+	existing, ok := d.statuses[status.MessageID]
+	if !ok {
+		return fmt.Errorf("processing status not found for message_id: %s", status.MessageID)
+	}
+	if existing.Status != from {
+		return services.ErrStatusConflict
+	}
+	d.save(status)
+	return nil
+}
+
+// save は処理状態を保存します（d.mu を取得して呼び出します）
+func (d *DBPilot) save(status *models.ProcessingStatus) {
+	updated := *status
+	if existing, ok := d.statuses[status.MessageID]; ok {
+		updated.CreatedAt = existing.CreatedAt
+		if updated.IncidentID == 0 {
+			updated.IncidentID = existing.IncidentID
+		}
+		if updated.CallbackURL == "" {
+			updated.CallbackURL = existing.CallbackURL
+		}
+	} else if updated.CreatedAt.IsZero() {
+		updated.CreatedAt = time.Now()
+	}
+	if updated.Status == models.StatusComplete {
+		if deadLetter, ok := d.deadLetters[status.MessageID]; ok && deadLetter.ResolvedAt == nil {
+			now := time.Now()
+			deadLetter.ResolvedAt = &now
+		}
+	}
+
+	d.statuses[status.MessageID] = &updated
+	d.updatedAt[status.MessageID] = time.Now()
+	d.history[status.MessageID] = append(d.history[status.MessageID], status.Status)
+}
+
+func (d *DBPilot) ListProcessingStatuses(status models.ProcessStatus) ([]models.ProcessingStatus, error) {
+	return d.SearchProcessingStatuses(models.StatusFilter{Statuses: []models.ProcessStatus{status}})
+}
+
+func (d *DBPilot) SearchProcessingStatuses(filter models.StatusFilter) ([]models.ProcessingStatus, error) {
+	d.mu.Lock()
+	err := d.fail("SearchProcessingStatuses")
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := d.SummarizeProcessingStatuses(filter)
+	if err != nil {
+		return nil, err
+	}
+	return list.Data, nil
+}
+
+func (d *DBPilot) SummarizeProcessingStatuses(filter models.StatusFilter) (*models.StatusList, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("SummarizeProcessingStatuses"); err != nil {
+		return nil, err
+	}
+
+	list := &models.StatusList{ByStatus: make(map[models.ProcessStatus]int64)}
+	var result []models.ProcessingStatus
+	for messageID, status := range d.statuses {
+		if len(filter.Statuses) > 0 && !containsStatus(filter.Statuses, status.Status) {
+			continue
+		}
+		if !filter.From.IsZero() && status.CreatedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !status.CreatedAt.Before(filter.To) {
+			continue
+		}
+		if !filter.UpdatedBefore.IsZero() && !d.updatedAt[messageID].Before(filter.UpdatedBefore) {
+			continue
+		}
+		if filter.Sender != "" {
+			emailData, ok := d.emails[messageID]
+			if !ok || !strings.Contains(strings.ToLower(emailData.From), strings.ToLower(filter.Sender)) {
+				continue
+			}
+		}
+		copied := *status
+		copied.UpdatedAt = d.updatedAt[messageID]
+		result = append(result, copied)
+		list.ByStatus[status.Status]++
+		list.Total++
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	list.Data = result
+	list.Count = len(result)
+	return list, nil
+}
+
+// Backdate はメッセージの最終更新日時を過去にずらします（滞留の検出を確認する場合に使います）
+func (d *DBPilot) Backdate(messageID string, age time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.updatedAt[messageID] = time.Now().Add(-age)
+}
+
+func (d *DBPilot) SaveDeadLetter(messageID string, emailData *models.EmailData, cause error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("SaveDeadLetter"); err != nil {
+		return err
+	}
+
+	deadLetter, ok := d.deadLetters[messageID]
+	if !ok || deadLetter.ResolvedAt != nil {
+		deadLetter = &models.DeadLetter{MessageID: messageID}
+		d.deadLetters[messageID] = deadLetter
+	}
+	deadLetter.Error = cause.Error()
+	deadLetter.Attempts++
+	deadLetter.LastFailedAt = time.Now()
+	if emailData != nil {
+		payload, err := json.Marshal(emailData)
+		if err != nil {
+			return err
+		}
+		deadLetter.Payload = string(payload)
+	}
+	return nil
+}
+
+func (d *DBPilot) GetDeadLetter(messageID string) (*models.DeadLetter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("GetDeadLetter"); err != nil {
+		return nil, err
+	}
+	deadLetter, ok := d.deadLetters[messageID]
+	if !ok {
+		return nil, fmt.Errorf("dead letter not found for message_id: %s", messageID)
+	}
+	copied := *deadLetter
+	return &copied, nil
+}
+
+func (d *DBPilot) MarkDeadLetterReprocessed(messageID string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("MarkDeadLetterReprocessed"); err != nil {
+		return 0, err
+	}
+	deadLetter, ok := d.deadLetters[messageID]
+	if !ok {
+		return 0, fmt.Errorf("dead letter not found for message_id: %s", messageID)
+	}
+	now := time.Now()
+	deadLetter.ReprocessCount++
+	deadLetter.LastReprocessedAt = &now
+	return deadLetter.ReprocessCount, nil
+}
+
+func (d *DBPilot) FindCachedResponse(contentHash, workflowVersion string, since time.Time) (*models.AIResponse, string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("FindCachedResponse"); err != nil {
+		return nil, "", err
+	}
+	cached, ok := d.cached[contentHash+"\n"+workflowVersion]
+	if !ok {
+		return nil, "", nil
+	}
+	copied := *cached.response
+	return &copied, cached.messageID, nil
+}
+
+func (d *DBPilot) GetCostUsage(from, to string) (*models.CostUsageReport, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("GetCostUsage"); err != nil {
+		return nil, err
+	}
+	report := *d.usage
+	report.From, report.To = from, to
+	return &report, nil
+}
+
+// Events はメッセージについて記録されたステップを記録順に返します
+func (d *DBPilot) Events(messageID string) []models.MessageStep {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]models.MessageStep(nil), d.events[messageID]...)
+}
+
+func (d *DBPilot) SaveMessageEvents(events []models.MessageEvent) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("SaveMessageEvents"); err != nil {
+		return err
+	}
+	for _, event := range events {
+		d.events[event.MessageID] = append(d.events[event.MessageID], event.Step)
+	}
+	return nil
+}
+
+func (d *DBPilot) SaveShadowResult(payload *models.ShadowResultPayload) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fail("SaveShadowResult"); err != nil {
+		return err
+	}
+	d.shadows = append(d.shadows, payload)
+	return nil
+}
+
+func containsStatus(statuses []models.ProcessStatus, status models.ProcessStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
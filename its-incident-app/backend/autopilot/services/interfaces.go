@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"autopilot/models"
+)
+
+// DBPilot はハンドラーとキャッシュ・利用上限が使うdbpilotの操作です（処理状態は StatusStore で扱います）。
+// 本番では DBPilotService を、テストでは services/fake のインメモリ実装を渡します
+type DBPilot interface {
+	SaveEmail(emailData *models.EmailData, messageID string) error
+	GetEmail(messageID string) (*models.EmailData, error)
+	SaveIncident(aiResponse *models.AIResponse, messageID string) error
+	ThreadEmail(messageID string, subjectWindow time.Duration) (*models.ThreadMatch, error)
+
+	SaveDeadLetter(messageID string, emailData *models.EmailData, cause error) error
+	GetDeadLetter(messageID string) (*models.DeadLetter, error)
+	MarkDeadLetterReprocessed(messageID string) (int, error)
+
+	FindCachedResponse(contentHash, workflowVersion string, since time.Time) (*models.AIResponse, string, error)
+	GetCostUsage(from, to string) (*models.CostUsageReport, error)
+	SaveShadowResult(payload *models.ShadowResultPayload) error
+	SaveMessageEvents(events []models.MessageEvent) error
+}
+
+// ErrStatusConflict は処理状態が想定した状態から変わっていたため保存しなかったことを表します
+var ErrStatusConflict = errors.New("processing status has changed")
+
+// StatusStore はメッセージの処理状態の保存先です。ハンドラーは処理状態の読み書きをすべてこのインターフェースで行います。
+// 設定（STATUS_STORE）に応じて DBPilotService または DatastoreStatusStore を使います
+type StatusStore interface {
+	GetProcessingStatus(messageID string) (*models.ProcessingStatus, error)
+	// UpdateProcessingStatus は処理状態を保存します。既存の作成日時は保持し、空のインシデントID・コールバックURLは既存の値を引き継ぎます
+	UpdateProcessingStatus(status *models.ProcessingStatus) error
+	// TransitionProcessingStatus は現在の状態が from の場合だけ処理状態を保存します（同時に承認・却下された場合に1つだけ成功させる）。
+	// 状態が異なる場合は ErrStatusConflict を返します
+	TransitionProcessingStatus(from models.ProcessStatus, status *models.ProcessingStatus) error
+	ListProcessingStatuses(status models.ProcessStatus) ([]models.ProcessingStatus, error)
+	SearchProcessingStatuses(filter models.StatusFilter) ([]models.ProcessingStatus, error)
+	SummarizeProcessingStatuses(filter models.StatusFilter) (*models.StatusList, error)
+}
+
+// AIClassifier はメールを分類するAI処理です。
+// 本番では AIService を、テストでは services/fake のインメモリ実装を渡します
+type AIClassifier interface {
+	WorkflowVersion(messageID, language string) string
+	ProcessEmail(ctx context.Context, messageID string, emailData *models.EmailData) (*models.AIResponse, error)
+	ProcessEmailStream(ctx context.Context, messageID string, emailData *models.EmailData, onPartial PartialFunc) (*models.AIResponse, error)
+	ShadowSampled(messageID string) bool
+	ProcessShadow(ctx context.Context, messageID string, emailData *models.EmailData) (*models.AIResponse, error)
+}
+
+var (
+	_ DBPilot      = (*DBPilotService)(nil)
+	_ StatusStore  = (*DBPilotService)(nil)
+	_ StatusStore  = (*DatastoreStatusStore)(nil)
+	_ AIClassifier = (*AIService)(nil)
+)
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"unicode"
+
+	"autopilot/models"
+)
+
+// 検出するメールの言語（ISO 639-1）
+const (
+	LanguageJapanese = "ja"
+	LanguageEnglish  = "en"
+)
+
+// languageSampleRunes は言語の判定に使う先頭からの文字数（長い本文やログの貼り付けで時間をかけないため）
+const languageSampleRunes = 2000
+
+// japaneseRatio は文字のうち仮名・漢字がこの割合以上なら日本語とみなすしきい値。
+// 日本語のメールでもホスト名やログなどの英数字が多く含まれるため、低めにしています
+const japaneseRatio = 0.1
+
+// DetectLanguage は件名と本文の文字種からメールの言語を判定します。
+// 仮名・漢字が一定の割合以上なら日本語、ラテン文字のみなら英語、文字を含まない場合は空文字を返します
+func DetectLanguage(emailData *models.EmailData) string {
+	var japanese, letters int
+	count := func(text string) {
+		n := 0
+		for _, r := range text {
+			if n >= languageSampleRunes {
+				return
+			}
+			n++
+			switch {
+			case unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Han):
+				japanese++
+				letters++
+			case unicode.IsLetter(r):
+				letters++
+			}
+		}
+	}
+	count(emailData.Subject)
+	count(emailData.Body)
+
+	if letters == 0 {
+		return ""
+	}
+	if float64(japanese)/float64(letters) >= japaneseRatio {
+		return LanguageJapanese
+	}
+	return LanguageEnglish
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"autopilot/config"
+	"autopilot/models"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name  string
+		email models.EmailData
+		want  string
+	}{
+		{"japanese", models.EmailData{Subject: "サーバーが応答しません", Body: "web01 が応答しません。"}, LanguageJapanese},
+		{"english", models.EmailData{Subject: "Server not responding", Body: "web01 is down."}, LanguageEnglish},
+		// ホスト名やログが多くても、仮名・漢字が一定の割合あれば日本語とする
+		{"japanese with logs", models.EmailData{
+			Subject: "障害通知",
+			Body:    "接続できません\nERROR connection refused host=db01.example.com port=5432\nERROR connection refused host=db02.example.com port=5432",
+		}, LanguageJapanese},
+		{"no letters", models.EmailData{Subject: "12345", Body: "!!! ---"}, ""},
+		// 先頭の一定の文字数だけで判定する
+		{"long english prefix", models.EmailData{Body: strings.Repeat("a", languageSampleRunes) + strings.Repeat("あ", 1000)}, LanguageEnglish},
+	}
+	for _, tt := range tests {
+		if got := DetectLanguage(&tt.email); got != tt.want {
+			t.Errorf("%s: DetectLanguage = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWorkflowVersionForLanguage(t *testing.T) {
+	s := NewAIService(nil, config.AIWorkflowConfig{
+		Version:          "v1",
+		CanaryVersion:    "v2",
+		CanaryPercent:    100,
+		LanguageVersions: map[string]string{LanguageEnglish: "v1-en"},
+	}, config.AIShadowConfig{}, config.AIRetryConfig{}, 0, 0, nil)
+
+	// 言語専用のバージョンはカナリアより優先する
+	if got := s.WorkflowVersion("msg-1", LanguageEnglish); got != "v1-en" {
+		t.Errorf("WorkflowVersion(en) = %q, want v1-en", got)
+	}
+	if got := s.WorkflowVersion("msg-1", LanguageJapanese); got != "v2" {
+		t.Errorf("WorkflowVersion(ja) = %q, want the canary version", got)
+	}
+	if got := s.WorkflowVersion("msg-1", ""); got != "v2" {
+		t.Errorf("WorkflowVersion(undetected) = %q, want the canary version", got)
+	}
+}
+
+func TestProcessEmailRecordsLanguage(t *testing.T) {
+	s := newTestAIService(t, newFakeAIProvider(t, http.StatusOK))
+	s.workflow.LanguageVersions = map[string]string{LanguageEnglish: "v1-en"}
+
+	resp, err := s.ProcessEmail(context.Background(), "msg-1", &models.EmailData{Subject: "Server not responding"})
+	if err != nil {
+		t.Fatalf("ProcessEmail: %v", err)
+	}
+	if resp.Language != LanguageEnglish || resp.WorkflowVersion != "v1-en" {
+		t.Errorf("language = %q, workflow version = %q, want en and v1-en", resp.Language, resp.WorkflowVersion)
+	}
+}
@@ -0,0 +1,16 @@
+package services
+
+import "autopilot/metrics"
+
+// aiLatencyBuckets はAI呼び出しのレイテンシ（秒）用のバケット。ワークフローは数十秒かかることがあるため既定より広くする
+var aiLatencyBuckets = []float64{0.5, 1, 2.5, 5, 10, 20, 30, 45, 60, 90}
+
+// AI呼び出しのレイテンシと失敗（/metrics で公開し、AIの遅延や障害をアラートで検知する）
+var (
+	aiRequestDuration = metrics.NewHistogramVec("autopilot_ai_request_duration_seconds",
+		"Latency of AI workflow calls by provider and outcome.", aiLatencyBuckets, "provider", "outcome")
+	aiFailoversTotal = metrics.NewCounterVec("autopilot_ai_failovers_total",
+		"AI requests that fell back from a failed or circuit-open provider.", "provider")
+	aiRetriesTotal = metrics.NewCounterVec("autopilot_ai_retries_total",
+		"Retried AI requests by provider.", "provider")
+)
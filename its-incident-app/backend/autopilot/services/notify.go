@@ -0,0 +1,67 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"autopilot/serviceauth"
+	"shared/idtoken"
+)
+
+// NotifyService は通知サービス（notify）経由で運用者にアラートを送信します
+type NotifyService struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewNotifyService は通知サービスのクライアントを作成します。baseURL が空の場合は nil を返します
+func NewNotifyService(baseURL string) *NotifyService {
+	if baseURL == "" {
+		return nil
+	}
+	return &NotifyService{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SendAlert はシステムからのアラートを送信します
+func (s *NotifyService) SendAlert(title, content, priority string) error {
+	if s == nil {
+		return fmt.Errorf("NOTIFICATION_SERVICE_URL is not configured")
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"title":     title,
+		"content":   content,
+		"responder": "system",
+		"name":      "autopilot",
+		"priority":  priority,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/notify", bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := idtoken.BearerToken(s.baseURL, serviceauth.PrimaryServiceToken()); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("notification service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
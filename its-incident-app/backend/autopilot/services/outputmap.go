@@ -0,0 +1,236 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"autopilot/config"
+	"autopilot/logger"
+	"autopilot/metrics"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+// outputKind はインシデントの項目の値の型です
+type outputKind int
+
+const (
+	outputString outputKind = iota
+	outputInt
+	outputLogs
+)
+
+// outputFields はマッピングできるインシデントの項目（dbpilotに送るワークフローの出力のキー名）と値の型
+var outputFields = map[string]outputKind{
+	"body":         outputString,
+	"user":         outputString,
+	"workflowLogs": outputLogs,
+	"host":         outputString,
+	"priority":     outputString,
+	"subject":      outputString,
+	"from":         outputString,
+	"place":        outputString,
+	"incident":     outputString,
+	"time":         outputString,
+	"incidentID":   outputInt,
+	"judgment":     outputString,
+	"sender":       outputString,
+	"final":        outputString,
+}
+
+// outputCoercionFailures は型を変換できずに空にしたワークフローの出力の数
+var outputCoercionFailures = metrics.NewCounterVec("autopilot_ai_output_coercion_failures_total",
+	"AI workflow outputs that could not be converted to the incident field type.", "field")
+
+// OutputMapper はAIワークフローの出力のキーをインシデントの項目に対応付けます。
+// 設定のない項目は同じ名前のキーを使い、値は項目の型（文字列・整数・ログの配列）に変換します
+type OutputMapper struct {
+	fields map[string]config.AIOutputField
+}
+
+// NewOutputMapper は項目名ごとの設定（AI_OUTPUT_MAPPING）からOutputMapperを作成します。未知の項目名はエラーになります
+func NewOutputMapper(fields map[string]config.AIOutputField) (*OutputMapper, error) {
+	for name, field := range fields {
+		kind, ok := outputFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown incident field %q in AI_OUTPUT_MAPPING (known: %s)", name, knownOutputFields())
+		}
+		if field.Default != "" {
+			if _, err := coerceOutput(kind, field.Default); err != nil {
+				return nil, fmt.Errorf("invalid default for %q in AI_OUTPUT_MAPPING: %v", name, err)
+			}
+		}
+	}
+
+	logger.Logger.Info("AIの出力のマッピングを設定しました", zap.Any("fields", fields))
+	return &OutputMapper{fields: fields}, nil
+}
+
+func knownOutputFields() string {
+	names := make([]string, 0, len(outputFields))
+	for name := range outputFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Apply はワークフローの生の出力 raw をマッピングし、data.Outputs を置き換えます
+func (m *OutputMapper) Apply(raw map[string]interface{}, data *models.AIResponseData) error {
+	mapped := make(map[string]interface{}, len(outputFields))
+	for name, kind := range outputFields {
+		field := m.fields[name]
+		keys := field.From
+		if len(keys) == 0 {
+			keys = []string{name}
+		}
+
+		var value interface{}
+		for _, key := range keys {
+			if v, ok := raw[key]; ok && !emptyOutput(v) {
+				value = v
+				break
+			}
+		}
+		if value == nil && field.Default != "" {
+			value = field.Default
+		}
+		if value == nil {
+			continue
+		}
+
+		coerced, err := coerceOutput(kind, value)
+		if err != nil {
+			outputCoercionFailures.Inc(name)
+			logger.Logger.Warn("ワークフローの出力をインシデントの項目の型に変換できませんでした",
+				zap.String("field", name), zap.Strings("keys", keys), zap.Error(err))
+			continue
+		}
+		mapped[name] = coerced
+	}
+
+	encoded, err := json.Marshal(mapped)
+	if err != nil {
+		return fmt.Errorf("failed to encode mapped outputs: %v", err)
+	}
+	var empty models.AIResponseData
+	data.Outputs = empty.Outputs
+	if err := json.Unmarshal(encoded, &data.Outputs); err != nil {
+		return fmt.Errorf("failed to decode mapped outputs: %v", err)
+	}
+	return nil
+}
+
+// emptyOutput は値がない（null または空白のみの文字列）かを返します
+func emptyOutput(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	}
+	return false
+}
+
+// coerceOutput は値を項目の型に変換します。オブジェクトや配列を文字列の項目に対応付けた場合はJSONの文字列にします
+func coerceOutput(kind outputKind, value interface{}) (interface{}, error) {
+	switch kind {
+	case outputInt:
+		switch v := value.(type) {
+		case json.Number:
+			return v.Int64()
+		case string:
+			return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		}
+		return nil, fmt.Errorf("cannot convert %T to integer", value)
+	case outputLogs:
+		if s, ok := value.(string); ok {
+			decoded, err := decodeOutputs([]byte(s))
+			if err != nil {
+				return nil, fmt.Errorf("workflow logs string is not JSON: %v", err)
+			}
+			value = decoded
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %T to workflow logs", value)
+		}
+		logs := make([]models.WorkflowLog, 0, len(items))
+		for _, item := range items {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot convert %T to a workflow log entry", item)
+			}
+			log := make(models.WorkflowLog, len(entry))
+			for key, v := range entry {
+				s, err := coerceOutput(outputString, v)
+				if err != nil {
+					return nil, err
+				}
+				log[key] = s.(string)
+			}
+			logs = append(logs, log)
+		}
+		return logs, nil
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+// decodeOutputs は数値を json.Number のままJSONを読み取ります（IDなどの桁落ちを防ぐ）
+func decodeOutputs(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// mapOutputs は応答の data（生のJSON）の outputs をマッピングして data.Outputs に反映します。
+// マッピングが設定されていない場合は何もしません
+func (s *AIService) mapOutputs(rawData []byte, data *models.AIResponseData) error {
+	if s.outputs == nil {
+		return nil
+	}
+
+	var envelope struct {
+		Outputs json.RawMessage `json:"outputs"`
+	}
+	if err := json.Unmarshal(rawData, &envelope); err != nil {
+		return fmt.Errorf("failed to decode AI response data: %v", err)
+	}
+	raw := map[string]interface{}{}
+	if len(envelope.Outputs) > 0 && string(envelope.Outputs) != "null" {
+		decoded, err := decodeOutputs(envelope.Outputs)
+		if err != nil {
+			return fmt.Errorf("failed to decode AI outputs: %v", err)
+		}
+		outputs, ok := decoded.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("AI outputs is %T, not an object", decoded)
+		}
+		raw = outputs
+	}
+	return s.outputs.Apply(raw, data)
+}
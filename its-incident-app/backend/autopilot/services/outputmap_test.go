@@ -0,0 +1,86 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"autopilot/config"
+	"autopilot/models"
+)
+
+func TestNewOutputMapperRejectsInvalidFields(t *testing.T) {
+	if _, err := NewOutputMapper(map[string]config.AIOutputField{"severity": {From: []string{"level"}}}); err == nil || !strings.Contains(err.Error(), "judgment") {
+		t.Errorf("unknown field error = %v, want the known fields listed", err)
+	}
+	if _, err := NewOutputMapper(map[string]config.AIOutputField{"incidentID": {Default: "none"}}); err == nil {
+		t.Error("non-integer default accepted for incidentID")
+	}
+}
+
+func TestMapOutputs(t *testing.T) {
+	mapper, err := NewOutputMapper(map[string]config.AIOutputField{
+		"judgment": {From: []string{"classification", "category"}},
+		"priority": {From: []string{"severity"}, Default: "中"},
+		"host":     {From: []string{"target"}},
+	})
+	if err != nil {
+		t.Fatalf("NewOutputMapper: %v", err)
+	}
+	s := &AIService{outputs: mapper}
+
+	raw := `{"status": "succeeded", "outputs": {
+		"classification": " ",
+		"category": "要対応",
+		"target": {"name": "web01", "ip": "10.0.0.1"},
+		"incidentID": "9007199254740993",
+		"workflowLogs": "[{\"step\": \"classify\", \"elapsed\": 1.5}]",
+		"subject": 42,
+		"final": true
+	}}`
+	var data models.AIResponseData
+	if err := s.mapOutputs([]byte(raw), &data); err != nil {
+		t.Fatalf("mapOutputs: %v", err)
+	}
+
+	outputs := data.Outputs
+	// 空の値は次の候補のキーを使い、どれもなければ既定値を使う
+	if outputs.Judgment != "要対応" || outputs.Priority != "中" {
+		t.Errorf("judgment = %q, priority = %q", outputs.Judgment, outputs.Priority)
+	}
+	// 文字列の項目に対応付けたオブジェクトや数値・真偽値は文字列にする
+	if outputs.Host != `{"ip":"10.0.0.1","name":"web01"}` || outputs.Subject != "42" || outputs.Final != "true" {
+		t.Errorf("host = %q, subject = %q, final = %q", outputs.Host, outputs.Subject, outputs.Final)
+	}
+	if outputs.IncidentID != 9007199254740993 {
+		t.Errorf("incidentID = %d, want no precision loss", outputs.IncidentID)
+	}
+	if len(outputs.WorkflowLogs) != 1 || outputs.WorkflowLogs[0]["step"] != "classify" || outputs.WorkflowLogs[0]["elapsed"] != "1.5" {
+		t.Errorf("workflowLogs = %+v", outputs.WorkflowLogs)
+	}
+}
+
+func TestMapOutputsDropsUnconvertibleValues(t *testing.T) {
+	mapper, err := NewOutputMapper(nil)
+	if err != nil {
+		t.Fatalf("NewOutputMapper: %v", err)
+	}
+	s := &AIService{outputs: mapper}
+
+	// 型を変換できない項目だけを空にし、ほかの項目は残す
+	raw := `{"outputs": {"incidentID": "INC-1", "workflowLogs": "not json", "judgment": "対応不要"}}`
+	var data models.AIResponseData
+	data.Outputs.Host = "stale"
+	if err := s.mapOutputs([]byte(raw), &data); err != nil {
+		t.Fatalf("mapOutputs: %v", err)
+	}
+	if data.Outputs.IncidentID != 0 || data.Outputs.WorkflowLogs != nil || data.Outputs.Judgment != "対応不要" || data.Outputs.Host != "" {
+		t.Errorf("unexpected outputs: %+v", data.Outputs)
+	}
+
+	if err := s.mapOutputs([]byte(`{"outputs": ["judgment"]}`), &data); err == nil {
+		t.Error("mapOutputs accepted non-object outputs")
+	}
+	if err := s.mapOutputs([]byte(`{"outputs": null}`), &data); err != nil {
+		t.Errorf("mapOutputs with null outputs: %v", err)
+	}
+}
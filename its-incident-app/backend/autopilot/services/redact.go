@@ -0,0 +1,108 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+
+	"autopilot/config"
+	"autopilot/logger"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+// redactRule はマスク対象の種類と正規表現です
+type redactRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// builtinRedactRules は常にマスクする個人情報です。
+// メールアドレスを電話番号・IPアドレスより先に処理し、アドレス中の数字が別の種類として扱われないようにします
+var builtinRedactRules = []redactRule{
+	{name: "email", pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+	{name: "ip", pattern: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)},
+	{name: "ip", pattern: regexp.MustCompile(`(?i)\b(?:[0-9a-f]{1,4}:){7}[0-9a-f]{1,4}\b|\b(?:[0-9a-f]{1,4}:){1,7}:(?:[0-9a-f]{1,4}(?::[0-9a-f]{1,4}){0,6})?\b`)},
+	{name: "phone", pattern: regexp.MustCompile(`(?:\+\d{1,3}[\s\-]?)?\(?0?\d{1,4}\)?[\s\-]\d{1,4}[\s\-]\d{3,4}\b|\b0\d{9,10}\b`)},
+}
+
+// Redactor はAIに送信するメール内容から個人情報をマスクします。
+// マスクするのはAIへのリクエストのみで、dbpilotには元のメールを保存します
+type Redactor struct {
+	rules     []redactRule
+	allowlist map[string]bool
+}
+
+// NewRedactor はマスク設定からRedactorを作成します。無効な場合は nil（マスクしない）を返します
+func NewRedactor(cfg config.RedactionConfig) *Redactor {
+	if !cfg.Enabled {
+		logger.Logger.Warn("AIに送信するメール内容の個人情報マスクが無効です")
+		return nil
+	}
+
+	r := &Redactor{
+		rules:     append([]redactRule{}, builtinRedactRules...),
+		allowlist: make(map[string]bool, len(cfg.Allowlist)),
+	}
+	// 顧客IDなどの追加パターンは組み込みより先に適用する（電話番号として部分的にマスクされないように）
+	custom := make([]redactRule, 0, len(cfg.Patterns))
+	for _, p := range cfg.Patterns {
+		custom = append(custom, redactRule{name: p.Name, pattern: regexp.MustCompile(p.Pattern)})
+	}
+	r.rules = append(custom, r.rules...)
+	// 一致箇所は小文字にして比較するため、許可リストも小文字で保持する
+	for _, value := range cfg.Allowlist {
+		r.allowlist[strings.ToLower(value)] = true
+	}
+
+	logger.Logger.Info("AIに送信するメール内容の個人情報マスクを設定しました",
+		zap.Int("custom_patterns", len(custom)),
+		zap.Int("allowlist", len(cfg.Allowlist)))
+	return r
+}
+
+// RedactEmail は件名・送信者・本文・添付ファイルの内容をマスクしたコピーを返します。元の emailData は変更しません
+func (r *Redactor) RedactEmail(emailData *models.EmailData) (*models.EmailData, map[string]int) {
+	if r == nil {
+		return emailData, nil
+	}
+
+	counts := make(map[string]int)
+	redacted := *emailData
+	redacted.RawHeader = nil
+	redacted.Subject = r.redact(emailData.Subject, counts)
+	redacted.From = r.redact(emailData.From, counts)
+	redacted.Body = r.redact(emailData.Body, counts)
+	redacted.Attachments = make([]models.Attachment, len(emailData.Attachments))
+	for i, attachment := range emailData.Attachments {
+		attachment.Content = r.redact(attachment.Content, counts)
+		redacted.Attachments[i] = attachment
+	}
+	return &redacted, counts
+}
+
+// redact は文字列中の一致箇所を [REDACTED:種類] に置き換え、種類ごとの件数を counts に加算します
+func (r *Redactor) redact(text string, counts map[string]int) string {
+	for _, rule := range r.rules {
+		text = rule.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if r.allowed(match) {
+				return match
+			}
+			counts[rule.name]++
+			return "[REDACTED:" + rule.name + "]"
+		})
+	}
+	return text
+}
+
+// allowed は許可リストの値、または許可されたドメイン（@example.com）のメールアドレスかを判定します
+func (r *Redactor) allowed(match string) bool {
+	value := strings.ToLower(match)
+	if r.allowlist[value] {
+		return true
+	}
+	if at := strings.LastIndex(value, "@"); at >= 0 {
+		return r.allowlist[value[at:]] || r.allowlist[value[at+1:]]
+	}
+	return false
+}
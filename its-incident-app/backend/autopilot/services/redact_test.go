@@ -0,0 +1,81 @@
+package services
+
+import (
+	"testing"
+
+	"autopilot/config"
+	"autopilot/models"
+)
+
+func TestRedactEmail(t *testing.T) {
+	r := NewRedactor(config.RedactionConfig{Enabled: true})
+
+	email := &models.EmailData{
+		From:      "taro.yamada@example.co.jp",
+		Subject:   "192.168.10.5 が応答しません",
+		Body:      "担当: 03-1234-5678 / 09012345678\n接続元: 2001:db8::1\n連絡先: hanako@example.com",
+		RawHeader: []byte("From: taro.yamada@example.co.jp\r\n\r\n"),
+		Attachments: []models.Attachment{
+			{FileName: "contacts.txt", Content: "admin@example.com"},
+		},
+	}
+	redacted, counts := r.RedactEmail(email)
+
+	if redacted.From != "[REDACTED:email]" {
+		t.Errorf("From = %q", redacted.From)
+	}
+	if redacted.Subject != "[REDACTED:ip] が応答しません" {
+		t.Errorf("Subject = %q", redacted.Subject)
+	}
+	if want := "担当: [REDACTED:phone] / [REDACTED:phone]\n接続元: [REDACTED:ip]\n連絡先: [REDACTED:email]"; redacted.Body != want {
+		t.Errorf("Body = %q, want %q", redacted.Body, want)
+	}
+	if redacted.Attachments[0].Content != "[REDACTED:email]" {
+		t.Errorf("attachment content = %q", redacted.Attachments[0].Content)
+	}
+	// 元のヘッダーはAIに送らない
+	if redacted.RawHeader != nil {
+		t.Errorf("RawHeader = %q, want nil", redacted.RawHeader)
+	}
+	if counts["email"] != 3 || counts["ip"] != 2 || counts["phone"] != 2 {
+		t.Errorf("counts = %v", counts)
+	}
+
+	// 元のメールは変更しない（dbpilotには元の内容を保存する）
+	if email.From != "taro.yamada@example.co.jp" || email.Attachments[0].Content != "admin@example.com" || email.RawHeader == nil {
+		t.Errorf("original email was modified: %+v", email)
+	}
+}
+
+func TestRedactEmailCustomPatternsAndAllowlist(t *testing.T) {
+	r := NewRedactor(config.RedactionConfig{
+		Enabled:   true,
+		Patterns:  []config.RedactionPattern{{Name: "customer_id", Pattern: `CUST-\d{4}-\d{4}-\d{4}`}},
+		Allowlist: []string{"@Example.com", "10.0.0.1"},
+	})
+
+	email := &models.EmailData{
+		From: "monitor@EXAMPLE.com",
+		Body: "顧客 CUST-0312-3456-7890 の 10.0.0.1 と 10.0.0.2 で障害、報告者 user@other.example.net",
+	}
+	redacted, counts := r.RedactEmail(email)
+
+	// 追加のパターンは電話番号として部分的にマスクされない。許可リストは大文字小文字を区別しない
+	if redacted.From != "monitor@EXAMPLE.com" {
+		t.Errorf("From = %q, want the allowed domain kept", redacted.From)
+	}
+	if want := "顧客 [REDACTED:customer_id] の 10.0.0.1 と [REDACTED:ip] で障害、報告者 [REDACTED:email]"; redacted.Body != want {
+		t.Errorf("Body = %q, want %q", redacted.Body, want)
+	}
+	if counts["customer_id"] != 1 || counts["phone"] != 0 {
+		t.Errorf("counts = %v", counts)
+	}
+}
+
+func TestRedactorDisabled(t *testing.T) {
+	r := NewRedactor(config.RedactionConfig{Enabled: false})
+	email := &models.EmailData{From: "taro.yamada@example.co.jp"}
+	if redacted, counts := r.RedactEmail(email); redacted != email || counts != nil {
+		t.Errorf("RedactEmail = %+v, %v, want the email unchanged", redacted, counts)
+	}
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"autopilot/config"
+)
+
+// RetryPolicy は1つのAIプロバイダーへの呼び出しを再試行するかを判断します
+type RetryPolicy interface {
+	// Backoff は attempt 回目（1始まり）の呼び出しが err で失敗した後の待ち時間を返します。再試行しない場合は false を返します
+	Backoff(attempt int, err error) (time.Duration, bool)
+}
+
+// aiStatusError はAI APIが200以外のステータスを返したことを表します
+type aiStatusError struct {
+	StatusCode int
+}
+
+func (e *aiStatusError) Error() string {
+	return fmt.Sprintf("AI API returned non-200 status: %d", e.StatusCode)
+}
+
+// ExponentialBackoff は指数バックオフとジッターで再試行するRetryPolicyです。
+// 再試行するのはタイムアウトと retryableStatus のHTTPステータスだけで、レスポンスの検証エラーなどは再試行しません
+type ExponentialBackoff struct {
+	maxAttempts     int
+	initial         time.Duration
+	max             time.Duration
+	jitter          float64
+	retryableStatus map[int]bool
+}
+
+// NewExponentialBackoff は設定からExponentialBackoffを作成します
+func NewExponentialBackoff(cfg config.AIRetryConfig) *ExponentialBackoff {
+	retryableStatus := make(map[int]bool, len(cfg.RetryableStatus))
+	for _, status := range cfg.RetryableStatus {
+		retryableStatus[status] = true
+	}
+	return &ExponentialBackoff{
+		maxAttempts:     cfg.MaxAttempts,
+		initial:         cfg.InitialBackoff,
+		max:             cfg.MaxBackoff,
+		jitter:          cfg.Jitter,
+		retryableStatus: retryableStatus,
+	}
+}
+
+func (p *ExponentialBackoff) Backoff(attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.maxAttempts || !p.retryable(err) {
+		return 0, false
+	}
+
+	wait := p.initial
+	for i := 1; i < attempt && wait < p.max; i++ {
+		wait *= 2
+	}
+	if p.max > 0 && wait > p.max {
+		wait = p.max
+	}
+	// 複数のインスタンスが同時に再試行しないよう、待ち時間を jitter の割合だけ短くする
+	if p.jitter > 0 && wait > 0 {
+		wait -= time.Duration(rand.Float64() * p.jitter * float64(wait))
+	}
+	return wait, true
+}
+
+// retryable はタイムアウトまたは再試行対象のHTTPステータスの場合に true を返します
+func (p *ExponentialBackoff) retryable(err error) bool {
+	var statusErr *aiStatusError
+	if errors.As(err, &statusErr) {
+		return p.retryableStatus[statusErr.StatusCode]
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errAIStreamStalled) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryBudget はリクエスト数に対する再試行の割合を制限します。
+// リクエストごとに ratio だけ貯まり、再試行ごとに1消費します（上限は maxRetryTokens）。nil の場合は制限しません
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+// maxRetryTokens は貯めておける再試行の回数（障害の直後に連続して再試行できる回数）
+const maxRetryTokens = 10
+
+func newRetryBudget(ratio float64) *retryBudget {
+	if ratio <= 0 {
+		return nil
+	}
+	return &retryBudget{ratio: ratio, tokens: maxRetryTokens}
+}
+
+func (b *retryBudget) deposit() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, maxRetryTokens)
+}
+
+func (b *retryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
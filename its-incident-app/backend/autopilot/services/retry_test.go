@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"autopilot/config"
+	"autopilot/models"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	p := NewExponentialBackoff(config.AIRetryConfig{
+		MaxAttempts:     5,
+		InitialBackoff:  100 * time.Millisecond,
+		MaxBackoff:      300 * time.Millisecond,
+		RetryableStatus: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+	})
+	unavailable := &aiStatusError{StatusCode: http.StatusServiceUnavailable}
+
+	// 待ち時間は倍々に増え、MaxBackoff で頭打ちになる
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 300 * time.Millisecond, 4: 300 * time.Millisecond} {
+		if wait, ok := p.Backoff(attempt, unavailable); !ok || wait != want {
+			t.Errorf("Backoff(%d) = %v, %v, want %v", attempt, wait, ok, want)
+		}
+	}
+	if _, ok := p.Backoff(5, unavailable); ok {
+		t.Error("retried after MaxAttempts")
+	}
+
+	tests := map[string]struct {
+		err       error
+		retryable bool
+	}{
+		"retryable status":      {fmt.Errorf("failed: %w", &aiStatusError{StatusCode: http.StatusTooManyRequests}), true},
+		"non-retryable status":  {&aiStatusError{StatusCode: http.StatusBadRequest}, false},
+		"deadline exceeded":     {fmt.Errorf("failed to make HTTP request: %w", context.DeadlineExceeded), true},
+		"stalled stream":        {errAIStreamStalled, true},
+		"invalid response":      {errors.New("invalid AI response: AI response missing task_id"), false},
+		"cancelled by shutdown": {context.Canceled, false},
+	}
+	for name, tt := range tests {
+		if _, ok := p.Backoff(1, tt.err); ok != tt.retryable {
+			t.Errorf("%s: retry = %v, want %v", name, ok, tt.retryable)
+		}
+	}
+}
+
+func TestExponentialBackoffJitter(t *testing.T) {
+	p := NewExponentialBackoff(config.AIRetryConfig{
+		MaxAttempts:     2,
+		InitialBackoff:  100 * time.Millisecond,
+		Jitter:          0.5,
+		RetryableStatus: []int{http.StatusServiceUnavailable},
+	})
+
+	// ジッターは待ち時間を最大で Jitter の割合だけ短くする
+	for i := 0; i < 100; i++ {
+		wait, ok := p.Backoff(1, &aiStatusError{StatusCode: http.StatusServiceUnavailable})
+		if !ok || wait <= 50*time.Millisecond || wait > 100*time.Millisecond {
+			t.Fatalf("Backoff = %v, %v, want between 50ms and 100ms", wait, ok)
+		}
+	}
+}
+
+func TestProcessEmailRetriesProvider(t *testing.T) {
+	retry := config.AIRetryConfig{
+		MaxAttempts:     3,
+		InitialBackoff:  time.Millisecond,
+		RetryableStatus: []int{http.StatusServiceUnavailable},
+	}
+
+	// 再試行対象のステータスは同じプロバイダーに再試行し、成功すれば切り替えない
+	primary := newFakeAIProvider(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK)
+	secondary := newFakeAIProvider(t, http.StatusOK)
+	s := newTestAIService(t, primary, secondary)
+	s.SetRetryPolicy(NewExponentialBackoff(retry))
+	resp, err := s.ProcessEmail(context.Background(), "msg-1", &models.EmailData{})
+	if err != nil || resp.Provider != "primary" {
+		t.Fatalf("ProcessEmail = %+v, %v, want primary after retries", resp, err)
+	}
+	if calls := primary.takeCalls(); calls != 3 {
+		t.Errorf("primary called %d times, want 3", calls)
+	}
+
+	// 再試行の対象外のステータスはすぐに次のプロバイダーに切り替える
+	primary.respond(http.StatusBadRequest)
+	resp, err = s.ProcessEmail(context.Background(), "msg-2", &models.EmailData{})
+	if err != nil || resp.Provider != "secondary" {
+		t.Fatalf("ProcessEmail = %+v, %v, want secondary", resp, err)
+	}
+	if calls := primary.takeCalls(); calls != 1 {
+		t.Errorf("primary called %d times for a non-retryable status, want 1", calls)
+	}
+
+	// 待ち時間が ctx の期限を超える場合は再試行しない
+	primary.respond(http.StatusServiceUnavailable)
+	retry.InitialBackoff = time.Minute
+	s.SetRetryPolicy(NewExponentialBackoff(retry))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.ProcessEmail(ctx, "msg-3", &models.EmailData{}); err != nil {
+		t.Fatalf("ProcessEmail: %v", err)
+	}
+	if calls := primary.takeCalls(); calls != 1 {
+		t.Errorf("primary called %d times past the deadline, want 1", calls)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	if b := newRetryBudget(0); b != nil || !b.withdraw() {
+		t.Fatal("zero ratio should disable the retry budget")
+	}
+
+	b := newRetryBudget(0.5)
+	for i := 0; i < maxRetryTokens; i++ {
+		if !b.withdraw() {
+			t.Fatalf("withdraw %d failed, want %d retries available at start", i+1, maxRetryTokens)
+		}
+	}
+	if b.withdraw() {
+		t.Fatal("withdraw succeeded with an empty budget")
+	}
+
+	// リクエストごとに ratio だけ貯まる（2リクエストで1回の再試行）
+	b.deposit()
+	if b.withdraw() {
+		t.Error("withdraw succeeded after half a token")
+	}
+	b.deposit()
+	if !b.withdraw() {
+		t.Error("withdraw failed after two requests")
+	}
+
+	// 上限を超えては貯まらない
+	for i := 0; i < 100; i++ {
+		b.deposit()
+	}
+	if b.tokens != maxRetryTokens {
+		t.Errorf("tokens = %v, want %d", b.tokens, maxRetryTokens)
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+// SaveShadowResult はシャドウのAIモデルの結果を、比較対象の本番の結果とともに保存します
+func (s *DBPilotService) SaveShadowResult(payload *models.ShadowResultPayload) error {
+	logFields := []zap.Field{
+		zap.String("message_id", payload.MessageID),
+		zap.String("operation", "SaveShadowResult"),
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		logger.Logger.Error("シャドウの結果のJSONエンコードに失敗しました",
+			append(logFields, zap.Error(err))...)
+		return fmt.Errorf("failed to marshal shadow result: %v", err)
+	}
+
+	req, err := s.createRequest("POST", "/shadow-results", jsonData)
+	if err != nil {
+		logger.Logger.Error("リクエストの作成に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Logger.Error("シャドウの結果の保存に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return fmt.Errorf("failed to save shadow result: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		logger.Logger.Error("シャドウの結果の保存でエラーが発生しました",
+			append(logFields,
+				zap.Int("status_code", resp.StatusCode),
+				zap.String("response_body", string(respBody)))...)
+		return fmt.Errorf("failed to save shadow result, status: %d, response: %s",
+			resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
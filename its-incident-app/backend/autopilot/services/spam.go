@@ -0,0 +1,199 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"autopilot/config"
+	"autopilot/logger"
+	"autopilot/metrics"
+	"autopilot/models"
+	"autopilot/secrets"
+
+	"go.uber.org/zap"
+)
+
+// spamRule はメールの項目（件名・送信者・本文・ヘッダー）が正規表現に一致した場合に加えるスコアです
+type spamRule struct {
+	name    string
+	field   string // subject / from / body / header:名前（小文字）
+	pattern *regexp.Regexp
+	score   float64
+}
+
+// builtinSpamRules は常に適用するルールです。監視システムの通知は Auto-Submitted: auto-generated を付けることが多いため、
+// 自動応答（auto-replied）だけを対象にし、一括配信のヘッダーは単独ではしきい値（既定 5）に届かないスコアにしています
+var builtinSpamRules = []spamRule{
+	{name: "auto_replied", field: "header:auto-submitted", pattern: regexp.MustCompile(`(?i)^auto-replied`), score: 5},
+	{name: "autoreply_header", field: "header:x-autoreply", pattern: regexp.MustCompile(`.`), score: 5},
+	{name: "autoreply_header", field: "header:x-autorespond", pattern: regexp.MustCompile(`.`), score: 5},
+	{name: "out_of_office", field: "subject", pattern: regexp.MustCompile(`(?i)out of (the )?office|automatic reply|auto[- ]?reply|自動応答|自動返信|不在(通知|のお知らせ)`), score: 5},
+	{name: "list_unsubscribe", field: "header:list-unsubscribe", pattern: regexp.MustCompile(`.`), score: 3},
+	{name: "bulk_precedence", field: "header:precedence", pattern: regexp.MustCompile(`(?i)^(bulk|list|junk)`), score: 2},
+	{name: "newsletter_subject", field: "subject", pattern: regexp.MustCompile(`(?i)newsletter|webinar|メルマガ|メールマガジン|ウェビナー`), score: 2},
+}
+
+// spamVerdicts は判定結果（quarantined / passed）ごとのメール数
+var spamVerdicts = metrics.NewCounterVec("autopilot_spam_verdicts_total",
+	"Received emails by spam scoring verdict.", "verdict")
+
+// SpamVerdict はスパム・ノイズ判定の結果です。Reasons は一致したルール（外部フィルターは filter:理由）です
+type SpamVerdict struct {
+	Score      float64  `json:"score"`
+	Threshold  float64  `json:"threshold"`
+	Reasons    []string `json:"reasons,omitempty"`
+	Quarantine bool     `json:"quarantine"`
+}
+
+// String は処理状態に保存する判定の説明を返します
+func (v SpamVerdict) String() string {
+	return fmt.Sprintf("spam score %.1f >= %.1f: %s", v.Score, v.Threshold, strings.Join(v.Reasons, ", "))
+}
+
+// SpamScorer は受信したメールにスコアを付け、しきい値以上のメールを隔離の対象にします
+type SpamScorer struct {
+	rules     []spamRule
+	threshold float64
+	filterURL string
+	client    *http.Client
+}
+
+// NewSpamScorer は設定からSpamScorerを作成します。無効な場合は nil（すべて通過）を返します
+func NewSpamScorer(cfg config.SpamConfig) *SpamScorer {
+	if !cfg.Enabled {
+		logger.Logger.Info("スパム・ノイズ判定は無効です")
+		return nil
+	}
+
+	s := &SpamScorer{
+		rules:     append([]spamRule{}, builtinSpamRules...),
+		threshold: cfg.Threshold,
+		filterURL: cfg.FilterURL,
+		client:    &http.Client{Timeout: cfg.FilterTimeout},
+	}
+	for _, rule := range cfg.Rules {
+		s.rules = append(s.rules, spamRule{
+			name:    rule.Name,
+			field:   strings.ToLower(rule.Field),
+			pattern: regexp.MustCompile(rule.Pattern),
+			score:   rule.Score,
+		})
+	}
+
+	logger.Logger.Info("スパム・ノイズ判定を設定しました",
+		zap.Float64("threshold", s.threshold),
+		zap.Int("custom_rules", len(cfg.Rules)),
+		zap.Bool("external_filter", s.filterURL != ""))
+	return s
+}
+
+// Score はルールと外部フィルターでメールのスコアを計算します。
+// 外部フィルターの呼び出しに失敗した場合はルールのスコアだけで判定します（受信は止めない）
+func (s *SpamScorer) Score(ctx context.Context, emailData *models.EmailData) SpamVerdict {
+	if s == nil {
+		return SpamVerdict{}
+	}
+
+	verdict := SpamVerdict{Threshold: s.threshold}
+	header := parseHeader(emailData.RawHeader)
+	for _, rule := range s.rules {
+		if rule.pattern.MatchString(spamField(emailData, header, rule.field)) {
+			verdict.Score += rule.score
+			verdict.Reasons = append(verdict.Reasons, rule.name)
+		}
+	}
+
+	if s.filterURL != "" {
+		score, reasons, err := s.callFilter(ctx, emailData)
+		if err != nil {
+			logger.Logger.Warn("外部のスパムフィルターの呼び出しに失敗したためルールのみで判定します", zap.Error(err))
+		} else {
+			verdict.Score += score
+			for _, reason := range reasons {
+				verdict.Reasons = append(verdict.Reasons, "filter:"+reason)
+			}
+		}
+	}
+
+	verdict.Quarantine = verdict.Score >= s.threshold
+	if verdict.Quarantine {
+		spamVerdicts.Inc("quarantined")
+	} else {
+		spamVerdicts.Inc("passed")
+	}
+	return verdict
+}
+
+// parseHeader は受信したRFC822のヘッダー部分を読み取ります。ヘッダーがない場合は空のヘッダーを返します
+func parseHeader(raw []byte) mail.Header {
+	if len(raw) == 0 {
+		return mail.Header{}
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return mail.Header{}
+	}
+	return msg.Header
+}
+
+func spamField(emailData *models.EmailData, header mail.Header, field string) string {
+	switch field {
+	case "subject":
+		return emailData.Subject
+	case "from":
+		return emailData.From
+	case "body":
+		return emailData.Body
+	}
+	return header.Get(strings.TrimPrefix(field, "header:"))
+}
+
+// callFilter は外部のスパムフィルター（SPAM_FILTER_URL）にメールを送り、スコアと理由を受け取ります。
+// SPAM_FILTER_TOKEN が設定されている場合は Bearer トークンとして送信します
+func (s *SpamScorer) callFilter(ctx context.Context, emailData *models.EmailData) (float64, []string, error) {
+	body, err := json.Marshal(map[string]string{
+		"from":    emailData.From,
+		"to":      emailData.To,
+		"subject": emailData.Subject,
+		"body":    emailData.Body,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.filterURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := secrets.Get("SPAM_FILTER_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return 0, nil, fmt.Errorf("spam filter returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var result struct {
+		Score   float64  `json:"score"`
+		Reasons []string `json:"reasons"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, nil, fmt.Errorf("failed to decode spam filter response: %v", err)
+	}
+	return result.Score, result.Reasons, nil
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"autopilot/config"
+	"autopilot/models"
+)
+
+func newTestSpamScorer(filterURL string, rules ...config.SpamRule) *SpamScorer {
+	return NewSpamScorer(config.SpamConfig{
+		Enabled:       true,
+		Threshold:     5,
+		Rules:         rules,
+		FilterURL:     filterURL,
+		FilterTimeout: time.Second,
+	})
+}
+
+func TestSpamScorerDisabled(t *testing.T) {
+	s := NewSpamScorer(config.SpamConfig{Enabled: false})
+	if s != nil {
+		t.Fatal("NewSpamScorer returned a scorer while disabled")
+	}
+
+	// 無効な場合はすべて通過させる
+	email := &models.EmailData{Subject: "Automatic reply: 不在のお知らせ"}
+	if verdict := s.Score(context.Background(), email); verdict.Quarantine || verdict.Score != 0 {
+		t.Errorf("verdict = %+v, want passed", verdict)
+	}
+}
+
+func TestSpamScorerRules(t *testing.T) {
+	s := newTestSpamScorer("")
+
+	tests := []struct {
+		name       string
+		email      models.EmailData
+		quarantine bool
+		reasons    string
+	}{
+		{
+			name:    "monitoring alert",
+			email:   models.EmailData{Subject: "[ALERT] web01 down", RawHeader: []byte("Auto-Submitted: auto-generated\r\n\r\n")},
+			reasons: "",
+		},
+		{
+			name:       "auto reply header",
+			email:      models.EmailData{Subject: "Re: 障害のお問い合わせ", RawHeader: []byte("Auto-Submitted: auto-replied\r\n\r\n")},
+			quarantine: true,
+			reasons:    "auto_replied",
+		},
+		{
+			name:       "out of office subject",
+			email:      models.EmailData{Subject: "自動返信: 不在のお知らせ"},
+			quarantine: true,
+			reasons:    "out_of_office",
+		},
+		{
+			// 一括配信のヘッダーだけではしきい値に届かない
+			name:    "bulk mail",
+			email:   models.EmailData{Subject: "月次レポート", RawHeader: []byte("List-Unsubscribe: <mailto:unsubscribe@example.com>\r\n\r\n")},
+			reasons: "list_unsubscribe",
+		},
+		{
+			name: "newsletter",
+			email: models.EmailData{
+				Subject:   "ウェビナーのご案内",
+				RawHeader: []byte("List-Unsubscribe: <mailto:unsubscribe@example.com>\r\nPrecedence: bulk\r\n\r\n"),
+			},
+			quarantine: true,
+			reasons:    "list_unsubscribe,bulk_precedence,newsletter_subject",
+		},
+		{
+			name:    "malformed header",
+			email:   models.EmailData{Subject: "サーバーが応答しません", RawHeader: []byte("not a header")},
+			reasons: "",
+		},
+	}
+	for _, tt := range tests {
+		verdict := s.Score(context.Background(), &tt.email)
+		if verdict.Quarantine != tt.quarantine {
+			t.Errorf("%s: quarantine = %v, want %v (verdict %+v)", tt.name, verdict.Quarantine, tt.quarantine, verdict)
+		}
+		if got := strings.Join(verdict.Reasons, ","); got != tt.reasons {
+			t.Errorf("%s: reasons = %q, want %q", tt.name, got, tt.reasons)
+		}
+		if verdict.Threshold != 5 {
+			t.Errorf("%s: threshold = %v, want 5", tt.name, verdict.Threshold)
+		}
+	}
+}
+
+func TestSpamScorerCustomRules(t *testing.T) {
+	s := newTestSpamScorer("",
+		config.SpamRule{Name: "vendor_marketing", Field: "From", Pattern: `@marketing\.example\.com$`, Score: 4},
+		config.SpamRule{Name: "campaign_header", Field: "header:X-Campaign-ID", Pattern: `.`, Score: 1},
+	)
+
+	// 追加のルールは組み込みのルールと合算する（フィールド名は大文字小文字を区別しない）
+	email := &models.EmailData{
+		From:      "news@marketing.example.com",
+		Subject:   "新製品のお知らせ",
+		RawHeader: []byte("X-Campaign-ID: 2026-10\r\n\r\n"),
+	}
+	verdict := s.Score(context.Background(), email)
+	if !verdict.Quarantine || verdict.Score != 5 {
+		t.Errorf("verdict = %+v, want quarantined with score 5", verdict)
+	}
+	if got := strings.Join(verdict.Reasons, ","); got != "vendor_marketing,campaign_header" {
+		t.Errorf("reasons = %q", got)
+	}
+	if want := "spam score 5.0 >= 5.0: vendor_marketing, campaign_header"; verdict.String() != want {
+		t.Errorf("String() = %q, want %q", verdict.String(), want)
+	}
+}
+
+func TestSpamScorerExternalFilter(t *testing.T) {
+	t.Setenv("SPAM_FILTER_TOKEN", "filter-token")
+
+	var authorization string
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(`{"score": 3.5, "reasons": ["phishing_link"]}`))
+	}))
+	defer server.Close()
+
+	// 外部フィルターのスコアはルールのスコアに加算し、理由には filter: を付ける
+	s := newTestSpamScorer(server.URL)
+	email := &models.EmailData{
+		From:      "unknown@example.net",
+		To:        "support@example.com",
+		Subject:   "アカウントの確認",
+		Body:      "こちらのリンクからログインしてください",
+		RawHeader: []byte("List-Unsubscribe: <mailto:unsubscribe@example.net>\r\n\r\n"),
+	}
+	verdict := s.Score(context.Background(), email)
+	if !verdict.Quarantine || verdict.Score != 6.5 {
+		t.Errorf("verdict = %+v, want quarantined with score 6.5", verdict)
+	}
+	if got := strings.Join(verdict.Reasons, ","); got != "list_unsubscribe,filter:phishing_link" {
+		t.Errorf("reasons = %q", got)
+	}
+	if authorization != "Bearer filter-token" {
+		t.Errorf("Authorization = %q, want Bearer filter-token", authorization)
+	}
+	if received["from"] != email.From || received["to"] != email.To || received["subject"] != email.Subject || received["body"] != email.Body {
+		t.Errorf("filter received %+v", received)
+	}
+}
+
+func TestSpamScorerFilterFailureFallsBackToRules(t *testing.T) {
+	tests := map[string]http.HandlerFunc{
+		"error status": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		},
+		"invalid response": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`not json`))
+		},
+		"timeout": func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		},
+	}
+	for name, handler := range tests {
+		server := httptest.NewServer(handler)
+
+		// 外部フィルターに失敗しても受信は止めず、ルールのスコアだけで判定する
+		s := newTestSpamScorer(server.URL)
+		s.client.Timeout = 100 * time.Millisecond
+		verdict := s.Score(context.Background(), &models.EmailData{Subject: "Out of Office: 10/20まで不在です"})
+		if !verdict.Quarantine || verdict.Score != 5 || strings.Join(verdict.Reasons, ",") != "out_of_office" {
+			t.Errorf("%s: verdict = %+v, want rules only", name, verdict)
+		}
+
+		alert := &models.EmailData{From: "monitor@example.com", Subject: "サーバーが応答しません"}
+		verdict = s.Score(context.Background(), alert)
+		if verdict.Quarantine || len(verdict.Reasons) != 0 {
+			t.Errorf("%s: alert verdict = %+v, want passed", name, verdict)
+		}
+		server.Close()
+	}
+}
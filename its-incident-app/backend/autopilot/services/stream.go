@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+// responseModeStreaming はワークフローにイベントを逐次返させる response_mode
+const responseModeStreaming = "streaming"
+
+// maxStreamEventSize はストリーミング応答の1イベント（data 行）の上限
+const maxStreamEventSize = 4 * 1024 * 1024
+
+// errAIStreamStalled はストリーミング応答のイベントが IdleTimeout の間届かなかったことを表します（再試行の対象）
+var errAIStreamStalled = errors.New("AI stream stalled")
+
+// partialOutputKeys は途中結果として処理状態に保存するワークフローの出力
+var partialOutputKeys = []string{"subject", "judgment", "priority", "incident", "host", "place", "sender", "final"}
+
+// PartialFunc はストリーミング中に途中結果が更新されるたびに呼び出されます。outputs は呼び出しごとのコピーです
+type PartialFunc func(outputs map[string]string)
+
+// readStream はSSE形式のストリーミング応答を読み取り、workflow_finished イベントからAI応答を組み立てます。
+// イベントを受け取るたびに idle をリセットし、ノードの出力に途中結果があれば onPartial に渡します。
+// 受け取ったイベント数も返します（タイムアウトの原因の切り分け用）
+func (s *AIService) readStream(body io.Reader, idle *time.Timer, onPartial PartialFunc) (*models.AIResponse, int, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamEventSize)
+
+	partial := make(map[string]string)
+	events := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		idle.Reset(s.streaming.IdleTimeout)
+		events++
+
+		var event models.AIStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			return nil, events, fmt.Errorf("failed to decode AI stream event: %v", err)
+		}
+
+		switch event.Event {
+		case "node_finished":
+			var node struct {
+				Outputs map[string]interface{} `json:"outputs"`
+			}
+			if err := json.Unmarshal(event.Data, &node); err != nil {
+				logger.Logger.Debug("ノードの出力のデコードに失敗しました", zap.Error(err))
+				continue
+			}
+			if mergePartialOutputs(partial, node.Outputs) && onPartial != nil {
+				copied := make(map[string]string, len(partial))
+				for key, value := range partial {
+					copied[key] = value
+				}
+				onPartial(copied)
+			}
+		case "workflow_finished":
+			aiResponse := &models.AIResponse{
+				TaskID:        event.TaskID,
+				WorkflowRunID: event.WorkflowRunID,
+			}
+			if err := json.Unmarshal(event.Data, &aiResponse.Data); err != nil {
+				return nil, events, fmt.Errorf("failed to decode AI response: %v", err)
+			}
+			if err := s.mapOutputs(event.Data, &aiResponse.Data); err != nil {
+				return nil, events, err
+			}
+			return aiResponse, events, nil
+		case "error":
+			return nil, events, fmt.Errorf("AI stream returned an error: %s", event.Message)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, events, err
+	}
+	return nil, events, fmt.Errorf("AI stream ended without workflow_finished")
+}
+
+// mergePartialOutputs はノードの出力のうち途中結果として保存する値を partial に反映し、変化があれば true を返します
+func mergePartialOutputs(partial map[string]string, outputs map[string]interface{}) bool {
+	changed := false
+	for _, key := range partialOutputKeys {
+		value, ok := outputs[key].(string)
+		if !ok || value == "" || partial[key] == value {
+			continue
+		}
+		partial[key] = value
+		changed = true
+	}
+	return changed
+}
+
+// streamError はストリーミングの失敗を、イベントが途絶えた（停止）か、進行中に期限に達したかで区別したエラーにします
+func (s *AIService) streamError(streamCtx, ctx context.Context, err error, events int) error {
+	if errors.Is(context.Cause(streamCtx), errAIStreamStalled) && ctx.Err() == nil {
+		return fmt.Errorf("%w: no event for %s after %d events", errAIStreamStalled, s.streaming.IdleTimeout, events)
+	}
+	if ctx.Err() != nil && events > 0 {
+		return fmt.Errorf("AI stream was still progressing (%d events) when the deadline was reached: %w", events, ctx.Err())
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"autopilot/config"
+	"autopilot/models"
+)
+
+// newStreamingAIService は handler をストリーミング応答のAIプロバイダーとして使うAIServiceを作成します（再試行なし）
+func newStreamingAIService(t *testing.T, handler http.HandlerFunc) *AIService {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	t.Setenv("TEST_AI_TOKEN", "ai-token")
+
+	s := NewAIService([]config.AIProviderConfig{{Name: "primary", Endpoint: server.URL, TokenEnv: "TEST_AI_TOKEN"}},
+		config.AIWorkflowConfig{Version: "v1"}, config.AIShadowConfig{}, config.AIRetryConfig{MaxAttempts: 1}, 0, 0, nil)
+	s.SetStreaming(config.AIStreamingConfig{Enabled: true, IdleTimeout: 100 * time.Millisecond})
+	return s
+}
+
+// writeEvents はSSEのイベントを1つずつ送信します
+func writeEvents(w http.ResponseWriter, events ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, event := range events {
+		fmt.Fprintf(w, "data: %s\n\n", event)
+		w.(http.Flusher).Flush()
+	}
+}
+
+func TestProcessEmailStreamReportsPartialOutputs(t *testing.T) {
+	var responseMode string
+	s := newStreamingAIService(t, func(w http.ResponseWriter, r *http.Request) {
+		var payload models.APIPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		responseMode = payload.ResponseMode
+
+		writeEvents(w,
+			`{"event": "workflow_started", "task_id": "task-1"}`,
+			`{"event": "node_finished", "data": {"outputs": {"subject": "web01 停止"}}}`,
+			`{"event": "node_finished", "data": {"outputs": {"subject": "web01 停止", "text": "ignored"}}}`,
+			`{"event": "node_finished", "data": {"outputs": {"judgment": "要対応", "priority": 1}}}`,
+			`{"event": "workflow_finished", "task_id": "task-1", "workflow_run_id": "run-1", "data": {"status": "succeeded", "outputs": {"judgment": "要対応"}}}`,
+		)
+	})
+
+	var partials []map[string]string
+	resp, err := s.ProcessEmailStream(context.Background(), "msg-1", &models.EmailData{}, func(outputs map[string]string) {
+		partials = append(partials, outputs)
+	})
+	if err != nil {
+		t.Fatalf("ProcessEmailStream: %v", err)
+	}
+	if responseMode != responseModeStreaming {
+		t.Errorf("response_mode = %q, want %q", responseMode, responseModeStreaming)
+	}
+	if resp.TaskID != "task-1" || resp.WorkflowRunID != "run-1" || resp.Data.Outputs.Judgment != "要対応" || resp.Provider != "primary" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	// 途中結果は保存対象の値が変わった場合だけ通知し、それまでの値も含める
+	if len(partials) != 2 || partials[0]["subject"] != "web01 停止" || partials[1]["subject"] != "web01 停止" || partials[1]["judgment"] != "要対応" {
+		t.Errorf("partials = %v", partials)
+	}
+	if _, ok := partials[0]["judgment"]; ok {
+		t.Errorf("earlier partial was modified: %v", partials[0])
+	}
+}
+
+func TestProcessEmailStreamFailures(t *testing.T) {
+	tests := map[string]struct {
+		events []string
+		want   string
+	}{
+		"error event":     {[]string{`{"event": "error", "message": "workflow crashed"}`}, "workflow crashed"},
+		"no finish event": {[]string{`{"event": "workflow_started"}`}, "without workflow_finished"},
+		"invalid event":   {[]string{`not json`}, "failed to decode AI stream event"},
+	}
+	for name, tt := range tests {
+		s := newStreamingAIService(t, func(w http.ResponseWriter, r *http.Request) {
+			writeEvents(w, tt.events...)
+		})
+		if _, err := s.ProcessEmailStream(context.Background(), "msg-1", &models.EmailData{}, nil); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", name, err, tt.want)
+		}
+	}
+}
+
+func TestProcessEmailStreamRetriesStalledStream(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	release := make(chan struct{})
+	defer close(release)
+	s := newStreamingAIService(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+
+		if first {
+			// イベントを1つ送った後に応答が止まる
+			writeEvents(w, `{"event": "workflow_started", "task_id": "task-1"}`)
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		writeEvents(w, `{"event": "workflow_finished", "task_id": "task-1", "data": {"status": "succeeded"}}`)
+	})
+	s.SetRetryPolicy(NewExponentialBackoff(config.AIRetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond}))
+
+	// イベントが IdleTimeout の間届かない場合は停止とみなし、再試行する
+	resp, err := s.ProcessEmailStream(context.Background(), "msg-1", &models.EmailData{}, nil)
+	if err != nil || resp.TaskID != "task-1" {
+		t.Fatalf("ProcessEmailStream = %+v, %v, want success after a retry", resp, err)
+	}
+	mu.Lock()
+	if calls != 2 {
+		t.Errorf("provider called %d times, want 2", calls)
+	}
+	calls = 0
+	mu.Unlock()
+
+	s.SetRetryPolicy(nil)
+	if _, err := s.ProcessEmailStream(context.Background(), "msg-2", &models.EmailData{}, nil); err == nil || !strings.Contains(err.Error(), errAIStreamStalled.Error()) {
+		t.Errorf("error = %v, want %v", err, errAIStreamStalled)
+	}
+}
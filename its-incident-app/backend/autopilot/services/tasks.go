@@ -0,0 +1,161 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/secrets"
+	"autopilot/serviceauth"
+	"shared/idtoken"
+
+	"go.uber.org/zap"
+)
+
+const cloudTasksBaseURL = "https://cloudtasks.googleapis.com/v2/"
+
+// TaskPayload はAI処理タスクのリクエストボディ
+type TaskPayload struct {
+	MessageID string `json:"message_id"`
+	// EnqueuedAt はタスクを登録した時刻（再試行を含めた処理時間の計測用）
+	EnqueuedAt time.Time `json:"enqueued_at,omitempty"`
+}
+
+// TaskQueueService はAI処理をCloud Tasksのキューに登録します。
+// タスクは targetURL（autopilot自身の /tasks/process-email）へ配信され、成功するまでキューの設定に従って再試行されます
+type TaskQueueService struct {
+	queue          string
+	targetURL      string
+	serviceAccount string
+	maxAttempts    int
+	client         *http.Client
+}
+
+// NewTaskQueueService はキュー（projects/.../locations/.../queues/...）のTaskQueueServiceを作成します。
+// serviceAccount を指定した場合、タスクにそのサービスアカウントのOIDCトークンを付与します
+func NewTaskQueueService(queue, targetURL, serviceAccount string, maxAttempts int) *TaskQueueService {
+	service := &TaskQueueService{
+		queue:          strings.TrimSuffix(queue, "/"),
+		targetURL:      targetURL,
+		serviceAccount: serviceAccount,
+		maxAttempts:    maxAttempts,
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}
+
+	logger.Logger.Info("Cloud Tasksキューを初期化しました",
+		zap.String("queue", service.queue),
+		zap.String("target_url", targetURL),
+		zap.Bool("has_service_account", serviceAccount != ""),
+		zap.Int("max_attempts", maxAttempts),
+	)
+
+	return service
+}
+
+// MaxAttempts はキューに設定した最大試行回数を返します（最終試行の判定に使用）
+func (s *TaskQueueService) MaxAttempts() int {
+	return s.maxAttempts
+}
+
+// EnqueueAIProcessing はメッセージのAI処理タスクを登録します。
+// タスク名はメッセージIDから決めるため、同じメッセージの重複登録は1件にまとめられます。
+// 再処理など意図的に再登録する場合は dedupKey に試行ごとに異なる値を指定します
+func (s *TaskQueueService) EnqueueAIProcessing(ctx context.Context, messageID, dedupKey string) error {
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("operation", "EnqueueAIProcessing"),
+		zap.String("dedup_key", dedupKey),
+	}
+
+	body, err := json.Marshal(TaskPayload{MessageID: messageID, EnqueuedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal task payload: %v", err)
+	}
+
+	httpRequest := map[string]interface{}{
+		"httpMethod": "POST",
+		"url":        s.targetURL,
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body":       body,
+	}
+	if s.serviceAccount != "" {
+		httpRequest["oidcToken"] = map[string]string{
+			"serviceAccountEmail": s.serviceAccount,
+			"audience":            idtoken.AudienceFor(s.targetURL),
+		}
+	} else if token := serviceauth.PrimaryServiceToken(); token != "" {
+		httpRequest["headers"].(map[string]string)["Authorization"] = "Bearer " + token
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"task": map[string]interface{}{
+			"name":        s.taskName(messageID, dedupKey),
+			"httpRequest": httpRequest,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal task: %v", err)
+	}
+
+	baseURL := cloudTasksBaseURL
+	emulator := os.Getenv("CLOUD_TASKS_EMULATOR_HOST")
+	if emulator != "" {
+		baseURL = "http://" + emulator + "/v2/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+s.queue+"/tasks", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if emulator == "" {
+		token, err := secrets.AccessToken()
+		if err != nil {
+			return fmt.Errorf("failed to get access token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Logger.Error("タスクの登録に失敗しました", append(logFields, zap.Error(err))...)
+		return fmt.Errorf("failed to create task: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		logger.Logger.Debug("AI処理タスクを登録しました", logFields...)
+		return nil
+	case http.StatusConflict:
+		// 同じメッセージのタスクが登録済み（または直近に実行済み）
+		logger.Logger.Info("AI処理タスクは登録済みです", logFields...)
+		return nil
+	default:
+		logger.Logger.Error("タスクの登録でエラーが発生しました",
+			append(logFields,
+				zap.Int("status_code", resp.StatusCode),
+				zap.String("response_body", string(respBody)))...)
+		return fmt.Errorf("failed to create task, status: %d, response: %s", resp.StatusCode, string(respBody))
+	}
+}
+
+// taskName はメッセージIDからタスク名を生成します（タスクIDに使えない文字を含むためハッシュ化）
+func (s *TaskQueueService) taskName(messageID, dedupKey string) string {
+	key := messageID
+	if dedupKey != "" {
+		key += "/" + dedupKey
+	}
+	sum := sha256.Sum256([]byte(key))
+	return s.queue + "/tasks/ai-" + hex.EncodeToString(sum[:16])
+}
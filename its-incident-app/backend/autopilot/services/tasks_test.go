@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testQueue = "projects/test-project/locations/asia-northeast1/queues/autopilot"
+
+// fakeCloudTasks はタスクの登録を記録するCloud Tasksエミュレーターの代わりです。
+// 同じ名前のタスクの再登録には409を返します
+type fakeCloudTasks struct {
+	mu     sync.Mutex
+	paths  []string
+	tasks  map[string]map[string]interface{}
+	status int
+}
+
+func newFakeCloudTasks(t *testing.T) *fakeCloudTasks {
+	t.Helper()
+	f := &fakeCloudTasks{tasks: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(server.Close)
+	t.Setenv("CLOUD_TASKS_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))
+	t.Setenv("SERVICE_TOKEN", "")
+	return f
+}
+
+func (f *fakeCloudTasks) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Task map[string]interface{} `json:"task"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paths = append(f.paths, r.Method+" "+r.URL.Path)
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		w.Write([]byte(`{"error":{"message":"unavailable"}}`))
+		return
+	}
+	name, _ := body.Task["name"].(string)
+	if _, ok := f.tasks[name]; ok {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	f.tasks[name] = body.Task
+	json.NewEncoder(w).Encode(body.Task)
+}
+
+// onlyTask は登録された唯一のタスクを返します
+func (f *fakeCloudTasks) onlyTask(t *testing.T) map[string]interface{} {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.tasks) != 1 {
+		t.Fatalf("tasks = %d, want 1", len(f.tasks))
+	}
+	for _, task := range f.tasks {
+		return task
+	}
+	return nil
+}
+
+func (f *fakeCloudTasks) taskCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.tasks)
+}
+
+func TestEnqueueAIProcessing(t *testing.T) {
+	fake := newFakeCloudTasks(t)
+	s := NewTaskQueueService(testQueue+"/", "https://autopilot.example.run.app/tasks/process-email", "tasks@test-project.iam.gserviceaccount.com", 5)
+
+	if err := s.EnqueueAIProcessing(context.Background(), "<msg-1@example.com>", ""); err != nil {
+		t.Fatalf("EnqueueAIProcessing: %v", err)
+	}
+	if len(fake.paths) != 1 || fake.paths[0] != "POST /v2/"+testQueue+"/tasks" {
+		t.Fatalf("unexpected requests: %v", fake.paths)
+	}
+
+	task := fake.onlyTask(t)
+	name, _ := task["name"].(string)
+	if !strings.HasPrefix(name, testQueue+"/tasks/ai-") {
+		t.Errorf("task name = %q, want it under %s/tasks/", name, testQueue)
+	}
+
+	httpRequest, _ := task["httpRequest"].(map[string]interface{})
+	if httpRequest["url"] != "https://autopilot.example.run.app/tasks/process-email" || httpRequest["httpMethod"] != "POST" {
+		t.Errorf("unexpected httpRequest: %+v", httpRequest)
+	}
+	oidc, _ := httpRequest["oidcToken"].(map[string]interface{})
+	if oidc["serviceAccountEmail"] != "tasks@test-project.iam.gserviceaccount.com" || oidc["audience"] != "https://autopilot.example.run.app" {
+		t.Errorf("unexpected oidcToken: %+v", oidc)
+	}
+
+	// body はJSONのバイト列のためbase64で送られる
+	encoded, _ := httpRequest["body"].(string)
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decode task body: %v", err)
+	}
+	var payload TaskPayload
+	if err := json.Unmarshal(decoded, &payload); err != nil {
+		t.Fatalf("unmarshal task payload: %v", err)
+	}
+	if payload.MessageID != "<msg-1@example.com>" || payload.EnqueuedAt.IsZero() {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestEnqueueAIProcessingDeduplicates(t *testing.T) {
+	fake := newFakeCloudTasks(t)
+	s := NewTaskQueueService(testQueue, "https://autopilot.example.run.app/tasks/process-email", "", 5)
+	ctx := context.Background()
+
+	// 同じメッセージの重複登録（409）は成功として扱い、タスクは1件にまとめる
+	for i := 0; i < 2; i++ {
+		if err := s.EnqueueAIProcessing(ctx, "msg-1", ""); err != nil {
+			t.Fatalf("EnqueueAIProcessing #%d: %v", i+1, err)
+		}
+	}
+	if got := fake.taskCount(); got != 1 {
+		t.Errorf("tasks = %d, want 1", got)
+	}
+
+	// 再処理のように dedupKey を変えた場合は別のタスクとして登録する
+	if err := s.EnqueueAIProcessing(ctx, "msg-1", "reprocess-1"); err != nil {
+		t.Fatalf("EnqueueAIProcessing with dedup key: %v", err)
+	}
+	if err := s.EnqueueAIProcessing(ctx, "msg-2", ""); err != nil {
+		t.Fatalf("EnqueueAIProcessing msg-2: %v", err)
+	}
+	if got := fake.taskCount(); got != 3 {
+		t.Errorf("tasks = %d, want 3", got)
+	}
+}
+
+func TestEnqueueAIProcessingReturnsError(t *testing.T) {
+	fake := newFakeCloudTasks(t)
+	fake.status = http.StatusServiceUnavailable
+	s := NewTaskQueueService(testQueue, "https://autopilot.example.run.app/tasks/process-email", "", 5)
+
+	if err := s.EnqueueAIProcessing(context.Background(), "msg-1", ""); err == nil {
+		t.Error("EnqueueAIProcessing succeeded while Cloud Tasks was unavailable")
+	}
+}
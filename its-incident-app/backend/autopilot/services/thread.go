@@ -0,0 +1,60 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"autopilot/logger"
+	"autopilot/models"
+
+	"go.uber.org/zap"
+)
+
+// ThreadEmail は保存済みのメールが既存のインシデントへの返信かをdbpilotで判定し、返信であればインシデントの対応履歴として追加します。
+// subjectWindow が 0 の場合は件名による判定を行いません
+func (s *DBPilotService) ThreadEmail(messageID string, subjectWindow time.Duration) (*models.ThreadMatch, error) {
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("operation", "ThreadEmail"),
+	}
+
+	jsonData, err := json.Marshal(map[string]int{"subject_window_seconds": int(subjectWindow / time.Second)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal thread request: %v", err)
+	}
+
+	req, err := s.createRequest("POST", "/emails/"+url.PathEscape(messageID)+"/thread", jsonData)
+	if err != nil {
+		logger.Logger.Error("リクエストの作成に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Logger.Error("スレッドの判定に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to thread email: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		logger.Logger.Error("スレッドの判定でエラーが発生しました",
+			append(logFields,
+				zap.Int("status_code", resp.StatusCode),
+				zap.String("response_body", string(respBody)))...)
+		return nil, fmt.Errorf("failed to thread email, status: %d, response: %s",
+			resp.StatusCode, string(respBody))
+	}
+
+	var match models.ThreadMatch
+	if err := json.NewDecoder(resp.Body).Decode(&match); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return &match, nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThreadEmail(t *testing.T) {
+	var path string
+	var body map[string]int
+	s := newTestDBPilotService(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.Method + " " + r.URL.EscapedPath()
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"incident_id": 12, "matched_by": "references", "response_id": 3}`))
+	})
+
+	match, err := s.ThreadEmail("<reply/1@example.com>", 72*time.Hour)
+	if err != nil {
+		t.Fatalf("ThreadEmail: %v", err)
+	}
+	if path != "POST /emails/%3Creply%2F1@example.com%3E/thread" {
+		t.Errorf("request = %q, want the message ID escaped in the path", path)
+	}
+	// 件名で返信元を探す期間は秒で送る
+	if body["subject_window_seconds"] != 72*60*60 {
+		t.Errorf("subject_window_seconds = %d, want %d", body["subject_window_seconds"], 72*60*60)
+	}
+	if match.IncidentID != 12 || match.MatchedBy != "references" || match.ResponseID != 3 {
+		t.Errorf("unexpected match: %+v", match)
+	}
+}
+
+func TestThreadEmailReturnsError(t *testing.T) {
+	s := newTestDBPilotService(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "email not found", http.StatusNotFound)
+	})
+
+	if _, err := s.ThreadEmail("msg-1", 0); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("ThreadEmail error = %v, want the status code", err)
+	}
+}
@@ -2,14 +2,21 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"autopilot/logger"
 	"autopilot/models"
+	"autopilot/mtls"
+	"autopilot/serviceauth"
+	"shared/idtoken"
 
 	"go.uber.org/zap"
 )
@@ -113,10 +120,16 @@ func (s *DBPilotService) SaveIncident(aiResponse *models.AIResponse, messageID s
 	}
 
 	payload := struct {
-		TaskID        string `json:"task_id"`
-		WorkflowRunID string `json:"workflow_run_id"`
-		MessageID     string `json:"message_id"`
-		Data          struct {
+		TaskID              string `json:"task_id"`
+		WorkflowRunID       string `json:"workflow_run_id"`
+		MessageID           string `json:"message_id"`
+		Provider            string `json:"provider,omitempty"`
+		WorkflowVersion     string `json:"workflow_version,omitempty"`
+		Language            string `json:"language,omitempty"`
+		ContentHash         string `json:"content_hash,omitempty"`
+		CacheHit            bool   `json:"cache_hit,omitempty"`
+		CachedFromMessageID string `json:"cached_from_message_id,omitempty"`
+		Data                struct {
 			ID         string `json:"id"`
 			WorkflowID string `json:"workflow_id"`
 			Status     string `json:"status"`
@@ -144,10 +157,16 @@ func (s *DBPilotService) SaveIncident(aiResponse *models.AIResponse, messageID s
 			FinishedAt  int64       `json:"finished_at"`
 		} `json:"data"`
 	}{
-		TaskID:        aiResponse.TaskID,
-		WorkflowRunID: aiResponse.WorkflowRunID,
-		MessageID:     messageID,
-		Data:          aiResponse.Data,
+		TaskID:              aiResponse.TaskID,
+		WorkflowRunID:       aiResponse.WorkflowRunID,
+		MessageID:           messageID,
+		Provider:            aiResponse.Provider,
+		WorkflowVersion:     aiResponse.WorkflowVersion,
+		Language:            aiResponse.Language,
+		ContentHash:         aiResponse.ContentHash,
+		CacheHit:            aiResponse.CacheHit,
+		CachedFromMessageID: aiResponse.CachedFromMessageID,
+		Data:                aiResponse.Data,
 	}
 
 	// デバッグログ: ペイロードの詳細
@@ -202,13 +221,22 @@ func (s *DBPilotService) SaveIncident(aiResponse *models.AIResponse, messageID s
 	return nil
 }
 
+// currentServiceToken はSecret Managerでローテーションされた最新のサービストークンを返します
+func (s *DBPilotService) currentServiceToken() string {
+	if token := serviceauth.PrimaryServiceToken(); token != "" {
+		return token
+	}
+	return s.serviceToken
+}
+
 func (s *DBPilotService) createRequest(method, path string, payload []byte) (*http.Request, error) {
 	if s.baseURL == "" {
 		logger.Logger.Error("DBPilot URLが設定されていません")
 		return nil, fmt.Errorf("DBPilot URL is not set")
 	}
 
-	if s.serviceToken == "" {
+	serviceToken := s.currentServiceToken()
+	if serviceToken == "" && !idtoken.IDTokenEnabled() && !mtls.Enabled() {
 		logger.Logger.Error("サービストークンが設定されていません")
 		return nil, fmt.Errorf("service token is not set")
 	}
@@ -223,11 +251,36 @@ func (s *DBPilotService) createRequest(method, path string, payload []byte) (*ht
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.serviceToken)
+	// 相互TLSのみで認証する場合はAuthorizationヘッダーを付与しない
+	if token := idtoken.BearerToken(s.baseURL, serviceToken); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 
 	return req, nil
 }
 
+// Ping はdbpilotの /health にリクエストし、疎通できない場合や200以外の場合にエラーを返します
+func (s *DBPilotService) Ping(ctx context.Context) error {
+	if s.baseURL == "" {
+		return fmt.Errorf("DBPilot URL is not set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(s.baseURL, "/")+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
 func (s *DBPilotService) GetProcessingStatus(messageID string) (*models.ProcessingStatus, error) {
 	logFields := []zap.Field{
 		zap.String("message_id", messageID),
@@ -287,11 +340,25 @@ func (s *DBPilotService) GetProcessingStatus(messageID string) (*models.Processi
 }
 
 func (s *DBPilotService) UpdateProcessingStatus(status *models.ProcessingStatus) error {
+	return s.putProcessingStatus(status, "")
+}
+
+// TransitionProcessingStatus は dbpilot の expected_status で、現在の状態が from の場合だけ処理状態を更新します
+func (s *DBPilotService) TransitionProcessingStatus(from models.ProcessStatus, status *models.ProcessingStatus) error {
+	return s.putProcessingStatus(status, from)
+}
+
+func (s *DBPilotService) putProcessingStatus(status *models.ProcessingStatus, expected models.ProcessStatus) error {
 	logFields := []zap.Field{
 		zap.String("message_id", status.MessageID),
 		zap.String("operation", "UpdateProcessingStatus"),
 		zap.String("status", string(status.Status)),
 	}
+	path := fmt.Sprintf("/status/%s", status.MessageID)
+	if expected != "" {
+		logFields = append(logFields, zap.String("expected_status", string(expected)))
+		path += "?expected_status=" + url.QueryEscape(string(expected))
+	}
 
 	jsonData, err := json.Marshal(status)
 	if err != nil {
@@ -300,7 +367,7 @@ func (s *DBPilotService) UpdateProcessingStatus(status *models.ProcessingStatus)
 		return fmt.Errorf("failed to marshal status: %v", err)
 	}
 
-	req, err := s.createRequest("PUT", fmt.Sprintf("/status/%s", status.MessageID), jsonData)
+	req, err := s.createRequest("PUT", path, jsonData)
 	if err != nil {
 		logger.Logger.Error("リクエストの作成に失敗しました",
 			append(logFields, zap.Error(err))...)
@@ -318,6 +385,13 @@ func (s *DBPilotService) UpdateProcessingStatus(status *models.ProcessingStatus)
 	}
 	defer resp.Body.Close()
 
+	switch {
+	case expected != "" && resp.StatusCode == http.StatusConflict:
+		return ErrStatusConflict
+	case expected != "" && resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("processing status not found for message_id: %s", status.MessageID)
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
 		logger.Logger.Error("処理状態の更新でエラーが発生しました",
@@ -332,3 +406,128 @@ func (s *DBPilotService) UpdateProcessingStatus(status *models.ProcessingStatus)
 	logger.Logger.Debug("処理状態を更新しました", logFields...)
 	return nil
 }
+
+func (s *DBPilotService) GetEmail(messageID string) (*models.EmailData, error) {
+	logFields := []zap.Field{
+		zap.String("message_id", messageID),
+		zap.String("operation", "GetEmail"),
+	}
+
+	req, err := s.createRequest("GET", fmt.Sprintf("/emails/%s", messageID), nil)
+	if err != nil {
+		logger.Logger.Error("リクエストの作成に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Logger.Error("メールデータの取得に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to get email: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("email not found for message_id: %s", messageID)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		logger.Logger.Error("メールデータの取得でエラーが発生しました",
+			append(logFields,
+				zap.Int("status_code", resp.StatusCode),
+				zap.String("response_body", string(respBody)))...)
+		return nil, fmt.Errorf("failed to get email, status: %d, response: %s",
+			resp.StatusCode, string(respBody))
+	}
+
+	var emailData models.EmailData
+	if err := json.NewDecoder(resp.Body).Decode(&emailData); err != nil {
+		logger.Logger.Error("レスポンスのデコードに失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to decode email: %v", err)
+	}
+
+	return &emailData, nil
+}
+
+func (s *DBPilotService) ListProcessingStatuses(status models.ProcessStatus) ([]models.ProcessingStatus, error) {
+	return s.SearchProcessingStatuses(models.StatusFilter{Statuses: []models.ProcessStatus{status}})
+}
+
+// SearchProcessingStatuses は状態・期間などの条件で処理状態の一覧を取得します
+func (s *DBPilotService) SearchProcessingStatuses(filter models.StatusFilter) ([]models.ProcessingStatus, error) {
+	list, err := s.SummarizeProcessingStatuses(filter)
+	if err != nil {
+		return nil, err
+	}
+	return list.Data, nil
+}
+
+// SummarizeProcessingStatuses は状態・期間・送信者などの条件で処理状態の一覧と、状態ごとの件数を取得します
+func (s *DBPilotService) SummarizeProcessingStatuses(filter models.StatusFilter) (*models.StatusList, error) {
+	statuses := make([]string, 0, len(filter.Statuses))
+	for _, status := range filter.Statuses {
+		statuses = append(statuses, string(status))
+	}
+
+	query := url.Values{}
+	if len(statuses) > 0 {
+		query.Set("status", strings.Join(statuses, ","))
+	}
+	if !filter.From.IsZero() {
+		query.Set("from", filter.From.Format(time.RFC3339))
+	}
+	if !filter.To.IsZero() {
+		query.Set("to", filter.To.Format(time.RFC3339))
+	}
+	if !filter.UpdatedBefore.IsZero() {
+		query.Set("updated_before", filter.UpdatedBefore.Format(time.RFC3339))
+	}
+	if filter.Sender != "" {
+		query.Set("sender", filter.Sender)
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	logFields := []zap.Field{
+		zap.String("operation", "SummarizeProcessingStatuses"),
+		zap.String("query", query.Encode()),
+	}
+
+	req, err := s.createRequest("GET", "/status?"+query.Encode(), nil)
+	if err != nil {
+		logger.Logger.Error("リクエストの作成に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Logger.Error("処理状態一覧の取得に失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to list processing statuses: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		logger.Logger.Error("処理状態一覧の取得でエラーが発生しました",
+			append(logFields,
+				zap.Int("status_code", resp.StatusCode),
+				zap.String("response_body", string(respBody)))...)
+		return nil, fmt.Errorf("failed to list processing statuses, status: %d, response: %s",
+			resp.StatusCode, string(respBody))
+	}
+
+	var result models.StatusList
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		logger.Logger.Error("レスポンスのデコードに失敗しました",
+			append(logFields, zap.Error(err))...)
+		return nil, fmt.Errorf("failed to decode processing statuses: %v", err)
+	}
+
+	return &result, nil
+}
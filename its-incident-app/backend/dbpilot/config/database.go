@@ -1,12 +1,16 @@
 package config
 
 import (
+	"context"
 	"dbpilot/logger"
+	"dbpilot/secrets"
 	"errors"
 	"fmt"
 	"os"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -40,15 +44,28 @@ func ConnectDatabase() error {
 		Colorful:                  false,
 	})
 
-	// データベース接続文字列の構築
+	// データベース接続文字列の構築（パスワードは接続ごとに設定する）
 	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=Asia/Tokyo",
+		"host=%s user=%s dbname=%s port=%s sslmode=disable TimeZone=Asia/Tokyo",
 		os.Getenv("DB_HOST"),
 		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
 		os.Getenv("DB_NAME"),
 		os.Getenv("DB_PORT"),
 	)
+	pgxConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to parse database config: %w", err)
+	}
+
+	// Secret Managerでパスワードがローテーションされても新しい接続に反映されるよう、接続確立時に取得する
+	connector := stdlib.OpenDB(*pgxConfig, stdlib.OptionBeforeConnect(func(ctx context.Context, cc *pgx.ConnConfig) error {
+		password := secrets.Get("DB_PASSWORD")
+		if password == "" {
+			return errors.New("database password is not available")
+		}
+		cc.Password = password
+		return nil
+	}))
 
 	// GORMの設定
 	config := &gorm.Config{
@@ -64,8 +81,7 @@ func ConnectDatabase() error {
 		zap.String("database", os.Getenv("DB_NAME")))
 
 	// データベースへの接続
-	var err error
-	DB, err = gorm.Open(postgres.Open(dsn), config)
+	DB, err = gorm.Open(postgres.New(postgres.Config{Conn: connector}), config)
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"dbpilot/logger"
+	"dbpilot/mtls"
 	"fmt"
 	"net/http"
 	"os"
@@ -68,7 +69,7 @@ func SetupServer(r *gin.Engine) *http.Server {
 	// ルート情報の表示
 	displayServerConfig(r, config)
 
-	return &http.Server{
+	srv := &http.Server{
 		Addr:              ":" + config.Port,
 		Handler:           r,
 		ReadTimeout:       config.ReadTimeout,
@@ -76,6 +77,15 @@ func SetupServer(r *gin.Engine) *http.Server {
 		IdleTimeout:       config.IdleTimeout,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
+
+	// 相互TLS（MTLS_CERT_FILE/MTLS_KEY_FILE が設定されている場合のみ）
+	tlsConfig, err := mtls.ServerConfig()
+	if err != nil {
+		logger.Logger.Fatal("mTLSの設定に失敗しました", zap.Error(err))
+	}
+	srv.TLSConfig = tlsConfig
+
+	return srv
 }
 
 func initLogLevel() zapcore.Level {
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type LockUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+// LockUser はアカウントをロックし、既存のセッションをすべて失効させるハンドラー
+func LockUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := findUserParam(c, db)
+		if !ok {
+			return
+		}
+
+		var req LockUserRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				handleError(c, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		now, sessionIDs, err := models.LockUser(db, user.ID, req.Reason)
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Uint("user_id", user.ID))
+			return
+		}
+
+		logger.Logger.Warn("アカウントをロックしました",
+			zap.Uint("user_id", user.ID),
+			zap.String("email", user.Email),
+			zap.String("reason", req.Reason),
+			zap.Int("revoked_sessions", len(sessionIDs)),
+		)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Account locked successfully",
+			"user_id":     user.ID,
+			"email":       user.Email,
+			"locked_at":   now,
+			"session_ids": sessionIDs,
+		})
+	}
+}
+
+// UnlockUser はアカウントのロックを解除するハンドラー
+func UnlockUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := findUserParam(c, db)
+		if !ok {
+			return
+		}
+
+		if err := models.UnlockUser(db, user.ID); err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Uint("user_id", user.ID))
+			return
+		}
+
+		logger.Logger.Info("アカウントのロックを解除しました",
+			zap.Uint("user_id", user.ID),
+			zap.String("email", user.Email),
+		)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Account unlocked successfully",
+			"user_id": user.ID,
+			"email":   user.Email,
+		})
+	}
+}
+
+// findUserParam はURLパラメータ :id のユーザーを取得し、見つからない場合はエラーレスポンスを返します
+func findUserParam(c *gin.Context, db *gorm.DB) (models.User, bool) {
+	var user models.User
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return user, false
+	}
+
+	if err := db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return user, false
+		}
+		handleError(c, http.StatusInternalServerError, err)
+		return user, false
+	}
+	return user, true
+}
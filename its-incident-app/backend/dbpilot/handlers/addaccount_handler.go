@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"dbpilot/models"
+	"errors"
 	"net/http"
 	"time"
 
@@ -61,6 +62,17 @@ func CreateLoginToken(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		// 未登録ユーザーはここで作成されるため、ドメインの許可リストを確認する
+		if !isEmailDomainAllowed(req.Email) {
+			logger.Logger.Warn("許可されていないドメインのメールアドレスです",
+				zap.String("email", req.Email))
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Email domain is not allowed",
+				"code":  errCodeEmailDomainNotAllowed,
+			})
+			return
+		}
+
 		// トランザクションを開始
 		err := db.Transaction(func(tx *gorm.DB) error {
 			// ユーザーを検索または作成
@@ -71,11 +83,11 @@ func CreateLoginToken(db *gorm.DB) gin.HandlerFunc {
 				return err
 			}
 
-			// 既存の未使用トークンを無効化
+			// 既存の未使用トークンを失効させる
 			if err := tx.Model(&models.LoginToken{}).
 				Where("email = ? AND used = ? AND expires_at > ?",
 					req.Email, false, time.Now()).
-				Update("used", true).Error; err != nil {
+				Updates(map[string]interface{}{"used": true, "revoked_at": time.Now()}).Error; err != nil {
 				return err
 			}
 
@@ -106,6 +118,26 @@ func CreateLoginToken(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// ログインリンクのトークンが無効な場合のエラーコード
+const (
+	errCodeLoginTokenUsed    = "login_token_used"
+	errCodeLoginTokenRevoked = "login_token_revoked"
+	errCodeLoginTokenExpired = "login_token_expired"
+)
+
+// loginTokenRejection はトークンを使用できない理由を返します（使用可能な場合は code が空）
+func loginTokenRejection(token models.LoginToken, now time.Time) (int, string, string) {
+	switch {
+	case token.RevokedAt != nil:
+		return http.StatusUnauthorized, errCodeLoginTokenRevoked, "Token has been revoked"
+	case token.Used:
+		return http.StatusUnauthorized, errCodeLoginTokenUsed, "Token has already been used"
+	case token.ExpiresAt.Before(now):
+		return http.StatusUnauthorized, errCodeLoginTokenExpired, "Token has expired"
+	}
+	return http.StatusOK, "", ""
+}
+
 func VerifyLoginToken(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logFields := []zap.Field{
@@ -142,26 +174,32 @@ func VerifyLoginToken(db *gorm.DB) gin.HandlerFunc {
 
 		logger.Logger.Info("トークンの状態", logFields...)
 
-		// トークンの有効性チェック
-		if loginToken.Used {
-			logger.Logger.Error("トークンは既に使用済みです", logFields...)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has already been used"})
+		// トークンの有効性チェック（再利用・失効・期限切れを区別して返す）
+		if status, code, message := loginTokenRejection(loginToken, time.Now()); code != "" {
+			logger.Logger.Error("トークンが無効です", append(logFields, zap.String("code", code))...)
+			c.JSON(status, gin.H{"error": message, "code": code})
 			return
 		}
 
-		if loginToken.ExpiresAt.Before(time.Now()) {
-			logger.Logger.Error("トークンの有効期限が切れています", logFields...)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has expired"})
-			return
-		}
-
-		// トークンを使用済みにマーク
-		if err := db.Model(&loginToken).Update("used", true).Error; err != nil {
+		// 同時に検証された場合も一度しか成功しないよう、未使用の場合のみ使用済みにする
+		now := time.Now()
+		consumed := db.Model(&models.LoginToken{}).
+			Where("id = ? AND used = ? AND expires_at > ?", loginToken.ID, false, now).
+			Updates(map[string]interface{}{"used": true, "consumed_at": now})
+		if consumed.Error != nil {
 			logger.Logger.Error("トークンの更新に失敗しました",
-				append(logFields, zap.Error(err))...)
+				append(logFields, zap.Error(consumed.Error))...)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update token status"})
 			return
 		}
+		if consumed.RowsAffected == 0 {
+			logger.Logger.Error("トークンは既に使用済みです", logFields...)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Token has already been used",
+				"code":  errCodeLoginTokenUsed,
+			})
+			return
+		}
 
 		// ユーザー情報を取得
 		var user models.User
@@ -183,3 +221,40 @@ func VerifyLoginToken(db *gorm.DB) gin.HandlerFunc {
 		})
 	}
 }
+
+// RevokeLoginToken は誤送信などで不要になったログインリンクのトークンを失効させるハンドラー
+func RevokeLoginToken(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Param("token")
+
+		var loginToken models.LoginToken
+		if err := db.Where("token = ?", token).First(&loginToken).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+				return
+			}
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		if loginToken.RevokedAt == nil && loginToken.ConsumedAt == nil {
+			if err := db.Model(&loginToken).Updates(map[string]interface{}{
+				"used":       true,
+				"revoked_at": time.Now(),
+			}).Error; err != nil {
+				handleError(c, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		logger.Logger.Warn("ログインリンクのトークンを失効させました",
+			zap.String("email", loginToken.Email),
+			zap.Bool("already_consumed", loginToken.ConsumedAt != nil))
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":          "Login token revoked successfully",
+			"email":            loginToken.Email,
+			"already_consumed": loginToken.ConsumedAt != nil,
+		})
+	}
+}
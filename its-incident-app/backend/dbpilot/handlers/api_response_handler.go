@@ -3,7 +3,9 @@ package handlers
 import (
 	"dbpilot/logger"
 	"dbpilot/models"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -45,6 +47,18 @@ func GetAPIResponseData(db *gorm.DB) gin.HandlerFunc {
 		if query.Status != nil {
 			dbQuery = dbQuery.Where("status = ?", *query.Status)
 		}
+		if query.Provider != nil {
+			dbQuery = dbQuery.Where("provider = ?", *query.Provider)
+		}
+		if query.WorkflowVersion != nil {
+			dbQuery = dbQuery.Where("workflow_version = ?", *query.WorkflowVersion)
+		}
+		if query.Language != nil {
+			dbQuery = dbQuery.Where("language = ?", *query.Language)
+		}
+		if query.HumanCorrected != nil {
+			dbQuery = dbQuery.Where("human_corrected = ?", *query.HumanCorrected)
+		}
 
 		// テキストフィールドの検索（ILIKE使用）
 		textFields := map[string]*string{
@@ -156,3 +170,44 @@ func GetAPIResponseData(db *gorm.DB) gin.HandlerFunc {
 		})
 	}
 }
+
+// FindCachedAPIResponse は同じ内容（content_hash）のメールに対する直近のAI応答を返すハンドラー。
+// since（UNIX秒）以降に作成され、欠損のない成功した応答のうち、キャッシュの再利用でも担当者の訂正を受けたものでもない応答を対象にします
+func FindCachedAPIResponse(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		contentHash := c.Query("content_hash")
+		logFields := []zap.Field{
+			zap.String("handler", "FindCachedAPIResponse"),
+			zap.String("content_hash", contentHash),
+		}
+
+		since, err := strconv.ParseInt(c.Query("since"), 10, 64)
+		if contentHash == "" || err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "content_hash and since are required"})
+			return
+		}
+
+		var apiData models.APIResponseData
+		err = db.Where("content_hash = ? AND workflow_version = ?", contentHash, c.Query("workflow_version")).
+			Where("cache_hit = ? AND is_partial = ? AND human_corrected = ? AND status = ?", false, false, false, "succeeded").
+			Where("created_at >= ?", since).
+			Order("created_at DESC").
+			First(&apiData).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Cached response not found"})
+				return
+			}
+			logger.Logger.Error("キャッシュ対象のAI応答の検索に失敗しました",
+				append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find cached response"})
+			return
+		}
+
+		logger.Logger.Debug("キャッシュ対象のAI応答が見つかりました",
+			append(logFields, zap.Uint("incident_id", apiData.IncidentID))...)
+
+		// 保存時のリクエスト（APIRequest）をそのまま返す
+		c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(apiData.RawResponse))
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAuditLogLimit = 50
+	maxAuditLogLimit     = 500
+)
+
+type CreateAuditLogRequest struct {
+	EventType string `json:"event_type" binding:"required"`
+	Outcome   string `json:"outcome" binding:"required"`
+	UserID    uint   `json:"user_id"`
+	Email     string `json:"email"`
+	ClientIP  string `json:"client_ip"`
+	UserAgent string `json:"user_agent"`
+	Details   string `json:"details"`
+}
+
+type AuditLogResponse struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	EventType string    `json:"event_type"`
+	Outcome   string    `json:"outcome"`
+	UserID    uint      `json:"user_id"`
+	Email     string    `json:"email"`
+	ClientIP  string    `json:"client_ip"`
+	UserAgent string    `json:"user_agent"`
+	Details   string    `json:"details"`
+}
+
+// CreateAuditLog は認証イベントを監査ログとして保存するハンドラー
+func CreateAuditLog(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateAuditLogRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		userAgent := req.UserAgent
+		if len(userAgent) > 255 {
+			userAgent = userAgent[:255]
+		}
+
+		entry := models.AuthAuditLog{
+			EventType: req.EventType,
+			Outcome:   req.Outcome,
+			UserID:    req.UserID,
+			Email:     req.Email,
+			ClientIP:  req.ClientIP,
+			UserAgent: userAgent,
+			Details:   req.Details,
+		}
+		if err := db.Create(&entry).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err,
+				zap.String("event_type", req.EventType))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"id": entry.ID})
+	}
+}
+
+// ListAuditLogs は監査ログを検索するハンドラー。
+// user_id・email・event_type（カンマ区切り）・from/to（RFC3339または日付）で絞り込めます
+func ListAuditLogs(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Model(&models.AuthAuditLog{})
+
+		if userID := c.Query("user_id"); userID != "" {
+			id, err := strconv.ParseUint(userID, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+				return
+			}
+			query = query.Where("user_id = ?", id)
+		}
+		if email := c.Query("email"); email != "" {
+			query = query.Where("email = ?", email)
+		}
+		if eventTypes := c.Query("event_type"); eventTypes != "" {
+			query = query.Where("event_type IN ?", strings.Split(eventTypes, ","))
+		}
+		if outcome := c.Query("outcome"); outcome != "" {
+			query = query.Where("outcome = ?", outcome)
+		}
+
+		if from := c.Query("from"); from != "" {
+			t, err := parseAuditTime(from, false)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from"})
+				return
+			}
+			query = query.Where("created_at >= ?", t)
+		}
+		if to := c.Query("to"); to != "" {
+			t, err := parseAuditTime(to, true)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to"})
+				return
+			}
+			query = query.Where("created_at < ?", t)
+		}
+
+		limit := defaultAuditLogLimit
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= maxAuditLogLimit {
+			limit = l
+		}
+		offset := 0
+		if o, err := strconv.Atoi(c.Query("offset")); err == nil && o > 0 {
+			offset = o
+		}
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		var entries []models.AuthAuditLog
+		if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&entries).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		items := make([]AuditLogResponse, 0, len(entries))
+		for _, e := range entries {
+			items = append(items, AuditLogResponse{
+				ID:        e.ID,
+				CreatedAt: e.CreatedAt,
+				EventType: e.EventType,
+				Outcome:   e.Outcome,
+				UserID:    e.UserID,
+				Email:     e.Email,
+				ClientIP:  e.ClientIP,
+				UserAgent: e.UserAgent,
+				Details:   e.Details,
+			})
+		}
+
+		logger.Logger.Info("監査ログを取得しました",
+			zap.Int64("total", total),
+			zap.Int("count", len(items)),
+		)
+
+		c.JSON(http.StatusOK, gin.H{
+			"total":  total,
+			"limit":  limit,
+			"offset": offset,
+			"items":  items,
+		})
+	}
+}
+
+// parseAuditTime はRFC3339または日付（JST）を解釈します。日付指定の to はその日の終わりまでを含めます
+func parseAuditTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	t, err := time.ParseInLocation("2006-01-02", value, jst)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	"dbpilot/models"
+	"dbpilot/security"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AuthFailureRequest struct {
+	Source   string `json:"source" binding:"required"`
+	Reason   string `json:"reason"`
+	ClientIP string `json:"client_ip" binding:"required"`
+	Email    string `json:"email"`
+	Path     string `json:"path"`
+}
+
+// RecordAuthFailure は他サービスで発生した認証失敗を記録するハンドラー
+func RecordAuthFailure(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AuthFailureRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		security.RecordAuthFailure(db, models.AuthFailure{
+			Source:   req.Source,
+			Reason:   req.Reason,
+			ClientIP: req.ClientIP,
+			Email:    req.Email,
+			Path:     req.Path,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Auth failure recorded"})
+	}
+}
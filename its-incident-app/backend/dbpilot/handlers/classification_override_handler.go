@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ClassificationOverrideRequest は担当者が訂正した分類です。指定した項目だけを訂正します
+type ClassificationOverrideRequest struct {
+	Judgment     *string `json:"judgment"`
+	Priority     *string `json:"priority"`
+	IncidentText *string `json:"incident_text"`
+	Operator     string  `json:"operator"`
+	Reason       string  `json:"reason"`
+}
+
+// OverrideClassification はメッセージのAIの分類（判定・優先度・インシデント内容）を担当者が訂正するハンドラー。
+// AIの出力はそのまま残し、訂正後の値と訂正者を保存して human_corrected を立てます（モデル評価用）
+func OverrideClassification(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID := c.Param("messageID")
+		logFields := []zap.Field{
+			zap.String("handler", "OverrideClassification"),
+			zap.String("message_id", messageID),
+		}
+
+		var req ClassificationOverrideRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err, logFields...)
+			return
+		}
+		if req.Judgment == nil && req.Priority == nil && req.IncidentText == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one of judgment, priority or incident_text is required"})
+			return
+		}
+
+		// セッションの利用者を優先し、サービス間呼び出しの場合はリクエストの operator を使う
+		operator := c.GetString("user_email")
+		if operator == "" {
+			operator = strings.TrimSpace(req.Operator)
+		}
+		if operator == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "operator is required"})
+			return
+		}
+
+		var apiData models.APIResponseData
+		err := db.Where("incident_id IN (?)",
+			db.Model(&models.Incident{}).Select("id").Where("message_id = ?", messageID)).
+			Order("id DESC").
+			First(&apiData).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "AI response not found for message"})
+				return
+			}
+			handleError(c, http.StatusInternalServerError, err, logFields...)
+			return
+		}
+
+		// 以前の訂正を引き継ぎ、今回指定された項目だけを更新する
+		now := time.Now()
+		updates := map[string]interface{}{
+			"human_corrected": true,
+			"override_by":     operator,
+			"override_reason": req.Reason,
+			"overridden_at":   now,
+		}
+		if req.Judgment != nil {
+			updates["override_judgment"] = strings.TrimSpace(*req.Judgment)
+		}
+		if req.Priority != nil {
+			updates["override_priority"] = strings.TrimSpace(*req.Priority)
+		}
+		if req.IncidentText != nil {
+			updates["override_incident_text"] = *req.IncidentText
+		}
+
+		if err := db.Model(&apiData).Updates(updates).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, logFields...)
+			return
+		}
+		if err := db.First(&apiData, apiData.ID).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, logFields...)
+			return
+		}
+
+		logger.Logger.Info("AIの分類を担当者が訂正しました",
+			append(logFields,
+				zap.Uint("incident_id", apiData.IncidentID),
+				zap.String("operator", operator),
+				zap.String("ai_judgment", apiData.Judgment),
+				zap.String("override_judgment", apiData.OverrideJudgment),
+				zap.String("ai_priority", apiData.Priority),
+				zap.String("override_priority", apiData.OverridePriority))...)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Classification overridden successfully",
+			"message_id":  messageID,
+			"incident_id": apiData.IncidentID,
+			"api_data":    apiData,
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// CostUsageSummary はAI利用量の合計です
+type CostUsageSummary struct {
+	Key         string  `json:"key,omitempty"`
+	Requests    int     `json:"requests"`
+	TotalTokens int64   `json:"total_tokens"`
+	ElapsedTime float64 `json:"elapsed_time"`
+}
+
+// recordCostUsage はAI応答の利用量を送信者別に記録します。集計の失敗はインシデントの保存に影響させません。
+// キャッシュの再利用や、AIを呼び出していないエラー応答（トークン・処理時間ともに0）は記録しません
+func recordCostUsage(db *gorm.DB, apiRequest *models.APIRequest, fallbackSender string, logFields []zap.Field) {
+	if apiRequest.CacheHit || (apiRequest.Data.TotalTokens == 0 && apiRequest.Data.ElapsedTime == 0) {
+		return
+	}
+
+	sender := fallbackSender
+	var senders []string
+	if err := db.Model(&models.EmailData{}).
+		Where("message_id = ?", apiRequest.MessageID).
+		Limit(1).
+		Pluck("email_from", &senders).Error; err == nil && len(senders) > 0 && senders[0] != "" {
+		sender = senders[0]
+	}
+	if sender == "" {
+		sender = "unknown"
+	}
+
+	if err := models.RecordCostUsage(db, time.Now(), sender,
+		apiRequest.Data.TotalTokens, apiRequest.Data.ElapsedTime); err != nil {
+		logger.Logger.Error("AI利用量の記録に失敗しました",
+			append(logFields, zap.Error(err))...)
+	}
+}
+
+// GetCostUsage は期間（from/to、YYYY-MM-DD、既定は当日）のAI利用量を日別・送信者別に集計して返すハンドラー
+func GetCostUsage(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jst, _ := time.LoadLocation("Asia/Tokyo")
+		today := time.Now().In(jst).Format("2006-01-02")
+		from := c.DefaultQuery("from", today)
+		to := c.DefaultQuery("to", from)
+		for _, value := range []string{from, to} {
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be YYYY-MM-DD"})
+				return
+			}
+		}
+
+		query := db.Model(&models.CostUsage{}).Where("date >= ? AND date <= ?", from, to)
+		if sender := c.Query("sender"); sender != "" {
+			query = query.Where("sender = ?", sender)
+		}
+
+		var total CostUsageSummary
+		var byDay, bySender []CostUsageSummary
+		selectSums := "COALESCE(SUM(requests), 0) AS requests, COALESCE(SUM(total_tokens), 0) AS total_tokens, COALESCE(SUM(elapsed_time), 0) AS elapsed_time"
+		err := query.Session(&gorm.Session{}).Select(selectSums).Scan(&total).Error
+		if err == nil {
+			err = query.Session(&gorm.Session{}).Select("date AS key, " + selectSums).
+				Group("date").Order("date").Scan(&byDay).Error
+		}
+		if err == nil {
+			err = query.Session(&gorm.Session{}).Select("sender AS key, " + selectSums).
+				Group("sender").Order("total_tokens DESC").Scan(&bySender).Error
+		}
+		if err != nil {
+			logger.Logger.Error("AI利用量の集計に失敗しました",
+				zap.Error(err),
+				zap.String("from", from),
+				zap.String("to", to),
+			)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate cost usage"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":      from,
+			"to":        to,
+			"total":     total,
+			"by_day":    byDay,
+			"by_sender": bySender,
+		})
+	}
+}
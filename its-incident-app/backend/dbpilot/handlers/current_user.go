@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// sessionUserID はリクエストの利用者IDを返します。
+// 認証ミドルウェアが特定済み（署名付きヘッダーまたはセッション検証）の場合はセッションを問い合わせません
+func sessionUserID(c *gin.Context, db *gorm.DB) (uint, bool) {
+	if userID, ok := c.Get("user_id"); ok {
+		if id, ok := userID.(uint); ok && id != 0 {
+			return id, true
+		}
+	}
+
+	sessionID := c.GetString("session")
+	if sessionID == "" {
+		return 0, false
+	}
+	session, err := models.GetSessionByID(db, sessionID)
+	if err != nil {
+		return 0, false
+	}
+	return session.UserID, true
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SaveDeadLetterRequest struct {
+	Payload json.RawMessage `json:"payload" binding:"required"`
+	Error   string          `json:"error"`
+}
+
+// SaveDeadLetter はAI処理に失敗したメッセージを保存するハンドラー。
+// 既に存在する場合は内容を更新して失敗回数を加算し、未解決に戻します
+func SaveDeadLetter(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID := c.Param("messageID")
+
+		var req SaveDeadLetterRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err, zap.String("message_id", messageID))
+			return
+		}
+
+		now := time.Now()
+		deadLetter := models.DeadLetter{
+			MessageID:    messageID,
+			Payload:      string(req.Payload),
+			Error:        req.Error,
+			Attempts:     1,
+			LastFailedAt: now,
+		}
+		err := db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "message_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"payload":        deadLetter.Payload,
+				"error":          deadLetter.Error,
+				"attempts":       gorm.Expr("dead_letters.attempts + 1"),
+				"last_failed_at": now,
+				"resolved_at":    nil,
+				"updated_at":     now,
+				"deleted_at":     nil,
+			}),
+		}).Create(&deadLetter).Error
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.String("message_id", messageID))
+			return
+		}
+
+		logger.Logger.Warn("デッドレターを保存しました",
+			zap.String("message_id", messageID),
+			zap.String("error", req.Error),
+		)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Dead letter saved successfully", "message_id": messageID})
+	}
+}
+
+// GetDeadLetter はメッセージIDでデッドレターを取得するハンドラー
+func GetDeadLetter(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deadLetter, ok := findDeadLetter(c, db)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, deadLetter)
+	}
+}
+
+// ListDeadLetters はデッドレターの一覧を取得するハンドラー（既定は未解決のみ、?resolved=true で解決済みも含む）
+func ListDeadLetters(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 100
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+
+		query := db.Model(&models.DeadLetter{}).Omit("payload")
+		if c.Query("resolved") != "true" {
+			query = query.Where("resolved_at IS NULL")
+		}
+
+		var deadLetters []models.DeadLetter
+		if err := query.Order("last_failed_at DESC").Limit(limit).Find(&deadLetters).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"count": len(deadLetters),
+			"data":  deadLetters,
+		})
+	}
+}
+
+// MarkDeadLetterReprocessed はデッドレターの再処理を記録するハンドラー
+func MarkDeadLetterReprocessed(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deadLetter, ok := findDeadLetter(c, db)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		if err := db.Model(deadLetter).Updates(map[string]interface{}{
+			"reprocess_count":     gorm.Expr("reprocess_count + 1"),
+			"last_reprocessed_at": now,
+		}).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.String("message_id", deadLetter.MessageID))
+			return
+		}
+
+		logger.Logger.Info("デッドレターの再処理を記録しました",
+			zap.String("message_id", deadLetter.MessageID),
+			zap.Int("reprocess_count", deadLetter.ReprocessCount+1),
+		)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message_id":      deadLetter.MessageID,
+			"reprocess_count": deadLetter.ReprocessCount + 1,
+		})
+	}
+}
+
+func findDeadLetter(c *gin.Context, db *gorm.DB) (*models.DeadLetter, bool) {
+	messageID := c.Param("messageID")
+
+	var deadLetter models.DeadLetter
+	if err := db.Where("message_id = ?", messageID).First(&deadLetter).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+			return nil, false
+		}
+		handleError(c, http.StatusInternalServerError, err, zap.String("message_id", messageID))
+		return nil, false
+	}
+	return &deadLetter, true
+}
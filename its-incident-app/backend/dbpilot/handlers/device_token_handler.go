@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+	"dbpilot/serviceauth"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errDeviceTokenNotFound = errors.New("device token not found")
+	errDeviceTokenExpired  = errors.New("device token expired")
+	errDeviceTokenRevoked  = errors.New("device token revoked")
+	errAccountLocked       = errors.New("account is locked")
+)
+
+type CreateDeviceTokenRequest struct {
+	TokenHash  string    `json:"token_hash" binding:"required"`
+	UserID     uint      `json:"user_id" binding:"required"`
+	Email      string    `json:"email"`
+	DeviceName string    `json:"device_name"`
+	ExpiresAt  time.Time `json:"expires_at" binding:"required"`
+}
+
+type ExchangeDeviceTokenRequest struct {
+	TokenHash        string    `json:"token_hash" binding:"required"`
+	NewTokenHash     string    `json:"new_token_hash" binding:"required"`
+	SessionID        string    `json:"session_id" binding:"required"`
+	SessionExpiresAt time.Time `json:"session_expires_at" binding:"required"`
+}
+
+type RevokeDeviceTokenRequest struct {
+	TokenHash string `json:"token_hash" binding:"required"`
+}
+
+// DeviceTokenResponse は端末一覧で返す端末トークンの情報（ハッシュは含めない）
+type DeviceTokenResponse struct {
+	ID         uint       `json:"id"`
+	DeviceName string     `json:"device_name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+}
+
+// CreateDeviceToken はログイン状態を保持するための端末トークンを保存するハンドラー
+func CreateDeviceToken(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateDeviceTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		token := models.DeviceToken{
+			TokenHash:  req.TokenHash,
+			UserID:     req.UserID,
+			Email:      req.Email,
+			DeviceName: req.DeviceName,
+			ExpiresAt:  req.ExpiresAt,
+		}
+		if err := db.Create(&token).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Uint("user_id", req.UserID))
+			return
+		}
+
+		logger.Logger.Info("端末トークンを作成しました",
+			zap.Uint("user_id", req.UserID),
+			zap.String("device_name", req.DeviceName))
+
+		c.JSON(http.StatusOK, gin.H{"id": token.ID, "message": "Device token created successfully"})
+	}
+}
+
+// ExchangeDeviceToken は端末トークンと引き換えに新しいセッションを作成するハンドラー。
+// 使用した端末トークンは新しいハッシュに置き換え、同じトークンを再利用できないようにします
+func ExchangeDeviceToken(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logFields := []zap.Field{
+			zap.String("handler", "ExchangeDeviceToken"),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+		}
+
+		var req ExchangeDeviceTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		var token models.DeviceToken
+		var session models.LoginSession
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("token_hash = ?", req.TokenHash).
+				First(&token).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return errDeviceTokenNotFound
+				}
+				return err
+			}
+
+			if token.RevokedAt != nil {
+				return errDeviceTokenRevoked
+			}
+			now := time.Now()
+			if now.After(token.ExpiresAt) {
+				return errDeviceTokenExpired
+			}
+
+			locked, err := models.IsUserLocked(tx, token.UserID)
+			if err != nil {
+				return err
+			}
+			if locked {
+				return errAccountLocked
+			}
+
+			if err := tx.Model(&token).Updates(map[string]interface{}{
+				"token_hash":   req.NewTokenHash,
+				"last_used_at": now,
+			}).Error; err != nil {
+				return err
+			}
+
+			session = models.LoginSession{
+				UserID:    token.UserID,
+				Email:     token.Email,
+				SessionID: req.SessionID,
+				ExpiresAt: req.SessionExpiresAt,
+			}
+			return tx.Create(&session).Error
+		})
+
+		switch {
+		case err == nil:
+		case errors.Is(err, errAccountLocked):
+			logger.Logger.Warn("ロック中のアカウントの端末トークンを拒否しました",
+				append(logFields, zap.Uint("user_id", token.UserID))...)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is locked", "code": "account_locked"})
+			return
+		case errors.Is(err, errDeviceTokenNotFound),
+			errors.Is(err, errDeviceTokenExpired),
+			errors.Is(err, errDeviceTokenRevoked):
+			logger.Logger.Info("端末トークンが無効です", append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		default:
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		logger.Logger.Info("端末トークンからセッションを作成しました",
+			append(logFields,
+				zap.Uint("user_id", token.UserID),
+				zap.Uint("device_token_id", token.ID))...)
+
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":           session.UserID,
+			"email":             session.Email,
+			"session_id":        session.SessionID,
+			"expires_at":        session.ExpiresAt,
+			"device_token_id":   token.ID,
+			"device_expires_at": token.ExpiresAt,
+		})
+	}
+}
+
+// RevokeDeviceTokenByHash はトークン自体を提示して端末トークンを失効させるハンドラー（ログアウト時に使用）
+func RevokeDeviceTokenByHash(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RevokeDeviceTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		result := db.Model(&models.DeviceToken{}).
+			Where("token_hash = ? AND revoked_at IS NULL", req.TokenHash).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			handleError(c, http.StatusInternalServerError, result.Error)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"revoked": result.RowsAffected > 0})
+	}
+}
+
+// ListDeviceTokens はリクエストしたユーザーの有効な端末トークンを一覧表示するハンドラー
+func ListDeviceTokens(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, ok := currentUserSession(c, db)
+		if !ok {
+			return
+		}
+
+		var tokens []models.DeviceToken
+		if err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", session.UserID, time.Now()).
+			Order("created_at DESC").
+			Find(&tokens).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Uint("user_id", session.UserID))
+			return
+		}
+
+		devices := make([]DeviceTokenResponse, 0, len(tokens))
+		for _, t := range tokens {
+			devices = append(devices, DeviceTokenResponse{
+				ID:         t.ID,
+				DeviceName: t.DeviceName,
+				CreatedAt:  t.CreatedAt,
+				LastUsedAt: t.LastUsedAt,
+				ExpiresAt:  t.ExpiresAt,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"devices": devices})
+	}
+}
+
+// DeleteDeviceToken はリクエストしたユーザーの端末トークンを個別に失効させるハンドラー
+func DeleteDeviceToken(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, ok := currentUserSession(c, db)
+		if !ok {
+			return
+		}
+
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device id"})
+			return
+		}
+
+		// 他のユーザーの端末は失効できないよう user_id で絞り込む
+		result := db.Model(&models.DeviceToken{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, session.UserID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			handleError(c, http.StatusInternalServerError, result.Error, zap.Uint("user_id", session.UserID))
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+			return
+		}
+
+		logger.Logger.Info("端末トークンを失効させました",
+			zap.Uint("user_id", session.UserID),
+			zap.Uint64("device_token_id", id))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Device revoked successfully"})
+	}
+}
+
+// currentUserSession はリクエストに使用したセッションを取得し、見つからない場合はエラーレスポンスを返します
+func currentUserSession(c *gin.Context, db *gorm.DB) (*models.LoginSession, bool) {
+	sessionID := c.GetString("session")
+	if sessionID == "" || serviceauth.IsServiceToken(sessionID) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+		return nil, false
+	}
+
+	session, err := models.GetSessionByID(db, sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session"})
+		return nil, false
+	}
+	return session, true
+}
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	directoryProvider       = "scim"
+	directoryDefaultLimit   = 100
+	directoryMaxLimit       = 500
+	directoryDeactivateNote = "deprovisioned by identity provider"
+)
+
+// DirectoryUser はIdPからのプロビジョニング（SCIM）向けのユーザー情報
+type DirectoryUser struct {
+	ID         uint      `json:"id"`
+	Email      string    `json:"email"`
+	Name       string    `json:"name"`
+	ExternalID string    `json:"external_id"`
+	Locale     string    `json:"locale"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type CreateDirectoryUserRequest struct {
+	Email      string `json:"email" binding:"required,email"`
+	Name       string `json:"name"`
+	ExternalID string `json:"external_id"`
+	Locale     string `json:"locale"`
+	Active     *bool  `json:"active"`
+}
+
+// UpdateDirectoryUserRequest は指定された項目のみ更新します
+type UpdateDirectoryUserRequest struct {
+	Email      *string `json:"email"`
+	Name       *string `json:"name"`
+	ExternalID *string `json:"external_id"`
+	Locale     *string `json:"locale"`
+	Active     *bool   `json:"active"`
+}
+
+// ListDirectoryUsers はユーザーを検索するハンドラー（email / external_id で絞り込み、offset / limit でページング）
+func ListDirectoryUsers(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(directoryDefaultLimit)))
+		if offset < 0 {
+			offset = 0
+		}
+		if limit <= 0 || limit > directoryMaxLimit {
+			limit = directoryDefaultLimit
+		}
+
+		query := db.Model(&models.User{})
+		if email := c.Query("email"); email != "" {
+			query = query.Where("LOWER(email) = ?", strings.ToLower(email))
+		}
+		if externalID := c.Query("external_id"); externalID != "" {
+			query = query.Where("auth_provider = ? AND external_subject = ?", directoryProvider, externalID)
+		}
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		var users []models.User
+		if err := query.Preload("Profile").Order("id").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		items := make([]DirectoryUser, 0, len(users))
+		for _, user := range users {
+			items = append(items, newDirectoryUser(user))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total":  total,
+			"offset": offset,
+			"limit":  limit,
+			"items":  items,
+		})
+	}
+}
+
+// GetDirectoryUser はIDでユーザーを取得するハンドラー
+func GetDirectoryUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := findDirectoryUser(c, db)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, newDirectoryUser(user))
+	}
+}
+
+// CreateDirectoryUser はIdPからプロビジョニングされたユーザーを作成するハンドラー
+func CreateDirectoryUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateDirectoryUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		if !isEmailDomainAllowed(req.Email) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Email domain is not allowed",
+				"code":  errCodeEmailDomainNotAllowed,
+			})
+			return
+		}
+
+		locale, ok := models.NormalizeLocale(req.Locale)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported locale", "code": "unsupported_locale"})
+			return
+		}
+
+		var existing int64
+		if err := db.Model(&models.User{}).Where("LOWER(email) = ?", strings.ToLower(req.Email)).
+			Count(&existing).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+		if existing > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "User already exists", "code": "user_exists"})
+			return
+		}
+
+		user := models.User{
+			Email:           req.Email,
+			AuthProvider:    directoryProvider,
+			ExternalSubject: req.ExternalID,
+		}
+		if req.Active != nil && !*req.Active {
+			now := time.Now()
+			user.LockedAt = &now
+			user.LockedReason = directoryDeactivateNote
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			user.Profile = models.Profile{UserID: user.ID, Name: req.Name, Locale: locale}
+			return tx.Create(&user.Profile).Error
+		})
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.String("email", req.Email))
+			return
+		}
+
+		logger.Logger.Info("IdPからユーザーをプロビジョニングしました",
+			zap.Uint("user_id", user.ID),
+			zap.String("email", user.Email))
+
+		c.JSON(http.StatusCreated, newDirectoryUser(user))
+	}
+}
+
+// UpdateDirectoryUser はユーザーの属性と有効/無効を更新するハンドラー。
+// 無効化するとアカウントをロックし、すべてのセッションを失効させます
+func UpdateDirectoryUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := findDirectoryUser(c, db)
+		if !ok {
+			return
+		}
+
+		var req UpdateDirectoryUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		var locale string
+		if req.Locale != nil {
+			var ok bool
+			if locale, ok = models.NormalizeLocale(*req.Locale); !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported locale", "code": "unsupported_locale"})
+				return
+			}
+		}
+
+		if req.Email != nil && !strings.EqualFold(*req.Email, user.Email) {
+			if !isEmailDomainAllowed(*req.Email) {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": "Email domain is not allowed",
+					"code":  errCodeEmailDomainNotAllowed,
+				})
+				return
+			}
+			var existing int64
+			if err := db.Model(&models.User{}).
+				Where("LOWER(email) = ? AND id <> ?", strings.ToLower(*req.Email), user.ID).
+				Count(&existing).Error; err != nil {
+				handleError(c, http.StatusInternalServerError, err)
+				return
+			}
+			if existing > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "User already exists", "code": "user_exists"})
+				return
+			}
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			updates := map[string]interface{}{}
+			if req.Email != nil {
+				updates["email"] = *req.Email
+			}
+			if req.ExternalID != nil {
+				updates["auth_provider"] = directoryProvider
+				updates["external_subject"] = *req.ExternalID
+			}
+			if len(updates) > 0 {
+				if err := tx.Model(&user).Updates(updates).Error; err != nil {
+					return err
+				}
+			}
+
+			profileUpdates := map[string]interface{}{}
+			if req.Name != nil {
+				profileUpdates["name"] = *req.Name
+			}
+			if req.Locale != nil {
+				profileUpdates["locale"] = locale
+			}
+			if len(profileUpdates) > 0 {
+				profile := models.Profile{UserID: user.ID}
+				if err := tx.Where("user_id = ?", user.ID).FirstOrCreate(&profile).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&profile).Updates(profileUpdates).Error; err != nil {
+					return err
+				}
+				user.Profile = profile
+			}
+			return nil
+		})
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Uint("user_id", user.ID))
+			return
+		}
+
+		var sessionIDs []string
+		if req.Active != nil {
+			switch {
+			case !*req.Active && user.LockedAt == nil:
+				lockedAt, revoked, err := models.LockUser(db, user.ID, directoryDeactivateNote)
+				if err != nil {
+					handleError(c, http.StatusInternalServerError, err, zap.Uint("user_id", user.ID))
+					return
+				}
+				user.LockedAt = &lockedAt
+				sessionIDs = revoked
+				logger.Logger.Warn("IdPの指示によりユーザーを無効化しました",
+					zap.Uint("user_id", user.ID),
+					zap.Int("revoked_sessions", len(revoked)))
+			case *req.Active && user.LockedAt != nil:
+				if err := models.UnlockUser(db, user.ID); err != nil {
+					handleError(c, http.StatusInternalServerError, err, zap.Uint("user_id", user.ID))
+					return
+				}
+				user.LockedAt = nil
+			}
+		}
+
+		if err := db.Preload("Profile").First(&user, user.ID).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Uint("user_id", user.ID))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user":        newDirectoryUser(user),
+			"session_ids": sessionIDs,
+		})
+	}
+}
+
+// findDirectoryUser は findUserParam で取得したユーザーにプロフィールを読み込みます
+func findDirectoryUser(c *gin.Context, db *gorm.DB) (models.User, bool) {
+	user, ok := findUserParam(c, db)
+	if !ok {
+		return user, false
+	}
+	if err := db.Where("user_id = ?", user.ID).Limit(1).Find(&user.Profile).Error; err != nil {
+		handleError(c, http.StatusInternalServerError, err, zap.Uint("user_id", user.ID))
+		return user, false
+	}
+	return user, true
+}
+
+func newDirectoryUser(user models.User) DirectoryUser {
+	externalID := ""
+	if user.AuthProvider == directoryProvider {
+		externalID = user.ExternalSubject
+	}
+	return DirectoryUser{
+		ID:         user.ID,
+		Email:      user.Email,
+		Name:       user.Profile.Name,
+		ExternalID: externalID,
+		Locale:     user.Profile.Locale,
+		Active:     user.LockedAt == nil,
+		CreatedAt:  user.CreatedAt,
+		UpdatedAt:  user.UpdatedAt,
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"os"
+	"strings"
+)
+
+// errCodeEmailDomainNotAllowed は許可されていないドメインのメールアドレスに返すエラーコード
+const errCodeEmailDomainNotAllowed = "email_domain_not_allowed"
+
+// isEmailDomainAllowed はメールアドレスのドメインが ALLOWED_EMAIL_DOMAINS（カンマ区切り）に含まれるかを返します。
+// 未設定の場合はすべてのドメインを許可します
+func isEmailDomainAllowed(email string) bool {
+	allowed := os.Getenv("ALLOWED_EMAIL_DOMAINS")
+	if strings.TrimSpace(allowed) == "" {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(strings.TrimSpace(email[at+1:]))
+
+	for _, d := range strings.Split(allowed, ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" && d == domain {
+			return true
+		}
+	}
+	return false
+}
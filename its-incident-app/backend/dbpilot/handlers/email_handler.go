@@ -1,14 +1,18 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
 	"dbpilot/logger"
 	"dbpilot/models"
+	"dbpilot/storage"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func AddEmailHandler(db *gorm.DB) gin.HandlerFunc {
@@ -41,20 +45,36 @@ func AddEmailHandler(db *gorm.DB) gin.HandlerFunc {
 		// Payloadのmessage_idをEmailDataにセット
 		emailData := payload.EmailData
 		emailData.MessageID = payload.MessageID
+		emailData.ThreadSubject = normalizeSubject(emailData.Subject)
 
-		// データベースに保存
-		if err := db.Create(&emailData).Error; err != nil {
+		// データベースに保存（上流の再送で同じメッセージIDが届いた場合は既存のデータを残す）
+		result := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "message_id"}},
+			DoNothing: true,
+		}).Create(&emailData)
+		if result.Error != nil {
 			logger.Logger.Error("メールデータの保存に失敗しました",
-				append(logFields, zap.Error(err))...)
+				append(logFields, zap.Error(result.Error))...)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save email data"})
 			return
 		}
+		if result.RowsAffected == 0 {
+			logger.Logger.Info("メールデータは保存済みです", logFields...)
+			c.JSON(http.StatusOK, gin.H{
+				"message":   "Email data already exists",
+				"duplicate": true,
+			})
+			return
+		}
 
 		logger.Logger.Info("メールデータを保存しました",
 			append(logFields,
 				zap.Int("email_id", int(emailData.ID)),
 				zap.String("subject", emailData.Subject))...)
 
+		// メンテナンスの告知（カレンダー招待）は期間を保存し、期間中のインシデントに記録する
+		recordMaintenanceWindow(db, &emailData, logFields)
+
 		// 保存成功時のレスポンス
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Email data saved successfully",
@@ -62,3 +82,80 @@ func AddEmailHandler(db *gorm.DB) gin.HandlerFunc {
 		})
 	}
 }
+
+// GetEmail はメッセージIDに紐づくメールデータを取得するハンドラー
+func GetEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID := c.Param("message_id")
+		logFields := []zap.Field{
+			zap.String("handler", "GetEmail"),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("message_id", messageID),
+		}
+
+		var emailData models.EmailData
+		if err := db.Where("message_id = ?", messageID).First(&emailData).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				logger.Logger.Warn("メールデータが見つかりません", logFields...)
+				c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+				return
+			}
+			logger.Logger.Error("メールデータの取得に失敗しました",
+				append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch email data"})
+			return
+		}
+
+		c.JSON(http.StatusOK, emailData)
+	}
+}
+
+// GetRawEmail は保存されている元のMIMEメッセージをそのまま返すハンドラー
+func GetRawEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID := c.Param("message_id")
+		logFields := []zap.Field{
+			zap.String("handler", "GetRawEmail"),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("message_id", messageID),
+		}
+
+		var emailData models.EmailData
+		if err := db.Where("message_id = ?", messageID).First(&emailData).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				logger.Logger.Warn("メールデータが見つかりません", logFields...)
+				c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+				return
+			}
+			logger.Logger.Error("メールデータの取得に失敗しました",
+				append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch email data"})
+			return
+		}
+
+		if emailData.RawGCSURI == "" {
+			logger.Logger.Warn("元のMIMEメッセージが保存されていません", logFields...)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Raw message not archived for this email"})
+			return
+		}
+
+		// mailconverterがCloud Storageに保存した生データをそのまま返す
+		raw, size, err := storage.Open(c.Request.Context(), emailData.RawGCSURI)
+		if err != nil {
+			logger.Logger.Error("元のMIMEメッセージの取得に失敗しました",
+				append(logFields, zap.String("raw_gcs_uri", emailData.RawGCSURI), zap.Error(err))...)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch raw message"})
+			return
+		}
+		defer raw.Close()
+
+		logger.Logger.Info("元のMIMEメッセージを返却します",
+			append(logFields, zap.Int64("size", size))...)
+
+		c.DataFromReader(http.StatusOK, size, "message/rfc822", raw, map[string]string{
+			"Content-Disposition": fmt.Sprintf("attachment; filename=%q", messageID+".eml"),
+		})
+	}
+}
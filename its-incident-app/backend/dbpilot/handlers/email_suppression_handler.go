@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SaveEmailSuppressionRequest は送信しない宛先の登録に使用されるリクエスト構造体
+type SaveEmailSuppressionRequest struct {
+	Email     string     `json:"email" binding:"required"`
+	Reason    string     `json:"reason" binding:"required,oneof=bounce spamreport"`
+	Detail    string     `json:"detail"`
+	EventAt   *time.Time `json:"event_at"`
+	MessageID string     `json:"message_id"`
+}
+
+// ListEmailSuppressions は送信しない宛先の一覧を取得するハンドラー（?email= で1件の確認）
+func ListEmailSuppressions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 100
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+
+		query := db.Model(&models.EmailSuppression{})
+		if email := c.Query("email"); email != "" {
+			query = query.Where("email = ?", strings.ToLower(strings.TrimSpace(email)))
+		}
+		if reason := c.Query("reason"); reason != "" {
+			query = query.Where("reason = ?", reason)
+		}
+
+		var suppressions []models.EmailSuppression
+		if err := query.Order("event_at DESC").Limit(limit).Find(&suppressions).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"count": len(suppressions),
+			"data":  suppressions,
+		})
+	}
+}
+
+// SaveEmailSuppression は送信しない宛先を登録するハンドラー。
+// 同じ宛先のイベントが再度届いた場合（SendGridの再送を含む）は理由と日時を更新し、回数を数えます
+func SaveEmailSuppression(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SaveEmailSuppressionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		eventAt := time.Now()
+		if req.EventAt != nil {
+			eventAt = *req.EventAt
+		}
+		suppression := models.EmailSuppression{
+			Email:     truncateRunes(strings.ToLower(strings.TrimSpace(req.Email)), 255),
+			Reason:    req.Reason,
+			Detail:    req.Detail,
+			EventAt:   eventAt,
+			Count:     1,
+			MessageID: truncateRunes(req.MessageID, 255),
+		}
+		err := db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "email"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"reason":     suppression.Reason,
+				"detail":     suppression.Detail,
+				"event_at":   suppression.EventAt,
+				"message_id": suppression.MessageID,
+				"count":      gorm.Expr("email_suppressions.count + 1"),
+				"updated_at": time.Now(),
+			}),
+		}).Create(&suppression).Error
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.String("reason", req.Reason))
+			return
+		}
+
+		logger.Logger.Info("送信しない宛先を登録しました",
+			zap.String("email", suppression.Email),
+			zap.String("reason", suppression.Reason),
+		)
+		c.JSON(http.StatusOK, gin.H{"email": suppression.Email, "reason": suppression.Reason})
+	}
+}
+
+// DeleteEmailSuppression は送信しない宛先を解除するハンドラー（宛先の不具合が解消した場合など）
+func DeleteEmailSuppression(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+			return
+		}
+
+		result := db.Delete(&models.EmailSuppression{}, id)
+		if result.Error != nil {
+			handleError(c, http.StatusInternalServerError, result.Error, zap.Uint64("suppression_id", id))
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Suppression not found"})
+			return
+		}
+
+		logger.Logger.Info("送信しない宛先を解除しました", zap.Uint64("suppression_id", id))
+		c.JSON(http.StatusOK, gin.H{"id": id, "deleted": true})
+	}
+}
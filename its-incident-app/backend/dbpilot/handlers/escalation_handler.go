@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// unacknowledgedStatus は誰も確認していないインシデントの状態
+const unacknowledgedStatus = "未着手"
+
+// StartEscalationRequest はエスカレーションの開始に使用されるリクエスト構造体
+type StartEscalationRequest struct {
+	Policy       string          `json:"policy" binding:"required"`
+	NextAt       *time.Time      `json:"next_at"`
+	Notification json.RawMessage `json:"notification"`
+}
+
+// AdvanceEscalationRequest は段階を実行する前に進行状況を更新するリクエスト構造体。
+// FromStep が現在の段階と一致しない場合（別のインスタンスが実行済み）は 409 を返します
+type AdvanceEscalationRequest struct {
+	FromStep int        `json:"from_step"`
+	NextAt   *time.Time `json:"next_at"` // 次の段階の予定（最後の段階の場合は nil）
+}
+
+// AcknowledgeEscalationRequest はエスカレーションを確認済みにするリクエスト構造体
+type AcknowledgeEscalationRequest struct {
+	AcknowledgedBy string `json:"acknowledged_by"`
+}
+
+// parseIncidentIDParam はパスの incidentID を返します
+func parseIncidentIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("incidentID"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid incident id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// acknowledgeEscalation はインシデントのエスカレーションを確認済みにします（エスカレーションがない場合は何もしません）
+func acknowledgeEscalation(db *gorm.DB, incidentID uint, acknowledgedBy string) error {
+	now := time.Now()
+	return db.Model(&models.Escalation{}).
+		Where("incident_id = ? AND acknowledged_at IS NULL", incidentID).
+		Updates(map[string]interface{}{
+			"acknowledged_at": now,
+			"acknowledged_by": truncateRunes(acknowledgedBy, 100),
+			"next_at":         nil,
+		}).Error
+}
+
+// ListEscalations はエスカレーションの一覧を取得するハンドラー（?due=true で次の段階の予定を過ぎた未確認のもののみ）
+func ListEscalations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 100
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+
+		query := db.Model(&models.Escalation{})
+		if c.Query("due") == "true" {
+			query = query.Where("acknowledged_at IS NULL AND next_at IS NOT NULL AND next_at <= ?", time.Now())
+		}
+
+		var escalations []models.Escalation
+		if err := query.Order("next_at ASC").Limit(limit).Find(&escalations).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"count": len(escalations),
+			"data":  escalations,
+		})
+	}
+}
+
+// GetEscalation はインシデントのエスカレーションの状態を取得するハンドラー
+func GetEscalation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		incidentID, ok := parseIncidentIDParam(c)
+		if !ok {
+			return
+		}
+
+		var escalation models.Escalation
+		err := db.Where("incident_id = ?", incidentID).First(&escalation).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Escalation not found"})
+			return
+		}
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Uint("incident_id", incidentID))
+			return
+		}
+		c.JSON(http.StatusOK, escalation)
+	}
+}
+
+// StartEscalation はインシデントのエスカレーションを開始するハンドラー。
+// 確認済みでない既存のエスカレーションがある場合は、新しいポリシーで最初からやり直します
+func StartEscalation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		incidentID, ok := parseIncidentIDParam(c)
+		if !ok {
+			return
+		}
+
+		var req StartEscalationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err, zap.Uint("incident_id", incidentID))
+			return
+		}
+
+		escalation := models.Escalation{
+			IncidentID:   incidentID,
+			Policy:       truncateRunes(req.Policy, 100),
+			NextAt:       req.NextAt,
+			Notification: string(req.Notification),
+		}
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "incident_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"policy", "step", "next_at", "notification", "acknowledged_at", "acknowledged_by", "updated_at"}),
+		}).Create(&escalation).Error
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Uint("incident_id", incidentID))
+			return
+		}
+
+		logger.Logger.Info("エスカレーションを開始しました",
+			zap.Uint("incident_id", incidentID),
+			zap.String("policy", escalation.Policy),
+		)
+		c.JSON(http.StatusOK, escalation)
+	}
+}
+
+// AdvanceEscalation はエスカレーションの段階を1つ進めるハンドラー。
+// notify は段階を実行する前に呼び出し、更新できた場合だけ通知するため、複数のインスタンスで同じ段階を重ねて実行しません
+func AdvanceEscalation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		incidentID, ok := parseIncidentIDParam(c)
+		if !ok {
+			return
+		}
+
+		var req AdvanceEscalationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err, zap.Uint("incident_id", incidentID))
+			return
+		}
+
+		result := db.Model(&models.Escalation{}).
+			Where("incident_id = ? AND step = ? AND acknowledged_at IS NULL", incidentID, req.FromStep).
+			Updates(map[string]interface{}{
+				"step":    req.FromStep + 1,
+				"next_at": req.NextAt,
+			})
+		if result.Error != nil {
+			handleError(c, http.StatusInternalServerError, result.Error, zap.Uint("incident_id", incidentID))
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Escalation already advanced or acknowledged"})
+			return
+		}
+
+		logger.Logger.Info("エスカレーションの段階を進めました",
+			zap.Uint("incident_id", incidentID),
+			zap.Int("step", req.FromStep+1),
+		)
+		c.JSON(http.StatusOK, gin.H{"incident_id": incidentID, "step": req.FromStep + 1})
+	}
+}
+
+// AcknowledgeEscalation はエスカレーションを確認済みにするハンドラー（インシデントの状態は変更しません）
+func AcknowledgeEscalation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		incidentID, ok := parseIncidentIDParam(c)
+		if !ok {
+			return
+		}
+
+		var req AcknowledgeEscalationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err, zap.Uint("incident_id", incidentID))
+			return
+		}
+
+		if err := acknowledgeEscalation(db, incidentID, req.AcknowledgedBy); err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Uint("incident_id", incidentID))
+			return
+		}
+
+		logger.Logger.Info("エスカレーションを確認済みにしました",
+			zap.Uint("incident_id", incidentID),
+			zap.String("acknowledged_by", req.AcknowledgedBy),
+		)
+		c.JSON(http.StatusOK, gin.H{"incident_id": incidentID, "acknowledged": true})
+	}
+}
@@ -88,6 +88,7 @@ func GetIncident(db *gorm.DB) gin.HandlerFunc {
 			Preload("Relations").
 			Preload("Relations.RelatedIncident").
 			Preload("APIData").
+			Preload("MaintenanceWindow").
 			First(&incident, id).Error
 
 		if err != nil {
@@ -100,6 +101,16 @@ func GetIncident(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		// タイムライン用にメッセージの処理のステップを含める（取得に失敗してもインシデントは返す）
+		if incident.MessageID != "" {
+			if err := db.Where("message_id = ?", incident.MessageID).
+				Order("occurred_at ASC, id ASC").
+				Find(&incident.Events).Error; err != nil {
+				logger.Logger.Warn("メッセージの処理のステップの取得に失敗しました",
+					append(logFields, zap.Error(err))...)
+			}
+		}
+
 		logger.Logger.Info("インシデントを取得しました",
 			append(logFields,
 				zap.String("status", incident.Status),
@@ -164,10 +175,10 @@ func GetIncidentAll(db *gorm.DB) gin.HandlerFunc {
 
 		// トランザクション処理
 		err = withTransaction(db, c, logFields, func(tx *gorm.DB) error {
-			// 有効なインシデントIDを取得
+			// 有効なインシデントIDを取得（件名が欠損していても部分的なデータとして記録されたものは含める）
 			validIncidentIDs := tx.Model(&models.APIResponseData{}).
 				Select("incident_id").
-				Where("subject IS NOT NULL AND subject != ''")
+				Where("(subject IS NOT NULL AND subject != '') OR is_partial = ?", true)
 
 			// メインクエリ構築
 			query := tx.Model(&models.Incident{}).
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"dbpilot/logger"
+	"dbpilot/models"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultGraphDepth = 2
+	maxGraphDepth     = 5
+)
+
+// IncidentGraphNode は関連グラフのノード（インシデント）
+type IncidentGraphNode struct {
+	ID       uint   `json:"id"`
+	Depth    int    `json:"depth"`
+	Status   string `json:"status"`
+	Assignee string `json:"assignee"`
+	Subject  string `json:"subject"`
+	Priority string `json:"priority"`
+	Judgment string `json:"judgment"`
+}
+
+// IncidentGraphEdge は関連グラフのエッジ（インシデント関連）
+type IncidentGraphEdge struct {
+	ID     uint `json:"id"`
+	Source uint `json:"source"`
+	Target uint `json:"target"`
+}
+
+// GetIncidentGraph はインシデント関連を指定の深さまで辿り、ノードとエッジの構造で返すハンドラー
+func GetIncidentGraph(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logFields := []zap.Field{
+			zap.String("handler", "GetIncidentGraph"),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+		}
+
+		idStr := c.Param("id")
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			logger.Logger.Warn("無効なIDが指定されました",
+				append(logFields, zap.String("id", idStr))...)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無効なIDです"})
+			return
+		}
+		rootID := uint(id)
+
+		depth := defaultGraphDepth
+		if depthStr := c.Query("depth"); depthStr != "" {
+			depth, err = strconv.Atoi(depthStr)
+			if err != nil || depth < 1 || depth > maxGraphDepth {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "depth must be between 1 and 5"})
+				return
+			}
+		}
+
+		logFields = append(logFields, zap.Uint("incident_id", rootID), zap.Int("depth", depth))
+
+		var root models.Incident
+		if err := db.First(&root, rootID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				logger.Logger.Info("インシデントが見つかりませんでした", logFields...)
+				c.JSON(http.StatusNotFound, gin.H{"error": "インシデントが見つかりません"})
+			} else {
+				logAndReturnError(c, http.StatusInternalServerError, err, "FETCH_ERROR", logFields)
+			}
+			return
+		}
+
+		// 幅優先探索で関連を辿る（関連は双方向として扱う）
+		depths := map[uint]int{rootID: 0}
+		order := []uint{rootID}
+		seenEdges := map[uint]bool{}
+		edges := []IncidentGraphEdge{}
+		frontier := []uint{rootID}
+
+		for level := 1; level <= depth && len(frontier) > 0; level++ {
+			var relations []models.IncidentRelation
+			if err := db.Where("incident_id IN ? OR related_incident_id IN ?", frontier, frontier).
+				Find(&relations).Error; err != nil {
+				logAndReturnError(c, http.StatusInternalServerError, err, "FETCH_ERROR", logFields)
+				return
+			}
+
+			var next []uint
+			for _, relation := range relations {
+				if !seenEdges[relation.ID] {
+					seenEdges[relation.ID] = true
+					edges = append(edges, IncidentGraphEdge{
+						ID:     relation.ID,
+						Source: relation.IncidentID,
+						Target: relation.RelatedIncidentID,
+					})
+				}
+
+				for _, nodeID := range []uint{relation.IncidentID, relation.RelatedIncidentID} {
+					if _, ok := depths[nodeID]; !ok {
+						depths[nodeID] = level
+						order = append(order, nodeID)
+						next = append(next, nodeID)
+					}
+				}
+			}
+			frontier = next
+		}
+
+		var incidents []models.Incident
+		if err := db.Preload("APIData").Where("id IN ?", order).Find(&incidents).Error; err != nil {
+			logAndReturnError(c, http.StatusInternalServerError, err, "FETCH_ERROR", logFields)
+			return
+		}
+
+		incidentByID := make(map[uint]models.Incident, len(incidents))
+		for _, incident := range incidents {
+			incidentByID[incident.ID] = incident
+		}
+
+		nodes := make([]IncidentGraphNode, 0, len(order))
+		for _, nodeID := range order {
+			incident, ok := incidentByID[nodeID]
+			if !ok {
+				continue
+			}
+			nodes = append(nodes, IncidentGraphNode{
+				ID:       incident.ID,
+				Depth:    depths[nodeID],
+				Status:   incident.Status,
+				Assignee: incident.Assignee,
+				Subject:  incident.APIData.Subject,
+				Priority: incident.APIData.Priority,
+				Judgment: incident.APIData.Judgment,
+			})
+		}
+
+		logger.Logger.Info("インシデント関連グラフを取得しました",
+			append(logFields,
+				zap.Int("nodes", len(nodes)),
+				zap.Int("edges", len(edges)))...)
+
+		c.JSON(http.StatusOK, gin.H{
+			"root":  rootID,
+			"depth": depth,
+			"nodes": nodes,
+			"edges": edges,
+		})
+	}
+}
@@ -74,6 +74,9 @@ func CreateIncident(db *gorm.DB) gin.HandlerFunc {
 				return
 			}
 
+			// 失敗したワークフローもトークンを消費するため利用量に含める
+			recordCostUsage(db, &apiRequest, "", logFields)
+
 			logger.Logger.Info("エラーログを保存しました",
 				append(logFields, zap.Uint("error_log_id", errorLog.ID))...)
 			c.JSON(http.StatusOK, gin.H{
@@ -83,6 +86,20 @@ func CreateIncident(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		// 出力項目の欠損チェック
+		outputs := apiRequest.Data.Outputs
+		missingFields := outputs.MissingFields()
+		isPartial := len(missingFields) > 0
+		if isPartial {
+			logger.Logger.Warn("AI出力に欠損している項目があります",
+				append(logFields, zap.Strings("missing_fields", missingFields))...)
+		}
+
+		missingFieldsJSON, err := json.Marshal(missingFields)
+		if err != nil {
+			missingFieldsJSON = []byte("[]")
+		}
+
 		// 成功時の処理
 		datetime := time.Unix(apiRequest.Data.CreatedAt, 0)
 		tx := db.Begin()
@@ -110,6 +127,19 @@ func CreateIncident(db *gorm.DB) gin.HandlerFunc {
 			MessageID: apiRequest.MessageID,
 		}
 
+		// 告知済みのメンテナンスの期間中であれば期間を記録する（確認に失敗してもインシデントは作成する）
+		maintenance, err := activeMaintenanceWindow(tx, datetime)
+		if err != nil {
+			logger.Logger.Warn("メンテナンスの期間の確認に失敗しました",
+				append(logFields, zap.Error(err))...)
+		}
+		if maintenance != nil {
+			incident.MaintenanceWindowID = &maintenance.ID
+			if maintenance.Suppress {
+				incident.Status = maintenanceSuppressedStatus
+			}
+		}
+
 		if err := tx.Create(&incident).Error; err != nil {
 			tx.Rollback()
 			logger.Logger.Error("インシデントの作成に失敗しました",
@@ -121,6 +151,30 @@ func CreateIncident(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		if maintenance != nil && maintenance.Suppress {
+			response := models.Response{
+				IncidentID: incident.ID,
+				Datetime:   time.Now(),
+				Responder:  "system",
+				Content: fmt.Sprintf("メンテナンス「%s」（%s〜%s）の期間中のため、自動で解決済みにしました",
+					maintenance.Summary,
+					maintenance.StartAt.Format("2006/01/02 15:04"),
+					maintenance.EndAt.Format("2006/01/02 15:04")),
+			}
+			if err := tx.Create(&response).Error; err != nil {
+				tx.Rollback()
+				logger.Logger.Error("対応履歴の作成に失敗しました",
+					append(logFields, zap.Error(err))...)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error":   "Failed to create response",
+					"details": err.Error(),
+				})
+				return
+			}
+			logger.Logger.Info("メンテナンスの期間中のためインシデントを自動で解決済みにしました",
+				append(logFields, zap.Uint("maintenance_window_id", maintenance.ID))...)
+		}
+
 		// WorkflowLogsの処理
 		workflowLogsJSON, err := json.Marshal(apiRequest.Data.Outputs.WorkflowLogs)
 		if err != nil {
@@ -137,27 +191,37 @@ func CreateIncident(db *gorm.DB) gin.HandlerFunc {
 			WorkflowID:    apiRequest.Data.WorkflowID,
 			Status:        apiRequest.Data.Status,
 
-			Body:         apiRequest.Data.Outputs.Body,
-			User:         apiRequest.Data.Outputs.User,
+			Body:         models.StringValue(outputs.Body),
+			User:         models.StringValue(outputs.User),
 			WorkflowLogs: string(workflowLogsJSON),
-			Host:         apiRequest.Data.Outputs.Host,
-			Priority:     apiRequest.Data.Outputs.Priority,
-			Subject:      apiRequest.Data.Outputs.Subject,
-			From:         apiRequest.Data.Outputs.From,
-			Place:        apiRequest.Data.Outputs.Place,
-			IncidentText: apiRequest.Data.Outputs.Incident,
-			Time:         apiRequest.Data.Outputs.Time,
-			Judgment:     apiRequest.Data.Outputs.Judgment,
-			Sender:       apiRequest.Data.Outputs.Sender,
-			Final:        apiRequest.Data.Outputs.Final,
-
-			ElapsedTime: apiRequest.Data.ElapsedTime,
-			TotalTokens: apiRequest.Data.TotalTokens,
-			TotalSteps:  apiRequest.Data.TotalSteps,
-			CreatedAt:   apiRequest.Data.CreatedAt,
-			FinishedAt:  apiRequest.Data.FinishedAt,
-			Error:       fmt.Sprintf("%v", apiRequest.Data.Error),
-			RawResponse: string(rawJSON),
+			Host:         models.StringValue(outputs.Host),
+			Priority:     models.StringValue(outputs.Priority),
+			Subject:      models.StringValue(outputs.Subject),
+			From:         models.StringValue(outputs.From),
+			Place:        models.StringValue(outputs.Place),
+			IncidentText: models.StringValue(outputs.Incident),
+			Time:         models.StringValue(outputs.Time),
+			Judgment:     models.StringValue(outputs.Judgment),
+			Sender:       models.StringValue(outputs.Sender),
+			Final:        models.StringValue(outputs.Final),
+
+			ElapsedTime:     apiRequest.Data.ElapsedTime,
+			TotalTokens:     apiRequest.Data.TotalTokens,
+			TotalSteps:      apiRequest.Data.TotalSteps,
+			CreatedAt:       apiRequest.Data.CreatedAt,
+			FinishedAt:      apiRequest.Data.FinishedAt,
+			Error:           fmt.Sprintf("%v", apiRequest.Data.Error),
+			RawResponse:     string(rawJSON),
+			Provider:        apiRequest.Provider,
+			WorkflowVersion: apiRequest.WorkflowVersion,
+			Language:        apiRequest.Language,
+
+			ContentHash:         apiRequest.ContentHash,
+			CacheHit:            apiRequest.CacheHit,
+			CachedFromMessageID: apiRequest.CachedFromMessageID,
+
+			IsPartial:     isPartial,
+			MissingFields: string(missingFieldsJSON),
 		}
 
 		if err := tx.Create(&apiData).Error; err != nil {
@@ -181,10 +245,21 @@ func CreateIncident(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		recordCostUsage(db, &apiRequest, models.StringValue(outputs.From), logFields)
+
+		// 処理状態からインシデントを参照できるようにする（完了通知のコールバックで使用）
+		if err := db.Model(&models.ProcessingStatus{}).
+			Where("message_id = ?", apiRequest.MessageID).
+			Update("incident_id", incident.ID).Error; err != nil {
+			logger.Logger.Warn("処理状態へのインシデントIDの設定に失敗しました",
+				append(logFields, zap.Error(err))...)
+		}
+
 		logger.Logger.Info("インシデントを作成しました",
 			append(logFields,
 				zap.Uint("incident_id", incident.ID),
-				zap.String("subject", apiData.Subject))...)
+				zap.String("subject", apiData.Subject),
+				zap.Bool("is_partial", isPartial))...)
 
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Incident created successfully",
@@ -193,6 +268,10 @@ func CreateIncident(db *gorm.DB) gin.HandlerFunc {
 				"incident": incident,
 				"api_data": apiData,
 			},
+			"validation": gin.H{
+				"complete":       !isPartial,
+				"missing_fields": missingFields,
+			},
 		})
 	}
 }
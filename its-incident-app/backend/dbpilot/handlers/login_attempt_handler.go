@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RecordLoginFailureRequest struct {
+	Email               string `json:"email" binding:"required"`
+	MaxAttempts         int    `json:"max_attempts" binding:"required,min=1"`
+	LockDurationSeconds int    `json:"lock_duration_seconds" binding:"required,min=1"`
+}
+
+type ResetLoginAttemptsRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// LoginAttemptResponse はアカウント単位のログイン失敗状況
+type LoginAttemptResponse struct {
+	Email          string     `json:"email"`
+	FailedCount    int        `json:"failed_count"`
+	Locked         bool       `json:"locked"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+	LockoutStarted bool       `json:"lockout_started,omitempty"`
+}
+
+// GetLoginAttempt はメールアドレスのログイン失敗回数とロックアウト状態を返すハンドラー
+func GetLoginAttempt(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email := normalizeLoginEmail(c.Query("email"))
+		if email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+			return
+		}
+
+		var attempt models.LoginAttempt
+		if err := db.Where("email = ?", email).First(&attempt).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusOK, LoginAttemptResponse{Email: email})
+				return
+			}
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, newLoginAttemptResponse(attempt, time.Now()))
+	}
+}
+
+// RecordLoginFailure はログイン失敗を記録し、上限に達した場合はアカウントを一定時間ロックするハンドラー。
+// ロック期間を過ぎた失敗回数はリセットしてから数えます
+func RecordLoginFailure(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RecordLoginFailureRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+		email := normalizeLoginEmail(req.Email)
+		lockDuration := time.Duration(req.LockDurationSeconds) * time.Second
+
+		var attempt models.LoginAttempt
+		lockoutStarted := false
+		now := time.Now()
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// 同時に失敗した場合も正しく数えるため、行を作成してからロックして更新する
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&models.LoginAttempt{Email: email}).Error; err != nil {
+				return err
+			}
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("email = ?", email).First(&attempt).Error; err != nil {
+				return err
+			}
+
+			// ロック中の失敗は数えない
+			if attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil) {
+				return nil
+			}
+			if attempt.LastFailedAt != nil && now.Sub(*attempt.LastFailedAt) > lockDuration {
+				attempt.FailedCount = 0
+			}
+
+			attempt.FailedCount++
+			attempt.LastFailedAt = &now
+			attempt.LockedUntil = nil
+			if attempt.FailedCount >= req.MaxAttempts {
+				lockedUntil := now.Add(lockDuration)
+				attempt.LockedUntil = &lockedUntil
+				attempt.FailedCount = 0
+				lockoutStarted = true
+			}
+
+			return tx.Model(&attempt).Updates(map[string]interface{}{
+				"failed_count":   attempt.FailedCount,
+				"last_failed_at": attempt.LastFailedAt,
+				"locked_until":   attempt.LockedUntil,
+			}).Error
+		})
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.String("email", email))
+			return
+		}
+
+		if lockoutStarted {
+			logger.Logger.Warn("ログイン失敗回数が上限に達したためアカウントを一時ロックしました",
+				zap.String("email", email),
+				zap.Time("locked_until", *attempt.LockedUntil))
+		}
+
+		resp := newLoginAttemptResponse(attempt, now)
+		resp.LockoutStarted = lockoutStarted
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// ResetLoginAttempts はログイン成功時に失敗回数をリセットするハンドラー
+func ResetLoginAttempts(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ResetLoginAttemptsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+		email := normalizeLoginEmail(req.Email)
+
+		if err := db.Model(&models.LoginAttempt{}).
+			Where("email = ? AND (locked_until IS NULL OR locked_until <= ?)", email, time.Now()).
+			Updates(map[string]interface{}{
+				"failed_count":   0,
+				"last_failed_at": nil,
+				"locked_until":   nil,
+			}).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.String("email", email))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Login attempts reset successfully"})
+	}
+}
+
+func newLoginAttemptResponse(attempt models.LoginAttempt, now time.Time) LoginAttemptResponse {
+	resp := LoginAttemptResponse{Email: attempt.Email, FailedCount: attempt.FailedCount}
+	if attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil) {
+		resp.Locked = true
+		resp.LockedUntil = attempt.LockedUntil
+	}
+	return resp
+}
+
+func normalizeLoginEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
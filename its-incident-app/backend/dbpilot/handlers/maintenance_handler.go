@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maintenanceSuppressedStatus はメンテナンスの期間中のため自動で解決済みにしたインシデントの状態
+const maintenanceSuppressedStatus = "解決済み"
+
+type UpdateMaintenanceWindowRequest struct {
+	Suppress  *bool `json:"suppress"`
+	Cancelled *bool `json:"cancelled"`
+}
+
+// recordMaintenanceWindow はカレンダー招待を含むメールからメンテナンスの期間を保存します。
+// 同じUIDの告知（日時の変更・中止）は既存の期間を更新し、運用者が設定した Suppress は残します
+func recordMaintenanceWindow(db *gorm.DB, email *models.EmailData, logFields []zap.Field) {
+	if email.CalendarStart == nil || email.CalendarEnd == nil {
+		return
+	}
+
+	uid := email.CalendarUID
+	if uid == "" {
+		uid = email.MessageID
+	}
+	window := models.MaintenanceWindow{
+		UID:       truncateRunes(uid, 255),
+		Summary:   truncateRunes(email.CalendarSummary, 255),
+		StartAt:   *email.CalendarStart,
+		EndAt:     *email.CalendarEnd,
+		Organizer: truncateRunes(email.EmailFrom, 255),
+		MessageID: email.MessageID,
+		Cancelled: email.CalendarMethod == "CANCEL",
+	}
+	if window.EndAt.Before(window.StartAt) {
+		window.EndAt = window.StartAt
+	}
+
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "uid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"summary", "start_at", "end_at", "organizer", "message_id", "cancelled", "updated_at"}),
+	}).Create(&window).Error
+	if err != nil {
+		logger.Logger.Warn("メンテナンスの期間の保存に失敗しました", append(logFields, zap.Error(err))...)
+		return
+	}
+
+	logger.Logger.Info("メンテナンスの期間を保存しました",
+		append(logFields,
+			zap.String("uid", window.UID),
+			zap.String("summary", window.Summary),
+			zap.Time("start_at", window.StartAt),
+			zap.Time("end_at", window.EndAt),
+			zap.Bool("cancelled", window.Cancelled))...)
+}
+
+// activeMaintenanceWindow は at を含む告知済みのメンテナンスの期間を返します（抑止する期間を優先）。ない場合は nil を返します
+func activeMaintenanceWindow(db *gorm.DB, at time.Time) (*models.MaintenanceWindow, error) {
+	var window models.MaintenanceWindow
+	err := db.Where("start_at <= ? AND end_at > ? AND cancelled = ?", at, at, false).
+		Order("suppress DESC, start_at DESC").
+		First(&window).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &window, nil
+}
+
+// ListMaintenanceWindows はメンテナンスの期間の一覧を取得するハンドラー（既定は終了していない期間のみ、?all=true で終了・中止したものも含む）
+func ListMaintenanceWindows(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 100
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+
+		query := db.Model(&models.MaintenanceWindow{})
+		if c.Query("all") != "true" {
+			query = query.Where("end_at > ? AND cancelled = ?", time.Now(), false)
+		}
+
+		var windows []models.MaintenanceWindow
+		if err := query.Order("start_at ASC").Limit(limit).Find(&windows).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"count": len(windows),
+			"data":  windows,
+		})
+	}
+}
+
+// UpdateMaintenanceWindow はメンテナンスの期間の抑止・中止を設定するハンドラー
+func UpdateMaintenanceWindow(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+			return
+		}
+
+		var req UpdateMaintenanceWindowRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err, zap.Uint64("maintenance_window_id", id))
+			return
+		}
+
+		var window models.MaintenanceWindow
+		if err := db.First(&window, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance window not found"})
+				return
+			}
+			handleError(c, http.StatusInternalServerError, err, zap.Uint64("maintenance_window_id", id))
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if req.Suppress != nil {
+			window.Suppress = *req.Suppress
+			updates["suppress"] = window.Suppress
+		}
+		if req.Cancelled != nil {
+			window.Cancelled = *req.Cancelled
+			updates["cancelled"] = window.Cancelled
+		}
+		if len(updates) > 0 {
+			if err := db.Model(&window).Updates(updates).Error; err != nil {
+				handleError(c, http.StatusInternalServerError, err, zap.Uint64("maintenance_window_id", id))
+				return
+			}
+		}
+
+		logger.Logger.Info("メンテナンスの期間を更新しました",
+			zap.Uint64("maintenance_window_id", id),
+			zap.Bool("suppress", window.Suppress),
+			zap.Bool("cancelled", window.Cancelled),
+		)
+		c.JSON(http.StatusOK, window)
+	}
+}
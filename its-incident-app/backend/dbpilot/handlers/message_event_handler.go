@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// maxMessageEventsPerRequest は1回のリクエストで保存できるイベント数の上限
+const maxMessageEventsPerRequest = 500
+
+// SaveMessageEvents はautopilotから送られたメッセージの処理のステップをまとめて保存するハンドラー
+func SaveMessageEvents(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.MessageEventsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+		if len(req.Events) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "events is required"})
+			return
+		}
+		if len(req.Events) > maxMessageEventsPerRequest {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "too many events"})
+			return
+		}
+
+		for i := range req.Events {
+			event := &req.Events[i]
+			if event.MessageID == "" || event.Step == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "message_id and step are required", "index": i})
+				return
+			}
+			event.ID = 0
+			if event.OccurredAt.IsZero() {
+				event.OccurredAt = time.Now()
+			}
+		}
+
+		if err := db.Create(&req.Events).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Int("events", len(req.Events)))
+			return
+		}
+
+		logger.Logger.Debug("メッセージの処理のステップを保存しました", zap.Int("events", len(req.Events)))
+		c.JSON(http.StatusCreated, gin.H{"count": len(req.Events)})
+	}
+}
+
+// ListMessageEvents はメッセージの処理のステップを発生順に返すハンドラー
+func ListMessageEvents(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID := c.Param("messageID")
+
+		var events []models.MessageEvent
+		if err := db.Where("message_id = ?", messageID).
+			Order("occurred_at ASC, id ASC").
+			Find(&events).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.String("message_id", messageID))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message_id": messageID,
+			"events":     events,
+			"count":      len(events),
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// CreateNotificationDeliveryRequest は送信前の通知の登録に使用されるリクエスト構造体
+type CreateNotificationDeliveryRequest struct {
+	Channel     string `json:"channel" binding:"required"`
+	Target      string `json:"target" binding:"required"`
+	IncidentID  uint   `json:"incident_id"`
+	PayloadHash string `json:"payload_hash"`
+	Payload     string `json:"payload"`
+}
+
+// UpdateNotificationDeliveryRequest は送信の結果を記録するリクエスト構造体。
+// ExpectedAttempts を指定した場合は試行回数が一致する場合だけ更新し、一致しない場合（別のインスタンスが再送済み）は 409 を返します
+type UpdateNotificationDeliveryRequest struct {
+	Status           string     `json:"status" binding:"required,oneof=pending sent retrying failed"`
+	Attempts         int        `json:"attempts"`
+	ExpectedAttempts *int       `json:"expected_attempts"`
+	LastError        string     `json:"last_error"`
+	NextAttemptAt    *time.Time `json:"next_attempt_at"`
+}
+
+// parseDeliveryTime は期間の指定（RFC3339 または日付）を返します。日付のみの場合は日本時間のその日の0時です
+func parseDeliveryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		jst = time.FixedZone("JST", 9*60*60)
+	}
+	return time.ParseInLocation("2006-01-02", value, jst)
+}
+
+// ListNotificationDeliveries は通知の送信状況の一覧を取得するハンドラー
+// （status・incident_id・channel・target（宛先）・from/to（送信日時）で絞り込み、?due=true で再送の予定を過ぎたもののみ）。
+// to に日付のみを指定した場合はその日の終わりまでを含みます
+func ListNotificationDeliveries(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 100
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+		offset, _ := strconv.Atoi(c.Query("offset"))
+		if offset < 0 {
+			offset = 0
+		}
+
+		query := db.Model(&models.NotificationDelivery{})
+		if c.Query("due") == "true" {
+			query = query.Where("status = ? AND next_attempt_at <= ?", models.DeliveryRetrying, time.Now())
+		}
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		if channel := c.Query("channel"); channel != "" {
+			query = query.Where("channel = ?", channel)
+		}
+		if incidentID, err := strconv.ParseUint(c.Query("incident_id"), 10, 64); err == nil {
+			query = query.Where("incident_id = ?", incidentID)
+		}
+		if target := strings.TrimSpace(c.Query("target")); target != "" {
+			query = query.Where("LOWER(target) = ?", strings.ToLower(target))
+		}
+		if value := c.Query("from"); value != "" {
+			from, err := parseDeliveryTime(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from"})
+				return
+			}
+			query = query.Where("created_at >= ?", from)
+		}
+		if value := c.Query("to"); value != "" {
+			to, err := parseDeliveryTime(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to"})
+				return
+			}
+			if len(value) == len("2006-01-02") {
+				to = to.AddDate(0, 0, 1)
+			}
+			query = query.Where("created_at < ?", to)
+		}
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		var deliveries []models.NotificationDelivery
+		if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&deliveries).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"count":  len(deliveries),
+			"total":  total,
+			"offset": offset,
+			"limit":  limit,
+			"data":   deliveries,
+		})
+	}
+}
+
+// CreateNotificationDelivery は送信する通知を登録するハンドラー
+func CreateNotificationDelivery(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateNotificationDeliveryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		delivery := models.NotificationDelivery{
+			Channel:     truncateRunes(req.Channel, 50),
+			Target:      truncateRunes(req.Target, 255),
+			IncidentID:  req.IncidentID,
+			PayloadHash: truncateRunes(req.PayloadHash, 64),
+			Payload:     req.Payload,
+			Status:      models.DeliveryPending,
+		}
+		if err := db.Create(&delivery).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.String("channel", req.Channel))
+			return
+		}
+		c.JSON(http.StatusOK, delivery)
+	}
+}
+
+// UpdateNotificationDelivery は通知の送信の結果（成功・再送の予定・失敗）を記録するハンドラー
+func UpdateNotificationDelivery(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+			return
+		}
+
+		var req UpdateNotificationDeliveryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err, zap.Uint64("delivery_id", id))
+			return
+		}
+
+		updates := map[string]interface{}{
+			"status":          req.Status,
+			"attempts":        req.Attempts,
+			"last_error":      req.LastError,
+			"next_attempt_at": req.NextAttemptAt,
+		}
+		if req.Status == models.DeliverySent {
+			updates["delivered_at"] = time.Now()
+		}
+
+		query := db.Model(&models.NotificationDelivery{}).Where("id = ?", id)
+		if req.ExpectedAttempts != nil {
+			query = query.Where("attempts = ?", *req.ExpectedAttempts)
+		}
+		result := query.Updates(updates)
+		if result.Error != nil {
+			handleError(c, http.StatusInternalServerError, result.Error, zap.Uint64("delivery_id", id))
+			return
+		}
+		if result.RowsAffected == 0 {
+			var count int64
+			if err := db.Model(&models.NotificationDelivery{}).Where("id = ?", id).Count(&count).Error; err != nil {
+				handleError(c, http.StatusInternalServerError, err, zap.Uint64("delivery_id", id))
+				return
+			}
+			if count == 0 {
+				handleError(c, http.StatusNotFound, errors.New("notification delivery not found"), zap.Uint64("delivery_id", id))
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "Delivery already updated"})
+			return
+		}
+
+		if req.Status == models.DeliveryFailed {
+			logger.Logger.Warn("通知の送信に失敗しました（再送しません）",
+				zap.Uint64("delivery_id", id),
+				zap.Int("attempts", req.Attempts),
+				zap.String("last_error", req.LastError),
+			)
+		}
+		c.JSON(http.StatusOK, gin.H{"id": id, "status": req.Status})
+	}
+}
@@ -15,6 +15,7 @@ import (
 type ProfileRequest struct {
 	Name     string `json:"name"`
 	ImageURL string `json:"image_url"`
+	Locale   string `json:"locale"`
 }
 
 type ProfileResponse struct {
@@ -22,20 +23,20 @@ type ProfileResponse struct {
 	Email    string `json:"email"`
 	Name     string `json:"name"`
 	ImageURL string `json:"image_url"`
+	Locale   string `json:"locale"`
+}
+
+// UpdateLocaleRequest は通知の言語の変更に使用されるリクエスト構造体
+type UpdateLocaleRequest struct {
+	Locale string `json:"locale"`
 }
 
 // RegisterProfile はセッションからUserIDを取得し、プロフィールを登録します
 func RegisterProfile(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		sessionID, exists := c.Get("session")
-		if !exists {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Session not found"})
-			return
-		}
-
-		// セッションIDからUserIDを取得
-		var session models.LoginSession
-		if err := db.Where("session_id = ?", sessionID).First(&session).Error; err != nil {
+		// セッションからUserIDを取得
+		userID, ok := sessionUserID(c, db)
+		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
 			return
 		}
@@ -47,8 +48,14 @@ func RegisterProfile(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		locale, ok := models.NormalizeLocale(req.Locale)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported locale"})
+			return
+		}
+
 		// プロフィールの登録
-		profile := models.Profile{UserID: session.UserID, Name: req.Name, ImageURL: req.ImageURL}
+		profile := models.Profile{UserID: userID, Name: req.Name, ImageURL: req.ImageURL, Locale: locale}
 		if err := db.Create(&profile).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create profile"})
 			return
@@ -61,28 +68,11 @@ func RegisterProfile(db *gorm.DB) gin.HandlerFunc {
 // GetProfile はセッションIDを使ってユーザーのプロフィール情報を取得します
 func GetProfile(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// セッション情報の取得
-		sessionID, exists := c.Get("session")
-		if !exists {
-			logger.Logger.Error("セッション情報が見つかりません")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Session not found"})
-			return
-		}
-
-		// 文字列型へ変換
-		sessionIDStr, ok := sessionID.(string)
+		// セッションから利用者を特定
+		userID, ok := sessionUserID(c, db)
 		if !ok {
-			logger.Logger.Error("セッションIDの型が不正です")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid session format"})
-			return
-		}
-
-		// セッションの検証
-		var session models.LoginSession
-		if err := db.Where("session_id = ?", sessionIDStr).First(&session).Error; err != nil {
 			logger.Logger.Error("セッションの検証に失敗しました",
-				zap.Error(err),
-				zap.String("session_id", sessionIDStr),
+				zap.String("session_id", c.GetString("session")),
 			)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
 			return
@@ -90,10 +80,10 @@ func GetProfile(db *gorm.DB) gin.HandlerFunc {
 
 		// ユーザーとプロフィール情報の取得
 		var user models.User
-		if err := db.Preload("Profile").Where("id = ?", session.UserID).First(&user).Error; err != nil {
+		if err := db.Preload("Profile").Where("id = ?", userID).First(&user).Error; err != nil {
 			logger.Logger.Error("ユーザーまたはプロフィール情報の取得に失敗しました",
 				zap.Error(err),
-				zap.Uint("user_id", session.UserID),
+				zap.Uint("user_id", userID),
 			)
 			c.JSON(http.StatusNotFound, gin.H{"error": "User or profile not found"})
 			return
@@ -104,6 +94,45 @@ func GetProfile(db *gorm.DB) gin.HandlerFunc {
 			Email:    user.Email,
 			Name:     user.Profile.Name,
 			ImageURL: user.Profile.ImageURL,
+			Locale:   user.Profile.Locale,
 		})
 	}
 }
+
+// UpdateProfileLocale はセッションの利用者の通知の言語を変更します（空の場合は既定の言語に戻します）
+func UpdateProfileLocale(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := sessionUserID(c, db)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+			return
+		}
+
+		var req UpdateLocaleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		locale, ok := models.NormalizeLocale(req.Locale)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported locale"})
+			return
+		}
+
+		profile := models.Profile{UserID: userID}
+		if err := db.Where("user_id = ?", userID).FirstOrCreate(&profile).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Uint("user_id", userID))
+			return
+		}
+		if err := db.Model(&profile).Update("locale", locale).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.Uint("user_id", userID))
+			return
+		}
+
+		logger.Logger.Info("通知の言語を変更しました",
+			zap.Uint("user_id", userID),
+			zap.String("locale", locale),
+		)
+		c.JSON(http.StatusOK, gin.H{"locale": locale})
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ProvisionUserRequest は外部IdPで認証されたユーザーのプロビジョニングリクエスト
+type ProvisionUserRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Name     string `json:"name"`
+	ImageURL string `json:"image_url"`
+	Provider string `json:"provider" binding:"required"`
+	Subject  string `json:"subject" binding:"required"`
+}
+
+// ProvisionUser はSSOログイン時にユーザーとプロフィールを必要に応じて作成するハンドラー
+func ProvisionUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logFields := []zap.Field{
+			zap.String("handler", "ProvisionUser"),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+		}
+
+		var req ProvisionUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logger.Logger.Warn("不正なプロビジョニングリクエスト",
+				append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		logFields = append(logFields,
+			zap.String("email", req.Email),
+			zap.String("provider", req.Provider))
+
+		var user models.User
+		created := false
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// IdPのサブジェクトで紐付け済みのユーザーを優先し、なければメールアドレスで検索
+			err := tx.Where("auth_provider = ? AND external_subject = ?", req.Provider, req.Subject).
+				First(&user).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				err = tx.Where("email = ?", req.Email).First(&user).Error
+			}
+
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				user = models.User{
+					Email:           req.Email,
+					AuthProvider:    req.Provider,
+					ExternalSubject: req.Subject,
+				}
+				if err := tx.Create(&user).Error; err != nil {
+					return err
+				}
+				created = true
+			case err != nil:
+				return err
+			case user.ExternalSubject == "":
+				// 既存ユーザーを初回SSOログイン時にIdPと紐付ける
+				if err := tx.Model(&user).Updates(models.User{
+					AuthProvider:    req.Provider,
+					ExternalSubject: req.Subject,
+				}).Error; err != nil {
+					return err
+				}
+			}
+
+			// プロフィールは未作成の場合のみIdPの属性で作成する
+			profile := models.Profile{UserID: user.ID}
+			return tx.Where("user_id = ?", user.ID).
+				Attrs(models.Profile{Name: req.Name, ImageURL: req.ImageURL}).
+				FirstOrCreate(&profile).Error
+		})
+		if err != nil {
+			logger.Logger.Error("ユーザーのプロビジョニングに失敗しました",
+				append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision user"})
+			return
+		}
+
+		logger.Logger.Info("ユーザーのプロビジョニングが完了しました",
+			append(logFields,
+				zap.Uint("user_id", user.ID),
+				zap.Bool("created", created))...)
+
+		c.JSON(http.StatusOK, gin.H{
+			"id":      user.ID,
+			"email":   user.Email,
+			"created": created,
+		})
+	}
+}
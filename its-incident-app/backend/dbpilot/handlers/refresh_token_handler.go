@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errRefreshTokenNotFound = errors.New("refresh token not found")
+	errRefreshTokenExpired  = errors.New("refresh token expired")
+	errRefreshTokenReused   = errors.New("refresh token reuse detected")
+)
+
+type CreateRefreshTokenRequest struct {
+	TokenHash string    `json:"token_hash" binding:"required"`
+	FamilyID  string    `json:"family_id" binding:"required"`
+	SessionID string    `json:"session_id" binding:"required"`
+	UserID    uint      `json:"user_id" binding:"required"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at" binding:"required"`
+}
+
+type RotateRefreshTokenRequest struct {
+	TokenHash        string    `json:"token_hash" binding:"required"`
+	NewTokenHash     string    `json:"new_token_hash" binding:"required"`
+	ExpiresAt        time.Time `json:"expires_at" binding:"required"`
+	SessionExpiresAt time.Time `json:"session_expires_at" binding:"required"`
+}
+
+// CreateRefreshToken はセッションに紐づくリフレッシュトークンを保存するハンドラー
+func CreateRefreshToken(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateRefreshTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		token := models.RefreshToken{
+			TokenHash: req.TokenHash,
+			FamilyID:  req.FamilyID,
+			SessionID: req.SessionID,
+			UserID:    req.UserID,
+			Email:     req.Email,
+			ExpiresAt: req.ExpiresAt,
+		}
+		if err := db.Create(&token).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err,
+				zap.String("session_id", req.SessionID))
+			return
+		}
+
+		logger.Logger.Info("リフレッシュトークンを作成しました",
+			zap.Uint("user_id", req.UserID),
+			zap.String("family_id", req.FamilyID))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Refresh token created successfully"})
+	}
+}
+
+// RotateRefreshToken はリフレッシュトークンを使用済みにして新しいトークンへ置き換え、セッションを延長するハンドラー。
+// 使用済みトークンが再提示された場合は漏洩とみなし、同じ系列のトークンとセッションをすべて失効させます
+func RotateRefreshToken(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logFields := []zap.Field{
+			zap.String("handler", "RotateRefreshToken"),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+		}
+
+		var req RotateRefreshTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		var current models.RefreshToken
+		var session models.LoginSession
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("token_hash = ?", req.TokenHash).
+				First(&current).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return errRefreshTokenNotFound
+				}
+				return err
+			}
+
+			if current.UsedAt != nil || current.RevokedAt != nil {
+				return errRefreshTokenReused
+			}
+
+			now := time.Now()
+			if now.After(current.ExpiresAt) {
+				return errRefreshTokenExpired
+			}
+
+			if err := tx.Where("session_id = ?", current.SessionID).First(&session).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return errRefreshTokenNotFound
+				}
+				return err
+			}
+
+			if err := tx.Model(&current).Updates(map[string]interface{}{
+				"used_at":     now,
+				"replaced_by": req.NewTokenHash,
+			}).Error; err != nil {
+				return err
+			}
+
+			next := models.RefreshToken{
+				TokenHash: req.NewTokenHash,
+				FamilyID:  current.FamilyID,
+				SessionID: current.SessionID,
+				UserID:    current.UserID,
+				Email:     current.Email,
+				ExpiresAt: req.ExpiresAt,
+			}
+			if err := tx.Create(&next).Error; err != nil {
+				return err
+			}
+
+			session.ExpiresAt = req.SessionExpiresAt
+			return tx.Model(&session).Update("expires_at", req.SessionExpiresAt).Error
+		})
+
+		switch {
+		case err == nil:
+		case errors.Is(err, errRefreshTokenReused):
+			revokeRefreshTokenFamily(db, current, logFields)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "reuse_detected": true})
+			return
+		case errors.Is(err, errRefreshTokenNotFound), errors.Is(err, errRefreshTokenExpired):
+			logger.Logger.Info("リフレッシュトークンが無効です", append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		default:
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		logger.Logger.Info("リフレッシュトークンをローテーションしました",
+			append(logFields,
+				zap.Uint("user_id", current.UserID),
+				zap.String("family_id", current.FamilyID))...)
+
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":    session.UserID,
+			"email":      session.Email,
+			"session_id": session.SessionID,
+			"expires_at": session.ExpiresAt,
+		})
+	}
+}
+
+// revokeRefreshTokenFamily は再利用が検知されたトークン系列とそのセッションを失効させます
+func revokeRefreshTokenFamily(db *gorm.DB, token models.RefreshToken, logFields []zap.Field) {
+	logFields = append(logFields,
+		zap.Uint("user_id", token.UserID),
+		zap.String("family_id", token.FamilyID))
+
+	logger.Logger.Warn("リフレッシュトークンの再利用を検知しました", logFields...)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", token.FamilyID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Where("session_id = ?", token.SessionID).Delete(&models.LoginSession{}).Error
+	})
+	if err != nil {
+		logger.Logger.Error("トークン系列の失効に失敗しました", append(logFields, zap.Error(err))...)
+	}
+}
@@ -115,6 +115,19 @@ func CreateResponse(db *gorm.DB) gin.HandlerFunc {
 			zap.String("assignee", req.Responder),
 		)
 
+		// 未着手以外の状態にした対応はインシデントの確認とみなし、エスカレーションを止める
+		if req.Status != "" && req.Status != unacknowledgedStatus {
+			if err := acknowledgeEscalation(tx, req.IncidentID, req.Responder); err != nil {
+				tx.Rollback()
+				logger.Logger.Error("エスカレーションの停止に失敗",
+					zap.Error(err),
+					zap.Uint("incident_id", req.IncidentID),
+				)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update escalation"})
+				return
+			}
+		}
+
 		// トランザクションをコミット
 		if err := tx.Commit().Error; err != nil {
 			logger.Logger.Error("トランザクションのコミットに失敗",
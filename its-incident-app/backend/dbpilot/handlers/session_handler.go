@@ -6,6 +6,7 @@ import (
 
 	"dbpilot/logger"
 	"dbpilot/models"
+	"dbpilot/serviceauth"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -41,6 +42,25 @@ func CreateSession(db *gorm.DB) gin.HandlerFunc {
 			zap.Time("expires_at", req.ExpiresAt),
 		)
 
+		// ロック中のアカウントにはセッションを発行しない
+		locked, err := models.IsUserLocked(db, req.UserID)
+		if err != nil {
+			logger.Logger.Error("アカウント状態の確認に失敗",
+				zap.Error(err),
+				zap.Uint("user_id", req.UserID),
+			)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check account status"})
+			return
+		}
+		if locked {
+			logger.Logger.Warn("ロック中のアカウントのセッション作成を拒否しました",
+				zap.Uint("user_id", req.UserID),
+				zap.String("email", req.Email),
+			)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is locked", "code": "account_locked"})
+			return
+		}
+
 		// セッション情報を構造体に格納
 		session := &models.LoginSession{
 			UserID:    req.UserID,
@@ -159,3 +179,138 @@ func DeleteSession(db *gorm.DB) gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"message": "Session deleted successfully"})
 	}
 }
+
+// DeleteCurrentSession はリクエストに使用されたセッションのみを削除します
+func DeleteCurrentSession(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID, ok := c.Get("session")
+		sessionIDStr, isString := sessionID.(string)
+		if !ok || !isString || sessionIDStr == "" {
+			logger.Logger.Warn("セッション情報が見つかりません",
+				zap.String("client_ip", c.ClientIP()),
+			)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+			return
+		}
+
+		deleted, err := models.DeleteSessionByID(db, sessionIDStr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete session"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Session revoked successfully",
+			"deleted": deleted,
+		})
+	}
+}
+
+// GetCurrentSession はリクエストに使用されたセッションの情報を返します
+func GetCurrentSession(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID := c.GetString("session")
+		if sessionID == "" || serviceauth.IsServiceToken(sessionID) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+			return
+		}
+
+		session, err := models.GetSessionByID(db, sessionID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":        session.UserID,
+			"email":          session.Email,
+			"session_id":     session.SessionID,
+			"expires_at":     session.ExpiresAt,
+			"elevated_until": session.ElevatedUntil,
+		})
+	}
+}
+
+type ElevateSessionRequest struct {
+	ElevatedUntil time.Time `json:"elevated_until" binding:"required"`
+}
+
+// ElevateCurrentSession は再認証に成功したセッションに、期限付きの昇格フラグを設定します
+func ElevateCurrentSession(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID := c.GetString("session")
+		if sessionID == "" || serviceauth.IsServiceToken(sessionID) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+			return
+		}
+
+		var req ElevateSessionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		session, err := models.ElevateSession(db, sessionID, req.ElevatedUntil)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to elevate session"})
+			return
+		}
+
+		logger.Logger.Info("セッションを昇格しました",
+			zap.Uint("user_id", session.UserID),
+			zap.Time("elevated_until", *session.ElevatedUntil),
+		)
+
+		c.JSON(http.StatusOK, gin.H{
+			"session_id":     session.SessionID,
+			"elevated_until": session.ElevatedUntil,
+		})
+	}
+}
+
+// DeleteAllSessions はリクエストしたユーザーのすべてのセッションを削除します。
+// except_current=true の場合はリクエストに使用したセッションを残します（パスワード変更後など）
+func DeleteAllSessions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID := c.GetString("session")
+		if sessionID == "" || serviceauth.IsServiceToken(sessionID) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+			return
+		}
+
+		session, err := models.GetSessionByID(db, sessionID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session"})
+			return
+		}
+
+		exceptSessionID := ""
+		if c.Query("except_current") == "true" {
+			exceptSessionID = session.SessionID
+		}
+
+		sessionIDs, err := models.DeleteSessionsByUserID(db, session.UserID, exceptSessionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete sessions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Sessions revoked successfully",
+			"deleted":     len(sessionIDs),
+			"session_ids": sessionIDs,
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ShadowReportSummary はシャドウと本番の結果の比較の集計です
+type ShadowReportSummary struct {
+	Provider              string  `json:"provider"`
+	WorkflowVersion       string  `json:"workflow_version"`
+	Total                 int     `json:"total"`
+	Errors                int     `json:"errors"`
+	PriorityMatches       int     `json:"priority_matches"`
+	JudgmentMatches       int     `json:"judgment_matches"`
+	PriorityMatchRate     float64 `json:"priority_match_rate"`
+	JudgmentMatchRate     float64 `json:"judgment_match_rate"`
+	AvgPrimaryTotalTokens float64 `json:"avg_primary_total_tokens"`
+	AvgShadowTotalTokens  float64 `json:"avg_shadow_total_tokens"`
+	AvgPrimaryElapsedTime float64 `json:"avg_primary_elapsed_time"`
+	AvgShadowElapsedTime  float64 `json:"avg_shadow_elapsed_time"`
+}
+
+// SaveShadowResult はシャドウのAIモデルの結果を本番の結果と比較して保存するハンドラー
+func SaveShadowResult(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.ShadowResultRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			handleError(c, http.StatusBadRequest, err)
+			return
+		}
+		if req.MessageID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message_id is required"})
+			return
+		}
+
+		primary := req.Primary.Data.Outputs
+		result := models.ShadowResult{
+			MessageID:              req.MessageID,
+			PrimaryProvider:        req.Primary.Provider,
+			PrimaryWorkflowVersion: req.Primary.WorkflowVersion,
+			PrimaryPriority:        models.StringValue(primary.Priority),
+			PrimaryJudgment:        models.StringValue(primary.Judgment),
+			PrimaryIncident:        models.StringValue(primary.Incident),
+			PrimaryTotalTokens:     req.Primary.Data.TotalTokens,
+			PrimaryElapsedTime:     req.Primary.Data.ElapsedTime,
+			Error:                  req.Error,
+			RawResponse:            "{}",
+		}
+		if req.Shadow != nil {
+			shadow := req.Shadow.Data.Outputs
+			result.Provider = req.Shadow.Provider
+			result.WorkflowVersion = req.Shadow.WorkflowVersion
+			result.ShadowPriority = models.StringValue(shadow.Priority)
+			result.ShadowJudgment = models.StringValue(shadow.Judgment)
+			result.ShadowIncident = models.StringValue(shadow.Incident)
+			result.ShadowTotalTokens = req.Shadow.Data.TotalTokens
+			result.ShadowElapsedTime = req.Shadow.Data.ElapsedTime
+			result.PriorityMatch = sameLabel(result.PrimaryPriority, result.ShadowPriority)
+			result.JudgmentMatch = sameLabel(result.PrimaryJudgment, result.ShadowJudgment)
+			if raw, err := json.Marshal(req.Shadow); err == nil {
+				result.RawResponse = string(raw)
+			}
+		}
+
+		if err := db.Create(&result).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err, zap.String("message_id", req.MessageID))
+			return
+		}
+
+		logger.Logger.Info("シャドウの結果を保存しました",
+			zap.String("message_id", req.MessageID),
+			zap.String("provider", result.Provider),
+			zap.Bool("priority_match", result.PriorityMatch),
+			zap.Bool("judgment_match", result.JudgmentMatch),
+			zap.Bool("shadow_error", result.Error != ""),
+		)
+
+		c.JSON(http.StatusCreated, result)
+	}
+}
+
+// ListShadowResults はシャドウの結果を新しい順に返すハンドラー。
+// message_id・provider で絞り込み、mismatch=true で本番と優先度または判定が異なるものだけを返します
+func ListShadowResults(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := shadowResultQuery(c, db)
+		if !ok {
+			return
+		}
+		if messageID := c.Query("message_id"); messageID != "" {
+			query = query.Where("message_id = ?", messageID)
+		}
+		if c.Query("mismatch") == "true" {
+			query = query.Where("error = '' AND (priority_match = ? OR judgment_match = ?)", false, false)
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit <= 0 || limit > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+
+		var results []models.ShadowResult
+		if err := query.Order("created_at DESC").Limit(limit).Find(&results).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"results": results,
+			"count":   len(results),
+		})
+	}
+}
+
+// GetShadowReport はシャドウと本番の結果の一致率・トークン・処理時間をプロバイダーとバージョンごとに集計するハンドラー。
+// from/to は RFC3339 または YYYY-MM-DD（JST）で、未指定の場合は全期間です
+func GetShadowReport(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := shadowResultQuery(c, db)
+		if !ok {
+			return
+		}
+
+		var summaries []ShadowReportSummary
+		err := query.Select(`provider, workflow_version,
+			COUNT(*) AS total,
+			SUM(CASE WHEN error <> '' THEN 1 ELSE 0 END) AS errors,
+			SUM(CASE WHEN priority_match THEN 1 ELSE 0 END) AS priority_matches,
+			SUM(CASE WHEN judgment_match THEN 1 ELSE 0 END) AS judgment_matches,
+			COALESCE(AVG(primary_total_tokens), 0) AS avg_primary_total_tokens,
+			COALESCE(AVG(CASE WHEN error = '' THEN shadow_total_tokens END), 0) AS avg_shadow_total_tokens,
+			COALESCE(AVG(primary_elapsed_time), 0) AS avg_primary_elapsed_time,
+			COALESCE(AVG(CASE WHEN error = '' THEN shadow_elapsed_time END), 0) AS avg_shadow_elapsed_time`).
+			Group("provider, workflow_version").
+			Order("provider, workflow_version").
+			Scan(&summaries).Error
+		if err != nil {
+			logger.Logger.Error("シャドウの比較レポートの集計に失敗しました", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate shadow results"})
+			return
+		}
+
+		// 一致率はシャドウの呼び出しに成功したものだけを母数にする
+		for i := range summaries {
+			if compared := summaries[i].Total - summaries[i].Errors; compared > 0 {
+				summaries[i].PriorityMatchRate = float64(summaries[i].PriorityMatches) / float64(compared)
+				summaries[i].JudgmentMatchRate = float64(summaries[i].JudgmentMatches) / float64(compared)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":    c.Query("from"),
+			"to":      c.Query("to"),
+			"reports": summaries,
+		})
+	}
+}
+
+// shadowResultQuery は from/to と provider の共通の絞り込みを適用します。不正な値の場合はエラーレスポンスを返します
+func shadowResultQuery(c *gin.Context, db *gorm.DB) (*gorm.DB, bool) {
+	query := db.Model(&models.ShadowResult{})
+	if provider := c.Query("provider"); provider != "" {
+		query = query.Where("provider = ?", provider)
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := parseAuditTime(from, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from"})
+			return nil, false
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseAuditTime(to, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to"})
+			return nil, false
+		}
+		query = query.Where("created_at < ?", t)
+	}
+	return query, true
+}
+
+// sameLabel はAIの出力ラベル（優先度・判定）が一致するかを、前後の空白と大文字小文字を無視して判定します
+func sameLabel(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
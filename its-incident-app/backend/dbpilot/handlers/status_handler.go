@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"dbpilot/logger"
@@ -12,7 +15,8 @@ import (
 	"gorm.io/gorm"
 )
 
-// UpdateProcessingStatus は処理状態を更新するハンドラー
+// UpdateProcessingStatus は処理状態を更新するハンドラー。
+// ?expected_status=held のように指定した場合は、現在の状態が一致する場合だけ更新します（一致しない場合は 409、存在しない場合は 404）
 func UpdateProcessingStatus(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		messageID := c.Param("messageID")
@@ -47,6 +51,12 @@ func UpdateProcessingStatus(db *gorm.DB) gin.HandlerFunc {
 		// 既存のステータスを確認
 		var existingStatus models.ProcessingStatus
 		result := db.Where("message_id = ?", messageID).First(&existingStatus)
+		expected := models.ProcessStatus(c.Query("expected_status"))
+
+		if expected != "" && result.Error == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Processing status not found"})
+			return
+		}
 
 		if result.Error == gorm.ErrRecordNotFound {
 			// 新規作成
@@ -72,8 +82,20 @@ func UpdateProcessingStatus(db *gorm.DB) gin.HandlerFunc {
 				"task_id": status.TaskID,
 				"error":   status.Error,
 			}
+			// コールバックURLとインシデントIDは指定された場合のみ更新する（途中の状態更新で消さない）
+			if status.CallbackURL != "" {
+				updates["callback_url"] = status.CallbackURL
+			}
+			if status.IncidentID != 0 {
+				updates["incident_id"] = status.IncidentID
+			}
+			// 途中結果は指定された場合のみ更新する（map の更新ではシリアライザーが使われないためJSONにして渡す）
+			if len(status.PartialOutputs) > 0 {
+				partial, _ := json.Marshal(status.PartialOutputs)
+				updates["partial_outputs"] = string(partial)
+			}
 
-			if status.Status == models.StatusComplete || status.Status == models.StatusFailed {
+			if status.Status == models.StatusComplete || status.Status == models.StatusFailed || status.Status == models.StatusRejected {
 				now := time.Now()
 				updates["completed_at"] = &now
 				logger.Logger.Info("処理が完了しました",
@@ -83,7 +105,13 @@ func UpdateProcessingStatus(db *gorm.DB) gin.HandlerFunc {
 				)
 			}
 
-			if err := db.Model(&existingStatus).Updates(updates).Error; err != nil {
+			query := db.Model(&existingStatus)
+			if expected != "" {
+				// 同時に承認・却下された場合などに1つだけ成功させる
+				query = query.Where("status = ?", expected)
+			}
+			updated := query.Updates(updates)
+			if err := updated.Error; err != nil {
 				logger.Logger.Error("ステータス更新に失敗",
 					zap.Error(err),
 					zap.String("message_id", messageID),
@@ -92,6 +120,14 @@ func UpdateProcessingStatus(db *gorm.DB) gin.HandlerFunc {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
+			if expected != "" && updated.RowsAffected == 0 {
+				logger.Logger.Info("ステータスが変わっていたため更新しませんでした",
+					zap.String("message_id", messageID),
+					zap.String("expected_status", string(expected)),
+				)
+				c.JSON(http.StatusConflict, gin.H{"error": "Processing status has changed"})
+				return
+			}
 
 			logger.Logger.Info("ステータスを更新しました",
 				zap.String("message_id", messageID),
@@ -100,6 +136,16 @@ func UpdateProcessingStatus(db *gorm.DB) gin.HandlerFunc {
 			)
 		}
 
+		// 再処理で完了した場合はデッドレターを解決済みにする
+		if status.Status == models.StatusComplete {
+			if err := models.ResolveDeadLetter(db, messageID); err != nil {
+				logger.Logger.Warn("デッドレターの解決に失敗しました",
+					zap.Error(err),
+					zap.String("message_id", messageID),
+				)
+			}
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Processing status updated successfully",
 			"status":  status,
@@ -149,3 +195,88 @@ func GetProcessingStatus(db *gorm.DB) gin.HandlerFunc {
 		c.JSON(http.StatusOK, status)
 	}
 }
+
+// ListProcessingStatuses は状態・期間で絞り込んだ処理状態の一覧を取得するハンドラー
+func ListProcessingStatuses(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		statusFilter := c.Query("status")
+
+		limit := 100
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+
+		query := db.Model(&models.ProcessingStatus{})
+		if statusFilter != "" {
+			// カンマ区切りで複数の状態を指定できる（例: failed,running）
+			query = query.Where("status IN ?", strings.Split(statusFilter, ","))
+		}
+
+		// 期間（作成日時）と、一定時間更新のないメッセージの絞り込み（RFC3339）
+		for param, condition := range map[string]string{
+			"from":           "created_at >= ?",
+			"to":             "created_at < ?",
+			"updated_before": "updated_at < ?",
+		} {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param + ": must be RFC3339"})
+				return
+			}
+			query = query.Where(condition, t)
+		}
+
+		// 送信者（メールアドレスの部分一致）での絞り込み
+		if sender := c.Query("sender"); sender != "" {
+			query = query.Where("message_id IN (?)", db.Model(&models.EmailData{}).
+				Select("message_id").
+				Where("email_from ILIKE ?", "%"+sender+"%"))
+		}
+
+		// 件数の上限に関係なく、条件に一致するメッセージの状態ごとの件数も返す
+		var counts []struct {
+			Status models.ProcessStatus
+			Count  int64
+		}
+		if err := query.Session(&gorm.Session{}).Select("status, COUNT(*) AS count").Group("status").Scan(&counts).Error; err != nil {
+			logger.Logger.Error("処理状態の件数の集計に失敗",
+				zap.Error(err),
+				zap.String("status", statusFilter),
+			)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		byStatus := make(map[models.ProcessStatus]int64, len(counts))
+		var total int64
+		for _, count := range counts {
+			byStatus[count.Status] = count.Count
+			total += count.Count
+		}
+
+		var statuses []models.ProcessingStatus
+		if err := query.Order("created_at ASC").Limit(limit).Find(&statuses).Error; err != nil {
+			logger.Logger.Error("処理状態一覧の取得に失敗",
+				zap.Error(err),
+				zap.String("status", statusFilter),
+			)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		logger.Logger.Info("処理状態一覧を取得しました",
+			zap.String("status", statusFilter),
+			zap.Int("count", len(statuses)),
+		)
+
+		c.JSON(http.StatusOK, gin.H{
+			"count":     len(statuses),
+			"total":     total,
+			"by_status": byStatus,
+			"data":      statuses,
+		})
+	}
+}
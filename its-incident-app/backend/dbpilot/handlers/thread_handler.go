@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// replyPrefix は件名の先頭の返信・転送の接頭辞（"Re:"、"RE[2]:"、"Fwd:"、"返信:" など）
+var replyPrefix = regexp.MustCompile(`^(?i:re|fw|fwd|aw|sv|返信|転送)(\[\d+\])?\s*[:：]\s*`)
+
+// messageIDToken は References などのヘッダーに含まれる <...> 形式の Message-ID
+var messageIDToken = regexp.MustCompile(`<[^<>\s]+>`)
+
+// normalizeSubject は件名から返信・転送の接頭辞を繰り返し取り除きます
+func normalizeSubject(subject string) string {
+	s := strings.TrimSpace(subject)
+	for {
+		trimmed := replyPrefix.ReplaceAllString(s, "")
+		if trimmed == s {
+			return s
+		}
+		s = strings.TrimSpace(trimmed)
+	}
+}
+
+// threadCandidates は In-Reply-To と References（新しい順）から返信元の Message-ID の候補を返します。
+// 保存時の山括弧の有無が揃っていないため、括弧付きと括弧なしの両方を含めます
+func threadCandidates(inReplyTo, references string) []string {
+	var ids []string
+	ids = append(ids, messageIDToken.FindAllString(inReplyTo, -1)...)
+	refs := messageIDToken.FindAllString(references, -1)
+	for i := len(refs) - 1; i >= 0; i-- {
+		ids = append(ids, refs[i])
+	}
+
+	seen := map[string]bool{}
+	var candidates []string
+	for _, id := range ids {
+		bare := strings.Trim(id, "<>")
+		if seen[bare] {
+			continue
+		}
+		seen[bare] = true
+		candidates = append(candidates, id, bare)
+	}
+	return candidates
+}
+
+// ThreadEmail は保存済みのメールが既存のインシデントへの返信かを判定し、返信であればインシデントの対応履歴として追加するハンドラー。
+// In-Reply-To・References で返信元のメールを探し、見つからない場合は subject_window_seconds 以内の同じ件名のメールで判定します（0 の場合は件名で判定しない）
+func ThreadEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID := c.Param("message_id")
+		logFields := []zap.Field{
+			zap.String("handler", "ThreadEmail"),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("message_id", messageID),
+		}
+
+		var req struct {
+			SubjectWindowSeconds int `json:"subject_window_seconds"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logger.Logger.Warn("リクエストのバインドに失敗しました", append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		var email models.EmailData
+		if err := db.Where("message_id = ?", messageID).First(&email).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				logger.Logger.Warn("メールデータが見つかりません", logFields...)
+				c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+				return
+			}
+			logger.Logger.Error("メールデータの取得に失敗しました", append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch email data"})
+			return
+		}
+
+		incidentID, matchedBy, err := findThreadIncident(db, &email, time.Duration(req.SubjectWindowSeconds)*time.Second)
+		if err != nil {
+			logger.Logger.Error("返信元のインシデントの検索に失敗しました", append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find thread"})
+			return
+		}
+		if incidentID == 0 {
+			logger.Logger.Info("返信元のインシデントは見つかりませんでした", logFields...)
+			c.JSON(http.StatusOK, gin.H{"incident_id": 0})
+			return
+		}
+		logFields = append(logFields, zap.Uint("incident_id", incidentID), zap.String("matched_by", matchedBy))
+
+		// 再送で同じメールが届いた場合は作成済みの対応履歴を返す
+		var response models.Response
+		err = db.Where("message_id = ?", messageID).First(&response).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			content := email.Body
+			if strings.TrimSpace(content) == "" {
+				content = email.Subject
+			}
+			response = models.Response{
+				IncidentID: incidentID,
+				Datetime:   time.Now(),
+				Responder:  truncateRunes(email.EmailFrom, 100),
+				Content:    content,
+				MessageID:  messageID,
+			}
+			err = db.Create(&response).Error
+		}
+		if err != nil {
+			logger.Logger.Error("対応履歴の作成に失敗しました", append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create response"})
+			return
+		}
+
+		logger.Logger.Info("返信メールを既存のインシデントに追加しました",
+			append(logFields, zap.Uint("response_id", response.ID))...)
+		c.JSON(http.StatusOK, gin.H{
+			"incident_id": response.IncidentID,
+			"matched_by":  matchedBy,
+			"response_id": response.ID,
+		})
+	}
+}
+
+// findThreadIncident は返信元のメールから作成された（または返信として追加された）インシデントを探します
+func findThreadIncident(db *gorm.DB, email *models.EmailData, subjectWindow time.Duration) (uint, string, error) {
+	if candidates := threadCandidates(email.InReplyTo, email.References); len(candidates) > 0 {
+		var parents []models.EmailData
+		if err := db.Select("message_id", "original_message_id").
+			Where("original_message_id IN ? AND message_id <> ?", candidates, email.MessageID).
+			Find(&parents).Error; err != nil {
+			return 0, "", err
+		}
+		// 候補の順（In-Reply-To、References の新しい順）に判定する
+		for i, candidate := range candidates {
+			for _, parent := range parents {
+				if parent.OriginalMessageID != candidate {
+					continue
+				}
+				incidentID, err := incidentForMessage(db, parent.MessageID)
+				if err != nil || incidentID != 0 {
+					matchedBy := "references"
+					if i < 2 && messageIDToken.MatchString(email.InReplyTo) {
+						matchedBy = "in_reply_to"
+					}
+					return incidentID, matchedBy, err
+				}
+			}
+		}
+	}
+
+	// 件名での判定は返信の接頭辞がある場合のみ（同じ件名の別の障害をまとめないように）
+	if subjectWindow <= 0 || email.ThreadSubject == "" || email.ThreadSubject == strings.TrimSpace(email.Subject) {
+		return 0, "", nil
+	}
+	var parents []models.EmailData
+	if err := db.Select("message_id").
+		Where("thread_subject = ? AND message_id <> ? AND created_at >= ?", email.ThreadSubject, email.MessageID, time.Now().Add(-subjectWindow)).
+		Order("created_at DESC").
+		Limit(20).
+		Find(&parents).Error; err != nil {
+		return 0, "", err
+	}
+	for _, parent := range parents {
+		incidentID, err := incidentForMessage(db, parent.MessageID)
+		if err != nil || incidentID != 0 {
+			return incidentID, "subject", err
+		}
+	}
+	return 0, "", nil
+}
+
+// incidentForMessage はメッセージIDから作成されたインシデント、または返信として追加された対応履歴のインシデントを返します
+func incidentForMessage(db *gorm.DB, messageID string) (uint, error) {
+	var incident models.Incident
+	err := db.Select("id").Where("message_id = ?", messageID).Order("id").First(&incident).Error
+	if err == nil {
+		return incident.ID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	var response models.Response
+	err = db.Select("incident_id").Where("message_id = ?", messageID).First(&response).Error
+	if err == nil {
+		return response.IncidentID, nil
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return 0, err
+}
+
+// truncateRunes は s を max 文字までに切り詰めます
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
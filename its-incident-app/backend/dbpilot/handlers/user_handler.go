@@ -24,6 +24,7 @@ type QueryUserResponse struct {
 	ID       uint   `json:"id"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Locked   bool   `json:"locked"`
 }
 
 // SaveUser はユーザー情報をDBに保存するハンドラー
@@ -39,6 +40,18 @@ func SaveUser(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		if !isEmailDomainAllowed(req.Email) {
+			logger.Logger.Warn("許可されていないドメインのメールアドレスです",
+				zap.String("email", req.Email),
+				zap.String("client_ip", c.ClientIP()),
+			)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Email domain is not allowed",
+				"code":  errCodeEmailDomainNotAllowed,
+			})
+			return
+		}
+
 		// メールアドレスの重複チェック
 		var existingUser models.User
 		if err := db.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
@@ -127,6 +140,7 @@ func QueryUser(db *gorm.DB) gin.HandlerFunc {
 			ID:       user.ID,
 			Email:    user.Email,
 			Password: user.Password,
+			Locked:   user.LockedAt != nil,
 		})
 	}
 }
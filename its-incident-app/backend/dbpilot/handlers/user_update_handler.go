@@ -29,28 +29,17 @@ func UpdateUser(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		// セッション情報から更新対象のユーザーを特定
-		sessionID, exists := c.Get("session")
-		if !exists {
-			logger.Logger.Warn("セッションが見つかりません",
-				zap.String("client_ip", c.ClientIP()),
-			)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
-			return
-		}
-
-		// セッションIDからユーザー情報を取得
-		var session models.LoginSession
-		if err := db.Where("session_id = ?", sessionID).First(&session).Error; err != nil {
+		userID, ok := sessionUserID(c, db)
+		if !ok {
 			logger.Logger.Error("セッション検証に失敗",
-				zap.Error(err),
-				zap.Any("session_id", sessionID),
+				zap.String("session_id", c.GetString("session")),
 			)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
 			return
 		}
 
 		logger.Logger.Info("ユーザー更新リクエストを受信",
-			zap.Uint("user_id", session.UserID),
+			zap.Uint("user_id", userID),
 			zap.Bool("password_update", req.Password != ""),
 			zap.Bool("name_update", req.Name != ""),
 		)
@@ -62,7 +51,7 @@ func UpdateUser(db *gorm.DB) gin.HandlerFunc {
 				tx.Rollback()
 				logger.Logger.Error("パニックが発生したためロールバック",
 					zap.Any("recover", r),
-					zap.Uint("user_id", session.UserID),
+					zap.Uint("user_id", userID),
 				)
 			}
 		}()
@@ -70,50 +59,50 @@ func UpdateUser(db *gorm.DB) gin.HandlerFunc {
 		// パスワードの更新（存在する場合）
 		if req.Password != "" {
 			if err := tx.Model(&models.User{}).
-				Where("id = ?", session.UserID).
+				Where("id = ?", userID).
 				Update("password", req.Password).Error; err != nil {
 				tx.Rollback()
 				logger.Logger.Error("パスワード更新に失敗",
 					zap.Error(err),
-					zap.Uint("user_id", session.UserID),
+					zap.Uint("user_id", userID),
 				)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 				return
 			}
 			logger.Logger.Info("パスワードを更新しました",
-				zap.Uint("user_id", session.UserID),
+				zap.Uint("user_id", userID),
 			)
 		}
 
 		// 名前の更新（存在する場合）
 		if req.Name != "" {
 			var profile models.Profile
-			err := tx.Where("user_id = ?", session.UserID).First(&profile).Error
+			err := tx.Where("user_id = ?", userID).First(&profile).Error
 
 			if err == gorm.ErrRecordNotFound {
 				// プロフィールが存在しない場合は新規作成
 				profile = models.Profile{
-					UserID: session.UserID,
+					UserID: userID,
 					Name:   req.Name,
 				}
 				if err := tx.Create(&profile).Error; err != nil {
 					tx.Rollback()
 					logger.Logger.Error("プロフィール作成に失敗",
 						zap.Error(err),
-						zap.Uint("user_id", session.UserID),
+						zap.Uint("user_id", userID),
 					)
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create profile"})
 					return
 				}
 				logger.Logger.Info("新規プロフィールを作成しました",
-					zap.Uint("user_id", session.UserID),
+					zap.Uint("user_id", userID),
 					zap.String("name", req.Name),
 				)
 			} else if err != nil {
 				tx.Rollback()
 				logger.Logger.Error("プロフィール取得でエラーが発生",
 					zap.Error(err),
-					zap.Uint("user_id", session.UserID),
+					zap.Uint("user_id", userID),
 				)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 				return
@@ -123,13 +112,13 @@ func UpdateUser(db *gorm.DB) gin.HandlerFunc {
 					tx.Rollback()
 					logger.Logger.Error("プロフィール更新に失敗",
 						zap.Error(err),
-						zap.Uint("user_id", session.UserID),
+						zap.Uint("user_id", userID),
 					)
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update name"})
 					return
 				}
 				logger.Logger.Info("プロフィールを更新しました",
-					zap.Uint("user_id", session.UserID),
+					zap.Uint("user_id", userID),
 					zap.String("name", req.Name),
 				)
 			}
@@ -139,14 +128,14 @@ func UpdateUser(db *gorm.DB) gin.HandlerFunc {
 		if err := tx.Commit().Error; err != nil {
 			logger.Logger.Error("トランザクションのコミットに失敗",
 				zap.Error(err),
-				zap.Uint("user_id", session.UserID),
+				zap.Uint("user_id", userID),
 			)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
 			return
 		}
 
 		logger.Logger.Info("ユーザー情報の更新が完了しました",
-			zap.Uint("user_id", session.UserID),
+			zap.Uint("user_id", userID),
 			zap.Bool("password_updated", req.Password != ""),
 			zap.Bool("name_updated", req.Name != ""),
 		)
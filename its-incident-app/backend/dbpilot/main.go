@@ -13,6 +13,8 @@ import (
 	"dbpilot/logger"
 	"dbpilot/middleware"
 	"dbpilot/models"
+	"dbpilot/mtls"
+	"shared/idtoken"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -30,6 +32,19 @@ func main() {
 		)
 	}
 
+	// 内部サービス呼び出しにクライアント証明書を付与（相互TLSが有効な場合のみ）
+	if err := mtls.InstallTransport(); err != nil {
+		logger.Logger.Fatal("mTLSクライアントの設定に失敗しました", zap.Error(err))
+	}
+
+	// IDトークンを受け付ける場合は呼び出し元のサービスアカウントを限定する（未設定の場合はすべてのIDトークンを拒否）
+	if err := idtoken.CheckConfig(); err != nil {
+		if cfg.Environment == "production" {
+			logger.Logger.Fatal("サービス認証の設定が不正です", zap.Error(err))
+		}
+		logger.Logger.Warn("サービス認証の設定が不正なため、IDトークンをすべて拒否します", zap.Error(err))
+	}
+
 	// ログレベルの設定
 	if err := logger.LogLevel.UnmarshalText([]byte(cfg.LogLevel.String())); err != nil {
 		logger.Logger.Fatal("ログレベルの設定に失敗しました",
@@ -96,16 +111,28 @@ func setupRouter(db *gorm.DB, cfg *config.ServerConfig) *gin.Engine {
 	// 公開エンドポイント
 	public := r.Group("/api/v1")
 	{
+		public.GET("/health", handlers.HealthCheck(db))
 		public.POST("/users", handlers.SaveUser(db))
 		public.POST("/login", handlers.QueryUser(db))
+		public.POST("/users/provision", handlers.ProvisionUser(db))
 		public.POST("/incidents", handlers.CreateIncident(db))
 		public.POST("/emails", handlers.AddEmailHandler(db))
+		public.POST("/emails/:message_id/thread", handlers.ThreadEmail(db))
+		public.GET("/status", handlers.ListProcessingStatuses(db))
 		public.GET("/status/:messageID", handlers.GetProcessingStatus(db))
 		public.PUT("/status/:messageID", handlers.UpdateProcessingStatus(db))
 		public.POST("/login-tokens", handlers.CreateLoginToken(db))
 		public.GET("/login-tokens/verify", handlers.VerifyLoginToken(db))
 		public.POST("/accounts", handlers.CreateAccount(db))
 		public.POST("/sessions", handlers.CreateSession(db))
+		public.POST("/refresh-tokens", handlers.CreateRefreshToken(db))
+		public.POST("/refresh-tokens/rotate", handlers.RotateRefreshToken(db))
+		public.GET("/login-attempts", handlers.GetLoginAttempt(db))
+		public.POST("/login-attempts/failure", handlers.RecordLoginFailure(db))
+		public.POST("/login-attempts/reset", handlers.ResetLoginAttempts(db))
+		public.POST("/device-tokens", handlers.CreateDeviceToken(db))
+		public.POST("/device-tokens/exchange", handlers.ExchangeDeviceToken(db))
+		public.POST("/device-tokens/revoke", handlers.RevokeDeviceTokenByHash(db))
 	}
 
 	// 保護されたエンドポイント
@@ -115,25 +142,82 @@ func setupRouter(db *gorm.DB, cfg *config.ServerConfig) *gin.Engine {
 		// プロフィール関連
 		protected.POST("/profiles", handlers.RegisterProfile(db))
 		protected.GET("/profiles", handlers.GetProfile(db))
+		protected.PUT("/profiles/locale", handlers.UpdateProfileLocale(db))
 
 		// インシデント関連
 		protected.GET("/incidents/:id", handlers.GetIncident(db))
+		protected.GET("/incidents/:id/graph", handlers.GetIncidentGraph(db))
 		protected.POST("/incidents-all", handlers.GetIncidentAll(db))
 		protected.POST("/incident-relations", handlers.CreateIncidentRelation(db))
 
+		// メール関連
+		protected.GET("/emails/:message_id", handlers.GetEmail(db))
+		protected.GET("/emails/:message_id/raw", handlers.GetRawEmail(db))
+
+		// AI処理のデッドレター
+		protected.GET("/dead-letters", handlers.ListDeadLetters(db))
+		protected.GET("/dead-letters/:messageID", handlers.GetDeadLetter(db))
+		protected.PUT("/dead-letters/:messageID", handlers.SaveDeadLetter(db))
+		protected.POST("/dead-letters/:messageID/reprocessed", handlers.MarkDeadLetterReprocessed(db))
+
 		// レスポンス関連
 		protected.POST("/responses", handlers.CreateResponse(db))
 
+		// エスカレーション関連
+		protected.GET("/escalations", handlers.ListEscalations(db))
+		protected.GET("/escalations/:incidentID", handlers.GetEscalation(db))
+		protected.PUT("/escalations/:incidentID", handlers.StartEscalation(db))
+		protected.POST("/escalations/:incidentID/advance", handlers.AdvanceEscalation(db))
+		protected.POST("/escalations/:incidentID/acknowledge", handlers.AcknowledgeEscalation(db))
+
+		// 通知の送信状況
+		protected.GET("/notification-deliveries", handlers.ListNotificationDeliveries(db))
+		protected.POST("/notification-deliveries", handlers.CreateNotificationDelivery(db))
+		protected.PATCH("/notification-deliveries/:id", handlers.UpdateNotificationDelivery(db))
+		protected.GET("/email-suppressions", handlers.ListEmailSuppressions(db))
+		protected.PUT("/email-suppressions", handlers.SaveEmailSuppression(db))
+		protected.DELETE("/email-suppressions/:id", handlers.DeleteEmailSuppression(db))
+
+		// メンテナンスの期間
+		protected.GET("/maintenance-windows", handlers.ListMaintenanceWindows(db))
+		protected.PUT("/maintenance-windows/:id", handlers.UpdateMaintenanceWindow(db))
+
 		// ユーザー関連
 		protected.POST("/users-update", handlers.UpdateUser(db))
 		protected.POST("/logout", handlers.LogoutHandler(db))
+		protected.DELETE("/login-tokens/:token", handlers.RevokeLoginToken(db))
+		protected.GET("/directory/users", handlers.ListDirectoryUsers(db))
+		protected.POST("/directory/users", handlers.CreateDirectoryUser(db))
+		protected.GET("/directory/users/:id", handlers.GetDirectoryUser(db))
+		protected.PATCH("/directory/users/:id", handlers.UpdateDirectoryUser(db))
+		protected.POST("/users/:id/lock", handlers.LockUser(db))
+		protected.POST("/users/:id/unlock", handlers.UnlockUser(db))
+
+		// セキュリティ関連
+		protected.POST("/auth-failures", handlers.RecordAuthFailure(db))
+		protected.POST("/audit-logs", handlers.CreateAuditLog(db))
+		protected.GET("/audit-logs", handlers.ListAuditLogs(db))
 
 		// セッション関連
 		protected.GET("/sessions", handlers.GetSession(db))
 		protected.DELETE("/sessions", handlers.DeleteSession(db))
+		protected.GET("/sessions/current", handlers.GetCurrentSession(db))
+		protected.DELETE("/sessions/current", handlers.DeleteCurrentSession(db))
+		protected.POST("/sessions/current/elevate", handlers.ElevateCurrentSession(db))
+		protected.DELETE("/sessions/all", handlers.DeleteAllSessions(db))
+		protected.GET("/device-tokens", handlers.ListDeviceTokens(db))
+		protected.DELETE("/device-tokens/:id", handlers.DeleteDeviceToken(db))
 
 		// Workflows用のエンドポイント
 		protected.POST("/api-responses/search", handlers.GetAPIResponseData(db))
+		protected.GET("/api-responses/cache", handlers.FindCachedAPIResponse(db))
+		protected.PUT("/api-responses/:messageID/override", handlers.OverrideClassification(db))
+		protected.GET("/cost-usage", handlers.GetCostUsage(db))
+		protected.POST("/shadow-results", handlers.SaveShadowResult(db))
+		protected.GET("/shadow-results", handlers.ListShadowResults(db))
+		protected.GET("/shadow-results/report", handlers.GetShadowReport(db))
+		protected.POST("/message-events", handlers.SaveMessageEvents(db))
+		protected.GET("/message-events/:messageID", handlers.ListMessageEvents(db))
 	}
 
 	logger.Logger.Info("ルーターの設定が完了しました")
@@ -145,7 +229,11 @@ func performMigrations(db *gorm.DB) error {
 
 	err := db.AutoMigrate(
 		&models.User{},
+		&models.MaintenanceWindow{},
 		&models.Incident{},
+		&models.Escalation{},
+		&models.NotificationDelivery{},
+		&models.EmailSuppression{},
 		&models.Profile{},
 		&models.LoginToken{},
 		&models.LoginSession{},
@@ -155,19 +243,36 @@ func performMigrations(db *gorm.DB) error {
 		&models.ErrorLog{},
 		&models.EmailData{},
 		&models.ProcessingStatus{},
+		&models.DeadLetter{},
+		&models.CostUsage{},
+		&models.ShadowResult{},
+		&models.MessageEvent{},
+		&models.RefreshToken{},
+		&models.DeviceToken{},
+		&models.LoginAttempt{},
+		&models.AuthFailure{},
+		&models.AuthAuditLog{},
+		&models.BruteForceAlert{},
 	)
 
 	if err != nil {
 		return err
 	}
 
+	// 生データはCloud Storageに保存するため、以前の生データの列を削除する
+	if db.Migrator().HasColumn(&models.EmailData{}, "raw_message") {
+		if err := db.Migrator().DropColumn(&models.EmailData{}, "raw_message"); err != nil {
+			return err
+		}
+	}
+
 	logger.Logger.Info("データベースマイグレーションが完了しました")
 	return nil
 }
 
 func handleGracefulShutdown(srv *http.Server, timeout time.Duration) {
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := mtls.ListenAndServe(srv); err != nil && err != http.ErrServerClosed {
 			logger.Logger.Fatal("サーバーの起動に失敗しました", zap.Error(err))
 		}
 	}()
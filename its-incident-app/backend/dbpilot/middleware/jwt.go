@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	jwksCacheDuration      = 10 * time.Minute
+	jwksMinRefreshInterval = 30 * time.Second
+
+	// 認証サービスがアクセストークンに設定する iss・aud の既定値（JWT_ISSUER・JWT_AUDIENCE で変更した場合は同じ値を設定する）
+	defaultAccessTokenIssuer   = "incident-tools-auth"
+	defaultAccessTokenAudience = "incident-tools"
+)
+
+// AccessTokenClaims は認証サービスが発行するアクセストークンのクレーム
+type AccessTokenClaims struct {
+	Email     string `json:"email"`
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// jwksCache は認証サービスのJWKSをキャッシュします
+type jwksCache struct {
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	client    *http.Client
+}
+
+var accessTokenKeys = &jwksCache{client: &http.Client{Timeout: 5 * time.Second}}
+
+// looksLikeJWT はトークンがJWT形式（ヘッダー.ペイロード.署名）かを判定します
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// verifyAccessToken は AUTH_JWKS_URL の公開鍵でアクセストークンをローカル検証します
+func verifyAccessToken(token string) (*AccessTokenClaims, error) {
+	if os.Getenv("AUTH_JWKS_URL") == "" {
+		return nil, errors.New("AUTH_JWKS_URL is not configured")
+	}
+
+	// 同じ鍵で署名された他の用途のトークンを受け付けないよう、iss・aud は常に検証する
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+		jwt.WithIssuer(getEnvOrDefault("JWT_ISSUER", defaultAccessTokenIssuer)),
+		jwt.WithAudience(getEnvOrDefault("JWT_AUDIENCE", defaultAccessTokenAudience)),
+	}
+
+	claims := &AccessTokenClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, accessTokenKeys.keyFunc, options...); err != nil {
+		return nil, err
+	}
+	if claims.SessionID == "" {
+		return nil, errors.New("sid claim is missing")
+	}
+	if _, err := claims.UserID(); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// UserID は sub クレーム（認証サービスが設定する利用者ID）を返します
+func (c *AccessTokenClaims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sub claim %q", c.Subject)
+	}
+	return uint(id), nil
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func (j *jwksCache) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key := j.get(kid); key != nil {
+		return key, nil
+	}
+
+	// 鍵のローテーションに備え、未知のkidの場合はJWKSを再取得する
+	if err := j.refresh(); err != nil {
+		return nil, err
+	}
+	if key := j.get(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("signing key %q not found", kid)
+}
+
+func (j *jwksCache) get(kid string) *rsa.PublicKey {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if time.Since(j.fetchedAt) > jwksCacheDuration {
+		return nil
+	}
+	return j.keys[kid]
+}
+
+func (j *jwksCache) refresh() error {
+	// 不正なkidによる再取得の連発を防ぐ
+	j.mu.RLock()
+	recentlyFetched := time.Since(j.fetchedAt) < jwksMinRefreshInterval
+	j.mu.RUnlock()
+	if recentlyFetched {
+		return nil
+	}
+
+	resp, err := j.client.Get(os.Getenv("AUTH_JWKS_URL"))
+	if err != nil {
+		return fmt.Errorf("failed to fetch jwks: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks endpoint returned status %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return fmt.Errorf("failed to decode jwks: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	j.mu.Unlock()
+	return nil
+}
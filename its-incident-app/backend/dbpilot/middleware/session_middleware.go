@@ -11,8 +11,13 @@ import (
 	"strings"
 	"time"
 
+	"dbpilot/identity"
 	"dbpilot/logger"
 	"dbpilot/models"
+	"dbpilot/mtls"
+	"dbpilot/security"
+	"dbpilot/serviceauth"
+	"shared/idtoken"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -84,6 +89,17 @@ func GinLogger() gin.HandlerFunc {
 func VerifySession(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+
+		// 検証済みのクライアント証明書（相互TLS）によるサービス間認証。
+		// ユーザーのセッションを転送するリクエストは通常どおりセッションを検証する
+		if authHeader == "" {
+			if identity := mtls.ClientIdentity(c.Request); identity != "" {
+				c.Set("session", "mtls:"+identity)
+				c.Next()
+				return
+			}
+		}
+
 		if authHeader == "" {
 			logUnauthorizedRequest(c, "認証ヘッダーが見つかりませんでした")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "認証ヘッダーが必要です"})
@@ -101,17 +117,70 @@ func VerifySession(db *gorm.DB) gin.HandlerFunc {
 		sessionID := parts[1]
 
 		// サービストークンチェック
-		serviceToken := os.Getenv("SERVICE_TOKEN")
-		if serviceToken != "" && sessionID == serviceToken {
+		if serviceauth.IsServiceToken(sessionID) {
 			c.Set("session", sessionID) // セッションIDのみを保存
 			c.Next()
 			return
 		}
 
+		// Google署名のIDトークンによるサービス間認証
+		if idtoken.IsGoogleIDToken(sessionID) {
+			if _, err := idtoken.VerifyIDToken(sessionID); err != nil {
+				logUnauthorizedRequest(c, "IDトークンの検証に失敗しました: "+err.Error())
+				recordAuthFailure(db, c, "invalid_id_token")
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid service token"})
+				c.Abort()
+				return
+			}
+			c.Set("session", sessionID)
+			c.Next()
+			return
+		}
+
+		// 認証サービスが署名した利用者情報ヘッダーがあり、同じセッションのものであればセッションの問い合わせを省略する。
+		// ヘッダーの有効期間（IDENTITY_HEADER_TTL）の間はログアウト後も受け付けるため、短い期間で発行される
+		if value := c.GetHeader(identity.Header); value != "" && identity.Enabled() {
+			id, err := identity.Verify(value)
+			if err == nil && id.SessionID == sessionID {
+				c.Set("session", id.SessionID)
+				c.Set("user_id", id.UserID)
+				c.Set("user_email", id.Email)
+				c.Next()
+				return
+			}
+			if err == nil {
+				err = fmt.Errorf("session id mismatch")
+			}
+			logger.Logger.Warn("利用者情報ヘッダーを検証できないためセッションを確認します",
+				zap.Error(err),
+				zap.String("client_ip", c.ClientIP()),
+			)
+		}
+
+		// アクセストークン(JWT)の場合は認証サービスの公開鍵でローカル検証する
+		if looksLikeJWT(sessionID) {
+			claims, err := verifyAccessToken(sessionID)
+			if err != nil {
+				logUnauthorizedRequest(c, "アクセストークンの検証に失敗しました: "+err.Error())
+				recordAuthFailure(db, c, "invalid_access_token")
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid access token"})
+				c.Abort()
+				return
+			}
+
+			userID, _ := claims.UserID()
+			c.Set("session", claims.SessionID)
+			c.Set("user_id", userID)
+			c.Set("user_email", claims.Email)
+			c.Next()
+			return
+		}
+
 		var session models.LoginSession
 		if err := db.Where("session_id = ?", sessionID).First(&session).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
 				logUnauthorizedRequest(c, "セッションが見つかりません")
+				recordAuthFailure(db, c, "session_not_found")
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
 			} else {
 				logger.Logger.Error("セッション検証でエラーが発生しました",
@@ -131,8 +200,10 @@ func VerifySession(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// セッションIDのみをコンテキストに保存
+		// セッションIDと利用者をコンテキストに保存
 		c.Set("session", session.SessionID)
+		c.Set("user_id", session.UserID)
+		c.Set("user_email", session.Email)
 		c.Next()
 	}
 }
@@ -153,6 +224,16 @@ func logUnauthorizedRequest(c *gin.Context, message string) {
 	)
 }
 
+// recordAuthFailure はブルートフォース検知のため認証失敗を記録します
+func recordAuthFailure(db *gorm.DB, c *gin.Context, reason string) {
+	security.RecordAuthFailure(db, models.AuthFailure{
+		Source:   "dbpilot",
+		Reason:   reason,
+		ClientIP: c.ClientIP(),
+		Path:     c.Request.URL.Path,
+	})
+}
+
 // Helper functions
 
 func getTraceID(c *gin.Context) string {
@@ -192,8 +273,9 @@ func isProtectedHeader(header string) bool {
 func shouldLogBody(path string) bool {
 	// ヘルスチェックなど、ボディのログが不要なパスを除外
 	excludedPaths := map[string]bool{
-		"/health": true,
-		"/ping":   true,
+		"/health":        true,
+		"/api/v1/health": true,
+		"/ping":          true,
 	}
 	return !excludedPaths[path]
 }
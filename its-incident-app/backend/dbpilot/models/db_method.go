@@ -2,9 +2,11 @@ package models
 
 import (
 	"dbpilot/logger"
+	"time"
 
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // DeleteSessionByEmail はメールアドレスに基づいてセッションを削除
@@ -25,6 +27,24 @@ func DeleteSessionByEmail(db *gorm.DB, email string) error {
 	return nil
 }
 
+// DeleteSessionByID はセッションIDに基づいて単一のセッションを削除
+func DeleteSessionByID(db *gorm.DB, sessionID string) (int64, error) {
+	result := db.Where("session_id = ?", sessionID).Delete(&LoginSession{})
+	if result.Error != nil {
+		logger.Logger.Error("セッション削除に失敗しました",
+			zap.Error(result.Error),
+			zap.String("session_id", sessionID),
+		)
+		return 0, result.Error
+	}
+
+	logger.Logger.Info("セッションを削除しました",
+		zap.String("session_id", sessionID),
+		zap.Int64("deleted_count", result.RowsAffected),
+	)
+	return result.RowsAffected, nil
+}
+
 // CreateSession は新しいセッションを作成
 func CreateSession(db *gorm.DB, session *LoginSession) error {
 	if err := db.Create(session).Error; err != nil {
@@ -68,6 +88,21 @@ func GetSessionByEmail(db *gorm.DB, email string) (*LoginSession, error) {
 	return &session, nil
 }
 
+// GetSessionByID はセッションIDに基づいてセッションを取得
+func GetSessionByID(db *gorm.DB, sessionID string) (*LoginSession, error) {
+	var session LoginSession
+	if err := db.Where("session_id = ?", sessionID).First(&session).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			logger.Logger.Error("セッション取得に失敗しました",
+				zap.Error(err),
+				zap.String("session_id", sessionID),
+			)
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
 // GetUserByEmail はメールアドレスに基づいてユーザーを取得
 func GetUserByEmail(db *gorm.DB, email string) (*User, error) {
 	var user User
@@ -126,3 +161,148 @@ func UpdateUser(db *gorm.DB, user *User) error {
 	)
 	return nil
 }
+
+// DeleteSessionsByUserID はユーザーのすべてのセッションを削除し、削除したセッションIDを返します。
+// exceptSessionID を指定した場合はそのセッションを残します。あわせてリフレッシュトークンも失効させ、
+// 全セッションが対象の場合は端末トークン（ログイン状態の保持）も失効させます
+func DeleteSessionsByUserID(db *gorm.DB, userID uint, exceptSessionID string) ([]string, error) {
+	var sessionIDs []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&LoginSession{}).Where("user_id = ?", userID)
+		if exceptSessionID != "" {
+			query = query.Where("session_id <> ?", exceptSessionID)
+		} else if err := tx.Model(&DeviceToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := query.Pluck("session_id", &sessionIDs).Error; err != nil {
+			return err
+		}
+		if len(sessionIDs) == 0 {
+			return nil
+		}
+
+		if err := tx.Where("session_id IN ?", sessionIDs).Delete(&LoginSession{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&RefreshToken{}).
+			Where("session_id IN ? AND revoked_at IS NULL", sessionIDs).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		logger.Logger.Error("ユーザーのセッション一括削除に失敗しました",
+			zap.Error(err),
+			zap.Uint("user_id", userID),
+		)
+		return nil, err
+	}
+
+	logger.Logger.Info("ユーザーのセッションを一括削除しました",
+		zap.Uint("user_id", userID),
+		zap.Int("deleted_count", len(sessionIDs)),
+	)
+	return sessionIDs, nil
+}
+
+// IsUserLocked はユーザーがロックされているかを返します（存在しないユーザーはロックなしとみなす）
+func IsUserLocked(db *gorm.DB, userID uint) (bool, error) {
+	var count int64
+	if err := db.Model(&User{}).
+		Where("id = ? AND locked_at IS NOT NULL", userID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ElevateSession はセッションの昇格期限を更新します（セッションの有効期限を超えない範囲に制限）
+func ElevateSession(db *gorm.DB, sessionID string, until time.Time) (*LoginSession, error) {
+	session, err := GetSessionByID(db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if until.After(session.ExpiresAt) {
+		until = session.ExpiresAt
+	}
+	if err := db.Model(session).Update("elevated_until", until).Error; err != nil {
+		logger.Logger.Error("セッションの昇格に失敗しました",
+			zap.Error(err),
+			zap.String("session_id", sessionID),
+		)
+		return nil, err
+	}
+	session.ElevatedUntil = &until
+	return session, nil
+}
+
+// ResolveDeadLetter は未解決のデッドレターを解決済みにします（存在しない場合は何もしない）
+func ResolveDeadLetter(db *gorm.DB, messageID string) error {
+	result := db.Model(&DeadLetter{}).
+		Where("message_id = ? AND resolved_at IS NULL", messageID).
+		Update("resolved_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		logger.Logger.Info("デッドレターを解決済みにしました",
+			zap.String("message_id", messageID),
+		)
+	}
+	return nil
+}
+
+// LockUser はユーザーをロックし、すべてのセッションを失効させます。失効したセッションIDを返します
+func LockUser(db *gorm.DB, userID uint, reason string) (time.Time, []string, error) {
+	now := time.Now()
+	if err := db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"locked_at":     now,
+		"locked_reason": reason,
+	}).Error; err != nil {
+		logger.Logger.Error("ユーザーのロックに失敗しました",
+			zap.Error(err),
+			zap.Uint("user_id", userID),
+		)
+		return now, nil, err
+	}
+
+	sessionIDs, err := DeleteSessionsByUserID(db, userID, "")
+	return now, sessionIDs, err
+}
+
+// UnlockUser はユーザーのロックを解除します
+func UnlockUser(db *gorm.DB, userID uint) error {
+	if err := db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"locked_at":     nil,
+		"locked_reason": "",
+	}).Error; err != nil {
+		logger.Logger.Error("ユーザーのロック解除に失敗しました",
+			zap.Error(err),
+			zap.Uint("user_id", userID),
+		)
+		return err
+	}
+	return nil
+}
+
+// RecordCostUsage はAI呼び出し1回分の利用量を日別・送信者別の集計に加算します
+func RecordCostUsage(db *gorm.DB, at time.Time, sender string, tokens int, elapsed float64) error {
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	usage := CostUsage{
+		Date:        at.In(jst).Format("2006-01-02"),
+		Sender:      sender,
+		Requests:    1,
+		TotalTokens: int64(tokens),
+		ElapsedTime: elapsed,
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "date"}, {Name: "sender"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"requests":     gorm.Expr("cost_usages.requests + 1"),
+			"total_tokens": gorm.Expr("cost_usages.total_tokens + ?", tokens),
+			"elapsed_time": gorm.Expr("cost_usages.elapsed_time + ?", elapsed),
+			"updated_at":   time.Now(),
+		}),
+	}).Create(&usage).Error
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -32,9 +33,13 @@ func (b *BaseModel) BeforeUpdate(tx *gorm.DB) error {
 
 type User struct {
 	BaseModel
-	Email    string `gorm:"unique;type:varchar(255);not null"`
-	Password string
-	Profile  Profile `gorm:"foreignKey:UserID"`
+	Email           string `gorm:"unique;type:varchar(255);not null"`
+	Password        string
+	AuthProvider    string `gorm:"size:50"`
+	ExternalSubject string `gorm:"size:255;index"`
+	LockedAt        *time.Time
+	LockedReason    string  `gorm:"size:255"`
+	Profile         Profile `gorm:"foreignKey:UserID"`
 }
 
 type Profile struct {
@@ -42,14 +47,38 @@ type Profile struct {
 	UserID   uint `gorm:"unique"`
 	Name     string
 	ImageURL string
+	// Locale は通知の言語（ja/en）。空の場合は通知側の既定の言語を使用します
+	Locale string `gorm:"size:10"`
+}
+
+// SupportedLocales は通知の言語として設定できる値
+var SupportedLocales = []string{"ja", "en"}
+
+// NormalizeLocale は "en-US" や "EN" などの言語の指定を SupportedLocales の値に変換します。
+// 空の場合は空（既定の言語）を返し、対応していない言語の場合は false を返します
+func NormalizeLocale(locale string) (string, bool) {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if locale == "" {
+		return "", true
+	}
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		locale = locale[:i]
+	}
+	for _, supported := range SupportedLocales {
+		if locale == supported {
+			return locale, true
+		}
+	}
+	return "", false
 }
 
 type LoginSession struct {
 	BaseModel
-	UserID    uint
-	Email     string
-	SessionID string `gorm:"unique"`
-	ExpiresAt time.Time
+	UserID        uint
+	Email         string
+	SessionID     string `gorm:"unique"`
+	ExpiresAt     time.Time
+	ElevatedUntil *time.Time // 再認証（ステップアップ認証）の有効期限
 }
 
 type Incident struct {
@@ -62,6 +91,73 @@ type Incident struct {
 	Responses []Response         `gorm:"foreignKey:IncidentID"`
 	Relations []IncidentRelation `gorm:"foreignKey:IncidentID"`
 	APIData   APIResponseData    `gorm:"foreignKey:IncidentID"`
+	// Events はメッセージの処理のステップ（単一インシデントの取得時のみ）
+	Events []MessageEvent `gorm:"-" json:",omitempty"`
+	// MaintenanceWindowID は作成時に告知済みのメンテナンスの期間中だった場合、その期間
+	MaintenanceWindowID *uint              `gorm:"index"`
+	MaintenanceWindow   *MaintenanceWindow `gorm:"foreignKey:MaintenanceWindowID" json:",omitempty"`
+}
+
+// MaintenanceWindow はカレンダー招待（text/calendar）のメールで告知されたメンテナンスの期間です。
+// 期間中に作成されたインシデントには期間を記録し、Suppress が true の場合は自動で解決済みにします
+type MaintenanceWindow struct {
+	BaseModel
+	UID       string    `json:"uid" gorm:"size:255;not null;uniqueIndex"` // 予定のUID（ない場合は告知メールのメッセージID）
+	Summary   string    `json:"summary" gorm:"size:255"`
+	StartAt   time.Time `json:"start_at" gorm:"type:timestamp with time zone;not null;index"`
+	EndAt     time.Time `json:"end_at" gorm:"type:timestamp with time zone;not null;index"`
+	Organizer string    `json:"organizer" gorm:"size:255"`  // 告知メールの差出人
+	MessageID string    `json:"message_id" gorm:"size:255"` // 最後に受信した告知メールのメッセージID
+	Cancelled bool      `json:"cancelled" gorm:"not null;default:false"`
+	Suppress  bool      `json:"suppress" gorm:"not null;default:false"`
+}
+
+// Escalation はインシデントの未確認時のエスカレーションの状態です（notify のエスカレーションポリシーの進行状況）。
+// NextAt が nil の場合はすべての段階を実行済み、AcknowledgedAt が設定されている場合は確認済みで、それ以上エスカレーションしません
+type Escalation struct {
+	BaseModel
+	IncidentID     uint       `json:"incident_id" gorm:"not null;uniqueIndex"`
+	Policy         string     `json:"policy" gorm:"size:100;not null"`
+	Step           int        `json:"step" gorm:"not null;default:0"` // 実行済みの段階の数
+	NextAt         *time.Time `json:"next_at" gorm:"type:timestamp with time zone;index"`
+	Notification   string     `json:"notification" gorm:"type:text"` // 最初の通知（notify のリクエストのJSON）
+	AcknowledgedAt *time.Time `json:"acknowledged_at" gorm:"type:timestamp with time zone"`
+	AcknowledgedBy string     `json:"acknowledged_by" gorm:"size:100"`
+}
+
+// 通知の送信状況
+const (
+	DeliveryPending  = "pending"
+	DeliverySent     = "sent"
+	DeliveryRetrying = "retrying"
+	DeliveryFailed   = "failed"
+)
+
+// NotificationDelivery は notify が送信した通知（送信先の種類と宛先ごと）の送信状況です。
+// 一時的な失敗は NextAttemptAt に notify が再送します（再送中も retrying のまま NextAttemptAt を先に延ばすため、途中で停止しても再び再送します）
+type NotificationDelivery struct {
+	BaseModel
+	Channel       string     `json:"channel" gorm:"size:50;not null;index"`
+	Target        string     `json:"target" gorm:"size:255;not null"` // チャンネルの名前・電話番号・メールアドレス（WebhookのURLは保存しない）
+	IncidentID    uint       `json:"incident_id" gorm:"index"`
+	PayloadHash   string     `json:"payload_hash" gorm:"size:64;index"`
+	Payload       string     `json:"payload" gorm:"type:text"` // 再送に使用する通知（notify のリクエスト、メールはテンプレートの種類と項目のJSON）
+	Status        string     `json:"status" gorm:"size:20;not null;index"`
+	Attempts      int        `json:"attempts" gorm:"not null;default:0"`
+	LastError     string     `json:"last_error" gorm:"type:text"`
+	NextAttemptAt *time.Time `json:"next_attempt_at" gorm:"type:timestamp with time zone;index"`
+	DeliveredAt   *time.Time `json:"delivered_at" gorm:"type:timestamp with time zone"`
+}
+
+// EmailSuppression はメールを送信しない宛先です（SendGridのイベントで恒久的な不達・迷惑メールの報告があったもの）
+type EmailSuppression struct {
+	BaseModel
+	Email     string    `json:"email" gorm:"size:255;not null;uniqueIndex"` // 小文字で保存
+	Reason    string    `json:"reason" gorm:"size:50;not null"`             // bounce / spamreport
+	Detail    string    `json:"detail" gorm:"type:text"`
+	EventAt   time.Time `json:"event_at" gorm:"type:timestamp with time zone"`
+	Count     int       `json:"count" gorm:"not null;default:1"` // 同じ宛先のイベントの回数
+	MessageID string    `json:"message_id" gorm:"size:255"`      // 最後のイベントのSendGridのメッセージID
 }
 
 type IncidentRelation struct {
@@ -77,6 +173,7 @@ type Response struct {
 	Datetime   time.Time `gorm:"not null"`
 	Responder  string    `gorm:"size:100;not null"`
 	Content    string    `gorm:"type:text;not null"`
+	MessageID  string    `gorm:"size:255;index"` // 返信メールから作成した場合のメッセージID
 }
 
 type APIResponseData struct {
@@ -108,30 +205,91 @@ type APIResponseData struct {
 	FinishedAt  int64
 	Error       string `gorm:"type:text"`
 	RawResponse string `gorm:"type:jsonb"`
+	// Provider は処理したAIプロバイダー名（フェイルオーバー時の追跡用）
+	Provider string `gorm:"size:100;index"`
+	// WorkflowVersion は使用したプロンプト/ワークフローのバージョン（分類品質の比較用）
+	WorkflowVersion string `gorm:"size:100;index"`
+	// Language は検出したメールの言語（ja/en、英語のベンダーアラートの絞り込み用）
+	Language string `gorm:"size:10;index"`
+	// ContentHash は正規化した件名・本文のハッシュ。CacheHit の場合は同じ内容の過去の結果を再利用しています
+	ContentHash         string `gorm:"size:64;index"`
+	CacheHit            bool   `gorm:"default:false"`
+	CachedFromMessageID string `gorm:"size:255"`
+
+	// AI出力の欠損情報
+	IsPartial     bool   `gorm:"default:false"`
+	MissingFields string `gorm:"type:jsonb"`
+
+	// 担当者による分類の訂正。AIの出力（Judgment/Priority/IncidentText）は残したまま、訂正後の値を別に保持します
+	HumanCorrected       bool   `gorm:"default:false;index"`
+	OverrideJudgment     string `gorm:"size:100"`
+	OverridePriority     string `gorm:"size:50"`
+	OverrideIncidentText string `gorm:"type:text"`
+	OverrideBy           string `gorm:"size:255"`
+	OverrideReason       string `gorm:"type:text"`
+	OverriddenAt         *time.Time
 }
 
+// OutputsData はAIワークフローの出力です。欠損やnullを許容するためポインタで受け取ります
 type OutputsData struct {
-	Body         string          `json:"body"`
-	User         string          `json:"user"`
+	Body         *string         `json:"body"`
+	User         *string         `json:"user"`
 	WorkflowLogs json.RawMessage `json:"workflowLogs"`
-	Host         string          `json:"host"`
-	Priority     string          `json:"priority"`
-	Subject      string          `json:"subject"`
-	From         string          `json:"from"`
-	Place        string          `json:"place"`
-	Incident     string          `json:"incident"`
-	Time         string          `json:"time"`
-	IncidentID   int             `json:"incidentID"`
-	Judgment     string          `json:"judgment"`
-	Sender       string          `json:"sender"`
-	Final        string          `json:"final"`
+	Host         *string         `json:"host"`
+	Priority     *string         `json:"priority"`
+	Subject      *string         `json:"subject"`
+	From         *string         `json:"from"`
+	Place        *string         `json:"place"`
+	Incident     *string         `json:"incident"`
+	Time         *string         `json:"time"`
+	IncidentID   *int            `json:"incidentID"`
+	Judgment     *string         `json:"judgment"`
+	Sender       *string         `json:"sender"`
+	Final        *string         `json:"final"`
+}
+
+// MissingFields はインシデント表示に必要な出力項目のうち、欠損または空のものをJSONキー名で返します
+func (o OutputsData) MissingFields() []string {
+	required := []struct {
+		name  string
+		value *string
+	}{
+		{"subject", o.Subject},
+		{"body", o.Body},
+		{"priority", o.Priority},
+		{"judgment", o.Judgment},
+		{"incident", o.Incident},
+		{"sender", o.Sender},
+	}
+
+	missing := []string{}
+	for _, field := range required {
+		if field.value == nil || strings.TrimSpace(*field.value) == "" {
+			missing = append(missing, field.name)
+		}
+	}
+	return missing
+}
+
+// StringValue はnil許容の文字列を値に変換します
+func StringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
 }
 
 type APIRequest struct {
-	TaskID        string `json:"task_id"`
-	WorkflowRunID string `json:"workflow_run_id"`
-	MessageID     string `json:"message_id"`
-	Data          struct {
+	TaskID              string `json:"task_id"`
+	WorkflowRunID       string `json:"workflow_run_id"`
+	MessageID           string `json:"message_id"`
+	Provider            string `json:"provider,omitempty"`
+	WorkflowVersion     string `json:"workflow_version,omitempty"`
+	Language            string `json:"language,omitempty"`
+	ContentHash         string `json:"content_hash,omitempty"`
+	CacheHit            bool   `json:"cache_hit,omitempty"`
+	CachedFromMessageID string `json:"cached_from_message_id,omitempty"`
+	Data                struct {
 		ID          string      `json:"id"`
 		WorkflowID  string      `json:"workflow_id"`
 		Status      string      `json:"status"`
@@ -168,7 +326,32 @@ type EmailData struct {
 	ContentTransferEncoding string `json:"content_transfer_encoding" gorm:"type:varchar(50)"`        // コンテンツ転送エンコーディング
 	CC                      string `json:"cc" gorm:"type:varchar(255)"`                              // CC
 	Body                    string `json:"body" gorm:"type:text"`                                    // メール本文
+	HTMLBody                string `json:"html_body,omitempty" gorm:"type:text"`                     // HTML本文（インライン画像は data URI に書き換え済み）
+	InReplyTo               string `json:"in_reply_to" gorm:"type:text"`                             // 返信元の Message-ID
+	References              string `json:"references" gorm:"type:text"`                              // スレッドの Message-ID の一覧
+	ThreadSubject           string `json:"-" gorm:"type:varchar(255);index"`                         // 返信の接頭辞を除いた件名
 	FileName                string `json:"file_name,omitempty" gorm:"type:varchar(255)"`             // ファイル名（添付ファイル）
+	RawGCSURI               string `json:"raw_gcs_uri,omitempty" gorm:"type:varchar(512)"`           // 生データ全体を保存したCloud StorageのURI
+	// カレンダー招待の最初の予定（メンテナンスの告知）
+	CalendarMethod  string     `json:"calendar_method,omitempty" gorm:"type:varchar(50)"`
+	CalendarUID     string     `json:"calendar_uid,omitempty" gorm:"type:varchar(255)"`
+	CalendarSummary string     `json:"calendar_summary,omitempty" gorm:"type:varchar(255)"`
+	CalendarStart   *time.Time `json:"calendar_start,omitempty" gorm:"type:timestamp with time zone"`
+	CalendarEnd     *time.Time `json:"calendar_end,omitempty" gorm:"type:timestamp with time zone"`
+	// Attachments は添付ファイルの情報と、テキスト形式の添付ファイルの内容
+	Attachments []EmailAttachment `json:"attachments,omitempty" gorm:"type:jsonb;serializer:json"`
+}
+
+// EmailAttachment は添付ファイルの情報です。テキスト形式の添付ファイルは内容（上限まで）も含みます
+type EmailAttachment struct {
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Content     string `json:"content,omitempty"`
+	Truncated   bool   `json:"truncated,omitempty"`
+	GCSURI      string `json:"gcs_uri,omitempty"` // 内容を保存したCloud StorageのURI
+	ContentID   string `json:"content_id,omitempty"`
+	Inline      bool   `json:"inline,omitempty"` // HTML本文に表示されるインライン画像
 }
 
 type EmailPayload struct {
@@ -179,11 +362,15 @@ type EmailPayload struct {
 // APIResponseDataQuery は検索条件を定義する構造体
 type APIResponseDataQuery struct {
 	// ID関連
-	IncidentID    *uint   `json:"incident_id,omitempty"`
-	TaskID        *string `json:"task_id,omitempty"`
-	WorkflowRunID *string `json:"workflow_run_id,omitempty"`
-	WorkflowID    *string `json:"workflow_id,omitempty"`
-	Status        *string `json:"status,omitempty"`
+	IncidentID      *uint   `json:"incident_id,omitempty"`
+	TaskID          *string `json:"task_id,omitempty"`
+	WorkflowRunID   *string `json:"workflow_run_id,omitempty"`
+	WorkflowID      *string `json:"workflow_id,omitempty"`
+	Status          *string `json:"status,omitempty"`
+	Provider        *string `json:"provider,omitempty"`
+	WorkflowVersion *string `json:"workflow_version,omitempty"`
+	Language        *string `json:"language,omitempty"`
+	HumanCorrected  *bool   `json:"human_corrected,omitempty"`
 
 	// テキストフィールド
 	Body         *string `json:"body,omitempty"`
@@ -231,6 +418,8 @@ const (
 	StatusRunning  ProcessStatus = "running"
 	StatusComplete ProcessStatus = "complete"
 	StatusFailed   ProcessStatus = "failed"
+	StatusHeld     ProcessStatus = "held"
+	StatusRejected ProcessStatus = "rejected"
 )
 
 type ProcessingStatus struct {
@@ -240,14 +429,159 @@ type ProcessingStatus struct {
 	TaskID      string        `json:"task_id,omitempty"`
 	CompletedAt *time.Time    `json:"completed_at,omitempty"`
 	Error       string        `json:"error,omitempty"`
+	IncidentID  uint          `json:"incident_id,omitempty"`
+	// CallbackURL は処理の完了時にautopilotが結果を通知する呼び出し元のURL
+	CallbackURL string `gorm:"type:text" json:"callback_url,omitempty"`
+	// PartialOutputs はストリーミング中に受け取ったAIの途中結果（件名・判定など）。完了前の確認に使います
+	PartialOutputs map[string]string `gorm:"type:jsonb;serializer:json" json:"partial_outputs,omitempty"`
+}
+
+// CostUsage は日別（JST）・送信者別のAI利用量の集計
+type CostUsage struct {
+	BaseModel
+	Date        string  `gorm:"type:varchar(10);not null;uniqueIndex:idx_cost_usage_date_sender" json:"date"` // YYYY-MM-DD
+	Sender      string  `gorm:"type:varchar(255);not null;uniqueIndex:idx_cost_usage_date_sender" json:"sender"`
+	Requests    int     `gorm:"default:0" json:"requests"`
+	TotalTokens int64   `gorm:"default:0" json:"total_tokens"`
+	ElapsedTime float64 `gorm:"default:0" json:"elapsed_time"`
+}
+
+// ShadowResult はシャドウ（評価中）のAIモデルの結果です。本番の分類には使わず、本番の結果との比較にのみ使います
+type ShadowResult struct {
+	BaseModel
+	MessageID              string  `gorm:"type:varchar(255);index;not null" json:"message_id"`
+	Provider               string  `gorm:"size:100;index" json:"provider"`
+	WorkflowVersion        string  `gorm:"size:100" json:"workflow_version"`
+	PrimaryProvider        string  `gorm:"size:100" json:"primary_provider"`
+	PrimaryWorkflowVersion string  `gorm:"size:100" json:"primary_workflow_version"`
+	PrimaryPriority        string  `gorm:"size:50" json:"primary_priority"`
+	ShadowPriority         string  `gorm:"size:50" json:"shadow_priority"`
+	PrimaryJudgment        string  `gorm:"size:100" json:"primary_judgment"`
+	ShadowJudgment         string  `gorm:"size:100" json:"shadow_judgment"`
+	PrimaryIncident        string  `gorm:"type:text" json:"primary_incident"`
+	ShadowIncident         string  `gorm:"type:text" json:"shadow_incident"`
+	PriorityMatch          bool    `gorm:"default:false" json:"priority_match"`
+	JudgmentMatch          bool    `gorm:"default:false" json:"judgment_match"`
+	PrimaryTotalTokens     int     `json:"primary_total_tokens"`
+	ShadowTotalTokens      int     `json:"shadow_total_tokens"`
+	PrimaryElapsedTime     float64 `json:"primary_elapsed_time"`
+	ShadowElapsedTime      float64 `json:"shadow_elapsed_time"`
+	Error                  string  `gorm:"type:text" json:"error,omitempty"` // シャドウの呼び出しに失敗した場合のエラー
+	RawResponse            string  `gorm:"type:jsonb" json:"-"`
+}
+
+// ShadowResultRequest はautopilotから受け取るシャドウの結果と、比較対象の本番の結果です
+type ShadowResultRequest struct {
+	MessageID string      `json:"message_id"`
+	Primary   APIRequest  `json:"primary"`
+	Shadow    *APIRequest `json:"shadow,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// MessageEvent はautopilotでのメッセージの処理の1ステップ（受信・メール保存・AI処理の開始/再試行/成功・インシデント保存など）です。
+// メッセージIDごとに発生順に並べ、インシデントのタイムラインに表示します
+type MessageEvent struct {
+	ID         uint              `gorm:"primarykey" json:"id"`
+	MessageID  string            `gorm:"type:varchar(255);index:idx_message_events_message_occurred,priority:1;not null" json:"message_id"`
+	Step       string            `gorm:"size:50;not null" json:"step"`
+	OccurredAt time.Time         `gorm:"index:idx_message_events_message_occurred,priority:2;not null" json:"occurred_at"`
+	Attributes map[string]string `gorm:"type:jsonb;serializer:json" json:"attributes,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+// MessageEventsRequest はautopilotからまとめて送られるステップのイベントです
+type MessageEventsRequest struct {
+	Events []MessageEvent `json:"events"`
+}
+
+// DeadLetter はAI処理の再試行がすべて失敗したメッセージ。
+// 再処理に必要なメールデータ（JSON）と最後のエラーを保持し、処理が完了すると解決済みになります
+type DeadLetter struct {
+	gorm.Model
+	MessageID         string     `gorm:"type:varchar(255);uniqueIndex;not null" json:"message_id"`
+	Payload           string     `gorm:"type:text;not null" json:"payload"`
+	Error             string     `gorm:"type:text" json:"error"`
+	Attempts          int        `gorm:"default:1" json:"attempts"`
+	LastFailedAt      time.Time  `json:"last_failed_at"`
+	ReprocessCount    int        `gorm:"default:0" json:"reprocess_count"`
+	LastReprocessedAt *time.Time `json:"last_reprocessed_at,omitempty"`
+	ResolvedAt        *time.Time `gorm:"index" json:"resolved_at,omitempty"`
 }
 
 type LoginToken struct {
 	gorm.Model
-	Email     string    `gorm:"type:varchar(255);index"` // 外部キー制約用
-	Token     string    `gorm:"uniqueIndex;type:varchar(255);not null"`
-	ExpiresAt time.Time `gorm:"not null"`
-	Used      bool      `gorm:"default:false"`
+	Email      string    `gorm:"type:varchar(255);index"` // 外部キー制約用
+	Token      string    `gorm:"uniqueIndex;type:varchar(255);not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	Used       bool      `gorm:"default:false"` // 使用済みまたは失効済み
+	ConsumedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// LoginAttempt はアカウント単位のログイン失敗回数とロックアウト期限
+type LoginAttempt struct {
+	BaseModel
+	Email        string `gorm:"uniqueIndex;type:varchar(255);not null"`
+	FailedCount  int    `gorm:"not null;default:0"`
+	LastFailedAt *time.Time
+	LockedUntil  *time.Time
+}
+
+// DeviceToken はセッション失効後に新しいセッションを発行するための長期間有効な端末トークン（ハッシュのみ保存）
+type DeviceToken struct {
+	BaseModel
+	TokenHash  string    `gorm:"uniqueIndex;type:varchar(64);not null"`
+	UserID     uint      `gorm:"index;not null"`
+	Email      string    `gorm:"type:varchar(255)"`
+	DeviceName string    `gorm:"type:varchar(100)"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// RefreshToken はセッション延長用のリフレッシュトークン（ハッシュのみ保存）
+type RefreshToken struct {
+	BaseModel
+	TokenHash  string    `gorm:"uniqueIndex;type:varchar(64);not null"`
+	FamilyID   string    `gorm:"index;type:varchar(64);not null"`
+	SessionID  string    `gorm:"index;not null"`
+	UserID     uint      `gorm:"not null"`
+	Email      string    `gorm:"type:varchar(255)"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	UsedAt     *time.Time
+	RevokedAt  *time.Time
+	ReplacedBy string `gorm:"type:varchar(64)"`
+}
+
+// AuthFailure は認証失敗の記録（auth・dbpilotの双方から集約）
+type AuthFailure struct {
+	BaseModel
+	Source   string `gorm:"size:50;not null"`
+	Reason   string `gorm:"size:100"`
+	ClientIP string `gorm:"size:64;index"`
+	Email    string `gorm:"type:varchar(255)"`
+	Path     string `gorm:"size:255"`
+}
+
+// AuthAuditLog は認証関連イベント（ログイン・ログアウト・トークン検証・アカウント作成・ロックなど）の監査ログ
+type AuthAuditLog struct {
+	BaseModel
+	EventType string `json:"event_type" gorm:"size:50;not null;index"`
+	Outcome   string `json:"outcome" gorm:"size:20;not null"`
+	UserID    uint   `json:"user_id" gorm:"index"`
+	Email     string `json:"email" gorm:"type:varchar(255);index"`
+	ClientIP  string `json:"client_ip" gorm:"size:64"`
+	UserAgent string `json:"user_agent" gorm:"size:255"`
+	Details   string `json:"details" gorm:"type:text"`
+}
+
+// BruteForceAlert はブルートフォース検知によるアラートの記録
+type BruteForceAlert struct {
+	BaseModel
+	ClientIP     string `gorm:"size:64;index"`
+	FailureCount int
+	AccountCount int
+	IncidentID   *uint
 }
 
 type LoginTokenRequest struct {
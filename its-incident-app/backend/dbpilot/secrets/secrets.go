@@ -0,0 +1,246 @@
+// Package secrets は環境変数またはGoogle Secret Managerから機密情報を取得します。
+// 環境変数の値が "sm://" で始まる場合はSecret Managerの参照として扱い、
+// 初回参照時に取得して SECRET_REFRESH_INTERVAL ごとに再取得します
+package secrets
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"dbpilot/logger"
+
+	"go.uber.org/zap"
+)
+
+const (
+	referencePrefix        = "sm://"
+	secretManagerBaseURL   = "https://secretmanager.googleapis.com/v1/"
+	metadataTokenURL       = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	metadataProjectURL     = "http://metadata.google.internal/computeMetadata/v1/project/project-id"
+	defaultRefreshInterval = 5 * time.Minute
+	tokenRefreshMargin     = time.Minute
+)
+
+type cachedSecret struct {
+	reference  string
+	value      string
+	fetchedAt  time.Time
+	refreshing bool
+	ready      chan struct{}
+}
+
+type accessToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+var (
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+
+	mu    sync.Mutex
+	cache = map[string]*cachedSecret{}
+
+	tokenMu sync.Mutex
+	token   accessToken
+)
+
+// Get は key の環境変数に設定された値を返します。
+// 値がSecret Managerの参照（sm://名前[/バージョン] または sm://projects/.../secrets/.../versions/...）の場合は
+// シークレットの内容を返し、取得に失敗した場合は前回取得した値（なければ空文字）を返します
+func Get(key string) string {
+	raw := os.Getenv(key)
+	if !strings.HasPrefix(raw, referencePrefix) {
+		return raw
+	}
+
+	mu.Lock()
+	entry, ok := cache[key]
+	if !ok || entry.reference != raw {
+		// 初回は取得が完了するまで待機する
+		entry = &cachedSecret{reference: raw, ready: make(chan struct{}), refreshing: true}
+		cache[key] = entry
+		mu.Unlock()
+		refresh(key, entry)
+		close(entry.ready)
+	} else {
+		mu.Unlock()
+		<-entry.ready
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if time.Since(entry.fetchedAt) >= refreshInterval() && !entry.refreshing {
+		// 期限切れの場合は現在の値を返しつつバックグラウンドで再取得する
+		entry.refreshing = true
+		go refresh(key, entry)
+	}
+	return entry.value
+}
+
+func refresh(key string, entry *cachedSecret) {
+	value, err := access(entry.reference)
+
+	mu.Lock()
+	defer mu.Unlock()
+	entry.refreshing = false
+	if err != nil {
+		// 失敗時は次回の参照で再試行できるよう取得時刻を更新しない
+		logger.Logger.Warn("シークレットの取得に失敗しました",
+			zap.String("key", key),
+			zap.Error(err))
+		return
+	}
+	entry.value = value
+	entry.fetchedAt = time.Now()
+}
+
+func access(reference string) (string, error) {
+	name, err := resourceName(strings.TrimPrefix(reference, referencePrefix))
+	if err != nil {
+		return "", err
+	}
+
+	bearer, err := metadataAccessToken()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, secretManagerBaseURL+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+bearer)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call secret manager: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("secret manager returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode secret manager response: %v", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(result.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret payload: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// resourceName は参照をSecret Managerのリソース名に変換します
+func resourceName(ref string) (string, error) {
+	if strings.HasPrefix(ref, "projects/") {
+		if !strings.Contains(ref, "/versions/") {
+			ref += "/versions/latest"
+		}
+		return ref, nil
+	}
+
+	secret, version, found := strings.Cut(ref, "/")
+	if !found || version == "" {
+		version = "latest"
+	}
+	if secret == "" {
+		return "", fmt.Errorf("invalid secret reference: %s", ref)
+	}
+
+	project, err := projectID()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("projects/%s/secrets/%s/versions/%s", project, secret, version), nil
+}
+
+func projectID() (string, error) {
+	if project := os.Getenv("GOOGLE_CLOUD_PROJECT"); project != "" {
+		return project, nil
+	}
+	body, err := metadataGet(metadataProjectURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve project id: %v", err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// AccessToken はメタデータサーバーから取得したGoogle APIのアクセストークンを返します（Secret Managerと同じキャッシュを使用）
+func AccessToken() (string, error) {
+	return metadataAccessToken()
+}
+
+func metadataAccessToken() (string, error) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	if token.token != "" && time.Now().Add(tokenRefreshMargin).Before(token.expiresAt) {
+		return token.token, nil
+	}
+
+	body, err := metadataGet(metadataTokenURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch access token: %v", err)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("failed to decode access token: %v", err)
+	}
+
+	token = accessToken{
+		token:     result.AccessToken,
+		expiresAt: time.Now().Add(time.Duration(result.ExpiresIn) * time.Second),
+	}
+	return token.token, nil
+}
+
+func metadataGet(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata server returned status %d", resp.StatusCode)
+	}
+	return body, nil
+}
+
+func refreshInterval() time.Duration {
+	if value := os.Getenv("SECRET_REFRESH_INTERVAL"); value != "" {
+		if interval, err := time.ParseDuration(value); err == nil && interval > 0 {
+			return interval
+		}
+	}
+	return defaultRefreshInterval
+}
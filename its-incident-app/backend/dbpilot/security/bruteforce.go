@@ -0,0 +1,183 @@
+// Package security は認証失敗の集約とブルートフォース攻撃の検知を行います
+package security
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dbpilot/logger"
+	"dbpilot/models"
+	"dbpilot/serviceauth"
+	"shared/idtoken"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultBruteForceWindow           = 10 * time.Minute
+	defaultBruteForceAccountThreshold = 5
+	defaultBruteForceFailureThreshold = 30
+	defaultBruteForceAlertCooldown    = time.Hour
+)
+
+var (
+	// 同一IPの検知処理が並行して走り、アラートが重複しないようにする
+	detectMu   sync.Mutex
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// RecordAuthFailure は認証失敗を保存し、バックグラウンドでしきい値の超過を確認します
+func RecordAuthFailure(db *gorm.DB, failure models.AuthFailure) {
+	if err := db.Create(&failure).Error; err != nil {
+		logger.Logger.Error("認証失敗の記録に失敗しました",
+			zap.Error(err),
+			zap.String("client_ip", failure.ClientIP))
+		return
+	}
+
+	if failure.ClientIP == "" {
+		return
+	}
+	go detectBruteForce(db, failure.ClientIP)
+}
+
+// detectBruteForce は同一IPからの失敗回数と対象アカウント数を集計し、しきい値を超えた場合にアラートを送信します
+func detectBruteForce(db *gorm.DB, clientIP string) {
+	detectMu.Lock()
+	defer detectMu.Unlock()
+
+	logFields := []zap.Field{zap.String("client_ip", clientIP)}
+	since := time.Now().Add(-getEnvDuration("BRUTE_FORCE_WINDOW", defaultBruteForceWindow))
+
+	var stats struct {
+		FailureCount int
+		AccountCount int
+	}
+	if err := db.Model(&models.AuthFailure{}).
+		Select("COUNT(*) AS failure_count, COUNT(DISTINCT NULLIF(email, '')) AS account_count").
+		Where("client_ip = ? AND created_at >= ?", clientIP, since).
+		Scan(&stats).Error; err != nil {
+		logger.Logger.Error("認証失敗の集計に失敗しました", append(logFields, zap.Error(err))...)
+		return
+	}
+
+	if stats.AccountCount < getEnvInt("BRUTE_FORCE_ACCOUNT_THRESHOLD", defaultBruteForceAccountThreshold) &&
+		stats.FailureCount < getEnvInt("BRUTE_FORCE_FAILURE_THRESHOLD", defaultBruteForceFailureThreshold) {
+		return
+	}
+
+	// クールダウン中は同じIPについて再度アラートしない
+	var recent int64
+	cooldownSince := time.Now().Add(-getEnvDuration("BRUTE_FORCE_ALERT_COOLDOWN", defaultBruteForceAlertCooldown))
+	if err := db.Model(&models.BruteForceAlert{}).
+		Where("client_ip = ? AND created_at >= ?", clientIP, cooldownSince).
+		Count(&recent).Error; err != nil {
+		logger.Logger.Error("アラート履歴の確認に失敗しました", append(logFields, zap.Error(err))...)
+		return
+	}
+	if recent > 0 {
+		return
+	}
+
+	logFields = append(logFields,
+		zap.Int("failure_count", stats.FailureCount),
+		zap.Int("account_count", stats.AccountCount))
+	logger.Logger.Warn("ブルートフォース攻撃の可能性を検知しました", logFields...)
+
+	alert := models.BruteForceAlert{
+		ClientIP:     clientIP,
+		FailureCount: stats.FailureCount,
+		AccountCount: stats.AccountCount,
+	}
+
+	if strings.EqualFold(os.Getenv("BRUTE_FORCE_CREATE_INCIDENT"), "true") {
+		incident := models.Incident{
+			Datetime: time.Now(),
+			Status:   "未着手",
+			Assignee: "-",
+		}
+		if err := db.Create(&incident).Error; err != nil {
+			logger.Logger.Error("インシデントの作成に失敗しました", append(logFields, zap.Error(err))...)
+		} else {
+			alert.IncidentID = &incident.ID
+		}
+	}
+
+	if err := db.Create(&alert).Error; err != nil {
+		logger.Logger.Error("アラートの記録に失敗しました", append(logFields, zap.Error(err))...)
+		return
+	}
+
+	if err := sendAlert(alert); err != nil {
+		logger.Logger.Error("ブルートフォースアラートの送信に失敗しました", append(logFields, zap.Error(err))...)
+	}
+}
+
+// sendAlert は通知サービス経由でアラートを送信します
+func sendAlert(alert models.BruteForceAlert) error {
+	notificationURL := os.Getenv("NOTIFICATION_SERVICE_URL")
+	if notificationURL == "" {
+		return fmt.Errorf("NOTIFICATION_SERVICE_URL is not configured")
+	}
+
+	payload := map[string]interface{}{
+		"title": "ブルートフォース攻撃の可能性を検知しました",
+		"content": fmt.Sprintf("IPアドレス %s から %d 件の認証失敗（対象アカウント %d 件）を検知しました。",
+			alert.ClientIP, alert.FailureCount, alert.AccountCount),
+		"responder": "system",
+		"name":      "security",
+		"priority":  "高",
+	}
+	if alert.IncidentID != nil {
+		payload["incident_id"] = *alert.IncidentID
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, notificationURL+"/notify", bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+idtoken.BearerToken(notificationURL, serviceauth.PrimaryServiceToken()))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("notification service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,43 @@
+package serviceauth
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"dbpilot/secrets"
+)
+
+// ServiceTokens は SERVICE_TOKEN に設定された有効なトークンの一覧を返します。
+// ローテーション時は "現行,次期" のようにカンマ区切りで複数指定し、
+// 全サービスが両方を受け付けた後に順序を入れ替えて送信側を切り替えます
+func ServiceTokens() []string {
+	var tokens []string
+	for _, token := range strings.Split(secrets.Get("SERVICE_TOKEN"), ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// PrimaryServiceToken は送信時に使用するトークン（一覧の先頭）を返します
+func PrimaryServiceToken() string {
+	if tokens := ServiceTokens(); len(tokens) > 0 {
+		return tokens[0]
+	}
+	return ""
+}
+
+// IsServiceToken は受信したトークンが有効なトークンのいずれかと一致するかを返します
+func IsServiceToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	matched := false
+	for _, candidate := range ServiceTokens() {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			matched = true
+		}
+	}
+	return matched
+}
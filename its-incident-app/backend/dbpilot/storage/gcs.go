@@ -0,0 +1,73 @@
+// Package storage はmailconverterがCloud Storageに保存した受信メールの生データを読み取ります。
+// STORAGE_EMULATOR_HOST が設定されている場合はエミュレーターに認証なしで接続します
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"dbpilot/secrets"
+)
+
+const gcsObjectEndpoint = "https://storage.googleapis.com/storage/v1/b/"
+
+var client = &http.Client{Timeout: 60 * time.Second}
+
+// ParseGCSURI は gs://バケット/オブジェクト 形式のURIをバケットとオブジェクト名に分けます
+func ParseGCSURI(uri string) (bucket, object string, err error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(uri), "gs://")
+	if !ok {
+		return "", "", fmt.Errorf("not a gs:// URI: %s", uri)
+	}
+	bucket, object, _ = strings.Cut(rest, "/")
+	if bucket == "" || object == "" {
+		return "", "", fmt.Errorf("invalid gs:// URI: %s", uri)
+	}
+	return bucket, object, nil
+}
+
+// Open は gs:// 形式のURIのオブジェクトの内容とサイズを返します。呼び出し側で Close してください
+func Open(ctx context.Context, uri string) (io.ReadCloser, int64, error) {
+	bucket, object, err := ParseGCSURI(uri)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	endpoint, useAuth := gcsObjectEndpoint, true
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+			host = "http://" + host
+		}
+		endpoint, useAuth = strings.TrimSuffix(host, "/")+"/storage/v1/b/", false
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		endpoint+url.PathEscape(bucket)+"/o/"+url.PathEscape(object)+"?alt=media", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if useAuth {
+		token, err := secrets.AccessToken()
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s: %v", uri, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, 0, fmt.Errorf("cloud storage returned status %d for %s: %s", resp.StatusCode, uri, string(body))
+	}
+	return resp.Body, resp.ContentLength, nil
+}
@@ -3,13 +3,16 @@ package config
 import (
 	"fmt"
 	"mailconvertor/logger"
+	"mailconvertor/mtls"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -24,6 +27,117 @@ type ServerConfig struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	IdleTimeout     time.Duration
+	// AttachmentBucket は添付ファイルの内容を保存するCloud Storageのバケット（空の場合は保存しない）
+	AttachmentBucket string
+	// AttachmentPrefix は添付ファイルのオブジェクト名の接頭辞（その下にメッセージIDごとに保存）
+	AttachmentPrefix string
+	// RawArchiveBucket は受信したメールの生データ（RFC822）をパース前に保存するCloud Storageのバケット（空の場合は保存しない）
+	RawArchiveBucket string
+	// RawArchivePrefix は生データのオブジェクト名の接頭辞（その下に メッセージID.eml として保存）
+	RawArchivePrefix string
+	// MaxMessageSize は受け付けるメールの上限（バイト、超える場合は 413）
+	MaxMessageSize int64
+	// StreamingThreshold を超えるメールはメモリに読み込まずにストリーミングでパースします（バイト）
+	StreamingThreshold int64
+	// SESTopicARNs はSESの受信通知として受け付けるSNSトピックのARN（空の場合は /ses を受け付けない）
+	SESTopicARNs []string
+	// SESS3Region はSESがメールを保存するS3バケットのリージョン
+	SESS3Region string
+	// SESS3Endpoint はS3のエンドポイント（LocalStack などの検証用、空の場合はAWS）
+	SESS3Endpoint string
+	// GmailSubscription はGmailのプッシュ通知を受け取るPub/Subのサブスクリプション（空の場合はGmailを監視しない）
+	GmailSubscription string
+	// GmailTopic は users.watch で通知先に登録するPub/Subのトピック
+	GmailTopic string
+	// GmailUser は監視するメールボックス（"me" またはメールアドレス）
+	GmailUser string
+	// GmailLabelIDs は監視するラベル
+	GmailLabelIDs []string
+	// IMAP は共有メールボックスをポーリングする設定（Host が空の場合はポーリングしない）
+	IMAP IMAPConfig
+	// DatastoreDatabase / DatastoreNamespace はIMAPの取り込み位置・処理済みの Message-ID・送信待ちのメールを保存するDatastoreのデータベースと名前空間
+	DatastoreDatabase  string
+	DatastoreNamespace string
+	// MessageIDDedup が true の場合、処理済みの Message-ID を DedupTTL の間Datastoreに記録して重複を送信しません
+	MessageIDDedup bool
+	DedupTTL       time.Duration
+	// ReadinessTimeout は /ready での依存サービス（Datastore・autopilot）ごとの確認の上限
+	ReadinessTimeout time.Duration
+	// AutopilotURL はメールデータの送信先（/ready で疎通を確認）
+	AutopilotURL string
+	// ForwardTimeout は送信先へのメールデータの送信1回あたりの上限
+	ForwardTimeout time.Duration
+	// SenderAllowlist が空でない場合、一致する送信者のメールだけをautopilotに送信します（SenderBlocklist が優先）。
+	// 要素はドメイン（サブドメインにも一致）またはメールアドレス
+	SenderAllowlist []string
+	SenderBlocklist []string
+	// ReceiveQueue は /receive で受け付けたメールを一時保存して非同期で処理する設定（Bucket が空の場合は同期的に処理）
+	ReceiveQueue ReceiveQueueConfig
+	// ForwardRetry はautopilotに送信できなかったメールをDatastoreに保存して再送する設定（Enabled が false の場合は 500 を返す）
+	ForwardRetry ForwardRetryConfig
+	// RoutingRules はメールの送信先のルール（JSONの配列、空の場合はすべて AUTOPILOT_URL に送信）。
+	// 例: [{"name":"vendor","sender_domain":"vendor.example.com","subject":"^\\[Notice\\]","target":"https://passthrough.example.com"}]
+	RoutingRules string
+	// BodyNormalize が true の場合、本文から署名・定型のフッター・引用した過去のメールを取り除いてから送信します。
+	// BodyRules（JSONの配列）のルールは既定のルールの後に適用します
+	BodyNormalize bool
+	BodyRules     string
+	// Batch は /receive/batch（過去のメールの移行用）の設定
+	Batch BatchConfig
+	// NotificationURL はアラートを送るnotifyサービスのURL（空の場合はアラートを送らない）
+	NotificationURL string
+	// FailureAlert は受信の失敗率によるアラートの設定
+	FailureAlert FailureAlertConfig
+}
+
+// ForwardRetryConfig はautopilotに送信できなかったメールの再送の設定です
+type ForwardRetryConfig struct {
+	Enabled      bool
+	Interval     time.Duration
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	BatchSize    int
+}
+
+// BatchConfig は複数のメールをまとめて受け付ける /receive/batch の設定です
+type BatchConfig struct {
+	Concurrency int
+	MaxItems    int
+	MaxBytes    int64
+}
+
+// FailureAlertConfig は受信の失敗（パースの失敗・空の本文・サイズ超過・送信の失敗）の割合によるアラートの設定です
+type FailureAlertConfig struct {
+	Threshold   float64
+	Window      time.Duration
+	MinMessages int
+	Cooldown    time.Duration
+	// Immediate が true の場合、パース・送信の失敗を1件ごとに通知します（種類ごとに ImmediateCooldown に1回まで）
+	Immediate         bool
+	ImmediateCooldown time.Duration
+}
+
+// ReceiveQueueConfig は受信したメールを一時保存して非同期で処理する設定です
+type ReceiveQueueConfig struct {
+	Bucket        string
+	Prefix        string
+	Workers       int
+	QueueSize     int
+	MaxAttempts   int
+	RetryDelay    time.Duration
+	SweepInterval time.Duration
+}
+
+// IMAPConfig はIMAPのポーリングの設定です。パスワードは IMAP_PASSWORD（sm:// 参照可）から取得します
+type IMAPConfig struct {
+	Host       string
+	Port       int
+	TLS        bool
+	Username   string
+	Folder     string
+	Interval   time.Duration
+	MarkSeen   bool
+	UnseenOnly bool
 }
 
 // InitConfig は環境設定を初期化します
@@ -45,19 +159,120 @@ func InitConfig() (*ServerConfig, error) {
 		LogLevel:    logLevel,
 		Environment: getEnv("ENVIRONMENT", "development"),
 		ServiceName: getEnv("K_SERVICE", "mailconvertor"),
+
+		AttachmentBucket: getEnv("ATTACHMENT_BUCKET", ""),
+		AttachmentPrefix: getEnv("ATTACHMENT_PREFIX", "attachments"),
+
+		RawArchiveBucket: getEnv("RAW_ARCHIVE_BUCKET", ""),
+		RawArchivePrefix: getEnv("RAW_ARCHIVE_PREFIX", "raw"),
+
+		MaxMessageSize:     getInt64("MAX_MESSAGE_SIZE", 64*1024*1024),
+		StreamingThreshold: getInt64("STREAMING_THRESHOLD", 8*1024*1024),
+
+		SESTopicARNs:  getList("SES_TOPIC_ARNS"),
+		SESS3Region:   getEnv("SES_S3_REGION", getEnv("AWS_REGION", "us-east-1")),
+		SESS3Endpoint: getEnv("SES_S3_ENDPOINT", ""),
+
+		GmailSubscription: getEnv("GMAIL_SUBSCRIPTION", ""),
+		GmailTopic:        getEnv("GMAIL_TOPIC", ""),
+		GmailUser:         getEnv("GMAIL_USER", "me"),
+		GmailLabelIDs:     getList("GMAIL_LABEL_IDS"),
+
+		IMAP: IMAPConfig{
+			Host:       getEnv("IMAP_HOST", ""),
+			Port:       int(getInt64("IMAP_PORT", 993)),
+			TLS:        !strings.EqualFold(getEnv("IMAP_TLS", "true"), "false"),
+			Username:   getEnv("IMAP_USERNAME", ""),
+			Folder:     getEnv("IMAP_FOLDER", "INBOX"),
+			Interval:   getDuration("IMAP_POLL_INTERVAL", time.Minute),
+			MarkSeen:   !strings.EqualFold(getEnv("IMAP_MARK_SEEN", "true"), "false"),
+			UnseenOnly: strings.EqualFold(getEnv("IMAP_UNSEEN_ONLY", "false"), "true"),
+		},
+		DatastoreDatabase:  getEnv("DATASTORE_DATABASE", ""),
+		DatastoreNamespace: getEnv("DATASTORE_NAMESPACE", ""),
+
+		MessageIDDedup: strings.EqualFold(getEnv("MESSAGE_ID_DEDUP", "false"), "true"),
+		DedupTTL:       getDuration("DEDUP_TTL", 72*time.Hour),
+
+		ReadinessTimeout: getDuration("READINESS_TIMEOUT", 3*time.Second),
+		AutopilotURL:     getEnv("AUTOPILOT_URL", ""),
+		ForwardTimeout:   getDuration("FORWARD_TIMEOUT", 30*time.Second),
+
+		SenderAllowlist: getList("SENDER_ALLOWLIST"),
+		SenderBlocklist: getList("SENDER_BLOCKLIST"),
+
+		ReceiveQueue: ReceiveQueueConfig{
+			Bucket:        getEnv("SPOOL_BUCKET", ""),
+			Prefix:        getEnv("SPOOL_PREFIX", "spool"),
+			Workers:       int(getInt64("RECEIVE_WORKERS", 4)),
+			QueueSize:     int(getInt64("RECEIVE_QUEUE_SIZE", 100)),
+			MaxAttempts:   int(getInt64("RECEIVE_MAX_ATTEMPTS", 5)),
+			RetryDelay:    getDuration("RECEIVE_RETRY_DELAY", 10*time.Second),
+			SweepInterval: getDuration("RECEIVE_SWEEP_INTERVAL", 5*time.Minute),
+		},
+
+		ForwardRetry: ForwardRetryConfig{
+			Enabled:      strings.EqualFold(getEnv("FORWARD_RETRY", "false"), "true"),
+			Interval:     getDuration("FORWARD_RETRY_INTERVAL", 30*time.Second),
+			InitialDelay: getDuration("FORWARD_RETRY_INITIAL_DELAY", 30*time.Second),
+			MaxDelay:     getDuration("FORWARD_RETRY_MAX_DELAY", 30*time.Minute),
+			BatchSize:    int(getInt64("FORWARD_RETRY_BATCH_SIZE", 50)),
+		},
+
+		RoutingRules: getEnv("ROUTING_RULES", ""),
+
+		BodyNormalize: strings.EqualFold(getEnv("BODY_NORMALIZE", "true"), "true"),
+		BodyRules:     getEnv("BODY_RULES", ""),
+
+		Batch: BatchConfig{
+			Concurrency: int(getInt64("BATCH_CONCURRENCY", 4)),
+			MaxItems:    int(getInt64("BATCH_MAX_ITEMS", 1000)),
+			MaxBytes:    getInt64("BATCH_MAX_BYTES", 100*1024*1024),
+		},
+
+		NotificationURL: getEnv("NOTIFICATION_SERVICE_URL", ""),
+		FailureAlert: FailureAlertConfig{
+			Threshold:   getFloat("FAILURE_ALERT_THRESHOLD", 0.2),
+			Window:      getDuration("FAILURE_ALERT_WINDOW", 10*time.Minute),
+			MinMessages: int(getInt64("FAILURE_ALERT_MIN_MESSAGES", 20)),
+			Cooldown:    getDuration("FAILURE_ALERT_COOLDOWN", 30*time.Minute),
+
+			Immediate:         strings.EqualFold(getEnv("FAILURE_ALERT_IMMEDIATE", "true"), "true"),
+			ImmediateCooldown: getDuration("FAILURE_ALERT_IMMEDIATE_COOLDOWN", 5*time.Minute),
+		},
 	}, nil
 }
 
+// Validate は設定の整合性を確認します
+func (c *ServerConfig) Validate() error {
+	if c.GmailSubscription != "" && c.GmailTopic == "" {
+		return fmt.Errorf("GMAIL_TOPIC is required when GMAIL_SUBSCRIPTION is set")
+	}
+	if c.IMAP.Host != "" && c.IMAP.Username == "" {
+		return fmt.Errorf("IMAP_USERNAME is required when IMAP_HOST is set")
+	}
+	return nil
+}
+
 // SetupServer はサーバーの設定を行います
 func SetupServer(r *gin.Engine) *http.Server {
 	config, _ := InitConfig()
 	displayServerConfig(r, config)
 
-	return &http.Server{
+	srv := &http.Server{
 		Addr:              ":" + config.Port,
 		Handler:           r,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
+
+	// 相互TLS（MTLS_CERT_FILE/MTLS_KEY_FILE が設定されている場合のみ）
+	tlsConfig, err := mtls.ServerConfig()
+	if err != nil {
+		logger.Logger.Fatal("mTLSの設定に失敗しました", zap.Error(err))
+	}
+	srv.TLSConfig = tlsConfig
+
+	return srv
 }
 
 func initLogLevel() zapcore.Level {
@@ -98,6 +313,44 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getInt64(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && f > 0 {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+// getList はカンマ区切りの環境変数を空要素を除いたリストで返します
+func getList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func displayServerConfig(r *gin.Engine, config *ServerConfig) {
 	var routeInfo strings.Builder
 	routeInfo.WriteString("Registered Endpoints:\n")
@@ -0,0 +1,291 @@
+// Package datastore はFirestore（Datastoreモード）のREST APIのうち、mailconvertor で使う操作（lookup・commit・runQuery）を提供します。
+// DATASTORE_EMULATOR_HOST が設定されている場合はエミュレーターに認証なしで接続します
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"mailconvertor/secrets"
+)
+
+const datastoreEndpoint = "https://datastore.googleapis.com/v1/"
+
+// ErrAlreadyExists は insert で同じキーのエンティティが既に存在したことを表します
+var ErrAlreadyExists = errors.New("entity already exists")
+
+// Client はプロジェクトのデータベース・名前空間に対して操作を行います
+type Client struct {
+	project   string
+	database  string
+	namespace string
+	endpoint  string
+	client    *http.Client
+	useAuth   bool
+}
+
+// NewClient は database（空の場合は既定のデータベース）と namespace を操作するClientを作成します
+func NewClient(database, namespace string) (*Client, error) {
+	project, err := secrets.ProjectID()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		project:   project,
+		database:  database,
+		namespace: namespace,
+		endpoint:  datastoreEndpoint,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		useAuth:   true,
+	}
+	if host := os.Getenv("DATASTORE_EMULATOR_HOST"); host != "" {
+		c.endpoint = "http://" + host + "/v1/"
+		c.useAuth = false
+	}
+	return c, nil
+}
+
+// Value はプロパティの値です（使用する型のみ）
+type Value struct {
+	StringValue        *string    `json:"stringValue,omitempty"`
+	IntegerValue       *string    `json:"integerValue,omitempty"`
+	TimestampValue     *time.Time `json:"timestampValue,omitempty"`
+	ExcludeFromIndexes bool       `json:"excludeFromIndexes,omitempty"`
+}
+
+// Key はエンティティのキーです
+type Key struct {
+	PartitionID map[string]string `json:"partitionId"`
+	Path        []PathElement     `json:"path"`
+}
+
+// PathElement はキーのパスの要素です
+type PathElement struct {
+	Kind string `json:"kind"`
+	Name string `json:"name,omitempty"`
+}
+
+// Entity はエンティティです
+type Entity struct {
+	Key        Key              `json:"key"`
+	Properties map[string]Value `json:"properties"`
+}
+
+// PropertyFilter はクエリのプロパティの条件です。Op は EQUAL・LESS_THAN_OR_EQUAL などのREST APIの演算子です
+type PropertyFilter struct {
+	Property string
+	Op       string
+	Value    Value
+}
+
+// Query は1つの種類に対するクエリです。OrderBy のプロパティの昇順に、最大 Limit 件を返します
+type Query struct {
+	Kind    string
+	Filter  *PropertyFilter
+	OrderBy string
+	Limit   int
+}
+
+// String は文字列の値を作成します。excludeFromIndexes が true の場合はインデックスに含めません
+func String(value string, excludeFromIndexes bool) Value {
+	return Value{StringValue: &value, ExcludeFromIndexes: excludeFromIndexes}
+}
+
+// Integer は整数の値を作成します
+func Integer(value int64) Value {
+	s := strconv.FormatInt(value, 10)
+	return Value{IntegerValue: &s}
+}
+
+// Timestamp は日時の値を作成します
+func Timestamp(value time.Time) Value {
+	value = value.UTC()
+	return Value{TimestampValue: &value}
+}
+
+// String はプロパティ name の文字列を返します（ない場合は空文字）
+func (e *Entity) String(name string) string {
+	if value, ok := e.Properties[name]; ok && value.StringValue != nil {
+		return *value.StringValue
+	}
+	return ""
+}
+
+// Integer はプロパティ name の整数を返します（ない場合は 0）
+func (e *Entity) Integer(name string) int64 {
+	if value, ok := e.Properties[name]; ok && value.IntegerValue != nil {
+		if n, err := strconv.ParseInt(*value.IntegerValue, 10, 64); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// Timestamp はプロパティ name の日時を返します（ない場合はゼロ値）
+func (e *Entity) Timestamp(name string) time.Time {
+	if value, ok := e.Properties[name]; ok && value.TimestampValue != nil {
+		return *value.TimestampValue
+	}
+	return time.Time{}
+}
+
+// Key は kind と name のキーを返します
+func (c *Client) Key(kind, name string) Key {
+	partition := map[string]string{"projectId": c.project}
+	if c.database != "" {
+		partition["databaseId"] = c.database
+	}
+	if c.namespace != "" {
+		partition["namespaceId"] = c.namespace
+	}
+	return Key{PartitionID: partition, Path: []PathElement{{Kind: kind, Name: name}}}
+}
+
+// Lookup はキーのエンティティを返します。存在しない場合は nil を返します
+func (c *Client) Lookup(ctx context.Context, key Key) (*Entity, error) {
+	var result struct {
+		Found []struct {
+			Entity Entity `json:"entity"`
+		} `json:"found"`
+	}
+	if err := c.call(ctx, ":lookup", map[string]interface{}{"keys": []Key{key}}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Found) == 0 {
+		return nil, nil
+	}
+	return &result.Found[0].Entity, nil
+}
+
+// RunQuery はクエリに一致するエンティティを返します
+func (c *Client) RunQuery(ctx context.Context, q Query) ([]Entity, error) {
+	query := map[string]interface{}{
+		"kind": []map[string]string{{"name": q.Kind}},
+	}
+	if q.Filter != nil {
+		query["filter"] = map[string]interface{}{
+			"propertyFilter": map[string]interface{}{
+				"property": map[string]string{"name": q.Filter.Property},
+				"op":       q.Filter.Op,
+				"value":    q.Filter.Value,
+			},
+		}
+	}
+	if q.OrderBy != "" {
+		query["order"] = []map[string]interface{}{{
+			"property":  map[string]string{"name": q.OrderBy},
+			"direction": "ASCENDING",
+		}}
+	}
+	if q.Limit > 0 {
+		query["limit"] = q.Limit
+	}
+
+	var result struct {
+		Batch struct {
+			EntityResults []struct {
+				Entity Entity `json:"entity"`
+			} `json:"entityResults"`
+		} `json:"batch"`
+	}
+	body := map[string]interface{}{
+		"partitionId": c.Key(q.Kind, "").PartitionID,
+		"query":       query,
+	}
+	if err := c.call(ctx, ":runQuery", body, &result); err != nil {
+		return nil, err
+	}
+
+	entities := make([]Entity, 0, len(result.Batch.EntityResults))
+	for _, r := range result.Batch.EntityResults {
+		entities = append(entities, r.Entity)
+	}
+	return entities, nil
+}
+
+// Name はキーの名前を返します
+func (k Key) Name() string {
+	if len(k.Path) == 0 {
+		return ""
+	}
+	return k.Path[len(k.Path)-1].Name
+}
+
+// Ping は存在しないキーを参照してDatastoreに到達できるか（認証を含む）を確認します
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Lookup(ctx, c.Key("HealthCheck", "ping"))
+	return err
+}
+
+// Upsert はエンティティを保存します（存在する場合は上書き）
+func (c *Client) Upsert(ctx context.Context, entity *Entity) error {
+	return c.commit(ctx, map[string]interface{}{"upsert": entity})
+}
+
+// Insert はエンティティを作成します。同じキーのエンティティが存在する場合は ErrAlreadyExists を返します
+func (c *Client) Insert(ctx context.Context, entity *Entity) error {
+	return c.commit(ctx, map[string]interface{}{"insert": entity})
+}
+
+// Delete はキーのエンティティを削除します（存在しない場合も成功）
+func (c *Client) Delete(ctx context.Context, key Key) error {
+	return c.commit(ctx, map[string]interface{}{"delete": key})
+}
+
+func (c *Client) commit(ctx context.Context, mutation map[string]interface{}) error {
+	return c.call(ctx, ":commit", map[string]interface{}{
+		"mode":      "NON_TRANSACTIONAL",
+		"mutations": []map[string]interface{}{mutation},
+	}, nil)
+}
+
+func (c *Client) call(ctx context.Context, method string, body map[string]interface{}, out interface{}) error {
+	// 既定以外のデータベースはリクエストでも指定する
+	if c.database != "" {
+		body["databaseId"] = c.database
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"projects/"+c.project+method, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.useAuth {
+		token, err := secrets.AccessToken()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, string(respBody))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("datastore %s returned status %d: %s", method, resp.StatusCode, string(respBody))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
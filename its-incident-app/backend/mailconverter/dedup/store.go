@@ -0,0 +1,78 @@
+// Package dedup は処理済みのメールの Message-ID を記録し、メールサーバーの再送による重複を検出します。
+// 記録は expire_at を持つエンティティとしてDatastoreに保存します。期限切れの記録を自動で削除するには
+// Datastoreの TTL ポリシーを種類 ProcessedMessage のプロパティ expire_at に設定してください
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"mailconvertor/datastore"
+)
+
+// processedKind は処理済みの Message-ID を記録するエンティティの種類（キーの名前は Message-ID のSHA-256）
+const processedKind = "ProcessedMessage"
+
+// Store は処理済みの Message-ID を ttl の間記録します
+type Store struct {
+	client *datastore.Client
+	ttl    time.Duration
+}
+
+// NewStore は client に ttl の間 Message-ID を記録するStoreを作成します
+func NewStore(client *datastore.Client, ttl time.Duration) *Store {
+	return &Store{client: client, ttl: ttl}
+}
+
+// Claim は Message-ID を処理中として記録します。期限内に同じ Message-ID が記録されている場合は
+// duplicate を true とし、最初に受け付けたメッセージID（X-Message-ID）を返します
+func (s *Store) Claim(ctx context.Context, originalMessageID, messageID string) (duplicate bool, firstMessageID string, err error) {
+	key := s.key(originalMessageID)
+	now := time.Now()
+	entity := &datastore.Entity{
+		Key: key,
+		Properties: map[string]datastore.Value{
+			"original_message_id": datastore.String(normalize(originalMessageID), true),
+			"message_id":          datastore.String(messageID, true),
+			"created_at":          datastore.Timestamp(now),
+			"expire_at":           datastore.Timestamp(now.Add(s.ttl)),
+		},
+	}
+
+	err = s.client.Insert(ctx, entity)
+	if err == nil {
+		return false, "", nil
+	}
+	if !errors.Is(err, datastore.ErrAlreadyExists) {
+		return false, "", err
+	}
+
+	// TTL による削除は遅れることがあるため、期限切れの記録は上書きして受け付ける
+	existing, err := s.client.Lookup(ctx, key)
+	if err != nil {
+		return false, "", err
+	}
+	if existing != nil && existing.Timestamp("expire_at").After(now) {
+		return true, existing.String("message_id"), nil
+	}
+	return false, "", s.client.Upsert(ctx, entity)
+}
+
+// Release は記録を取り消します。送信に失敗したメールを再送で処理できるようにするために使います
+func (s *Store) Release(ctx context.Context, originalMessageID string) error {
+	return s.client.Delete(ctx, s.key(originalMessageID))
+}
+
+func (s *Store) key(originalMessageID string) datastore.Key {
+	sum := sha256.Sum256([]byte(normalize(originalMessageID)))
+	return s.client.Key(processedKind, hex.EncodeToString(sum[:]))
+}
+
+// normalize は Message-ID の前後の空白と山括弧を取り除きます
+func normalize(originalMessageID string) string {
+	return strings.Trim(strings.TrimSpace(originalMessageID), "<>")
+}
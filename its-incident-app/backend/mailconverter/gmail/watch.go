@@ -0,0 +1,450 @@
+// Package gmail はGmailのプッシュ通知（users.watch + Pub/Sub）を購読し、
+// 新しく届いたメールをGmail APIで取得して取り込みます（SMTPからHTTPへの転送が不要になります）。
+// Gmail APIはOAuthのリフレッシュトークン（GMAIL_CLIENT_ID / GMAIL_CLIENT_SECRET / GMAIL_REFRESH_TOKEN、sm:// 参照可）、
+// Pub/Subはメタデータサーバーのアクセストークンで呼び出します
+package gmail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mailconvertor/logger"
+	"mailconvertor/secrets"
+
+	"go.uber.org/zap"
+)
+
+const (
+	gmailBaseURL  = "https://gmail.googleapis.com/gmail/v1/users/"
+	pubsubBaseURL = "https://pubsub.googleapis.com/v1/"
+	oauthTokenURL = "https://oauth2.googleapis.com/token"
+
+	// watchRenewInterval ごとに users.watch を呼び直す（監視は7日で期限切れになる）
+	watchRenewInterval = 24 * time.Hour
+	// maxPullMessages は1回のPullで受け取る通知の上限
+	maxPullMessages = 10
+	// pullTimeout はPullで通知を待つ時間（通知がなければ空で戻る）
+	pullTimeout = 50 * time.Second
+	// maxIngestAttempts を超えて取り込みに失敗したメールは飛ばす（パースできないメールで監視が止まらないように）
+	maxIngestAttempts = 5
+)
+
+// IngestFunc は取得したメールの生データ（RFC822）を取り込みます。エラーの場合は通知を確認応答せず再試行します
+type IngestFunc func(ctx context.Context, messageID string, raw []byte) error
+
+// Config はGmailの監視の設定です
+type Config struct {
+	User         string        // 監視するメールボックス（"me" またはメールアドレス）
+	Topic        string        // 通知先のPub/Subトピック（projects/.../topics/...）
+	Subscription string        // 通知を受け取るPull型のサブスクリプション（projects/.../subscriptions/...）
+	LabelIDs     []string      // 監視するラベル（既定 INBOX）
+	RetryDelay   time.Duration // Pub/Sub・Gmail APIの呼び出しに失敗した場合の待機時間
+}
+
+// Watcher はGmailの新着メールを監視して取り込むワーカーです
+type Watcher struct {
+	cfg    Config
+	ingest IngestFunc
+	client *http.Client
+
+	// historyID はここまで取り込んだメールボックスの履歴ID
+	historyID uint64
+	// attempts はメールごとの取り込みの失敗回数
+	attempts map[string]int
+
+	tokenMu     sync.Mutex
+	token       string
+	tokenExpiry time.Time
+
+	done chan struct{}
+}
+
+// NewWatcher はGmailの監視ワーカーを作成します。サブスクリプションが未設定の場合は nil を返します
+func NewWatcher(cfg Config, ingest IngestFunc) *Watcher {
+	if cfg.Subscription == "" {
+		return nil
+	}
+	if cfg.User == "" {
+		cfg.User = "me"
+	}
+	if len(cfg.LabelIDs) == 0 {
+		cfg.LabelIDs = []string{"INBOX"}
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = 10 * time.Second
+	}
+	return &Watcher{
+		cfg:      cfg,
+		ingest:   ingest,
+		client:   &http.Client{Timeout: 60 * time.Second},
+		attempts: map[string]int{},
+		done:     make(chan struct{}),
+	}
+}
+
+// Start は監視を開始します。ctx がキャンセルされると処理中のメールを終えてから停止します
+func (w *Watcher) Start(ctx context.Context) {
+	if w == nil {
+		return
+	}
+	go w.run(ctx)
+}
+
+// Wait はワーカーの停止を待ちます（ctx の期限まで）
+func (w *Watcher) Wait(ctx context.Context) {
+	if w == nil {
+		return
+	}
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		logger.Logger.Warn("Gmailの監視の停止がタイムアウトしました")
+	}
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	defer close(w.done)
+	log := logger.Logger.With(zap.String("gmailUser", w.cfg.User))
+
+	var renewAt time.Time
+	for ctx.Err() == nil {
+		if time.Now().After(renewAt) {
+			if err := w.watch(ctx); err != nil {
+				log.Error("Gmailの監視の開始に失敗しました", zap.Error(err))
+				sleep(ctx, w.cfg.RetryDelay)
+				continue
+			}
+			renewAt = time.Now().Add(watchRenewInterval)
+		}
+
+		if err := w.pullOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Error("Gmailの通知の処理に失敗しました", zap.Error(err))
+			sleep(ctx, w.cfg.RetryDelay)
+		}
+	}
+	log.Info("Gmailの監視を停止しました")
+}
+
+// watch は users.watch を呼び出して通知を登録します。初回は返された履歴IDから取り込みを始めます
+func (w *Watcher) watch(ctx context.Context) error {
+	body := map[string]interface{}{
+		"topicName":           w.cfg.Topic,
+		"labelIds":            w.cfg.LabelIDs,
+		"labelFilterBehavior": "include",
+	}
+	var result struct {
+		HistoryID  string `json:"historyId"`
+		Expiration string `json:"expiration"`
+	}
+	if err := w.gmailCall(ctx, http.MethodPost, "/watch", body, &result); err != nil {
+		return err
+	}
+
+	historyID, err := strconv.ParseUint(result.HistoryID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid history id %q: %v", result.HistoryID, err)
+	}
+	if w.historyID == 0 {
+		w.historyID = historyID
+	}
+	logger.Logger.Info("Gmailの監視を登録しました",
+		zap.String("gmailUser", w.cfg.User),
+		zap.Uint64("historyId", w.historyID),
+		zap.String("expiration", result.Expiration))
+	return nil
+}
+
+// pullOnce はサブスクリプションから通知を受け取り、新着メールを取り込んでから確認応答します
+func (w *Watcher) pullOnce(ctx context.Context) error {
+	var pulled struct {
+		ReceivedMessages []struct {
+			AckID   string `json:"ackId"`
+			Message struct {
+				Data string `json:"data"`
+			} `json:"message"`
+		} `json:"receivedMessages"`
+	}
+	pullCtx, cancel := context.WithTimeout(ctx, pullTimeout)
+	err := w.pubsubCall(pullCtx, ":pull", map[string]int{"maxMessages": maxPullMessages}, &pulled)
+	cancel()
+	if err != nil {
+		if pullCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			return nil
+		}
+		return err
+	}
+	if len(pulled.ReceivedMessages) == 0 {
+		return nil
+	}
+
+	// 通知は「履歴IDが進んだ」ことだけを示すため、まとめて前回の履歴IDからの差分を取り込む
+	var latest uint64
+	ackIDs := make([]string, 0, len(pulled.ReceivedMessages))
+	for _, received := range pulled.ReceivedMessages {
+		ackIDs = append(ackIDs, received.AckID)
+		data, err := base64.StdEncoding.DecodeString(received.Message.Data)
+		if err != nil {
+			continue
+		}
+		var notification struct {
+			HistoryID uint64 `json:"historyId"`
+		}
+		if err := json.Unmarshal(data, &notification); err == nil && notification.HistoryID > latest {
+			latest = notification.HistoryID
+		}
+	}
+
+	if latest > w.historyID {
+		if err := w.syncHistory(ctx, latest); err != nil {
+			// 確認応答しないことでPub/Subに再送させる
+			return err
+		}
+	}
+	return w.pubsubCall(ctx, ":acknowledge", map[string][]string{"ackIds": ackIDs}, nil)
+}
+
+// syncHistory は前回の履歴IDから追加されたメールを取り込みます。すべて成功した場合のみ履歴IDを進めます
+func (w *Watcher) syncHistory(ctx context.Context, latest uint64) error {
+	log := logger.Logger.With(zap.String("gmailUser", w.cfg.User))
+
+	var messageIDs []string
+	seen := map[string]bool{}
+	next := w.historyID
+	pageToken := ""
+	for {
+		query := url.Values{
+			"startHistoryId": {strconv.FormatUint(w.historyID, 10)},
+			"historyTypes":   {"messageAdded"},
+		}
+		if len(w.cfg.LabelIDs) == 1 {
+			query.Set("labelId", w.cfg.LabelIDs[0])
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var page struct {
+			History []struct {
+				MessagesAdded []struct {
+					Message struct {
+						ID       string   `json:"id"`
+						LabelIDs []string `json:"labelIds"`
+					} `json:"message"`
+				} `json:"messagesAdded"`
+			} `json:"history"`
+			HistoryID     string `json:"historyId"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err := w.gmailCall(ctx, http.MethodGet, "/history?"+query.Encode(), nil, &page)
+		if isNotFound(err) {
+			// 履歴IDが古すぎる（約1週間より前）場合は通知の履歴IDから取り込み直す
+			log.Warn("Gmailの履歴IDが古いため通知の履歴IDから再開します",
+				zap.Uint64("historyId", w.historyID),
+				zap.Uint64("latest", latest))
+			w.historyID = latest
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, history := range page.History {
+			for _, added := range history.MessagesAdded {
+				if id := added.Message.ID; !seen[id] && w.watched(added.Message.LabelIDs) {
+					seen[id] = true
+					messageIDs = append(messageIDs, id)
+				}
+			}
+		}
+		if id, err := strconv.ParseUint(page.HistoryID, 10, 64); err == nil && id > next {
+			next = id
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+
+	for _, id := range messageIDs {
+		raw, err := w.fetchRaw(ctx, id)
+		if isNotFound(err) {
+			// 取り込む前に削除されたメールは飛ばす
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch gmail message %s: %v", id, err)
+		}
+		messageID := "gmail-" + id
+		if err := w.ingest(ctx, messageID, raw); err != nil {
+			w.attempts[id]++
+			if w.attempts[id] < maxIngestAttempts {
+				return fmt.Errorf("failed to ingest gmail message %s: %v", id, err)
+			}
+			log.Error("Gmailのメールの取り込みを諦めました",
+				zap.String("messageId", messageID),
+				zap.Int("attempts", w.attempts[id]),
+				zap.Error(err))
+			delete(w.attempts, id)
+			continue
+		}
+		delete(w.attempts, id)
+		log.Info("Gmailのメールを取り込みました", zap.String("messageId", messageID), zap.Int("size", len(raw)))
+	}
+
+	w.historyID = max(next, latest)
+	return nil
+}
+
+// watched はメールが監視するラベルのいずれかを持つかを返します
+func (w *Watcher) watched(labelIDs []string) bool {
+	for _, label := range labelIDs {
+		for _, want := range w.cfg.LabelIDs {
+			if label == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fetchRaw はメールの生データ（RFC822）を取得します
+func (w *Watcher) fetchRaw(ctx context.Context, id string) ([]byte, error) {
+	var message struct {
+		Raw string `json:"raw"`
+	}
+	if err := w.gmailCall(ctx, http.MethodGet, "/messages/"+url.PathEscape(id)+"?format=raw", nil, &message); err != nil {
+		return nil, err
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(message.Raw, "="))
+}
+
+// apiError はAPIがエラーのステータスを返したことを表します
+type apiError struct {
+	status int
+	body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("api returned status %d: %s", e.status, e.body)
+}
+
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*apiError)
+	return ok && apiErr.status == http.StatusNotFound
+}
+
+func (w *Watcher) gmailCall(ctx context.Context, method, path string, body, result interface{}) error {
+	token, err := w.gmailToken(ctx)
+	if err != nil {
+		return err
+	}
+	return w.call(ctx, method, gmailBaseURL+url.PathEscape(w.cfg.User)+path, token, body, result)
+}
+
+func (w *Watcher) pubsubCall(ctx context.Context, action string, body, result interface{}) error {
+	token, err := secrets.AccessToken()
+	if err != nil {
+		return err
+	}
+	return w.call(ctx, http.MethodPost, pubsubBaseURL+w.cfg.Subscription+action, token, body, result)
+}
+
+func (w *Watcher) call(ctx context.Context, method, endpoint, token string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &apiError{status: resp.StatusCode, body: string(respBody)}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// gmailToken はリフレッシュトークンからGmail APIのアクセストークンを取得します（期限の1分前まで再利用）
+func (w *Watcher) gmailToken(ctx context.Context) (string, error) {
+	w.tokenMu.Lock()
+	defer w.tokenMu.Unlock()
+
+	if w.token != "" && time.Now().Add(time.Minute).Before(w.tokenExpiry) {
+		return w.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {secrets.Get("GMAIL_CLIENT_ID")},
+		"client_secret": {secrets.Get("GMAIL_CLIENT_SECRET")},
+		"refresh_token": {secrets.Get("GMAIL_REFRESH_TOKEN")},
+	}
+	if form.Get("refresh_token") == "" {
+		return "", fmt.Errorf("GMAIL_REFRESH_TOKEN is not set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oauthTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh gmail token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode gmail token: %v", err)
+	}
+
+	w.token = result.AccessToken
+	w.tokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	return w.token, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"go.uber.org/zap"
+	"mailconvertor/logger"
+	"mailconvertor/models"
+	"mailconvertor/storage"
+)
+
+// rawArchive は受信したメールの生データ（RFC822）の保存先。nil の場合は保存しません
+var rawArchive *storage.Uploader
+
+// ConfigureRawArchive は受信したメールの生データを保存するCloud Storageの保存先を設定します。
+// 保存したメールはパースの不具合の調査や再処理に使います
+func ConfigureRawArchive(uploader *storage.Uploader) {
+	rawArchive = uploader
+}
+
+// rawObjectName はメッセージの生データのオブジェクト名を返します
+func rawObjectName(messageID string) string {
+	return sanitizeObjectName(messageID) + ".eml"
+}
+
+// parseBuffered はメールの生データを保存してからパースし、保存先のURIを設定します
+func parseBuffered(ctx context.Context, messageID string, raw []byte) (*models.EmailData, error) {
+	recordReceived()
+	uri := archiveRaw(ctx, messageID, bytes.NewReader(raw))
+	emailData, err := ParseEmail(raw)
+	if err != nil {
+		recordParseFailure(err, messageID)
+		return nil, err
+	}
+	emailData.RawGCSURI = uri
+	recordIfEmpty(emailData, messageID)
+	normalizeBody(emailData, messageID)
+	return emailData, nil
+}
+
+// parseStreamed はメールの生データを保存しながらストリーミングでパースし、保存先のURIを設定します。
+// パースに失敗した場合も残りを読み切って生データ全体を保存します
+func parseStreamed(ctx context.Context, messageID string, body io.Reader) (*models.EmailData, error) {
+	recordReceived()
+	if rawArchive == nil {
+		emailData, err := ParseEmailStream(ctx, messageID, body)
+		if err != nil {
+			recordParseFailure(err, messageID)
+			return nil, err
+		}
+		recordIfEmpty(emailData, messageID)
+		normalizeBody(emailData, messageID)
+		return emailData, nil
+	}
+
+	reader, writer := io.Pipe()
+	result := make(chan string, 1)
+	go func() {
+		uri := archiveRaw(ctx, messageID, reader)
+		// 保存が途中で失敗した場合も、パース側の書き込みが止まらないようにする
+		reader.Close()
+		result <- uri
+	}()
+
+	tee := io.TeeReader(body, &bestEffortWriter{w: writer})
+	emailData, err := ParseEmailStream(ctx, messageID, tee)
+	io.Copy(io.Discard, tee)
+	writer.Close()
+	uri := <-result
+
+	if err != nil {
+		recordParseFailure(err, messageID)
+		return nil, err
+	}
+	emailData.RawGCSURI = uri
+	recordIfEmpty(emailData, messageID)
+	normalizeBody(emailData, messageID)
+	return emailData, nil
+}
+
+// archiveRaw は生データを保存し、URIを返します。保存先が未設定の場合・保存に失敗した場合は空文字を返します（受信は止めない）
+func archiveRaw(ctx context.Context, messageID string, raw io.Reader) string {
+	if rawArchive == nil {
+		return ""
+	}
+	uri, err := rawArchive.Upload(ctx, rawObjectName(messageID), "message/rfc822", raw)
+	if err != nil {
+		logger.Logger.Error("メールの生データの保存に失敗しました",
+			zap.String("messageId", messageID),
+			zap.Error(err))
+		return ""
+	}
+	logger.Logger.Debug("メールの生データを保存しました",
+		zap.String("messageId", messageID),
+		zap.String("uri", uri))
+	return uri
+}
+
+// bestEffortWriter は最初の書き込みエラーの後は書き込みを捨て、常に成功を返す Writer です
+type bestEffortWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (b *bestEffortWriter) Write(p []byte) (int, error) {
+	if b.err == nil {
+		_, b.err = b.w.Write(p)
+	}
+	return len(p), nil
+}
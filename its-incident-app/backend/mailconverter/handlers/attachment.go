@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jhillyerd/enmime"
+	"go.uber.org/zap"
+	"mailconvertor/logger"
+	"mailconvertor/models"
+	"mailconvertor/storage"
+)
+
+const (
+	// maxAttachmentText は添付ファイル1件あたりに含める内容の上限（バイト）
+	maxAttachmentText = 32 * 1024
+	// maxAttachmentTextTotal はメール1通あたりに含める添付ファイルの内容の合計の上限（バイト）
+	maxAttachmentTextTotal = 64 * 1024
+)
+
+// attachmentStore は添付ファイルの内容の保存先。nil の場合は保存しません
+var attachmentStore *storage.Uploader
+
+var (
+	// maxMessageSize は受け付けるメールの上限（バイト）。超える場合は 413 を返します
+	maxMessageSize int64 = 64 * 1024 * 1024
+	// streamingThreshold はメモリに読み込んでパースするメールの上限（バイト）。超える場合はストリーミングでパースします
+	streamingThreshold int64 = 8 * 1024 * 1024
+)
+
+// ConfigureLimits は受け付けるメールの上限と、ストリーミングでパースするしきい値を設定します
+func ConfigureLimits(maxSize, threshold int64) {
+	maxMessageSize = maxSize
+	streamingThreshold = min(threshold, maxSize)
+}
+
+// ConfigureAttachmentStore は添付ファイルの内容を保存するCloud Storageの保存先を設定します
+func ConfigureAttachmentStore(uploader *storage.Uploader) {
+	attachmentStore = uploader
+}
+
+// textAttachmentTypes はテキストとして内容を取り出すContent-Type（text/* 以外）
+var textAttachmentTypes = map[string]bool{
+	"application/json":     true,
+	"application/x-ndjson": true,
+	"application/xml":      true,
+	"application/yaml":     true,
+	"application/x-yaml":   true,
+	"application/x-sh":     true,
+}
+
+// textAttachmentExtensions はContent-Typeが application/octet-stream などでもテキストとして扱う拡張子
+var textAttachmentExtensions = map[string]bool{
+	".log": true, ".txt": true, ".csv": true, ".tsv": true, ".json": true,
+	".xml": true, ".yaml": true, ".yml": true, ".out": true, ".err": true,
+}
+
+// extractAttachments は添付ファイルの情報を取り出します。
+// 監視システムはアラートの詳細をログファイルとして添付することが多いため、テキスト形式の添付ファイルは内容も上限まで含めます
+func extractAttachments(parts []*enmime.Part) []models.Attachment {
+	attachments := make([]models.Attachment, 0, len(parts))
+	remaining := maxAttachmentTextTotal
+	for _, part := range parts {
+		attachment := models.Attachment{
+			FileName:    part.FileName,
+			ContentType: part.ContentType,
+			Size:        len(part.Content),
+			ContentID:   part.ContentID,
+			Data:        part.Content,
+		}
+
+		if remaining > 0 && isTextAttachment(part) && utf8.Valid(part.Content) {
+			limit := min(maxAttachmentText, remaining)
+			attachment.Content, attachment.Truncated = truncateUTF8(part.Content, limit)
+			remaining -= len(attachment.Content)
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+// uploadAttachments は添付ファイルの内容を メッセージID/連番-ファイル名 としてCloud Storageに保存し、URIを設定します。
+// 保存に失敗した添付ファイルはURIなしで送信します（メールの受信は止めない）
+func uploadAttachments(ctx context.Context, messageID string, attachments []models.Attachment) {
+	for i := range attachments {
+		attachment := &attachments[i]
+		data := attachment.Data
+		attachment.Data = nil
+		if attachmentStore == nil {
+			continue
+		}
+
+		uri, err := attachmentStore.Upload(ctx, attachmentObjectName(messageID, i, attachment.FileName), attachment.ContentType, bytes.NewReader(data))
+		if err != nil {
+			logger.Logger.Error("添付ファイルの保存に失敗しました",
+				zap.String("messageId", messageID),
+				zap.String("fileName", attachment.FileName),
+				zap.Error(err))
+			continue
+		}
+		attachment.GCSURI = uri
+	}
+}
+
+// attachmentObjectName はメッセージの index 番目（0始まり）の添付ファイルのオブジェクト名を返します
+func attachmentObjectName(messageID string, index int, fileName string) string {
+	return fmt.Sprintf("%s/%d-%s", sanitizeObjectName(messageID), index+1, sanitizeObjectName(filepath.Base(fileName)))
+}
+
+// sanitizeObjectName はオブジェクト名に使えない文字（区切り文字・制御文字など）を _ に置き換えます
+func sanitizeObjectName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '#' || r == '?' || r == '<' || r == '>' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "attachment"
+	}
+	return name
+}
+
+// isTextAttachment はContent-Typeまたは拡張子からテキスト形式の添付ファイルかを判定します
+func isTextAttachment(part *enmime.Part) bool {
+	return isTextContent(part.ContentType, part.FileName)
+}
+
+// isTextContent はContent-Typeまたはファイル名の拡張子がテキスト形式かを判定します
+func isTextContent(contentType, fileName string) bool {
+	contentType = strings.ToLower(contentType)
+	if strings.HasPrefix(contentType, "text/") || textAttachmentTypes[contentType] {
+		return true
+	}
+	return textAttachmentExtensions[strings.ToLower(filepath.Ext(fileName))]
+}
+
+// truncateUTF8 は文字の途中で切れないように content を limit バイト以内に切り詰めます
+func truncateUTF8(content []byte, limit int) (string, bool) {
+	if len(content) <= limit {
+		return string(content), false
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(content[cut]) {
+		cut--
+	}
+	return string(content[:cut]), true
+}
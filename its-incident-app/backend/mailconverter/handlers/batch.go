@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"mailconvertor/logger"
+	"mailconvertor/models"
+	"mailconvertor/msgid"
+	"mailconvertor/storage"
+)
+
+// outcomeError は処理に失敗したメールの結果
+const outcomeError = "error"
+
+// BatchConfig は /receive/batch の設定です
+type BatchConfig struct {
+	Concurrency int   // 同時に処理するメールの数
+	MaxItems    int   // 1回のリクエストで受け付けるメールの数の上限
+	MaxBytes    int64 // リクエストの本文の上限
+}
+
+var batchConfig = BatchConfig{Concurrency: 4, MaxItems: 1000, MaxBytes: 100 * 1024 * 1024}
+
+// ConfigureBatch は /receive/batch の同時実行数と上限を設定します
+func ConfigureBatch(cfg BatchConfig) {
+	batchConfig = cfg
+}
+
+// batchRequest は /receive/batch のリクエストです。messages と manifest のどちらか一方を指定します
+type batchRequest struct {
+	Messages []batchMessage `json:"messages"`
+	// Manifest は取り込むメール（.eml）の gs:// URIを1行に1つ並べたオブジェクトのURI。
+	// URIの後に空白で区切ってメッセージIDを指定できます（空行と # で始まる行は無視）
+	Manifest string `json:"manifest"`
+}
+
+// batchMessage はリクエストに含めるメールです
+type batchMessage struct {
+	MessageID string `json:"message_id"` // 省略した場合は バッチID-連番
+	Raw       []byte `json:"raw"`        // RFC822の生データ（Base64）
+}
+
+// batchItem は処理するメールです。source が空でない場合は Cloud Storage から読み取ります
+type batchItem struct {
+	messageID string
+	raw       []byte
+	source    string
+}
+
+// HandleBatchReceive は過去のメールボックスの移行用に複数のメールを受け付け、同時実行数を制限して処理します。
+// メールごとの結果を返し、一部のメールの失敗でリクエスト全体を失敗にはしません
+func (h *EmailHandler) HandleBatchReceive(c *gin.Context) {
+	log := logger.Logger
+	batchID := msgid.New()
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, batchConfig.MaxBytes)
+	var req batchRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Warn("バッチのリクエストが上限を超えています", zap.String("batchId", batchID), zap.Int64("limit", maxBytesErr.Limit))
+			err = fmt.Errorf("request body exceeds the maximum size of %d bytes", maxBytesErr.Limit)
+			c.JSON(http.StatusRequestEntityTooLarge, errorResponse(http.StatusRequestEntityTooLarge, models.ErrorOversize, "Batch too large", batchID, err))
+			return
+		}
+		log.Warn("バッチのリクエストのパースに失敗しました", zap.String("batchId", batchID), zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, models.ErrorInvalidRequest, "Invalid batch request", batchID, err))
+		return
+	}
+
+	items, err := batchItems(c.Request.Context(), batchID, &req)
+	if err != nil {
+		log.Warn("バッチのリクエストが不正です", zap.String("batchId", batchID), zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, models.ErrorInvalidRequest, "Invalid batch request", batchID, err))
+		return
+	}
+
+	log.Info("バッチの処理を開始します",
+		zap.String("batchId", batchID),
+		zap.Int("items", len(items)),
+		zap.String("manifest", req.Manifest))
+	start := time.Now()
+	results := h.processBatch(c.Request.Context(), items)
+
+	response := models.BatchResponse{
+		Status:    "success",
+		Code:      http.StatusOK,
+		TraceID:   batchID,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Total:     len(results),
+		Results:   results,
+	}
+	for _, result := range results {
+		if result.Status == outcomeError {
+			response.Failed++
+		}
+	}
+	if response.Failed > 0 {
+		response.Status = "partial"
+	}
+
+	log.Info("バッチの処理が完了しました",
+		zap.String("batchId", batchID),
+		zap.Int("total", response.Total),
+		zap.Int("failed", response.Failed),
+		zap.Duration("elapsed", time.Since(start)))
+	c.JSON(http.StatusOK, response)
+}
+
+// batchItems はリクエストから処理するメールの一覧を作成します
+func batchItems(ctx context.Context, batchID string, req *batchRequest) ([]batchItem, error) {
+	if (len(req.Messages) > 0) == (req.Manifest != "") {
+		return nil, fmt.Errorf("specify either messages or manifest")
+	}
+
+	var items []batchItem
+	if req.Manifest != "" {
+		var err error
+		if items, err = readManifest(ctx, req.Manifest); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, message := range req.Messages {
+			items = append(items, batchItem{messageID: message.MessageID, raw: message.Raw})
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no messages in the batch")
+	}
+	if len(items) > batchConfig.MaxItems {
+		return nil, fmt.Errorf("batch contains %d messages, exceeding the maximum of %d", len(items), batchConfig.MaxItems)
+	}
+	for i := range items {
+		if items[i].messageID == "" {
+			items[i].messageID = batchID + "-" + strconv.Itoa(i)
+		} else if !msgid.Valid(items[i].messageID) {
+			return nil, fmt.Errorf("invalid message_id at index %d", i)
+		}
+	}
+	return items, nil
+}
+
+// readManifest はマニフェストのオブジェクトを読み取り、メールのURIの一覧を返します
+func readManifest(ctx context.Context, uri string) ([]batchItem, error) {
+	bucket, object, err := storage.ParseGCSURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	reader, _, err := storage.NewUploader(bucket, "").Open(ctx, object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	defer reader.Close()
+
+	var items []batchItem
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if _, _, err := storage.ParseGCSURI(fields[0]); err != nil {
+			return nil, fmt.Errorf("manifest line %q: %v", line, err)
+		}
+		item := batchItem{source: fields[0]}
+		if len(fields) > 1 {
+			item.messageID = fields[1]
+		}
+		items = append(items, item)
+		if len(items) > batchConfig.MaxItems {
+			return nil, fmt.Errorf("manifest contains more than %d messages", batchConfig.MaxItems)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	return items, nil
+}
+
+// processBatch は Concurrency 件ずつ同時にメールを処理し、リクエストの順に結果を返します
+func (h *EmailHandler) processBatch(ctx context.Context, items []batchItem) []models.BatchResult {
+	results := make([]models.BatchResult, len(items))
+	readers := &bucketReaders{uploaders: map[string]*storage.Uploader{}}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(batchConfig.Concurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = h.processBatchItem(ctx, readers, i, items[i])
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// processBatchItem は1通のメールをパースして外部APIに送信します
+func (h *EmailHandler) processBatchItem(ctx context.Context, readers *bucketReaders, index int, item batchItem) models.BatchResult {
+	result := models.BatchResult{Index: index, MessageID: item.messageID, Source: item.source}
+	fail := func(errType string, err error) models.BatchResult {
+		logger.Logger.Warn("バッチのメールの処理に失敗しました",
+			zap.String("messageId", item.messageID),
+			zap.String("source", item.source),
+			zap.String("errorType", errType),
+			zap.Error(err))
+		result.Status, result.Error, result.ErrorType = outcomeError, err.Error(), errType
+		return result
+	}
+	if ctx.Err() != nil {
+		return fail(models.ErrorUnavailable, ctx.Err())
+	}
+
+	var emailData *models.EmailData
+	streamed := false
+	if item.source == "" {
+		if int64(len(item.raw)) > maxMessageSize {
+			recordFailure(failureTooBig, item.messageID)
+			return fail(models.ErrorOversize, fmt.Errorf("message exceeds the maximum size of %d bytes", maxMessageSize))
+		}
+		if len(item.raw) == 0 {
+			return fail(models.ErrorEmptyBody, fmt.Errorf("message is empty"))
+		}
+		data, err := parseBuffered(ctx, item.messageID, item.raw)
+		if err != nil {
+			return fail(models.ErrorParse, err)
+		}
+		emailData = data
+	} else {
+		data, uploaded, errType, err := readers.parse(ctx, item)
+		if err != nil {
+			return fail(errType, err)
+		}
+		emailData, streamed = data, uploaded
+	}
+
+	outcome, err := h.deliverEmail(ctx, emailData, item.messageID, streamed)
+	if err != nil {
+		return fail(models.ErrorUpstream, err)
+	}
+	result.Status = outcome
+	return result
+}
+
+// bucketReaders はマニフェストのメールをバケットごとのクライアントで読み取ります
+type bucketReaders struct {
+	mu        sync.Mutex
+	uploaders map[string]*storage.Uploader
+}
+
+// parse はCloud Storageのメールを読み取ってパースします。しきい値を超える場合はストリーミングでパースし、uploaded を true で返します。
+// 失敗した場合はエラーの種類（models.Error*）を返します
+func (r *bucketReaders) parse(ctx context.Context, item batchItem) (emailData *models.EmailData, uploaded bool, errType string, err error) {
+	bucket, object, err := storage.ParseGCSURI(item.source)
+	if err != nil {
+		return nil, false, models.ErrorInvalidRequest, err
+	}
+	r.mu.Lock()
+	uploader, ok := r.uploaders[bucket]
+	if !ok {
+		uploader = storage.NewUploader(bucket, "")
+		r.uploaders[bucket] = uploader
+	}
+	r.mu.Unlock()
+
+	reader, size, err := uploader.Open(ctx, object)
+	if err != nil {
+		return nil, false, models.ErrorUnavailable, err
+	}
+	defer reader.Close()
+
+	if size > maxMessageSize {
+		recordFailure(failureTooBig, item.messageID)
+		return nil, false, models.ErrorOversize, fmt.Errorf("message exceeds the maximum size of %d bytes", maxMessageSize)
+	}
+	if size == 0 {
+		return nil, false, models.ErrorEmptyBody, fmt.Errorf("message is empty")
+	}
+	if size > 0 && size <= streamingThreshold {
+		raw, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, false, models.ErrorReadFailed, err
+		}
+		if emailData, err = parseBuffered(ctx, item.messageID, raw); err != nil {
+			return nil, false, models.ErrorParse, err
+		}
+		return emailData, false, "", nil
+	}
+	if emailData, err = parseStreamed(ctx, item.messageID, io.LimitReader(reader, maxMessageSize)); err != nil {
+		return nil, false, models.ErrorParse, err
+	}
+	return emailData, true, "", nil
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+	"mailconvertor/ics"
+	"mailconvertor/logger"
+	"mailconvertor/models"
+)
+
+// maxCalendarSize はカレンダー招待として読み取るパートの上限（バイト）。超えた場合は予定を取り出しません
+const maxCalendarSize = 256 * 1024
+
+// isCalendarPart はContent-Typeまたは拡張子からカレンダー招待のパートかを判定します
+func isCalendarPart(contentType, fileName string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return mediaType == "text/calendar" || mediaType == "application/ics" ||
+		strings.EqualFold(path.Ext(fileName), ".ics")
+}
+
+// applyCalendar はカレンダー招待の最初の予定（メンテナンスの告知など）を emailData に設定し、設定できた場合は true を返します。
+// パースできない招待は無視し、本文のみで送信します
+func applyCalendar(emailData *models.EmailData, data []byte, charset string) bool {
+	if len(data) > maxCalendarSize {
+		return false
+	}
+	if decoded, err := charsetReader(charset, bytes.NewReader(data)); err == nil {
+		if text, err := io.ReadAll(decoded); err == nil {
+			data = text
+		}
+	}
+
+	calendar, err := ics.Parse(data)
+	if err != nil {
+		logger.Logger.Warn("カレンダー招待のパースに失敗しました",
+			zap.String("messageId", emailData.OriginalMessageID),
+			zap.Error(err))
+		return false
+	}
+	if len(calendar.Events) == 0 {
+		return false
+	}
+
+	event := calendar.Events[0]
+	emailData.CalendarMethod = calendar.Method
+	if event.Status == "CANCELLED" {
+		emailData.CalendarMethod = "CANCEL"
+	}
+	emailData.CalendarUID = event.UID
+	emailData.CalendarSummary = event.Summary
+	emailData.CalendarStart = &event.Start
+	emailData.CalendarEnd = &event.End
+
+	logger.Logger.Info("カレンダー招待の予定を取り出しました",
+		zap.String("messageId", emailData.OriginalMessageID),
+		zap.String("method", emailData.CalendarMethod),
+		zap.String("summary", event.Summary),
+		zap.Time("start", event.Start),
+		zap.Time("end", event.End),
+		zap.Int("events", len(calendar.Events)))
+	return true
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"mailconvertor/dedup"
+	"mailconvertor/logger"
+	"mailconvertor/models"
+)
+
+// dedupStore は処理済みの Message-ID の記録先。nil の場合は重複を確認しません
+var dedupStore *dedup.Store
+
+// ConfigureDedup は Message-ID による重複排除の記録先を設定します
+func ConfigureDedup(store *dedup.Store) {
+	dedupStore = store
+}
+
+// claimMessage は Message-ID が処理済みかを確認し、未処理なら記録します。
+// 記録できた場合 claimed は true です。記録先の障害時は重複なしとして処理を続けます（受信は止めない）
+func claimMessage(ctx context.Context, emailData *models.EmailData, messageID string) (duplicate bool, firstMessageID string, claimed bool) {
+	if dedupStore == nil || emailData.OriginalMessageID == "" {
+		return false, "", false
+	}
+
+	duplicate, firstMessageID, err := dedupStore.Claim(ctx, emailData.OriginalMessageID, messageID)
+	if err != nil {
+		logger.Logger.Warn("Message-IDの重複確認に失敗したため処理を続けます",
+			zap.String("messageId", messageID),
+			zap.String("originalMessageId", emailData.OriginalMessageID),
+			zap.Error(err))
+		return false, "", false
+	}
+	if duplicate {
+		logger.Logger.Info("処理済みのMessage-IDのため送信をスキップします",
+			zap.String("messageId", messageID),
+			zap.String("originalMessageId", emailData.OriginalMessageID),
+			zap.String("duplicateOf", firstMessageID))
+		return true, firstMessageID, false
+	}
+	return false, "", true
+}
+
+// releaseMessage は送信に失敗したメールの記録を取り消し、再送で処理できるようにします
+func releaseMessage(ctx context.Context, emailData *models.EmailData) {
+	if err := dedupStore.Release(ctx, emailData.OriginalMessageID); err != nil {
+		logger.Logger.Warn("Message-IDの記録の取り消しに失敗しました",
+			zap.String("originalMessageId", emailData.OriginalMessageID),
+			zap.Error(err))
+	}
+}
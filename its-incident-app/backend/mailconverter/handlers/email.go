@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,6 +17,9 @@ import (
 	"go.uber.org/zap"
 	"mailconvertor/logger"
 	"mailconvertor/models"
+	"mailconvertor/msgid"
+	"mailconvertor/serviceauth"
+	"shared/idtoken"
 )
 
 func ParseEmail(rawEmailData []byte) (*models.EmailData, error) {
@@ -38,11 +44,34 @@ func ParseEmail(rawEmailData []byte) (*models.EmailData, error) {
 		ContentTransferEncoding: env.GetHeader("Content-Transfer-Encoding"),
 		CC:                      env.GetHeader("CC"),
 		Body:                    env.Text,
+		InReplyTo:               env.GetHeader("In-Reply-To"),
+		References:              env.GetHeader("References"),
 	}
 
 	if len(env.Attachments) > 0 {
 		emailData.FileName = env.Attachments[0].FileName
 	}
+	// インライン画像（グラフのスクリーンショットなど）は添付ファイルと一緒に保存し、HTML本文に埋め込む
+	inlineAttachments, images := extractInlineImages(env)
+	emailData.Attachments = append(extractAttachments(env.Attachments), inlineAttachments...)
+	emailData.HTMLBody = rewriteInlineImages(env.HTML, images)
+
+	// メンテナンスの告知などのカレンダー招待は、本文・添付ファイルのどちらにあっても予定を取り出す
+	if env.Root != nil {
+		calendars := env.Root.BreadthMatchAll(func(p *enmime.Part) bool {
+			return isCalendarPart(p.ContentType, p.FileName)
+		})
+		for _, part := range calendars {
+			if applyCalendar(emailData, part.Content, "") {
+				break
+			}
+		}
+	}
+
+	// 生データ全体はCloud Storageに保存し（RawGCSURI）、後続のサービスにはヘッダーのみを渡す
+	if rawHeader, err := readRawHeader(bufio.NewReader(bytes.NewReader(rawEmailData))); err == nil {
+		emailData.RawHeader = rawHeader
+	}
 
 	log.Debug("メールのパースが完了しました",
 		zap.String("messageId", emailData.OriginalMessageID),
@@ -53,81 +82,272 @@ func ParseEmail(rawEmailData []byte) (*models.EmailData, error) {
 	return emailData, nil
 }
 
-func createResponse(status string, code int, message string, traceID string, err error) models.APIResponse {
+func createResponse(status string, code int, message string, traceID string) models.APIResponse {
 	timestamp := time.Now().UTC().Format(time.RFC3339)
 
-	response := models.APIResponse{
+	return models.APIResponse{
 		Status:    status,
 		Code:      code,
 		Message:   message,
 		TraceID:   traceID,
 		Timestamp: timestamp,
 	}
+}
 
-	if err != nil {
-		errType := "unknown_error"
-		switch {
-		case code == http.StatusBadRequest:
-			errType = "invalid_request"
-		case code == http.StatusInternalServerError:
-			errType = "internal_error"
-		}
+// retryableErrors は時間をおいて同じメールを再送すれば成功し得るエラーの種類
+var retryableErrors = map[string]bool{
+	models.ErrorReadFailed:  true,
+	models.ErrorUpstream:    true,
+	models.ErrorUnavailable: true,
+	models.ErrorInternal:    true,
+}
 
-		response.Error = &models.ErrorInfo{
-			Type:    errType,
-			Message: err.Error(),
-			Detail:  fmt.Sprintf("%+v", err),
-		}
+// errorResponse は errType（models.Error*）のエラーのレスポンスを作成します。
+// 送信先が 4xx（429 を除く）で拒否した場合など、再送しても結果が変わらないエラーは Retryable を false にします
+func errorResponse(code int, errType string, message string, traceID string, err error) models.APIResponse {
+	response := createResponse("error", code, message, traceID)
+	response.Error = &models.ErrorInfo{
+		Type:      errType,
+		Retryable: retryableErrors[errType] && isRetryable(err),
+		Message:   err.Error(),
+		Detail:    fmt.Sprintf("%+v", err),
 	}
-
 	return response
 }
 
-func HandleEmailReceive(c *gin.Context) {
+// EmailConfig はメールデータの送信の設定です
+type EmailConfig struct {
+	AutopilotURL   string        // 送信先のルールに一致しないメールの送信先
+	ForwardTimeout time.Duration // 送信先への1回のリクエストの上限
+}
+
+// EmailHandler はメールを受け付けてパースし、送信先に送信します。
+// 送信に使う http.Client は作成時に1つだけ作り、リクエストごとに接続を再利用します
+type EmailHandler struct {
+	cfg     EmailConfig
+	client  *http.Client
+	queue   *ReceiveQueue   // nil の場合は /receive で同期的に処理
+	retrier *ForwardRetrier // nil の場合は送信に失敗したメールをエラーとして返す
+}
+
+// NewEmailHandler は main で読み込んだ設定でメールの受信・送信のハンドラーを作成します
+func NewEmailHandler(cfg EmailConfig) *EmailHandler {
+	return &EmailHandler{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.ForwardTimeout},
+	}
+}
+
+// SetReceiveQueue は /receive で使う非同期処理の待ち行列を設定します（nil の場合は同期的に処理）
+func (h *EmailHandler) SetReceiveQueue(queue *ReceiveQueue) {
+	h.queue = queue
+}
+
+// SetForwardRetrier は送信に失敗したメールの保存先を設定します（nil の場合は保存しない）
+func (h *EmailHandler) SetForwardRetrier(retrier *ForwardRetrier) {
+	h.retrier = retrier
+}
+
+func (h *EmailHandler) HandleEmailReceive(c *gin.Context) {
 	// ロガーの取得
 	log := logger.Logger
 
 	messageID := c.GetHeader("X-Message-ID")
 	if messageID == "" {
-		messageID = fmt.Sprintf("gen-%d", time.Now().UnixNano())
+		messageID = msgid.New()
 		log.Info("メッセージIDを生成しました", zap.String("messageId", messageID))
+	} else if !msgid.Valid(messageID) {
+		// メッセージIDはオブジェクト名・Datastoreのキー・ログにそのまま使うため、形式が不正なものは受け付けない
+		log.Warn("X-Message-IDの形式が不正です", zap.String("messageId", messageID))
+		err := fmt.Errorf("X-Message-ID must be at most %d characters of letters, digits and ._:@+=-", msgid.MaxLength)
+		response := errorResponse(http.StatusBadRequest, models.ErrorInvalidMessageID, "Invalid X-Message-ID", "", err)
+		c.JSON(http.StatusBadRequest, response)
+		return
 	}
 
-	rawEmailData, err := io.ReadAll(c.Request.Body)
+	if c.Request.ContentLength > maxMessageSize {
+		rejectTooLarge(c, messageID, c.Request.ContentLength)
+		return
+	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxMessageSize)
+	// 一時保存先がある場合はパース・送信を待たずに 202 を返す（上流のメールゲートウェイのタイムアウト対策）
+	if h.queue != nil {
+		h.acceptEmail(c, messageID, body)
+		return
+	}
+	h.receiveEmail(c, messageID, body, c.Request.ContentLength)
+}
+
+// receiveEmail はメールの生データ（RFC822）を読み取ってパースし、外部APIに送信してレスポンスを返します。
+// body のサイズは呼び出し側で maxMessageSize までに制限してください（size は不明な場合 -1）
+func (h *EmailHandler) receiveEmail(c *gin.Context, messageID string, body io.Reader, size int64) {
+	log := logger.Logger
+
+	// しきい値以下のメールはメモリに読み込んでパースし、超えるメールはストリーミングでパースする
+	head, err := io.ReadAll(io.LimitReader(body, streamingThreshold+1))
 	if err != nil {
+		if isTooLarge(err) {
+			rejectTooLarge(c, messageID, -1)
+			return
+		}
 		log.Error("リクエストボディの読み取りに失敗しました", zap.Error(err))
-		response := createResponse("error", http.StatusBadRequest, "Failed to read request body", messageID, err)
+		response := errorResponse(http.StatusBadRequest, models.ErrorReadFailed, "Failed to read request body", messageID, err)
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+	if len(bytes.TrimSpace(head)) == 0 {
+		log.Warn("メールの生データが空です", zap.String("messageId", messageID))
+		err := fmt.Errorf("request body is empty")
+		response := errorResponse(http.StatusBadRequest, models.ErrorEmptyBody, "Email is empty", messageID, err)
 		c.JSON(http.StatusBadRequest, response)
 		return
 	}
 
-	log.Debug("メールデータを受信しました",
-		zap.String("messageId", messageID),
-		zap.Int("size", len(rawEmailData)),
-	)
-
-	emailData, err := ParseEmail(rawEmailData)
+	var emailData *models.EmailData
+	if int64(len(head)) <= streamingThreshold {
+		log.Debug("メールデータを受信しました",
+			zap.String("messageId", messageID),
+			zap.Int("size", len(head)),
+		)
+		emailData, err = parseBuffered(c.Request.Context(), messageID, head)
+	} else {
+		log.Info("大きなメールのためストリーミングでパースします",
+			zap.String("messageId", messageID),
+			zap.Int64("contentLength", size),
+		)
+		emailData, err = parseStreamed(c.Request.Context(), messageID, io.MultiReader(bytes.NewReader(head), body))
+	}
 	if err != nil {
+		if isTooLarge(err) {
+			rejectTooLarge(c, messageID, -1)
+			return
+		}
 		log.Error("メールのパースに失敗しました", zap.Error(err))
-		response := createResponse("error", http.StatusInternalServerError, "Failed to parse email", messageID, err)
+		response := errorResponse(http.StatusInternalServerError, models.ErrorParse, "Failed to parse email", messageID, err)
 		c.JSON(http.StatusInternalServerError, response)
 		return
 	}
 
+	// 許可されていない送信者のメールはAIの利用枠を使わないよう、autopilotに送信しない
+	if reason := checkSender(emailData, messageID); reason != "" {
+		err := fmt.Errorf("sender %s is not accepted (%s)", senderAddress(emailData.From), reason)
+		response := errorResponse(http.StatusForbidden, models.ErrorUnauthorizedSender, "Sender is not accepted", messageID, err)
+		c.JSON(http.StatusForbidden, response)
+		return
+	}
+
+	duplicate, firstMessageID, claimed := claimMessage(c.Request.Context(), emailData, messageID)
+	if duplicate {
+		response := createResponse("success", http.StatusOK, "Duplicate email skipped", messageID)
+		response.Duplicate = true
+		response.DuplicateOf = firstMessageID
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// ストリーミングでパースしたメールの添付ファイルはパース中に保存済み
+	if int64(len(head)) <= streamingThreshold {
+		uploadAttachments(c.Request.Context(), messageID, emailData.Attachments)
+	}
 	logEmailData(emailData)
 
-	if err := sendToExternalAPI(emailData, messageID); err != nil {
+	if err := h.sendToExternalAPI(emailData, messageID); err != nil {
 		log.Error("外部APIへの送信に失敗しました", zap.Error(err))
-		response := createResponse("error", http.StatusInternalServerError, "Failed to send to external API", messageID, err)
+		recordForwardFailure(err, messageID)
+		// 送信待ちとして保存できた場合は後で再送するため、受信は成功として扱う
+		if h.deferForward(c.Request.Context(), emailData, messageID, err) {
+			response := createResponse("accepted", http.StatusAccepted, "Email queued for delivery", messageID)
+			c.JSON(http.StatusAccepted, response)
+			return
+		}
+		if claimed {
+			releaseMessage(c.Request.Context(), emailData)
+		}
+		response := errorResponse(http.StatusInternalServerError, models.ErrorUpstream, "Failed to send to external API", messageID, err)
 		c.JSON(http.StatusInternalServerError, response)
 		return
 	}
 
 	log.Info("メール処理が正常に完了しました", zap.String("messageId", messageID))
-	response := createResponse("success", http.StatusOK, "Email processed successfully", messageID, nil)
+	response := createResponse("success", http.StatusOK, "Email processed successfully", messageID)
 	c.JSON(http.StatusOK, response)
 }
 
+// IngestEmail はHTTP以外の経路（Gmailの監視など）で取得したメールの生データをパースし、外部APIに送信します
+func (h *EmailHandler) IngestEmail(ctx context.Context, messageID string, raw []byte) error {
+	if int64(len(raw)) > maxMessageSize {
+		recordFailure(failureTooBig, messageID)
+		return fmt.Errorf("message exceeds the maximum size of %d bytes", maxMessageSize)
+	}
+
+	emailData, err := parseBuffered(ctx, messageID, raw)
+	if err != nil {
+		return err
+	}
+	return h.forwardEmail(ctx, emailData, messageID, false)
+}
+
+// forwardEmail の処理結果
+const (
+	outcomeProcessed = "processed" // 外部APIに送信した
+	outcomeRejected  = "rejected"  // 受け付けない送信者のため送信しなかった
+	outcomeDuplicate = "duplicate" // 処理済みの Message-ID のため送信しなかった
+	outcomeQueued    = "queued"    // 送信に失敗したため送信待ちとして保存した
+)
+
+// forwardEmail はパース済みのメールを外部APIに送信します。受け付けない送信者・処理済みのメールは送信せずに nil を返します。
+// attachmentsUploaded はストリーミングでパースして添付ファイルを保存済みの場合に true を指定します
+func (h *EmailHandler) forwardEmail(ctx context.Context, emailData *models.EmailData, messageID string, attachmentsUploaded bool) error {
+	_, err := h.deliverEmail(ctx, emailData, messageID, attachmentsUploaded)
+	return err
+}
+
+// deliverEmail は forwardEmail と同じ処理で、送信したか・送信しなかった理由（outcome*）を返します
+func (h *EmailHandler) deliverEmail(ctx context.Context, emailData *models.EmailData, messageID string, attachmentsUploaded bool) (string, error) {
+	// 受け付けない送信者のメールは再試行しても結果が変わらないため、エラーにせず読み飛ばす
+	if checkSender(emailData, messageID) != "" {
+		return outcomeRejected, nil
+	}
+	duplicate, _, claimed := claimMessage(ctx, emailData, messageID)
+	if duplicate {
+		return outcomeDuplicate, nil
+	}
+	if !attachmentsUploaded {
+		uploadAttachments(ctx, messageID, emailData.Attachments)
+	}
+	logEmailData(emailData)
+	if err := h.sendToExternalAPI(emailData, messageID); err != nil {
+		recordForwardFailure(err, messageID)
+		if h.deferForward(ctx, emailData, messageID, err) {
+			return outcomeQueued, nil
+		}
+		if claimed {
+			releaseMessage(ctx, emailData)
+		}
+		return "", err
+	}
+	return outcomeProcessed, nil
+}
+
+// rejectTooLarge は上限を超えるメールを 413 で拒否します。size が不明な場合は -1 です
+func rejectTooLarge(c *gin.Context, messageID string, size int64) {
+	recordFailure(failureTooBig, messageID)
+	logger.Logger.Warn("メールのサイズが上限を超えています",
+		zap.String("messageId", messageID),
+		zap.Int64("size", size),
+		zap.Int64("maxMessageSize", maxMessageSize),
+	)
+	err := fmt.Errorf("message exceeds the maximum size of %d bytes", maxMessageSize)
+	response := errorResponse(http.StatusRequestEntityTooLarge, models.ErrorOversize, "Email is too large", messageID, err)
+	c.JSON(http.StatusRequestEntityTooLarge, response)
+}
+
+// isTooLarge はリクエストボディが MaxBytesReader の上限を超えたことによるエラーかを返します
+func isTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr) || strings.Contains(err.Error(), "http: request body too large")
+}
+
 func logEmailData(emailData *models.EmailData) {
 	log := logger.Logger
 
@@ -140,10 +360,11 @@ func logEmailData(emailData *models.EmailData) {
 		zap.String("contentType", emailData.ContentType),
 		zap.Int("bodyLength", len(emailData.Body)),
 		zap.Bool("hasFileName", emailData.FileName != ""),
+		zap.Int("attachments", len(emailData.Attachments)),
 	)
 }
 
-func sendToExternalAPI(emailData *models.EmailData, messageID string) error {
+func (h *EmailHandler) sendToExternalAPI(emailData *models.EmailData, messageID string) error {
 	log := logger.Logger
 
 	payloadBytes, err := json.MarshalIndent(emailData, "", "  ")
@@ -152,17 +373,46 @@ func sendToExternalAPI(emailData *models.EmailData, messageID string) error {
 		return fmt.Errorf("failed to marshal payload: %v", err)
 	}
 
+	route, target := h.routeFor(emailData)
 	log.Info("外部APIにデータを送信します",
 		zap.String("messageId", messageID),
+		zap.String("route", route),
 		zap.String("originalMsgId", emailData.OriginalMessageID),
 		zap.String("from", emailData.From),
 		zap.String("to", emailData.To),
 		zap.String("subject", emailData.Subject),
 		zap.Int("payloadSize", len(payloadBytes)),
 	)
+	if err := h.postToExternalAPI(target, payloadBytes, messageID); err != nil {
+		return err
+	}
+	forwardedTotal.Inc(route)
+	return nil
+}
+
+// apiStatusError は外部APIがエラーのステータスを返したことを表します
+type apiStatusError struct {
+	code int
+}
+
+func (e *apiStatusError) Error() string {
+	return fmt.Sprintf("external API returned error status: %d", e.code)
+}
+
+// isRetryable は送信のエラーが時間をおけば成功し得るもの（接続の失敗・5xx・429）かを返します
+func isRetryable(err error) bool {
+	var statusErr *apiStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= http.StatusInternalServerError || statusErr.code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// postToExternalAPI はメールデータを送信先（apiURL）の /receive に送信します
+func (h *EmailHandler) postToExternalAPI(apiURL string, payloadBytes []byte, messageID string) error {
+	log := logger.Logger
 
-	apiURL := os.Getenv("AUTOPILOT_URL")
-	bearerToken := os.Getenv("SERVICE_TOKEN")
+	bearerToken := idtoken.BearerToken(apiURL, serviceauth.PrimaryServiceToken())
 	if bearerToken == "" {
 		log.Error("Bearer tokenが設定されていません")
 		return fmt.Errorf("bearer token is not set")
@@ -180,8 +430,7 @@ func sendToExternalAPI(emailData *models.EmailData, messageID string) error {
 		req.Header.Set("X-Message-ID", messageID)
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := h.client.Do(req)
 	if err != nil {
 		log.Error("HTTPリクエストの実行に失敗しました", zap.Error(err))
 		return fmt.Errorf("failed to make HTTP request: %v", err)
@@ -198,7 +447,7 @@ func sendToExternalAPI(emailData *models.EmailData, messageID string) error {
 		logger.Logger.Error("外部APIがエラーを返しました",
 			zap.String("messageId", messageID),
 			zap.Int("statusCode", resp.StatusCode))
-		return fmt.Errorf("external API returned error status: %d", resp.StatusCode)
+		return &apiStatusError{code: resp.StatusCode}
 	}
 
 	logger.Logger.Info("外部APIにデータを送信しました",
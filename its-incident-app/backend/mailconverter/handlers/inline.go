@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/jhillyerd/enmime"
+	"mailconvertor/models"
+)
+
+// maxInlineEmbedTotal はHTML本文に data URI として埋め込むインライン画像の合計の上限（バイト）。
+// 超えた画像は cid: 参照のまま残し、添付ファイルの content_id で保存先を参照します
+const maxInlineEmbedTotal = 2 * 1024 * 1024
+
+// cidReference はHTML本文の cid: 参照（<img src="cid:graph1@zabbix"> など）
+var cidReference = regexp.MustCompile(`(?i)\bcid:([^"'\s()<>]+)`)
+
+// inlineImage はHTML本文に埋め込むインライン画像です
+type inlineImage struct {
+	contentType string
+	data        []byte
+}
+
+// normalizeContentID は Content-ID・cid: 参照の山括弧とURLエンコードを取り除き、大文字・小文字を区別せずに照合できるようにします
+func normalizeContentID(id string) string {
+	id = strings.Trim(strings.TrimSpace(id), "<>")
+	if unescaped, err := url.PathUnescape(id); err == nil {
+		id = unescaped
+	}
+	return strings.ToLower(id)
+}
+
+// isInlineImage は cid: で参照される画像のパートかを判定します
+func isInlineImage(contentType, contentID string) bool {
+	return contentID != "" && strings.HasPrefix(strings.ToLower(contentType), "image/")
+}
+
+// extractInlineImages はインライン画像（監視ツールのグラフなど）を添付ファイルとして取り出し、
+// HTML本文に埋め込む画像（上限まで）を Content-ID ごとに返します
+func extractInlineImages(env *enmime.Envelope) ([]models.Attachment, map[string]inlineImage) {
+	var parts []*enmime.Part
+	for _, part := range append(append([]*enmime.Part{}, env.Inlines...), env.OtherParts...) {
+		if isInlineImage(part.ContentType, part.ContentID) {
+			parts = append(parts, part)
+		}
+	}
+
+	attachments := extractAttachments(parts)
+	images := map[string]inlineImage{}
+	embedded := 0
+	// 添付ファイルとして送られた画像も cid: で参照されることがあるため埋め込みの対象にする
+	for _, part := range append(parts, env.Attachments...) {
+		if !isInlineImage(part.ContentType, part.ContentID) || embedded+len(part.Content) > maxInlineEmbedTotal {
+			continue
+		}
+		id := normalizeContentID(part.ContentID)
+		if _, ok := images[id]; ok {
+			continue
+		}
+		images[id] = inlineImage{contentType: part.ContentType, data: part.Content}
+		embedded += len(part.Content)
+	}
+	for i := range attachments {
+		attachments[i].Inline = true
+	}
+	return attachments, images
+}
+
+// rewriteInlineImages はHTML本文の cid: 参照を data URI に書き換え、元のメールの見た目のまま表示できるようにします。
+// 埋め込めなかった参照はそのまま残します
+func rewriteInlineImages(html string, images map[string]inlineImage) string {
+	if html == "" || len(images) == 0 {
+		return html
+	}
+	return cidReference.ReplaceAllStringFunc(html, func(ref string) string {
+		image, ok := images[normalizeContentID(ref[len("cid:"):])]
+		if !ok {
+			return ref
+		}
+		return "data:" + image.contentType + ";base64," + base64.StdEncoding.EncodeToString(image.data)
+	})
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"mailconvertor/logger"
+	"mailconvertor/metrics"
+	"mailconvertor/models"
+	"mailconvertor/notify"
+)
+
+// 受信の失敗の種類（メトリクスのラベル）
+const (
+	failureParse   = "parse_error"    // MIMEのパースに失敗
+	failureEmpty   = "empty_body"     // パースできたが本文・テキストの添付ファイルが空
+	failureTooBig  = "too_large"      // サイズの上限を超えた
+	failureForward = "forward_failed" // autopilotへの送信に失敗
+)
+
+var (
+	receivedTotal = metrics.NewCounterVec("mailconvertor_received_total",
+		"パースを開始したメールの件数")
+	receiveFailuresTotal = metrics.NewCounterVec("mailconvertor_receive_failures_total",
+		"パース・送信に失敗したメールの件数（空の本文を含む）", "reason")
+)
+
+// AlertConfig は受信の失敗率によるアラートの設定です
+type AlertConfig struct {
+	Threshold   float64       // アラートを送る失敗率（0〜1）
+	Window      time.Duration // 失敗率を集計する期間
+	MinMessages int           // 集計期間内にこの件数以上を受信した場合のみ判定する
+	Cooldown    time.Duration // 同じアラートを再送するまでの間隔
+	// ImmediateCooldown はパース・送信の失敗を1件ごとに即時通知する間隔（失敗の種類ごと）。0 の場合は即時に通知しません
+	ImmediateCooldown time.Duration
+}
+
+// immediateFailures は1件でも発生したら即時に通知する失敗の種類と、通知に含めるエラーの種類（models.Error*）
+var immediateFailures = map[string]string{
+	failureParse:   models.ErrorParse,
+	failureForward: models.ErrorUpstream,
+}
+
+// failureMonitor は直近の受信件数と失敗件数を1分単位で集計し、失敗率がしきい値を超えたらアラートを送ります。
+// パースの不具合は「インシデントが作られない」ことでしか気付けないため、受信側で検知します
+type failureMonitor struct {
+	notifier  *notify.Notifier
+	cfg       AlertConfig
+	mu        sync.Mutex
+	buckets   map[int64]*failureBucket // 分（Unix時刻 / 60）ごとの件数
+	lastAlert time.Time
+
+	lastImmediate map[string]time.Time // 失敗の種類ごとに最後に即時通知した時刻
+	suppressed    map[string]int       // 即時通知の間隔内のため通知しなかった件数
+}
+
+type failureBucket struct {
+	received int
+	failures map[string]int
+}
+
+// monitor は失敗率の監視。nil の場合はメトリクスのみ記録します
+var monitor *failureMonitor
+
+// ConfigureFailureAlerts は失敗率がしきい値を超えた場合のアラートの送信先を設定します（notifier が nil の場合は送らない）
+func ConfigureFailureAlerts(notifier *notify.Notifier, cfg AlertConfig) {
+	if notifier == nil {
+		monitor = nil
+		return
+	}
+	monitor = &failureMonitor{
+		notifier:      notifier,
+		cfg:           cfg,
+		buckets:       map[int64]*failureBucket{},
+		lastImmediate: map[string]time.Time{},
+		suppressed:    map[string]int{},
+	}
+}
+
+// recordReceived はパースを開始したメールを記録します
+func recordReceived() {
+	receivedTotal.Inc()
+	if monitor != nil {
+		monitor.record("")
+	}
+}
+
+// recordFailure は受信の失敗を記録します
+func recordFailure(reason, messageID string) {
+	receiveFailuresTotal.Inc(reason)
+	logger.Logger.Debug("受信の失敗を記録しました", zap.String("messageId", messageID), zap.String("reason", reason))
+	if monitor != nil {
+		monitor.record(reason)
+	}
+}
+
+// recordParseFailure はパースの失敗を記録します。サイズの上限によるものは拒否の応答（rejectTooLarge）で記録します
+func recordParseFailure(err error, messageID string) {
+	if !isTooLarge(err) {
+		recordFailure(failureParse, messageID)
+		notifyFailure(failureParse, messageID, err)
+	}
+}
+
+// recordForwardFailure は送信先への送信の失敗を記録します
+func recordForwardFailure(err error, messageID string) {
+	recordFailure(failureForward, messageID)
+	notifyFailure(failureForward, messageID, err)
+}
+
+// notifyFailure は取り込みが止まっていることに数分で気付けるよう、パース・送信の失敗を即時に通知します
+func notifyFailure(reason, messageID string, err error) {
+	if monitor != nil && monitor.cfg.ImmediateCooldown > 0 {
+		monitor.notifyImmediate(reason, messageID, err)
+	}
+}
+
+// recordIfEmpty は本文が空のメールを記録します
+func recordIfEmpty(emailData *models.EmailData, messageID string) {
+	if isEmptyEmail(emailData) {
+		recordFailure(failureEmpty, messageID)
+	}
+}
+
+// isEmptyEmail は本文とテキストの添付ファイルの内容がすべて空かを返します
+func isEmptyEmail(emailData *models.EmailData) bool {
+	if strings.TrimSpace(emailData.Body) != "" {
+		return false
+	}
+	for _, attachment := range emailData.Attachments {
+		if strings.TrimSpace(attachment.Content) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// record は受信（reason が空）または失敗を集計し、失敗の場合は失敗率を判定します
+func (m *failureMonitor) record(reason string) {
+	now := time.Now()
+	minute := now.Unix() / 60
+
+	m.mu.Lock()
+	bucket, ok := m.buckets[minute]
+	if !ok {
+		bucket = &failureBucket{failures: map[string]int{}}
+		m.buckets[minute] = bucket
+	}
+	if reason == "" {
+		bucket.received++
+	} else {
+		bucket.failures[reason]++
+	}
+
+	// 集計期間を過ぎた件数を捨ててから判定する
+	oldest := now.Add(-m.cfg.Window).Unix() / 60
+	received := 0
+	failures := map[string]int{}
+	for key, b := range m.buckets {
+		if key <= oldest {
+			delete(m.buckets, key)
+			continue
+		}
+		received += b.received
+		for r, n := range b.failures {
+			failures[r] += n
+		}
+	}
+
+	total := 0
+	for _, n := range failures {
+		total += n
+	}
+	alert := reason != "" && received >= m.cfg.MinMessages &&
+		float64(total)/float64(received) >= m.cfg.Threshold &&
+		now.Sub(m.lastAlert) >= m.cfg.Cooldown
+	if alert {
+		m.lastAlert = now
+	}
+	m.mu.Unlock()
+
+	if alert {
+		go m.alert(received, total, failures)
+	}
+}
+
+func (m *failureMonitor) alert(received, total int, failures map[string]int) {
+	reasons := make([]string, 0, len(failures))
+	for reason, n := range failures {
+		reasons = append(reasons, fmt.Sprintf("%s: %d", reason, n))
+	}
+	sort.Strings(reasons)
+
+	rate := float64(total) / float64(received)
+	logger.Logger.Warn("メールの受信の失敗率がしきい値を超えました",
+		zap.Int("received", received),
+		zap.Int("failures", total),
+		zap.Float64("rate", rate),
+		zap.Strings("reasons", reasons))
+
+	content := fmt.Sprintf("直近 %s に受信した %d 件のうち %d 件（%.0f%%）のメールでパース・送信に失敗しています（%s）。"+
+		"パースの不具合やautopilotの停止により、インシデントが作成されていない可能性があります。",
+		m.cfg.Window, received, total, rate*100, strings.Join(reasons, ", "))
+	if err := m.notifier.SendAlert("メールの受信で失敗が増えています", content, "高"); err != nil {
+		logger.Logger.Error("失敗率のアラートの送信に失敗しました", zap.Error(err))
+	}
+}
+
+// notifyImmediate は失敗を即時に通知します。同じ種類の失敗は ImmediateCooldown に1回までとし、
+// 間隔内に通知しなかった件数を次の通知に含めます
+func (m *failureMonitor) notifyImmediate(reason, messageID string, err error) {
+	now := time.Now()
+	m.mu.Lock()
+	if now.Sub(m.lastImmediate[reason]) < m.cfg.ImmediateCooldown {
+		m.suppressed[reason]++
+		m.mu.Unlock()
+		return
+	}
+	m.lastImmediate[reason] = now
+	suppressed := m.suppressed[reason]
+	m.suppressed[reason] = 0
+	m.mu.Unlock()
+
+	errorCode := immediateFailures[reason]
+	message := err.Error()
+	if runes := []rune(message); len(runes) > 500 {
+		message = string(runes[:500]) + "…"
+	}
+	content := fmt.Sprintf("メッセージID: %s\nエラーコード: %s\nエラー: %s", messageID, errorCode, message)
+	if suppressed > 0 {
+		content += fmt.Sprintf("\n前回の通知以降に同じ種類の失敗が他に %d 件ありました。", suppressed)
+	}
+
+	go func() {
+		logger.Logger.Warn("メールの取り込みの失敗を通知します",
+			zap.String("messageId", messageID),
+			zap.String("errorCode", errorCode),
+			zap.Int("suppressed", suppressed))
+		if err := m.notifier.SendAlert("メールの取り込みに失敗しました（"+errorCode+"）", content, "高"); err != nil {
+			logger.Logger.Error("取り込みの失敗の通知に失敗しました", zap.String("messageId", messageID), zap.Error(err))
+		}
+	}()
+}
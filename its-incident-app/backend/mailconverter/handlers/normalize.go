@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"mailconvertor/logger"
+	"mailconvertor/metrics"
+	"mailconvertor/models"
+)
+
+// 本文の正規化ルールの動作
+const (
+	bodyRuleCut    = "cut"    // 一致した行から後ろ（署名・引用した過去のメールなど）をすべて取り除く
+	bodyRuleRemove = "remove" // 一致した部分だけを取り除く
+)
+
+var bodyRulesApplied = metrics.NewCounterVec("mailconvertor_body_rules_applied_total",
+	"本文の正規化ルールを適用したメールの件数", "rule")
+
+// BodyRule は本文から署名・定型のフッター・引用を取り除くルールです。Pattern は行単位（(?m)）で評価します
+type BodyRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"` // cut（既定）または remove
+}
+
+// defaultBodyRules は一般的なメールクライアント・監視ツールの署名・引用・フッター
+var defaultBodyRules = []BodyRule{
+	{Name: "signature", Pattern: `^-- ?$`},
+	{Name: "outlook_original", Pattern: `^\s*-{2,}\s*(Original Message|元のメッセージ|Forwarded message|転送されたメッセージ)\s*-{2,}\s*$`},
+	{Name: "outlook_header", Pattern: `^\s*(From|差出人|送信者)\s*[:：].*\n\s*(Sent|Date|送信日時|日時)\s*[:：]`},
+	{Name: "reply_header", Pattern: `^\s*On .{1,200} wrote:\s*$`},
+	{Name: "reply_header_ja", Pattern: `^\s*\d{4}[年/].{1,200}(書きました|wrote)[:：]\s*$`},
+	{Name: "quoted", Pattern: `^\s*>.*$\n?`, Action: bodyRuleRemove},
+	{Name: "confidentiality", Pattern: `^.*(この(電子)?メールには.*(機密|秘密)情報|This (e-?mail|message) (and any attachments )?(is|may be) confidential|CONFIDENTIALITY NOTICE).*$`},
+}
+
+// bodyRule は正規表現をコンパイル済みのルールです
+type bodyRule struct {
+	BodyRule
+	pattern *regexp.Regexp
+}
+
+// bodyRules は上から順に適用するルール。nil の場合は本文を正規化しません
+var bodyRules []bodyRule
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+var trailingSpaces = regexp.MustCompile(`(?m)[ \t\r]+$`)
+
+// ConfigureBodyNormalization は本文の正規化を設定します。rulesJSON（JSONの配列）のルールは既定のルールの後に適用します。
+// enabled が false の場合は正規化しません
+func ConfigureBodyNormalization(enabled bool, rulesJSON string) error {
+	bodyRules = nil
+	if !enabled {
+		return nil
+	}
+
+	rules := append([]BodyRule{}, defaultBodyRules...)
+	if strings.TrimSpace(rulesJSON) != "" {
+		var custom []BodyRule
+		if err := json.Unmarshal([]byte(rulesJSON), &custom); err != nil {
+			return fmt.Errorf("invalid body rules: %v", err)
+		}
+		rules = append(rules, custom...)
+	}
+
+	compiled := make([]bodyRule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%d", i)
+		}
+		if rule.Action == "" {
+			rule.Action = bodyRuleCut
+		}
+		if rule.Action != bodyRuleCut && rule.Action != bodyRuleRemove {
+			return fmt.Errorf("body rule %d (%s) has an unknown action %q", i, rule.Name, rule.Action)
+		}
+		pattern, err := regexp.Compile("(?m)" + rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("body rule %d (%s) has an invalid pattern: %v", i, rule.Name, err)
+		}
+		compiled = append(compiled, bodyRule{BodyRule: rule, pattern: pattern})
+	}
+	bodyRules = compiled
+	return nil
+}
+
+// normalizeBody はAIに渡す本文から署名・フッター・引用した過去のメールを取り除き、空白を詰めます。
+// 元の本文は生データ（RawGCSURI）から確認できます
+func normalizeBody(emailData *models.EmailData, messageID string) {
+	if bodyRules == nil || emailData.Body == "" {
+		return
+	}
+
+	body := strings.ReplaceAll(emailData.Body, "\r\n", "\n")
+	var applied []string
+	for _, rule := range bodyRules {
+		next := rule.apply(body)
+		if next == body {
+			continue
+		}
+		// 本文がすべて引用の場合など、取り除くと何も残らないルールは適用しない
+		if strings.TrimSpace(next) == "" {
+			continue
+		}
+		body = next
+		applied = append(applied, rule.Name)
+		bodyRulesApplied.Inc(rule.Name)
+	}
+	body = trailingSpaces.ReplaceAllString(body, "")
+	body = strings.TrimSpace(blankLines.ReplaceAllString(body, "\n\n"))
+
+	if len(applied) > 0 {
+		logger.Logger.Debug("本文を正規化しました",
+			zap.String("messageId", messageID),
+			zap.Strings("rules", applied),
+			zap.Int("originalLength", len(emailData.Body)),
+			zap.Int("length", len(body)))
+	}
+	emailData.Body = body
+}
+
+// apply はルールを適用した本文を返します。cut は本文の先頭に一致した場合（返信の前に引用がある場合など）は適用しません
+func (r *bodyRule) apply(body string) string {
+	if r.Action == bodyRuleRemove {
+		return r.pattern.ReplaceAllString(body, "")
+	}
+	for _, loc := range r.pattern.FindAllStringIndex(body, -1) {
+		if strings.TrimSpace(body[:loc[0]]) != "" {
+			return body[:loc[0]]
+		}
+	}
+	return body
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"mailconvertor/logger"
+	"mailconvertor/models"
+	"mailconvertor/storage"
+)
+
+const (
+	// spoolPending は処理待ちのメール、spoolFailed は再試行をすべて失敗したメールを保存する場所（一時保存の prefix 以下）
+	spoolPending = "pending/"
+	spoolFailed  = "failed/"
+	// staleSpoolAge を過ぎても処理待ちに残っているメールは、停止したインスタンスや満杯の待ち行列から取り残されたとみなす
+	staleSpoolAge = 10 * time.Minute
+	// maxRetryDelay は再試行の間隔の上限
+	maxRetryDelay = 5 * time.Minute
+	// queueProcessTimeout は1通の処理（読み取り・パース・送信）の上限
+	queueProcessTimeout = 5 * time.Minute
+)
+
+// QueueConfig は受信したメールを非同期で処理する設定です
+type QueueConfig struct {
+	Workers       int
+	QueueSize     int
+	MaxAttempts   int
+	RetryDelay    time.Duration // 最初の再試行までの間隔（以降は2倍ずつ、上限 5分）
+	SweepInterval time.Duration // 取り残されたメールを探す間隔
+}
+
+// ReceiveQueue は /receive で受け付けたメールを一時保存し、ワーカーでパース・送信します。
+// 上流のメールゲートウェイはautopilotの処理を待たずに 202 を受け取ります
+type ReceiveQueue struct {
+	spool  *storage.Uploader
+	cfg    QueueConfig
+	emails *EmailHandler // パースしたメールの送信に使う
+	jobs   chan string   // 処理待ちのオブジェクト名
+	mu     sync.Mutex
+	active map[string]bool // 待ち行列にある・処理中のオブジェクト
+	wg     sync.WaitGroup
+	done   chan struct{}
+}
+
+// NewReceiveQueue は spool に一時保存して非同期で処理し、emails で送信する待ち行列を作成します。spool が nil の場合は nil を返します
+func NewReceiveQueue(spool *storage.Uploader, cfg QueueConfig, emails *EmailHandler) *ReceiveQueue {
+	if spool == nil {
+		return nil
+	}
+	return &ReceiveQueue{
+		spool:  spool,
+		cfg:    cfg,
+		emails: emails,
+		jobs:   make(chan string, cfg.QueueSize),
+		active: map[string]bool{},
+		done:   make(chan struct{}),
+	}
+}
+
+// Start はワーカーと、取り残されたメールを探す処理を開始します。ctx がキャンセルされると処理中のメールを終えてから停止します
+func (q *ReceiveQueue) Start(ctx context.Context) {
+	if q == nil {
+		return
+	}
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker(ctx)
+	}
+	q.wg.Add(1)
+	go q.sweep(ctx)
+	go func() {
+		q.wg.Wait()
+		close(q.done)
+	}()
+	logger.Logger.Info("受信したメールの非同期処理を開始します",
+		zap.Int("workers", q.cfg.Workers),
+		zap.Int("queueSize", q.cfg.QueueSize))
+}
+
+// Wait はワーカーの停止を待ちます（ctx の期限まで）。未処理のメールは一時保存に残り、次に起動したインスタンスが処理します
+func (q *ReceiveQueue) Wait(ctx context.Context) {
+	if q == nil {
+		return
+	}
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		logger.Logger.Warn("受信したメールの非同期処理の停止がタイムアウトしました")
+	}
+}
+
+// acceptEmail はメールの生データを一時保存して待ち行列に登録し、202 を返します
+func (h *EmailHandler) acceptEmail(c *gin.Context, messageID string, body io.Reader) {
+	object, err := h.queue.store(c.Request.Context(), messageID, body)
+	if err != nil {
+		if isTooLarge(err) {
+			rejectTooLarge(c, messageID, -1)
+			return
+		}
+		logger.Logger.Error("メールの一時保存に失敗しました",
+			zap.String("messageId", messageID),
+			zap.Error(err))
+		response := errorResponse(http.StatusServiceUnavailable, models.ErrorUnavailable, "Failed to store email", messageID, err)
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+
+	h.queue.enqueue(object)
+	logger.Logger.Info("メールを受け付けました（非同期で処理します）",
+		zap.String("messageId", messageID),
+		zap.String("object", object))
+	response := createResponse("accepted", http.StatusAccepted, "Email accepted for processing", messageID)
+	c.JSON(http.StatusAccepted, response)
+}
+
+// store はメールの生データを処理待ちとして保存し、オブジェクト名を返します
+func (q *ReceiveQueue) store(ctx context.Context, messageID string, body io.Reader) (string, error) {
+	name := spoolPending + url.PathEscape(messageID) + ".eml"
+	if _, err := q.spool.Upload(ctx, name, "message/rfc822", body); err != nil {
+		return "", err
+	}
+	return q.spool.ObjectName(name), nil
+}
+
+// enqueue はオブジェクトを待ち行列に登録します。満杯の場合は一時保存に残し、取り残されたメールとして後で処理します
+func (q *ReceiveQueue) enqueue(object string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.active[object] {
+		return
+	}
+	select {
+	case q.jobs <- object:
+		q.active[object] = true
+	default:
+		logger.Logger.Warn("非同期処理の待ち行列が満杯のため後で処理します", zap.String("object", object))
+	}
+}
+
+func (q *ReceiveQueue) worker(ctx context.Context) {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case object := <-q.jobs:
+			q.process(ctx, object)
+			q.mu.Lock()
+			delete(q.active, object)
+			q.mu.Unlock()
+		}
+	}
+}
+
+// process はメールを送信し、成功したら一時保存を削除します。失敗した場合は間隔を空けて再試行し、
+// 上限に達したら failed/ に移します
+func (q *ReceiveQueue) process(ctx context.Context, object string) {
+	messageID := spoolMessageID(object)
+	log := logger.Logger.With(zap.String("messageId", messageID), zap.String("object", object))
+
+	delay := q.cfg.RetryDelay
+	for attempt := 1; ; attempt++ {
+		permanent, err := q.forward(ctx, object, messageID)
+		if err == nil {
+			if err := q.spool.Delete(context.WithoutCancel(ctx), object); err != nil {
+				log.Warn("一時保存したメールの削除に失敗しました", zap.Error(err))
+			}
+			log.Info("受け付けたメールを処理しました", zap.Int("attempts", attempt))
+			return
+		}
+		if permanent || attempt >= q.cfg.MaxAttempts {
+			log.Error("受け付けたメールの処理を諦めました", zap.Int("attempts", attempt), zap.Error(err))
+			q.moveToFailed(ctx, object)
+			return
+		}
+
+		log.Warn("受け付けたメールの処理に失敗したため再試行します",
+			zap.Int("attempt", attempt),
+			zap.Duration("retryIn", delay),
+			zap.Error(err))
+		select {
+		case <-ctx.Done():
+			// 停止中は再試行せず、一時保存に残して他のインスタンスに任せる
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// forward は一時保存したメールを読み取ってパースし、外部APIに送信します。
+// パースできないメールは再試行しても結果が変わらないため permanent を true で返します
+func (q *ReceiveQueue) forward(ctx context.Context, object, messageID string) (permanent bool, err error) {
+	// 停止の合図で送信を中断しないよう、処理中のメールは上限時間まで続ける
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), queueProcessTimeout)
+	defer cancel()
+
+	reader, size, err := q.spool.Open(ctx, object)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	var emailData *models.EmailData
+	if size >= 0 && size <= streamingThreshold {
+		raw, err := io.ReadAll(reader)
+		if err != nil {
+			return false, err
+		}
+		if emailData, err = parseBuffered(ctx, messageID, raw); err != nil {
+			return true, err
+		}
+		return false, q.emails.forwardEmail(ctx, emailData, messageID, false)
+	}
+
+	emailData, err = parseStreamed(ctx, messageID, io.LimitReader(reader, maxMessageSize))
+	if err != nil {
+		return false, err
+	}
+	return false, q.emails.forwardEmail(ctx, emailData, messageID, true)
+}
+
+// moveToFailed は処理を諦めたメールを調査用に failed/ に移します
+func (q *ReceiveQueue) moveToFailed(ctx context.Context, object string) {
+	ctx = context.WithoutCancel(ctx)
+	failed := q.spool.ObjectName(spoolFailed + path.Base(object))
+	if err := q.spool.Copy(ctx, object, failed); err != nil {
+		logger.Logger.Error("処理できなかったメールの移動に失敗しました",
+			zap.String("object", object),
+			zap.Error(err))
+		return
+	}
+	if err := q.spool.Delete(ctx, object); err != nil {
+		logger.Logger.Warn("一時保存したメールの削除に失敗しました",
+			zap.String("object", object),
+			zap.Error(err))
+	}
+}
+
+// sweep は定期的に処理待ちのメールを確認し、取り残されたメールを待ち行列に登録します
+func (q *ReceiveQueue) sweep(ctx context.Context) {
+	defer q.wg.Done()
+	ticker := time.NewTicker(q.cfg.SweepInterval)
+	defer ticker.Stop()
+	for {
+		objects, err := q.spool.List(ctx, spoolPending)
+		if err != nil && ctx.Err() == nil {
+			logger.Logger.Error("処理待ちのメールの一覧の取得に失敗しました", zap.Error(err))
+		}
+		for _, object := range objects {
+			if time.Since(object.Updated) >= staleSpoolAge {
+				q.enqueue(object.Name)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// spoolMessageID はオブジェクト名からメッセージIDを復元します
+func spoolMessageID(object string) string {
+	name := strings.TrimSuffix(path.Base(object), ".eml")
+	if messageID, err := url.PathUnescape(name); err == nil {
+		return messageID
+	}
+	return name
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"mailconvertor/datastore"
+	"mailconvertor/logger"
+	"mailconvertor/serviceauth"
+	"shared/idtoken"
+)
+
+// 依存サービスの状態
+const (
+	dependencyUp   = "up"
+	dependencyDown = "down"
+)
+
+// dependencyStatus は依存サービスごとのチェック結果
+type dependencyStatus struct {
+	Status    string `json:"status"`
+	Critical  bool   `json:"critical"` // 到達できない場合にメールを処理できない依存サービス
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReadinessHandler は /ready で依存サービス（Datastore・autopilot）の疎通を確認します
+type ReadinessHandler struct {
+	store        *datastore.Client // nil の場合はDatastoreを使わない構成
+	emails       *EmailHandler
+	autopilotURL string
+	timeout      time.Duration
+	client       *http.Client
+}
+
+// NewReadinessHandler は main で作成した共有のDatastoreクライアントと、emails の送信先のautopilotの疎通を確認するハンドラーを作成します
+func NewReadinessHandler(store *datastore.Client, emails *EmailHandler, timeout time.Duration) *ReadinessHandler {
+	return &ReadinessHandler{
+		store:        store,
+		emails:       emails,
+		autopilotURL: emails.cfg.AutopilotURL,
+		timeout:      timeout,
+		client:       &http.Client{},
+	}
+}
+
+// HandleHealth はプロセスが応答できることだけを返します（依存サービスは確認しない）
+func HandleHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// HandleReady は依存サービスの疎通を並行して確認し、メールを処理できない場合は 503 を返します。
+// 送信待ちの保存・非同期処理が有効な場合、autopilotに到達できなくてもメールは受け付けて後で送信できるため degraded とします
+func (h *ReadinessHandler) HandleReady(c *gin.Context) {
+	ctx := c.Request.Context()
+	results := make(map[string]dependencyStatus)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	check := func(name string, critical bool, ping func(context.Context) error) {
+		defer wg.Done()
+		pingCtx, cancel := context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+
+		start := time.Now()
+		err := ping(pingCtx)
+		result := dependencyStatus{Status: dependencyUp, Critical: critical, LatencyMS: time.Since(start).Milliseconds()}
+		if err != nil {
+			result.Status = dependencyDown
+			result.Error = err.Error()
+		}
+		mu.Lock()
+		results[name] = result
+		mu.Unlock()
+	}
+
+	if h.store != nil {
+		wg.Add(1)
+		go check("datastore", true, h.store.Ping)
+	}
+	wg.Add(1)
+	go check("autopilot", h.emails.retrier == nil && h.emails.queue == nil, h.pingAutopilot)
+	wg.Wait()
+
+	overall := "ok"
+	for _, result := range results {
+		if result.Status == dependencyUp {
+			continue
+		}
+		if result.Critical {
+			overall = "unavailable"
+		} else if overall == "ok" {
+			overall = "degraded"
+		}
+	}
+	httpStatus := http.StatusOK
+	if overall == "unavailable" {
+		httpStatus = http.StatusServiceUnavailable
+	}
+
+	if overall != "ok" {
+		logger.Logger.Warn("依存サービスに異常があります",
+			zap.String("status", overall), zap.Any("dependencies", results))
+	}
+
+	c.JSON(httpStatus, gin.H{
+		"status":       overall,
+		"dependencies": results,
+	})
+}
+
+// pingAutopilot はautopilotの /health にリクエストし、疎通できない場合や200以外の場合にエラーを返します
+func (h *ReadinessHandler) pingAutopilot(ctx context.Context) error {
+	if h.autopilotURL == "" {
+		return fmt.Errorf("AUTOPILOT_URL is not set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(h.autopilotURL, "/")+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	// 本番環境のautopilotは /health にも認証が必要
+	if token := idtoken.BearerToken(h.autopilotURL, serviceauth.PrimaryServiceToken()); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+	"mailconvertor/logger"
+	"mailconvertor/models"
+	"mailconvertor/pending"
+)
+
+// RetryConfig はautopilotに送信できなかったメールの再送の設定です
+type RetryConfig struct {
+	Interval     time.Duration // 送信待ちのメールを確認する間隔
+	InitialDelay time.Duration // 最初の再送までの間隔（以降は2倍ずつ）
+	MaxDelay     time.Duration // 再送の間隔の上限
+	BatchSize    int           // 1回の確認で再送する件数の上限
+}
+
+// ForwardRetrier はautopilotに送信できなかったメールデータを送信待ちとして保存し、バックグラウンドで再送します。
+// 複数のインスタンスが同じメールを再送することがありますが、autopilotがメッセージIDで重複を除きます
+type ForwardRetrier struct {
+	store  *pending.Store
+	cfg    RetryConfig
+	emails *EmailHandler // 再送に使う送信先の設定・HTTPクライアント
+	done   chan struct{}
+}
+
+// NewForwardRetrier は store に送信待ちのメールを保存し、emails の送信先に再送するワーカーを作成します。store が nil の場合は nil を返します
+func NewForwardRetrier(store *pending.Store, cfg RetryConfig, emails *EmailHandler) *ForwardRetrier {
+	if store == nil {
+		return nil
+	}
+	return &ForwardRetrier{store: store, cfg: cfg, emails: emails, done: make(chan struct{})}
+}
+
+// Start は再送を開始します。ctx がキャンセルされると再送中のメールを終えてから停止します
+func (r *ForwardRetrier) Start(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	go r.run(ctx)
+}
+
+// Wait はワーカーの停止を待ちます（ctx の期限まで）
+func (r *ForwardRetrier) Wait(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		logger.Logger.Warn("送信待ちのメールの再送の停止がタイムアウトしました")
+	}
+}
+
+// deferForward は送信に失敗したメールデータを送信待ちとして保存します。保存できた場合は true を返します。
+// 4xx（429 を除く）のように再送しても結果が変わらないエラーは保存しません
+func (h *EmailHandler) deferForward(ctx context.Context, emailData *models.EmailData, messageID string, sendErr error) bool {
+	if h.retrier == nil || !isRetryable(sendErr) {
+		return false
+	}
+
+	payload, err := json.Marshal(emailData)
+	if err == nil && len(payload) > pending.MaxPayloadSize && len(emailData.RawHeader) > 0 {
+		// Datastoreに収まらない場合はスパム判定用のヘッダーを除く
+		trimmed := *emailData
+		trimmed.RawHeader = nil
+		payload, err = json.Marshal(&trimmed)
+	}
+	if err != nil {
+		logger.Logger.Error("送信待ちのメールデータのJSONエンコードに失敗しました",
+			zap.String("messageId", messageID),
+			zap.Error(err))
+		return false
+	}
+
+	_, target := h.routeFor(emailData)
+	now := time.Now()
+	entry := &pending.Entry{
+		MessageID:     messageID,
+		Target:        target,
+		Payload:       payload,
+		Attempts:      1,
+		LastError:     sendErr.Error(),
+		CreatedAt:     now,
+		NextAttemptAt: now.Add(h.retrier.cfg.InitialDelay),
+	}
+	if err := h.retrier.store.Save(ctx, entry); err != nil {
+		logger.Logger.Error("送信待ちのメールの保存に失敗しました",
+			zap.String("messageId", messageID),
+			zap.Error(err))
+		return false
+	}
+
+	logger.Logger.Warn("autopilotに送信できなかったため送信待ちとして保存しました",
+		zap.String("messageId", messageID),
+		zap.Time("nextAttemptAt", entry.NextAttemptAt),
+		zap.Error(sendErr))
+	return true
+}
+
+func (r *ForwardRetrier) run(ctx context.Context) {
+	defer close(r.done)
+	logger.Logger.Info("送信待ちのメールの再送を開始します", zap.Duration("interval", r.cfg.Interval))
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := r.retryDue(ctx); err != nil && ctx.Err() == nil {
+			logger.Logger.Error("送信待ちのメールの確認に失敗しました", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			logger.Logger.Info("送信待ちのメールの再送を停止しました")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// retryDue は再送の時刻を過ぎたメールを送信します。失敗したメールは間隔を延ばして次の再送の時刻を保存します
+func (r *ForwardRetrier) retryDue(ctx context.Context) error {
+	entries, err := r.store.Due(ctx, time.Now(), r.cfg.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		log := logger.Logger.With(zap.String("messageId", entry.MessageID), zap.Int("attempts", entry.Attempts))
+		// 停止の合図で保存・削除を中断しないよう、再送中のメールは最後まで処理する
+		storeCtx := context.WithoutCancel(ctx)
+
+		// 送信先を保存する前のエントリーはautopilotに送信する
+		target := entry.Target
+		if target == "" {
+			target = r.emails.cfg.AutopilotURL
+		}
+		err := r.emails.postToExternalAPI(target, entry.Payload, entry.MessageID)
+		if err == nil || !isRetryable(err) {
+			if err != nil {
+				log.Error("autopilotが受け付けなかったため送信待ちのメールを破棄します", zap.Error(err))
+			} else {
+				log.Info("送信待ちのメールを送信しました", zap.Duration("delay", time.Since(entry.CreatedAt)))
+			}
+			if err := r.store.Delete(storeCtx, entry.MessageID); err != nil {
+				log.Warn("送信待ちのメールの削除に失敗しました", zap.Error(err))
+			}
+			continue
+		}
+
+		entry.Attempts++
+		entry.LastError = err.Error()
+		entry.NextAttemptAt = time.Now().Add(r.backoff(entry.Attempts))
+		if err := r.store.Save(storeCtx, entry); err != nil {
+			log.Error("送信待ちのメールの更新に失敗しました", zap.Error(err))
+			continue
+		}
+		log.Warn("送信待ちのメールの再送に失敗しました",
+			zap.Time("nextAttemptAt", entry.NextAttemptAt),
+			zap.Error(err))
+	}
+	return nil
+}
+
+// backoff は attempts 回目の失敗の後の再送までの間隔を返します
+func (r *ForwardRetrier) backoff(attempts int) time.Duration {
+	delay := r.cfg.InitialDelay
+	for i := 1; i < attempts && delay < r.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, r.cfg.MaxDelay)
+}
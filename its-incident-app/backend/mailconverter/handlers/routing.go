@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"mailconvertor/metrics"
+	"mailconvertor/models"
+)
+
+// defaultRoute はどのルールにも一致しないメールの送信先（AUTOPILOT_URL）の名前
+const defaultRoute = "autopilot"
+
+var forwardedTotal = metrics.NewCounterVec("mailconvertor_forwarded_total",
+	"送信先ごとの送信したメールの件数", "route")
+
+// RoutingRule はメールの送信先を決めるルールです。指定した条件をすべて満たすメールを Target に送信します
+// （条件を指定しないルールはすべてのメールに一致）
+type RoutingRule struct {
+	Name         string `json:"name"`
+	SenderDomain string `json:"sender_domain"` // 送信者のドメイン（サブドメインにも一致）またはメールアドレス
+	To           string `json:"to"`            // 宛先（To・CC）のメールアドレスまたはドメイン
+	Subject      string `json:"subject"`       // 件名の正規表現
+	Target       string `json:"target"`        // 送信先のベースURL（/receive にメールデータを送信）
+}
+
+// route は件名の正規表現をコンパイル済みのルールです
+type route struct {
+	RoutingRule
+	subject *regexp.Regexp
+}
+
+// routes は上から順に評価するルール。空の場合はすべて AUTOPILOT_URL に送信します
+var routes []route
+
+// ConfigureRouting は送信先のルール（JSONの配列）を設定します。空文字の場合はすべて AUTOPILOT_URL に送信します
+func ConfigureRouting(rulesJSON string) error {
+	routes = nil
+	if strings.TrimSpace(rulesJSON) == "" {
+		return nil
+	}
+
+	var rules []RoutingRule
+	if err := json.Unmarshal([]byte(rulesJSON), &rules); err != nil {
+		return fmt.Errorf("invalid routing rules: %v", err)
+	}
+	for i, rule := range rules {
+		if rule.Target == "" {
+			return fmt.Errorf("routing rule %d (%s) has no target", i, rule.Name)
+		}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%d", i)
+		}
+		rule.SenderDomain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(rule.SenderDomain), "@"))
+		rule.To = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(rule.To), "@"))
+
+		r := route{RoutingRule: rule}
+		if rule.Subject != "" {
+			subject, err := regexp.Compile(rule.Subject)
+			if err != nil {
+				return fmt.Errorf("routing rule %d (%s) has an invalid subject pattern: %v", i, rule.Name, err)
+			}
+			r.subject = subject
+		}
+		routes = append(routes, r)
+	}
+	return nil
+}
+
+// routeFor はメールに最初に一致したルールの名前と送信先を返します。一致しない場合は AUTOPILOT_URL を返します
+func (h *EmailHandler) routeFor(emailData *models.EmailData) (name, target string) {
+	for _, r := range routes {
+		if r.matches(emailData) {
+			return r.Name, r.Target
+		}
+	}
+	return defaultRoute, h.cfg.AutopilotURL
+}
+
+func (r *route) matches(emailData *models.EmailData) bool {
+	if r.SenderDomain != "" && !matchSender(senderAddress(emailData.From), []string{r.SenderDomain}) {
+		return false
+	}
+	if r.To != "" && !matchRecipient(emailData, r.To) {
+		return false
+	}
+	if r.subject != nil && !r.subject.MatchString(emailData.Subject) {
+		return false
+	}
+	return true
+}
+
+// matchRecipient は To・CC のいずれかのアドレスがルール（アドレス、ドメイン、またはそのサブドメイン）に一致するかを返します
+func matchRecipient(emailData *models.EmailData, rule string) bool {
+	for _, header := range []string{emailData.To, emailData.CC} {
+		if header == "" {
+			continue
+		}
+		addresses, err := mail.ParseAddressList(header)
+		if err != nil {
+			// 表示名がデコード済みでパースできない場合は区切りごとに取り出す
+			for _, part := range strings.Split(header, ",") {
+				if matchSender(senderAddress(part), []string{rule}) {
+					return true
+				}
+			}
+			continue
+		}
+		for _, addr := range addresses {
+			if matchSender(strings.ToLower(addr.Address), []string{rule}) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/mail"
+	"strings"
+
+	"go.uber.org/zap"
+	"mailconvertor/logger"
+	"mailconvertor/metrics"
+	"mailconvertor/models"
+)
+
+// 送信者のルールで拒否した理由
+const (
+	senderBlocked    = "blocked"     // 拒否リストに一致
+	senderNotAllowed = "not_allowed" // 許可リストに一致しない
+)
+
+var senderRejectedTotal = metrics.NewCounterVec("mailconvertor_sender_rejected_total",
+	"送信者のドメインの許可・拒否リストで受け付けなかったメールの件数", "reason")
+
+var (
+	// senderAllowlist が空でない場合、一致する送信者のメールだけを受け付けます
+	senderAllowlist []string
+	// senderBlocklist に一致する送信者のメールは受け付けません（許可リストより優先）
+	senderBlocklist []string
+)
+
+// ConfigureSenderRules は送信者の許可・拒否リストを設定します。
+// 要素はドメイン（サブドメインにも一致）またはメールアドレスで、大文字・小文字は区別しません
+func ConfigureSenderRules(allow, block []string) {
+	senderAllowlist = normalizeSenderRules(allow)
+	senderBlocklist = normalizeSenderRules(block)
+}
+
+func normalizeSenderRules(rules []string) []string {
+	var normalized []string
+	for _, rule := range rules {
+		if rule = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(rule), "@")); rule != "" {
+			normalized = append(normalized, rule)
+		}
+	}
+	return normalized
+}
+
+// checkSender は送信者がルールで受け付けられるかを判定し、受け付けない場合は理由を返します（受け付ける場合は空文字）
+func checkSender(emailData *models.EmailData, messageID string) string {
+	if len(senderAllowlist) == 0 && len(senderBlocklist) == 0 {
+		return ""
+	}
+
+	address := senderAddress(emailData.From)
+	reason := ""
+	switch {
+	case matchSender(address, senderBlocklist):
+		reason = senderBlocked
+	case len(senderAllowlist) > 0 && !matchSender(address, senderAllowlist):
+		reason = senderNotAllowed
+	default:
+		return ""
+	}
+
+	senderRejectedTotal.Inc(reason)
+	logger.Logger.Warn("送信者のルールによりメールを受け付けませんでした",
+		zap.String("messageId", messageID),
+		zap.String("from", address),
+		zap.String("reason", reason))
+	return reason
+}
+
+// senderAddress は From ヘッダーからメールアドレスを小文字で取り出します
+func senderAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	// 表示名がデコード済みでパースできない場合は山括弧内を使う
+	if start := strings.LastIndex(from, "<"); start >= 0 {
+		if end := strings.Index(from[start:], ">"); end > 0 {
+			return strings.ToLower(strings.TrimSpace(from[start+1 : start+end]))
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(from))
+}
+
+// matchSender はアドレスがいずれかのルール（アドレス、ドメイン、またはそのサブドメイン）に一致するかを返します
+func matchSender(address string, rules []string) bool {
+	_, domain, found := strings.Cut(address, "@")
+	if !found {
+		return false
+	}
+	for _, rule := range rules {
+		if strings.Contains(rule, "@") {
+			if address == rule {
+				return true
+			}
+			continue
+		}
+		if domain == rule || strings.HasSuffix(domain, "."+rule) {
+			return true
+		}
+	}
+	return false
+}
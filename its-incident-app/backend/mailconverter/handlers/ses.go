@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"mailconvertor/logger"
+	"mailconvertor/models"
+	"mailconvertor/storage"
+)
+
+// maxSNSMessageSize はSNSのメッセージの上限（SNSの上限 256KB に余裕を持たせる）
+const maxSNSMessageSize = 512 * 1024
+
+var (
+	// sesTopicARNs は受け付けるSNSトピックのARN。空の場合はSESの受信通知を受け付けません
+	sesTopicARNs map[string]bool
+	// sesObjects はS3に保存されたメールの読み取り元
+	sesObjects *storage.S3Reader
+)
+
+// ConfigureSES はSESの受信通知として受け付けるSNSトピックと、S3に保存されたメールの読み取り元を設定します
+func ConfigureSES(topicARNs []string, reader *storage.S3Reader) {
+	sesTopicARNs = make(map[string]bool, len(topicARNs))
+	for _, arn := range topicARNs {
+		sesTopicARNs[arn] = true
+	}
+	sesObjects = reader
+}
+
+// sesNotification はSESの受信ルールのSNSアクション・S3アクションが送信する通知です
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	Mail             struct {
+		MessageID   string   `json:"messageId"`
+		Source      string   `json:"source"`
+		Destination []string `json:"destination"`
+	} `json:"mail"`
+	Receipt struct {
+		SpamVerdict  sesVerdict `json:"spamVerdict"`
+		VirusVerdict sesVerdict `json:"virusVerdict"`
+		Action       struct {
+			Type       string `json:"type"`
+			Encoding   string `json:"encoding"`
+			BucketName string `json:"bucketName"`
+			ObjectKey  string `json:"objectKey"`
+		} `json:"action"`
+	} `json:"receipt"`
+	// Content はSNSアクションの場合のメールの生データ（Encoding が BASE64 の場合はBase64）
+	Content string `json:"content"`
+}
+
+type sesVerdict struct {
+	Status string `json:"status"`
+}
+
+// HandleSESNotification はAmazon SES（SNS経由）の受信通知を処理します。
+// SNSの署名とトピックを検証し、購読確認にはSubscribeURLへのアクセスで応答します。
+// 受信通知はメールの生データ（通知に含まれる、またはS3に保存されたもの）を /receive と同じ処理に渡します
+func (h *EmailHandler) HandleSESNotification(c *gin.Context) {
+	log := logger.Logger
+
+	var msg SNSMessage
+	if err := json.NewDecoder(io.LimitReader(c.Request.Body, maxSNSMessageSize)).Decode(&msg); err != nil {
+		log.Warn("SNSメッセージのデコードに失敗しました", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, models.ErrorInvalidRequest, "Invalid SNS message", "", err))
+		return
+	}
+
+	logFields := []zap.Field{
+		zap.String("snsMessageId", msg.MessageID),
+		zap.String("type", msg.Type),
+		zap.String("topicArn", msg.TopicArn),
+	}
+
+	if !sesTopicARNs[msg.TopicArn] {
+		log.Warn("許可されていないSNSトピックからのメッセージです", logFields...)
+		c.JSON(http.StatusForbidden, gin.H{"error": "topic is not allowed", "code": http.StatusForbidden})
+		return
+	}
+	if err := msg.Verify(c.Request.Context()); err != nil {
+		log.Warn("SNSメッセージの署名検証に失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusForbidden, gin.H{"error": "invalid SNS signature", "code": http.StatusForbidden})
+		return
+	}
+
+	switch msg.Type {
+	case "SubscriptionConfirmation":
+		if err := msg.ConfirmSubscription(c.Request.Context()); err != nil {
+			log.Error("SNSの購読確認に失敗しました", append(logFields, zap.Error(err))...)
+			c.JSON(http.StatusBadGateway, errorResponse(http.StatusBadGateway, models.ErrorUpstream, "Failed to confirm subscription", msg.MessageID, err))
+			return
+		}
+		log.Info("SNSの購読を確認しました", logFields...)
+		c.JSON(http.StatusOK, createResponse("success", http.StatusOK, "Subscription confirmed", msg.MessageID))
+		return
+	case "UnsubscribeConfirmation":
+		log.Warn("SNSの購読が解除されました", logFields...)
+		c.Status(http.StatusOK)
+		return
+	case "Notification":
+	default:
+		log.Warn("未対応のSNSメッセージの種類です", logFields...)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	var notification sesNotification
+	if err := json.Unmarshal([]byte(msg.Message), &notification); err != nil {
+		log.Error("SESの受信通知のデコードに失敗しました", append(logFields, zap.Error(err))...)
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, models.ErrorInvalidRequest, "Invalid SES notification", msg.MessageID, err))
+		return
+	}
+	if notification.NotificationType != "Received" {
+		log.Info("受信以外のSES通知のため無視します",
+			append(logFields, zap.String("notificationType", notification.NotificationType))...)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	// SESのメッセージIDをそのまま使い、SNSの再送は autopilot の重複排除に任せる
+	messageID := "ses-" + notification.Mail.MessageID
+	action := notification.Receipt.Action
+	log.Info("SESの受信通知を受け取りました",
+		append(logFields,
+			zap.String("messageId", messageID),
+			zap.String("source", notification.Mail.Source),
+			zap.Strings("destination", notification.Mail.Destination),
+			zap.String("action", action.Type),
+			zap.String("spamVerdict", notification.Receipt.SpamVerdict.Status),
+			zap.String("virusVerdict", notification.Receipt.VirusVerdict.Status))...)
+
+	switch action.Type {
+	case "SNS":
+		raw := []byte(notification.Content)
+		if strings.EqualFold(action.Encoding, "BASE64") {
+			decoded, err := base64.StdEncoding.DecodeString(notification.Content)
+			if err != nil {
+				log.Error("SESのメール内容のデコードに失敗しました", append(logFields, zap.Error(err))...)
+				c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, models.ErrorInvalidRequest, "Invalid SES content", messageID, err))
+				return
+			}
+			raw = decoded
+		}
+		if len(raw) == 0 {
+			err := fmt.Errorf("notification has no content")
+			log.Error("SESの受信通知にメールの内容がありません", logFields...)
+			c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, models.ErrorEmptyBody, "SES notification has no content", messageID, err))
+			return
+		}
+		h.receiveEmail(c, messageID, bytes.NewReader(raw), int64(len(raw)))
+
+	case "S3":
+		if sesObjects == nil {
+			err := fmt.Errorf("S3 reader is not configured")
+			log.Error("S3に保存されたメールを読み取れません", logFields...)
+			c.JSON(http.StatusInternalServerError, errorResponse(http.StatusInternalServerError, models.ErrorInternal, "S3 is not configured", messageID, err))
+			return
+		}
+		object, size, err := sesObjects.Open(c.Request.Context(), action.BucketName, action.ObjectKey)
+		if err != nil {
+			log.Error("S3からのメールの取得に失敗しました",
+				append(logFields, zap.String("bucket", action.BucketName), zap.String("key", action.ObjectKey), zap.Error(err))...)
+			// SNSに再送させるため 5xx を返す
+			c.JSON(http.StatusBadGateway, errorResponse(http.StatusBadGateway, models.ErrorUpstream, "Failed to fetch email from S3", messageID, err))
+			return
+		}
+		defer object.Close()
+		if size > maxMessageSize {
+			rejectTooLarge(c, messageID, size)
+			return
+		}
+		h.receiveEmail(c, messageID, http.MaxBytesReader(c.Writer, object, maxMessageSize), size)
+
+	default:
+		err := fmt.Errorf("unsupported receipt action %q", action.Type)
+		log.Error("未対応のSESの受信アクションです", append(logFields, zap.String("action", action.Type))...)
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, models.ErrorInvalidRequest, "Unsupported SES receipt action", messageID, err))
+	}
+}
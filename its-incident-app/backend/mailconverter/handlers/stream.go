@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jaytaylor/html2text"
+	"go.uber.org/zap"
+	"golang.org/x/text/encoding/htmlindex"
+	"mailconvertor/logger"
+	"mailconvertor/models"
+)
+
+const (
+	// maxStreamHeaderSize はストリーミングで読み取るヘッダーの上限（RawHeader に保持）
+	maxStreamHeaderSize = 256 * 1024
+	// maxStreamBodySize はストリーミングで読み取る本文（text/plain・text/html）の上限
+	maxStreamBodySize = 1024 * 1024
+	// maxMultipartDepth は入れ子のマルチパートをたどる深さの上限
+	maxMultipartDepth = 10
+)
+
+// headerDecoder はエンコードされたヘッダー（=?ISO-2022-JP?B?...?= など）をUTF-8に変換します
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// streamState はストリーミングでのパースの途中結果です
+type streamState struct {
+	ctx         context.Context
+	messageID   string
+	text        strings.Builder
+	html        strings.Builder
+	attachments []models.Attachment
+	remaining   int // 添付ファイルの内容として含められる残りのバイト数
+	calendar    []byte
+	calCharset  string
+	images      map[string]inlineImage // HTML本文に埋め込むインライン画像（Content-ID ごと）
+	embedded    int                    // 埋め込むインライン画像の合計のバイト数
+}
+
+// ParseEmailStream は大きなメールをメモリに読み込まずにパースします。
+// 本文は上限まで読み取り、添付ファイルはCloud Storage（設定時）に直接送信して情報と
+// テキスト形式の内容（上限まで）だけを残します。RawHeader にはヘッダーを保持します
+func ParseEmailStream(ctx context.Context, messageID string, r io.Reader) (*models.EmailData, error) {
+	log := logger.Logger
+
+	reader := bufio.NewReader(r)
+	rawHeader, err := readRawHeader(reader)
+	if err != nil {
+		log.Error("ヘッダーの読み取りに失敗しました", zap.Error(err))
+		return nil, fmt.Errorf("failed to read message header: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(rawHeader))
+	if err != nil {
+		log.Error("ヘッダーのパースに失敗しました", zap.Error(err))
+		return nil, fmt.Errorf("failed to parse message header: %v", err)
+	}
+
+	header := func(name string) string {
+		value := msg.Header.Get(name)
+		if decoded, err := headerDecoder.DecodeHeader(value); err == nil {
+			return decoded
+		}
+		return value
+	}
+	emailData := &models.EmailData{
+		From:                    header("From"),
+		To:                      header("To"),
+		Subject:                 header("Subject"),
+		Date:                    header("Date"),
+		OriginalMessageID:       header("Message-ID"),
+		MIMEVersion:             header("MIME-Version"),
+		ContentType:             header("Content-Type"),
+		ContentTransferEncoding: header("Content-Transfer-Encoding"),
+		CC:                      header("CC"),
+		InReplyTo:               header("In-Reply-To"),
+		References:              header("References"),
+		RawHeader:               rawHeader,
+	}
+
+	state := &streamState{ctx: ctx, messageID: messageID, remaining: maxAttachmentTextTotal, images: map[string]inlineImage{}}
+	if err := state.walk(textproto.MIMEHeader(msg.Header), reader, 0); err != nil {
+		log.Error("MIMEメッセージのパースに失敗しました", zap.Error(err))
+		return nil, fmt.Errorf("failed to parse MIME message: %v", err)
+	}
+
+	emailData.Body = state.text.String()
+	if emailData.Body == "" && state.html.Len() > 0 {
+		text, err := html2text.FromString(state.html.String())
+		if err != nil {
+			log.Warn("HTML本文のテキスト変換に失敗しました", zap.Error(err))
+			text = state.html.String()
+		}
+		emailData.Body = text
+	}
+	emailData.HTMLBody = rewriteInlineImages(state.html.String(), state.images)
+	emailData.Attachments = state.attachments
+	for _, attachment := range state.attachments {
+		if !attachment.Inline {
+			emailData.FileName = attachment.FileName
+			break
+		}
+	}
+	if state.calendar != nil {
+		applyCalendar(emailData, state.calendar, state.calCharset)
+	}
+
+	log.Debug("メールのストリーミングパースが完了しました",
+		zap.String("messageId", emailData.OriginalMessageID),
+		zap.String("from", emailData.From),
+		zap.String("subject", emailData.Subject),
+		zap.Int("attachments", len(state.attachments)),
+	)
+	return emailData, nil
+}
+
+// readRawHeader は空行までのヘッダーを読み取ります（空行を含む）。本文のないメールは空行を補います
+func readRawHeader(reader *bufio.Reader) ([]byte, error) {
+	var header bytes.Buffer
+	for {
+		line, err := reader.ReadSlice('\n')
+		header.Write(line)
+		if header.Len() > maxStreamHeaderSize {
+			return nil, fmt.Errorf("header exceeds %d bytes", maxStreamHeaderSize)
+		}
+		switch {
+		case err == io.EOF:
+			header.WriteString("\r\n")
+			return header.Bytes(), nil
+		case err == bufio.ErrBufferFull:
+			continue
+		case err != nil:
+			return nil, err
+		case len(bytes.TrimRight(line, "\r\n")) == 0:
+			return header.Bytes(), nil
+		}
+	}
+}
+
+// walk はパートをたどり、本文と添付ファイルを取り出します
+func (s *streamState) walk(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMultipartDepth {
+			return fmt.Errorf("multipart nesting exceeds %d levels", maxMultipartDepth)
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := s.walk(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	content := decodeTransfer(header.Get("Content-Transfer-Encoding"), body)
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	fileName := dispositionParams["filename"]
+	if fileName == "" {
+		fileName = params["name"]
+	}
+	if decoded, err := headerDecoder.DecodeHeader(fileName); err == nil {
+		fileName = decoded
+	}
+
+	// カレンダー招待は予定を取り出すために読み取り、読み取った分を戻して本文・添付ファイルとしても扱う
+	if s.calendar == nil && isCalendarPart(mediaType, fileName) {
+		head, err := io.ReadAll(io.LimitReader(content, maxCalendarSize+1))
+		if err != nil {
+			return err
+		}
+		s.calendar, s.calCharset = head, params["charset"]
+		content = io.MultiReader(bytes.NewReader(head), content)
+	}
+
+	// cid: で参照される画像は本文に埋め込むために読み取り、添付ファイルとしても保存する
+	if contentID := strings.Trim(strings.TrimSpace(header.Get("Content-ID")), "<>"); isInlineImage(mediaType, contentID) {
+		return s.inlineImage(fileName, mediaType, contentID, disposition == "attachment", content)
+	}
+
+	switch {
+	case disposition == "attachment" || (fileName != "" && disposition != "inline"):
+		return s.attachment(fileName, mediaType, content)
+	case mediaType == "text/plain" && s.text.Len() == 0:
+		return readText(&s.text, content, params["charset"])
+	case mediaType == "text/html" && s.html.Len() == 0:
+		return readText(&s.html, content, params["charset"])
+	}
+	// インライン画像など本文・添付ファイル以外のパートは読み飛ばす
+	_, err = io.Copy(io.Discard, content)
+	return err
+}
+
+// attachment は添付ファイルをCloud Storageに送信し、サイズとテキスト形式の内容（上限まで）を記録します
+func (s *streamState) attachment(fileName, contentType string, content io.Reader) error {
+	index := len(s.attachments)
+	attachment := models.Attachment{FileName: fileName, ContentType: contentType}
+
+	counter := &countingReader{r: content}
+	var captured bytes.Buffer
+	var source io.Reader = counter
+	if s.remaining > 0 && isTextContent(contentType, fileName) {
+		limit := min(maxAttachmentText, s.remaining)
+		// 切り詰めの判定のため上限より1バイト多く記録する
+		source = io.TeeReader(counter, &limitedWriter{w: &captured, remaining: limit + 1})
+	}
+
+	if attachmentStore != nil {
+		uri, err := attachmentStore.Upload(s.ctx, attachmentObjectName(s.messageID, index, fileName), contentType, source)
+		if err != nil {
+			logger.Logger.Error("添付ファイルの保存に失敗しました",
+				zap.String("messageId", s.messageID),
+				zap.String("fileName", fileName),
+				zap.Error(err))
+		}
+		attachment.GCSURI = uri
+	}
+	// 送信しなかった（または途中で失敗した）残りを読み切ってサイズを確定する
+	if _, err := io.Copy(io.Discard, source); err != nil {
+		return err
+	}
+	attachment.Size = int(counter.n)
+
+	if captured.Len() > 0 {
+		limit := min(maxAttachmentText, s.remaining)
+		text, truncated := truncateUTF8(captured.Bytes(), limit)
+		if utf8.ValidString(text) {
+			attachment.Content, attachment.Truncated = text, truncated
+			s.remaining -= len(text)
+		}
+	}
+	s.attachments = append(s.attachments, attachment)
+	return nil
+}
+
+// inlineImage はインライン画像を添付ファイルとして保存し、埋め込みの上限までは内容を保持します
+func (s *streamState) inlineImage(fileName, contentType, contentID string, attached bool, content io.Reader) error {
+	budget := max(maxInlineEmbedTotal-s.embedded, 0)
+	head, err := io.ReadAll(io.LimitReader(content, int64(budget)+1))
+	if err != nil {
+		return err
+	}
+	id := normalizeContentID(contentID)
+	if _, ok := s.images[id]; !ok && len(head) <= budget {
+		s.images[id] = inlineImage{contentType: contentType, data: head}
+		s.embedded += len(head)
+	}
+
+	if err := s.attachment(fileName, contentType, io.MultiReader(bytes.NewReader(head), content)); err != nil {
+		return err
+	}
+	attachment := &s.attachments[len(s.attachments)-1]
+	attachment.ContentID = contentID
+	attachment.Inline = !attached
+	return nil
+}
+
+// decodeTransfer はContent-Transfer-Encodingに応じてデコードするReaderを返します
+// （multipart.Reader は quoted-printable を自動でデコードし、ヘッダーを取り除きます）
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// readText は本文を上限まで読み取り、charset からUTF-8に変換して dst に書き込みます。上限を超えた分は読み飛ばします
+func readText(dst *strings.Builder, content io.Reader, charset string) error {
+	raw, err := io.ReadAll(io.LimitReader(content, maxStreamBodySize))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		return err
+	}
+
+	decoded, err := charsetReader(charset, bytes.NewReader(raw))
+	if err != nil {
+		dst.Write(raw)
+		return nil
+	}
+	text, err := io.ReadAll(decoded)
+	if err != nil {
+		dst.Write(raw)
+		return nil
+	}
+	dst.Write(text)
+	return nil
+}
+
+// charsetReader は charset（ISO-2022-JP・Shift_JIS など）からUTF-8に変換するReaderを返します
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return input, nil
+	}
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return encoding.NewDecoder().Reader(input), nil
+}
+
+// countingReader は読み取ったバイト数を数えます
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// limitedWriter は remaining バイトまで書き込み、それ以降は捨てます（エラーにはしない）
+type limitedWriter struct {
+	w         io.Writer
+	remaining int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.remaining > 0 {
+		chunk := p
+		if len(chunk) > l.remaining {
+			chunk = chunk[:l.remaining]
+		}
+		l.remaining -= len(chunk)
+		if _, err := l.w.Write(chunk); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,292 @@
+// Package ics はメールのカレンダー招待（text/calendar、RFC 5545）から予定を取り出します。
+// ベンダーのメンテナンスの告知は予定として届くことが多いため、開始・終了・件名だけを扱います
+package ics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event はカレンダーの予定（VEVENT）です
+type Event struct {
+	UID      string
+	Summary  string
+	Location string
+	Start    time.Time
+	End      time.Time
+	AllDay   bool
+	Status   string // TENTATIVE / CONFIRMED / CANCELLED
+}
+
+// Calendar はカレンダー（VCALENDAR）です
+type Calendar struct {
+	Method string // REQUEST（告知・更新）/ CANCEL（中止）など
+	Events []Event
+}
+
+// defaultLocation は TZID がないか解釈できない場合の時刻のタイムゾーン。
+// Outlook は "Tokyo Standard Time" のようなWindowsのタイムゾーン名を使うため、国内の告知は日本時間とみなします
+var defaultLocation = time.FixedZone("JST", 9*60*60)
+
+// windowsZones はWindowsのタイムゾーン名（Outlookの TZID）とIANAのタイムゾーン名の対応
+var windowsZones = map[string]string{
+	"Tokyo Standard Time":            "Asia/Tokyo",
+	"UTC":                            "UTC",
+	"GMT Standard Time":              "Europe/London",
+	"Pacific Standard Time":          "America/Los_Angeles",
+	"Eastern Standard Time":          "America/New_York",
+	"China Standard Time":            "Asia/Shanghai",
+	"Korea Standard Time":            "Asia/Seoul",
+	"Singapore Standard Time":        "Asia/Singapore",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Central Standard Time":          "America/Chicago",
+	"AUS Eastern Standard Time":      "Australia/Sydney",
+	"India Standard Time":            "Asia/Kolkata",
+	"Taipei Standard Time":           "Asia/Taipei",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"Romance Standard Time":          "Europe/Paris",
+	"Mountain Standard Time":         "America/Denver",
+	"SE Asia Standard Time":          "Asia/Bangkok",
+	"Coordinated Universal Time":     "UTC",
+	"Greenwich Standard Time":        "Atlantic/Reykjavik",
+	"E. Australia Standard Time":     "Australia/Brisbane",
+	"New Zealand Standard Time":      "Pacific/Auckland",
+	"Hawaiian Standard Time":         "Pacific/Honolulu",
+	"Alaskan Standard Time":          "America/Anchorage",
+	"US Mountain Standard Time":      "America/Phoenix",
+	"Arabian Standard Time":          "Asia/Dubai",
+	"Russian Standard Time":          "Europe/Moscow",
+	"South Africa Standard Time":     "Africa/Johannesburg",
+	"E. South America Standard Time": "America/Sao_Paulo",
+}
+
+// durationPattern は DURATION の値（P1D、PT2H30M、P1W など）
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// property はコンテンツ行（NAME;PARAM=VALUE:値）です
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse はカレンダーをパースします。開始時刻のない予定は含めません
+func Parse(data []byte) (*Calendar, error) {
+	calendar := &Calendar{}
+	var event *Event
+	var duration time.Duration
+	var hasDuration bool
+	inCalendar := false
+
+	for _, line := range unfold(data) {
+		prop, ok := parseLine(line)
+		if !ok {
+			continue
+		}
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VCALENDAR"):
+			inCalendar = true
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VEVENT"):
+			event, duration, hasDuration = &Event{}, 0, false
+		case prop.name == "END" && strings.EqualFold(prop.value, "VEVENT"):
+			if event != nil && !event.Start.IsZero() {
+				switch {
+				case !event.End.IsZero():
+				case hasDuration:
+					event.End = event.Start.Add(duration)
+				case event.AllDay:
+					event.End = event.Start.AddDate(0, 0, 1)
+				default:
+					event.End = event.Start
+				}
+				calendar.Events = append(calendar.Events, *event)
+			}
+			event = nil
+		case event == nil:
+			// 予定の外は METHOD のみ扱う（VTIMEZONE などの入れ子の要素は読み飛ばす）
+			if prop.name == "METHOD" && inCalendar {
+				calendar.Method = strings.ToUpper(prop.value)
+			}
+		case prop.name == "UID":
+			event.UID = prop.value
+		case prop.name == "SUMMARY":
+			event.Summary = unescapeText(prop.value)
+		case prop.name == "LOCATION":
+			event.Location = unescapeText(prop.value)
+		case prop.name == "STATUS":
+			event.Status = strings.ToUpper(prop.value)
+		case prop.name == "DTSTART":
+			t, allDay, err := parseTime(prop)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DTSTART: %v", err)
+			}
+			event.Start, event.AllDay = t, allDay
+		case prop.name == "DTEND":
+			t, _, err := parseTime(prop)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DTEND: %v", err)
+			}
+			event.End = t
+		case prop.name == "DURATION":
+			d, err := parseDuration(prop.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DURATION: %v", err)
+			}
+			duration, hasDuration = d, true
+		}
+	}
+
+	if !inCalendar {
+		return nil, fmt.Errorf("VCALENDAR not found")
+	}
+	return calendar, nil
+}
+
+// unfold は折り返された行（次の行が空白で始まる）を1行に戻します
+func unfold(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseLine はコンテンツ行を名前・パラメーター・値に分けます。値の区切りの ':' は引用符の外の最初のものです
+func parseLine(line string) (property, bool) {
+	inQuote := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuote = !inQuote
+		} else if r == ':' && !inQuote {
+			colon = i
+			break
+		}
+	}
+	if colon <= 0 {
+		return property{}, false
+	}
+
+	segments := splitParams(line[:colon])
+	prop := property{
+		name:   strings.ToUpper(segments[0]),
+		params: map[string]string{},
+		value:  line[colon+1:],
+	}
+	for _, segment := range segments[1:] {
+		if key, value, ok := strings.Cut(segment, "="); ok {
+			prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return prop, true
+}
+
+// splitParams は名前とパラメーターを引用符の外の ';' で分けます
+func splitParams(s string) []string {
+	var segments []string
+	inQuote := false
+	start := 0
+	for i, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case r == ';' && !inQuote:
+			segments = append(segments, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(segments, s[start:])
+}
+
+// parseTime は DTSTART・DTEND の値を解釈します。日付のみ（VALUE=DATE）の場合は allDay を true で返します
+func parseTime(prop property) (t time.Time, allDay bool, err error) {
+	value := strings.TrimSpace(prop.value)
+	if strings.EqualFold(prop.params["VALUE"], "DATE") || len(value) == len("20060102") {
+		t, err = time.ParseInLocation("20060102", value, location(prop.params["TZID"]))
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	t, err = time.ParseInLocation("20060102T150405", value, location(prop.params["TZID"]))
+	return t, false, err
+}
+
+// location は TZID のタイムゾーンを返します。IANA・Windowsのどちらの名前でもない場合は日本時間とみなします
+func location(tzid string) *time.Location {
+	tzid = strings.TrimPrefix(tzid, "/")
+	if tzid == "" {
+		return defaultLocation
+	}
+	if name, ok := windowsZones[tzid]; ok {
+		tzid = name
+	}
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+	return defaultLocation
+}
+
+// parseDuration は DURATION の値（ISO 8601 の期間）を解釈します
+func parseDuration(value string) (time.Duration, error) {
+	m := durationPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("unsupported duration %q", value)
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+2])
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(n) * unit
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+// unescapeText はテキストの値のエスケープ（\n、\,、\;、\\）を戻します
+func unescapeText(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range value {
+		if escaped {
+			switch r {
+			case 'n', 'N':
+				b.WriteRune('\n')
+			default:
+				b.WriteRune(r)
+			}
+			escaped = false
+			continue
+		}
+		if r == '\\' {
+			escaped = true
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package imap
+
+import (
+	"context"
+	"time"
+
+	"mailconvertor/datastore"
+)
+
+// checkpointKind は取り込み済みの位置を保存するエンティティの種類（キーの名前はメールボックス）
+const checkpointKind = "ImapCheckpoint"
+
+// Checkpoint はメールボックスのどこまで取り込んだかを表します。UIDValidity が変わった場合は LastUID は無効です
+type Checkpoint struct {
+	UIDValidity uint32
+	LastUID     uint32
+}
+
+// CheckpointStore は取り込み済みの位置をDatastoreに保存します
+type CheckpointStore struct {
+	client *datastore.Client
+}
+
+// NewCheckpointStore は client に位置を保存するCheckpointStoreを作成します
+func NewCheckpointStore(client *datastore.Client) *CheckpointStore {
+	return &CheckpointStore{client: client}
+}
+
+// Load はメールボックスの位置を返します。保存されていない場合は nil を返します
+func (s *CheckpointStore) Load(ctx context.Context, mailbox string) (*Checkpoint, error) {
+	entity, err := s.client.Lookup(ctx, s.client.Key(checkpointKind, mailbox))
+	if err != nil || entity == nil {
+		return nil, err
+	}
+	return &Checkpoint{
+		UIDValidity: uint32(entity.Integer("uid_validity")),
+		LastUID:     uint32(entity.Integer("last_uid")),
+	}, nil
+}
+
+// Save はメールボックスの位置を保存します
+func (s *CheckpointStore) Save(ctx context.Context, mailbox string, checkpoint Checkpoint) error {
+	return s.client.Upsert(ctx, &datastore.Entity{
+		Key: s.client.Key(checkpointKind, mailbox),
+		Properties: map[string]datastore.Value{
+			"uid_validity": datastore.Integer(int64(checkpoint.UIDValidity)),
+			"last_uid":     datastore.Integer(int64(checkpoint.LastUID)),
+			"updated_at":   datastore.Timestamp(time.Now()),
+		},
+	})
+}
@@ -0,0 +1,226 @@
+package imap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commandTimeout は1コマンドの応答を待つ時間
+const commandTimeout = 2 * time.Minute
+
+// response は応答の1行です。リテラル（{n} に続くデータ）は literals に入ります
+type response struct {
+	line     string
+	literals [][]byte
+}
+
+// client はポーリングに必要なコマンドだけを実装したIMAP4rev1のクライアントです
+type client struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	tag        int
+	maxLiteral int64
+}
+
+// dial はIMAPサーバーに接続し、挨拶を読み取ります
+func dial(host string, port int, useTLS bool, maxLiteral int64) (*client, error) {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", address, err)
+	}
+
+	c := &client{conn: conn, reader: bufio.NewReader(conn), maxLiteral: maxLiteral}
+	conn.SetDeadline(time.Now().Add(commandTimeout))
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting.line, "* OK") && !strings.HasPrefix(greeting.line, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting: %s", greeting.line)
+	}
+	return c, nil
+}
+
+// close はログアウトして接続を閉じます
+func (c *client) close() {
+	c.command("LOGOUT")
+	c.conn.Close()
+}
+
+// login はユーザー名とパスワードで認証します
+func (c *client) login(username, password string) error {
+	_, err := c.command("LOGIN " + quote(username) + " " + quote(password))
+	return err
+}
+
+// selectFolder はフォルダーを開き、UIDVALIDITY と UIDNEXT（返されない場合は 0）を返します
+func (c *client) selectFolder(folder string) (uidValidity, uidNext uint32, err error) {
+	responses, err := c.command("SELECT " + quote(folder))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, r := range responses {
+		if v, ok := responseCode(r.line, "UIDVALIDITY"); ok {
+			uidValidity = v
+		}
+		if v, ok := responseCode(r.line, "UIDNEXT"); ok {
+			uidNext = v
+		}
+	}
+	if uidValidity == 0 {
+		return 0, 0, fmt.Errorf("server did not return UIDVALIDITY for %s", folder)
+	}
+	return uidValidity, uidNext, nil
+}
+
+// search は UID SEARCH の結果のUIDを返します
+func (c *client) search(criteria string) ([]uint32, error) {
+	responses, err := c.command("UID SEARCH " + criteria)
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, r := range responses {
+		if !strings.HasPrefix(r.line, "* SEARCH") {
+			continue
+		}
+		for _, field := range strings.Fields(strings.TrimPrefix(r.line, "* SEARCH")) {
+			if uid, err := strconv.ParseUint(field, 10, 32); err == nil {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	return uids, nil
+}
+
+// fetchRaw はメールの生データ（RFC822）を既読にせずに取得します
+func (c *client) fetchRaw(uid uint32) ([]byte, error) {
+	responses, err := c.command(fmt.Sprintf("UID FETCH %d (BODY.PEEK[])", uid))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range responses {
+		if strings.Contains(r.line, " FETCH ") && len(r.literals) > 0 {
+			return r.literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("message uid %d not found", uid)
+}
+
+// markSeen はメールに既読（\Seen）を付けます
+func (c *client) markSeen(uid uint32) error {
+	_, err := c.command(fmt.Sprintf("UID STORE %d +FLAGS.SILENT (\\Seen)", uid))
+	return err
+}
+
+// command はコマンドを送信し、タグ付きの応答までの応答を返します。OK 以外の場合はエラーを返します
+func (c *client) command(command string) ([]response, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	c.conn.SetDeadline(time.Now().Add(commandTimeout))
+	if _, err := io.WriteString(c.conn, tag+" "+command+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	var responses []response
+	for {
+		r, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(r.line, tag+" ") {
+			responses = append(responses, r)
+			continue
+		}
+		status := strings.TrimPrefix(r.line, tag+" ")
+		if !strings.HasPrefix(status, "OK") {
+			verb, _, _ := strings.Cut(command, " ")
+			return nil, fmt.Errorf("%s failed: %s", verb, status)
+		}
+		return responses, nil
+	}
+}
+
+// readResponse は応答を1つ読み取ります。行末が {n} の場合は続く n バイトをリテラルとして読み、行の続きを連結します
+func (c *client) readResponse() (response, error) {
+	var r response
+	var line strings.Builder
+	for {
+		text, err := c.reader.ReadString('\n')
+		if err != nil {
+			return r, err
+		}
+		text = strings.TrimRight(text, "\r\n")
+		line.WriteString(text)
+
+		size, ok := literalSize(text)
+		if !ok {
+			r.line = line.String()
+			return r, nil
+		}
+		if size > c.maxLiteral {
+			return r, fmt.Errorf("literal of %d bytes exceeds the limit of %d bytes", size, c.maxLiteral)
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, literal); err != nil {
+			return r, err
+		}
+		r.literals = append(r.literals, literal)
+	}
+}
+
+// literalSize は行末の {n} からリテラルのバイト数を返します
+func literalSize(line string) (int64, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	open := strings.LastIndex(line, "{")
+	if open < 0 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(strings.TrimSuffix(line[open+1:len(line)-1], "+"), 10, 64)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// responseCode は "* OK [NAME 値]" 形式の応答コードの値を返します
+func responseCode(line, name string) (uint32, bool) {
+	start := strings.Index(line, "["+name+" ")
+	if start < 0 {
+		return 0, false
+	}
+	rest := line[start+len(name)+2:]
+	end := strings.Index(rest, "]")
+	if end < 0 {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(rest[:end]), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(value), true
+}
+
+// quote はIMAPの引用符付き文字列にします
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
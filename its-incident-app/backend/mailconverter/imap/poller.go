@@ -0,0 +1,204 @@
+// Package imap は共有メールボックスをIMAPで定期的に確認し、新着メールを取り込みます（転送の仕組みが用意できない環境向け）。
+// 取り込み済みの位置（UIDVALIDITY と最後のUID）はDatastoreに保存し、再起動後もその続きから取り込みます
+package imap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"mailconvertor/logger"
+	"mailconvertor/secrets"
+
+	"go.uber.org/zap"
+)
+
+// maxIngestAttempts を超えて取り込みに失敗したメールは飛ばす（パースできないメールでポーリングが止まらないように）
+const maxIngestAttempts = 5
+
+// IngestFunc は取得したメールの生データ（RFC822）を取り込みます
+type IngestFunc func(ctx context.Context, messageID string, raw []byte) error
+
+// Config はIMAPのポーリングの設定です。パスワードは IMAP_PASSWORD（sm:// 参照可）から取得します
+type Config struct {
+	Host           string
+	Port           int
+	TLS            bool
+	Username       string
+	Folder         string
+	Interval       time.Duration
+	MarkSeen       bool  // 取り込んだメールに既読を付ける
+	UnseenOnly     bool  // 未読のメールだけを取り込む（初回は既存の未読メールも取り込む）
+	MaxMessageSize int64 // 取得するメールの上限（バイト）
+}
+
+// Poller はIMAPのメールボックスを定期的に確認して新着メールを取り込むワーカーです
+type Poller struct {
+	cfg      Config
+	store    *CheckpointStore
+	ingest   IngestFunc
+	mailbox  string
+	attempts map[uint32]int
+	done     chan struct{}
+}
+
+// NewPoller はIMAPのポーリングワーカーを作成します。ホストが未設定の場合は nil を返します
+func NewPoller(cfg Config, store *CheckpointStore, ingest IngestFunc) *Poller {
+	if cfg.Host == "" {
+		return nil
+	}
+	return &Poller{
+		cfg:      cfg,
+		store:    store,
+		ingest:   ingest,
+		mailbox:  cfg.Username + "@" + net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)) + "/" + cfg.Folder,
+		attempts: map[uint32]int{},
+		done:     make(chan struct{}),
+	}
+}
+
+// Start はポーリングを開始します。ctx がキャンセルされると処理中のメールを終えてから停止します
+func (p *Poller) Start(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	go p.run(ctx)
+}
+
+// Wait はワーカーの停止を待ちます（ctx の期限まで）
+func (p *Poller) Wait(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		logger.Logger.Warn("IMAPのポーリングの停止がタイムアウトしました")
+	}
+}
+
+func (p *Poller) run(ctx context.Context) {
+	defer close(p.done)
+	log := logger.Logger.With(zap.String("mailbox", p.mailbox))
+	log.Info("IMAPのポーリングを開始します", zap.Duration("interval", p.cfg.Interval))
+
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := p.pollOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Error("IMAPのポーリングに失敗しました", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			log.Info("IMAPのポーリングを停止しました")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollOnce はメールボックスに接続し、前回の位置より後のメールを古い順に取り込みます
+func (p *Poller) pollOnce(ctx context.Context) error {
+	log := logger.Logger.With(zap.String("mailbox", p.mailbox))
+
+	checkpoint, err := p.store.Load(ctx, p.mailbox)
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoint: %v", err)
+	}
+
+	c, err := dial(p.cfg.Host, p.cfg.Port, p.cfg.TLS, p.cfg.MaxMessageSize)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.login(p.cfg.Username, secrets.Get("IMAP_PASSWORD")); err != nil {
+		return err
+	}
+	uidValidity, uidNext, err := c.selectFolder(p.cfg.Folder)
+	if err != nil {
+		return err
+	}
+
+	if checkpoint == nil || checkpoint.UIDValidity != uidValidity {
+		// 初回（またはUIDが振り直された場合）は現在の位置から取り込む。未読のみの場合は既存の未読メールも対象にする
+		start := Checkpoint{UIDValidity: uidValidity}
+		if !p.cfg.UnseenOnly {
+			if uidNext == 0 {
+				uids, err := c.search("ALL")
+				if err != nil {
+					return err
+				}
+				for _, uid := range uids {
+					uidNext = max(uidNext, uid+1)
+				}
+			}
+			start.LastUID = max(uidNext, 1) - 1
+		}
+		if checkpoint != nil {
+			log.Warn("UIDVALIDITY が変わったため取り込み位置を初期化します",
+				zap.Uint32("previous", checkpoint.UIDValidity),
+				zap.Uint32("current", uidValidity))
+		}
+		if err := p.store.Save(ctx, p.mailbox, start); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %v", err)
+		}
+		checkpoint = &start
+		log.Info("IMAPの取り込み位置を初期化しました", zap.Uint32("lastUid", start.LastUID))
+	}
+
+	criteria := fmt.Sprintf("UID %d:*", checkpoint.LastUID+1)
+	if p.cfg.UnseenOnly {
+		criteria = "UNSEEN " + criteria
+	}
+	found, err := c.search(criteria)
+	if err != nil {
+		return err
+	}
+	// "n:*" は n より大きいUIDがなくても最大のUIDを返すため除外する
+	var uids []uint32
+	for _, uid := range found {
+		if uid > checkpoint.LastUID {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+	for _, uid := range uids {
+		if ctx.Err() != nil {
+			return nil
+		}
+		messageID := fmt.Sprintf("imap-%d-%d", uidValidity, uid)
+		raw, err := c.fetchRaw(uid)
+		if err == nil {
+			err = p.ingest(ctx, messageID, raw)
+		}
+		if err != nil {
+			p.attempts[uid]++
+			if p.attempts[uid] < maxIngestAttempts {
+				return fmt.Errorf("failed to ingest uid %d: %v", uid, err)
+			}
+			log.Error("IMAPのメールの取り込みを諦めました",
+				zap.String("messageId", messageID),
+				zap.Int("attempts", p.attempts[uid]),
+				zap.Error(err))
+		} else {
+			log.Info("IMAPのメールを取り込みました", zap.String("messageId", messageID), zap.Int("size", len(raw)))
+			if p.cfg.MarkSeen {
+				if err := c.markSeen(uid); err != nil {
+					log.Warn("既読の設定に失敗しました", zap.String("messageId", messageID), zap.Error(err))
+				}
+			}
+		}
+		delete(p.attempts, uid)
+
+		checkpoint.LastUID = uid
+		if err := p.store.Save(ctx, p.mailbox, *checkpoint); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %v", err)
+		}
+	}
+	return nil
+}
@@ -3,12 +3,22 @@ package main
 import (
 	"context"
 	"mailconvertor/config"
+	"mailconvertor/datastore"
+	"mailconvertor/dedup"
+	"mailconvertor/gmail"
 	"mailconvertor/handlers"
+	"mailconvertor/imap"
 	"mailconvertor/logger"
+	"mailconvertor/metrics"
 	"mailconvertor/middleware"
+	"mailconvertor/mtls"
+	"mailconvertor/notify"
+	"mailconvertor/pending"
+	"mailconvertor/storage"
 	"net/http"
 	"os"
 	"os/signal"
+	"shared/idtoken"
 	"syscall"
 	"time"
 
@@ -17,10 +27,132 @@ import (
 )
 
 func main() {
-	_, err := config.InitConfig()
+	cfg, err := config.InitConfig()
 	if err != nil {
 		logger.Logger.Fatal("設定の初期化に失敗しました", zap.Error(err))
 	}
+	if err := cfg.Validate(); err != nil {
+		logger.Logger.Fatal("設定が不正です", zap.Error(err))
+	}
+
+	// 内部サービス呼び出しにクライアント証明書を付与（相互TLSが有効な場合のみ）
+	if err := mtls.InstallTransport(); err != nil {
+		logger.Logger.Fatal("mTLSクライアントの設定に失敗しました", zap.Error(err))
+	}
+
+	// IDトークンを受け付ける場合は呼び出し元のサービスアカウントを限定する（未設定の場合はすべてのIDトークンを拒否）
+	if err := idtoken.CheckConfig(); err != nil {
+		if cfg.Environment == "production" {
+			logger.Logger.Fatal("サービス認証の設定が不正です", zap.Error(err))
+		}
+		logger.Logger.Warn("サービス認証の設定が不正なため、IDトークンをすべて拒否します", zap.Error(err))
+	}
+
+	// 添付ファイルの内容はCloud Storageに保存し、URIだけをautopilotに渡す
+	handlers.ConfigureAttachmentStore(storage.NewUploader(cfg.AttachmentBucket, cfg.AttachmentPrefix))
+	// 受信したメールの生データはパース前に保存し、パースの不具合の調査や再処理に使う
+	handlers.ConfigureRawArchive(storage.NewUploader(cfg.RawArchiveBucket, cfg.RawArchivePrefix))
+	handlers.ConfigureLimits(cfg.MaxMessageSize, cfg.StreamingThreshold)
+	handlers.ConfigureSES(cfg.SESTopicARNs, storage.NewS3Reader(cfg.SESS3Region, cfg.SESS3Endpoint))
+	handlers.ConfigureSenderRules(cfg.SenderAllowlist, cfg.SenderBlocklist)
+	// 送信先のルールに一致するメールはautopilot以外（AI処理を通さない経路など）に送信する
+	if err := handlers.ConfigureRouting(cfg.RoutingRules); err != nil {
+		logger.Logger.Fatal("送信先のルールの設定に失敗しました", zap.Error(err))
+	}
+	// 署名・フッター・引用はAIの利用量と分類の誤りを増やすため、送信前に本文から取り除く
+	if err := handlers.ConfigureBodyNormalization(cfg.BodyNormalize, cfg.BodyRules); err != nil {
+		logger.Logger.Fatal("本文の正規化ルールの設定に失敗しました", zap.Error(err))
+	}
+	// 送信先の設定とHTTPクライアントは起動時に作成し、すべての受信経路で共有する
+	emails := handlers.NewEmailHandler(handlers.EmailConfig{
+		AutopilotURL:   cfg.AutopilotURL,
+		ForwardTimeout: cfg.ForwardTimeout,
+	})
+	handlers.ConfigureBatch(handlers.BatchConfig{
+		Concurrency: cfg.Batch.Concurrency,
+		MaxItems:    cfg.Batch.MaxItems,
+		MaxBytes:    cfg.Batch.MaxBytes,
+	})
+	// パースの不具合などで失敗が増えた場合はnotifyサービスでアラートを送る
+	alertConfig := handlers.AlertConfig{
+		Threshold:   cfg.FailureAlert.Threshold,
+		Window:      cfg.FailureAlert.Window,
+		MinMessages: cfg.FailureAlert.MinMessages,
+		Cooldown:    cfg.FailureAlert.Cooldown,
+	}
+	// パース・送信の失敗は件数が少なくても取り込みが止まっている可能性があるため、1件目から通知する
+	if cfg.FailureAlert.Immediate {
+		alertConfig.ImmediateCooldown = cfg.FailureAlert.ImmediateCooldown
+	}
+	handlers.ConfigureFailureAlerts(notify.NewNotifier(cfg.NotificationURL), alertConfig)
+
+	// Message-ID・IMAPの取り込み位置・送信待ちのメールはDatastoreに保存する
+	var store *datastore.Client
+	if cfg.MessageIDDedup || cfg.IMAP.Host != "" || cfg.ForwardRetry.Enabled {
+		if store, err = datastore.NewClient(cfg.DatastoreDatabase, cfg.DatastoreNamespace); err != nil {
+			logger.Logger.Fatal("Datastoreの初期化に失敗しました", zap.Error(err))
+		}
+		// 起動時に疎通を確認する（失敗しても起動は続け、/ready で状態を返す）
+		pingCtx, cancel := context.WithTimeout(context.Background(), cfg.ReadinessTimeout)
+		if err := store.Ping(pingCtx); err != nil {
+			logger.Logger.Warn("Datastoreに接続できません", zap.Error(err))
+		}
+		cancel()
+	}
+	if cfg.MessageIDDedup {
+		handlers.ConfigureDedup(dedup.NewStore(store, cfg.DedupTTL))
+	}
+
+	// autopilotに送信できなかったメールは保存してバックグラウンドで再送する（FORWARD_RETRY 設定時のみ）
+	var retrier *handlers.ForwardRetrier
+	if cfg.ForwardRetry.Enabled {
+		retrier = handlers.NewForwardRetrier(pending.NewStore(store), handlers.RetryConfig{
+			Interval:     cfg.ForwardRetry.Interval,
+			InitialDelay: cfg.ForwardRetry.InitialDelay,
+			MaxDelay:     cfg.ForwardRetry.MaxDelay,
+			BatchSize:    cfg.ForwardRetry.BatchSize,
+		}, emails)
+	}
+	emails.SetForwardRetrier(retrier)
+
+	// Gmailのプッシュ通知を購読して新着メールを取り込む（GMAIL_SUBSCRIPTION 設定時のみ）
+	watcher := gmail.NewWatcher(gmail.Config{
+		User:         cfg.GmailUser,
+		Topic:        cfg.GmailTopic,
+		Subscription: cfg.GmailSubscription,
+		LabelIDs:     cfg.GmailLabelIDs,
+	}, emails.IngestEmail)
+	ingestCtx, stopIngest := context.WithCancel(context.Background())
+	retrier.Start(ingestCtx)
+	watcher.Start(ingestCtx)
+
+	// 共有メールボックスをIMAPで定期的に確認して新着メールを取り込む（IMAP_HOST 設定時のみ）
+	var poller *imap.Poller
+	if cfg.IMAP.Host != "" {
+		poller = imap.NewPoller(imap.Config{
+			Host:           cfg.IMAP.Host,
+			Port:           cfg.IMAP.Port,
+			TLS:            cfg.IMAP.TLS,
+			Username:       cfg.IMAP.Username,
+			Folder:         cfg.IMAP.Folder,
+			Interval:       cfg.IMAP.Interval,
+			MarkSeen:       cfg.IMAP.MarkSeen,
+			UnseenOnly:     cfg.IMAP.UnseenOnly,
+			MaxMessageSize: cfg.MaxMessageSize,
+		}, imap.NewCheckpointStore(store), emails.IngestEmail)
+	}
+	poller.Start(ingestCtx)
+
+	// /receive で受け付けたメールはCloud Storageに一時保存し、ワーカーでパース・送信する（SPOOL_BUCKET 設定時のみ）
+	receiveQueue := handlers.NewReceiveQueue(storage.NewUploader(cfg.ReceiveQueue.Bucket, cfg.ReceiveQueue.Prefix), handlers.QueueConfig{
+		Workers:       cfg.ReceiveQueue.Workers,
+		QueueSize:     cfg.ReceiveQueue.QueueSize,
+		MaxAttempts:   cfg.ReceiveQueue.MaxAttempts,
+		RetryDelay:    cfg.ReceiveQueue.RetryDelay,
+		SweepInterval: cfg.ReceiveQueue.SweepInterval,
+	}, emails)
+	emails.SetReceiveQueue(receiveQueue)
+	receiveQueue.Start(ingestCtx)
 
 	// ルーターの設定
 	r := gin.New()
@@ -33,19 +165,32 @@ func main() {
 	}
 	middleware.SetupMiddleware(r, middlewareConfig)
 
-	r.POST("/receive", handlers.HandleEmailReceive)
+	r.GET("/health", handlers.HandleHealth)
+	// 依存サービス（Datastore）の疎通確認
+	r.GET("/ready", handlers.NewReadinessHandler(store, emails, cfg.ReadinessTimeout).HandleReady)
+	r.POST("/receive", emails.HandleEmailReceive)
+	// 過去のメールの移行用（任意のバケットを読み取れるため内部API用の認証）
+	r.POST("/receive/batch", emails.HandleBatchReceive)
+	r.POST("/ses", emails.HandleSESNotification)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	// サーバーの設定と起動
 	srv := config.SetupServer(r)
 
 	// グレースフルシャットダウンの実装
-	handleGracefulShutdown(srv)
+	handleGracefulShutdown(srv, func(ctx context.Context) {
+		stopIngest()
+		watcher.Wait(ctx)
+		poller.Wait(ctx)
+		receiveQueue.Wait(ctx)
+		retrier.Wait(ctx)
+	})
 }
 
-func handleGracefulShutdown(srv *http.Server) {
+func handleGracefulShutdown(srv *http.Server, stopWorkers func(ctx context.Context)) {
 	// サーバーを別のゴルーチンで起動
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := mtls.ListenAndServe(srv); err != nil && err != http.ErrServerClosed {
 			logger.Logger.Fatal("サーバーの起動に失敗しました", zap.Error(err))
 		}
 	}()
@@ -64,6 +209,7 @@ func handleGracefulShutdown(srv *http.Server) {
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Logger.Error("サーバーのシャットダウンでエラーが発生", zap.Error(err))
 	}
+	stopWorkers(ctx)
 
 	logger.Logger.Info("サーバーを正常に終了しました")
 }
@@ -11,11 +11,16 @@ import (
 	"time"
 
 	"mailconvertor/logger"
+	"mailconvertor/mtls"
+	"mailconvertor/serviceauth"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// maxLoggedBody は未認証リクエストのログに残すボディの上限（バイト）
+const maxLoggedBody = 4 * 1024
+
 type Config struct {
 	EnableLogger bool
 	EnableAuth   bool
@@ -40,8 +45,8 @@ func PathBasedAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		path := c.Request.URL.Path
 
-		// ヘルスチェックはスキップ
-		if path == "/health" {
+		// ヘルスチェック・疎通確認はスキップ
+		if path == "/health" || path == "/ready" {
 			c.Next()
 			return
 		}
@@ -52,6 +57,12 @@ func PathBasedAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// SESの受信通知（SNS）はトークンを付けられないため、ハンドラーでSNSの署名を検証する
+		if path == "/ses" && c.Request.Method == "POST" {
+			c.Next()
+			return
+		}
+
 		// その他の内部APIエンドポイント
 		internalAuthMiddleware(c)
 	}
@@ -85,8 +96,13 @@ func externalAuthMiddleware(c *gin.Context) {
 
 // internalAuthMiddleware 内部API用認証
 func internalAuthMiddleware(c *gin.Context) {
-	serviceToken := os.Getenv("SERVICE_TOKEN")
-	if serviceToken == "" {
+	// 検証済みのクライアント証明書（相互TLS）によるサービス間認証
+	if mtls.IsVerifiedClient(c.Request) {
+		c.Next()
+		return
+	}
+
+	if os.Getenv("SERVICE_TOKEN") == "" && os.Getenv("ID_TOKEN_AUDIENCE") == "" {
 		logger.Logger.Warn("SERVICE_TOKEN is not set")
 		abortWithError(c, http.StatusUnauthorized, "unauthorized: service token not configured")
 		return
@@ -99,8 +115,9 @@ func internalAuthMiddleware(c *gin.Context) {
 		return
 	}
 
+	// SERVICE_TOKEN またはGoogle署名のIDトークンを受け付ける
 	token := strings.TrimPrefix(authHeader, "Bearer ")
-	if token != serviceToken {
+	if !serviceauth.Authorize(token) {
 		logUnauthorizedRequest(c)
 		abortWithError(c, http.StatusUnauthorized, "invalid internal token")
 		return
@@ -121,7 +138,8 @@ func abortWithError(c *gin.Context, status int, message string) {
 func logUnauthorizedRequest(c *gin.Context) {
 	var bodyBytes []byte
 	if c.Request.Body != nil {
-		bodyBytes, _ = io.ReadAll(c.Request.Body)
+		// 大きなメールを未認証で送られてもメモリに読み込まないよう、ログに残す分だけ読む
+		bodyBytes, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxLoggedBody))
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 	}
 
@@ -1,33 +1,100 @@
 package models
 
+import "time"
+
 // EmailData はメールのデータ構造を定義します
 type EmailData struct {
-	From                    string `json:"from"`
-	To                      string `json:"to"`
-	Subject                 string `json:"subject"`
-	Date                    string `json:"date"`
-	OriginalMessageID       string `json:"original_message_id"`
-	MIMEVersion             string `json:"mime_version"`
-	ContentType             string `json:"content_type"`
-	ContentTransferEncoding string `json:"content_transfer_encoding"`
-	CC                      string `json:"cc"`
-	Body                    string `json:"body"`
-	FileName                string `json:"file_name,omitempty"`
+	From                    string       `json:"from"`
+	To                      string       `json:"to"`
+	Subject                 string       `json:"subject"`
+	Date                    string       `json:"date"`
+	OriginalMessageID       string       `json:"original_message_id"`
+	MIMEVersion             string       `json:"mime_version"`
+	ContentType             string       `json:"content_type"`
+	ContentTransferEncoding string       `json:"content_transfer_encoding"`
+	CC                      string       `json:"cc"`
+	Body                    string       `json:"body"`
+	HTMLBody                string       `json:"html_body,omitempty"`   // HTML本文（インライン画像の cid: 参照を data URI に書き換えたもの）
+	InReplyTo               string       `json:"in_reply_to,omitempty"` // 返信元の Message-ID（スレッドの判定用）
+	References              string       `json:"references,omitempty"`  // スレッドの Message-ID の一覧
+	FileName                string       `json:"file_name,omitempty"`   // 最初の添付ファイル名（互換性のため残す）
+	Attachments             []Attachment `json:"attachments,omitempty"`
+	RawHeader               []byte       `json:"raw_header,omitempty"`  // 受信したRFC822のヘッダー部分（スパム判定のヘッダーのルール用）
+	RawGCSURI               string       `json:"raw_gcs_uri,omitempty"` // 生データ全体を保存したCloud StorageのURI（RAW_ARCHIVE_BUCKET 設定時）
+
+	// カレンダー招待（text/calendar）の最初の予定。メンテナンスの告知の期間としてdbpilotが使います
+	CalendarMethod  string     `json:"calendar_method,omitempty"` // REQUEST（告知・更新）、CANCEL（中止）など
+	CalendarUID     string     `json:"calendar_uid,omitempty"`    // 予定のUID（更新・中止の照合用）
+	CalendarSummary string     `json:"calendar_summary,omitempty"`
+	CalendarStart   *time.Time `json:"calendar_start,omitempty"`
+	CalendarEnd     *time.Time `json:"calendar_end,omitempty"`
+}
+
+// Attachment は添付ファイルの情報です。ログなどテキスト形式の添付ファイルは内容も含みます
+type Attachment struct {
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Content     string `json:"content,omitempty"`
+	Truncated   bool   `json:"truncated,omitempty"`  // Content を上限で切り詰めた場合 true
+	GCSURI      string `json:"gcs_uri,omitempty"`    // 内容を保存したCloud StorageのURI（ATTACHMENT_BUCKET 設定時）
+	ContentID   string `json:"content_id,omitempty"` // HTML本文から cid: で参照される場合のContent-ID
+	Inline      bool   `json:"inline,omitempty"`     // HTML本文に表示されるインライン画像の場合 true
+	Data        []byte `json:"-"`                    // 添付ファイルの内容（Cloud Storageへの保存用、送信しない）
 }
 
 // APIResponse はAPIレスポンスの構造を定義します
 type APIResponse struct {
-	Status    string     `json:"status"`            // "success" or "error"
+	Status    string     `json:"status"`            // "success"、"accepted"（非同期で処理）または "error"
 	Code      int        `json:"code"`              // HTTPステータスコード
 	Message   string     `json:"message,omitempty"` // 処理結果の説明
 	TraceID   string     `json:"trace_id"`          // X-Message-IDの値
 	Timestamp string     `json:"timestamp"`         // 処理時のタイムスタンプ
 	Error     *ErrorInfo `json:"error,omitempty"`   // エラー情報（エラー時のみ）
+
+	Duplicate   bool   `json:"duplicate,omitempty"`    // 処理済みのMessage-IDのため送信しなかった場合 true
+	DuplicateOf string `json:"duplicate_of,omitempty"` // 最初に受け付けたときのX-Message-ID
 }
 
+// BatchResponse は /receive/batch のレスポンスです
+type BatchResponse struct {
+	Status    string        `json:"status"`   // "success"（すべて処理）または "partial"（失敗したメールがある）
+	Code      int           `json:"code"`     // HTTPステータスコード
+	TraceID   string        `json:"trace_id"` // バッチID
+	Timestamp string        `json:"timestamp"`
+	Total     int           `json:"total"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"` // リクエスト（マニフェスト）の順
+}
+
+// BatchResult はバッチのメールごとの結果です
+type BatchResult struct {
+	Index     int    `json:"index"`
+	MessageID string `json:"message_id"`
+	Source    string `json:"source,omitempty"` // マニフェストで指定したメールの gs:// URI
+	Status    string `json:"status"`           // processed、duplicate、rejected、queued または error
+	Error     string `json:"error,omitempty"`
+	ErrorType string `json:"error_type,omitempty"` // エラーの種類（ErrorInfo.Type と同じ値）
+}
+
+// ErrorInfo.Type の値。上流の転送元はメッセージの文言ではなく種類（または Retryable）で再送するか破棄するかを判断します
+const (
+	ErrorInvalidRequest     = "INVALID_REQUEST"     // リクエスト・通知の形式が不正（破棄）
+	ErrorInvalidMessageID   = "INVALID_MESSAGE_ID"  // X-Message-ID の形式が不正（破棄）
+	ErrorEmptyBody          = "EMPTY_BODY"          // メールの生データが空（破棄）
+	ErrorReadFailed         = "READ_ERROR"          // リクエストボディの読み取りが途中で失敗した（再送）
+	ErrorParse              = "PARSE_ERROR"         // MIMEメッセージとしてパースできない（破棄）
+	ErrorOversize           = "OVERSIZE"            // メール・リクエストのサイズが上限を超える（破棄）
+	ErrorUnauthorizedSender = "UNAUTHORIZED_SENDER" // 受け付けない送信者（破棄）
+	ErrorUpstream           = "UPSTREAM_ERROR"      // 送信先・外部サービスへの送信に失敗した（Retryable が true の場合は再送）
+	ErrorUnavailable        = "UNAVAILABLE"         // メールの一時保存に失敗した（再送）
+	ErrorInternal           = "INTERNAL_ERROR"      // 設定の不備など mailconverter 側の問題（再送）
+)
+
 // ErrorInfo はエラー詳細情報の構造を定義します
 type ErrorInfo struct {
-	Type    string `json:"type"`             // エラーの種類（parse_error, api_error, etc.）
-	Message string `json:"message"`          // エラーメッセージ
-	Detail  string `json:"detail,omitempty"` // 詳細なエラー情報
+	Type      string `json:"type"`             // エラーの種類（Error* の定数）
+	Retryable bool   `json:"retryable"`        // 時間をおいて同じメールを再送すれば成功し得る場合 true
+	Message   string `json:"message"`          // エラーメッセージ
+	Detail    string `json:"detail,omitempty"` // 詳細なエラー情報
 }
@@ -0,0 +1,79 @@
+// Package msgid はメッセージIDの生成と検証を行います。
+// 生成するIDはULID（時刻順に並ぶ26文字のCrockford Base32）で、Datastoreやログでも受信順に並びます
+package msgid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// encoding はULIDで使うCrockford Base32の文字（I・L・O・U を除く）
+const encoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// MaxLength は受け付けるメッセージIDの長さの上限
+const MaxLength = 128
+
+// validID は受け付けるメッセージIDの形式（オブジェクト名・Datastoreのキーにそのまま使える文字）
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:@+=-]*$`)
+
+var (
+	mu       sync.Mutex
+	lastMS   uint64
+	lastRand [10]byte
+)
+
+// New はULIDを生成します。同じミリ秒（または時刻の巻き戻り）では乱数部を1増やし、生成順に並ぶようにします
+func New() string {
+	ms := uint64(time.Now().UnixMilli())
+
+	var id [16]byte
+	mu.Lock()
+	if ms <= lastMS {
+		ms = lastMS
+		increment(lastRand[:])
+	} else {
+		lastMS = ms
+		if _, err := rand.Read(lastRand[:]); err != nil {
+			// 乱数が取得できない場合は時刻で代用する（衝突の可能性は上がるが順序は保つ）
+			binary.BigEndian.PutUint64(lastRand[2:], uint64(time.Now().UnixNano()))
+		}
+	}
+	id[0] = byte(ms >> 40)
+	id[1] = byte(ms >> 32)
+	binary.BigEndian.PutUint32(id[2:], uint32(ms))
+	copy(id[6:], lastRand[:])
+	mu.Unlock()
+
+	return encode(id)
+}
+
+// Valid はメッセージIDが受け付けられる形式かを返します
+func Valid(id string) bool {
+	return len(id) <= MaxLength && validID.MatchString(id)
+}
+
+// increment は b をビッグエンディアンの整数として1増やします
+func increment(b []byte) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
+
+// encode は128ビットを26文字のCrockford Base32にします（先頭の2ビットは0）
+func encode(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	var dst [26]byte
+	for i := len(dst) - 1; i >= 0; i-- {
+		dst[i] = encoding[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(dst[:])
+}
@@ -0,0 +1,68 @@
+// Package notify は通知サービス（notify）経由で運用者にアラートを送信します
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"mailconvertor/serviceauth"
+	"shared/idtoken"
+)
+
+// Notifier は通知サービスのクライアントです
+type Notifier struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewNotifier は通知サービスのクライアントを作成します。baseURL が空の場合は nil を返します
+func NewNotifier(baseURL string) *Notifier {
+	if baseURL == "" {
+		return nil
+	}
+	return &Notifier{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SendAlert はシステムからのアラートを送信します
+func (n *Notifier) SendAlert(title, content, priority string) error {
+	if n == nil {
+		return fmt.Errorf("NOTIFICATION_SERVICE_URL is not configured")
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"title":     title,
+		"content":   content,
+		"responder": "system",
+		"name":      "mailconvertor",
+		"priority":  priority,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.baseURL+"/notify", bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := idtoken.BearerToken(n.baseURL, serviceauth.PrimaryServiceToken()); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("notification service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
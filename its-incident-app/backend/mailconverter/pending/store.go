@@ -0,0 +1,93 @@
+// Package pending はautopilotに送信できなかったメールデータをDatastoreに保存し、再送の対象を返します。
+// 保存したメールデータは送信に成功するまで、間隔を空けながら再送します
+package pending
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"mailconvertor/datastore"
+)
+
+const (
+	// pendingKind は送信待ちのメールデータを保存するエンティティの種類（キーの名前はメッセージID）
+	pendingKind = "PendingForward"
+	// MaxPayloadSize はDatastoreのエンティティに保存できるメールデータ（JSON）の上限（エンティティの上限 1MiB から余裕を取る）
+	MaxPayloadSize = 1000 * 1024
+)
+
+// Entry は送信待ちのメールデータです
+type Entry struct {
+	MessageID     string
+	Target        string // 送信先のベースURL（送信先のルールで決めたもの）
+	Payload       []byte // 送信するメールデータ（JSON）
+	Attempts      int
+	LastError     string
+	CreatedAt     time.Time
+	NextAttemptAt time.Time
+}
+
+// Store は送信待ちのメールデータをDatastoreに保存します
+type Store struct {
+	client *datastore.Client
+}
+
+// NewStore は client に送信待ちのメールデータを保存するStoreを作成します
+func NewStore(client *datastore.Client) *Store {
+	return &Store{client: client}
+}
+
+// Save はエントリーを保存します（同じメッセージIDのエントリーは上書き）
+func (s *Store) Save(ctx context.Context, entry *Entry) error {
+	if len(entry.Payload) > MaxPayloadSize {
+		return fmt.Errorf("payload of %d bytes exceeds the limit of %d bytes", len(entry.Payload), MaxPayloadSize)
+	}
+	return s.client.Upsert(ctx, &datastore.Entity{
+		Key: s.client.Key(pendingKind, entry.MessageID),
+		Properties: map[string]datastore.Value{
+			"target":          datastore.String(entry.Target, true),
+			"payload":         datastore.String(string(entry.Payload), true),
+			"attempts":        datastore.Integer(int64(entry.Attempts)),
+			"last_error":      datastore.String(entry.LastError, true),
+			"created_at":      datastore.Timestamp(entry.CreatedAt),
+			"next_attempt_at": datastore.Timestamp(entry.NextAttemptAt),
+		},
+	})
+}
+
+// Due は再送の時刻を過ぎたエントリーを時刻の古い順に最大 limit 件返します
+func (s *Store) Due(ctx context.Context, now time.Time, limit int) ([]*Entry, error) {
+	entities, err := s.client.RunQuery(ctx, datastore.Query{
+		Kind: pendingKind,
+		Filter: &datastore.PropertyFilter{
+			Property: "next_attempt_at",
+			Op:       "LESS_THAN_OR_EQUAL",
+			Value:    datastore.Timestamp(now),
+		},
+		OrderBy: "next_attempt_at",
+		Limit:   limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(entities))
+	for _, entity := range entities {
+		entries = append(entries, &Entry{
+			MessageID:     entity.Key.Name(),
+			Target:        entity.String("target"),
+			Payload:       []byte(entity.String("payload")),
+			Attempts:      int(entity.Integer("attempts")),
+			LastError:     entity.String("last_error"),
+			CreatedAt:     entity.Timestamp("created_at"),
+			NextAttemptAt: entity.Timestamp("next_attempt_at"),
+		})
+	}
+	return entries, nil
+}
+
+// Delete は送信できたエントリーを削除します
+func (s *Store) Delete(ctx context.Context, messageID string) error {
+	return s.client.Delete(ctx, s.client.Key(pendingKind, messageID))
+}
@@ -0,0 +1,231 @@
+// Package storage は添付ファイル・受信したメールの生データなどをCloud Storageに保存し、
+// SESがAmazon S3に保存したメールを読み取ります。
+// STORAGE_EMULATOR_HOST が設定されている場合はエミュレーターに認証なしで接続します
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"mailconvertor/secrets"
+)
+
+const (
+	gcsUploadEndpoint = "https://storage.googleapis.com/upload/storage/v1/b/"
+	gcsObjectEndpoint = "https://storage.googleapis.com/storage/v1/b/"
+)
+
+// Uploader はバケットにオブジェクトを保存します。受信したメールの一時保存用に、オブジェクトの読み取り・一覧・削除もできます
+type Uploader struct {
+	bucket         string
+	prefix         string
+	endpoint       string
+	objectEndpoint string
+	useAuth        bool
+	client         *http.Client
+}
+
+// Object はオブジェクトの一覧の要素です
+type Object struct {
+	Name    string
+	Size    int64
+	Updated time.Time
+}
+
+// NewUploader は bucket の prefix 以下にオブジェクトを保存するUploaderを作成します。bucket が空の場合は nil を返します
+func NewUploader(bucket, prefix string) *Uploader {
+	if bucket == "" {
+		return nil
+	}
+
+	u := &Uploader{
+		bucket:         bucket,
+		prefix:         strings.Trim(prefix, "/"),
+		endpoint:       gcsUploadEndpoint,
+		objectEndpoint: gcsObjectEndpoint,
+		useAuth:        true,
+		client:         &http.Client{Timeout: 60 * time.Second},
+	}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+			host = "http://" + host
+		}
+		u.endpoint = strings.TrimSuffix(host, "/") + "/upload/storage/v1/b/"
+		u.objectEndpoint = strings.TrimSuffix(host, "/") + "/storage/v1/b/"
+		u.useAuth = false
+	}
+	return u
+}
+
+// ParseGCSURI は gs://バケット/オブジェクト 形式のURIをバケットとオブジェクト名に分けます
+func ParseGCSURI(uri string) (bucket, object string, err error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(uri), "gs://")
+	if !ok {
+		return "", "", fmt.Errorf("not a gs:// URI: %s", uri)
+	}
+	bucket, object, _ = strings.Cut(rest, "/")
+	if bucket == "" || object == "" {
+		return "", "", fmt.Errorf("invalid gs:// URI: %s", uri)
+	}
+	return bucket, object, nil
+}
+
+// ObjectName は prefix を付けたオブジェクト名を返します
+func (u *Uploader) ObjectName(name string) string {
+	if u.prefix == "" {
+		return name
+	}
+	return u.prefix + "/" + name
+}
+
+// Upload は data をオブジェクト name（prefix は ObjectName で付与）として保存し、gs:// 形式のURIを返します。
+// data はメモリに読み込まずにそのまま送信します（大きな添付ファイルのストリーミング用）
+func (u *Uploader) Upload(ctx context.Context, name, contentType string, data io.Reader) (string, error) {
+	object := u.ObjectName(name)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	query := url.Values{"uploadType": {"media"}, "name": {object}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		u.endpoint+url.PathEscape(u.bucket)+"/o?"+query.Encode(), data)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if u.useAuth {
+		token, err := secrets.AccessToken()
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload %s: %v", object, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("cloud storage returned status %d for %s: %s", resp.StatusCode, object, string(body))
+	}
+	return "gs://" + u.bucket + "/" + object, nil
+}
+
+// Open はオブジェクト（prefix を含む名前）の内容とサイズを返します。呼び出し側で Close してください
+func (u *Uploader) Open(ctx context.Context, object string) (io.ReadCloser, int64, error) {
+	resp, err := u.do(ctx, http.MethodGet, u.objectURL(object)+"?alt=media")
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, 0, fmt.Errorf("cloud storage returned status %d for %s: %s", resp.StatusCode, object, string(body))
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// Copy はオブジェクトを同じバケットの dst（prefix を含む名前）に複製します
+func (u *Uploader) Copy(ctx context.Context, object, dst string) error {
+	resp, err := u.do(ctx, http.MethodPost, u.objectURL(object)+"/copyTo/b/"+url.PathEscape(u.bucket)+"/o/"+url.PathEscape(dst))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("cloud storage returned status %d for copy of %s: %s", resp.StatusCode, object, string(body))
+	}
+	return nil
+}
+
+// Delete はオブジェクト（prefix を含む名前）を削除します（存在しない場合も成功）
+func (u *Uploader) Delete(ctx context.Context, object string) error {
+	resp, err := u.do(ctx, http.MethodDelete, u.objectURL(object))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("cloud storage returned status %d for delete of %s: %s", resp.StatusCode, object, string(body))
+	}
+	return nil
+}
+
+// List は name（prefix は ObjectName で付与）で始まるオブジェクトの一覧を返します
+func (u *Uploader) List(ctx context.Context, name string) ([]Object, error) {
+	var objects []Object
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {u.ObjectName(name)}, "fields": {"items(name,size,updated),nextPageToken"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		resp, err := u.do(ctx, http.MethodGet, u.objectEndpoint+url.PathEscape(u.bucket)+"/o?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Items []struct {
+				Name    string    `json:"name"`
+				Size    int64     `json:"size,string"`
+				Updated time.Time `json:"updated"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("cloud storage returned status %d for list of %s: %s", resp.StatusCode, name, string(body))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode object list: %v", err)
+		}
+
+		for _, item := range page.Items {
+			objects = append(objects, Object{Name: item.Name, Size: item.Size, Updated: item.Updated})
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func (u *Uploader) objectURL(object string) string {
+	return u.objectEndpoint + url.PathEscape(u.bucket) + "/o/" + url.PathEscape(object)
+}
+
+func (u *Uploader) do(ctx context.Context, method, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if u.useAuth {
+		token, err := secrets.AccessToken()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cloud storage request failed: %v", err)
+	}
+	return resp, nil
+}
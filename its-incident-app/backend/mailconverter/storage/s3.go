@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"mailconvertor/secrets"
+)
+
+// emptyPayloadHash は空のリクエストボディのSHA-256
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Reader はAmazon S3からオブジェクトを読み取ります（SESの受信ルールでS3に保存されたメール用）。
+// 認証情報は AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY（sm:// 参照可）/ AWS_SESSION_TOKEN を使用し、
+// endpoint を指定した場合（LocalStack など）はパス形式でアクセスします
+type S3Reader struct {
+	region   string
+	endpoint string
+	client   *http.Client
+}
+
+// NewS3Reader は region のS3からオブジェクトを読み取るS3Readerを作成します
+func NewS3Reader(region, endpoint string) *S3Reader {
+	return &S3Reader{
+		region:   region,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Open は bucket のオブジェクト key を開き、内容とサイズ（不明な場合は -1）を返します。呼び出し側で Close してください
+func (s *S3Reader) Open(ctx context.Context, bucket, key string) (io.ReadCloser, int64, error) {
+	accessKey := secrets.Get("AWS_ACCESS_KEY_ID")
+	secretKey := secrets.Get("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, 0, fmt.Errorf("AWS credentials are not set")
+	}
+
+	host, path := bucket+".s3."+s.region+".amazonaws.com", "/"+key
+	scheme := "https"
+	if s.endpoint != "" {
+		u, err := url.Parse(s.endpoint)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid S3 endpoint: %v", err)
+		}
+		scheme, host, path = u.Scheme, u.Host, "/"+bucket+"/"+key
+	}
+	canonicalURI := awsURIEncode(path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+host+canonicalURI, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	signS3Request(req, host, canonicalURI, s.region, accessKey, secretKey, secrets.Get("AWS_SESSION_TOKEN"), time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get s3://%s/%s: %v", bucket, key, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, 0, fmt.Errorf("s3 returned status %d for s3://%s/%s: %s", resp.StatusCode, bucket, key, string(body))
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// signS3Request はリクエストにAWS署名バージョン4の Authorization ヘッダーを付与します
+func signS3Request(req *http.Request, host, canonicalURI, region, accessKey, secretKey, sessionToken string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + host + "\n" +
+		"x-amz-content-sha256:" + emptyPayloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + sessionToken + "\n"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, canonicalURI, "", canonicalHeaders, signedHeaders, emptyPayloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode は署名用にパスをエンコードします（非予約文字と "/" 以外をパーセントエンコード）
+func awsURIEncode(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
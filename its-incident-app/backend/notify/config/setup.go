@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"notification/logger"
+	"notification/mtls"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ServerConfig サーバーの基本設定
+type ServerConfig struct {
+	Port            string
+	GinMode         string
+	LogLevel        zapcore.Level
+	Environment     string
+	ServiceName     string
+	ShutdownTimeout time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+}
+
+// InitConfig は環境設定を初期化します
+func InitConfig() (*ServerConfig, error) {
+	// .envファイルの読み込み
+	if err := godotenv.Load(); err != nil {
+		fmt.Println(".envファイルが見つかりません")
+	}
+
+	// ログレベルの設定
+	logLevel := initLogLevel()
+
+	// Ginモードの設定
+	ginMode := initGinMode()
+
+	return &ServerConfig{
+		Port:            getEnv("SERVER_PORT", "8080"),
+		GinMode:         ginMode,
+		LogLevel:        logLevel,
+		Environment:     getEnv("ENVIRONMENT", "development"),
+		ServiceName:     getEnv("K_SERVICE", "notification-service"),
+		ShutdownTimeout: getDuration("SHUTDOWN_TIMEOUT", 10*time.Second),
+		ReadTimeout:     getDuration("HTTP_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:    getDuration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:     getDuration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+	}, nil
+}
+
+// SetupServer はサーバーの設定を行います
+func SetupServer(r *gin.Engine) *http.Server {
+	config, _ := InitConfig()
+	displayServerConfig(r, config)
+
+	srv := &http.Server{
+		Addr:              ":" + config.Port,
+		Handler:           r,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	// 相互TLS（MTLS_CERT_FILE/MTLS_KEY_FILE が設定されている場合のみ）
+	tlsConfig, err := mtls.ServerConfig()
+	if err != nil {
+		logger.Logger.Fatal("mTLSの設定に失敗しました", zap.Error(err))
+	}
+	srv.TLSConfig = tlsConfig
+
+	return srv
+}
+
+func initLogLevel() zapcore.Level {
+	logLevelStr := getEnv("LOG_LEVEL", "info")
+	var logLevel zapcore.Level
+	if err := logLevel.UnmarshalText([]byte(logLevelStr)); err != nil {
+		fmt.Printf("Invalid LOG_LEVEL '%s', defaulting to 'info'\n", logLevelStr)
+		logLevel = zapcore.InfoLevel
+	}
+	logger.LogLevel.SetLevel(logLevel)
+	return logLevel
+}
+
+func initGinMode() string {
+	ginMode := os.Getenv("GIN_MODE")
+	if ginMode == "" {
+		ginMode = "release"
+	}
+	gin.SetMode(ginMode)
+	return ginMode
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+func displayServerConfig(r *gin.Engine, config *ServerConfig) {
+	var routeInfo strings.Builder
+	routeInfo.WriteString("Registered Endpoints:\n")
+	for _, route := range r.Routes() {
+		routeInfo.WriteString(fmt.Sprintf("- %s: %s -> %s\n",
+			route.Method,
+			route.Path,
+			route.Handler))
+	}
+
+	fmt.Printf("\n"+
+		"=================================\n"+
+		"Server Configuration:\n"+
+		"- Port: %s\n"+
+		"- Mode: %s\n"+
+		"- Log Level: %s\n"+
+		"- Environment: %s\n"+
+		"- Service: %s\n"+
+		"=================================\n"+
+		"%s"+
+		"=================================\n",
+		config.Port,
+		config.GinMode,
+		logger.LogLevel.String(),
+		config.Environment,
+		config.ServiceName,
+		routeInfo.String())
+}
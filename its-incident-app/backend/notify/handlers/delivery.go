@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"notification/logger"
+	"notification/models"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDeliveryMaxAttempts   = 5
+	defaultDeliveryRetryBackoff  = time.Minute
+	maxDeliveryRetryBackoff      = time.Hour
+	defaultDeliveryRetryInterval = 30 * time.Second
+	// deliveryClaimLease は再送を始めた通知を他のインスタンスが再送しない時間です。
+	// 再送の途中でインスタンスが停止した場合は、この時間の後に再び再送の対象になります
+	deliveryClaimLease = 5 * time.Minute
+)
+
+// 通知の送信状況（dbpilotの NotificationDelivery.Status）
+const (
+	deliveryPending  = "pending"
+	deliverySent     = "sent"
+	deliveryRetrying = "retrying"
+	deliveryFailed   = "failed"
+)
+
+// TransientError は時間をおいて再送すれば成功する可能性がある送信の失敗（通信エラー・429・5xx）です
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string { return e.Err.Error() }
+func (e *TransientError) Unwrap() error { return e.Err }
+
+// transient は通信エラーを再送の対象にします
+func transient(err error) error {
+	return &TransientError{Err: err}
+}
+
+// statusError は送信先が返したステータスが429・5xxの場合に再送の対象にします
+func statusError(status int, err error) error {
+	if status == http.StatusTooManyRequests || status >= http.StatusInternalServerError {
+		return &TransientError{Err: err}
+	}
+	return err
+}
+
+func isTransient(err error) bool {
+	var t *TransientError
+	return errors.As(err, &t)
+}
+
+// deliveryRecord はdbpilotに保存した通知の送信状況
+type deliveryRecord struct {
+	ID        uint   `json:"ID"`
+	Channel   string `json:"channel"`
+	Target    string `json:"target"`
+	Payload   string `json:"payload"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+}
+
+// deliveryTrackingEnabled は送信状況をdbpilotに保存するか（DELIVERY_TRACKING=false で無効）を返します
+func deliveryTrackingEnabled() bool {
+	return os.Getenv("DELIVERY_TRACKING") != "false" && os.Getenv("DB_PILOT_SERVICE_URL") != ""
+}
+
+// deliveryMaxAttempts は DELIVERY_MAX_ATTEMPTS（最初の送信を含む試行回数の上限）を返します
+func deliveryMaxAttempts() int {
+	if n, err := strconv.Atoi(os.Getenv("DELIVERY_MAX_ATTEMPTS")); err == nil && n > 0 {
+		return n
+	}
+	return defaultDeliveryMaxAttempts
+}
+
+// deliveryBackoff は attempts 回目の失敗の後に再送するまでの時間（DELIVERY_RETRY_BACKOFF から倍々、上限1時間）を返します
+func deliveryBackoff(attempts int) time.Duration {
+	backoff := defaultDeliveryRetryBackoff
+	if d, err := time.ParseDuration(os.Getenv("DELIVERY_RETRY_BACKOFF")); err == nil && d > 0 {
+		backoff = d
+	}
+	for i := 1; i < attempts && backoff < maxDeliveryRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxDeliveryRetryBackoff {
+		backoff = maxDeliveryRetryBackoff
+	}
+	return backoff
+}
+
+// deliver は宛先1件への通知を送信し、送信状況をdbpilotに保存します。
+// 一時的な失敗は再送のワーカー（StartDeliveryRetryWorker）が再送します。送信状況を保存できない場合も送信は行います
+func deliver(channel, target string, req models.NotificationRequest, send func() error) error {
+	return deliverPayload(channel, target, req.IncidentID, req, send)
+}
+
+// deliverPayload は deliver と同じですが、再送に使用する内容（payload）を指定します（メールはテンプレートの種類と項目）
+func deliverPayload(channel, target string, incidentID uint, payload interface{}, send func() error) error {
+	var id uint
+	if deliveryTrackingEnabled() {
+		var err error
+		if id, err = recordDelivery(channel, target, incidentID, payload); err != nil {
+			logger.Logger.Warn("通知の送信状況の保存に失敗しました", zap.String("channel", channel), zap.Error(err))
+		}
+	}
+
+	err := send()
+	if id != 0 {
+		finishDelivery(id, 1, err)
+	}
+	return err
+}
+
+// recordDelivery は送信する通知をdbpilotに登録し、IDを返します
+func recordDelivery(channel, target string, incidentID uint, payload interface{}) (uint, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal notification: %v", err)
+	}
+	hash := sha256.Sum256(encoded)
+
+	var record deliveryRecord
+	body := map[string]interface{}{
+		"channel":      channel,
+		"target":       target,
+		"incident_id":  incidentID,
+		"payload_hash": hex.EncodeToString(hash[:]),
+		"payload":      string(encoded),
+	}
+	if err := callDBPilot(http.MethodPost, "/notification-deliveries", body, &record); err != nil {
+		return 0, err
+	}
+	return record.ID, nil
+}
+
+// finishDelivery は attempts 回目の送信の結果を記録します（一時的な失敗で上限に達していない場合は再送を予約します）
+func finishDelivery(id uint, attempts int, sendErr error) {
+	body := map[string]interface{}{
+		"status":   deliverySent,
+		"attempts": attempts,
+	}
+	if sendErr != nil {
+		body["last_error"] = sendErr.Error()
+		if isTransient(sendErr) && attempts < deliveryMaxAttempts() {
+			body["status"] = deliveryRetrying
+			body["next_attempt_at"] = time.Now().Add(deliveryBackoff(attempts))
+		} else {
+			body["status"] = deliveryFailed
+		}
+	}
+	if err := callDBPilot(http.MethodPatch, fmt.Sprintf("/notification-deliveries/%d", id), body, nil); err != nil {
+		logger.Logger.Warn("通知の送信結果の保存に失敗しました", zap.Uint("delivery_id", id), zap.Error(err))
+	}
+}
+
+// StartDeliveryRetryWorker は再送の予定を過ぎた通知を DELIVERY_RETRY_INTERVAL ごとに再送します。戻り値の関数で停止します
+func StartDeliveryRetryWorker() func() {
+	interval := defaultDeliveryRetryInterval
+	if d, err := time.ParseDuration(os.Getenv("DELIVERY_RETRY_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if deliveryTrackingEnabled() {
+					retryDueDeliveries()
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// retryDueDeliveries は再送の予定を過ぎた通知を再送します
+func retryDueDeliveries() {
+	var due struct {
+		Data []deliveryRecord `json:"data"`
+	}
+	if err := callDBPilot(http.MethodGet, "/notification-deliveries?due=true", nil, &due); err != nil {
+		logger.Logger.Error("再送する通知の取得に失敗しました", zap.Error(err))
+		return
+	}
+
+	for _, record := range due.Data {
+		// 再送の途中で停止したインスタンスが上限まで試行していた場合は、再送せずに失敗として記録する
+		if record.Attempts >= deliveryMaxAttempts() {
+			finishDelivery(record.ID, record.Attempts, fmt.Errorf("retry interrupted: %s", record.LastError))
+			continue
+		}
+
+		// 試行回数を先に進め、更新できた（他のインスタンスが再送していない）場合だけ再送する。
+		// 状態は retrying のまま再送の予定を先に延ばし、結果を記録する前に停止した場合も期限の後に再び再送の対象にする
+		claim := map[string]interface{}{
+			"status":            deliveryRetrying,
+			"attempts":          record.Attempts + 1,
+			"expected_attempts": record.Attempts,
+			"last_error":        record.LastError,
+			"next_attempt_at":   time.Now().Add(deliveryClaimLease),
+		}
+		if err := callDBPilot(http.MethodPatch, fmt.Sprintf("/notification-deliveries/%d", record.ID), claim, nil); err != nil {
+			continue
+		}
+
+		err := resendDelivery(record)
+		finishDelivery(record.ID, record.Attempts+1, err)
+
+		fields := []zap.Field{
+			zap.Uint("delivery_id", record.ID),
+			zap.String("channel", record.Channel),
+			zap.Int("attempts", record.Attempts+1),
+		}
+		if err != nil {
+			logger.Logger.Warn("通知の再送に失敗しました", append(fields, zap.Error(err))...)
+			continue
+		}
+		logger.Logger.Info("通知を再送しました", fields...)
+	}
+}
+
+// resendDelivery は宛先1件に通知を再送します
+func resendDelivery(record deliveryRecord) error {
+	channel, target := record.Channel, record.Target
+	// メールは送信状況にテンプレートの種類と項目を保存しているため、テンプレートから作り直す
+	if channel == ChannelEmail {
+		return resendMail(target, record.Payload)
+	}
+
+	var req models.NotificationRequest
+	if err := json.Unmarshal([]byte(record.Payload), &req); err != nil {
+		return fmt.Errorf("invalid payload: %v", err)
+	}
+	switch channel {
+	case ChannelTeams:
+		webhooks, err := teamsWebhooks()
+		if err != nil {
+			return err
+		}
+		webhookURL, ok := webhooks[target]
+		if !ok {
+			return fmt.Errorf("%s: webhook not configured", target)
+		}
+		return SendTeamsNotification(webhookURL, req)
+	case ChannelSlack:
+		webhooks, err := slackWebhooks()
+		if err != nil {
+			return err
+		}
+		webhookURL, ok := webhooks[target]
+		if !ok {
+			return fmt.Errorf("%s: webhook not configured", target)
+		}
+		return SendSlackNotification(webhookURL, req)
+	case ChannelSMS:
+		return SendSMS(target, formatSMS(req))
+	case ChannelVoice:
+		// 再送を待っている間に確認された場合はかけない
+		if acknowledged, err := incidentAcknowledged(req.IncidentID); err == nil && acknowledged {
+			return nil
+		}
+		return placeCall(target, buildCallTwiML(req, target))
+	default:
+		return fmt.Errorf("unknown channel: %s", channel)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDeliveries は再送の対象の通知を返し、送信状況の更新を記録するテスト用のdbpilotです
+type fakeDeliveries struct {
+	*fakeDBPilot
+	due []deliveryRecord
+
+	mu      sync.Mutex
+	patches []map[string]interface{}
+}
+
+func (f *fakeDeliveries) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/notification-deliveries") {
+		f.fakeDBPilot.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": f.due})
+	case http.MethodPatch:
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		f.patches = append(f.patches, body)
+		f.mu.Unlock()
+		w.Write([]byte(`{}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRetryDueDeliveriesResendsEmail(t *testing.T) {
+	data, _ := json.Marshal(IncidentCreatedData{IncidentID: 42, Title: "メールサーバーの障害", Priority: "高"})
+	payload, _ := json.Marshal(mailDelivery{Template: MailTemplateIncidentCreated, Subject: "old", Data: data})
+	dbpilot := &fakeDeliveries{
+		fakeDBPilot: &fakeDBPilot{locales: map[string]string{"en@example.com": "en"}},
+		due: []deliveryRecord{{
+			ID:        1,
+			Channel:   ChannelEmail,
+			Target:    "en@example.com",
+			Payload:   string(payload),
+			Attempts:  1,
+			LastError: "sendgrid: 503",
+		}},
+	}
+	recorder := setupMailTest(t, dbpilot.fakeDBPilot)
+	server := httptest.NewServer(dbpilot)
+	t.Cleanup(server.Close)
+	t.Setenv("DB_PILOT_SERVICE_URL", server.URL)
+
+	retryDueDeliveries()
+
+	sent := recorder.messages()
+	if len(sent) != 1 || sent[0].To[0] != "en@example.com" || !strings.Contains(sent[0].Subject, "Incident #42") {
+		t.Fatalf("unexpected resent mails: %+v", sent)
+	}
+
+	if len(dbpilot.patches) != 2 {
+		t.Fatalf("patched %d times, want 2 (claim and result)", len(dbpilot.patches))
+	}
+	// 再送を始める時点では retrying のまま再送の予定を先に延ばす（途中で停止しても再び再送の対象になる）
+	claim := dbpilot.patches[0]
+	if claim["status"] != deliveryRetrying || claim["expected_attempts"] != float64(1) || claim["attempts"] != float64(2) {
+		t.Errorf("unexpected claim: %v", claim)
+	}
+	leaseUntil, err := time.Parse(time.RFC3339Nano, claim["next_attempt_at"].(string))
+	if err != nil || time.Until(leaseUntil) < deliveryClaimLease-time.Minute {
+		t.Errorf("claim does not lease the delivery: %v", claim["next_attempt_at"])
+	}
+	if claim["last_error"] != "sendgrid: 503" {
+		t.Errorf("claim cleared last_error: %v", claim["last_error"])
+	}
+	if result := dbpilot.patches[1]; result["status"] != deliverySent {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestRetryDueDeliveriesFailsInterruptedLastAttempt(t *testing.T) {
+	dbpilot := &fakeDeliveries{
+		fakeDBPilot: &fakeDBPilot{},
+		due: []deliveryRecord{{
+			ID:       1,
+			Channel:  ChannelSMS,
+			Target:   "+819012345678",
+			Payload:  `{"title":"障害"}`,
+			Attempts: 3,
+		}},
+	}
+	setupMailTest(t, dbpilot.fakeDBPilot)
+	server := httptest.NewServer(dbpilot)
+	t.Cleanup(server.Close)
+	t.Setenv("DB_PILOT_SERVICE_URL", server.URL)
+	t.Setenv("DELIVERY_MAX_ATTEMPTS", "3")
+
+	retryDueDeliveries()
+
+	if len(dbpilot.patches) != 1 || dbpilot.patches[0]["status"] != deliveryFailed {
+		t.Fatalf("unexpected patches: %v", dbpilot.patches)
+	}
+}
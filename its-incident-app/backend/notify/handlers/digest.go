@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"notification/logger"
+	"notification/models"
+
+	"go.uber.org/zap"
+)
+
+// defaultDigestMaxItems はまとめ通知の件数の既定の上限（超えた時点で送信する）
+const defaultDigestMaxItems = 50
+
+// digestLines はまとめ通知の本文に載せる通知の件数（残りは件数のみ）
+const digestLines = 20
+
+// digestBuffer は送信先ごとにまとめ通知を待っている通知です
+type digestBuffer struct {
+	channel   string
+	recipient string
+	items     []models.NotificationRequest
+	timer     *time.Timer
+}
+
+var (
+	digestMu      sync.Mutex
+	digestBuffers = map[string]*digestBuffer{}
+)
+
+// digestInterval は DIGEST_INTERVAL（まとめ通知を送信する間隔）を返します。0 の場合はまとめずにすぐ送信します
+func digestInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("DIGEST_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return 0
+	}
+	return interval
+}
+
+// digestMaxItems は DIGEST_MAX_ITEMS（間隔を待たずに送信する件数）を返します
+func digestMaxItems() int {
+	if n, err := strconv.Atoi(os.Getenv("DIGEST_MAX_ITEMS")); err == nil && n > 0 {
+		return n
+	}
+	return defaultDigestMaxItems
+}
+
+// digestEligible は通知をまとめ通知にするかを返します。
+// DIGEST_SEVERITIES（カンマ区切り、既定は low）の重要度の通知だけをまとめ、それ以外はすぐに送信します
+func digestEligible(req models.NotificationRequest) bool {
+	if digestInterval() == 0 {
+		return false
+	}
+	severities := os.Getenv("DIGEST_SEVERITIES")
+	if strings.TrimSpace(severities) == "" {
+		severities = string(models.SeverityLow)
+	}
+	severity := models.ParseSeverity(req.Priority)
+	for _, s := range strings.Split(severities, ",") {
+		if models.ParseSeverity(s) == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// digestRecipients は送信先の種類ごとの宛先（Teams・Slackのチャンネル、SMSの電話番号）を返します
+func digestRecipients(channel string, req models.NotificationRequest) ([]string, error) {
+	switch channel {
+	case ChannelTeams:
+		rules, err := teamsRoutingRules()
+		if err != nil {
+			return nil, err
+		}
+		_, channels := teamsChannelsFor(req, rules)
+		return channels, nil
+	case ChannelSlack:
+		routes, err := slackSeverityRoutes()
+		if err != nil {
+			return nil, err
+		}
+		return slackChannelsFor(req, routes), nil
+	case ChannelSMS:
+		return smsRecipientsFor(req)
+	default:
+		return nil, fmt.Errorf("channel %s does not support digest", channel)
+	}
+}
+
+// enqueueDigest は通知を宛先ごとのまとめ通知に追加します。
+// 宛先の最初の通知から DIGEST_INTERVAL 後（または DIGEST_MAX_ITEMS 件に達した時点）にまとめて送信します。
+// 待っている通知はプロセスのメモリにだけ保持するため、終了時には FlushDigests で送信します
+func enqueueDigest(channel string, req models.NotificationRequest) error {
+	recipients, err := digestRecipients(channel, req)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("no %s recipients for priority %q", channel, req.Priority)
+	}
+
+	var full []*digestBuffer
+	digestMu.Lock()
+	for _, recipient := range recipients {
+		recipient = strings.TrimSpace(recipient)
+		key := channel + ":" + recipient
+		buffer, ok := digestBuffers[key]
+		if !ok {
+			buffer = &digestBuffer{channel: channel, recipient: recipient}
+			buffer.timer = time.AfterFunc(digestInterval(), func() { flushDigest(key) })
+			digestBuffers[key] = buffer
+		}
+		buffer.items = append(buffer.items, req)
+		if len(buffer.items) >= digestMaxItems() {
+			buffer.timer.Stop()
+			delete(digestBuffers, key)
+			full = append(full, buffer)
+		}
+	}
+	digestMu.Unlock()
+
+	for _, buffer := range full {
+		go sendDigest(buffer)
+	}
+	return nil
+}
+
+// flushDigest は宛先のまとめ通知を送信します
+func flushDigest(key string) {
+	digestMu.Lock()
+	buffer, ok := digestBuffers[key]
+	delete(digestBuffers, key)
+	digestMu.Unlock()
+	if ok {
+		sendDigest(buffer)
+	}
+}
+
+// FlushDigests は待っているまとめ通知をすべて送信します（シャットダウン時に呼び出す）
+func FlushDigests() {
+	digestMu.Lock()
+	buffers := make([]*digestBuffer, 0, len(digestBuffers))
+	for key, buffer := range digestBuffers {
+		buffer.timer.Stop()
+		buffers = append(buffers, buffer)
+		delete(digestBuffers, key)
+	}
+	digestMu.Unlock()
+
+	for _, buffer := range buffers {
+		sendDigest(buffer)
+	}
+}
+
+// sendDigest はまとめ通知を宛先に送信します
+func sendDigest(buffer *digestBuffer) {
+	digest := buildDigest(buffer.items)
+
+	var err error
+	switch buffer.channel {
+	case ChannelTeams:
+		var webhooks map[string]string
+		if webhooks, err = teamsWebhooks(); err == nil {
+			if webhookURL, ok := webhooks[buffer.recipient]; ok {
+				err = deliver(ChannelTeams, buffer.recipient, digest, func() error { return SendTeamsNotification(webhookURL, digest) })
+			} else {
+				err = fmt.Errorf("webhook not configured")
+			}
+		}
+	case ChannelSlack:
+		var webhooks map[string]string
+		if webhooks, err = slackWebhooks(); err == nil {
+			if webhookURL, ok := webhooks[buffer.recipient]; ok {
+				err = deliver(ChannelSlack, buffer.recipient, digest, func() error { return SendSlackNotification(webhookURL, digest) })
+			} else {
+				err = fmt.Errorf("webhook not configured")
+			}
+		}
+	case ChannelSMS:
+		var recipients []string
+		recipients, err = applyQuietHours(ChannelSMS, digest, []string{buffer.recipient}, func(deferred []string) {
+			if err := sendSMSTo(digest, deferred); err != nil {
+				logger.Logger.Error("延期したまとめ通知の送信に失敗しました", zap.Error(err))
+			}
+		})
+		if err == nil && len(recipients) > 0 {
+			err = sendSMSTo(digest, recipients)
+		}
+	}
+
+	recipient := buffer.recipient
+	if buffer.channel == ChannelSMS {
+		recipient = maskPhoneNumber(recipient)
+	}
+	if err != nil {
+		logger.Logger.Error("まとめ通知の送信に失敗しました",
+			zap.String("channel", buffer.channel),
+			zap.String("recipient", recipient),
+			zap.Int("count", len(buffer.items)),
+			zap.Error(err),
+		)
+		return
+	}
+	logger.Logger.Info("まとめ通知を送信しました",
+		zap.String("channel", buffer.channel),
+		zap.String("recipient", recipient),
+		zap.Int("count", len(buffer.items)),
+	)
+}
+
+// buildDigest は通知の一覧を1件の通知にまとめます。
+// 個々のインシデントの操作ボタンは付けないため、IncidentID は設定しません
+func buildDigest(items []models.NotificationRequest) models.NotificationRequest {
+	// 重要度の高いものから、同じ重要度は届いた順に並べる
+	sorted := make([]models.NotificationRequest, len(items))
+	copy(sorted, items)
+	rank := map[models.Severity]int{models.SeverityCritical: 0, models.SeverityHigh: 1, models.SeverityMedium: 2, models.SeverityLow: 3}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank[models.ParseSeverity(sorted[i].Priority)] < rank[models.ParseSeverity(sorted[j].Priority)]
+	})
+
+	var lines []string
+	for i, item := range sorted {
+		if i == digestLines {
+			lines = append(lines, fmt.Sprintf("ほか %d 件", len(sorted)-digestLines))
+			break
+		}
+		line := "- "
+		if item.Priority != "" {
+			line += "[" + item.Priority + "] "
+		}
+		if item.IncidentID != 0 {
+			line += fmt.Sprintf("#%d ", item.IncidentID)
+		}
+		lines = append(lines, line+item.Title)
+	}
+
+	return models.NotificationRequest{
+		Title:    fmt.Sprintf("通知のまとめ（%d件）", len(items)),
+		Content:  strings.Join(lines, "\n"),
+		Priority: sorted[0].Priority,
+		Name:     "notify",
+	}
+}
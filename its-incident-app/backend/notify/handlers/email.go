@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification/models"
+)
+
+// mailSendTimeout は通知のメール1通あたりの送信の上限（すべてのプロバイダーを試す時間を含む）
+const mailSendTimeout = 30 * time.Second
+
+// emailConfigured はメールの送信プロバイダー（MAIL_PROVIDERS）が設定されているかを返します
+func emailConfigured() bool {
+	return mailSender != nil
+}
+
+// emailRecipientsFor は通知のメールの宛先を返します。
+// リクエストで指定された宛先を優先し、指定がない場合は EMAIL_RECIPIENTS（重要度ごとの宛先のJSONオブジェクト）の重要度の宛先を返します。
+// 例: {"critical":["oncall@example.com"],"high":["team@example.com"]}
+func emailRecipientsFor(req models.NotificationRequest) ([]string, error) {
+	if len(req.EmailRecipients) > 0 {
+		return req.EmailRecipients, nil
+	}
+	value := os.Getenv("EMAIL_RECIPIENTS")
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var recipients map[models.Severity][]string
+	if err := json.Unmarshal([]byte(value), &recipients); err != nil {
+		return nil, fmt.Errorf("invalid EMAIL_RECIPIENTS: %v", err)
+	}
+	return recipients[models.ParseSeverity(req.Priority)], nil
+}
+
+// incidentURL は INCIDENT_URL_TEMPLATE からインシデントのURLを返します（設定がない場合は空文字）
+func incidentURL(incidentID uint) string {
+	template := os.Getenv("INCIDENT_URL_TEMPLATE")
+	if template == "" || incidentID == 0 {
+		return ""
+	}
+	return strings.ReplaceAll(template, "{id}", strconv.FormatUint(uint64(incidentID), 10))
+}
+
+// incidentMail は通知の種類（Event）に応じたメールのテンプレートと項目を返します
+func incidentMail(req models.NotificationRequest) (MailTemplate, interface{}) {
+	if req.Event == models.EventSLABreach {
+		data := SLABreachData{
+			IncidentID:  req.IncidentID,
+			Title:       req.Title,
+			Priority:    req.Priority,
+			Status:      req.IncidentStatus,
+			IncidentURL: incidentURL(req.IncidentID),
+		}
+		if req.Deadline != nil {
+			data.Deadline = *req.Deadline
+			data.Overdue = time.Since(*req.Deadline)
+		}
+		return MailTemplateSLABreach, data
+	}
+	return MailTemplateIncidentCreated, IncidentCreatedData{
+		IncidentID:  req.IncidentID,
+		Title:       req.Title,
+		Priority:    req.Priority,
+		Responder:   req.Responder,
+		Content:     req.Content,
+		IncidentURL: incidentURL(req.IncidentID),
+	}
+}
+
+// sendEmail は通知の宛先それぞれに、宛先の言語のテンプレート（incident_created・sla_breach）でメールを送信します。
+// 送信しない宛先（恒久的な不達・迷惑メールの報告）は送信せずに飛ばし、失敗した宛先のエラーを返します
+func sendEmail(req models.NotificationRequest) error {
+	recipients, err := emailRecipientsFor(req)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("no email recipients for priority %q", req.Priority)
+	}
+
+	kind, data := incidentMail(req)
+	var failures []string
+	for _, to := range recipients {
+		to = strings.TrimSpace(to)
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		err := SendMail(ctx, to, kind, data)
+		cancel()
+		if err != nil && !errors.Is(err, ErrRecipientSuppressed) {
+			failures = append(failures, fmt.Sprintf("%s: %v", maskEmail(to), err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
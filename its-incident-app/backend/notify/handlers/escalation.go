@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification/logger"
+	"notification/models"
+	"notification/serviceauth"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// defaultEscalationPollInterval はエスカレーションの予定を確認する既定の間隔
+const defaultEscalationPollInterval = 30 * time.Second
+
+// EscalationStep はエスカレーションの段階です。
+// Delay は前の段階（最初の段階は最初の通知）から確認されないまま待つ時間で、送信先の指定は通知の送信先の指定と同じです
+type EscalationStep struct {
+	Name            string   `json:"name"`
+	Delay           string   `json:"delay"` // 例: 15m
+	Channels        []string `json:"channels"`
+	TeamsChannels   []string `json:"teams_channels"`
+	SlackChannels   []string `json:"slack_channels"`
+	SMSRecipients   []string `json:"sms_recipients"`
+	VoiceRecipients []string `json:"voice_recipients"`
+	EmailRecipients []string `json:"email_recipients"`
+}
+
+// EscalationPolicy は確認されないインシデントの通知先を段階的に広げるポリシーです（担当者 → チームのチャンネル → 管理者 など）
+type EscalationPolicy struct {
+	Name       string           `json:"name"`
+	Priorities []string         `json:"priorities"` // 対象の優先度（省略時はすべての通知）
+	Steps      []EscalationStep `json:"steps"`
+}
+
+// escalationState はdbpilotに保存したエスカレーションの状態
+type escalationState struct {
+	IncidentID   uint   `json:"incident_id"`
+	Policy       string `json:"policy"`
+	Step         int    `json:"step"`
+	Notification string `json:"notification"`
+}
+
+// escalationPolicies は ESCALATION_POLICIES（上から順に評価するポリシーのJSONの配列）を返します。
+// 例: [{"name":"p1","priorities":["緊急"],"steps":[{"delay":"10m","channels":["sms"],"sms_recipients":["+819012345678"]},
+// {"delay":"15m","channels":["teams"],"teams_channels":["oncall"]},{"delay":"30m","channels":["voice"],"voice_recipients":["+819087654321"]}]}]
+func escalationPolicies() ([]EscalationPolicy, error) {
+	value := os.Getenv("ESCALATION_POLICIES")
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var policies []EscalationPolicy
+	if err := json.Unmarshal([]byte(value), &policies); err != nil {
+		return nil, fmt.Errorf("invalid ESCALATION_POLICIES: %v", err)
+	}
+	for _, policy := range policies {
+		if policy.Name == "" || len(policy.Steps) == 0 {
+			return nil, fmt.Errorf("invalid ESCALATION_POLICIES: name and steps are required")
+		}
+		for i, step := range policy.Steps {
+			if _, err := step.delay(); err != nil {
+				return nil, fmt.Errorf("invalid ESCALATION_POLICIES (%s step %d): %v", policy.Name, i+1, err)
+			}
+		}
+	}
+	return policies, nil
+}
+
+func (s EscalationStep) delay() (time.Duration, error) {
+	if s.Delay == "" {
+		return 0, nil
+	}
+	delay, err := time.ParseDuration(s.Delay)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("invalid delay %q", s.Delay)
+	}
+	return delay, nil
+}
+
+// escalationPolicyFor はインシデントの通知に最初に一致したポリシーを返します。一致しない場合や設定が不正な場合は nil を返します
+func escalationPolicyFor(req models.NotificationRequest) *EscalationPolicy {
+	if req.IncidentID == 0 {
+		return nil
+	}
+	policies, err := escalationPolicies()
+	if err != nil {
+		logger.Logger.Error("エスカレーションポリシーの読み込みに失敗しました", zap.Error(err))
+		return nil
+	}
+	severity := models.ParseSeverity(req.Priority)
+	for i := range policies {
+		if len(policies[i].Priorities) == 0 {
+			return &policies[i]
+		}
+		for _, priority := range policies[i].Priorities {
+			if models.ParseSeverity(priority) == severity {
+				return &policies[i]
+			}
+		}
+	}
+	return nil
+}
+
+// findEscalationPolicy は名前でポリシーを返します
+func findEscalationPolicy(name string) (*EscalationPolicy, error) {
+	policies, err := escalationPolicies()
+	if err != nil {
+		return nil, err
+	}
+	for i := range policies {
+		if policies[i].Name == name {
+			return &policies[i], nil
+		}
+	}
+	return nil, fmt.Errorf("escalation policy %q not found", name)
+}
+
+// nextEscalationAt は step 番目の段階を実行する時刻を返します（段階がない場合は nil）
+func nextEscalationAt(policy EscalationPolicy, step int, from time.Time) *time.Time {
+	if step >= len(policy.Steps) {
+		return nil
+	}
+	delay, _ := policy.Steps[step].delay()
+	next := from.Add(delay)
+	return &next
+}
+
+// startEscalation はインシデントのエスカレーションをdbpilotに登録します（同じインシデントの通知では最初からやり直します）
+func startEscalation(req models.NotificationRequest, policy EscalationPolicy) error {
+	notification, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %v", err)
+	}
+	body := map[string]interface{}{
+		"policy":       policy.Name,
+		"next_at":      nextEscalationAt(policy, 0, time.Now()),
+		"notification": json.RawMessage(notification),
+	}
+	if err := callDBPilot(http.MethodPut, fmt.Sprintf("/escalations/%d", req.IncidentID), body, nil); err != nil {
+		return err
+	}
+	logger.Logger.Info("エスカレーションを開始しました",
+		zap.Uint("incident_id", req.IncidentID),
+		zap.String("policy", policy.Name),
+	)
+	return nil
+}
+
+// StartEscalationWorker は予定を過ぎたエスカレーションの段階を ESCALATION_POLL_INTERVAL ごとに実行します。
+// 状態はdbpilotに保存するため、再起動や複数のインスタンスでも段階を重ねて実行しません。戻り値の関数で停止します
+func StartEscalationWorker() func() {
+	interval := defaultEscalationPollInterval
+	if d, err := time.ParseDuration(os.Getenv("ESCALATION_POLL_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if os.Getenv("ESCALATION_POLICIES") != "" {
+					runDueEscalations()
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// runDueEscalations は予定を過ぎたエスカレーションの次の段階を実行します
+func runDueEscalations() {
+	var due struct {
+		Data []escalationState `json:"data"`
+	}
+	if err := callDBPilot(http.MethodGet, "/escalations?due=true", nil, &due); err != nil {
+		logger.Logger.Error("エスカレーションの予定の取得に失敗しました", zap.Error(err))
+		return
+	}
+	for _, state := range due.Data {
+		runEscalationStep(state)
+	}
+}
+
+// runEscalationStep はエスカレーションの段階を1つ実行します。
+// インシデントが確認済みの場合は止め、段階を進められた（他のインスタンスが実行していない）場合だけ通知します
+func runEscalationStep(state escalationState) {
+	fields := []zap.Field{
+		zap.Uint("incident_id", state.IncidentID),
+		zap.String("policy", state.Policy),
+		zap.Int("step", state.Step+1),
+	}
+
+	acknowledged, err := incidentAcknowledged(state.IncidentID)
+	if err != nil {
+		logger.Logger.Warn("インシデントの状態の取得に失敗しました", append(fields, zap.Error(err))...)
+	} else if acknowledged {
+		body := map[string]string{"acknowledged_by": "dbpilot"}
+		if err := callDBPilot(http.MethodPost, fmt.Sprintf("/escalations/%d/acknowledge", state.IncidentID), body, nil); err != nil {
+			logger.Logger.Error("エスカレーションの停止に失敗しました", append(fields, zap.Error(err))...)
+		}
+		return
+	}
+
+	policy, err := findEscalationPolicy(state.Policy)
+	var req models.NotificationRequest
+	if err == nil {
+		err = json.Unmarshal([]byte(state.Notification), &req)
+	}
+	if err != nil || state.Step >= len(policy.Steps) {
+		// ポリシーが削除・変更された場合は、それ以上エスカレーションしない
+		logger.Logger.Warn("エスカレーションを続行できないため終了します", append(fields, zap.Error(err))...)
+		body := map[string]interface{}{"from_step": state.Step, "next_at": nil}
+		callDBPilot(http.MethodPost, fmt.Sprintf("/escalations/%d/advance", state.IncidentID), body, nil)
+		return
+	}
+
+	body := map[string]interface{}{
+		"from_step": state.Step,
+		"next_at":   nextEscalationAt(*policy, state.Step+1, time.Now()),
+	}
+	if err := callDBPilot(http.MethodPost, fmt.Sprintf("/escalations/%d/advance", state.IncidentID), body, nil); err != nil {
+		// 他のインスタンスが実行済み、または確認済み
+		logger.Logger.Info("エスカレーションの段階を実行しません", append(fields, zap.Error(err))...)
+		return
+	}
+
+	step := policy.Steps[state.Step]
+	req.Title = fmt.Sprintf("[エスカレーション %d/%d] %s", state.Step+1, len(policy.Steps), req.Title)
+	req.Channels = step.Channels
+	req.TeamsChannels = step.TeamsChannels
+	req.SlackChannels = step.SlackChannels
+	req.SMSRecipients = step.SMSRecipients
+	req.VoiceRecipients = step.VoiceRecipients
+	req.EmailRecipients = step.EmailRecipients
+
+	channels, err := notificationChannels(req)
+	if err != nil {
+		logger.Logger.Error("エスカレーションの送信先が不正です", append(fields, zap.Error(err))...)
+		return
+	}
+	for _, channel := range channels {
+		if err := sendChannel(channel, req); err != nil {
+			logger.Logger.Error("エスカレーションの通知に失敗しました", append(fields, zap.String("channel", channel), zap.Error(err))...)
+			continue
+		}
+		logger.Logger.Info("エスカレーションの通知を送信しました", append(fields, zap.String("channel", channel))...)
+	}
+}
+
+// AcknowledgeRequest はインシデントの確認のリクエストです
+type AcknowledgeRequest struct {
+	Responder string `json:"responder" binding:"required"`
+	Content   string `json:"content"`
+}
+
+// AcknowledgeHandler はインシデントを確認済み（調査中）にし、エスカレーションを止めます。
+// dbpilotの対応履歴として保存するため、Teams・電話からの確認と同じくエスカレーションも確認済みになります
+func AcknowledgeHandler(c *gin.Context) {
+	incidentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || incidentID == 0 {
+		RespondWithError(c, http.StatusBadRequest, "Invalid incident id")
+		return
+	}
+	var req AcknowledgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondWithError(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	if req.Content == "" {
+		req.Content = "通知を確認しました"
+	}
+
+	if err := forwardAcknowledge(uint(incidentID), req.Responder, req.Content); err != nil {
+		logger.Logger.Error("インシデントの確認のdbpilotへの転送に失敗しました",
+			zap.Error(err),
+			zap.Uint64("incident_id", incidentID),
+		)
+		RespondWithError(c, http.StatusBadGateway, "Failed to acknowledge incident")
+		return
+	}
+
+	logger.Logger.Info("インシデントが確認されました",
+		zap.Uint64("incident_id", incidentID),
+		zap.String("responder", req.Responder),
+	)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "incident_id": incidentID, "incident_status": acknowledgedStatus})
+}
+
+// callDBPilot はdbpilotのAPIをサービス間認証で呼び出し、結果を out（nil の場合は読み捨て）に読み込みます
+func callDBPilot(method, path string, body interface{}, out interface{}) error {
+	reader := bytes.NewReader(nil)
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal DB pilot request: %v", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, os.Getenv("DB_PILOT_SERVICE_URL")+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create DB pilot request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+serviceauth.PrimaryServiceToken())
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send DB pilot request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("DB pilot returned status %d", resp.StatusCode)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode DB pilot response: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"notification/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// notificationHistoryFilters は通知の履歴の絞り込みに使用できるクエリパラメーターと、dbpilotの送信状況の項目の対応です
+var notificationHistoryFilters = map[string]string{
+	"incident_id": "incident_id",
+	"recipient":   "target", // チャンネルの名前・電話番号・メールアドレス
+	"channel":     "channel",
+	"status":      "status", // pending / sent / retrying / failed
+	"from":        "from",   // RFC3339 または日付（2006-01-02）
+	"to":          "to",
+	"limit":       "limit",
+	"offset":      "offset",
+}
+
+// NotificationHistoryHandler は送信した通知の履歴（dbpilotに保存した送信状況）を返します。
+// 振り返りで「誰にいつ通知が届いたか」を確認するため、インシデント・宛先・送信先の種類・状態・日時で絞り込めます
+func NotificationHistoryHandler(c *gin.Context) {
+	query := url.Values{}
+	for param, field := range notificationHistoryFilters {
+		if value := c.Query(param); value != "" {
+			query.Set(field, value)
+		}
+	}
+
+	var history json.RawMessage
+	if err := callDBPilot(http.MethodGet, "/notification-deliveries?"+query.Encode(), nil, &history); err != nil {
+		logger.Logger.Error("通知の履歴の取得に失敗しました", zap.Error(err))
+		RespondWithError(c, http.StatusBadGateway, "Failed to fetch notification history")
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", history)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"notification/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// defaultLoginLinkTTL はリクエストに有効期間がない場合にメールに表示する有効期間
+const defaultLoginLinkTTL = 15 * time.Minute
+
+// LoginLinkRequest はauthサービスからのログインリンクの送信リクエストです
+type LoginLinkRequest struct {
+	Email            string    `json:"email" binding:"required,email"`
+	LoginURL         string    `json:"login_url" binding:"required"`
+	ExpiresInMinutes int       `json:"expires_in_minutes"`
+	ExpiresAt        time.Time `json:"expires_at"`
+}
+
+// expiresIn はメールに表示するリンクの有効期間を返します
+func (r LoginLinkRequest) expiresIn() time.Duration {
+	if r.ExpiresInMinutes > 0 {
+		return time.Duration(r.ExpiresInMinutes) * time.Minute
+	}
+	if !r.ExpiresAt.IsZero() {
+		if d := time.Until(r.ExpiresAt); d > 0 {
+			return d
+		}
+	}
+	return defaultLoginLinkTTL
+}
+
+// SendLoginLink はログインリンクのメールを宛先の言語のテンプレート（login_link）で作成し、
+// MAIL_PROVIDERS のプロバイダーで送信します
+func SendLoginLink(c *gin.Context) {
+	var req LoginLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondWithError(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	err := SendMail(c.Request.Context(), req.Email, MailTemplateLoginLink, LoginLinkData{
+		Email:     req.Email,
+		LoginURL:  req.LoginURL,
+		ExpiresIn: req.expiresIn(),
+	})
+	switch {
+	case errors.Is(err, ErrRecipientSuppressed):
+		RespondWithError(c, http.StatusUnprocessableEntity, "Recipient address is suppressed")
+		return
+	case errors.Is(err, ErrMailerNotConfigured):
+		RespondWithError(c, http.StatusServiceUnavailable, "Mail provider is not configured")
+		return
+	case err != nil:
+		logger.Logger.Error("ログインリンクのメールの送信に失敗しました",
+			zap.String("email", maskEmail(req.Email)),
+			zap.Error(err),
+		)
+		RespondWithError(c, http.StatusInternalServerError, "Failed to send login email")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Login link sent successfully"})
+}
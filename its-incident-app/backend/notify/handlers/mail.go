@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"notification/logger"
+	"notification/mailer"
+
+	"go.uber.org/zap"
+)
+
+// ChannelEmail はメールの送信先（送信状況の記録に使用）
+const ChannelEmail = "email"
+
+// ErrRecipientSuppressed は送信しない宛先（恒久的な不達・迷惑メールの報告）へのメールであることを表します
+var ErrRecipientSuppressed = errors.New("recipient is suppressed")
+
+// ErrMailerNotConfigured はメールの送信プロバイダー（MAIL_PROVIDERS）が設定されていないことを表します
+var ErrMailerNotConfigured = errors.New("mailer is not configured")
+
+// mailSender はメールの送信に使用するプロバイダー（main で MAIL_PROVIDERS から作成）
+var mailSender mailer.Mailer
+
+// ConfigureMailer はメールの送信に使用するプロバイダーを設定します
+func ConfigureMailer(m mailer.Mailer) {
+	mailSender = m
+}
+
+// mailDelivery は送信状況に保存するメールの内容です。再送時は Data から宛先の言語のテンプレートで作り直します。
+// ログインリンクのメールは本文にリンクを含むため Data を保存せず、再送もしません（期限が短いため、利用者が再度要求する）
+type mailDelivery struct {
+	Template MailTemplate    `json:"template"`
+	Subject  string          `json:"subject"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// SendMail は宛先の言語のテンプレートでメール通知を作成し、送信します。
+// 送信しない宛先の場合は ErrRecipientSuppressed を返します（確認に失敗した場合は送信します）。
+// すべてのプロバイダーで送信できなかった通知のメールは、再送のワーカー（StartDeliveryRetryWorker）が再送します
+func SendMail(ctx context.Context, to string, kind MailTemplate, data interface{}) error {
+	if mailSender == nil {
+		return ErrMailerNotConfigured
+	}
+
+	if err := checkSuppressed(to, kind); err != nil {
+		return err
+	}
+
+	subject, body, err := RenderMail(kind, RecipientLocale(to), data)
+	if err != nil {
+		return err
+	}
+
+	record := mailDelivery{Template: kind, Subject: subject}
+	retryable := kind != MailTemplateLoginLink
+	if retryable {
+		if record.Data, err = json.Marshal(data); err != nil {
+			return fmt.Errorf("failed to marshal mail data: %v", err)
+		}
+	}
+	err = deliverPayload(ChannelEmail, to, mailIncidentID(data), record, func() error {
+		err := mailSender.Send(ctx, mailer.Message{To: []string{to}, Subject: subject, HTML: body})
+		if err != nil && retryable {
+			// 代替のプロバイダーを含めてすべて失敗した場合は、時間をおいて再送する
+			return transient(err)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Logger.Info("メールを送信しました",
+		zap.String("email", maskEmail(to)),
+		zap.String("template", string(kind)),
+	)
+	return nil
+}
+
+// resendMail は送信状況に保存したメールの内容から、宛先の言語のテンプレートでメールを作り直して再送します
+func resendMail(to, payload string) error {
+	if mailSender == nil {
+		return ErrMailerNotConfigured
+	}
+
+	var record mailDelivery
+	if err := json.Unmarshal([]byte(payload), &record); err != nil {
+		return fmt.Errorf("invalid payload: %v", err)
+	}
+	if len(record.Data) == 0 {
+		return fmt.Errorf("mail %s cannot be resent", record.Template)
+	}
+
+	var data interface{}
+	switch record.Template {
+	case MailTemplateIncidentCreated:
+		var d IncidentCreatedData
+		if err := json.Unmarshal(record.Data, &d); err != nil {
+			return fmt.Errorf("invalid mail data: %v", err)
+		}
+		data = d
+	case MailTemplateSLABreach:
+		var d SLABreachData
+		if err := json.Unmarshal(record.Data, &d); err != nil {
+			return fmt.Errorf("invalid mail data: %v", err)
+		}
+		data = d
+	default:
+		return fmt.Errorf("mail %s cannot be resent", record.Template)
+	}
+	// 再送を待っている間に不達・迷惑メールの報告を受けた宛先には送信しない
+	if err := checkSuppressed(to, record.Template); err != nil {
+		return err
+	}
+
+	subject, body, err := RenderMail(record.Template, RecipientLocale(to), data)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+	defer cancel()
+	if err := mailSender.Send(ctx, mailer.Message{To: []string{to}, Subject: subject, HTML: body}); err != nil {
+		return transient(err)
+	}
+	return nil
+}
+
+// checkSuppressed は送信しない宛先（恒久的な不達・迷惑メールの報告）の場合に ErrRecipientSuppressed を返します。
+// 確認に失敗した場合は、通知が届かないことを避けるため送信します
+func checkSuppressed(to string, kind MailTemplate) error {
+	suppressed, err := EmailSuppressed(to)
+	if err != nil {
+		logger.Logger.Warn("送信しない宛先の確認に失敗しました", zap.String("email", maskEmail(to)), zap.Error(err))
+	}
+	if suppressed {
+		logger.Logger.Info("送信しない宛先のためメールを送信しません",
+			zap.String("email", maskEmail(to)),
+			zap.String("template", string(kind)),
+		)
+		return ErrRecipientSuppressed
+	}
+	return nil
+}
+
+// mailIncidentID はメールの項目からインシデントIDを返します（送信状況の絞り込みに使用）
+func mailIncidentID(data interface{}) uint {
+	switch d := data.(type) {
+	case IncidentCreatedData:
+		return d.IncidentID
+	case SLABreachData:
+		return d.IncidentID
+	default:
+		return 0
+	}
+}
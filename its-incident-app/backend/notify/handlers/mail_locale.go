@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"notification/logger"
+	"notification/serviceauth"
+
+	"go.uber.org/zap"
+)
+
+// recipientLocaleTTL は宛先の言語をキャッシュする時間（dbpilotへの問い合わせをメールごとに行わない）
+const recipientLocaleTTL = 10 * time.Minute
+
+type cachedLocale struct {
+	locale    string
+	fetchedAt time.Time
+}
+
+var (
+	recipientLocalesMu sync.Mutex
+	recipientLocales   = map[string]cachedLocale{}
+)
+
+// RecipientLocale はdbpilotのプロフィールに設定された宛先の言語を返します。
+// 利用者として登録されていない宛先や、取得に失敗した場合は空（既定の言語）を返します
+func RecipientLocale(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return ""
+	}
+
+	recipientLocalesMu.Lock()
+	cached, ok := recipientLocales[email]
+	recipientLocalesMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < recipientLocaleTTL {
+		return cached.locale
+	}
+
+	locale, err := fetchRecipientLocale(email)
+	if err != nil {
+		logger.Logger.Warn("宛先の言語の取得に失敗しました。既定の言語で送信します", zap.Error(err))
+		return ""
+	}
+	recipientLocalesMu.Lock()
+	recipientLocales[email] = cachedLocale{locale: locale, fetchedAt: time.Now()}
+	recipientLocalesMu.Unlock()
+	return locale
+}
+
+// fetchRecipientLocale はdbpilotのユーザー検索（/directory/users）から宛先の言語を取得します
+func fetchRecipientLocale(email string) (string, error) {
+	endpoint := fmt.Sprintf("%s/directory/users?limit=1&email=%s", os.Getenv("DB_PILOT_SERVICE_URL"), url.QueryEscape(email))
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create DB pilot request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+serviceauth.PrimaryServiceToken())
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send DB pilot request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("DB pilot returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Items []struct {
+			Locale string `json:"locale"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode directory users: %v", err)
+	}
+	if len(result.Items) == 0 {
+		return "", nil
+	}
+	return result.Items[0].Locale, nil
+}
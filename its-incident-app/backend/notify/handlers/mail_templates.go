@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"notification/logger"
+
+	"go.uber.org/zap"
+)
+
+// MailTemplate はメール通知の種類（templates/<種類>.<言語>.html）
+type MailTemplate string
+
+const (
+	MailTemplateLoginLink       MailTemplate = "login_link"
+	MailTemplateIncidentCreated MailTemplate = "incident_created"
+	MailTemplateSLABreach       MailTemplate = "sla_breach"
+)
+
+// LoginLinkData はログインリンクのメールの項目
+type LoginLinkData struct {
+	Email     string
+	LoginURL  string
+	ExpiresIn time.Duration
+}
+
+// IncidentCreatedData はインシデント登録のメールの項目
+type IncidentCreatedData struct {
+	IncidentID  uint
+	Title       string
+	Priority    string
+	Responder   string
+	Content     string
+	IncidentURL string
+}
+
+// SLABreachData はSLA超過のメールの項目
+type SLABreachData struct {
+	IncidentID  uint
+	Title       string
+	Priority    string
+	Status      string
+	Deadline    time.Time
+	Overdue     time.Duration
+	IncidentURL string
+}
+
+// mailLocales はメール通知のテンプレートを用意している言語
+var mailLocales = []string{"ja", "en"}
+
+// defaultMailLocale は MAIL_DEFAULT_LOCALE が設定されていない場合の既定の言語
+const defaultMailLocale = "ja"
+
+//go:embed templates/*.html
+var embeddedMailTemplates embed.FS
+
+// mailTemplateSamples は起動時の検証でテンプレートに渡す値（項目名の誤りを実行時ではなく起動時に検出する）
+var mailTemplateSamples = map[MailTemplate]interface{}{
+	MailTemplateLoginLink: LoginLinkData{
+		Email:     "user@example.com",
+		LoginURL:  "https://example.com/login?token=sample",
+		ExpiresIn: 15 * time.Minute,
+	},
+	MailTemplateIncidentCreated: IncidentCreatedData{
+		IncidentID:  1,
+		Title:       "サンプル",
+		Priority:    "高",
+		Responder:   "担当者",
+		Content:     "本文",
+		IncidentURL: "https://example.com/work?incident=1",
+	},
+	MailTemplateSLABreach: SLABreachData{
+		IncidentID:  1,
+		Title:       "サンプル",
+		Priority:    "高",
+		Status:      "未着手",
+		Deadline:    time.Now(),
+		Overdue:     90 * time.Minute,
+		IncidentURL: "https://example.com/work?incident=1",
+	},
+}
+
+// mailTemplateKey はテンプレートの種類と言語の組
+type mailTemplateKey struct {
+	kind   MailTemplate
+	locale string
+}
+
+var (
+	mailTemplatesMu sync.RWMutex
+	mailTemplates   = map[mailTemplateKey]*template.Template{}
+)
+
+// LoadMailTemplates はメール通知のテンプレートを言語ごとに読み込み、検証します。
+// dir（MAIL_TEMPLATE_DIR）を指定した場合は、そのディレクトリーにあるファイルで組み込みのテンプレートを置き換えます。
+// テンプレートごとに subject と body を定義する必要があり、いずれかのテンプレートが不正な場合はエラーを返します
+func LoadMailTemplates(dir string) error {
+	loaded := make(map[mailTemplateKey]*template.Template, len(mailTemplateSamples)*len(mailLocales))
+	for kind, sample := range mailTemplateSamples {
+		for _, locale := range mailLocales {
+			name := fmt.Sprintf("%s.%s.html", kind, locale)
+			var fsys fs.FS = embeddedMailTemplates
+			file, source := path.Join("templates", name), "embedded"
+			if dir != "" {
+				if _, err := os.Stat(path.Join(dir, name)); err == nil {
+					fsys, file, source = os.DirFS(dir), name, path.Join(dir, name)
+				}
+			}
+
+			tmpl, err := template.New(name).Funcs(mailTemplateFuncs(locale)).ParseFS(fsys, file)
+			if err != nil {
+				return fmt.Errorf("failed to parse mail template %s: %v", name, err)
+			}
+			for _, block := range []string{"subject", "body"} {
+				if tmpl.Lookup(block) == nil {
+					return fmt.Errorf("mail template %s does not define %q", name, block)
+				}
+				if err := tmpl.ExecuteTemplate(&bytes.Buffer{}, block, sample); err != nil {
+					return fmt.Errorf("invalid mail template %s: %v", name, err)
+				}
+			}
+			loaded[mailTemplateKey{kind, locale}] = tmpl
+			logger.Logger.Info("メールのテンプレートを読み込みました",
+				zap.String("template", string(kind)),
+				zap.String("locale", locale),
+				zap.String("source", source),
+			)
+		}
+	}
+
+	mailTemplatesMu.Lock()
+	mailTemplates = loaded
+	mailTemplatesMu.Unlock()
+	return nil
+}
+
+// mailLocaleChain は言語の指定からテンプレートを探す順番を返します。
+// 指定された言語（en-US）→ 言語の部分（en）→ MAIL_DEFAULT_LOCALE → ja の順に探します
+func mailLocaleChain(locale string) []string {
+	var chain []string
+	add := func(l string) {
+		l = strings.ToLower(strings.TrimSpace(l))
+		if l == "" {
+			return
+		}
+		for _, existing := range chain {
+			if existing == l {
+				return
+			}
+		}
+		chain = append(chain, l)
+	}
+	add(locale)
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		add(locale[:i])
+	}
+	add(os.Getenv("MAIL_DEFAULT_LOCALE"))
+	add(defaultMailLocale)
+	return chain
+}
+
+// RenderMail はメール通知の件名と本文（HTML）を宛先の言語で作成します。
+// locale は宛先の言語（空の場合は既定の言語）、data は種類ごとの項目（LoginLinkData など）です
+func RenderMail(kind MailTemplate, locale string, data interface{}) (subject, body string, err error) {
+	var tmpl *template.Template
+	mailTemplatesMu.RLock()
+	for _, candidate := range mailLocaleChain(locale) {
+		if t, ok := mailTemplates[mailTemplateKey{kind, candidate}]; ok {
+			tmpl = t
+			break
+		}
+	}
+	mailTemplatesMu.RUnlock()
+	if tmpl == nil {
+		return "", "", fmt.Errorf("mail template %s is not loaded", kind)
+	}
+
+	var subjectBuf, bodyBuf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subjectBuf, "subject", data); err != nil {
+		return "", "", fmt.Errorf("failed to render subject of %s: %v", kind, err)
+	}
+	if err := tmpl.ExecuteTemplate(&bodyBuf, "body", data); err != nil {
+		return "", "", fmt.Errorf("failed to render body of %s: %v", kind, err)
+	}
+	// 件名はHTMLではないため、html/template によるエスケープを戻して1行にする
+	subject = strings.Join(strings.Fields(html.UnescapeString(subjectBuf.String())), " ")
+	return subject, bodyBuf.String(), nil
+}
+
+// mailTemplateFuncs はテンプレートで使用する関数（言語ごとの書式）を返します
+func mailTemplateFuncs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"duration": func(d time.Duration) string { return formatDuration(d, locale) },
+	}
+}
+
+// formatDuration は期間を「1時間30分」（英語の場合は "1h 30m"）の形式で返します
+func formatDuration(d time.Duration, locale string) string {
+	d = d.Round(time.Minute)
+	hours, minutes := int(d/time.Hour), int(d%time.Hour/time.Minute)
+	if locale == "en" {
+		switch {
+		case hours > 0 && minutes > 0:
+			return fmt.Sprintf("%dh %dm", hours, minutes)
+		case hours > 0:
+			return fmt.Sprintf("%dh", hours)
+		default:
+			return fmt.Sprintf("%dm", minutes)
+		}
+	}
+	switch {
+	case hours > 0 && minutes > 0:
+		return fmt.Sprintf("%d時間%d分", hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%d時間", hours)
+	default:
+		return fmt.Sprintf("%d分", minutes)
+	}
+}
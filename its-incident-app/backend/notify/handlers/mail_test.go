@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"notification/mailer"
+	"notification/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordingMailer は送信したメールを記録するテスト用のプロバイダーです
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Name() string { return "recording" }
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *recordingMailer) messages() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mailer.Message(nil), m.sent...)
+}
+
+// fakeDBPilot はメールの送信で参照するdbpilotのAPI（利用者の言語・送信しない宛先）のテスト用の実装です
+type fakeDBPilot struct {
+	locales    map[string]string
+	suppressed map[string]bool
+}
+
+func (f *fakeDBPilot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("email")
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/directory/users":
+		items := []map[string]string{}
+		if locale, ok := f.locales[email]; ok {
+			items = append(items, map[string]string{"email": email, "locale": locale})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	case "/email-suppressions":
+		count := 0
+		if f.suppressed[email] {
+			count = 1
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"count": count})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// setupMailTest はテンプレートを読み込み、テスト用のプロバイダーとdbpilotを設定します
+func setupMailTest(t *testing.T, dbpilot *fakeDBPilot) *recordingMailer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	server := httptest.NewServer(dbpilot)
+	t.Cleanup(server.Close)
+	t.Setenv("DB_PILOT_SERVICE_URL", server.URL)
+	t.Setenv("DELIVERY_TRACKING", "false")
+	t.Setenv("MAIL_DEFAULT_LOCALE", "")
+
+	if err := LoadMailTemplates(""); err != nil {
+		t.Fatalf("LoadMailTemplates: %v", err)
+	}
+
+	recipientLocalesMu.Lock()
+	recipientLocales = map[string]cachedLocale{}
+	recipientLocalesMu.Unlock()
+
+	recorder := &recordingMailer{}
+	ConfigureMailer(recorder)
+	t.Cleanup(func() { ConfigureMailer(nil) })
+	return recorder
+}
+
+// postJSON はハンドラーにJSONのリクエストを送信し、レスポンスを返します
+func postJSON(t *testing.T, handler gin.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(payload))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return w
+}
+
+// notificationForTest はメールの宛先を指定したインシデントの通知を返します
+func notificationForTest(recipients ...string) models.NotificationRequest {
+	return models.NotificationRequest{
+		IncidentID:      42,
+		Title:           "メールサーバーの障害",
+		Content:         "メールが送信できません",
+		Priority:        "高",
+		EmailRecipients: recipients,
+	}
+}
+
+func TestSendLoginLinkUsesRecipientLocale(t *testing.T) {
+	recorder := setupMailTest(t, &fakeDBPilot{locales: map[string]string{
+		"en@example.com":   "en",
+		"enus@example.com": "en-US",
+		"ja@example.com":   "ja",
+	}})
+
+	tests := []struct {
+		email       string
+		wantSubject string
+		wantBody    string
+	}{
+		{"en@example.com", "Your login link", `lang="en"`},
+		{"enus@example.com", "Your login link", `lang="en"`},
+		{"ja@example.com", "ログインリンク", `lang="ja"`},
+		// 利用者として登録されていない宛先は既定の言語
+		{"unknown@example.com", "ログインリンク", `lang="ja"`},
+	}
+	for _, tt := range tests {
+		w := postJSON(t, SendLoginLink, map[string]interface{}{
+			"email":              tt.email,
+			"login_url":          "https://example.com/auth/verify?token=abc",
+			"expires_in_minutes": 15,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", tt.email, w.Code, w.Body.String())
+		}
+
+		sent := recorder.messages()
+		msg := sent[len(sent)-1]
+		if len(msg.To) != 1 || msg.To[0] != tt.email {
+			t.Errorf("%s: to = %v", tt.email, msg.To)
+		}
+		if msg.Subject != tt.wantSubject {
+			t.Errorf("%s: subject = %q, want %q", tt.email, msg.Subject, tt.wantSubject)
+		}
+		if !strings.Contains(msg.HTML, tt.wantBody) || !strings.Contains(msg.HTML, "token=abc") {
+			t.Errorf("%s: unexpected body %q", tt.email, msg.HTML)
+		}
+	}
+}
+
+func TestNotifyEmailUsesRecipientLocale(t *testing.T) {
+	recorder := setupMailTest(t, &fakeDBPilot{locales: map[string]string{"en@example.com": "en"}})
+	t.Setenv("INCIDENT_URL_TEMPLATE", "https://incident.example.com/work?incident={id}")
+
+	err := sendChannel(ChannelEmail, notificationForTest("en@example.com", "ja@example.com"))
+	if err != nil {
+		t.Fatalf("sendChannel: %v", err)
+	}
+
+	sent := recorder.messages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d mails, want 2", len(sent))
+	}
+	for _, msg := range sent {
+		var want string
+		switch msg.To[0] {
+		case "en@example.com":
+			want = "Incident #42"
+		case "ja@example.com":
+			want = "インシデント #42"
+		}
+		if !strings.Contains(msg.Subject, want) {
+			t.Errorf("%s: subject = %q, want %q", msg.To[0], msg.Subject, want)
+		}
+		if !strings.Contains(msg.HTML, "https://incident.example.com/work?incident=42") {
+			t.Errorf("%s: body does not contain the incident URL", msg.To[0])
+		}
+	}
+}
+
+func TestSuppressedRecipientsAreNotSent(t *testing.T) {
+	recorder := setupMailTest(t, &fakeDBPilot{suppressed: map[string]bool{"bounced@example.com": true}})
+
+	w := postJSON(t, SendLoginLink, map[string]interface{}{
+		"email":     "bounced@example.com",
+		"login_url": "https://example.com/auth/verify?token=abc",
+	})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("login link status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	// 通知のメールは送信しない宛先だけを飛ばし、残りの宛先には送信する
+	if err := sendChannel(ChannelEmail, notificationForTest("bounced@example.com", "ok@example.com")); err != nil {
+		t.Fatalf("sendChannel: %v", err)
+	}
+
+	// 再送を待っている間に送信しない宛先になった場合も送信しない
+	data, _ := json.Marshal(IncidentCreatedData{IncidentID: 42, Title: "障害"})
+	payload, _ := json.Marshal(mailDelivery{Template: MailTemplateIncidentCreated, Data: data})
+	if err := resendMail("bounced@example.com", string(payload)); err != ErrRecipientSuppressed {
+		t.Errorf("resendMail error = %v, want %v", err, ErrRecipientSuppressed)
+	}
+
+	sent := recorder.messages()
+	if len(sent) != 1 || sent[0].To[0] != "ok@example.com" {
+		t.Errorf("unexpected sent mails: %+v", sent)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"notification/models"
+	"notification/secrets"
 	"os"
 	"strings"
 
@@ -20,36 +21,163 @@ func NotifyHandler(c *gin.Context) {
 		return
 	}
 
-	teamsWebhookURL := os.Getenv("TEAMS_WEBHOOK_URL")
-	if teamsWebhookURL == "" {
-		RespondWithError(c, http.StatusInternalServerError, "Teams webhook URL not configured")
+	channels, err := notificationChannels(req)
+	if err != nil {
+		RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(channels) == 0 {
+		RespondWithError(c, http.StatusInternalServerError, "No notification channel configured")
 		return
 	}
 
-	if err := SendTeamsNotification(teamsWebhookURL, req); err != nil {
-		RespondWithError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to send notification: %v", err))
+	// 一部の送信先に失敗しても、残りの送信先には通知する
+	results := map[string]string{}
+	var failures []string
+	digest := digestEligible(req)
+	for _, channel := range channels {
+		// 重要度の低い通知はチャット・SMSの宛先ごとにまとめて送信する
+		if digest && channel != ChannelVoice && channel != ChannelEmail {
+			if err := enqueueDigest(channel, req); err != nil {
+				results[channel] = "failed"
+				failures = append(failures, fmt.Sprintf("%s: %v", channel, err))
+				continue
+			}
+			results[channel] = "queued"
+			continue
+		}
+
+		if err := sendChannel(channel, req); err != nil {
+			results[channel] = "failed"
+			failures = append(failures, fmt.Sprintf("%s: %v", channel, err))
+			continue
+		}
+		results[channel] = "success"
+	}
+	if len(failures) == len(channels) {
+		RespondWithError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to send notification: %s", strings.Join(failures, "; ")))
 		return
 	}
+	// 確認されない場合のエスカレーション（ポリシーがない場合は、最後の手段として電話をかける）
+	if policy := escalationPolicyFor(req); policy != nil {
+		if err := startEscalation(req, *policy); err != nil {
+			failures = append(failures, fmt.Sprintf("escalation: %v", err))
+		}
+	} else if _, called := results[ChannelVoice]; !called {
+		scheduleVoiceEscalation(req)
+	}
 
 	authHeader := c.GetHeader("Authorization")
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	endpoint := os.Getenv("DB_PILOT_SERVICE_URL") + "/responses"
 
-	_, err := SendDBpilot(req, token, endpoint)
+	_, err = SendDBpilot(req, token, endpoint)
 	if err != nil {
 		fmt.Printf("db pilot error: %V\n", err)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Notification sent successfully",
-		"status":  "success",
-	})
+	response := gin.H{
+		"message":  "Notification sent successfully",
+		"status":   "success",
+		"priority": models.PriorityMetadata(req.Priority),
+		"channels": results,
+	}
+	if len(failures) > 0 {
+		response["errors"] = failures
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// sendChannel は送信先の種類ごとに通知を送信します
+func sendChannel(channel string, req models.NotificationRequest) error {
+	switch channel {
+	case ChannelTeams:
+		return sendTeams(req)
+	case ChannelSlack:
+		return sendSlack(req)
+	case ChannelSMS:
+		return sendSMS(req)
+	case ChannelVoice:
+		return sendVoice(req)
+	case ChannelEmail:
+		return sendEmail(req)
+	default:
+		return fmt.Errorf("unknown channel: %s", channel)
+	}
+}
+
+// notificationChannels は通知の送信先を返します。リクエストで指定がない場合は Webhook が設定されている送信先すべてです
+func notificationChannels(req models.NotificationRequest) ([]string, error) {
+	if len(req.Channels) == 0 {
+		var channels []string
+		if teamsConfigured() {
+			channels = append(channels, ChannelTeams)
+		}
+		if secrets.Get("SLACK_WEBHOOKS") != "" {
+			channels = append(channels, ChannelSlack)
+		}
+		// SMSは重要度ごとの宛先（SMS_RECIPIENTS）がある通知だけに送る
+		if smsConfigured() {
+			if recipients, err := smsRecipientsFor(req); err == nil && len(recipients) > 0 {
+				channels = append(channels, ChannelSMS)
+			}
+		}
+		// メールも重要度ごとの宛先（EMAIL_RECIPIENTS）がある通知だけに送る
+		if emailConfigured() {
+			if recipients, err := emailRecipientsFor(req); err == nil && len(recipients) > 0 {
+				channels = append(channels, ChannelEmail)
+			}
+		}
+		return channels, nil
+	}
+
+	var channels []string
+	for _, channel := range req.Channels {
+		switch channel = strings.ToLower(strings.TrimSpace(channel)); channel {
+		case ChannelTeams:
+			if !teamsConfigured() {
+				return nil, fmt.Errorf("Teams webhook URL not configured")
+			}
+		case ChannelSlack:
+			if secrets.Get("SLACK_WEBHOOKS") == "" {
+				return nil, fmt.Errorf("Slack webhooks not configured")
+			}
+		case ChannelSMS:
+			if !smsConfigured() {
+				return nil, fmt.Errorf("Twilio is not configured")
+			}
+		case ChannelVoice:
+			if !voiceConfigured() {
+				return nil, fmt.Errorf("Twilio voice is not configured")
+			}
+		case ChannelEmail:
+			if !emailConfigured() {
+				return nil, fmt.Errorf("Mail provider is not configured")
+			}
+		default:
+			return nil, fmt.Errorf("unknown channel: %s", channel)
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
 }
 
+// SendTeamsNotification はTeamsのWebhook（Workflows）にAdaptive Cardを送信します。
+// 従来の title・content を参照するフロー向けに、同じ項目もリクエストに含めます
 func SendTeamsNotification(webhookURL string, notification models.NotificationRequest) error {
+	priority := models.PriorityMetadata(notification.Priority)
 	teamsReq := map[string]interface{}{
-		"title":   notification.Title,
-		"content": notification.Content,
+		"type": "message",
+		"attachments": []map[string]interface{}{
+			{
+				"contentType": adaptiveCardContentType,
+				"content":     buildAdaptiveCard(notification),
+			},
+		},
+		"title":      notification.Title,
+		"content":    notification.Content,
+		"importance": priority.TeamsImportance,
+		"priority":   priority,
 	}
 
 	teamsReqJSON, err := json.Marshal(teamsReq)
@@ -59,12 +187,13 @@ func SendTeamsNotification(webhookURL string, notification models.NotificationRe
 
 	resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(teamsReqJSON))
 	if err != nil {
-		return fmt.Errorf("error sending request: %v", err)
+		return transient(fmt.Errorf("error sending request: %v", err))
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("teams webhook returned unexpected status: %d", resp.StatusCode)
+	// Workflows は 202、従来のIncoming Webhookは 200 を返す
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return statusError(resp.StatusCode, fmt.Errorf("teams webhook returned unexpected status: %d", resp.StatusCode))
 	}
 
 	return nil
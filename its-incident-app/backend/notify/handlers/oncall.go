@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"notification/logger"
+	"notification/models"
+
+	"go.uber.org/zap"
+)
+
+// OnCallShift はオンコール当番の担当時間です。
+// Start が End より後の場合は日をまたぐ当番（22:00〜翌09:00 など）で、Weekdays は開始する曜日を指定します
+type OnCallShift struct {
+	Name     string   `json:"name"`
+	Phone    string   `json:"phone"`    // E.164形式
+	Weekdays []string `json:"weekdays"` // mon〜sun（省略時は毎日）
+	Start    string   `json:"start"`    // HH:MM
+	End      string   `json:"end"`      // HH:MM
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// onCallLocation は ONCALL_TIMEZONE（当番表・静かな時間帯のタイムゾーン、既定は Asia/Tokyo）を返します
+func onCallLocation() *time.Location {
+	name := os.Getenv("ONCALL_TIMEZONE")
+	if name == "" {
+		name = "Asia/Tokyo"
+	}
+	if loc, err := time.LoadLocation(name); err == nil {
+		return loc
+	}
+	return time.FixedZone("JST", 9*60*60)
+}
+
+// onCallSchedule は ONCALL_SCHEDULE（オンコール当番の担当時間のJSONの配列）を返します。
+// 例: [{"name":"佐藤","phone":"+819012345678","weekdays":["mon","tue","wed","thu","fri"],"start":"18:00","end":"09:00"}]
+func onCallSchedule() ([]OnCallShift, error) {
+	value := os.Getenv("ONCALL_SCHEDULE")
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var shifts []OnCallShift
+	if err := json.Unmarshal([]byte(value), &shifts); err != nil {
+		return nil, fmt.Errorf("invalid ONCALL_SCHEDULE: %v", err)
+	}
+	return shifts, nil
+}
+
+// parseClock は HH:MM を0時からの時間に変換します
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (HH:MM required)", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// onShift は now が当番の担当時間内かを返します（前日に始まった日をまたぐ当番を含む）
+func (s OnCallShift) onShift(now time.Time) (bool, error) {
+	start, err := parseClock(s.Start)
+	if err != nil {
+		return false, err
+	}
+	end, err := parseClock(s.End)
+	if err != nil {
+		return false, err
+	}
+	if end <= start {
+		end += 24 * time.Hour
+	}
+
+	for _, daysAgo := range []int{0, 1} {
+		day := time.Date(now.Year(), now.Month(), now.Day()-daysAgo, 0, 0, 0, 0, now.Location())
+		if len(s.Weekdays) > 0 {
+			matched := false
+			for _, name := range s.Weekdays {
+				if weekday, ok := weekdayNames[strings.ToLower(strings.TrimSpace(name))]; ok && weekday == day.Weekday() {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		if !now.Before(day.Add(start)) && now.Before(day.Add(end)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// onCallNow は now に当番の担当者の電話番号を返します
+func onCallNow(now time.Time) ([]string, error) {
+	shifts, err := onCallSchedule()
+	if err != nil {
+		return nil, err
+	}
+	var phones []string
+	for _, shift := range shifts {
+		on, err := shift.onShift(now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ONCALL_SCHEDULE (%s): %v", shift.Name, err)
+		}
+		if on && shift.Phone != "" && !containsFold(phones, shift.Phone) {
+			phones = append(phones, strings.TrimSpace(shift.Phone))
+		}
+	}
+	return phones, nil
+}
+
+// quietHours は QUIET_HOURS（当番以外の人に連絡しない時間帯、例: 22:00-07:00）が now を含む場合に、その終了時刻を返します
+func quietHours(now time.Time) (time.Time, bool, error) {
+	value := strings.TrimSpace(os.Getenv("QUIET_HOURS"))
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return time.Time{}, false, fmt.Errorf("invalid QUIET_HOURS %q (HH:MM-HH:MM required)", value)
+	}
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid QUIET_HOURS: %v", err)
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid QUIET_HOURS: %v", err)
+	}
+	if end <= start {
+		end += 24 * time.Hour
+	}
+
+	for _, daysAgo := range []int{0, 1} {
+		day := time.Date(now.Year(), now.Month(), now.Day()-daysAgo, 0, 0, 0, 0, now.Location())
+		if !now.Before(day.Add(start)) && now.Before(day.Add(end)) {
+			return day.Add(end), true, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// quietHoursOverride は静かな時間帯でも全員に連絡する重要度（QUIET_HOURS_OVERRIDE_SEVERITIES、既定は critical）かを返します
+func quietHoursOverride(req models.NotificationRequest) bool {
+	severities := os.Getenv("QUIET_HOURS_OVERRIDE_SEVERITIES")
+	if strings.TrimSpace(severities) == "" {
+		severities = string(models.SeverityCritical)
+	}
+	severity := models.ParseSeverity(req.Priority)
+	for _, s := range strings.Split(severities, ",") {
+		if models.ParseSeverity(s) == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// applyQuietHours は静かな時間帯に当番以外の宛先（SMS・電話）に連絡しないよう宛先を絞り込みます。
+// 当番以外の宛先への通知は、当番がいる場合は当番に振り替え、いない場合は静かな時間帯の終了後に later で送信します。
+// 送信を延期した通知はプロセスのメモリにだけ保持するため、再起動した場合は送信しません
+func applyQuietHours(channel string, req models.NotificationRequest, recipients []string, later func([]string)) ([]string, error) {
+	now := time.Now().In(onCallLocation())
+	until, quiet, err := quietHours(now)
+	if err != nil {
+		return nil, err
+	}
+	if !quiet || quietHoursOverride(req) {
+		return recipients, nil
+	}
+
+	onCall, err := onCallNow(now)
+	if err != nil {
+		return nil, err
+	}
+	var deliver, offShift []string
+	for _, recipient := range recipients {
+		if containsFold(onCall, recipient) {
+			deliver = append(deliver, strings.TrimSpace(recipient))
+		} else {
+			offShift = append(offShift, strings.TrimSpace(recipient))
+		}
+	}
+	if len(offShift) == 0 {
+		return deliver, nil
+	}
+
+	if len(onCall) > 0 {
+		for _, phone := range onCall {
+			if !containsFold(deliver, phone) {
+				deliver = append(deliver, phone)
+			}
+		}
+		logger.Logger.Info("静かな時間帯のため当番に振り替えて通知します",
+			zap.String("channel", channel),
+			zap.Uint("incident_id", req.IncidentID),
+			zap.Int("off_shift", len(offShift)),
+			zap.Int("on_call", len(onCall)),
+		)
+		return deliver, nil
+	}
+
+	time.AfterFunc(until.Sub(now), func() { later(offShift) })
+	logger.Logger.Info("静かな時間帯で当番がいないため、通知を延期します",
+		zap.String("channel", channel),
+		zap.Uint("incident_id", req.IncidentID),
+		zap.Int("deferred", len(offShift)),
+		zap.Time("until", until),
+	)
+	return deliver, nil
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"notification/logger"
+	"notification/secrets"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxSendGridEventsBody はSendGridのイベントのリクエストの上限（SendGridは最大で数千件をまとめて送信する）
+const maxSendGridEventsBody = 10 << 20
+
+// SendGridの署名付きイベントのヘッダー
+const (
+	sendGridSignatureHeader = "X-Twilio-Email-Event-Webhook-Signature"
+	sendGridTimestampHeader = "X-Twilio-Email-Event-Webhook-Timestamp"
+)
+
+// SendGridEvent はSendGridのEvent Webhookのイベントです（使用する項目のみ）
+type SendGridEvent struct {
+	Email       string `json:"email"`
+	Timestamp   int64  `json:"timestamp"`
+	Event       string `json:"event"` // delivered / bounce / spamreport / dropped など
+	Type        string `json:"type"`  // bounce の種類（bounce: 恒久的な不達、blocked: 一時的な拒否）
+	Reason      string `json:"reason"`
+	Status      string `json:"status"`
+	SGEventID   string `json:"sg_event_id"`
+	SGMessageID string `json:"sg_message_id"`
+}
+
+// verifySendGridSignature はSendGridの署名（ECDSA、SENDGRID_WEBHOOK_PUBLIC_KEY）を検証します
+func verifySendGridSignature(publicKey, signature, timestamp string, body []byte) error {
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("public key is not ECDSA")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	digest := sha256.Sum256(append([]byte(timestamp), body...))
+	if !ecdsa.VerifyASN1(key, digest[:], sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// SendGridEventsHandler はSendGridのEvent Webhookを受け取り、恒久的な不達・迷惑メールの報告があった宛先をdbpilotに登録します。
+// SendGridから呼び出されるため、サービス間認証の代わりに署名（SENDGRID_WEBHOOK_PUBLIC_KEY）で検証します
+func SendGridEventsHandler(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSendGridEventsBody))
+	if err != nil {
+		RespondWithError(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	publicKey := secrets.Get("SENDGRID_WEBHOOK_PUBLIC_KEY")
+	if publicKey == "" {
+		logger.Logger.Error("SendGridのイベントの署名鍵が設定されていません")
+		RespondWithError(c, http.StatusServiceUnavailable, "SendGrid event webhook is not configured")
+		return
+	}
+	if err := verifySendGridSignature(publicKey, c.GetHeader(sendGridSignatureHeader), c.GetHeader(sendGridTimestampHeader), body); err != nil {
+		logger.Logger.Warn("SendGridのイベントの署名の検証に失敗しました",
+			zap.Error(err),
+			zap.String("client_ip", c.ClientIP()),
+		)
+		RespondWithError(c, http.StatusForbidden, "Invalid signature")
+		return
+	}
+
+	var events []SendGridEvent
+	if err := json.Unmarshal(body, &events); err != nil {
+		RespondWithError(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	counts := map[string]int{}
+	for _, event := range events {
+		if err := processSendGridEvent(event); err != nil {
+			// dbpilotに登録できない場合は、SendGridに再送させる
+			logger.Logger.Error("SendGridのイベントの処理に失敗しました",
+				zap.Error(err),
+				zap.String("event", event.Event),
+				zap.String("sg_event_id", event.SGEventID),
+			)
+			RespondWithError(c, http.StatusServiceUnavailable, "Failed to process events")
+			return
+		}
+		counts[event.Event]++
+	}
+
+	logger.Logger.Info("SendGridのイベントを処理しました", zap.Int("events", len(events)), zap.Any("counts", counts))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "processed": len(events)})
+}
+
+// processSendGridEvent はイベント1件を処理します。恒久的な不達（bounce）と迷惑メールの報告（spamreport）の宛先を送信しない宛先にします
+func processSendGridEvent(event SendGridEvent) error {
+	fields := []zap.Field{
+		zap.String("event", event.Event),
+		zap.String("email", maskEmail(event.Email)),
+		zap.String("sg_message_id", event.SGMessageID),
+	}
+
+	switch event.Event {
+	case "bounce":
+		if event.Type == "blocked" {
+			// 一時的な拒否（受信側の容量・レート制限など）は送信を止めない
+			logger.Logger.Warn("メールが一時的に拒否されました", append(fields, zap.String("reason", event.Reason))...)
+			return nil
+		}
+		return suppressEmail(event, "bounce")
+	case "spamreport":
+		return suppressEmail(event, "spamreport")
+	case "delivered":
+		logger.Logger.Info("メールが配信されました", fields...)
+	case "dropped", "deferred":
+		logger.Logger.Warn("メールが配信されていません", append(fields, zap.String("reason", event.Reason))...)
+	}
+	return nil
+}
+
+// suppressEmail はイベントの宛先をdbpilotの送信しない宛先に登録します
+func suppressEmail(event SendGridEvent, reason string) error {
+	if event.Email == "" {
+		return nil
+	}
+	detail := strings.TrimSpace(strings.Join([]string{event.Status, event.Reason}, " "))
+	body := map[string]interface{}{
+		"email":      event.Email,
+		"reason":     reason,
+		"detail":     detail,
+		"event_at":   time.Unix(event.Timestamp, 0),
+		"message_id": event.SGMessageID,
+	}
+	if err := callDBPilot(http.MethodPut, "/email-suppressions", body, nil); err != nil {
+		return err
+	}
+
+	logger.Logger.Warn("宛先への送信を停止しました",
+		zap.String("email", maskEmail(event.Email)),
+		zap.String("reason", reason),
+		zap.String("detail", detail),
+	)
+	return nil
+}
+
+// EmailSuppressed は宛先が送信しない宛先（恒久的な不達・迷惑メールの報告）かを返します。メールを送信する前に確認します
+func EmailSuppressed(email string) (bool, error) {
+	var result struct {
+		Count int `json:"count"`
+	}
+	path := "/email-suppressions?limit=1&email=" + url.QueryEscape(strings.ToLower(strings.TrimSpace(email)))
+	if err := callDBPilot(http.MethodGet, path, nil, &result); err != nil {
+		return false, err
+	}
+	return result.Count > 0, nil
+}
+
+// maskEmail はログに出力するメールアドレスのローカル部を先頭1文字以外伏せます
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 1 {
+		return email
+	}
+	return email[:1] + strings.Repeat("*", at-1) + email[at:]
+}
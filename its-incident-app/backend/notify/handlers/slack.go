@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"notification/models"
+	"notification/secrets"
+)
+
+// 通知の送信先
+const (
+	ChannelTeams = "teams"
+	ChannelSlack = "slack"
+)
+
+// defaultSlackChannel は重要度のルールがない場合に送信するSlackのチャンネル（SLACK_WEBHOOKS の名前）
+const defaultSlackChannel = "default"
+
+var slackClient = &http.Client{Timeout: 10 * time.Second}
+
+// slackWebhooks は SLACK_WEBHOOKS（チャンネルの名前とIncoming WebhookのURLのJSONオブジェクト）を返します。
+// 例: {"default":"https://hooks.slack.com/services/...","oncall":"https://hooks.slack.com/services/..."}
+func slackWebhooks() (map[string]string, error) {
+	value := secrets.Get("SLACK_WEBHOOKS")
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var webhooks map[string]string
+	if err := json.Unmarshal([]byte(value), &webhooks); err != nil {
+		return nil, fmt.Errorf("invalid SLACK_WEBHOOKS: %v", err)
+	}
+	return webhooks, nil
+}
+
+// slackSeverityRoutes は SLACK_SEVERITY_ROUTES（重要度ごとの送信先のチャンネルのJSONオブジェクト）を返します。
+// 例: {"critical":["oncall","default"],"high":["default"]}
+func slackSeverityRoutes() (map[models.Severity][]string, error) {
+	value := os.Getenv("SLACK_SEVERITY_ROUTES")
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var routes map[models.Severity][]string
+	if err := json.Unmarshal([]byte(value), &routes); err != nil {
+		return nil, fmt.Errorf("invalid SLACK_SEVERITY_ROUTES: %v", err)
+	}
+	return routes, nil
+}
+
+// slackChannelsFor は通知を送信するSlackのチャンネルを返します。
+// リクエストで指定されたチャンネルを優先し、指定がない場合は重要度のルール、ルールもない場合は default に送信します
+func slackChannelsFor(req models.NotificationRequest, routes map[models.Severity][]string) []string {
+	if len(req.SlackChannels) > 0 {
+		return req.SlackChannels
+	}
+	if channels, ok := routes[models.ParseSeverity(req.Priority)]; ok {
+		return channels
+	}
+	return []string{defaultSlackChannel}
+}
+
+// SendSlackNotification はSlackのIncoming WebhookにBlock Kit形式で通知を送信します
+func SendSlackNotification(webhookURL string, notification models.NotificationRequest) error {
+	priority := models.PriorityMetadata(notification.Priority)
+	// header ブロックは空のテキストを受け付けない
+	title := notification.Title
+	if title == "" {
+		title = "通知"
+	}
+
+	fields := []map[string]string{
+		{"type": "mrkdwn", "text": "*重要度*\n" + string(priority.Severity)},
+	}
+	if notification.Name != "" {
+		fields = append(fields, map[string]string{"type": "mrkdwn", "text": "*送信元*\n" + notification.Name})
+	}
+	if notification.IncidentID != 0 {
+		fields = append(fields, map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("*インシデントID*\n%d", notification.IncidentID)})
+	}
+
+	blocks := []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]string{"type": "plain_text", "text": truncate(title, 150)},
+		},
+		{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": truncate(notification.Content, 3000)},
+		},
+		{
+			"type":   "section",
+			"fields": fields,
+		},
+	}
+	slackReq := map[string]interface{}{
+		// 通知のプレビューなど、ブロックを表示できない場合のテキスト
+		"text": title,
+		"attachments": []map[string]interface{}{
+			{"color": priority.SlackColor, "blocks": blocks},
+		},
+	}
+
+	slackReqJSON, err := json.Marshal(slackReq)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	resp, err := slackClient.Post(webhookURL, "application/json", bytes.NewBuffer(slackReqJSON))
+	if err != nil {
+		return transient(fmt.Errorf("error sending request: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp.StatusCode, fmt.Errorf("slack webhook returned unexpected status: %d", resp.StatusCode))
+	}
+
+	return nil
+}
+
+// sendSlack はリクエストの送信先のSlackのチャンネルすべてに通知を送信し、失敗したチャンネルのエラーを返します
+func sendSlack(req models.NotificationRequest) error {
+	webhooks, err := slackWebhooks()
+	if err != nil {
+		return err
+	}
+	routes, err := slackSeverityRoutes()
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, channel := range slackChannelsFor(req, routes) {
+		webhookURL, ok := webhooks[channel]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("%s: webhook not configured", channel))
+			continue
+		}
+		if err := deliver(ChannelSlack, channel, req, func() error { return SendSlackNotification(webhookURL, req) }); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", channel, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// truncate はSlackのブロックの文字数の上限に収まるよう文字列を切り詰めます
+func truncate(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit-1]) + "…"
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification/logger"
+	"notification/models"
+	"notification/secrets"
+
+	"go.uber.org/zap"
+)
+
+// ChannelSMS はTwilio経由のSMSの送信先
+const ChannelSMS = "sms"
+
+// defaultSMSTemplate はSMSの本文の既定のテンプレート
+const defaultSMSTemplate = "[{severity}] {title} #{incident_id} {url}"
+
+// maxSMSLength はSMSの本文の上限（日本語を含む場合の2通分）
+const maxSMSLength = 140
+
+var e164Number = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+var twilioClient = &http.Client{Timeout: 10 * time.Second}
+
+// smsConfigured はTwilioのアカウントと送信元の番号が設定されているかを返します
+func smsConfigured() bool {
+	return os.Getenv("TWILIO_ACCOUNT_SID") != "" && secrets.Get("TWILIO_AUTH_TOKEN") != "" && os.Getenv("TWILIO_FROM_NUMBER") != ""
+}
+
+// smsRecipientsFor はSMSの宛先（E.164形式の電話番号）を返します。
+// リクエストで指定された宛先を優先し、指定がない場合は SMS_RECIPIENTS（重要度ごとの宛先のJSONオブジェクト）の重要度の宛先を返します。
+// 例: {"critical":["+819012345678"],"high":["+819012345678"]}（夜間のP1だけSMSにする場合は critical のみ）
+func smsRecipientsFor(req models.NotificationRequest) ([]string, error) {
+	if len(req.SMSRecipients) > 0 {
+		return req.SMSRecipients, nil
+	}
+	value := os.Getenv("SMS_RECIPIENTS")
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var recipients map[models.Severity][]string
+	if err := json.Unmarshal([]byte(value), &recipients); err != nil {
+		return nil, fmt.Errorf("invalid SMS_RECIPIENTS: %v", err)
+	}
+	return recipients[models.ParseSeverity(req.Priority)], nil
+}
+
+// formatSMS はテンプレート（SMS_TEMPLATE）から短いSMSの本文を作成します。
+// {severity}・{priority}・{title}・{incident_id}・{url}（INCIDENT_URL_TEMPLATE のURL）を置き換えます
+func formatSMS(req models.NotificationRequest) string {
+	template := os.Getenv("SMS_TEMPLATE")
+	if template == "" {
+		template = defaultSMSTemplate
+	}
+
+	incidentID, incidentURL := "", ""
+	if req.IncidentID != 0 {
+		incidentID = strconv.FormatUint(uint64(req.IncidentID), 10)
+		if urlTemplate := os.Getenv("INCIDENT_URL_TEMPLATE"); urlTemplate != "" {
+			incidentURL = strings.ReplaceAll(urlTemplate, "{id}", incidentID)
+		}
+	}
+	body := strings.NewReplacer(
+		"{severity}", strings.ToUpper(string(models.ParseSeverity(req.Priority))),
+		"{priority}", req.Priority,
+		"{title}", req.Title,
+		"{incident_id}", incidentID,
+		"{url}", incidentURL,
+	).Replace(template)
+	// 空の項目の空白と、インシデントIDがない通知の "#" だけの部分を詰める
+	var words []string
+	for _, word := range strings.Fields(body) {
+		if word != "#" {
+			words = append(words, word)
+		}
+	}
+	body = strings.Join(words, " ")
+
+	// URLは途中で切ると開けないため、タイトルの側を切り詰める
+	if runes := []rune(body); len(runes) > maxSMSLength {
+		if incidentURL != "" && strings.HasSuffix(body, incidentURL) {
+			head := []rune(strings.TrimSuffix(body, incidentURL))
+			if keep := maxSMSLength - len([]rune(incidentURL)) - 1; keep > 0 && keep < len(head) {
+				return string(head[:keep]) + "…" + incidentURL
+			}
+		}
+		return string(runes[:maxSMSLength-1]) + "…"
+	}
+	return body
+}
+
+// SendSMS はTwilioのMessages APIで1件のSMSを送信します
+func SendSMS(to, body string) error {
+	if !e164Number.MatchString(to) {
+		return fmt.Errorf("invalid phone number (E.164 required)")
+	}
+
+	accountSID := os.Getenv("TWILIO_ACCOUNT_SID")
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", os.Getenv("TWILIO_FROM_NUMBER"))
+	form.Set("Body", body)
+
+	endpoint := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", url.PathEscape(accountSID))
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(accountSID, secrets.Get("TWILIO_AUTH_TOKEN"))
+
+	resp, err := twilioClient.Do(req)
+	if err != nil {
+		return transient(fmt.Errorf("error sending request: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		var twilioErr struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&twilioErr)
+		return statusError(resp.StatusCode, fmt.Errorf("twilio returned unexpected status: %d (%d %s)", resp.StatusCode, twilioErr.Code, twilioErr.Message))
+	}
+	return nil
+}
+
+// sendSMS は通知の宛先すべてにSMSを送信し、失敗した宛先のエラーを返します
+func sendSMS(req models.NotificationRequest) error {
+	recipients, err := smsRecipientsFor(req)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("no SMS recipients for priority %q", req.Priority)
+	}
+
+	recipients, err = applyQuietHours(ChannelSMS, req, recipients, func(deferred []string) {
+		if err := sendSMSTo(req, deferred); err != nil {
+			logger.Logger.Error("延期したSMSの送信に失敗しました", zap.Uint("incident_id", req.IncidentID), zap.Error(err))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sendSMSTo(req, recipients)
+}
+
+// sendSMSTo は宛先それぞれにSMSを送信し、失敗した宛先のエラーを返します
+func sendSMSTo(req models.NotificationRequest, recipients []string) error {
+	body := formatSMS(req)
+	var failures []string
+	for _, to := range recipients {
+		to = strings.TrimSpace(to)
+		if err := deliver(ChannelSMS, to, req, func() error { return SendSMS(to, body) }); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", maskPhoneNumber(to), err))
+			continue
+		}
+		logger.Logger.Info("SMSを送信しました",
+			zap.Uint("incident_id", req.IncidentID),
+			zap.String("to", maskPhoneNumber(to)),
+		)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// maskPhoneNumber はログ・エラーに出力する電話番号を末尾4桁以外伏せます
+func maskPhoneNumber(number string) string {
+	number = strings.TrimSpace(number)
+	if len(number) <= 4 {
+		return number
+	}
+	return strings.Repeat("*", len(number)-4) + number[len(number)-4:]
+}
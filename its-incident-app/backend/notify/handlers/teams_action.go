@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"notification/logger"
+	"notification/secrets"
+	"notification/serviceauth"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// teamsActionAcknowledge はインシデントを確認済み（調査中）にする操作
+const teamsActionAcknowledge = "acknowledge"
+
+// acknowledgedStatus は確認の操作で設定するインシデントの状態
+const acknowledgedStatus = "調査中"
+
+// defaultTeamsActionTTL はカードの操作ボタンの有効期間の既定値
+const defaultTeamsActionTTL = 72 * time.Hour
+
+// TeamsActionRequest はカードの操作のコールバックです。
+// GET（Action.OpenUrl）ではクエリパラメーター、POST では確認画面のフォームまたはJSON（Power Automateのフローなど）で受け取ります
+type TeamsActionRequest struct {
+	Action     string `json:"action" form:"action"`
+	IncidentID uint   `json:"incident_id" form:"incident_id"`
+	Expires    int64  `json:"expires" form:"expires"`
+	Signature  string `json:"sig" form:"sig"`
+	User       string `json:"user" form:"user"` // 操作した利用者（分かる場合のみ）
+}
+
+// teamsActionsEnabled は操作ボタン・音声通話の応答のURLの署名鍵（TEAMS_ACTION_SECRET）が設定されているかを返します
+func teamsActionsEnabled() bool {
+	return secrets.Get("TEAMS_ACTION_SECRET") != ""
+}
+
+// teamsActionTTL は TEAMS_ACTION_TTL（カードの操作ボタンの有効期間）を返します
+func teamsActionTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("TEAMS_ACTION_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultTeamsActionTTL
+}
+
+// signTeamsAction は操作・インシデント・有効期限に対する署名（HMAC-SHA256、base64url）を返します
+func signTeamsAction(action string, incidentID uint, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secrets.Get("TEAMS_ACTION_SECRET")))
+	fmt.Fprintf(mac, "%s:%d:%d", action, incidentID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyTeamsAction は操作の署名と有効期限を検証します
+func verifyTeamsAction(req TeamsActionRequest) error {
+	if !teamsActionsEnabled() {
+		return fmt.Errorf("teams actions are not configured")
+	}
+	if req.Action != teamsActionAcknowledge {
+		return fmt.Errorf("unknown action: %s", req.Action)
+	}
+	if req.IncidentID == 0 || req.Signature == "" {
+		return fmt.Errorf("incident_id and sig are required")
+	}
+	expected := signTeamsAction(req.Action, req.IncidentID, req.Expires)
+	if !hmac.Equal([]byte(expected), []byte(req.Signature)) {
+		return fmt.Errorf("invalid signature")
+	}
+	if time.Now().Unix() > req.Expires {
+		return fmt.Errorf("action has expired")
+	}
+	return nil
+}
+
+// TeamsActionHandler はTeamsのカードの操作ボタンのコールバックを受け取り、dbpilotに転送します。
+// 利用者のブラウザーから直接呼び出されるため、サービス間認証の代わりにURLの署名で検証します。
+// リンクのプレビューなどのGETでインシデントを更新しないよう、GETでは確認画面を返してPOSTで更新します
+func TeamsActionHandler(c *gin.Context) {
+	var req TeamsActionRequest
+	var err error
+	if c.Request.Method == http.MethodPost {
+		err = c.ShouldBind(&req)
+	} else {
+		err = c.ShouldBindQuery(&req)
+	}
+	if err != nil {
+		respondTeamsAction(c, http.StatusBadRequest, "リクエストが不正です")
+		return
+	}
+
+	if err := verifyTeamsAction(req); err != nil {
+		logger.Logger.Warn("Teamsの操作の検証に失敗しました",
+			zap.Error(err),
+			zap.String("action", req.Action),
+			zap.Uint("incident_id", req.IncidentID),
+			zap.String("client_ip", c.ClientIP()),
+		)
+		respondTeamsAction(c, http.StatusForbidden, "リンクが無効か、有効期限が切れています")
+		return
+	}
+	if c.Request.Method != http.MethodPost {
+		renderTeamsActionConfirm(c, req)
+		return
+	}
+
+	responder := strings.TrimSpace(req.User)
+	if responder == "" {
+		responder = "Teams"
+	}
+	if err := forwardAcknowledge(req.IncidentID, responder, "Teamsの通知から確認しました"); err != nil {
+		logger.Logger.Error("Teamsの操作のdbpilotへの転送に失敗しました",
+			zap.Error(err),
+			zap.String("action", req.Action),
+			zap.Uint("incident_id", req.IncidentID),
+		)
+		respondTeamsAction(c, http.StatusBadGateway, "インシデントを更新できませんでした。時間をおいて再度お試しください")
+		return
+	}
+
+	logger.Logger.Info("Teamsの操作でインシデントを更新しました",
+		zap.String("action", req.Action),
+		zap.Uint("incident_id", req.IncidentID),
+		zap.String("responder", responder),
+	)
+	respondTeamsAction(c, http.StatusOK, fmt.Sprintf("インシデント %d を確認済み（%s）にしました", req.IncidentID, acknowledgedStatus))
+}
+
+// forwardAcknowledge は確認の操作をインシデントの対応履歴としてdbpilotに保存し、状態を更新します
+func forwardAcknowledge(incidentID uint, responder, content string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"incident_id": incidentID,
+		"datetime":    time.Now(),
+		"responder":   responder,
+		"content":     content,
+		"status":      acknowledgedStatus,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal DB pilot request: %v", err)
+	}
+
+	dbRequest, err := http.NewRequest(http.MethodPost, os.Getenv("DB_PILOT_SERVICE_URL")+"/responses", bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create DB pilot request: %v", err)
+	}
+	dbRequest.Header.Set("Content-Type", "application/json")
+	dbRequest.Header.Set("Authorization", "Bearer "+serviceauth.PrimaryServiceToken())
+
+	dbResp, err := (&http.Client{Timeout: 10 * time.Second}).Do(dbRequest)
+	if err != nil {
+		return fmt.Errorf("failed to send DB pilot request: %v", err)
+	}
+	defer dbResp.Body.Close()
+
+	if dbResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("DB pilot returned status %d", dbResp.StatusCode)
+	}
+	return nil
+}
+
+// renderTeamsActionConfirm は操作を実行するボタン（署名を引き継いでPOSTするフォーム）の画面を返します
+func renderTeamsActionConfirm(c *gin.Context, req TeamsActionRequest) {
+	hidden := func(name, value string) string {
+		return `<input type="hidden" name="` + name + `" value="` + html.EscapeString(value) + `">`
+	}
+	page := "<!DOCTYPE html><html lang=\"ja\"><head><meta charset=\"utf-8\"><title>インシデント通知</title></head><body>" +
+		fmt.Sprintf("<p>インシデント %d を確認済み（%s）にしますか？</p>", req.IncidentID, acknowledgedStatus) +
+		`<form method="post">` +
+		hidden("action", req.Action) +
+		hidden("incident_id", fmt.Sprint(req.IncidentID)) +
+		hidden("expires", fmt.Sprint(req.Expires)) +
+		hidden("sig", req.Signature) +
+		`<label>担当者 <input type="text" name="user" value="` + html.EscapeString(req.User) + `"></label> ` +
+		`<button type="submit">確認する</button></form></body></html>`
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+}
+
+// respondTeamsAction はブラウザー（確認画面のフォーム）の場合はHTML、JSONで呼び出された場合はJSONで結果を返します
+func respondTeamsAction(c *gin.Context, status int, message string) {
+	if c.ContentType() == "application/json" {
+		if status == http.StatusOK {
+			c.JSON(status, gin.H{"status": "success", "message": message})
+		} else {
+			RespondWithError(c, status, message)
+		}
+		return
+	}
+	page := "<!DOCTYPE html><html lang=\"ja\"><head><meta charset=\"utf-8\"><title>インシデント通知</title></head>" +
+		"<body><p>" + html.EscapeString(message) + "</p></body></html>"
+	c.Data(status, "text/html; charset=utf-8", []byte(page))
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification/models"
+)
+
+// adaptiveCardContentType はTeamsに送信するAdaptive Cardの添付ファイルの種類
+const adaptiveCardContentType = "application/vnd.microsoft.card.adaptive"
+
+// severityCardColor は重要度ごとのタイトルの色（Adaptive Cardの color）
+var severityCardColor = map[models.Severity]string{
+	models.SeverityCritical: "attention",
+	models.SeverityHigh:     "warning",
+	models.SeverityMedium:   "accent",
+	models.SeverityLow:      "default",
+}
+
+// buildAdaptiveCard はインシデントの項目と操作ボタン（確認・インシデントを開く）を含むAdaptive Cardを作成します
+func buildAdaptiveCard(notification models.NotificationRequest) map[string]interface{} {
+	priority := models.PriorityMetadata(notification.Priority)
+
+	facts := []map[string]string{
+		{"title": "重要度", "value": string(priority.Severity)},
+	}
+	if notification.IncidentID != 0 {
+		facts = append(facts, map[string]string{"title": "インシデントID", "value": strconv.FormatUint(uint64(notification.IncidentID), 10)})
+	}
+	if notification.Responder != "" {
+		facts = append(facts, map[string]string{"title": "担当者", "value": notification.Responder})
+	}
+	if notification.Name != "" {
+		facts = append(facts, map[string]string{"title": "送信元", "value": notification.Name})
+	}
+
+	card := map[string]interface{}{
+		"type":    "AdaptiveCard",
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"version": "1.4",
+		"body": []map[string]interface{}{
+			{
+				"type":   "TextBlock",
+				"text":   notification.Title,
+				"size":   "Large",
+				"weight": "Bolder",
+				"color":  severityCardColor[priority.Severity],
+				"wrap":   true,
+			},
+			{
+				"type": "TextBlock",
+				"text": notification.Content,
+				"wrap": true,
+			},
+			{
+				"type":  "FactSet",
+				"facts": facts,
+			},
+		},
+	}
+	if actions := cardActions(notification); len(actions) > 0 {
+		card["actions"] = actions
+	}
+	return card
+}
+
+// cardActions はカードの操作ボタンを返します。
+// 「インシデントを開く」は INCIDENT_URL_TEMPLATE、「確認」は NOTIFY_PUBLIC_URL と TEAMS_ACTION_SECRET が設定されている場合のみ追加します
+func cardActions(notification models.NotificationRequest) []map[string]interface{} {
+	if notification.IncidentID == 0 {
+		return nil
+	}
+
+	var actions []map[string]interface{}
+	if ackURL := teamsActionURL(teamsActionAcknowledge, notification.IncidentID, time.Now().Add(teamsActionTTL())); ackURL != "" {
+		actions = append(actions, map[string]interface{}{
+			"type":  "Action.OpenUrl",
+			"title": "確認（Acknowledge）",
+			"url":   ackURL,
+			"style": "positive",
+		})
+	}
+	// 例: https://incident.example.com/work?incident={id}
+	if template := os.Getenv("INCIDENT_URL_TEMPLATE"); template != "" {
+		actions = append(actions, map[string]interface{}{
+			"type":  "Action.OpenUrl",
+			"title": "インシデントを開く",
+			"url":   strings.ReplaceAll(template, "{id}", strconv.FormatUint(uint64(notification.IncidentID), 10)),
+		})
+	}
+	return actions
+}
+
+// teamsActionURL はカードの操作ボタンから呼び出す notify のURL（署名付き）を返します。設定がない場合は空文字を返します
+func teamsActionURL(action string, incidentID uint, expires time.Time) string {
+	baseURL := os.Getenv("NOTIFY_PUBLIC_URL")
+	if baseURL == "" || !teamsActionsEnabled() {
+		return ""
+	}
+
+	query := url.Values{}
+	query.Set("action", action)
+	query.Set("incident_id", strconv.FormatUint(uint64(incidentID), 10))
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", signTeamsAction(action, incidentID, expires.Unix()))
+	return fmt.Sprintf("%s/teams/actions?%s", strings.TrimSuffix(baseURL, "/"), query.Encode())
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"notification/logger"
+	"notification/models"
+	"notification/secrets"
+
+	"go.uber.org/zap"
+)
+
+// defaultTeamsChannel はどのルールにも一致しない通知の送信先（TEAMS_WEBHOOK_URL）の名前
+const defaultTeamsChannel = "default"
+
+// TeamsRoutingRule はTeamsの送信先のチャンネルを決めるルールです。
+// 指定した条件をすべて満たす通知を Webhooks（TEAMS_WEBHOOKS の名前）に送信します（条件を指定しないルールはすべての通知に一致）
+type TeamsRoutingRule struct {
+	Name           string   `json:"name"`
+	Enabled        *bool    `json:"enabled"`         // false の場合は評価しない（省略時は有効）
+	Priorities     []string `json:"priorities"`      // 優先度（高/中/低、critical/high など）のいずれか
+	AssigneeGroups []string `json:"assignee_groups"` // 担当グループのいずれか
+	Tags           []string `json:"tags"`            // インシデントのタグのいずれか
+	Webhooks       []string `json:"webhooks"`
+}
+
+// teamsWebhooks は TEAMS_WEBHOOKS（チャンネルの名前とWebhookのURLのJSONオブジェクト）に、
+// 既定の送信先として TEAMS_WEBHOOK_URL を default の名前で加えて返します
+func teamsWebhooks() (map[string]string, error) {
+	webhooks := map[string]string{}
+	if value := secrets.Get("TEAMS_WEBHOOKS"); strings.TrimSpace(value) != "" {
+		if err := json.Unmarshal([]byte(value), &webhooks); err != nil {
+			return nil, fmt.Errorf("invalid TEAMS_WEBHOOKS: %v", err)
+		}
+	}
+	if url := os.Getenv("TEAMS_WEBHOOK_URL"); url != "" {
+		if _, ok := webhooks[defaultTeamsChannel]; !ok {
+			webhooks[defaultTeamsChannel] = url
+		}
+	}
+	return webhooks, nil
+}
+
+// teamsConfigured はTeamsの送信先が1つ以上設定されているかを返します
+func teamsConfigured() bool {
+	return os.Getenv("TEAMS_WEBHOOK_URL") != "" || strings.TrimSpace(secrets.Get("TEAMS_WEBHOOKS")) != ""
+}
+
+// teamsRoutingRules は TEAMS_ROUTING_RULES（上から順に評価するルールのJSONの配列）を返します。
+// 例: [{"name":"critical","priorities":["緊急"],"webhooks":["oncall","default"]},{"name":"network","tags":["network"],"webhooks":["network"]}]
+func teamsRoutingRules() ([]TeamsRoutingRule, error) {
+	value := os.Getenv("TEAMS_ROUTING_RULES")
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var rules []TeamsRoutingRule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("invalid TEAMS_ROUTING_RULES: %v", err)
+	}
+	return rules, nil
+}
+
+// teamsChannelsFor は通知に最初に一致した有効なルールの名前と送信先を返します。一致しない場合は default に送信します。
+// リクエストで送信先が指定されている場合はルールを評価せず、その送信先を返します
+func teamsChannelsFor(req models.NotificationRequest, rules []TeamsRoutingRule) (string, []string) {
+	if len(req.TeamsChannels) > 0 {
+		return "request", req.TeamsChannels
+	}
+	for i, rule := range rules {
+		if rule.Enabled != nil && !*rule.Enabled {
+			continue
+		}
+		if !rule.matches(req) || len(rule.Webhooks) == 0 {
+			continue
+		}
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule%d", i)
+		}
+		return name, rule.Webhooks
+	}
+	return defaultTeamsChannel, []string{defaultTeamsChannel}
+}
+
+func (r *TeamsRoutingRule) matches(req models.NotificationRequest) bool {
+	if len(r.Priorities) > 0 {
+		severity := models.ParseSeverity(req.Priority)
+		matched := false
+		for _, priority := range r.Priorities {
+			if models.ParseSeverity(priority) == severity {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.AssigneeGroups) > 0 && !containsFold(r.AssigneeGroups, req.AssigneeGroup) {
+		return false
+	}
+	if len(r.Tags) > 0 {
+		matched := false
+		for _, tag := range req.Tags {
+			if containsFold(r.Tags, tag) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// containsFold は大文字・小文字を区別せずに values に value が含まれるかを返します
+func containsFold(values []string, value string) bool {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return false
+	}
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendTeams はルールで決めたTeamsのチャンネルすべてに通知を送信し、失敗したチャンネルのエラーを返します
+func sendTeams(req models.NotificationRequest) error {
+	webhooks, err := teamsWebhooks()
+	if err != nil {
+		return err
+	}
+	rules, err := teamsRoutingRules()
+	if err != nil {
+		return err
+	}
+
+	rule, channels := teamsChannelsFor(req, rules)
+	logger.Logger.Info("Teamsの送信先を決定しました",
+		zap.Uint("incident_id", req.IncidentID),
+		zap.String("rule", rule),
+		zap.Strings("channels", channels),
+	)
+
+	var failures []string
+	for _, channel := range channels {
+		webhookURL, ok := webhooks[channel]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("%s: webhook not configured", channel))
+			continue
+		}
+		if err := deliver(ChannelTeams, channel, req, func() error { return SendTeamsNotification(webhookURL, req) }); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", channel, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
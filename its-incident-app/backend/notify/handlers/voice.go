@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"notification/logger"
+	"notification/models"
+	"notification/secrets"
+	"notification/serviceauth"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ChannelVoice はTwilio Voiceによる音声通話の送信先
+const ChannelVoice = "voice"
+
+// voiceAcknowledgeDigit は通話中に押すと確認済みにする番号
+const voiceAcknowledgeDigit = "1"
+
+// defaultVoiceEscalationDelay はチャット・SMSで確認されない場合に電話をかけるまでの既定の時間
+const defaultVoiceEscalationDelay = 15 * time.Minute
+
+// unacknowledgedStatus は誰も確認していないインシデントの状態
+const unacknowledgedStatus = "未着手"
+
+// pendingVoiceEscalations は電話をかける予定のインシデント（同じインシデントの通知で重ねて予約しない）
+var (
+	pendingVoiceMu          sync.Mutex
+	pendingVoiceEscalations = map[uint]*time.Timer{}
+)
+
+// voiceConfigured はTwilioのアカウント・発信元の番号と、応答を受け取るURL（NOTIFY_PUBLIC_URL と署名鍵）が設定されているかを返します
+func voiceConfigured() bool {
+	return os.Getenv("TWILIO_ACCOUNT_SID") != "" && secrets.Get("TWILIO_AUTH_TOKEN") != "" &&
+		os.Getenv("TWILIO_FROM_NUMBER") != "" && os.Getenv("NOTIFY_PUBLIC_URL") != "" && teamsActionsEnabled()
+}
+
+// voiceRecipientsFor は電話をかける宛先（E.164形式）を返します。
+// リクエストで指定された宛先を優先し、指定がない場合は VOICE_RECIPIENTS（重要度ごとの宛先のJSONオブジェクト）の重要度の宛先を返します。
+// 例: {"critical":["+819012345678","+819087654321"]}（すべての宛先に同時にかけ、誰かが番号を押すと確認済みになる）
+func voiceRecipientsFor(req models.NotificationRequest) ([]string, error) {
+	if len(req.VoiceRecipients) > 0 {
+		return req.VoiceRecipients, nil
+	}
+	value := os.Getenv("VOICE_RECIPIENTS")
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var recipients map[models.Severity][]string
+	if err := json.Unmarshal([]byte(value), &recipients); err != nil {
+		return nil, fmt.Errorf("invalid VOICE_RECIPIENTS: %v", err)
+	}
+	return recipients[models.ParseSeverity(req.Priority)], nil
+}
+
+// voiceEscalationDelay は VOICE_ESCALATION_DELAY（チャット・SMSの通知から電話をかけるまでの時間）を返します
+func voiceEscalationDelay() time.Duration {
+	if delay, err := time.ParseDuration(os.Getenv("VOICE_ESCALATION_DELAY")); err == nil && delay > 0 {
+		return delay
+	}
+	return defaultVoiceEscalationDelay
+}
+
+// scheduleVoiceEscalation はチャット・SMSの通知の後、一定時間たってもインシデントが確認されていない場合に電話をかけます。
+// 予約はプロセスのメモリにだけ保持するため、再起動した場合は電話をかけません
+func scheduleVoiceEscalation(req models.NotificationRequest) {
+	if req.IncidentID == 0 || !voiceConfigured() {
+		return
+	}
+	if recipients, err := voiceRecipientsFor(req); err != nil || len(recipients) == 0 {
+		return
+	}
+
+	pendingVoiceMu.Lock()
+	defer pendingVoiceMu.Unlock()
+	if _, ok := pendingVoiceEscalations[req.IncidentID]; ok {
+		return
+	}
+	delay := voiceEscalationDelay()
+	pendingVoiceEscalations[req.IncidentID] = time.AfterFunc(delay, func() {
+		pendingVoiceMu.Lock()
+		delete(pendingVoiceEscalations, req.IncidentID)
+		pendingVoiceMu.Unlock()
+		escalateToVoice(req)
+	})
+	logger.Logger.Info("未確認の場合の電話の呼び出しを予約しました",
+		zap.Uint("incident_id", req.IncidentID),
+		zap.Duration("delay", delay),
+	)
+}
+
+// escalateToVoice はインシデントがまだ確認されていない場合だけ電話をかけます
+func escalateToVoice(req models.NotificationRequest) {
+	acknowledged, err := incidentAcknowledged(req.IncidentID)
+	if err != nil {
+		// 確認できない場合は、呼び出さずに見逃すより電話をかける
+		logger.Logger.Warn("インシデントの状態の取得に失敗しました", zap.Uint("incident_id", req.IncidentID), zap.Error(err))
+	} else if acknowledged {
+		logger.Logger.Info("インシデントが確認済みのため電話をかけません", zap.Uint("incident_id", req.IncidentID))
+		return
+	}
+
+	if err := sendVoice(req); err != nil {
+		logger.Logger.Error("電話の呼び出しに失敗しました", zap.Uint("incident_id", req.IncidentID), zap.Error(err))
+	}
+}
+
+// incidentAcknowledged はdbpilotのインシデントの状態が未着手以外（誰かが確認した）かを返します
+func incidentAcknowledged(incidentID uint) (bool, error) {
+	endpoint := fmt.Sprintf("%s/incidents/%d", os.Getenv("DB_PILOT_SERVICE_URL"), incidentID)
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create DB pilot request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+serviceauth.PrimaryServiceToken())
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to send DB pilot request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("DB pilot returned status %d", resp.StatusCode)
+	}
+
+	var incident struct {
+		Status string `json:"Status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&incident); err != nil {
+		return false, fmt.Errorf("failed to decode incident: %v", err)
+	}
+	return incident.Status != "" && incident.Status != unacknowledgedStatus, nil
+}
+
+// sendVoice は宛先すべてに電話をかけます（応答した相手が番号を押すと確認済みになります）
+func sendVoice(req models.NotificationRequest) error {
+	recipients, err := voiceRecipientsFor(req)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("no voice recipients for priority %q", req.Priority)
+	}
+
+	recipients, err = applyQuietHours(ChannelVoice, req, recipients, func(deferred []string) {
+		// 延期している間に確認された場合はかけない
+		if acknowledged, err := incidentAcknowledged(req.IncidentID); err == nil && acknowledged {
+			return
+		}
+		if err := callRecipients(req, deferred); err != nil {
+			logger.Logger.Error("延期した電話の呼び出しに失敗しました", zap.Uint("incident_id", req.IncidentID), zap.Error(err))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return callRecipients(req, recipients)
+}
+
+// callRecipients は宛先それぞれに電話をかけ、失敗した宛先のエラーを返します
+func callRecipients(req models.NotificationRequest, recipients []string) error {
+	var failures []string
+	for _, to := range recipients {
+		to = strings.TrimSpace(to)
+		if err := deliver(ChannelVoice, to, req, func() error { return placeCall(to, buildCallTwiML(req, to)) }); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", maskPhoneNumber(to), err))
+			continue
+		}
+		logger.Logger.Info("電話をかけました",
+			zap.Uint("incident_id", req.IncidentID),
+			zap.String("to", maskPhoneNumber(to)),
+		)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// buildCallTwiML はインシデントの概要を読み上げ、番号の入力（確認）を受け付けるTwiMLを作成します
+func buildCallTwiML(req models.NotificationRequest, to string) string {
+	summary := fmt.Sprintf("インシデント通知です。重要度、%s。%s。", req.Priority, req.Title)
+	if req.IncidentID != 0 {
+		summary += fmt.Sprintf("インシデント番号、%d。", req.IncidentID)
+	}
+
+	gatherURL := voiceGatherURL(req.IncidentID, to, time.Now().Add(teamsActionTTL()))
+	return "<Response>" +
+		`<Gather numDigits="1" timeout="10" method="POST" action="` + xmlEscape(gatherURL) + `">` +
+		`<Say language="ja-JP">` + xmlEscape(summary) + `</Say>` +
+		`<Say language="ja-JP">確認した場合は ` + voiceAcknowledgeDigit + ` を押してください。</Say>` +
+		`<Say language="ja-JP">` + xmlEscape(summary) + `</Say>` +
+		`<Say language="ja-JP">確認した場合は ` + voiceAcknowledgeDigit + ` を押してください。</Say>` +
+		"</Gather>" +
+		`<Say language="ja-JP">入力がなかったため、通話を終了します。</Say>` +
+		"</Response>"
+}
+
+// voiceGatherURL は番号の入力を受け取る notify のURL（署名付き）を返します
+func voiceGatherURL(incidentID uint, to string, expires time.Time) string {
+	query := url.Values{}
+	query.Set("action", teamsActionAcknowledge)
+	query.Set("incident_id", strconv.FormatUint(uint64(incidentID), 10))
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", signTeamsAction(teamsActionAcknowledge, incidentID, expires.Unix()))
+	query.Set("user", "電話（"+maskPhoneNumber(to)+"）")
+	return fmt.Sprintf("%s/voice/gather?%s", strings.TrimSuffix(os.Getenv("NOTIFY_PUBLIC_URL"), "/"), query.Encode())
+}
+
+// placeCall はTwilioのCalls APIで電話をかけます
+func placeCall(to, twiml string) error {
+	if !e164Number.MatchString(to) {
+		return fmt.Errorf("invalid phone number (E.164 required)")
+	}
+
+	accountSID := os.Getenv("TWILIO_ACCOUNT_SID")
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", os.Getenv("TWILIO_FROM_NUMBER"))
+	form.Set("Twiml", twiml)
+
+	endpoint := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Calls.json", url.PathEscape(accountSID))
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(accountSID, secrets.Get("TWILIO_AUTH_TOKEN"))
+
+	resp, err := twilioClient.Do(req)
+	if err != nil {
+		return transient(fmt.Errorf("error sending request: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		var twilioErr struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&twilioErr)
+		return statusError(resp.StatusCode, fmt.Errorf("twilio returned unexpected status: %d (%d %s)", resp.StatusCode, twilioErr.Code, twilioErr.Message))
+	}
+	return nil
+}
+
+// VoiceGatherHandler は通話中に押された番号（TwilioのGather）を受け取り、確認の番号の場合はdbpilotに転送します。
+// Twilioから呼び出されるため、サービス間認証の代わりにURLの署名で検証します
+func VoiceGatherHandler(c *gin.Context) {
+	var req TeamsActionRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		respondTwiML(c, "エラーが発生しました。")
+		return
+	}
+	if err := verifyTeamsAction(req); err != nil {
+		logger.Logger.Warn("音声通話の応答の検証に失敗しました",
+			zap.Error(err),
+			zap.Uint("incident_id", req.IncidentID),
+			zap.String("client_ip", c.ClientIP()),
+		)
+		respondTwiML(c, "この通知は無効か、有効期限が切れています。")
+		return
+	}
+
+	if c.PostForm("Digits") != voiceAcknowledgeDigit {
+		respondTwiML(c, "確認されませんでした。通話を終了します。")
+		return
+	}
+	if err := forwardAcknowledge(req.IncidentID, req.User, "電話の呼び出しで確認しました"); err != nil {
+		logger.Logger.Error("音声通話の確認のdbpilotへの転送に失敗しました",
+			zap.Error(err),
+			zap.Uint("incident_id", req.IncidentID),
+		)
+		respondTwiML(c, "インシデントを更新できませんでした。システムから確認してください。")
+		return
+	}
+
+	logger.Logger.Info("音声通話でインシデントが確認されました",
+		zap.Uint("incident_id", req.IncidentID),
+		zap.String("responder", req.User),
+	)
+	respondTwiML(c, "確認しました。対応をお願いします。")
+}
+
+// respondTwiML はメッセージを読み上げて通話を終了するTwiMLを返します
+func respondTwiML(c *gin.Context, message string) {
+	twiml := `<?xml version="1.0" encoding="UTF-8"?><Response><Say language="ja-JP">` + xmlEscape(message) + `</Say></Response>`
+	c.Data(http.StatusOK, "text/xml; charset=utf-8", []byte(twiml))
+}
+
+// xmlEscape はTwiMLに埋め込む文字列をエスケープします
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
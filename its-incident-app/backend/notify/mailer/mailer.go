@@ -0,0 +1,110 @@
+// Package mailer はメールの送信をプロバイダー（SendGrid・AWS SES・SMTP）から切り離します。
+// MAIL_PROVIDERS に優先する順に指定したプロバイダーで送信し、失敗した場合は次のプロバイダーで送信します
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"notification/logger"
+
+	"go.uber.org/zap"
+)
+
+// Message は送信するメールです
+type Message struct {
+	From    string // 省略時は MAIL_FROM
+	To      []string
+	Subject string
+	HTML    string
+}
+
+// Mailer はメールを送信するプロバイダーです
+type Mailer interface {
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// FallbackMailer は先頭のプロバイダーから順に送信し、失敗した場合は次のプロバイダーで送信します
+type FallbackMailer struct {
+	mailers []Mailer
+}
+
+// NewFallbackMailer は優先する順のプロバイダーから FallbackMailer を作成します
+func NewFallbackMailer(mailers ...Mailer) *FallbackMailer {
+	return &FallbackMailer{mailers: mailers}
+}
+
+func (m *FallbackMailer) Name() string {
+	names := make([]string, 0, len(m.mailers))
+	for _, mailer := range m.mailers {
+		names = append(names, mailer.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// Send はいずれかのプロバイダーで送信できた場合は nil、すべてのプロバイダーで失敗した場合はそれぞれのエラーを返します
+func (m *FallbackMailer) Send(ctx context.Context, msg Message) error {
+	if msg.From == "" {
+		msg.From = os.Getenv("MAIL_FROM")
+	}
+	if msg.From == "" || len(msg.To) == 0 {
+		return fmt.Errorf("from and to are required")
+	}
+
+	var errs []error
+	for i, mailer := range m.mailers {
+		err := mailer.Send(ctx, msg)
+		if err == nil {
+			if i > 0 {
+				logger.Logger.Warn("代替のプロバイダーでメールを送信しました",
+					zap.String("provider", mailer.Name()),
+					zap.Errors("previous_errors", errs),
+				)
+			}
+			return nil
+		}
+		logger.Logger.Error("メールの送信に失敗しました",
+			zap.String("provider", mailer.Name()),
+			zap.Error(err),
+		)
+		errs = append(errs, fmt.Errorf("%s: %w", mailer.Name(), err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FromEnv は MAIL_PROVIDERS（カンマ区切り、例: sendgrid,ses,smtp）のプロバイダーを設定から作成します。
+// 未指定の場合は sendgrid のみを使用し、指定したプロバイダーの設定が不足している場合はエラーを返します
+func FromEnv() (*FallbackMailer, error) {
+	providers := os.Getenv("MAIL_PROVIDERS")
+	if strings.TrimSpace(providers) == "" {
+		providers = "sendgrid"
+	}
+
+	var mailers []Mailer
+	for _, name := range strings.Split(providers, ",") {
+		var mailer Mailer
+		var err error
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "sendgrid":
+			mailer, err = newSendGridFromEnv()
+		case "ses":
+			mailer, err = newSESFromEnv()
+		case "smtp":
+			mailer, err = newSMTPFromEnv()
+		default:
+			err = fmt.Errorf("unknown provider")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAIL_PROVIDERS (%s): %v", name, err)
+		}
+		mailers = append(mailers, mailer)
+	}
+	return NewFallbackMailer(mailers...), nil
+}
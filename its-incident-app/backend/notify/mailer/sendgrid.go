@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"notification/secrets"
+)
+
+const sendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+
+// SendGrid はSendGridのMail Send APIで送信します
+type SendGrid struct {
+	apiKey func() string
+	client *http.Client
+}
+
+func newSendGridFromEnv() (*SendGrid, error) {
+	if secrets.Get("SENDGRID_API_KEY") == "" {
+		return nil, fmt.Errorf("SENDGRID_API_KEY is not set")
+	}
+	// ローテーションに追従するため、キーは送信のたびに取得する
+	return &SendGrid{
+		apiKey: func() string { return secrets.Get("SENDGRID_API_KEY") },
+		client: &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+func (s *SendGrid) Name() string { return "sendgrid" }
+
+func (s *SendGrid) Send(ctx context.Context, msg Message) error {
+	to := make([]map[string]string, 0, len(msg.To))
+	for _, address := range msg.To {
+		to = append(to, map[string]string{"email": address})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"personalizations": []map[string]interface{}{{"to": to}},
+		"from":             map[string]string{"email": msg.From},
+		"subject":          msg.Subject,
+		"content":          []map[string]string{{"type": "text/html", "value": msg.HTML}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sendGridEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.apiKey())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sendgrid returned unexpected status: %d %s", resp.StatusCode, bytes.TrimSpace(detail))
+	}
+	return nil
+}
@@ -11,7 +11,9 @@ import (
 	"notification/config"
 	"notification/handlers"
 	"notification/logger"
+	"notification/mailer"
 	"notification/middleware"
+	"notification/mtls"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -24,6 +26,28 @@ func main() {
 		logger.Logger.Fatal("設定の初期化に失敗しました", zap.Error(err))
 	}
 
+	// メール通知のテンプレートの読み込みと検証（MAIL_TEMPLATE_DIR のファイルで組み込みのテンプレートを置き換え可能）
+	if err := handlers.LoadMailTemplates(os.Getenv("MAIL_TEMPLATE_DIR")); err != nil {
+		logger.Logger.Fatal("メールのテンプレートの読み込みに失敗しました", zap.Error(err))
+	}
+
+	// メールの送信プロバイダー（MAIL_PROVIDERS の順に送信し、失敗した場合は次のプロバイダーで送信）
+	mail, err := mailer.FromEnv()
+	switch {
+	case err == nil:
+		handlers.ConfigureMailer(mail)
+		logger.Logger.Info("メールの送信プロバイダーを設定しました", zap.String("providers", mail.Name()))
+	case os.Getenv("MAIL_PROVIDERS") != "":
+		logger.Logger.Fatal("メールの送信プロバイダーの設定に失敗しました", zap.Error(err))
+	default:
+		logger.Logger.Warn("メールの送信プロバイダーが設定されていないため、メールは送信しません", zap.Error(err))
+	}
+
+	// 内部サービス呼び出しにクライアント証明書を付与（相互TLSが有効な場合のみ）
+	if err := mtls.InstallTransport(); err != nil {
+		logger.Logger.Fatal("mTLSクライアントの設定に失敗しました", zap.Error(err))
+	}
+
 	// ルーターの設定
 	r := gin.New()
 	r.Use(gin.Logger())
@@ -32,14 +56,30 @@ func main() {
 	middlewareConfig := &middleware.Config{
 		EnableLogger: true,
 		EnableAuth:   cfg.Environment == "production",
+		// Teamsのカードの操作ボタンは利用者のブラウザー、音声通話の応答はTwilio、メールのイベントはSendGridから呼び出されるため、署名で検証する
+		PublicPaths: []string{"/teams/actions", "/voice/gather", "/sendgrid/events"},
 	}
 	middleware.SetupMiddleware(r, middlewareConfig)
 
 	// ハンドラーの設定
 	r.POST("/send-login-link", handlers.SendLoginLink)
 	r.POST("/notify", handlers.NotifyHandler)
+	r.GET("/notifications", handlers.NotificationHistoryHandler)
+	r.GET("/teams/actions", handlers.TeamsActionHandler)
+	r.POST("/teams/actions", handlers.TeamsActionHandler)
+	r.POST("/voice/gather", handlers.VoiceGatherHandler)
+	r.POST("/incidents/:id/acknowledge", handlers.AcknowledgeHandler)
+	r.POST("/sendgrid/events", handlers.SendGridEventsHandler)
 	r.GET("/health", handleHealthCheck)
 
+	// 確認されないインシデントのエスカレーション（ESCALATION_POLICIES）
+	stopEscalations := handlers.StartEscalationWorker()
+	defer stopEscalations()
+
+	// 一時的に失敗した通知の再送
+	stopRetries := handlers.StartDeliveryRetryWorker()
+	defer stopRetries()
+
 	// サーバーの設定と起動
 	srv := config.SetupServer(r)
 
@@ -55,7 +95,7 @@ func handleHealthCheck(c *gin.Context) {
 func handleGracefulShutdown(srv *http.Server, timeout time.Duration) {
 	// サーバーを別のゴルーチンで起動
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := mtls.ListenAndServe(srv); err != nil && err != http.ErrServerClosed {
 			logger.Logger.Fatal("サーバーの起動に失敗しました", zap.Error(err))
 		}
 	}()
@@ -75,5 +115,8 @@ func handleGracefulShutdown(srv *http.Server, timeout time.Duration) {
 		logger.Logger.Error("サーバーのシャットダウンでエラーが発生", zap.Error(err))
 	}
 
+	// 送信を待っているまとめ通知を送信
+	handlers.FlushDigests()
+
 	logger.Logger.Info("サーバーを正常に終了しました")
 }
@@ -10,7 +10,10 @@ import (
 	"strings"
 	"time"
 
+	"notification/identity"
 	"notification/logger"
+	"notification/mtls"
+	"notification/serviceauth"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -19,6 +22,8 @@ import (
 type Config struct {
 	EnableLogger bool
 	EnableAuth   bool
+	// PublicPaths はサービス間認証を行わないパス（URLの署名などで独自に検証するもの）
+	PublicPaths []string
 	// 他のミドルウェア設定を追加
 }
 
@@ -32,15 +37,31 @@ func SetupMiddleware(r *gin.Engine, cfg *Config) {
 	}
 
 	if cfg.EnableAuth {
-		r.Use(AuthMiddleware())
+		r.Use(AuthMiddleware(cfg.PublicPaths...))
 	}
 }
 
-// AuthMiddleware Bearerトークン検証用ミドルウェア
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware Bearerトークン検証用ミドルウェア（publicPaths は検証しない）
+func AuthMiddleware(publicPaths ...string) gin.HandlerFunc {
+	public := make(map[string]bool, len(publicPaths))
+	for _, path := range publicPaths {
+		public[path] = true
+	}
 	return func(c *gin.Context) {
-		serviceToken := os.Getenv("SERVICE_TOKEN")
-		if serviceToken == "" {
+		if public[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		// 検証済みのクライアント証明書（相互TLS）によるサービス間認証
+		if mtls.IsVerifiedClient(c.Request) {
+			if setAuthenticatedUser(c) {
+				c.Next()
+			}
+			return
+		}
+
+		if len(serviceauth.ServiceTokens()) == 0 {
 			logger.Logger.Warn("SERVICE_TOKEN is not set")
 			abortWithError(c, http.StatusUnauthorized, "unauthorized")
 			return
@@ -54,16 +75,44 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token != serviceToken {
+		if !serviceauth.IsServiceToken(token) {
 			logUnauthorizedRequest(c)
 			abortWithError(c, http.StatusUnauthorized, "invalid token")
 			return
 		}
 
+		if !setAuthenticatedUser(c) {
+			return
+		}
+
 		c.Next()
 	}
 }
 
+// setAuthenticatedUser は認証サービスが署名した利用者情報ヘッダーを検証し、利用者をコンテキストに保存します。
+// ヘッダーがない場合は何もせず、検証に失敗した場合は401を返して false を返します
+func setAuthenticatedUser(c *gin.Context) bool {
+	value := c.GetHeader(identity.Header)
+	if value == "" || !identity.Enabled() {
+		return true
+	}
+
+	id, err := identity.Verify(value)
+	if err != nil {
+		logger.Logger.Warn("利用者情報ヘッダーの検証に失敗しました",
+			zap.Error(err),
+			zap.String("client_ip", c.ClientIP()),
+		)
+		abortWithError(c, http.StatusUnauthorized, "invalid identity header")
+		return false
+	}
+
+	c.Set("user_id", id.UserID)
+	c.Set("user_email", id.Email)
+	c.Set("session", id.SessionID)
+	return true
+}
+
 // abortWithError エラーレスポンスを返す補助関数
 func abortWithError(c *gin.Context, status int, message string) {
 	c.AbortWithStatusJSON(status, gin.H{"error": message})
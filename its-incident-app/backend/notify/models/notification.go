@@ -1,11 +1,43 @@
 package models
 
+import "time"
+
+// 通知の種類（NotificationRequest.Event）。メールのテンプレートの選択に使用します
+const (
+	EventIncidentCreated = "incident_created"
+	EventSLABreach       = "sla_breach"
+)
+
 type NotificationRequest struct {
 	IncidentID uint `json:"incident_id"`
-	
+
 	Responder string `json:"responder"`
 	Content   string `json:"content"`
-	Title  string `json:"title"`
-	Chanel string `json:"chanel"`
-	Name   string `json:"name"`
+	Title     string `json:"title"`
+	Chanel    string `json:"chanel"`
+	Name      string `json:"name"`
+	// Priority はインシデントの優先度（高/中/低など）で、チャネルごとの通知優先度の導出に使用します
+	Priority string `json:"priority,omitempty"`
+	// AssigneeGroup・Tags はTeamsの送信先のルール（TEAMS_ROUTING_RULES）の条件に使用します
+	AssigneeGroup string   `json:"assignee_group,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	// Event は通知の種類（incident_created・sla_breach、省略時は incident_created）
+	Event string `json:"event,omitempty"`
+	// IncidentStatus・Deadline はSLA超過（sla_breach）の通知のインシデントの状態と対応期限です
+	// （status は dbpilot の対応記録の状態のため、別の項目名にしています）
+	IncidentStatus string     `json:"incident_status,omitempty"`
+	Deadline       *time.Time `json:"deadline,omitempty"`
+	// Channels は送信先（teams、slack、sms、voice、email）。省略した場合は設定されているすべての送信先に送信し、
+	// voice は確認されないまま VOICE_ESCALATION_DELAY がたった場合にだけかけます
+	Channels []string `json:"channels,omitempty"`
+	// TeamsChannels はTeamsの送信先のチャンネル（TEAMS_WEBHOOKS の名前）。省略した場合はルール（TEAMS_ROUTING_RULES）で決めます
+	TeamsChannels []string `json:"teams_channels,omitempty"`
+	// SlackChannels はSlackの送信先のチャンネル（SLACK_WEBHOOKS の名前）。省略した場合は重要度のルールで決めます
+	SlackChannels []string `json:"slack_channels,omitempty"`
+	// SMSRecipients はSMSの宛先（E.164形式）。省略した場合は重要度ごとの宛先（SMS_RECIPIENTS）に送信します
+	SMSRecipients []string `json:"sms_recipients,omitempty"`
+	// VoiceRecipients は電話をかける宛先（E.164形式）。省略した場合は重要度ごとの宛先（VOICE_RECIPIENTS）にかけます
+	VoiceRecipients []string `json:"voice_recipients,omitempty"`
+	// EmailRecipients はメールの宛先。省略した場合は重要度ごとの宛先（EMAIL_RECIPIENTS）に送信します
+	EmailRecipients []string `json:"email_recipients,omitempty"`
 }
@@ -0,0 +1,53 @@
+package models
+
+import "strings"
+
+// Severity は通知の重要度を表す型
+type Severity string
+
+const (
+	SeverityCritical Severity = "critical"
+	SeverityHigh     Severity = "high"
+	SeverityMedium   Severity = "medium"
+	SeverityLow      Severity = "low"
+)
+
+// DeliveryPriority はチャネルごとの通知優先度メタデータ
+type DeliveryPriority struct {
+	Severity        Severity `json:"severity"`
+	WebPushUrgency  string   `json:"web_push_urgency"` // RFC 8030 Urgency: very-low, low, normal, high
+	TeamsImportance string   `json:"teams_importance"` // normal, high, urgent
+	FCMPriority     string   `json:"fcm_priority"`     // normal, high
+	Sound           string   `json:"sound"`            // silent, default, alarm
+	SlackColor      string   `json:"slack_color"`      // Slackのメッセージの左端の色
+}
+
+// ParseSeverity はインシデントの優先度文字列（高/中/低、high/low、P1〜P4など）を重要度に変換します
+func ParseSeverity(priority string) Severity {
+	switch strings.ToLower(strings.TrimSpace(priority)) {
+	case "緊急", "critical", "urgent", "p1":
+		return SeverityCritical
+	case "高", "high", "p2":
+		return SeverityHigh
+	case "低", "low", "p4", "info":
+		return SeverityLow
+	default:
+		return SeverityMedium
+	}
+}
+
+// PriorityMetadata は重要度からチャネルごとの優先度メタデータを導出します
+func PriorityMetadata(priority string) DeliveryPriority {
+	severity := ParseSeverity(priority)
+
+	switch severity {
+	case SeverityCritical:
+		return DeliveryPriority{Severity: severity, WebPushUrgency: "high", TeamsImportance: "urgent", FCMPriority: "high", Sound: "alarm", SlackColor: "#d10c20"}
+	case SeverityHigh:
+		return DeliveryPriority{Severity: severity, WebPushUrgency: "high", TeamsImportance: "high", FCMPriority: "high", Sound: "default", SlackColor: "#f2760a"}
+	case SeverityLow:
+		return DeliveryPriority{Severity: severity, WebPushUrgency: "low", TeamsImportance: "normal", FCMPriority: "normal", Sound: "silent", SlackColor: "#8c8c8c"}
+	default:
+		return DeliveryPriority{Severity: severity, WebPushUrgency: "normal", TeamsImportance: "normal", FCMPriority: "normal", Sound: "default", SlackColor: "#e8b10c"}
+	}
+}
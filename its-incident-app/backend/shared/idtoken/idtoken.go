@@ -0,0 +1,325 @@
+// Package idtoken はCloud Run間のサービス認証に使用するGoogle署名のIDトークンを扱います（各サービスで共有）。
+// IDトークンが使用できない環境では、各サービスの serviceauth が SERVICE_TOKEN にフォールバックします
+package idtoken
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metadataIdentityURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/identity"
+	googleCertsURL      = "https://www.googleapis.com/oauth2/v3/certs"
+	tokenRefreshMargin  = 5 * time.Minute
+	certsCacheDuration  = time.Hour
+	clockSkew           = 30 * time.Second
+)
+
+// Claims はGoogle署名IDトークンのクレーム
+type Claims struct {
+	Issuer        string `json:"iss"`
+	Audience      string `json:"aud"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	ExpiresAt     int64  `json:"exp"`
+	IssuedAt      int64  `json:"iat"`
+}
+
+type cachedToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+var (
+	httpClient = &http.Client{Timeout: 5 * time.Second}
+
+	tokenMu    sync.Mutex
+	tokenCache = map[string]cachedToken{}
+
+	certsMu        sync.RWMutex
+	certs          map[string]*rsa.PublicKey
+	certsFetchedAt time.Time
+)
+
+// IDTokenEnabled は送信時にIDトークンを使用する設定かを返します（SERVICE_AUTH_MODE=idtoken）
+func IDTokenEnabled() bool {
+	return strings.EqualFold(os.Getenv("SERVICE_AUTH_MODE"), "idtoken")
+}
+
+// BearerToken は送信先URLに対するサービス認証用のトークンを返します。
+// IDトークンが無効、または取得に失敗した場合は fallback（SERVICE_TOKEN）を返します
+func BearerToken(targetURL, fallback string) string {
+	if IDTokenEnabled() {
+		if token, err := FetchIDToken(AudienceFor(targetURL)); err == nil {
+			return token
+		}
+	}
+	return fallback
+}
+
+// AudienceFor は送信先URLからオーディエンス（Cloud RunサービスのURL）を求めます
+func AudienceFor(targetURL string) string {
+	u, err := url.Parse(targetURL)
+	if err != nil || u.Host == "" {
+		return targetURL
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// FetchIDToken はメタデータサーバーから指定オーディエンスのIDトークンを取得します（期限前まではキャッシュ）
+func FetchIDToken(audience string) (string, error) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	if cached, ok := tokenCache[audience]; ok && time.Now().Add(tokenRefreshMargin).Before(cached.expiresAt) {
+		return cached.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet,
+		metadataIdentityURL+"?format=full&audience="+url.QueryEscape(audience), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call metadata server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	token := strings.TrimSpace(string(body))
+	claims, err := decodeClaims(token)
+	if err != nil {
+		return "", err
+	}
+
+	tokenCache[audience] = cachedToken{token: token, expiresAt: time.Unix(claims.ExpiresAt, 0)}
+	return token, nil
+}
+
+// CheckConfig は受信時のIDトークン検証の設定を確認します。
+// ID_TOKEN_AUDIENCE を設定して SERVICE_ACCOUNT_ALLOWLIST が空の場合はエラーを返します
+// （この場合は任意のGCPプロジェクトが発行したIDトークンが通るため、すべてのIDトークンを拒否します）
+func CheckConfig() error {
+	if os.Getenv("ID_TOKEN_AUDIENCE") != "" && len(allowedServiceAccounts()) == 0 {
+		return errors.New("SERVICE_ACCOUNT_ALLOWLIST must be set when ID_TOKEN_AUDIENCE is set")
+	}
+	return nil
+}
+
+// IsGoogleIDToken は署名を検証せずに発行者がGoogleのJWTかを判定します
+func IsGoogleIDToken(token string) bool {
+	claims, err := decodeClaims(token)
+	if err != nil {
+		return false
+	}
+	return isGoogleIssuer(claims.Issuer)
+}
+
+// VerifyIDToken はGoogle署名IDトークンの署名・発行者・オーディエンス・有効期限と、
+// 呼び出し元のサービスアカウントが SERVICE_ACCOUNT_ALLOWLIST に含まれることを検証します（未設定の場合はすべて拒否）
+func VerifyIDToken(token string) (*Claims, error) {
+	audience := os.Getenv("ID_TOKEN_AUDIENCE")
+	if audience == "" {
+		return nil, errors.New("ID_TOKEN_AUDIENCE is not configured")
+	}
+	allowlist := allowedServiceAccounts()
+	if len(allowlist) == 0 {
+		return nil, errors.New("SERVICE_ACCOUNT_ALLOWLIST is not configured")
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %v", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unexpected signing algorithm: %s", header.Alg)
+	}
+
+	key, err := googleCert(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("invalid token signature")
+	}
+
+	claims, err := decodeClaims(token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !isGoogleIssuer(claims.Issuer) {
+		return nil, fmt.Errorf("unexpected issuer: %s", claims.Issuer)
+	}
+	if claims.Audience != audience {
+		return nil, fmt.Errorf("unexpected audience: %s", claims.Audience)
+	}
+	if now.Add(-clockSkew).After(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, errors.New("token expired")
+	}
+	if claims.IssuedAt != 0 && now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, errors.New("token used before issued")
+	}
+
+	if !claims.EmailVerified || !containsFold(allowlist, claims.Email) {
+		return nil, fmt.Errorf("service account %q is not allowed", claims.Email)
+	}
+
+	return claims, nil
+}
+
+func googleCert(kid string) (*rsa.PublicKey, error) {
+	certsMu.RLock()
+	key, ok := certs[kid]
+	fresh := time.Since(certsFetchedAt) < certsCacheDuration
+	certsMu.RUnlock()
+	if ok && fresh {
+		return key, nil
+	}
+
+	if err := refreshGoogleCerts(); err != nil {
+		return nil, err
+	}
+
+	certsMu.RLock()
+	defer certsMu.RUnlock()
+	if key, ok := certs[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("signing key %q not found", kid)
+}
+
+func refreshGoogleCerts() error {
+	certsMu.Lock()
+	defer certsMu.Unlock()
+
+	// 不明なkidによる再取得の連発を防ぐ
+	if time.Since(certsFetchedAt) < 30*time.Second {
+		return nil
+	}
+
+	resp, err := httpClient.Get(googleCertsURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch google certs: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("google certs endpoint returned status %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return fmt.Errorf("failed to decode google certs: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	certs = keys
+	certsFetchedAt = time.Now()
+	return nil
+}
+
+func decodeClaims(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token payload: %v", err)
+	}
+	return &claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func isGoogleIssuer(issuer string) bool {
+	return issuer == "accounts.google.com" || issuer == "https://accounts.google.com"
+}
+
+// allowedServiceAccounts は SERVICE_ACCOUNT_ALLOWLIST（カンマ区切り）のサービスアカウントを返します
+func allowedServiceAccounts() []string {
+	var accounts []string
+	for _, account := range strings.Split(os.Getenv("SERVICE_ACCOUNT_ALLOWLIST"), ",") {
+		if account = strings.TrimSpace(account); account != "" {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
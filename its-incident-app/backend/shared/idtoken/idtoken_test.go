@@ -0,0 +1,130 @@
+package idtoken
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const (
+	testAudience = "https://dbpilot.example.run.app"
+	testKeyID    = "test-key"
+)
+
+// setupTestCerts はGoogleの公開鍵の代わりにテスト用の鍵を設定し、その秘密鍵を返します
+func setupTestCerts(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	certsMu.Lock()
+	certs = map[string]*rsa.PublicKey{testKeyID: &key.PublicKey}
+	certsFetchedAt = time.Now()
+	certsMu.Unlock()
+	t.Cleanup(func() {
+		certsMu.Lock()
+		certs = nil
+		certsFetchedAt = time.Time{}
+		certsMu.Unlock()
+	})
+	return key
+}
+
+// signToken はテスト用の鍵で署名したIDトークンを返します
+func signToken(t *testing.T, key *rsa.PrivateKey, claims Claims) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": testKeyID, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func testClaims(email string) Claims {
+	now := time.Now()
+	return Claims{
+		Issuer:        "https://accounts.google.com",
+		Audience:      testAudience,
+		Subject:       "1234567890",
+		Email:         email,
+		EmailVerified: true,
+		ExpiresAt:     now.Add(time.Hour).Unix(),
+		IssuedAt:      now.Unix(),
+	}
+}
+
+func TestVerifyIDTokenRequiresAllowlist(t *testing.T) {
+	key := setupTestCerts(t)
+	t.Setenv("ID_TOKEN_AUDIENCE", testAudience)
+	t.Setenv("SERVICE_ACCOUNT_ALLOWLIST", "")
+
+	// 許可するサービスアカウントが未設定の場合は、正しく署名されたIDトークンもすべて拒否する
+	token := signToken(t, key, testClaims("autopilot@project.iam.gserviceaccount.com"))
+	if _, err := VerifyIDToken(token); err == nil {
+		t.Error("ID token accepted without SERVICE_ACCOUNT_ALLOWLIST")
+	}
+	if err := CheckConfig(); err == nil {
+		t.Error("CheckConfig accepted ID_TOKEN_AUDIENCE without SERVICE_ACCOUNT_ALLOWLIST")
+	}
+
+	// IDトークンを受け付けない場合は設定不要
+	t.Setenv("ID_TOKEN_AUDIENCE", "")
+	if err := CheckConfig(); err != nil {
+		t.Errorf("CheckConfig without ID_TOKEN_AUDIENCE: %v", err)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	key := setupTestCerts(t)
+	t.Setenv("ID_TOKEN_AUDIENCE", testAudience)
+	t.Setenv("SERVICE_ACCOUNT_ALLOWLIST", "autopilot@project.iam.gserviceaccount.com, mailconverter@project.iam.gserviceaccount.com")
+
+	if err := CheckConfig(); err != nil {
+		t.Fatalf("CheckConfig: %v", err)
+	}
+
+	allowed := signToken(t, key, testClaims("Autopilot@project.iam.gserviceaccount.com"))
+	claims, err := VerifyIDToken(allowed)
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims.Email != "Autopilot@project.iam.gserviceaccount.com" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	unverified := testClaims("autopilot@project.iam.gserviceaccount.com")
+	unverified.EmailVerified = false
+	wrongAudience := testClaims("autopilot@project.iam.gserviceaccount.com")
+	wrongAudience.Audience = "https://other.example.run.app"
+	expired := testClaims("autopilot@project.iam.gserviceaccount.com")
+	expired.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+	wrongIssuer := testClaims("autopilot@project.iam.gserviceaccount.com")
+	wrongIssuer.Issuer = "https://evil.example.com"
+
+	tests := map[string]string{
+		"other project's service account": signToken(t, key, testClaims("attacker@other-project.iam.gserviceaccount.com")),
+		"unverified email":                signToken(t, key, unverified),
+		"wrong audience":                  signToken(t, key, wrongAudience),
+		"expired":                         signToken(t, key, expired),
+		"wrong issuer":                    signToken(t, key, wrongIssuer),
+		"signed by another key":           signToken(t, otherKey, testClaims("autopilot@project.iam.gserviceaccount.com")),
+	}
+	for name, token := range tests {
+		if _, err := VerifyIDToken(token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
@@ -1,25 +1,21 @@
 import { cookies } from 'next/headers'
 import { NextResponse } from 'next/server'
-import type { NextRequest } from 'next/server'
 
 // 環境変数の型チェック
 if (!process.env.AUTH_URL) {
     throw new Error('AUTH_URL is not defined in environment variables')
 }
 
-export async function POST(request: NextRequest) {
+export async function POST() {
     const cookieStore = await cookies()
     const sessionID = cookieStore.get('session_id')?.value
-    const body = await request.json()
 
-    console.log(body)
-
-    const response = await fetch(`${process.env.DBPILOT_URL}/logout`, {
+    // セッション失効・クッキー削除は認証サービスでまとめて行う
+    const response = await fetch(`${process.env.AUTH_URL}/logout`, {
         method: 'POST',
         headers: {
             Authorization: `Bearer ${sessionID}`
-        },
-        body: JSON.stringify(body)
+        }
     })
 
     if (!response.ok) {
@@ -30,6 +26,13 @@ export async function POST(request: NextRequest) {
 
     // 認証サーバーからのレスポンスを取得
     const data = await response.json()
+    const res = NextResponse.json(data)
+
+    // 認証サーバーからのSet-Cookieヘッダー（クッキー削除）を転送
+    const setCookieHeader = response.headers.get('set-cookie')
+    if (setCookieHeader) {
+        res.headers.set('set-cookie', setCookieHeader)
+    }
 
-    return NextResponse.json(data)
+    return res
 }
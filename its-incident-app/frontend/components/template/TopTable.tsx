@@ -140,7 +140,10 @@ const TopTable = ({ onIncidentClick }: TopTableProps) => {
                                             </TableCell>
                                             <TableCell>{format(fromUnixTime(incident.APIData.CreatedAt), 'yyyy-MM-dd HH:mm')}</TableCell>
                                             <TableCell>
-                                                <div className="font-medium">{incident.APIData.Subject}</div>
+                                                <div className="flex items-center gap-2 font-medium">
+                                                    {incident.APIData.Subject || '(件名なし)'}
+                                                    {incident.APIData.IsPartial && <Badge variant="yellow">partial</Badge>}
+                                                </div>
                                                 <div className="text-sm text-muted-foreground">{incident.APIData.Sender}</div>
                                             </TableCell>
                                             <TableCell>{incident.Assignee || '-'}</TableCell>
@@ -48,6 +48,8 @@ interface GAIResponse {
     UpdatedAt: string
     WorkflowLogs: string
     Sender: string
+    IsPartial: boolean
+    MissingFields: string // JSON配列形式の欠損項目
 }
 
 interface StatusCount {